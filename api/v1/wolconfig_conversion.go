@@ -0,0 +1,243 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+var _ conversion.Convertible = &WolConfig{}
+
+// ConvertTo converts this WolConfig to the hub version (v1beta1)
+func (src *WolConfig) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1beta1.WolConfig)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 WolConfig but got %T", dstRaw)
+	}
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Status = src.Status
+
+	spec := &src.Spec
+	dst.Spec = v1beta1.WolConfigSpec{
+		DiscoveryMode:          spec.DiscoveryMode,
+		NamespaceSelectors:     spec.NamespaceSelectors,
+		NamespaceLabelSelector: spec.NamespaceLabelSelector,
+		VMSelector:             spec.VMSelector,
+		ExplicitMappings:       spec.ExplicitMappings,
+		Exclusions:             spec.Exclusions,
+		CacheTTL:               int(spec.CacheTTL),
+		Precedence:             spec.Precedence,
+		ConflictPolicy:         spec.ConflictPolicy,
+		StartServiceAccount:    spec.StartServiceAccount,
+		SecureOn:               spec.SecureOn,
+		ShutdownOnLAN:          spec.ShutdownOnLAN,
+		IdleShutdown:           spec.IdleShutdown,
+		RawCapture:             spec.RawCapture,
+		RateLimit:              spec.RateLimit,
+		Relays:                 spec.Relays,
+		Audit:                  spec.Audit,
+		WakeMetadata:           spec.WakeMetadata,
+		DryRun:                 spec.DryRun,
+		PlacementHint:          spec.PlacementHint,
+		StatusMappings:         spec.StatusMappings,
+		WakeVerification:       spec.WakeVerification,
+		Agent: v1beta1.AgentSpec{
+			NodeSelector:           spec.Agent.NodeSelector,
+			Tolerations:            spec.Agent.Tolerations,
+			Resources:              spec.Agent.Resources,
+			Image:                  spec.Agent.Image,
+			ImagePullPolicy:        spec.Agent.ImagePullPolicy,
+			UpdateStrategy:         spec.Agent.UpdateStrategy,
+			PriorityClassName:      spec.Agent.PriorityClassName,
+			Shared:                 spec.Agent.Shared,
+			ServiceAccountName:     spec.Agent.ServiceAccountName,
+			Promiscuous:            spec.Agent.Promiscuous,
+			CaptureBackend:         spec.Agent.CaptureBackend,
+			DirectedWake:           spec.Agent.DirectedWake,
+			NetworkAwareScheduling: spec.Agent.NetworkAwareScheduling,
+			MetricsPort:            spec.Agent.MetricsPort,
+			InterfaceSelector:      spec.Agent.InterfaceSelector,
+			IPFamilies:             spec.Agent.IPFamilies,
+			ListenModes:            spec.Agent.ListenModes,
+			VLANs:                  spec.Agent.VLANs,
+			MinAgents:              spec.Agent.MinAgents,
+		},
+	}
+	for _, port := range spec.WOLPorts {
+		dst.Spec.WOLPorts = append(dst.Spec.WOLPorts, int(port))
+	}
+
+	if tuning := spec.Agent.Tuning; tuning != nil {
+		dst.Spec.Agent.Tuning = &v1beta1.AgentTuning{
+			UDPReadBufferBytes:    tuning.UDPReadBufferBytes,
+			RawReadBufferBytes:    tuning.RawReadBufferBytes,
+			ReceiveTimeoutSeconds: tuning.ReceiveTimeoutSeconds,
+		}
+	}
+	if dedupe := spec.Dedupe; dedupe != nil {
+		dst.Spec.Dedupe = &v1beta1.DedupeSpec{}
+		if dedupe.Agent != nil {
+			dst.Spec.Dedupe.AgentWindowSeconds = dedupe.Agent.WindowSeconds
+			// In v1beta1 l'intervallo di pulizia è un tuning dell'agent
+			if dedupe.Agent.CleanupIntervalSeconds != nil {
+				if dst.Spec.Agent.Tuning == nil {
+					dst.Spec.Agent.Tuning = &v1beta1.AgentTuning{}
+				}
+				dst.Spec.Agent.Tuning.DedupeCleanupIntervalSeconds = dedupe.Agent.CleanupIntervalSeconds
+			}
+		}
+		if dedupe.Aggregator != nil {
+			dst.Spec.Dedupe.AggregatorWindowSeconds = dedupe.Aggregator.WindowSeconds
+		}
+	}
+
+	if triggers := spec.WakeTriggers; triggers != nil {
+		dst.Spec.ARPWake = triggers.ARP
+		dst.Spec.WakeHooks = triggers.Hooks
+		if triggers.ICMPEcho || len(triggers.TCPPorts) > 0 || triggers.DNS || triggers.MQTT != nil {
+			dst.Spec.WakeTriggers = &v1beta1.WakeTriggersSpec{
+				ICMPEcho: triggers.ICMPEcho,
+				TCPPorts: triggers.TCPPorts,
+				DNS:      triggers.DNS,
+			}
+		}
+		if mqtt := triggers.MQTT; mqtt != nil {
+			dst.Spec.WakeTriggers.MQTT = &v1beta1.MQTTTriggerSpec{
+				BrokerURL:         mqtt.BrokerURL,
+				Topic:             mqtt.Topic,
+				ClientID:          mqtt.ClientID,
+				Username:          mqtt.Username,
+				PasswordSecretRef: mqtt.PasswordSecretRef,
+			}
+			if mqtt.TLS != nil {
+				dst.Spec.WakeTriggers.MQTT.CASecretRef = mqtt.TLS.CASecretRef
+			}
+		}
+	}
+	return nil
+}
+
+// ConvertFrom converts from the hub version (v1beta1) to this version
+func (dst *WolConfig) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1beta1.WolConfig)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 WolConfig but got %T", srcRaw)
+	}
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Status = src.Status
+
+	spec := &src.Spec
+	dst.Spec = WolConfigSpec{
+		DiscoveryMode:          spec.DiscoveryMode,
+		NamespaceSelectors:     spec.NamespaceSelectors,
+		NamespaceLabelSelector: spec.NamespaceLabelSelector,
+		VMSelector:             spec.VMSelector,
+		ExplicitMappings:       spec.ExplicitMappings,
+		Exclusions:             spec.Exclusions,
+		CacheTTL:               int32(spec.CacheTTL),
+		Precedence:             spec.Precedence,
+		ConflictPolicy:         spec.ConflictPolicy,
+		StartServiceAccount:    spec.StartServiceAccount,
+		SecureOn:               spec.SecureOn,
+		ShutdownOnLAN:          spec.ShutdownOnLAN,
+		IdleShutdown:           spec.IdleShutdown,
+		RawCapture:             spec.RawCapture,
+		RateLimit:              spec.RateLimit,
+		Relays:                 spec.Relays,
+		Audit:                  spec.Audit,
+		WakeMetadata:           spec.WakeMetadata,
+		DryRun:                 spec.DryRun,
+		PlacementHint:          spec.PlacementHint,
+		StatusMappings:         spec.StatusMappings,
+		WakeVerification:       spec.WakeVerification,
+		Agent: AgentSpec{
+			NodeSelector:           spec.Agent.NodeSelector,
+			Tolerations:            spec.Agent.Tolerations,
+			Resources:              spec.Agent.Resources,
+			Image:                  spec.Agent.Image,
+			ImagePullPolicy:        spec.Agent.ImagePullPolicy,
+			UpdateStrategy:         spec.Agent.UpdateStrategy,
+			PriorityClassName:      spec.Agent.PriorityClassName,
+			Shared:                 spec.Agent.Shared,
+			ServiceAccountName:     spec.Agent.ServiceAccountName,
+			Promiscuous:            spec.Agent.Promiscuous,
+			CaptureBackend:         spec.Agent.CaptureBackend,
+			DirectedWake:           spec.Agent.DirectedWake,
+			NetworkAwareScheduling: spec.Agent.NetworkAwareScheduling,
+			MetricsPort:            spec.Agent.MetricsPort,
+			InterfaceSelector:      spec.Agent.InterfaceSelector,
+			IPFamilies:             spec.Agent.IPFamilies,
+			ListenModes:            spec.Agent.ListenModes,
+			VLANs:                  spec.Agent.VLANs,
+			MinAgents:              spec.Agent.MinAgents,
+		},
+	}
+	for _, port := range spec.WOLPorts {
+		dst.Spec.WOLPorts = append(dst.Spec.WOLPorts, int32(port))
+	}
+
+	var cleanupInterval *int32
+	if tuning := spec.Agent.Tuning; tuning != nil {
+		cleanupInterval = tuning.DedupeCleanupIntervalSeconds
+		if tuning.UDPReadBufferBytes != nil || tuning.RawReadBufferBytes != nil || tuning.ReceiveTimeoutSeconds != nil {
+			dst.Spec.Agent.Tuning = &AgentTuning{
+				UDPReadBufferBytes:    tuning.UDPReadBufferBytes,
+				RawReadBufferBytes:    tuning.RawReadBufferBytes,
+				ReceiveTimeoutSeconds: tuning.ReceiveTimeoutSeconds,
+			}
+		}
+	}
+	if spec.Dedupe != nil || cleanupInterval != nil {
+		dst.Spec.Dedupe = &DedupeSpec{}
+		var agentWindow, aggregatorWindow *int32
+		if spec.Dedupe != nil {
+			agentWindow, aggregatorWindow = spec.Dedupe.AgentWindowSeconds, spec.Dedupe.AggregatorWindowSeconds
+		}
+		if agentWindow != nil || cleanupInterval != nil {
+			dst.Spec.Dedupe.Agent = &AgentDedupeSpec{WindowSeconds: agentWindow, CleanupIntervalSeconds: cleanupInterval}
+		}
+		if aggregatorWindow != nil {
+			dst.Spec.Dedupe.Aggregator = &AggregatorDedupeSpec{WindowSeconds: aggregatorWindow}
+		}
+	}
+
+	if spec.ARPWake != nil || spec.WakeTriggers != nil || len(spec.WakeHooks) > 0 {
+		dst.Spec.WakeTriggers = &WakeTriggersSpec{ARP: spec.ARPWake, Hooks: spec.WakeHooks}
+	}
+	if triggers := spec.WakeTriggers; triggers != nil {
+		dst.Spec.WakeTriggers.ICMPEcho = triggers.ICMPEcho
+		dst.Spec.WakeTriggers.TCPPorts = triggers.TCPPorts
+		dst.Spec.WakeTriggers.DNS = triggers.DNS
+		if mqtt := triggers.MQTT; mqtt != nil {
+			dst.Spec.WakeTriggers.MQTT = &MQTTTriggerSpec{
+				BrokerURL:         mqtt.BrokerURL,
+				Topic:             mqtt.Topic,
+				ClientID:          mqtt.ClientID,
+				Username:          mqtt.Username,
+				PasswordSecretRef: mqtt.PasswordSecretRef,
+			}
+			if mqtt.CASecretRef != nil {
+				dst.Spec.WakeTriggers.MQTT.TLS = &TLSSpec{CASecretRef: mqtt.CASecretRef}
+			}
+		}
+	}
+	return nil
+}
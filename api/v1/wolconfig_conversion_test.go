@@ -0,0 +1,125 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
+
+func newHubConfig() *v1beta1.WolConfig {
+	secret := &v1beta1.SecretKeyReference{Name: "mqtt", Namespace: "wol", Key: "password"}
+	return &v1beta1.WolConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "lab", Labels: map[string]string{"env": "lab"}},
+		Spec: v1beta1.WolConfigSpec{
+			DiscoveryMode:      v1beta1.DiscoveryModeLabelSelector,
+			NamespaceSelectors: []string{"tenant"},
+			VMSelector:         &metav1.LabelSelector{MatchLabels: map[string]string{"wol": "true"}},
+			WOLPorts:           []int{7, 9},
+			CacheTTL:           120,
+			Precedence:         5,
+			ConflictPolicy:     v1beta1.ConflictPolicyReject,
+			ARPWake:            &v1beta1.ARPWakeSpec{Enabled: true, Threshold: 2, WindowSeconds: 5},
+			WakeTriggers: &v1beta1.WakeTriggersSpec{
+				ICMPEcho: true,
+				TCPPorts: []int32{22},
+				MQTT: &v1beta1.MQTTTriggerSpec{BrokerURL: "mqtts://broker:8883", Topic: "wake",
+					PasswordSecretRef: secret, CASecretRef: &v1beta1.SecretKeyReference{Name: "ca", Namespace: "wol"}},
+			},
+			WakeHooks: []v1beta1.WakeHookSpec{{Name: "ci", KeySecretRef: *secret}},
+			Audit: &v1beta1.AuditSpec{File: &v1beta1.AuditFileSpec{Path: "/var/log/wol/audit.log", MaxSizeMB: 10},
+				Webhook: &v1beta1.AuditWebhookSpec{URL: "https://siem/ingest", BearerTokenSecretRef: secret}},
+			WakeMetadata:     &v1beta1.WakeMetadataSpec{Enabled: true, Guest: true},
+			DryRun:           true,
+			PlacementHint:    &v1beta1.PlacementHintSpec{Enabled: true, TopologyKey: "topology.kubernetes.io/zone", Weight: 80},
+			StatusMappings:   &v1beta1.StatusMappingsSpec{Enabled: true, MaxEntries: 10},
+			WakeVerification: &v1beta1.WakeVerificationSpec{Enabled: true, TimeoutSeconds: 120},
+			Dedupe:           &v1beta1.DedupeSpec{AgentWindowSeconds: int32Ptr(3), AggregatorWindowSeconds: int32Ptr(20)},
+			Agent: v1beta1.AgentSpec{
+				NodeSelector:   map[string]string{"wol": "true"},
+				Shared:         true,
+				CaptureBackend: v1beta1.CaptureBackendEBPF,
+				VLANs:          []int32{0, 100},
+				MinAgents:      int32Ptr(2),
+				Tuning: &v1beta1.AgentTuning{UDPReadBufferBytes: int32Ptr(1 << 20),
+					DedupeCleanupIntervalSeconds: int32Ptr(60)},
+			},
+		},
+		Status: v1beta1.WolConfigStatus{ManagedVMs: 3, MappingCount: 1, Mappings: []v1beta1.MACMappingStatus{
+			{MACAddress: "52:54:00:00:00:01", Namespace: "tenant", Name: "vm1", MappingType: "discovered"}}},
+	}
+}
+
+func TestWolConfigConversion_RoundTrip(t *testing.T) {
+	hub := newHubConfig()
+	v1 := &WolConfig{}
+	if err := v1.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom failed: %v", err)
+	}
+
+	triggers := v1.Spec.WakeTriggers
+	if triggers == nil || triggers.ARP == nil || len(triggers.Hooks) != 1 || !triggers.ICMPEcho {
+		t.Fatalf("Expected ARPWake, WakeTriggers and WakeHooks in WakeTriggers, got %+v", triggers)
+	}
+	if triggers.MQTT.TLS == nil || triggers.MQTT.TLS.CASecretRef.Name != "ca" {
+		t.Errorf("Expected the MQTT CA in the TLS section, got %+v", triggers.MQTT.TLS)
+	}
+	if dedupe := v1.Spec.Dedupe; dedupe.Agent == nil || *dedupe.Agent.CleanupIntervalSeconds != 60 ||
+		*dedupe.Agent.WindowSeconds != 3 || *dedupe.Aggregator.WindowSeconds != 20 {
+		t.Errorf("Expected the dedupe settings in Dedupe, got %+v", dedupe)
+	}
+	if len(v1.Spec.WOLPorts) != 2 || v1.Spec.WOLPorts[1] != 9 {
+		t.Errorf("Expected WOL ports [7 9], got %v", v1.Spec.WOLPorts)
+	}
+
+	back := &v1beta1.WolConfig{}
+	if err := v1.ConvertTo(back); err != nil {
+		t.Fatalf("ConvertTo failed: %v", err)
+	}
+	if !equality.Semantic.DeepEqual(hub, back) {
+		t.Errorf("Round trip changed the WolConfig:\nwant %+v\ngot  %+v", hub.Spec, back.Spec)
+	}
+}
+
+func TestWolConfigConversion_Minimal(t *testing.T) {
+	hub := &v1beta1.WolConfig{ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec: v1beta1.WolConfigSpec{DiscoveryMode: v1beta1.DiscoveryModeAll}}
+	v1 := &WolConfig{}
+	if err := v1.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom failed: %v", err)
+	}
+	// Nessuna sezione vuota inventata dalla conversione
+	if v1.Spec.WakeTriggers != nil || v1.Spec.Dedupe != nil || v1.Spec.Agent.Tuning != nil {
+		t.Errorf("Expected no optional sections, got %+v", v1.Spec)
+	}
+
+	back := &v1beta1.WolConfig{}
+	if err := v1.ConvertTo(back); err != nil {
+		t.Fatalf("ConvertTo failed: %v", err)
+	}
+	if !equality.Semantic.DeepEqual(hub, back) {
+		t.Errorf("Round trip changed the WolConfig:\nwant %+v\ngot  %+v", hub.Spec, back.Spec)
+	}
+}
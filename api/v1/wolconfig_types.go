@@ -0,0 +1,496 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// The v1 WolConfig keeps the sections of v1beta1 that did not change and
+// reuses their types; it differs in WOLPorts and CacheTTL (int32), in the wake
+// triggers (ARPWake, WakeTriggers and WakeHooks grouped in WakeTriggers), in
+// the MQTT TLS settings and in the dedupe settings (all in Dedupe).
+
+// WolConfigSpec defines the desired state of WolConfig
+type WolConfigSpec struct {
+	// DiscoveryMode determines how VMs are discovered
+	// +kubebuilder:default=All
+	// +optional
+	DiscoveryMode v1beta1.DiscoveryMode `json:"discoveryMode,omitempty"`
+
+	// NamespaceSelectors lists namespaces to watch for VMs
+	// If empty, and without NamespaceLabelSelector, all namespaces are monitored
+	// +optional
+	NamespaceSelectors []string `json:"namespaceSelectors,omitempty"`
+
+	// NamespaceLabelSelector selects the namespaces to watch for VMs by their
+	// labels, in addition to NamespaceSelectors. Namespaces created later with
+	// matching labels are watched without editing the WolConfig
+	// +optional
+	NamespaceLabelSelector *metav1.LabelSelector `json:"namespaceLabelSelector,omitempty"`
+
+	// VMSelector is a label selector for VMs (used with DiscoveryMode=LabelSelector)
+	// +optional
+	VMSelector *metav1.LabelSelector `json:"vmSelector,omitempty"`
+
+	// ExplicitMappings provides explicit MAC to VM mappings (used with DiscoveryMode=Explicit).
+	// Forward entries apply with every discovery mode
+	// +optional
+	ExplicitMappings []v1beta1.MACVMMapping `json:"explicitMappings,omitempty"`
+
+	// Exclusions leaves namespaces, VMs and MAC ranges out of the discovery,
+	// applied after NamespaceSelectors and VMSelector. Explicit mappings are not affected
+	// +optional
+	Exclusions *v1beta1.DiscoveryExclusions `json:"exclusions,omitempty"`
+
+	// WOLPorts are the UDP ports to listen for Wake-on-LAN packets
+	// +kubebuilder:default={9}
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=10
+	// +kubebuilder:validation:items:Minimum=1
+	// +kubebuilder:validation:items:Maximum=65535
+	// +optional
+	WOLPorts []int32 `json:"wolPorts,omitempty"`
+
+	// CacheTTL is the cache time-to-live in seconds for VM mappings
+	// +kubebuilder:default=300
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	CacheTTL int32 `json:"cacheTTL,omitempty"`
+
+	// Precedence orders WolConfigs when they map the same MAC to different VMs:
+	// the config with the highest precedence wins
+	// +kubebuilder:default=0
+	// +optional
+	Precedence int32 `json:"precedence,omitempty"`
+
+	// ConflictPolicy resolves MACs claimed by configs with the same precedence.
+	// Reject wins over StartAll, then PreferOldest, then PreferExplicit
+	// +kubebuilder:default=PreferExplicit
+	// +optional
+	ConflictPolicy v1beta1.ConflictPolicy `json:"conflictPolicy,omitempty"`
+
+	// StartServiceAccount is a ServiceAccount the manager impersonates when starting
+	// VMs matched by this config, so the config can only start VMs that account is
+	// allowed to start. If not set, the operator's own identity is used.
+	// +optional
+	StartServiceAccount *v1beta1.ServiceAccountReference `json:"startServiceAccount,omitempty"`
+
+	// SecureOn configures the enforcement of SecureOn passwords for VMs matched by this config
+	// +optional
+	SecureOn *v1beta1.SecureOnSpec `json:"secureOn,omitempty"`
+
+	// WakeTriggers wakes stopped VMs on events other than magic packets:
+	// ARP requests and other traffic sent to them, MQTT messages, signed HTTP calls
+	// +optional
+	WakeTriggers *WakeTriggersSpec `json:"wakeTriggers,omitempty"`
+
+	// ShutdownOnLAN lets sleep packets stop or pause the VMs of this config
+	// +optional
+	ShutdownOnLAN *v1beta1.ShutdownOnLANSpec `json:"shutdownOnLAN,omitempty"`
+
+	// IdleShutdown stops or pauses the running VMs of this config that stay
+	// idle, so they are woken again by WOL when needed
+	// +optional
+	IdleShutdown *v1beta1.IdleShutdownSpec `json:"idleShutdown,omitempty"`
+
+	// RawCapture widens what the agents' raw listeners recognize as a wake
+	// packet beyond broadcast EtherType 0x0842 frames. Requires the Raw listen mode
+	// +optional
+	RawCapture *v1beta1.RawCaptureSpec `json:"rawCapture,omitempty"`
+
+	// Dedupe tunes how long repeated packets for the VMs of this config are
+	// dropped, by the agents and by the manager
+	// +optional
+	Dedupe *DedupeSpec `json:"dedupe,omitempty"`
+
+	// RateLimit bounds the start and stop requests that the packets for the
+	// VMs of this config send to KubeVirt
+	// +optional
+	RateLimit *v1beta1.EventRateLimitSpec `json:"rateLimit,omitempty"`
+
+	// Agent configuration for the WOL DaemonSet
+	// +optional
+	Agent AgentSpec `json:"agent,omitempty"`
+
+	// Relays are the external event sources (e.g. a relay at a branch office)
+	// allowed to report WOL events for this config through the relay endpoint
+	// of the manager, each authenticated by its own token
+	// +listType=map
+	// +listMapKey=name
+	// +kubebuilder:validation:MaxItems=50
+	// +optional
+	Relays []v1beta1.RelaySpec `json:"relays,omitempty"`
+
+	// Audit writes a JSON record of every wake and sleep decision on the VMs
+	// of this config (accepted or rejected, with source, reason and latency)
+	// to a file or an HTTP endpoint, e.g. for a SIEM
+	// +optional
+	Audit *v1beta1.AuditSpec `json:"audit,omitempty"`
+
+	// WakeMetadata annotates the VMs of this config with their last wake
+	// (time, source, node) and a wake counter, so that tools (and, optionally,
+	// the guest) can see why a VM was started
+	// +optional
+	WakeMetadata *v1beta1.WakeMetadataSpec `json:"wakeMetadata,omitempty"`
+
+	// DryRun makes the manager match and record the WOL packets and wake
+	// requests of this config (dedupe, metrics, Events, audit) without acting
+	// on the VMs: nothing is started, stopped or forwarded. Useful to roll out
+	// a config and check what it would wake
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// PlacementHint makes the VMs woken by a magic packet prefer the node that
+	// received it (or the nodes of its rack or zone), e.g. for L2-local storage
+	// or GPU locality
+	// +optional
+	PlacementHint *v1beta1.PlacementHintSpec `json:"placementHint,omitempty"`
+
+	// StatusMappings lists the resolved MAC to VM mappings of this config in
+	// its status, so that kubectl shows which MACs are wakeable
+	// +optional
+	StatusMappings *v1beta1.StatusMappingsSpec `json:"statusMappings,omitempty"`
+
+	// WakeVerification checks that the VMs started for a wake reach Running,
+	// and reports those that do not (Event, metric, audit record)
+	// +optional
+	WakeVerification *v1beta1.WakeVerificationSpec `json:"wakeVerification,omitempty"`
+}
+
+// WakeTriggersSpec configures the wakes triggered by events other than magic
+// packets. The ARP, ICMPEcho, TCPPorts and DNS triggers are captured by the
+// agents on their raw listeners for the IPv4 addresses of the stopped VMs: the
+// ones last reported while they were running, plus the comma-separated list in
+// their wol.pillon.org/ip-addresses annotation. VMs with SecureOn Require are
+// never woken by a trigger.
+type WakeTriggersSpec struct {
+	// ARP wakes a VM on ARP who-has requests for its IPs
+	// +optional
+	ARP *v1beta1.ARPWakeSpec `json:"arp,omitempty"`
+
+	// ICMPEcho wakes a VM on a ping (ICMP echo request) to one of its IPs
+	// +optional
+	ICMPEcho bool `json:"icmpEcho,omitempty"`
+
+	// TCPPorts wakes a VM on a connection attempt (TCP SYN) to one of these ports of its IPs
+	// +kubebuilder:validation:MaxItems=16
+	// +kubebuilder:validation:items:Minimum=1
+	// +kubebuilder:validation:items:Maximum=65535
+	// +optional
+	TCPPorts []int32 `json:"tcpPorts,omitempty"`
+
+	// DNS wakes a VM on a DNS or mDNS address query (A, AAAA, ANY) for its
+	// name: <vm>, <vm>.local, or <vm>.<namespace> followed by any domain.
+	// Only the queries crossing the interfaces of the agents are seen.
+	// +optional
+	DNS bool `json:"dns,omitempty"`
+
+	// MQTT subscribes the manager to a topic of an MQTT broker whose messages
+	// wake the VMs of this config, e.g. published by Home Assistant or IoT devices
+	// +optional
+	MQTT *MQTTTriggerSpec `json:"mqtt,omitempty"`
+
+	// Hooks are the signed HTTP callers (home automation, CI jobs, cloud
+	// schedulers) allowed to wake the VMs of this config through the wake hook
+	// endpoint of the manager REST API, each with its own HMAC key
+	// +listType=map
+	// +listMapKey=name
+	// +kubebuilder:validation:MaxItems=50
+	// +optional
+	Hooks []v1beta1.WakeHookSpec `json:"hooks,omitempty"`
+}
+
+// MQTTTriggerSpec configures the MQTT subscription of a WolConfig. A message
+// is {"mac":"52:54:00:12:34:56"}, {"vm":"my-vm","namespace":"team-a"} or a
+// bare MAC; retained messages are ignored, so a stale one does not wake the
+// VM at every reconnection.
+type MQTTTriggerSpec struct {
+	// BrokerURL is the address of the broker: tcp:// or mqtt:// (port 1883 by
+	// default), ssl://, tls:// or mqtts:// (port 8883 by default)
+	// +kubebuilder:validation:Pattern=`^(tcp|mqtt|ssl|tls|mqtts)://[^/?#]+$`
+	BrokerURL string `json:"brokerURL"`
+
+	// Topic is the topic filter subscribed to, wildcards allowed
+	// +kubebuilder:default="kubevirt-wol/wake"
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=256
+	// +optional
+	Topic string `json:"topic,omitempty"`
+
+	// ClientID identifies the manager to the broker (default kubevirt-wol-<wolconfig>)
+	// +kubebuilder:validation:MaxLength=64
+	// +optional
+	ClientID string `json:"clientID,omitempty"`
+
+	// Username authenticates the manager to the broker
+	// +optional
+	Username string `json:"username,omitempty"`
+
+	// PasswordSecretRef references the Secret key holding the password of Username
+	// +optional
+	PasswordSecretRef *v1beta1.SecretKeyReference `json:"passwordSecretRef,omitempty"`
+
+	// TLS configures the verification of the certificate of a TLS broker
+	// +optional
+	TLS *TLSSpec `json:"tls,omitempty"`
+}
+
+// TLSSpec configures how the manager verifies the certificate of a server
+type TLSSpec struct {
+	// CASecretRef references the Secret key holding the PEM CA certificates
+	// that sign the certificate of the server, instead of the system ones
+	// +optional
+	CASecretRef *v1beta1.SecretKeyReference `json:"caSecretRef,omitempty"`
+}
+
+// DedupeSpec configures the dedupe of the agents and of the manager
+type DedupeSpec struct {
+	// Agent configures the dedupe cache of the agents
+	// +optional
+	Agent *AgentDedupeSpec `json:"agent,omitempty"`
+
+	// Aggregator configures the dedupe of the manager
+	// +optional
+	Aggregator *AggregatorDedupeSpec `json:"aggregator,omitempty"`
+}
+
+// AgentDedupeSpec configures the dedupe cache of the agents
+type AgentDedupeSpec struct {
+	// WindowSeconds is how long an agent drops a packet it already
+	// reported (same MAC, port and SecureOn password). Defaults to 2
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=300
+	// +optional
+	WindowSeconds *int32 `json:"windowSeconds,omitempty"`
+
+	// CleanupIntervalSeconds is how often expired entries are removed
+	// from the cache. Defaults to 30
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=3600
+	// +optional
+	CleanupIntervalSeconds *int32 `json:"cleanupIntervalSeconds,omitempty"`
+}
+
+// AggregatorDedupeSpec configures the dedupe of the manager
+type AggregatorDedupeSpec struct {
+	// WindowSeconds is how long the manager answers DUPLICATE to the same
+	// packet reported by other agents or resent, for the MACs matched by this
+	// config. Unmatched MACs keep the default. Defaults to 10
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=300
+	// +optional
+	WindowSeconds *int32 `json:"windowSeconds,omitempty"`
+}
+
+// AgentSpec defines the DaemonSet configuration for WOL agents
+type AgentSpec struct {
+	// NodeSelector is a selector which must be true for the agent pod to fit on a node
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations allow the agent pods to schedule onto nodes with matching taints
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// Resources describes the compute resource requirements for agent pods
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Image is the container image for the agent (optional, defaults to controller's agent image)
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// ImagePullPolicy for agent container image
+	// +kubebuilder:default=IfNotPresent
+	// +optional
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+	// UpdateStrategy for the DaemonSet
+	// +optional
+	UpdateStrategy *appsv1.DaemonSetUpdateStrategy `json:"updateStrategy,omitempty"`
+
+	// PriorityClassName for agent pods
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// Shared runs the agents of this WolConfig in the wol-shared-agent
+	// DaemonSet, together with those of the other WolConfigs with shared
+	// agents, instead of a DaemonSet of its own. The shared agents listen on
+	// the union of the WOLPorts and RawCapture settings of these WolConfigs;
+	// the other agent settings come from the first of them by name
+	// +optional
+	Shared bool `json:"shared,omitempty"`
+
+	// ServiceAccountName is the ServiceAccount of the agent pods, in the
+	// namespace of the manager. Defaults to the ServiceAccount labelled
+	// app.kubernetes.io/name=wol-agent, app.kubernetes.io/component=agent
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// Promiscuous enables promiscuous capture on the agents' raw listeners, needed
+	// for WoL frames unicast to the VM MAC on NICs that are not bridge ports.
+	// When false only the frames the NIC accepts anyway reach the BPF filter,
+	// which in practice means broadcast EtherType 0x0842 frames.
+	// +kubebuilder:default=true
+	// +optional
+	Promiscuous *bool `json:"promiscuous,omitempty"`
+
+	// CaptureBackend selects how the raw listeners capture the frames. EBPF
+	// attaches an XDP program to each interface that copies only EtherType
+	// 0x0842 and the UDP WOLPorts frames (plus those of the enabled ARP, NDP and
+	// trigger features) to the agent, so Promiscuous is not used; it needs the
+	// BPF, PERFMON and NET_ADMIN capabilities and falls back to AFPacket on the
+	// interfaces where the program cannot be attached. Defaults to AFPacket
+	// +kubebuilder:default=AFPacket
+	// +optional
+	CaptureBackend v1beta1.AgentCaptureBackend `json:"captureBackend,omitempty"`
+
+	// DirectedWake makes the agents' raw listeners also capture magic packets
+	// sent as unicast IPv4 UDP to the WOLPorts of an address other than the
+	// node's, e.g. by etherwake or wakeonlan to the last-known IP of a VM.
+	// Requires the Raw listen mode; unicast to a VM MAC reaches a NIC that is
+	// not a bridge port only with Promiscuous
+	// +optional
+	DirectedWake bool `json:"directedWake,omitempty"`
+
+	// NetworkAwareScheduling restricts the agents to the nodes that provide the
+	// bridge of the NetworkAttachmentDefinitions used by the managed VMs, by
+	// requesting the bridge-marker / ovs-cni resource those NADs declare.
+	// Only applies when all the NADs use the same bridge resource; otherwise
+	// the agents are not restricted
+	// +optional
+	NetworkAwareScheduling bool `json:"networkAwareScheduling,omitempty"`
+
+	// MetricsPort is the host port of the agents' Prometheus /metrics endpoint.
+	// Defaults to the health check port, 8080; 0 disables the metrics. The
+	// agents use the host network, so the port must be free on the nodes
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	MetricsPort *int32 `json:"metricsPort,omitempty"`
+
+	// Tuning overrides the agent socket defaults, for high-throughput or very
+	// low-memory nodes
+	// +optional
+	Tuning *AgentTuning `json:"tuning,omitempty"`
+
+	// InterfaceSelector selects the interfaces the agents open raw sockets on,
+	// e.g. the ones attached to the WOL VLAN, instead of those picked by name. The node annotations wol.pillon.org/interface-include and
+	// wol.pillon.org/interface-exclude, when set, replace it on their node.
+	// Interfaces of the VMs' NetworkAttachmentDefinitions are listened on anyway
+	// +optional
+	InterfaceSelector *v1beta1.InterfaceSelector `json:"interfaceSelector,omitempty"`
+
+	// IPFamilies are the IP families of the agents' UDP listener: IPv4 binds
+	// 0.0.0.0 (broadcast), IPv6 binds :: and joins the ff02::1 all-nodes group
+	// on every multicast interface. Both for dual-stack networks.
+	// Defaults to IPv4
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=2
+	// +kubebuilder:validation:items:Enum=IPv4;IPv6
+	// +listType=set
+	// +optional
+	IPFamilies []corev1.IPFamily `json:"ipFamilies,omitempty"`
+
+	// ListenModes selects the agents' listeners. Without Raw (or Both) the
+	// agents run without the NET_RAW capability, and ARP wake is disabled.
+	// Defaults to Both
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=2
+	// +listType=set
+	// +optional
+	ListenModes []v1beta1.AgentListenMode `json:"listenModes,omitempty"`
+
+	// VLANs restricts the agents' raw listeners to the frames of these VLAN
+	// IDs, matched against the outer and the inner tag of QinQ frames; 0
+	// accepts the untagged frames. The UDP listeners are not restricted.
+	// Defaults to every VLAN
+	// +kubebuilder:validation:MaxItems=64
+	// +kubebuilder:validation:items:Minimum=0
+	// +kubebuilder:validation:items:Maximum=4094
+	// +listType=set
+	// +optional
+	VLANs []int32 `json:"vlans,omitempty"`
+
+	// MinAgents is the number of agents that must be connected (sending
+	// heartbeats to the manager) for the WolConfig to be Ready. 0 does not
+	// wait for the agents. Defaults to 1
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinAgents *int32 `json:"minAgents,omitempty"`
+}
+
+// AgentTuning tunes the agent sockets. Unset fields keep the agent defaults.
+type AgentTuning struct {
+	// UDPReadBufferBytes is the receive buffer (SO_RCVBUF) of the UDP listener.
+	// Defaults to 65536; the kernel caps it at net.core.rmem_max
+	// +kubebuilder:validation:Minimum=4096
+	// +kubebuilder:validation:Maximum=67108864
+	// +optional
+	UDPReadBufferBytes *int32 `json:"udpReadBufferBytes,omitempty"`
+
+	// RawReadBufferBytes is the receive buffer (SO_RCVBUF) of the raw Ethernet
+	// listeners. Defaults to the kernel default (net.core.rmem_default)
+	// +kubebuilder:validation:Minimum=4096
+	// +kubebuilder:validation:Maximum=67108864
+	// +optional
+	RawReadBufferBytes *int32 `json:"rawReadBufferBytes,omitempty"`
+
+	// ReceiveTimeoutSeconds is how long a listener read waits for a packet
+	// (SO_RCVTIMEO on the raw sockets, read deadline on the UDP socket) before
+	// checking for shutdown and refreshing its watchdog heartbeat. Defaults to 1.
+	// At most 10, since the watchdog considers a loop silent for 15s stuck
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10
+	// +optional
+	ReceiveTimeoutSeconds *int32 `json:"receiveTimeoutSeconds,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+// +kubebuilder:resource:scope=Cluster,shortName=wolcfg
+// +kubebuilder:printcolumn:name="Discovery Mode",type=string,JSONPath=`.spec.discoveryMode`
+// +kubebuilder:printcolumn:name="WOL Ports",type=string,JSONPath=`.spec.wolPorts`
+// +kubebuilder:printcolumn:name="Managed VMs",type=integer,JSONPath=`.status.managedVMs`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// WolConfig is the Schema for the Wake-on-LAN configurations API
+type WolConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WolConfigSpec           `json:"spec,omitempty"`
+	Status v1beta1.WolConfigStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WolConfigList contains a list of WolConfig
+type WolConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WolConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WolConfig{}, &WolConfigList{})
+}
@@ -0,0 +1,461 @@
+//go:build !ignore_autogenerated
+
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	"github.com/gpillon/kubevirt-wol/api/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AgentDedupeSpec) DeepCopyInto(out *AgentDedupeSpec) {
+	*out = *in
+	if in.WindowSeconds != nil {
+		in, out := &in.WindowSeconds, &out.WindowSeconds
+		*out = new(int32)
+		**out = **in
+	}
+	if in.CleanupIntervalSeconds != nil {
+		in, out := &in.CleanupIntervalSeconds, &out.CleanupIntervalSeconds
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentDedupeSpec.
+func (in *AgentDedupeSpec) DeepCopy() *AgentDedupeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AgentDedupeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AgentSpec) DeepCopyInto(out *AgentSpec) {
+	*out = *in
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+	if in.UpdateStrategy != nil {
+		in, out := &in.UpdateStrategy, &out.UpdateStrategy
+		*out = new(appsv1.DaemonSetUpdateStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Promiscuous != nil {
+		in, out := &in.Promiscuous, &out.Promiscuous
+		*out = new(bool)
+		**out = **in
+	}
+	if in.MetricsPort != nil {
+		in, out := &in.MetricsPort, &out.MetricsPort
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Tuning != nil {
+		in, out := &in.Tuning, &out.Tuning
+		*out = new(AgentTuning)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.InterfaceSelector != nil {
+		in, out := &in.InterfaceSelector, &out.InterfaceSelector
+		*out = new(v1beta1.InterfaceSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IPFamilies != nil {
+		in, out := &in.IPFamilies, &out.IPFamilies
+		*out = make([]corev1.IPFamily, len(*in))
+		copy(*out, *in)
+	}
+	if in.ListenModes != nil {
+		in, out := &in.ListenModes, &out.ListenModes
+		*out = make([]v1beta1.AgentListenMode, len(*in))
+		copy(*out, *in)
+	}
+	if in.VLANs != nil {
+		in, out := &in.VLANs, &out.VLANs
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.MinAgents != nil {
+		in, out := &in.MinAgents, &out.MinAgents
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentSpec.
+func (in *AgentSpec) DeepCopy() *AgentSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AgentSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AgentTuning) DeepCopyInto(out *AgentTuning) {
+	*out = *in
+	if in.UDPReadBufferBytes != nil {
+		in, out := &in.UDPReadBufferBytes, &out.UDPReadBufferBytes
+		*out = new(int32)
+		**out = **in
+	}
+	if in.RawReadBufferBytes != nil {
+		in, out := &in.RawReadBufferBytes, &out.RawReadBufferBytes
+		*out = new(int32)
+		**out = **in
+	}
+	if in.ReceiveTimeoutSeconds != nil {
+		in, out := &in.ReceiveTimeoutSeconds, &out.ReceiveTimeoutSeconds
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentTuning.
+func (in *AgentTuning) DeepCopy() *AgentTuning {
+	if in == nil {
+		return nil
+	}
+	out := new(AgentTuning)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AggregatorDedupeSpec) DeepCopyInto(out *AggregatorDedupeSpec) {
+	*out = *in
+	if in.WindowSeconds != nil {
+		in, out := &in.WindowSeconds, &out.WindowSeconds
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AggregatorDedupeSpec.
+func (in *AggregatorDedupeSpec) DeepCopy() *AggregatorDedupeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AggregatorDedupeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DedupeSpec) DeepCopyInto(out *DedupeSpec) {
+	*out = *in
+	if in.Agent != nil {
+		in, out := &in.Agent, &out.Agent
+		*out = new(AgentDedupeSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Aggregator != nil {
+		in, out := &in.Aggregator, &out.Aggregator
+		*out = new(AggregatorDedupeSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DedupeSpec.
+func (in *DedupeSpec) DeepCopy() *DedupeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DedupeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MQTTTriggerSpec) DeepCopyInto(out *MQTTTriggerSpec) {
+	*out = *in
+	if in.PasswordSecretRef != nil {
+		in, out := &in.PasswordSecretRef, &out.PasswordSecretRef
+		*out = new(v1beta1.SecretKeyReference)
+		**out = **in
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(TLSSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MQTTTriggerSpec.
+func (in *MQTTTriggerSpec) DeepCopy() *MQTTTriggerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MQTTTriggerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TLSSpec) DeepCopyInto(out *TLSSpec) {
+	*out = *in
+	if in.CASecretRef != nil {
+		in, out := &in.CASecretRef, &out.CASecretRef
+		*out = new(v1beta1.SecretKeyReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TLSSpec.
+func (in *TLSSpec) DeepCopy() *TLSSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TLSSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WakeTriggersSpec) DeepCopyInto(out *WakeTriggersSpec) {
+	*out = *in
+	if in.ARP != nil {
+		in, out := &in.ARP, &out.ARP
+		*out = new(v1beta1.ARPWakeSpec)
+		**out = **in
+	}
+	if in.TCPPorts != nil {
+		in, out := &in.TCPPorts, &out.TCPPorts
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.MQTT != nil {
+		in, out := &in.MQTT, &out.MQTT
+		*out = new(MQTTTriggerSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Hooks != nil {
+		in, out := &in.Hooks, &out.Hooks
+		*out = make([]v1beta1.WakeHookSpec, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WakeTriggersSpec.
+func (in *WakeTriggersSpec) DeepCopy() *WakeTriggersSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WakeTriggersSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WolConfig) DeepCopyInto(out *WolConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WolConfig.
+func (in *WolConfig) DeepCopy() *WolConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(WolConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WolConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WolConfigList) DeepCopyInto(out *WolConfigList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WolConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WolConfigList.
+func (in *WolConfigList) DeepCopy() *WolConfigList {
+	if in == nil {
+		return nil
+	}
+	out := new(WolConfigList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WolConfigList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WolConfigSpec) DeepCopyInto(out *WolConfigSpec) {
+	*out = *in
+	if in.NamespaceSelectors != nil {
+		in, out := &in.NamespaceSelectors, &out.NamespaceSelectors
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NamespaceLabelSelector != nil {
+		in, out := &in.NamespaceLabelSelector, &out.NamespaceLabelSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.VMSelector != nil {
+		in, out := &in.VMSelector, &out.VMSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExplicitMappings != nil {
+		in, out := &in.ExplicitMappings, &out.ExplicitMappings
+		*out = make([]v1beta1.MACVMMapping, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Exclusions != nil {
+		in, out := &in.Exclusions, &out.Exclusions
+		*out = new(v1beta1.DiscoveryExclusions)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.WOLPorts != nil {
+		in, out := &in.WOLPorts, &out.WOLPorts
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.StartServiceAccount != nil {
+		in, out := &in.StartServiceAccount, &out.StartServiceAccount
+		*out = new(v1beta1.ServiceAccountReference)
+		**out = **in
+	}
+	if in.SecureOn != nil {
+		in, out := &in.SecureOn, &out.SecureOn
+		*out = new(v1beta1.SecureOnSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.WakeTriggers != nil {
+		in, out := &in.WakeTriggers, &out.WakeTriggers
+		*out = new(WakeTriggersSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ShutdownOnLAN != nil {
+		in, out := &in.ShutdownOnLAN, &out.ShutdownOnLAN
+		*out = new(v1beta1.ShutdownOnLANSpec)
+		**out = **in
+	}
+	if in.IdleShutdown != nil {
+		in, out := &in.IdleShutdown, &out.IdleShutdown
+		*out = new(v1beta1.IdleShutdownSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RawCapture != nil {
+		in, out := &in.RawCapture, &out.RawCapture
+		*out = new(v1beta1.RawCaptureSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Dedupe != nil {
+		in, out := &in.Dedupe, &out.Dedupe
+		*out = new(DedupeSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RateLimit != nil {
+		in, out := &in.RateLimit, &out.RateLimit
+		*out = new(v1beta1.EventRateLimitSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	in.Agent.DeepCopyInto(&out.Agent)
+	if in.Relays != nil {
+		in, out := &in.Relays, &out.Relays
+		*out = make([]v1beta1.RelaySpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.Audit != nil {
+		in, out := &in.Audit, &out.Audit
+		*out = new(v1beta1.AuditSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.WakeMetadata != nil {
+		in, out := &in.WakeMetadata, &out.WakeMetadata
+		*out = new(v1beta1.WakeMetadataSpec)
+		**out = **in
+	}
+	if in.PlacementHint != nil {
+		in, out := &in.PlacementHint, &out.PlacementHint
+		*out = new(v1beta1.PlacementHintSpec)
+		**out = **in
+	}
+	if in.StatusMappings != nil {
+		in, out := &in.StatusMappings, &out.StatusMappings
+		*out = new(v1beta1.StatusMappingsSpec)
+		**out = **in
+	}
+	if in.WakeVerification != nil {
+		in, out := &in.WakeVerification, &out.WakeVerification
+		*out = new(v1beta1.WakeVerificationSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WolConfigSpec.
+func (in *WolConfigSpec) DeepCopy() *WolConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WolConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -35,15 +35,141 @@ const (
 	DiscoveryModeExplicit DiscoveryMode = "Explicit"
 )
 
-// MACVMMapping defines an explicit MAC address to VM mapping
+// ConflictPolicy defines how a MAC claimed by VMs of different WolConfigs
+// with the same precedence is resolved
+// +kubebuilder:validation:Enum=PreferExplicit;PreferOldest;Reject;StartAll
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyPreferExplicit prefers explicit mappings over discovered VMs,
+	// then the oldest WolConfig
+	ConflictPolicyPreferExplicit ConflictPolicy = "PreferExplicit"
+	// ConflictPolicyPreferOldest prefers the oldest WolConfig
+	ConflictPolicyPreferOldest ConflictPolicy = "PreferOldest"
+	// ConflictPolicyReject leaves a conflicting MAC unmapped so no VM is started
+	ConflictPolicyReject ConflictPolicy = "Reject"
+	// ConflictPolicyStartAll starts every VM claiming the MAC
+	ConflictPolicyStartAll ConflictPolicy = "StartAll"
+)
+
+// SecureOnPolicy defines how the SecureOn password carried by a magic packet is enforced
+// +kubebuilder:validation:Enum=Ignore;Audit;Require
+type SecureOnPolicy string
+
+const (
+	// SecureOnPolicyIgnore starts the VM regardless of the password
+	SecureOnPolicyIgnore SecureOnPolicy = "Ignore"
+	// SecureOnPolicyAudit starts the VM but logs and counts missing or wrong passwords
+	SecureOnPolicyAudit SecureOnPolicy = "Audit"
+	// SecureOnPolicyRequire rejects packets without the expected password
+	SecureOnPolicyRequire SecureOnPolicy = "Require"
+)
+
+// AgentListenMode selects the listeners started by the agents
+// +kubebuilder:validation:Enum=Raw;UDP;Both
+type AgentListenMode string
+
+const (
+	// ListenModeRaw captures WoL frames with raw Ethernet sockets (requires NET_RAW)
+	ListenModeRaw AgentListenMode = "Raw"
+	// ListenModeUDP receives magic packets on the UDP WOL ports
+	ListenModeUDP AgentListenMode = "UDP"
+	// ListenModeBoth starts both the raw and the UDP listeners
+	ListenModeBoth AgentListenMode = "Both"
+)
+
+// AgentCaptureBackend selects how the agents' raw listeners capture the frames
+// +kubebuilder:validation:Enum=AFPacket;EBPF
+type AgentCaptureBackend string
+
+const (
+	// CaptureBackendAFPacket captures with AF_PACKET sockets and a classic BPF filter
+	CaptureBackendAFPacket AgentCaptureBackend = "AFPacket"
+	// CaptureBackendEBPF attaches an XDP program to each interface, copying to
+	// userspace only the frames the listeners need (requires Linux 5.9+)
+	CaptureBackendEBPF AgentCaptureBackend = "EBPF"
+)
+
+// MACVMMapping defines an explicit MAC address to VM mapping, or a Forward
+// entry re-emitting the magic packets of the MAC to an external machine
+// +kubebuilder:validation:XValidation:rule="has(self.forward) ? !has(self.vmName) && !has(self.namespace) && !has(self.vmSelector) : has(self.namespace) && has(self.vmName) != has(self.vmSelector)",message="a mapping needs a namespace and either vmName or vmSelector, or forward"
+// +kubebuilder:validation:XValidation:rule="!self.macAddress.contains('*') || has(self.vmSelector)",message="a macAddress with wildcards requires vmSelector"
 type MACVMMapping struct {
-	// MACAddress in format xx:xx:xx:xx:xx:xx
-	// +kubebuilder:validation:Pattern=`^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$`
+	// MACAddress in format xx:xx:xx:xx:xx:xx. With a VMSelector, octets can be
+	// wildcards matching any value (e.g. 52:54:00:aa:*:*)
+	// +kubebuilder:validation:Pattern=`^([0-9A-Fa-f]{2}|\*)(:([0-9A-Fa-f]{2}|\*)){5}$`
 	MACAddress string `json:"macAddress"`
 	// VMName is the name of the VirtualMachine
-	VMName string `json:"vmName"`
+	// +optional
+	VMName string `json:"vmName,omitempty"`
+	// VMSelector maps the MACs matching MACAddress to the VMs of Namespace
+	// with these labels that have them, e.g. short-lived VMs getting their
+	// MACs from a known range
+	// +optional
+	VMSelector *metav1.LabelSelector `json:"vmSelector,omitempty"`
 	// Namespace where the VM resides
-	Namespace string `json:"namespace"`
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// Forward re-emits the magic packets of this MAC to a machine outside the
+	// cluster (e.g. a bare-metal host) instead of starting a VM
+	// +optional
+	Forward *WOLForwardTarget `json:"forward,omitempty"`
+	// SecureOnPolicy overrides the config-level SecureOn policy for this mapping
+	// +optional
+	SecureOnPolicy SecureOnPolicy `json:"secureOnPolicy,omitempty"`
+	// PasswordSecretRef references the SecureOn password of this VM, used instead of
+	// the config-level password. Without a SecureOnPolicy, it makes the policy Require
+	// when the config-level policy is Ignore.
+	// +optional
+	PasswordSecretRef *SecretKeyReference `json:"passwordSecretRef,omitempty"`
+}
+
+// WOLForwardTarget is where the magic packets of a Forward mapping are re-emitted
+// +kubebuilder:validation:XValidation:rule="has(self.address) || has(self.interface)",message="forward needs an address or an interface"
+// +kubebuilder:validation:XValidation:rule="!has(self.interface) || has(self.nodeName)",message="forward.interface requires forward.nodeName"
+type WOLForwardTarget struct {
+	// Address is the IP the magic packet is sent to over UDP, usually the
+	// directed broadcast address of the target's subnet. Without an address,
+	// the packet is sent as a raw Ethernet frame (EtherType 0x0842) on Interface
+	// +optional
+	Address string `json:"address,omitempty"`
+
+	// Port is the UDP destination port
+	// +kubebuilder:default=9
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+
+	// Interface is the host interface of NodeName the packet is sent from
+	// (default: chosen by the routing table). Raw frames and interface
+	// binding require the NET_RAW capability, i.e. the Raw listen mode
+	// +optional
+	Interface string `json:"interface,omitempty"`
+
+	// NodeName is the node whose agent re-emits the packet. Without a node,
+	// the manager sends the packet to Address from its own pod
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+}
+
+// DiscoveryExclusions leaves VMs and MACs out of the All and LabelSelector
+// discovery of a WolConfig
+type DiscoveryExclusions struct {
+	// Namespaces whose VMs are not discovered
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// VMSelector excludes the VMs matching the label selector
+	// +optional
+	VMSelector *metav1.LabelSelector `json:"vmSelector,omitempty"`
+
+	// MACPrefixes excludes the MACs starting with one of the prefixes, e.g.
+	// the OUI "00:50:56" of infrastructure appliances or "52:54:00:ff"
+	// +kubebuilder:validation:MaxItems=100
+	// +kubebuilder:validation:items:Pattern=`^([0-9A-Fa-f]{2}:){0,5}[0-9A-Fa-f]{2}$`
+	// +optional
+	MACPrefixes []string `json:"macPrefixes,omitempty"`
 }
 
 // WolConfigSpec defines the desired state of WolConfig
@@ -54,18 +180,30 @@ type WolConfigSpec struct {
 	DiscoveryMode DiscoveryMode `json:"discoveryMode,omitempty"`
 
 	// NamespaceSelectors lists namespaces to watch for VMs
-	// If empty, all namespaces are monitored
+	// If empty, and without NamespaceLabelSelector, all namespaces are monitored
 	// +optional
 	NamespaceSelectors []string `json:"namespaceSelectors,omitempty"`
 
+	// NamespaceLabelSelector selects the namespaces to watch for VMs by their
+	// labels, in addition to NamespaceSelectors. Namespaces created later with
+	// matching labels are watched without editing the WolConfig
+	// +optional
+	NamespaceLabelSelector *metav1.LabelSelector `json:"namespaceLabelSelector,omitempty"`
+
 	// VMSelector is a label selector for VMs (used with DiscoveryMode=LabelSelector)
 	// +optional
 	VMSelector *metav1.LabelSelector `json:"vmSelector,omitempty"`
 
-	// ExplicitMappings provides explicit MAC to VM mappings (used with DiscoveryMode=Explicit)
+	// ExplicitMappings provides explicit MAC to VM mappings (used with DiscoveryMode=Explicit).
+	// Forward entries apply with every discovery mode
 	// +optional
 	ExplicitMappings []MACVMMapping `json:"explicitMappings,omitempty"`
 
+	// Exclusions leaves namespaces, VMs and MAC ranges out of the discovery,
+	// applied after NamespaceSelectors and VMSelector. Explicit mappings are not affected
+	// +optional
+	Exclusions *DiscoveryExclusions `json:"exclusions,omitempty"`
+
 	// WOLPorts are the UDP ports to listen for Wake-on-LAN packets
 	// Default: [9]
 	// +kubebuilder:default={9}
@@ -80,9 +218,549 @@ type WolConfigSpec struct {
 	// +optional
 	CacheTTL int `json:"cacheTTL,omitempty"`
 
+	// Precedence orders WolConfigs when they map the same MAC to different VMs:
+	// the config with the highest precedence wins
+	// +kubebuilder:default=0
+	// +optional
+	Precedence int32 `json:"precedence,omitempty"`
+
+	// ConflictPolicy resolves MACs claimed by configs with the same precedence.
+	// Reject wins over StartAll, then PreferOldest, then PreferExplicit
+	// +kubebuilder:default=PreferExplicit
+	// +optional
+	ConflictPolicy ConflictPolicy `json:"conflictPolicy,omitempty"`
+
+	// StartServiceAccount is a ServiceAccount the manager impersonates when starting
+	// VMs matched by this config, so the config can only start VMs that account is
+	// allowed to start. If not set, the operator's own identity is used.
+	// +optional
+	StartServiceAccount *ServiceAccountReference `json:"startServiceAccount,omitempty"`
+
+	// SecureOn configures the enforcement of SecureOn passwords for VMs matched by this config
+	// +optional
+	SecureOn *SecureOnSpec `json:"secureOn,omitempty"`
+
+	// ARPWake lets agents wake stopped VMs when clients send ARP requests for their IPs
+	// +optional
+	ARPWake *ARPWakeSpec `json:"arpWake,omitempty"`
+
+	// WakeTriggers lets agents wake stopped VMs on other traffic sent to them
+	// (pings, connection attempts, DNS queries for their name)
+	// +optional
+	WakeTriggers *WakeTriggersSpec `json:"wakeTriggers,omitempty"`
+
+	// ShutdownOnLAN lets sleep packets stop or pause the VMs of this config
+	// +optional
+	ShutdownOnLAN *ShutdownOnLANSpec `json:"shutdownOnLAN,omitempty"`
+
+	// IdleShutdown stops or pauses the running VMs of this config that stay
+	// idle, so they are woken again by WOL when needed
+	// +optional
+	IdleShutdown *IdleShutdownSpec `json:"idleShutdown,omitempty"`
+
+	// RawCapture widens what the agents' raw listeners recognize as a wake
+	// packet beyond broadcast EtherType 0x0842 frames. Requires the Raw listen mode
+	// +optional
+	RawCapture *RawCaptureSpec `json:"rawCapture,omitempty"`
+
+	// Dedupe tunes how long repeated packets for the VMs of this config are
+	// dropped, e.g. shorter for high-frequency wake testing or longer on chatty networks
+	// +optional
+	Dedupe *DedupeSpec `json:"dedupe,omitempty"`
+
+	// RateLimit bounds the start and stop requests that the packets for the
+	// VMs of this config send to KubeVirt
+	// +optional
+	RateLimit *EventRateLimitSpec `json:"rateLimit,omitempty"`
+
 	// Agent configuration for the WOL DaemonSet
 	// +optional
 	Agent AgentSpec `json:"agent,omitempty"`
+
+	// Relays are the external event sources (e.g. a relay at a branch office)
+	// allowed to report WOL events for this config through the relay endpoint
+	// of the manager, each authenticated by its own token
+	// +listType=map
+	// +listMapKey=name
+	// +kubebuilder:validation:MaxItems=50
+	// +optional
+	Relays []RelaySpec `json:"relays,omitempty"`
+
+	// WakeHooks are the signed HTTP callers (home automation, CI jobs, cloud
+	// schedulers) allowed to wake the VMs of this config through the wake hook
+	// endpoint of the manager REST API, each with its own HMAC key
+	// +listType=map
+	// +listMapKey=name
+	// +kubebuilder:validation:MaxItems=50
+	// +optional
+	WakeHooks []WakeHookSpec `json:"wakeHooks,omitempty"`
+
+	// Audit writes a JSON record of every wake and sleep decision on the VMs
+	// of this config (accepted or rejected, with source, reason and latency)
+	// to a file or an HTTP endpoint, e.g. for a SIEM
+	// +optional
+	Audit *AuditSpec `json:"audit,omitempty"`
+
+	// WakeMetadata annotates the VMs of this config with their last wake
+	// (time, source, node) and a wake counter, so that tools (and, optionally,
+	// the guest) can see why a VM was started
+	// +optional
+	WakeMetadata *WakeMetadataSpec `json:"wakeMetadata,omitempty"`
+
+	// DryRun makes the manager match and record the WOL packets and wake
+	// requests of this config (dedupe, metrics, Events, audit) without acting
+	// on the VMs: nothing is started, stopped or forwarded. Useful to roll out
+	// a config and check what it would wake
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// PlacementHint makes the VMs woken by a magic packet prefer the node that
+	// received it (or the nodes of its rack or zone), e.g. for L2-local storage
+	// or GPU locality
+	// +optional
+	PlacementHint *PlacementHintSpec `json:"placementHint,omitempty"`
+
+	// StatusMappings lists the resolved MAC to VM mappings of this config in
+	// its status, so that kubectl shows which MACs are wakeable
+	// +optional
+	StatusMappings *StatusMappingsSpec `json:"statusMappings,omitempty"`
+
+	// WakeVerification checks that the VMs started for a wake reach Running,
+	// and reports those that do not (Event, metric, audit record)
+	// +optional
+	WakeVerification *WakeVerificationSpec `json:"wakeVerification,omitempty"`
+}
+
+// WakeVerificationSpec configures the check of the VMs started for a wake
+type WakeVerificationSpec struct {
+	// Enabled reports the started VMs not Running within TimeoutSeconds
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// TimeoutSeconds is how long a started VM has to reach Running
+	// +kubebuilder:validation:Minimum=10
+	// +kubebuilder:validation:Maximum=3600
+	// +kubebuilder:default=300
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// StatusMappingsSpec configures the mappings listed in the WolConfig status
+type StatusMappingsSpec struct {
+	// Enabled lists the mappings of this config in status.mappings
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// MaxEntries bounds the mappings listed in the status, sorted by MAC;
+	// status.mappingCount reports the total
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=1000
+	// +kubebuilder:default=100
+	// +optional
+	MaxEntries int32 `json:"maxEntries,omitempty"`
+}
+
+// PlacementHintSpec configures the node affinity hint of the woken VMs
+type PlacementHintSpec struct {
+	// Enabled turns on the placement hint for the VMs of this config
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// TopologyKey is the node label whose value the preferred nodes share with
+	// the node that received the packet: kubernetes.io/hostname prefers that
+	// very node, a rack or zone label the nodes of the same rack or zone
+	// +kubebuilder:default="kubernetes.io/hostname"
+	// +optional
+	TopologyKey string `json:"topologyKey,omitempty"`
+
+	// Weight of the preferred node affinity term, against the other ones of the VM
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +kubebuilder:default=100
+	// +optional
+	Weight int32 `json:"weight,omitempty"`
+}
+
+// WakeMetadataSpec configures the wake annotations of the VMs
+type WakeMetadataSpec struct {
+	// Enabled turns on the wake annotations on the VMs of this config
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Guest also sets the annotations on the VM template before a stopped VM
+	// is started: they reach the VMI and its virt-launcher pod, from which a
+	// downwardAPI volume exposes them to the guest
+	// +optional
+	Guest bool `json:"guest,omitempty"`
+}
+
+// RelaySpec provisions an external relay
+type RelaySpec struct {
+	// Name identifies the relay in the status, logs and events
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+
+	// TokenSecretRef references the Secret key holding the bearer token of the relay
+	TokenSecretRef SecretKeyReference `json:"tokenSecretRef"`
+}
+
+// WakeHookSpec provisions a wake hook
+type WakeHookSpec struct {
+	// Name identifies the hook in the X-WOL-Hook header, logs and wake reasons
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+
+	// KeySecretRef references the Secret key holding the HMAC-SHA256 key
+	// the requests of the hook are signed with
+	KeySecretRef SecretKeyReference `json:"keySecretRef"`
+}
+
+// AuditSpec configures the sinks of the audit records of a WolConfig.
+// Records are written by the manager replica that takes the decision.
+// +kubebuilder:validation:XValidation:rule="has(self.file) || has(self.webhook)",message="audit needs a file or a webhook"
+type AuditSpec struct {
+	// File appends the records, one JSON object per line, to a file of the manager
+	// +optional
+	File *AuditFileSpec `json:"file,omitempty"`
+
+	// Webhook posts the records in batches to an HTTP endpoint
+	// +optional
+	Webhook *AuditWebhookSpec `json:"webhook,omitempty"`
+}
+
+// AuditFileSpec configures an audit file. WolConfigs with the same path share
+// the file, rotated with the settings of the first of them by name.
+type AuditFileSpec struct {
+	// Path is the absolute path of the file in the manager container,
+	// e.g. on a volume mounted for the purpose
+	// +kubebuilder:validation:Pattern=`^/`
+	Path string `json:"path"`
+
+	// MaxSizeMB rotates the file once it reaches this size
+	// +kubebuilder:default=100
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10240
+	// +optional
+	MaxSizeMB int32 `json:"maxSizeMB,omitempty"`
+
+	// MaxBackups is how many rotated files are kept, as <path>.1 (the newest)
+	// to <path>.<maxBackups>
+	// +kubebuilder:default=5
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	MaxBackups int32 `json:"maxBackups,omitempty"`
+}
+
+// AuditWebhookSpec configures an audit endpoint. The records are posted as
+// newline-delimited JSON (application/x-ndjson), up to 100 per request; the
+// records of a batch the endpoint does not accept are dropped.
+type AuditWebhookSpec struct {
+	// URL is the http:// or https:// endpoint the records are posted to
+	// +kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url"`
+
+	// BearerTokenSecretRef references the Secret key holding the token sent
+	// in the Authorization header
+	// +optional
+	BearerTokenSecretRef *SecretKeyReference `json:"bearerTokenSecretRef,omitempty"`
+
+	// CASecretRef references the Secret key holding the PEM CA certificates
+	// that sign the certificate of the endpoint, instead of the system ones
+	// +optional
+	CASecretRef *SecretKeyReference `json:"caSecretRef,omitempty"`
+}
+
+// RawCaptureSpec configures the magic packets captured at L2 by the agents' raw listeners
+type RawCaptureSpec struct {
+	// UDPPorts are the UDP ports whose IPv4 datagrams, broadcast included, are
+	// parsed for magic packets, e.g. 7 for legacy routers that rewrite WoL into
+	// UDP/7. Unlike wolPorts no socket is bound, so the ports may be in use on
+	// the node
+	// +kubebuilder:validation:MaxItems=8
+	// +kubebuilder:validation:items:Minimum=1
+	// +kubebuilder:validation:items:Maximum=65535
+	// +listType=set
+	// +optional
+	UDPPorts []int32 `json:"udpPorts,omitempty"`
+
+	// EtherTypes are the EtherTypes (e.g. "0x88b7") of the broadcast Ethernet
+	// frames carrying a wake magic packet, in addition to 0x0842. They cannot be
+	// ARP, IP, VLAN tags or the Shutdown-on-LAN EtherType
+	// +kubebuilder:validation:MaxItems=8
+	// +kubebuilder:validation:items:Pattern=`^0x[0-9A-Fa-f]{4}$`
+	// +listType=set
+	// +optional
+	EtherTypes []string `json:"etherTypes,omitempty"`
+}
+
+// DedupeSpec configures the dedupe windows of the agents and of the manager
+type DedupeSpec struct {
+	// AgentWindowSeconds is how long an agent drops a packet it already
+	// reported (same MAC, port and SecureOn password). Defaults to 2
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=300
+	// +optional
+	AgentWindowSeconds *int32 `json:"agentWindowSeconds,omitempty"`
+
+	// AggregatorWindowSeconds is how long the manager answers DUPLICATE to the
+	// same packet reported by other agents or resent, for the MACs matched by
+	// this config. Unmatched MACs keep the default. Defaults to 10
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=300
+	// +optional
+	AggregatorWindowSeconds *int32 `json:"aggregatorWindowSeconds,omitempty"`
+}
+
+// ARPWakeSpec configures wakes triggered by ARP who-has requests for the IPs of stopped VMs.
+// The IPs of a VM are the ones last reported while it was running, plus the
+// comma-separated list in its wol.pillon.org/ip-addresses annotation.
+type ARPWakeSpec struct {
+	// Enabled turns on ARP-triggered wakes for the VMs of this config
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Threshold is the number of ARP requests for the same IP, within WindowSeconds,
+	// needed to wake the VM. Scanners usually ask once per address, real clients retry.
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Threshold int32 `json:"threshold,omitempty"`
+
+	// WindowSeconds is the window in which Threshold ARP requests must be seen
+	// +kubebuilder:default=10
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	WindowSeconds int32 `json:"windowSeconds,omitempty"`
+
+	// Respond makes the agents answer the ARP requests and IPv6 neighbor
+	// solicitations for the IPs of the stopped VMs with the MAC of the VM, so
+	// tools that wake by IP (unicast magic packets) can resolve them. Answered
+	// clients stop asking: with Respond a Threshold above 1 only wakes on the
+	// magic packets, captured by the agents with Agent.DirectedWake.
+	// Neighbor solicitations reach the agents only in promiscuous mode.
+	// +optional
+	Respond bool `json:"respond,omitempty"`
+}
+
+// WakeTriggersSpec configures wakes triggered by packets other than magic
+// packets, like the wake-on-unicast patterns of server NICs. The agents
+// capture them on their raw listeners for the IPv4 addresses of the stopped
+// VMs, found like for ARPWake. Packets addressed to the MAC of a VM reach a
+// node only in promiscuous mode, or when flooded by the switches. VMs with
+// SecureOn Require are never woken by a trigger.
+type WakeTriggersSpec struct {
+	// MQTT subscribes the manager to a topic of an MQTT broker whose messages
+	// wake the VMs of this config, e.g. published by Home Assistant or IoT devices
+	// +optional
+	MQTT *MQTTTriggerSpec `json:"mqtt,omitempty"`
+
+	// ICMPEcho wakes a VM on a ping (ICMP echo request) to one of its IPs
+	// +optional
+	ICMPEcho bool `json:"icmpEcho,omitempty"`
+
+	// TCPPorts wakes a VM on a connection attempt (TCP SYN) to one of these ports of its IPs
+	// +kubebuilder:validation:MaxItems=16
+	// +kubebuilder:validation:items:Minimum=1
+	// +kubebuilder:validation:items:Maximum=65535
+	// +optional
+	TCPPorts []int32 `json:"tcpPorts,omitempty"`
+
+	// DNS wakes a VM on a DNS or mDNS address query (A, AAAA, ANY) for its
+	// name: <vm>, <vm>.local, or <vm>.<namespace> followed by any domain.
+	// Only the queries crossing the interfaces of the agents are seen.
+	// +optional
+	DNS bool `json:"dns,omitempty"`
+}
+
+// MQTTTriggerSpec configures the MQTT subscription of a WolConfig. A message
+// is {"mac":"52:54:00:12:34:56"}, {"vm":"my-vm","namespace":"team-a"} or a
+// bare MAC; retained messages are ignored, so a stale one does not wake the
+// VM at every reconnection.
+type MQTTTriggerSpec struct {
+	// BrokerURL is the address of the broker: tcp:// or mqtt:// (port 1883 by
+	// default), ssl://, tls:// or mqtts:// (port 8883 by default)
+	// +kubebuilder:validation:Pattern=`^(tcp|mqtt|ssl|tls|mqtts)://[^/?#]+$`
+	BrokerURL string `json:"brokerURL"`
+
+	// Topic is the topic filter subscribed to, wildcards allowed
+	// +kubebuilder:default="kubevirt-wol/wake"
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=256
+	// +optional
+	Topic string `json:"topic,omitempty"`
+
+	// ClientID identifies the manager to the broker (default kubevirt-wol-<wolconfig>)
+	// +kubebuilder:validation:MaxLength=64
+	// +optional
+	ClientID string `json:"clientID,omitempty"`
+
+	// Username authenticates the manager to the broker
+	// +optional
+	Username string `json:"username,omitempty"`
+
+	// PasswordSecretRef references the Secret key holding the password of Username
+	// +optional
+	PasswordSecretRef *SecretKeyReference `json:"passwordSecretRef,omitempty"`
+
+	// CASecretRef references the Secret key holding the PEM CA certificates
+	// that sign the certificate of a TLS broker, instead of the system ones
+	// +optional
+	CASecretRef *SecretKeyReference `json:"caSecretRef,omitempty"`
+}
+
+// ShutdownAction is what a sleep packet does to a VM
+// +kubebuilder:validation:Enum=Stop;Pause
+type ShutdownAction string
+
+const (
+	// ShutdownActionStop gracefully stops the VM
+	ShutdownActionStop ShutdownAction = "Stop"
+	// ShutdownActionPause pauses the running instance of the VM
+	ShutdownActionPause ShutdownAction = "Pause"
+)
+
+// ShutdownOnLANSpec configures the sleep packets that stop or pause VMs.
+// A magic packet carrying the MAC of a VM reversed byte by byte (the
+// Sleep-on-LAN convention) is a sleep packet for the VM.
+type ShutdownOnLANSpec struct {
+	// Enabled turns on sleep packets for the VMs of this config
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Action is what a sleep packet does to the VM
+	// +kubebuilder:default=Stop
+	// +optional
+	Action ShutdownAction `json:"action,omitempty"`
+
+	// EtherType also makes broadcast Ethernet frames with this EtherType
+	// (e.g. "0x0843") carrying the magic packet of a VM sleep packets.
+	// Requires the Raw listen mode
+	// +kubebuilder:validation:Pattern=`^0x[0-9A-Fa-f]{4}$`
+	// +optional
+	EtherType string `json:"etherType,omitempty"`
+}
+
+// IdleShutdownSpec configures the stop of idle VMs. A running VM is idle when
+// every configured signal says so; a signal that cannot be read keeps it awake.
+// +kubebuilder:validation:XValidation:rule="has(self.prometheus) || (has(self.noLoggedInUsers) && self.noLoggedInUsers)",message="prometheus or noLoggedInUsers is required"
+type IdleShutdownSpec struct {
+	// IdleAfter is how long a VM must stay idle before being stopped
+	// +kubebuilder:default="1h"
+	// +optional
+	IdleAfter metav1.Duration `json:"idleAfter,omitempty"`
+
+	// Action is what happens to an idle VM
+	// +kubebuilder:default=Stop
+	// +optional
+	Action ShutdownAction `json:"action,omitempty"`
+
+	// Prometheus reads the CPU usage of the VMs from the KubeVirt metrics
+	// (kubevirt_vmi_cpu_usage_seconds_total)
+	// +optional
+	Prometheus *IdlePrometheusSpec `json:"prometheus,omitempty"`
+
+	// NoLoggedInUsers makes a VM idle only when its guest agent reports no
+	// logged-in user. VMs without a connected guest agent are never idle
+	// +optional
+	NoLoggedInUsers bool `json:"noLoggedInUsers,omitempty"`
+}
+
+// IdlePrometheusSpec is the Prometheus (or Thanos Querier) scraping KubeVirt
+type IdlePrometheusSpec struct {
+	// URL of the Prometheus HTTP API, e.g. http://prometheus-k8s.monitoring:9090
+	// +kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url"`
+
+	// CPUThresholdMillicores is the CPU usage, averaged over 5 minutes, below
+	// which a VM is idle
+	// +kubebuilder:default=50
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	CPUThresholdMillicores int32 `json:"cpuThresholdMillicores,omitempty"`
+
+	// BearerTokenSecretRef references the Secret key holding the token sent
+	// to Prometheus, e.g. for the OpenShift Thanos Querier
+	// +optional
+	BearerTokenSecretRef *SecretKeyReference `json:"bearerTokenSecretRef,omitempty"`
+
+	// CASecretRef references the Secret key holding the PEM CA certificates
+	// of an HTTPS Prometheus, instead of the system ones
+	// +optional
+	CASecretRef *SecretKeyReference `json:"caSecretRef,omitempty"`
+}
+
+// EventRateLimitSpec configures the token buckets applied by the manager to
+// the packets of a WolConfig. A packet over either bucket is rejected as RATE_LIMITED.
+type EventRateLimitSpec struct {
+	// PerMAC is the bucket of each MAC address
+	// +optional
+	PerMAC *TokenBucketSpec `json:"perMAC,omitempty"`
+
+	// PerNode is the bucket of each agent (node) or relay reporting packets
+	// +optional
+	PerNode *TokenBucketSpec `json:"perNode,omitempty"`
+}
+
+// TokenBucketSpec is a token bucket: Burst requests at once, refilled at
+// RequestsPerMinute
+type TokenBucketSpec struct {
+	// RequestsPerMinute is the rate at which the bucket refills
+	// +kubebuilder:validation:Minimum=1
+	RequestsPerMinute int32 `json:"requestsPerMinute"`
+
+	// Burst is the size of the bucket
+	// +kubebuilder:default=5
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Burst int32 `json:"burst,omitempty"`
+}
+
+// SecureOnSpec configures SecureOn password enforcement
+type SecureOnSpec struct {
+	// Policy applied to the VMs matched by this config
+	// +kubebuilder:default=Ignore
+	// +optional
+	Policy SecureOnPolicy `json:"policy,omitempty"`
+
+	// Namespaces restricts the policy to VMs in these namespaces.
+	// VMs in other namespaces use Ignore. If empty, the policy applies to all VMs of this config.
+	// Explicit mappings with their own SecureOnPolicy are not affected.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// PasswordSecretRef references the Secret key holding the expected password,
+	// as 6 (or 4) hex bytes separated by colons or dashes (e.g. 01:23:45:67:89:ab)
+	// +optional
+	PasswordSecretRef *SecretKeyReference `json:"passwordSecretRef,omitempty"`
+}
+
+// SecretKeyReference identifies a key of a Secret
+type SecretKeyReference struct {
+	// Name of the Secret
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// Namespace of the Secret
+	// +kubebuilder:validation:MinLength=1
+	Namespace string `json:"namespace"`
+	// Key within the Secret data
+	// +kubebuilder:default=password
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// ServiceAccountReference identifies a ServiceAccount
+type ServiceAccountReference struct {
+	// Name of the ServiceAccount
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// Namespace of the ServiceAccount
+	// +kubebuilder:validation:MinLength=1
+	Namespace string `json:"namespace"`
 }
 
 // AgentSpec defines the DaemonSet configuration for WOL agents
@@ -115,6 +793,165 @@ type AgentSpec struct {
 	// PriorityClassName for agent pods
 	// +optional
 	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// Shared runs the agents of this WolConfig in the wol-shared-agent
+	// DaemonSet, together with those of the other WolConfigs with shared
+	// agents, instead of a DaemonSet of its own. The shared agents listen on
+	// the union of the WOLPorts and RawCapture settings of these WolConfigs;
+	// the other agent settings come from the first of them by name
+	// +optional
+	Shared bool `json:"shared,omitempty"`
+
+	// ServiceAccountName is the ServiceAccount of the agent pods, in the
+	// namespace of the manager. Defaults to the ServiceAccount labelled
+	// app.kubernetes.io/name=wol-agent, app.kubernetes.io/component=agent
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// Promiscuous enables promiscuous capture on the agents' raw listeners, needed
+	// for WoL frames unicast to the VM MAC on NICs that are not bridge ports.
+	// When false only the frames the NIC accepts anyway reach the BPF filter,
+	// which in practice means broadcast EtherType 0x0842 frames.
+	// +kubebuilder:default=true
+	// +optional
+	Promiscuous *bool `json:"promiscuous,omitempty"`
+
+	// CaptureBackend selects how the raw listeners capture the frames. EBPF
+	// attaches an XDP program to each interface that copies only EtherType
+	// 0x0842 and the UDP WOLPorts frames (plus those of the enabled ARP, NDP and
+	// trigger features) to the agent, so Promiscuous is not used; it needs the
+	// BPF, PERFMON and NET_ADMIN capabilities and falls back to AFPacket on the
+	// interfaces where the program cannot be attached. Defaults to AFPacket
+	// +kubebuilder:default=AFPacket
+	// +optional
+	CaptureBackend AgentCaptureBackend `json:"captureBackend,omitempty"`
+
+	// DirectedWake makes the agents' raw listeners also capture magic packets
+	// sent as unicast IPv4 UDP to the WOLPorts of an address other than the
+	// node's, e.g. by etherwake or wakeonlan to the last-known IP of a VM.
+	// Requires the Raw listen mode; unicast to a VM MAC reaches a NIC that is
+	// not a bridge port only with Promiscuous
+	// +optional
+	DirectedWake bool `json:"directedWake,omitempty"`
+
+	// NetworkAwareScheduling restricts the agents to the nodes that provide the
+	// bridge of the NetworkAttachmentDefinitions used by the managed VMs, by
+	// requesting the bridge-marker / ovs-cni resource those NADs declare.
+	// Only applies when all the NADs use the same bridge resource; otherwise
+	// the agents are not restricted
+	// +optional
+	NetworkAwareScheduling bool `json:"networkAwareScheduling,omitempty"`
+
+	// MetricsPort is the host port of the agents' Prometheus /metrics endpoint.
+	// Defaults to the health check port, 8080; 0 disables the metrics. The
+	// agents use the host network, so the port must be free on the nodes
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	MetricsPort *int32 `json:"metricsPort,omitempty"`
+
+	// Tuning overrides the agent socket and cache defaults, for high-throughput
+	// or very low-memory nodes
+	// +optional
+	Tuning *AgentTuning `json:"tuning,omitempty"`
+
+	// InterfaceSelector selects the interfaces the agents open raw sockets on,
+	// e.g. the ones attached to the WOL VLAN, instead of those picked by name. The node annotations wol.pillon.org/interface-include and
+	// wol.pillon.org/interface-exclude, when set, replace it on their node.
+	// Interfaces of the VMs' NetworkAttachmentDefinitions are listened on anyway
+	// +optional
+	InterfaceSelector *InterfaceSelector `json:"interfaceSelector,omitempty"`
+
+	// IPFamilies are the IP families of the agents' UDP listener: IPv4 binds
+	// 0.0.0.0 (broadcast), IPv6 binds :: and joins the ff02::1 all-nodes group
+	// on every multicast interface. Both for dual-stack networks.
+	// Defaults to IPv4
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=2
+	// +kubebuilder:validation:items:Enum=IPv4;IPv6
+	// +listType=set
+	// +optional
+	IPFamilies []corev1.IPFamily `json:"ipFamilies,omitempty"`
+
+	// ListenModes selects the agents' listeners. Without Raw (or Both) the
+	// agents run without the NET_RAW capability, and ARP wake is disabled.
+	// Defaults to Both
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=2
+	// +listType=set
+	// +optional
+	ListenModes []AgentListenMode `json:"listenModes,omitempty"`
+
+	// VLANs restricts the agents' raw listeners to the frames of these VLAN
+	// IDs, matched against the outer and the inner tag of QinQ frames; 0
+	// accepts the untagged frames. The UDP listeners are not restricted.
+	// Defaults to every VLAN
+	// +kubebuilder:validation:MaxItems=64
+	// +kubebuilder:validation:items:Minimum=0
+	// +kubebuilder:validation:items:Maximum=4094
+	// +listType=set
+	// +optional
+	VLANs []int32 `json:"vlans,omitempty"`
+
+	// MinAgents is the number of agents that must be connected (sending
+	// heartbeats to the manager) for the WolConfig to be Ready. 0 does not
+	// wait for the agents. Defaults to 1
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinAgents *int32 `json:"minAgents,omitempty"`
+}
+
+// InterfaceSelector selects network interfaces by name, with shell patterns
+// (e.g. "eth1", "br-wol*")
+type InterfaceSelector struct {
+	// Include, if not empty, selects the matching interfaces among all the up
+	// ones, instead of those the agent picks by name (NICs, Wi-Fi, br-*), so
+	// bonds, VLANs and teams (e.g. "bond0", "vlan100", "team*") can be used
+	// +kubebuilder:validation:MaxItems=16
+	// +kubebuilder:validation:items:MinLength=1
+	// +kubebuilder:validation:items:Pattern=`^[^,\s]+$`
+	// +optional
+	Include []string `json:"include,omitempty"`
+
+	// Exclude drops the matching interfaces
+	// +kubebuilder:validation:MaxItems=16
+	// +kubebuilder:validation:items:MinLength=1
+	// +kubebuilder:validation:items:Pattern=`^[^,\s]+$`
+	// +optional
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// AgentTuning tunes the agent sockets and caches. Unset fields keep the agent defaults.
+type AgentTuning struct {
+	// UDPReadBufferBytes is the receive buffer (SO_RCVBUF) of the UDP listener.
+	// Defaults to 65536; the kernel caps it at net.core.rmem_max
+	// +kubebuilder:validation:Minimum=4096
+	// +kubebuilder:validation:Maximum=67108864
+	// +optional
+	UDPReadBufferBytes *int32 `json:"udpReadBufferBytes,omitempty"`
+
+	// RawReadBufferBytes is the receive buffer (SO_RCVBUF) of the raw Ethernet
+	// listeners. Defaults to the kernel default (net.core.rmem_default)
+	// +kubebuilder:validation:Minimum=4096
+	// +kubebuilder:validation:Maximum=67108864
+	// +optional
+	RawReadBufferBytes *int32 `json:"rawReadBufferBytes,omitempty"`
+
+	// ReceiveTimeoutSeconds is how long a listener read waits for a packet
+	// (SO_RCVTIMEO on the raw sockets, read deadline on the UDP socket) before
+	// checking for shutdown and refreshing its watchdog heartbeat. Defaults to 1.
+	// At most 10, since the watchdog considers a loop silent for 15s stuck
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10
+	// +optional
+	ReceiveTimeoutSeconds *int32 `json:"receiveTimeoutSeconds,omitempty"`
+
+	// DedupeCleanupIntervalSeconds is how often expired entries are removed
+	// from the agent dedupe cache. Defaults to 30
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=3600
+	// +optional
+	DedupeCleanupIntervalSeconds *int32 `json:"dedupeCleanupIntervalSeconds,omitempty"`
 }
 
 // WolConfigStatus defines the observed state of WolConfig
@@ -134,6 +971,100 @@ type WolConfigStatus struct {
 	// AgentStatus contains information about the agent DaemonSet
 	// +optional
 	AgentStatus *AgentStatus `json:"agentStatus,omitempty"`
+
+	// Conflicts lists MACs this config claims that are also claimed by other VMs
+	// (truncated to a bounded number of entries)
+	// +optional
+	Conflicts []MappingConflict `json:"conflicts,omitempty"`
+
+	// ConflictCount is the total number of conflicting MACs, including the ones
+	// left out of Conflicts by the truncation
+	// +optional
+	ConflictCount int `json:"conflictCount,omitempty"`
+
+	// Mappings lists the MAC to VM mappings of this config, with
+	// spec.statusMappings (sorted by MAC, truncated to maxEntries)
+	// +optional
+	Mappings []MACMappingStatus `json:"mappings,omitempty"`
+
+	// MappingCount is the total number of MACs mapped by this config, including
+	// the ones left out of Mappings by the truncation
+	// +optional
+	MappingCount int `json:"mappingCount,omitempty"`
+
+	// NetworkAttachments lists the NetworkAttachmentDefinitions (<namespace>/<name>)
+	// used by the managed VMs, whose interfaces are suggested to the agents
+	// +optional
+	NetworkAttachments []string `json:"networkAttachments,omitempty"`
+
+	// Listeners summarizes, per node, the UDP ports and interfaces the agents
+	// bound and the ones they failed to bind (nodes with failures first,
+	// truncated to a bounded number of entries)
+	// +optional
+	Listeners []NodeListenerStatus `json:"listeners,omitempty"`
+}
+
+// NodeListenerStatus reports what the agent on a node is listening on
+type NodeListenerStatus struct {
+	// NodeName is the node of the agent, or the name of the relay
+	NodeName string `json:"nodeName"`
+
+	// Relay is true for the listeners of an external relay
+	// +optional
+	Relay bool `json:"relay,omitempty"`
+
+	// UDPPorts are the UDP (IPv4) ports bound by the agent
+	// +optional
+	UDPPorts []int `json:"udpPorts,omitempty"`
+
+	// UDP6Ports are the UDP (IPv6) ports bound by the agent
+	// +optional
+	UDP6Ports []int `json:"udp6Ports,omitempty"`
+
+	// Interfaces are the interfaces with a raw Ethernet WoL listener
+	// +optional
+	Interfaces []string `json:"interfaces,omitempty"`
+
+	// Errors lists the listeners that could not be started,
+	// e.g. "udp/9: address already in use"
+	// +optional
+	Errors []string `json:"errors,omitempty"`
+
+	// LastReport is when the agent last reported its listeners
+	LastReport metav1.Time `json:"lastReport"`
+}
+
+// MACMappingStatus reports a MAC address and the VM it wakes
+type MACMappingStatus struct {
+	// MACAddress is the mapped MAC address
+	MACAddress string `json:"macAddress"`
+
+	// Namespace of the VM
+	Namespace string `json:"namespace"`
+
+	// Name of the VM
+	Name string `json:"name"`
+
+	// MappingType is how the MAC was mapped: explicit, discovered or pool-member
+	// +optional
+	MappingType string `json:"mappingType,omitempty"`
+}
+
+// MappingConflict reports a MAC address claimed by more than one VM
+type MappingConflict struct {
+	// MACAddress is the conflicting MAC address
+	MACAddress string `json:"macAddress"`
+
+	// Candidates lists the claiming VMs as <wolconfig>:<namespace>/<vm>
+	Candidates []string `json:"candidates"`
+
+	// Winner is the candidate the MAC resolves to (empty when the MAC was rejected)
+	// +optional
+	Winner string `json:"winner,omitempty"`
+
+	// Companions are the candidates started along with the winner (StartAll policy)
+	// +optional
+	Companions []string `json:"companions,omitempty"`
 }
 
 // AgentStatus contains status information about the agent DaemonSet
@@ -149,6 +1080,46 @@ type AgentStatus struct {
 
 	// NumberAvailable is the number of nodes with available daemon pods
 	NumberAvailable int32 `json:"numberAvailable,omitempty"`
+
+	// Nodes reports the last heartbeat of the agent on each node (agents not
+	// alive first, truncated to a bounded number of entries). An agent silent
+	// for 10 minutes is dropped
+	// +optional
+	Nodes []NodeAgentStatus `json:"nodes,omitempty"`
+}
+
+// NodeAgentStatus is the state reported by the agent on a node
+type NodeAgentStatus struct {
+	// NodeName is the node of the agent
+	NodeName string `json:"nodeName"`
+
+	// Alive is false when the agent missed its last heartbeats (dead, or
+	// unable to reach the manager)
+	Alive bool `json:"alive"`
+
+	// Version of the agent
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// LastHeartbeat is when the agent last reported its state
+	LastHeartbeat metav1.Time `json:"lastHeartbeat"`
+
+	// StartTime is when the agent started
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// Interfaces are the interfaces with a raw Ethernet WoL listener
+	// +optional
+	Interfaces []string `json:"interfaces,omitempty"`
+
+	// PacketsSeen is the number of valid magic packets received since the agent started
+	// +optional
+	PacketsSeen int64 `json:"packetsSeen,omitempty"`
+
+	// ReportFailures is the number of WOL events the agent failed to report
+	// to the manager since it started
+	// +optional
+	ReportFailures int64 `json:"reportFailures,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,117 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WolPolicyAction defines what a wake does to the VMs selected by a WolPolicy
+// +kubebuilder:validation:Enum=Start;Resume;RestartIfCrashed;Ignore
+type WolPolicyAction string
+
+const (
+	// WolPolicyActionStart starts the VM if it is stopped
+	WolPolicyActionStart WolPolicyAction = "Start"
+	// WolPolicyActionResume unpauses the VM if it is paused, and starts it if it is stopped
+	WolPolicyActionResume WolPolicyAction = "Resume"
+	// WolPolicyActionRestartIfCrashed restarts the VM if it crashed, and starts it if it is stopped
+	WolPolicyActionRestartIfCrashed WolPolicyAction = "RestartIfCrashed"
+	// WolPolicyActionIgnore ignores the wakes
+	WolPolicyActionIgnore WolPolicyAction = "Ignore"
+)
+
+// WolPolicySpec defines what happens when a selected VM is woken
+type WolPolicySpec struct {
+	// VMSelector selects the VirtualMachines of the namespace the policy
+	// applies to. An empty selector selects every VM of the namespace
+	// +optional
+	VMSelector *metav1.LabelSelector `json:"vmSelector,omitempty"`
+
+	// Action is what a wake does to the VM
+	// +kubebuilder:default=Start
+	// +optional
+	Action WolPolicyAction `json:"action,omitempty"`
+
+	// RateLimit bounds the wakes of each VM; the extra wakes are rejected
+	// +optional
+	RateLimit *WakeRateLimit `json:"rateLimit,omitempty"`
+
+	// QuietHours are the time windows in which wakes are rejected
+	// +optional
+	QuietHours []QuietHours `json:"quietHours,omitempty"`
+}
+
+// WakeRateLimit bounds the wakes of a VM
+type WakeRateLimit struct {
+	// MaxWakes is the number of wakes accepted per VM in each period
+	// +kubebuilder:validation:Minimum=1
+	MaxWakes int32 `json:"maxWakes"`
+
+	// PeriodSeconds is the length of the sliding window
+	// +kubebuilder:default=3600
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+}
+
+// QuietHours is a daily time window in which wakes are rejected
+type QuietHours struct {
+	// Start of the window, as HH:MM
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start"`
+
+	// End of the window, as HH:MM (excluded). An end before the start spans midnight
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	End string `json:"end"`
+
+	// Days the window starts on (every day when empty)
+	// +kubebuilder:validation:items:Enum=Monday;Tuesday;Wednesday;Thursday;Friday;Saturday;Sunday
+	// +listType=set
+	// +optional
+	Days []string `json:"days,omitempty"`
+
+	// TimeZone is the IANA time zone of Start and End (e.g. Europe/Rome)
+	// +kubebuilder:default=UTC
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Namespaced,shortName=wolpol
+// +kubebuilder:printcolumn:name="Action",type=string,JSONPath=`.spec.action`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// WolPolicy lets the owners of the VMs of a namespace define what a wake does to them
+type WolPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec WolPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WolPolicyList contains a list of WolPolicy
+type WolPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WolPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WolPolicy{}, &WolPolicyList{})
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WolScheduleSpec defines when the selected VMs are woken and put to sleep
+// +kubebuilder:validation:XValidation:rule="has(self.vmNames) || has(self.vmSelector)",message="vmNames or vmSelector is required"
+// +kubebuilder:validation:XValidation:rule="has(self.wakeCron) || has(self.sleepCron)",message="wakeCron or sleepCron is required"
+type WolScheduleSpec struct {
+	// VMNames are VirtualMachines of the namespace of the schedule
+	// +kubebuilder:validation:MaxItems=100
+	// +listType=set
+	// +optional
+	VMNames []string `json:"vmNames,omitempty"`
+
+	// VMSelector selects VirtualMachines of the namespace of the schedule, in
+	// addition to VMNames. An empty selector selects every VM of the namespace
+	// +optional
+	VMSelector *metav1.LabelSelector `json:"vmSelector,omitempty"`
+
+	// WakeCron is the cron schedule (minute hour day-of-month month
+	// day-of-week, or @daily, @weekly, ...) the VMs are started on
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	WakeCron string `json:"wakeCron,omitempty"`
+
+	// SleepCron is the cron schedule the VMs are put to sleep on
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	SleepCron string `json:"sleepCron,omitempty"`
+
+	// SleepAction is what SleepCron does to the running VMs
+	// +kubebuilder:default=Stop
+	// +optional
+	SleepAction ShutdownAction `json:"sleepAction,omitempty"`
+
+	// TimeZone is the IANA time zone of the cron schedules (e.g. Europe/Rome)
+	// +kubebuilder:default=UTC
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// StartingDeadlineSeconds is how late a run can still happen, e.g. after
+	// a manager restart; older missed runs are skipped
+	// +kubebuilder:default=600
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	StartingDeadlineSeconds int64 `json:"startingDeadlineSeconds,omitempty"`
+
+	// Suspend stops the schedule without deleting it
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+// WolScheduleStatus reports the runs of a WolSchedule
+type WolScheduleStatus struct {
+	// LastWakeTime is the scheduled time of the last wake run
+	// +optional
+	LastWakeTime *metav1.Time `json:"lastWakeTime,omitempty"`
+
+	// NextWakeTime is the scheduled time of the next wake run
+	// +optional
+	NextWakeTime *metav1.Time `json:"nextWakeTime,omitempty"`
+
+	// LastSleepTime is the scheduled time of the last sleep run
+	// +optional
+	LastSleepTime *metav1.Time `json:"lastSleepTime,omitempty"`
+
+	// NextSleepTime is the scheduled time of the next sleep run
+	// +optional
+	NextSleepTime *metav1.Time `json:"nextSleepTime,omitempty"`
+
+	// ScheduledVMs is the number of VMs the last run acted on
+	// +optional
+	ScheduledVMs int32 `json:"scheduledVMs,omitempty"`
+
+	// Conditions represent the latest available observations of the schedule
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Namespaced,shortName=wolsched
+// +kubebuilder:printcolumn:name="Wake",type=string,JSONPath=`.spec.wakeCron`
+// +kubebuilder:printcolumn:name="Sleep",type=string,JSONPath=`.spec.sleepCron`
+// +kubebuilder:printcolumn:name="Next Wake",type=date,JSONPath=`.status.nextWakeTime`
+// +kubebuilder:printcolumn:name="Next Sleep",type=date,JSONPath=`.status.nextSleepTime`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// WolSchedule wakes and puts to sleep VMs of its namespace on cron schedules
+type WolSchedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WolScheduleSpec   `json:"spec,omitempty"`
+	Status WolScheduleStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WolScheduleList contains a list of WolSchedule
+type WolScheduleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WolSchedule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WolSchedule{}, &WolScheduleList{})
+}
@@ -27,6 +27,21 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ARPWakeSpec) DeepCopyInto(out *ARPWakeSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ARPWakeSpec.
+func (in *ARPWakeSpec) DeepCopy() *ARPWakeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ARPWakeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AgentSpec) DeepCopyInto(out *AgentSpec) {
 	*out = *in
@@ -44,50 +59,766 @@ func (in *AgentSpec) DeepCopyInto(out *AgentSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
-	in.Resources.DeepCopyInto(&out.Resources)
-	if in.UpdateStrategy != nil {
-		in, out := &in.UpdateStrategy, &out.UpdateStrategy
-		*out = new(appsv1.DaemonSetUpdateStrategy)
-		(*in).DeepCopyInto(*out)
+	in.Resources.DeepCopyInto(&out.Resources)
+	if in.UpdateStrategy != nil {
+		in, out := &in.UpdateStrategy, &out.UpdateStrategy
+		*out = new(appsv1.DaemonSetUpdateStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Promiscuous != nil {
+		in, out := &in.Promiscuous, &out.Promiscuous
+		*out = new(bool)
+		**out = **in
+	}
+	if in.MetricsPort != nil {
+		in, out := &in.MetricsPort, &out.MetricsPort
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Tuning != nil {
+		in, out := &in.Tuning, &out.Tuning
+		*out = new(AgentTuning)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.InterfaceSelector != nil {
+		in, out := &in.InterfaceSelector, &out.InterfaceSelector
+		*out = new(InterfaceSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IPFamilies != nil {
+		in, out := &in.IPFamilies, &out.IPFamilies
+		*out = make([]corev1.IPFamily, len(*in))
+		copy(*out, *in)
+	}
+	if in.ListenModes != nil {
+		in, out := &in.ListenModes, &out.ListenModes
+		*out = make([]AgentListenMode, len(*in))
+		copy(*out, *in)
+	}
+	if in.VLANs != nil {
+		in, out := &in.VLANs, &out.VLANs
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.MinAgents != nil {
+		in, out := &in.MinAgents, &out.MinAgents
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentSpec.
+func (in *AgentSpec) DeepCopy() *AgentSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AgentSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AgentStatus) DeepCopyInto(out *AgentStatus) {
+	*out = *in
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]NodeAgentStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentStatus.
+func (in *AgentStatus) DeepCopy() *AgentStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AgentStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AgentTuning) DeepCopyInto(out *AgentTuning) {
+	*out = *in
+	if in.UDPReadBufferBytes != nil {
+		in, out := &in.UDPReadBufferBytes, &out.UDPReadBufferBytes
+		*out = new(int32)
+		**out = **in
+	}
+	if in.RawReadBufferBytes != nil {
+		in, out := &in.RawReadBufferBytes, &out.RawReadBufferBytes
+		*out = new(int32)
+		**out = **in
+	}
+	if in.ReceiveTimeoutSeconds != nil {
+		in, out := &in.ReceiveTimeoutSeconds, &out.ReceiveTimeoutSeconds
+		*out = new(int32)
+		**out = **in
+	}
+	if in.DedupeCleanupIntervalSeconds != nil {
+		in, out := &in.DedupeCleanupIntervalSeconds, &out.DedupeCleanupIntervalSeconds
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentTuning.
+func (in *AgentTuning) DeepCopy() *AgentTuning {
+	if in == nil {
+		return nil
+	}
+	out := new(AgentTuning)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AuditFileSpec) DeepCopyInto(out *AuditFileSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AuditFileSpec.
+func (in *AuditFileSpec) DeepCopy() *AuditFileSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AuditFileSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AuditSpec) DeepCopyInto(out *AuditSpec) {
+	*out = *in
+	if in.File != nil {
+		in, out := &in.File, &out.File
+		*out = new(AuditFileSpec)
+		**out = **in
+	}
+	if in.Webhook != nil {
+		in, out := &in.Webhook, &out.Webhook
+		*out = new(AuditWebhookSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AuditSpec.
+func (in *AuditSpec) DeepCopy() *AuditSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AuditSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AuditWebhookSpec) DeepCopyInto(out *AuditWebhookSpec) {
+	*out = *in
+	if in.BearerTokenSecretRef != nil {
+		in, out := &in.BearerTokenSecretRef, &out.BearerTokenSecretRef
+		*out = new(SecretKeyReference)
+		**out = **in
+	}
+	if in.CASecretRef != nil {
+		in, out := &in.CASecretRef, &out.CASecretRef
+		*out = new(SecretKeyReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AuditWebhookSpec.
+func (in *AuditWebhookSpec) DeepCopy() *AuditWebhookSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AuditWebhookSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DedupeSpec) DeepCopyInto(out *DedupeSpec) {
+	*out = *in
+	if in.AgentWindowSeconds != nil {
+		in, out := &in.AgentWindowSeconds, &out.AgentWindowSeconds
+		*out = new(int32)
+		**out = **in
+	}
+	if in.AggregatorWindowSeconds != nil {
+		in, out := &in.AggregatorWindowSeconds, &out.AggregatorWindowSeconds
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DedupeSpec.
+func (in *DedupeSpec) DeepCopy() *DedupeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DedupeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscoveryExclusions) DeepCopyInto(out *DiscoveryExclusions) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.VMSelector != nil {
+		in, out := &in.VMSelector, &out.VMSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MACPrefixes != nil {
+		in, out := &in.MACPrefixes, &out.MACPrefixes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscoveryExclusions.
+func (in *DiscoveryExclusions) DeepCopy() *DiscoveryExclusions {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscoveryExclusions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EventRateLimitSpec) DeepCopyInto(out *EventRateLimitSpec) {
+	*out = *in
+	if in.PerMAC != nil {
+		in, out := &in.PerMAC, &out.PerMAC
+		*out = new(TokenBucketSpec)
+		**out = **in
+	}
+	if in.PerNode != nil {
+		in, out := &in.PerNode, &out.PerNode
+		*out = new(TokenBucketSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EventRateLimitSpec.
+func (in *EventRateLimitSpec) DeepCopy() *EventRateLimitSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EventRateLimitSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IdlePrometheusSpec) DeepCopyInto(out *IdlePrometheusSpec) {
+	*out = *in
+	if in.BearerTokenSecretRef != nil {
+		in, out := &in.BearerTokenSecretRef, &out.BearerTokenSecretRef
+		*out = new(SecretKeyReference)
+		**out = **in
+	}
+	if in.CASecretRef != nil {
+		in, out := &in.CASecretRef, &out.CASecretRef
+		*out = new(SecretKeyReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IdlePrometheusSpec.
+func (in *IdlePrometheusSpec) DeepCopy() *IdlePrometheusSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IdlePrometheusSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IdleShutdownSpec) DeepCopyInto(out *IdleShutdownSpec) {
+	*out = *in
+	out.IdleAfter = in.IdleAfter
+	if in.Prometheus != nil {
+		in, out := &in.Prometheus, &out.Prometheus
+		*out = new(IdlePrometheusSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IdleShutdownSpec.
+func (in *IdleShutdownSpec) DeepCopy() *IdleShutdownSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IdleShutdownSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InterfaceSelector) DeepCopyInto(out *InterfaceSelector) {
+	*out = *in
+	if in.Include != nil {
+		in, out := &in.Include, &out.Include
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InterfaceSelector.
+func (in *InterfaceSelector) DeepCopy() *InterfaceSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(InterfaceSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MACMappingStatus) DeepCopyInto(out *MACMappingStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MACMappingStatus.
+func (in *MACMappingStatus) DeepCopy() *MACMappingStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MACMappingStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MACVMMapping) DeepCopyInto(out *MACVMMapping) {
+	*out = *in
+	if in.VMSelector != nil {
+		in, out := &in.VMSelector, &out.VMSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Forward != nil {
+		in, out := &in.Forward, &out.Forward
+		*out = new(WOLForwardTarget)
+		**out = **in
+	}
+	if in.PasswordSecretRef != nil {
+		in, out := &in.PasswordSecretRef, &out.PasswordSecretRef
+		*out = new(SecretKeyReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MACVMMapping.
+func (in *MACVMMapping) DeepCopy() *MACVMMapping {
+	if in == nil {
+		return nil
+	}
+	out := new(MACVMMapping)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MQTTTriggerSpec) DeepCopyInto(out *MQTTTriggerSpec) {
+	*out = *in
+	if in.PasswordSecretRef != nil {
+		in, out := &in.PasswordSecretRef, &out.PasswordSecretRef
+		*out = new(SecretKeyReference)
+		**out = **in
+	}
+	if in.CASecretRef != nil {
+		in, out := &in.CASecretRef, &out.CASecretRef
+		*out = new(SecretKeyReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MQTTTriggerSpec.
+func (in *MQTTTriggerSpec) DeepCopy() *MQTTTriggerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MQTTTriggerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MappingConflict) DeepCopyInto(out *MappingConflict) {
+	*out = *in
+	if in.Candidates != nil {
+		in, out := &in.Candidates, &out.Candidates
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Companions != nil {
+		in, out := &in.Companions, &out.Companions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MappingConflict.
+func (in *MappingConflict) DeepCopy() *MappingConflict {
+	if in == nil {
+		return nil
+	}
+	out := new(MappingConflict)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeAgentStatus) DeepCopyInto(out *NodeAgentStatus) {
+	*out = *in
+	in.LastHeartbeat.DeepCopyInto(&out.LastHeartbeat)
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Interfaces != nil {
+		in, out := &in.Interfaces, &out.Interfaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeAgentStatus.
+func (in *NodeAgentStatus) DeepCopy() *NodeAgentStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeAgentStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeListenerStatus) DeepCopyInto(out *NodeListenerStatus) {
+	*out = *in
+	if in.UDPPorts != nil {
+		in, out := &in.UDPPorts, &out.UDPPorts
+		*out = make([]int, len(*in))
+		copy(*out, *in)
+	}
+	if in.UDP6Ports != nil {
+		in, out := &in.UDP6Ports, &out.UDP6Ports
+		*out = make([]int, len(*in))
+		copy(*out, *in)
+	}
+	if in.Interfaces != nil {
+		in, out := &in.Interfaces, &out.Interfaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Errors != nil {
+		in, out := &in.Errors, &out.Errors
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.LastReport.DeepCopyInto(&out.LastReport)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeListenerStatus.
+func (in *NodeListenerStatus) DeepCopy() *NodeListenerStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeListenerStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlacementHintSpec) DeepCopyInto(out *PlacementHintSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlacementHintSpec.
+func (in *PlacementHintSpec) DeepCopy() *PlacementHintSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PlacementHintSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuietHours) DeepCopyInto(out *QuietHours) {
+	*out = *in
+	if in.Days != nil {
+		in, out := &in.Days, &out.Days
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuietHours.
+func (in *QuietHours) DeepCopy() *QuietHours {
+	if in == nil {
+		return nil
+	}
+	out := new(QuietHours)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RawCaptureSpec) DeepCopyInto(out *RawCaptureSpec) {
+	*out = *in
+	if in.UDPPorts != nil {
+		in, out := &in.UDPPorts, &out.UDPPorts
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.EtherTypes != nil {
+		in, out := &in.EtherTypes, &out.EtherTypes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RawCaptureSpec.
+func (in *RawCaptureSpec) DeepCopy() *RawCaptureSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RawCaptureSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RelaySpec) DeepCopyInto(out *RelaySpec) {
+	*out = *in
+	out.TokenSecretRef = in.TokenSecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RelaySpec.
+func (in *RelaySpec) DeepCopy() *RelaySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RelaySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretKeyReference) DeepCopyInto(out *SecretKeyReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretKeyReference.
+func (in *SecretKeyReference) DeepCopy() *SecretKeyReference {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretKeyReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecureOnSpec) DeepCopyInto(out *SecureOnSpec) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PasswordSecretRef != nil {
+		in, out := &in.PasswordSecretRef, &out.PasswordSecretRef
+		*out = new(SecretKeyReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecureOnSpec.
+func (in *SecureOnSpec) DeepCopy() *SecureOnSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SecureOnSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceAccountReference) DeepCopyInto(out *ServiceAccountReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceAccountReference.
+func (in *ServiceAccountReference) DeepCopy() *ServiceAccountReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceAccountReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ShutdownOnLANSpec) DeepCopyInto(out *ShutdownOnLANSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ShutdownOnLANSpec.
+func (in *ShutdownOnLANSpec) DeepCopy() *ShutdownOnLANSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ShutdownOnLANSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StatusMappingsSpec) DeepCopyInto(out *StatusMappingsSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StatusMappingsSpec.
+func (in *StatusMappingsSpec) DeepCopy() *StatusMappingsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(StatusMappingsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TokenBucketSpec) DeepCopyInto(out *TokenBucketSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TokenBucketSpec.
+func (in *TokenBucketSpec) DeepCopy() *TokenBucketSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TokenBucketSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WOLForwardTarget) DeepCopyInto(out *WOLForwardTarget) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WOLForwardTarget.
+func (in *WOLForwardTarget) DeepCopy() *WOLForwardTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(WOLForwardTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WakeHookSpec) DeepCopyInto(out *WakeHookSpec) {
+	*out = *in
+	out.KeySecretRef = in.KeySecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WakeHookSpec.
+func (in *WakeHookSpec) DeepCopy() *WakeHookSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WakeHookSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WakeMetadataSpec) DeepCopyInto(out *WakeMetadataSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WakeMetadataSpec.
+func (in *WakeMetadataSpec) DeepCopy() *WakeMetadataSpec {
+	if in == nil {
+		return nil
 	}
+	out := new(WakeMetadataSpec)
+	in.DeepCopyInto(out)
+	return out
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentSpec.
-func (in *AgentSpec) DeepCopy() *AgentSpec {
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WakeRateLimit) DeepCopyInto(out *WakeRateLimit) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WakeRateLimit.
+func (in *WakeRateLimit) DeepCopy() *WakeRateLimit {
 	if in == nil {
 		return nil
 	}
-	out := new(AgentSpec)
+	out := new(WakeRateLimit)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *AgentStatus) DeepCopyInto(out *AgentStatus) {
+func (in *WakeTriggersSpec) DeepCopyInto(out *WakeTriggersSpec) {
 	*out = *in
+	if in.MQTT != nil {
+		in, out := &in.MQTT, &out.MQTT
+		*out = new(MQTTTriggerSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TCPPorts != nil {
+		in, out := &in.TCPPorts, &out.TCPPorts
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentStatus.
-func (in *AgentStatus) DeepCopy() *AgentStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WakeTriggersSpec.
+func (in *WakeTriggersSpec) DeepCopy() *WakeTriggersSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(AgentStatus)
+	out := new(WakeTriggersSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *MACVMMapping) DeepCopyInto(out *MACVMMapping) {
+func (in *WakeVerificationSpec) DeepCopyInto(out *WakeVerificationSpec) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MACVMMapping.
-func (in *MACVMMapping) DeepCopy() *MACVMMapping {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WakeVerificationSpec.
+func (in *WakeVerificationSpec) DeepCopy() *WakeVerificationSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(MACVMMapping)
+	out := new(WakeVerificationSpec)
 	in.DeepCopyInto(out)
 	return out
 }
@@ -159,6 +890,11 @@ func (in *WolConfigSpec) DeepCopyInto(out *WolConfigSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.NamespaceLabelSelector != nil {
+		in, out := &in.NamespaceLabelSelector, &out.NamespaceLabelSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.VMSelector != nil {
 		in, out := &in.VMSelector, &out.VMSelector
 		*out = new(v1.LabelSelector)
@@ -167,14 +903,101 @@ func (in *WolConfigSpec) DeepCopyInto(out *WolConfigSpec) {
 	if in.ExplicitMappings != nil {
 		in, out := &in.ExplicitMappings, &out.ExplicitMappings
 		*out = make([]MACVMMapping, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Exclusions != nil {
+		in, out := &in.Exclusions, &out.Exclusions
+		*out = new(DiscoveryExclusions)
+		(*in).DeepCopyInto(*out)
 	}
 	if in.WOLPorts != nil {
 		in, out := &in.WOLPorts, &out.WOLPorts
 		*out = make([]int, len(*in))
 		copy(*out, *in)
 	}
+	if in.StartServiceAccount != nil {
+		in, out := &in.StartServiceAccount, &out.StartServiceAccount
+		*out = new(ServiceAccountReference)
+		**out = **in
+	}
+	if in.SecureOn != nil {
+		in, out := &in.SecureOn, &out.SecureOn
+		*out = new(SecureOnSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ARPWake != nil {
+		in, out := &in.ARPWake, &out.ARPWake
+		*out = new(ARPWakeSpec)
+		**out = **in
+	}
+	if in.WakeTriggers != nil {
+		in, out := &in.WakeTriggers, &out.WakeTriggers
+		*out = new(WakeTriggersSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ShutdownOnLAN != nil {
+		in, out := &in.ShutdownOnLAN, &out.ShutdownOnLAN
+		*out = new(ShutdownOnLANSpec)
+		**out = **in
+	}
+	if in.IdleShutdown != nil {
+		in, out := &in.IdleShutdown, &out.IdleShutdown
+		*out = new(IdleShutdownSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RawCapture != nil {
+		in, out := &in.RawCapture, &out.RawCapture
+		*out = new(RawCaptureSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Dedupe != nil {
+		in, out := &in.Dedupe, &out.Dedupe
+		*out = new(DedupeSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RateLimit != nil {
+		in, out := &in.RateLimit, &out.RateLimit
+		*out = new(EventRateLimitSpec)
+		(*in).DeepCopyInto(*out)
+	}
 	in.Agent.DeepCopyInto(&out.Agent)
+	if in.Relays != nil {
+		in, out := &in.Relays, &out.Relays
+		*out = make([]RelaySpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.WakeHooks != nil {
+		in, out := &in.WakeHooks, &out.WakeHooks
+		*out = make([]WakeHookSpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.Audit != nil {
+		in, out := &in.Audit, &out.Audit
+		*out = new(AuditSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.WakeMetadata != nil {
+		in, out := &in.WakeMetadata, &out.WakeMetadata
+		*out = new(WakeMetadataSpec)
+		**out = **in
+	}
+	if in.PlacementHint != nil {
+		in, out := &in.PlacementHint, &out.PlacementHint
+		*out = new(PlacementHintSpec)
+		**out = **in
+	}
+	if in.StatusMappings != nil {
+		in, out := &in.StatusMappings, &out.StatusMappings
+		*out = new(StatusMappingsSpec)
+		**out = **in
+	}
+	if in.WakeVerification != nil {
+		in, out := &in.WakeVerification, &out.WakeVerification
+		*out = new(WakeVerificationSpec)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WolConfigSpec.
@@ -204,7 +1027,31 @@ func (in *WolConfigStatus) DeepCopyInto(out *WolConfigStatus) {
 	if in.AgentStatus != nil {
 		in, out := &in.AgentStatus, &out.AgentStatus
 		*out = new(AgentStatus)
-		**out = **in
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conflicts != nil {
+		in, out := &in.Conflicts, &out.Conflicts
+		*out = make([]MappingConflict, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Mappings != nil {
+		in, out := &in.Mappings, &out.Mappings
+		*out = make([]MACMappingStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.NetworkAttachments != nil {
+		in, out := &in.NetworkAttachments, &out.NetworkAttachments
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Listeners != nil {
+		in, out := &in.Listeners, &out.Listeners
+		*out = make([]NodeListenerStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 }
 
@@ -217,3 +1064,215 @@ func (in *WolConfigStatus) DeepCopy() *WolConfigStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WolPolicy) DeepCopyInto(out *WolPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WolPolicy.
+func (in *WolPolicy) DeepCopy() *WolPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(WolPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WolPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WolPolicyList) DeepCopyInto(out *WolPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WolPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WolPolicyList.
+func (in *WolPolicyList) DeepCopy() *WolPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(WolPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WolPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WolPolicySpec) DeepCopyInto(out *WolPolicySpec) {
+	*out = *in
+	if in.VMSelector != nil {
+		in, out := &in.VMSelector, &out.VMSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RateLimit != nil {
+		in, out := &in.RateLimit, &out.RateLimit
+		*out = new(WakeRateLimit)
+		**out = **in
+	}
+	if in.QuietHours != nil {
+		in, out := &in.QuietHours, &out.QuietHours
+		*out = make([]QuietHours, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WolPolicySpec.
+func (in *WolPolicySpec) DeepCopy() *WolPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WolPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WolSchedule) DeepCopyInto(out *WolSchedule) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WolSchedule.
+func (in *WolSchedule) DeepCopy() *WolSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(WolSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WolSchedule) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WolScheduleList) DeepCopyInto(out *WolScheduleList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WolSchedule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WolScheduleList.
+func (in *WolScheduleList) DeepCopy() *WolScheduleList {
+	if in == nil {
+		return nil
+	}
+	out := new(WolScheduleList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WolScheduleList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WolScheduleSpec) DeepCopyInto(out *WolScheduleSpec) {
+	*out = *in
+	if in.VMNames != nil {
+		in, out := &in.VMNames, &out.VMNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.VMSelector != nil {
+		in, out := &in.VMSelector, &out.VMSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WolScheduleSpec.
+func (in *WolScheduleSpec) DeepCopy() *WolScheduleSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WolScheduleSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WolScheduleStatus) DeepCopyInto(out *WolScheduleStatus) {
+	*out = *in
+	if in.LastWakeTime != nil {
+		in, out := &in.LastWakeTime, &out.LastWakeTime
+		*out = (*in).DeepCopy()
+	}
+	if in.NextWakeTime != nil {
+		in, out := &in.NextWakeTime, &out.NextWakeTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastSleepTime != nil {
+		in, out := &in.LastSleepTime, &out.LastSleepTime
+		*out = (*in).DeepCopy()
+	}
+	if in.NextSleepTime != nil {
+		in, out := &in.NextSleepTime, &out.NextSleepTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WolScheduleStatus.
+func (in *WolScheduleStatus) DeepCopy() *WolScheduleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WolScheduleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
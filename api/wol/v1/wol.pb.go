@@ -26,34 +26,58 @@ const (
 type ResponseStatus int32
 
 const (
-	ResponseStatus_UNKNOWN            ResponseStatus = 0
-	ResponseStatus_ACCEPTED           ResponseStatus = 1 // Evento accettato e in processing
-	ResponseStatus_DUPLICATE          ResponseStatus = 2 // Evento duplicato (già processato recentemente)
-	ResponseStatus_VM_NOT_FOUND       ResponseStatus = 3 // Nessuna VM configurata per questo MAC
-	ResponseStatus_VM_START_INITIATED ResponseStatus = 4 // Start della VM iniziato con successo
-	ResponseStatus_VM_ALREADY_RUNNING ResponseStatus = 5 // VM già in esecuzione
-	ResponseStatus_ERROR              ResponseStatus = 6 // Errore durante il processing
+	ResponseStatus_UNKNOWN                    ResponseStatus = 0
+	ResponseStatus_ACCEPTED                   ResponseStatus = 1  // Evento accettato e in processing
+	ResponseStatus_DUPLICATE                  ResponseStatus = 2  // Evento duplicato (già processato recentemente)
+	ResponseStatus_VM_NOT_FOUND               ResponseStatus = 3  // Nessuna VM configurata per questo MAC
+	ResponseStatus_VM_START_INITIATED         ResponseStatus = 4  // Start della VM iniziato con successo
+	ResponseStatus_VM_ALREADY_RUNNING         ResponseStatus = 5  // VM già in esecuzione: nessuno start richiesto
+	ResponseStatus_ERROR                      ResponseStatus = 6  // Errore durante il processing
+	ResponseStatus_SECURE_ON_PASSWORD_MISSING ResponseStatus = 7  // La VM richiede una password SecureOn, assente nel pacchetto
+	ResponseStatus_SECURE_ON_PASSWORD_INVALID ResponseStatus = 8  // Password SecureOn errata
+	ResponseStatus_POLICY_REJECTED            ResponseStatus = 9  // Wake rifiutato da una WolPolicy (Ignore, quiet hours, rate limit)
+	ResponseStatus_FORWARDED                  ResponseStatus = 10 // Magic packet riemesso verso una macchina esterna (mapping Forward)
+	ResponseStatus_VM_STOP_INITIATED          ResponseStatus = 11 // Stop o pausa della VM richiesti da un pacchetto di sleep
+	ResponseStatus_RATE_LIMITED               ResponseStatus = 12 // Pacchetto oltre il rate limit (per MAC o per nodo) della WolConfig
+	ResponseStatus_NODE_UNVERIFIED            ResponseStatus = 13 // Il nodo dichiarato non corrisponde all'IP o all'identità dell'agent
+	ResponseStatus_DRY_RUN                    ResponseStatus = 14 // WolConfig in dry-run: la VM sarebbe stata avviata, fermata o il pacchetto inoltrato
 )
 
 // Enum value maps for ResponseStatus.
 var (
 	ResponseStatus_name = map[int32]string{
-		0: "UNKNOWN",
-		1: "ACCEPTED",
-		2: "DUPLICATE",
-		3: "VM_NOT_FOUND",
-		4: "VM_START_INITIATED",
-		5: "VM_ALREADY_RUNNING",
-		6: "ERROR",
+		0:  "UNKNOWN",
+		1:  "ACCEPTED",
+		2:  "DUPLICATE",
+		3:  "VM_NOT_FOUND",
+		4:  "VM_START_INITIATED",
+		5:  "VM_ALREADY_RUNNING",
+		6:  "ERROR",
+		7:  "SECURE_ON_PASSWORD_MISSING",
+		8:  "SECURE_ON_PASSWORD_INVALID",
+		9:  "POLICY_REJECTED",
+		10: "FORWARDED",
+		11: "VM_STOP_INITIATED",
+		12: "RATE_LIMITED",
+		13: "NODE_UNVERIFIED",
+		14: "DRY_RUN",
 	}
 	ResponseStatus_value = map[string]int32{
-		"UNKNOWN":            0,
-		"ACCEPTED":           1,
-		"DUPLICATE":          2,
-		"VM_NOT_FOUND":       3,
-		"VM_START_INITIATED": 4,
-		"VM_ALREADY_RUNNING": 5,
-		"ERROR":              6,
+		"UNKNOWN":                    0,
+		"ACCEPTED":                   1,
+		"DUPLICATE":                  2,
+		"VM_NOT_FOUND":               3,
+		"VM_START_INITIATED":         4,
+		"VM_ALREADY_RUNNING":         5,
+		"ERROR":                      6,
+		"SECURE_ON_PASSWORD_MISSING": 7,
+		"SECURE_ON_PASSWORD_INVALID": 8,
+		"POLICY_REJECTED":            9,
+		"FORWARDED":                  10,
+		"VM_STOP_INITIATED":          11,
+		"RATE_LIMITED":               12,
+		"NODE_UNVERIFIED":            13,
+		"DRY_RUN":                    14,
 	}
 )
 
@@ -130,7 +154,7 @@ func (x HealthCheckResponse_ServingStatus) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use HealthCheckResponse_ServingStatus.Descriptor instead.
 func (HealthCheckResponse_ServingStatus) EnumDescriptor() ([]byte, []int) {
-	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{4, 0}
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{30, 0}
 }
 
 // WOLEvent rappresenta un pacchetto WOL ricevuto da un agent
@@ -147,7 +171,23 @@ type WOLEvent struct {
 	// Porta sorgente del pacchetto
 	SourcePort uint32 `protobuf:"varint,5,opt,name=source_port,json=sourcePort,proto3" json:"source_port,omitempty"`
 	// Dimensione del pacchetto ricevuto
-	PacketSize    uint32 `protobuf:"varint,6,opt,name=packet_size,json=packetSize,proto3" json:"packet_size,omitempty"`
+	PacketSize uint32 `protobuf:"varint,6,opt,name=packet_size,json=packetSize,proto3" json:"packet_size,omitempty"`
+	// Porta UDP di destinazione del pacchetto (0 = frame Ethernet raw, EtherType 0x0842)
+	DestinationPort uint32 `protobuf:"varint,7,opt,name=destination_port,json=destinationPort,proto3" json:"destination_port,omitempty"`
+	// Password SecureOn (xx:xx:xx:xx:xx:xx) se presente in coda al magic packet
+	SecureOnPassword string `protobuf:"bytes,8,opt,name=secure_on_password,json=secureOnPassword,proto3" json:"secure_on_password,omitempty"`
+	// Microsecondi trascorsi sull'agent tra la ricezione del pacchetto (timestamp)
+	// e l'invio dell'evento: separa i ritardi del nodo da quelli di rete
+	AgentDelayUs uint64 `protobuf:"varint,9,opt,name=agent_delay_us,json=agentDelayUs,proto3" json:"agent_delay_us,omitempty"`
+	// Pacchetto di sleep (frame raw con l'EtherType di shutdownOnLAN): chiede
+	// di fermare o mettere in pausa la VM invece di avviarla
+	Sleep bool `protobuf:"varint,10,opt,name=sleep,proto3" json:"sleep,omitempty"`
+	// Numero di sequenza dell'evento su ReportWOLEventStream, ripetuto nella
+	// risposta (0 sulle chiamate unary)
+	Sequence uint64 `protobuf:"varint,11,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	// Versione dell'agent (o del relay) che ha inviato l'evento, vuota se più
+	// vecchio: il manager segnala le versioni troppo distanti dalla propria
+	AgentVersion  string `protobuf:"bytes,12,opt,name=agent_version,json=agentVersion,proto3" json:"agent_version,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -224,6 +264,48 @@ func (x *WOLEvent) GetPacketSize() uint32 {
 	return 0
 }
 
+func (x *WOLEvent) GetDestinationPort() uint32 {
+	if x != nil {
+		return x.DestinationPort
+	}
+	return 0
+}
+
+func (x *WOLEvent) GetSecureOnPassword() string {
+	if x != nil {
+		return x.SecureOnPassword
+	}
+	return ""
+}
+
+func (x *WOLEvent) GetAgentDelayUs() uint64 {
+	if x != nil {
+		return x.AgentDelayUs
+	}
+	return 0
+}
+
+func (x *WOLEvent) GetSleep() bool {
+	if x != nil {
+		return x.Sleep
+	}
+	return false
+}
+
+func (x *WOLEvent) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *WOLEvent) GetAgentVersion() string {
+	if x != nil {
+		return x.AgentVersion
+	}
+	return ""
+}
+
 // WOLEventResponse conferma la ricezione e il processing dell'evento
 type WOLEventResponse struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
@@ -237,8 +319,12 @@ type WOLEventResponse struct {
 	WasDuplicate bool `protobuf:"varint,4,opt,name=was_duplicate,json=wasDuplicate,proto3" json:"was_duplicate,omitempty"`
 	// Tempo impiegato per processare la richiesta (millisecondi)
 	ProcessingTimeMs int64 `protobuf:"varint,5,opt,name=processing_time_ms,json=processingTimeMs,proto3" json:"processing_time_ms,omitempty"`
-	unknownFields    protoimpl.UnknownFields
-	sizeCache        protoimpl.SizeCache
+	// Sequence dell'evento a cui risponde (solo su ReportWOLEventStream)
+	Sequence uint64 `protobuf:"varint,6,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	// Dipendenze avviate dopo la VM (annotazione wol.pillon.org/wake-with), in ordine
+	Dependencies  []*WakeDependency `protobuf:"bytes,7,rep,name=dependencies,proto3" json:"dependencies,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *WOLEventResponse) Reset() {
@@ -306,30 +392,46 @@ func (x *WOLEventResponse) GetProcessingTimeMs() int64 {
 	return 0
 }
 
-// VMInfo contiene informazioni sulla VM target
-type VMInfo struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
-	Namespace     string                 `protobuf:"bytes,2,opt,name=namespace,proto3" json:"namespace,omitempty"`
-	CurrentState  string                 `protobuf:"bytes,3,opt,name=current_state,json=currentState,proto3" json:"current_state,omitempty"`
+func (x *WOLEventResponse) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *WOLEventResponse) GetDependencies() []*WakeDependency {
+	if x != nil {
+		return x.Dependencies
+	}
+	return nil
+}
+
+// WakeDependency è l'esito dell'avvio di una dipendenza della VM svegliata
+type WakeDependency struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Namespace string                 `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	Name      string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	// VM_START_INITIATED, oppure il motivo per cui non è stata avviata
+	Status        ResponseStatus `protobuf:"varint,3,opt,name=status,proto3,enum=wol.v1.ResponseStatus" json:"status,omitempty"`
+	Message       string         `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *VMInfo) Reset() {
-	*x = VMInfo{}
+func (x *WakeDependency) Reset() {
+	*x = WakeDependency{}
 	mi := &file_api_wol_v1_wol_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *VMInfo) String() string {
+func (x *WakeDependency) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*VMInfo) ProtoMessage() {}
+func (*WakeDependency) ProtoMessage() {}
 
-func (x *VMInfo) ProtoReflect() protoreflect.Message {
+func (x *WakeDependency) ProtoReflect() protoreflect.Message {
 	mi := &file_api_wol_v1_wol_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -341,54 +443,68 @@ func (x *VMInfo) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use VMInfo.ProtoReflect.Descriptor instead.
-func (*VMInfo) Descriptor() ([]byte, []int) {
+// Deprecated: Use WakeDependency.ProtoReflect.Descriptor instead.
+func (*WakeDependency) Descriptor() ([]byte, []int) {
 	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{2}
 }
 
-func (x *VMInfo) GetName() string {
+func (x *WakeDependency) GetNamespace() string {
 	if x != nil {
-		return x.Name
+		return x.Namespace
 	}
 	return ""
 }
 
-func (x *VMInfo) GetNamespace() string {
+func (x *WakeDependency) GetName() string {
 	if x != nil {
-		return x.Namespace
+		return x.Name
 	}
 	return ""
 }
 
-func (x *VMInfo) GetCurrentState() string {
+func (x *WakeDependency) GetStatus() ResponseStatus {
 	if x != nil {
-		return x.CurrentState
+		return x.Status
+	}
+	return ResponseStatus_UNKNOWN
+}
+
+func (x *WakeDependency) GetMessage() string {
+	if x != nil {
+		return x.Message
 	}
 	return ""
 }
 
-// HealthCheckRequest per verificare stato server
-type HealthCheckRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Service       string                 `protobuf:"bytes,1,opt,name=service,proto3" json:"service,omitempty"`
+// WakeRequest chiede l'avvio di una VM gestita da una WolConfig
+type WakeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Namespace della VM
+	Namespace string `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	// Nome della VirtualMachine
+	Name string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	// Componente che ha generato la richiesta (es. "activator")
+	Source string `protobuf:"bytes,3,opt,name=source,proto3" json:"source,omitempty"`
+	// Descrizione del trigger, solo per i log (es. "tcp 10.0.0.5:51234 -> :22")
+	Reason        string `protobuf:"bytes,4,opt,name=reason,proto3" json:"reason,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *HealthCheckRequest) Reset() {
-	*x = HealthCheckRequest{}
+func (x *WakeRequest) Reset() {
+	*x = WakeRequest{}
 	mi := &file_api_wol_v1_wol_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *HealthCheckRequest) String() string {
+func (x *WakeRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*HealthCheckRequest) ProtoMessage() {}
+func (*WakeRequest) ProtoMessage() {}
 
-func (x *HealthCheckRequest) ProtoReflect() protoreflect.Message {
+func (x *WakeRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_api_wol_v1_wol_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -400,40 +516,64 @@ func (x *HealthCheckRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use HealthCheckRequest.ProtoReflect.Descriptor instead.
-func (*HealthCheckRequest) Descriptor() ([]byte, []int) {
+// Deprecated: Use WakeRequest.ProtoReflect.Descriptor instead.
+func (*WakeRequest) Descriptor() ([]byte, []int) {
 	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{3}
 }
 
-func (x *HealthCheckRequest) GetService() string {
+func (x *WakeRequest) GetNamespace() string {
 	if x != nil {
-		return x.Service
+		return x.Namespace
 	}
 	return ""
 }
 
-// HealthCheckResponse risposta health check
-type HealthCheckResponse struct {
-	state         protoimpl.MessageState            `protogen:"open.v1"`
-	Status        HealthCheckResponse_ServingStatus `protobuf:"varint,1,opt,name=status,proto3,enum=wol.v1.HealthCheckResponse_ServingStatus" json:"status,omitempty"`
+func (x *WakeRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *WakeRequest) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *WakeRequest) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+// ARPTargetsRequest chiede la lista degli IP da osservare
+type ARPTargetsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Nodo dell'agent
+	NodeName string `protobuf:"bytes,1,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
+	// WolConfig dell'agent (vuoto = tutte le WolConfig con arpWake abilitato)
+	WolConfig     string `protobuf:"bytes,2,opt,name=wol_config,json=wolConfig,proto3" json:"wol_config,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *HealthCheckResponse) Reset() {
-	*x = HealthCheckResponse{}
+func (x *ARPTargetsRequest) Reset() {
+	*x = ARPTargetsRequest{}
 	mi := &file_api_wol_v1_wol_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *HealthCheckResponse) String() string {
+func (x *ARPTargetsRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*HealthCheckResponse) ProtoMessage() {}
+func (*ARPTargetsRequest) ProtoMessage() {}
 
-func (x *HealthCheckResponse) ProtoReflect() protoreflect.Message {
+func (x *ARPTargetsRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_api_wol_v1_wol_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -445,64 +585,2025 @@ func (x *HealthCheckResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use HealthCheckResponse.ProtoReflect.Descriptor instead.
-func (*HealthCheckResponse) Descriptor() ([]byte, []int) {
+// Deprecated: Use ARPTargetsRequest.ProtoReflect.Descriptor instead.
+func (*ARPTargetsRequest) Descriptor() ([]byte, []int) {
 	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{4}
 }
 
-func (x *HealthCheckResponse) GetStatus() HealthCheckResponse_ServingStatus {
+func (x *ARPTargetsRequest) GetNodeName() string {
 	if x != nil {
-		return x.Status
+		return x.NodeName
 	}
-	return HealthCheckResponse_UNKNOWN
+	return ""
 }
 
-var File_api_wol_v1_wol_proto protoreflect.FileDescriptor
+func (x *ARPTargetsRequest) GetWolConfig() string {
+	if x != nil {
+		return x.WolConfig
+	}
+	return ""
+}
 
-const file_api_wol_v1_wol_proto_rawDesc = "" +
-	"\n" +
-	"\x14api/wol/v1/wol.proto\x12\x06wol.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xe1\x01\n" +
-	"\bWOLEvent\x12\x1f\n" +
-	"\vmac_address\x18\x01 \x01(\tR\n" +
-	"macAddress\x128\n" +
-	"\ttimestamp\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12\x1b\n" +
-	"\tnode_name\x18\x03 \x01(\tR\bnodeName\x12\x1b\n" +
-	"\tsource_ip\x18\x04 \x01(\tR\bsourceIp\x12\x1f\n" +
-	"\vsource_port\x18\x05 \x01(\rR\n" +
-	"sourcePort\x12\x1f\n" +
-	"\vpacket_size\x18\x06 \x01(\rR\n" +
-	"packetSize\"\xd8\x01\n" +
-	"\x10WOLEventResponse\x12.\n" +
-	"\x06status\x18\x01 \x01(\x0e2\x16.wol.v1.ResponseStatusR\x06status\x12\x18\n" +
-	"\amessage\x18\x02 \x01(\tR\amessage\x12'\n" +
-	"\avm_info\x18\x03 \x01(\v2\x0e.wol.v1.VMInfoR\x06vmInfo\x12#\n" +
-	"\rwas_duplicate\x18\x04 \x01(\bR\fwasDuplicate\x12,\n" +
-	"\x12processing_time_ms\x18\x05 \x01(\x03R\x10processingTimeMs\"_\n" +
-	"\x06VMInfo\x12\x12\n" +
-	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1c\n" +
-	"\tnamespace\x18\x02 \x01(\tR\tnamespace\x12#\n" +
-	"\rcurrent_state\x18\x03 \x01(\tR\fcurrentState\".\n" +
-	"\x12HealthCheckRequest\x12\x18\n" +
-	"\aservice\x18\x01 \x01(\tR\aservice\"\x94\x01\n" +
-	"\x13HealthCheckResponse\x12A\n" +
-	"\x06status\x18\x01 \x01(\x0e2).wol.v1.HealthCheckResponse.ServingStatusR\x06status\":\n" +
-	"\rServingStatus\x12\v\n" +
-	"\aUNKNOWN\x10\x00\x12\v\n" +
-	"\aSERVING\x10\x01\x12\x0f\n" +
-	"\vNOT_SERVING\x10\x02*\x87\x01\n" +
-	"\x0eResponseStatus\x12\v\n" +
-	"\aUNKNOWN\x10\x00\x12\f\n" +
-	"\bACCEPTED\x10\x01\x12\r\n" +
-	"\tDUPLICATE\x10\x02\x12\x10\n" +
-	"\fVM_NOT_FOUND\x10\x03\x12\x16\n" +
-	"\x12VM_START_INITIATED\x10\x04\x12\x16\n" +
-	"\x12VM_ALREADY_RUNNING\x10\x05\x12\t\n" +
-	"\x05ERROR\x10\x062\xda\x01\n" +
-	"\n" +
-	"WOLService\x12<\n" +
-	"\x0eReportWOLEvent\x12\x10.wol.v1.WOLEvent\x1a\x18.wol.v1.WOLEventResponse\x12F\n" +
-	"\x14ReportWOLEventStream\x12\x10.wol.v1.WOLEvent\x1a\x18.wol.v1.WOLEventResponse(\x010\x01\x12F\n" +
-	"\vHealthCheck\x12\x1a.wol.v1.HealthCheckRequest\x1a\x1b.wol.v1.HealthCheckResponseB2Z0github.com/gpillon/kubevirt-wol/api/wol/v1;wolv1b\x06proto3"
+// ARPTarget è un IP di una VM spenta
+type ARPTarget struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Ip        string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	Namespace string                 `protobuf:"bytes,2,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	Name      string                 `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	// Richieste ARP necessarie entro window_seconds per svegliare la VM
+	Threshold     uint32 `protobuf:"varint,4,opt,name=threshold,proto3" json:"threshold,omitempty"`
+	WindowSeconds uint32 `protobuf:"varint,5,opt,name=window_seconds,json=windowSeconds,proto3" json:"window_seconds,omitempty"`
+	// MAC della VM con cui rispondere alle richieste ARP e alle neighbor
+	// solicitation per l'IP (anche IPv6), se respond
+	MacAddress string `protobuf:"bytes,6,opt,name=mac_address,json=macAddress,proto3" json:"mac_address,omitempty"`
+	Respond    bool   `protobuf:"varint,7,opt,name=respond,proto3" json:"respond,omitempty"`
+	// Trigger di wake della WolConfig (ping, SYN verso tcp_ports, query DNS
+	// per il nome della VM). Con triggers_only le richieste ARP non svegliano
+	// la VM (arpWake non abilitato)
+	TriggersOnly  bool     `protobuf:"varint,8,opt,name=triggers_only,json=triggersOnly,proto3" json:"triggers_only,omitempty"`
+	IcmpEcho      bool     `protobuf:"varint,9,opt,name=icmp_echo,json=icmpEcho,proto3" json:"icmp_echo,omitempty"`
+	TcpPorts      []uint32 `protobuf:"varint,10,rep,packed,name=tcp_ports,json=tcpPorts,proto3" json:"tcp_ports,omitempty"`
+	Dns           bool     `protobuf:"varint,11,opt,name=dns,proto3" json:"dns,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ARPTarget) Reset() {
+	*x = ARPTarget{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ARPTarget) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ARPTarget) ProtoMessage() {}
+
+func (x *ARPTarget) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ARPTarget.ProtoReflect.Descriptor instead.
+func (*ARPTarget) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ARPTarget) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *ARPTarget) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *ARPTarget) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ARPTarget) GetThreshold() uint32 {
+	if x != nil {
+		return x.Threshold
+	}
+	return 0
+}
+
+func (x *ARPTarget) GetWindowSeconds() uint32 {
+	if x != nil {
+		return x.WindowSeconds
+	}
+	return 0
+}
+
+func (x *ARPTarget) GetMacAddress() string {
+	if x != nil {
+		return x.MacAddress
+	}
+	return ""
+}
+
+func (x *ARPTarget) GetRespond() bool {
+	if x != nil {
+		return x.Respond
+	}
+	return false
+}
+
+func (x *ARPTarget) GetTriggersOnly() bool {
+	if x != nil {
+		return x.TriggersOnly
+	}
+	return false
+}
+
+func (x *ARPTarget) GetIcmpEcho() bool {
+	if x != nil {
+		return x.IcmpEcho
+	}
+	return false
+}
+
+func (x *ARPTarget) GetTcpPorts() []uint32 {
+	if x != nil {
+		return x.TcpPorts
+	}
+	return nil
+}
+
+func (x *ARPTarget) GetDns() bool {
+	if x != nil {
+		return x.Dns
+	}
+	return false
+}
+
+// ARPTargetsResponse contiene gli IP da osservare
+type ARPTargetsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Targets       []*ARPTarget           `protobuf:"bytes,1,rep,name=targets,proto3" json:"targets,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ARPTargetsResponse) Reset() {
+	*x = ARPTargetsResponse{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ARPTargetsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ARPTargetsResponse) ProtoMessage() {}
+
+func (x *ARPTargetsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ARPTargetsResponse.ProtoReflect.Descriptor instead.
+func (*ARPTargetsResponse) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ARPTargetsResponse) GetTargets() []*ARPTarget {
+	if x != nil {
+		return x.Targets
+	}
+	return nil
+}
+
+// InterfaceHintsRequest richiede le interfacce suggerite per un agent
+type InterfaceHintsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Nodo dell'agent
+	NodeName string `protobuf:"bytes,1,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
+	// WolConfig dell'agent (vuoto = tutte le WolConfig)
+	WolConfig     string `protobuf:"bytes,2,opt,name=wol_config,json=wolConfig,proto3" json:"wol_config,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InterfaceHintsRequest) Reset() {
+	*x = InterfaceHintsRequest{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InterfaceHintsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InterfaceHintsRequest) ProtoMessage() {}
+
+func (x *InterfaceHintsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InterfaceHintsRequest.ProtoReflect.Descriptor instead.
+func (*InterfaceHintsRequest) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *InterfaceHintsRequest) GetNodeName() string {
+	if x != nil {
+		return x.NodeName
+	}
+	return ""
+}
+
+func (x *InterfaceHintsRequest) GetWolConfig() string {
+	if x != nil {
+		return x.WolConfig
+	}
+	return ""
+}
+
+// NetworkAttachment descrive una NetworkAttachmentDefinition usata dalle VM
+type NetworkAttachment struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Namespace string                 `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	Name      string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	// Tipo di plugin CNI (bridge, ovs, macvlan, ...)
+	Type string `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+	// Interfaccia host su cui arrivano i pacchetti (bridge o master)
+	Interface string `protobuf:"bytes,4,opt,name=interface,proto3" json:"interface,omitempty"`
+	// VLAN configurata (0 = nessuna)
+	Vlan          uint32 `protobuf:"varint,5,opt,name=vlan,proto3" json:"vlan,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NetworkAttachment) Reset() {
+	*x = NetworkAttachment{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NetworkAttachment) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NetworkAttachment) ProtoMessage() {}
+
+func (x *NetworkAttachment) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NetworkAttachment.ProtoReflect.Descriptor instead.
+func (*NetworkAttachment) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *NetworkAttachment) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *NetworkAttachment) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *NetworkAttachment) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *NetworkAttachment) GetInterface() string {
+	if x != nil {
+		return x.Interface
+	}
+	return ""
+}
+
+func (x *NetworkAttachment) GetVlan() uint32 {
+	if x != nil {
+		return x.Vlan
+	}
+	return 0
+}
+
+// InterfaceHintsResponse contiene le interfacce da ascoltare
+type InterfaceHintsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Nomi delle interfacce host, senza duplicati
+	Interfaces  []string             `protobuf:"bytes,1,rep,name=interfaces,proto3" json:"interfaces,omitempty"`
+	Attachments []*NetworkAttachment `protobuf:"bytes,2,rep,name=attachments,proto3" json:"attachments,omitempty"`
+	// Regole sulle interfacce dalle annotazioni del nodo, che sostituiscono
+	// quelle dell'agent (assenti = nessun override)
+	NodeRules     *InterfaceRules `protobuf:"bytes,3,opt,name=node_rules,json=nodeRules,proto3" json:"node_rules,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InterfaceHintsResponse) Reset() {
+	*x = InterfaceHintsResponse{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InterfaceHintsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InterfaceHintsResponse) ProtoMessage() {}
+
+func (x *InterfaceHintsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InterfaceHintsResponse.ProtoReflect.Descriptor instead.
+func (*InterfaceHintsResponse) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *InterfaceHintsResponse) GetInterfaces() []string {
+	if x != nil {
+		return x.Interfaces
+	}
+	return nil
+}
+
+func (x *InterfaceHintsResponse) GetAttachments() []*NetworkAttachment {
+	if x != nil {
+		return x.Attachments
+	}
+	return nil
+}
+
+func (x *InterfaceHintsResponse) GetNodeRules() *InterfaceRules {
+	if x != nil {
+		return x.NodeRules
+	}
+	return nil
+}
+
+// InterfaceRules seleziona le interfacce dei raw listener per nome, con
+// pattern shell (es. "eth*")
+type InterfaceRules struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Se non vuoto, solo le interfacce che corrispondono
+	Include []string `protobuf:"bytes,1,rep,name=include,proto3" json:"include,omitempty"`
+	// Interfacce escluse
+	Exclude       []string `protobuf:"bytes,2,rep,name=exclude,proto3" json:"exclude,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InterfaceRules) Reset() {
+	*x = InterfaceRules{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InterfaceRules) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InterfaceRules) ProtoMessage() {}
+
+func (x *InterfaceRules) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InterfaceRules.ProtoReflect.Descriptor instead.
+func (*InterfaceRules) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *InterfaceRules) GetInclude() []string {
+	if x != nil {
+		return x.Include
+	}
+	return nil
+}
+
+func (x *InterfaceRules) GetExclude() []string {
+	if x != nil {
+		return x.Exclude
+	}
+	return nil
+}
+
+// ListenerReport elenca i listener di un agent
+type ListenerReport struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Nodo dell'agent
+	NodeName string `protobuf:"bytes,1,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
+	// WolConfig dell'agent
+	WolConfig     string             `protobuf:"bytes,2,opt,name=wol_config,json=wolConfig,proto3" json:"wol_config,omitempty"`
+	Bindings      []*ListenerBinding `protobuf:"bytes,3,rep,name=bindings,proto3" json:"bindings,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListenerReport) Reset() {
+	*x = ListenerReport{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListenerReport) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListenerReport) ProtoMessage() {}
+
+func (x *ListenerReport) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListenerReport.ProtoReflect.Descriptor instead.
+func (*ListenerReport) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ListenerReport) GetNodeName() string {
+	if x != nil {
+		return x.NodeName
+	}
+	return ""
+}
+
+func (x *ListenerReport) GetWolConfig() string {
+	if x != nil {
+		return x.WolConfig
+	}
+	return ""
+}
+
+func (x *ListenerReport) GetBindings() []*ListenerBinding {
+	if x != nil {
+		return x.Bindings
+	}
+	return nil
+}
+
+// ListenerBinding descrive un listener UDP o raw
+type ListenerBinding struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// "udp" o "raw"
+	Protocol string `protobuf:"bytes,1,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	// Porta UDP (solo per "udp")
+	Port uint32 `protobuf:"varint,2,opt,name=port,proto3" json:"port,omitempty"`
+	// Interfaccia (solo per "raw")
+	Interface string `protobuf:"bytes,3,opt,name=interface,proto3" json:"interface,omitempty"`
+	// True se il listener è attivo
+	Bound bool `protobuf:"varint,4,opt,name=bound,proto3" json:"bound,omitempty"`
+	// Errore dell'ultimo tentativo di apertura, se non attivo
+	Error         string `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListenerBinding) Reset() {
+	*x = ListenerBinding{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListenerBinding) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListenerBinding) ProtoMessage() {}
+
+func (x *ListenerBinding) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListenerBinding.ProtoReflect.Descriptor instead.
+func (*ListenerBinding) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ListenerBinding) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *ListenerBinding) GetPort() uint32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *ListenerBinding) GetInterface() string {
+	if x != nil {
+		return x.Interface
+	}
+	return ""
+}
+
+func (x *ListenerBinding) GetBound() bool {
+	if x != nil {
+		return x.Bound
+	}
+	return false
+}
+
+func (x *ListenerBinding) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// ListenerReportResponse conferma la ricezione del report
+type ListenerReportResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Accepted      bool                   `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListenerReportResponse) Reset() {
+	*x = ListenerReportResponse{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListenerReportResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListenerReportResponse) ProtoMessage() {}
+
+func (x *ListenerReportResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListenerReportResponse.ProtoReflect.Descriptor instead.
+func (*ListenerReportResponse) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *ListenerReportResponse) GetAccepted() bool {
+	if x != nil {
+		return x.Accepted
+	}
+	return false
+}
+
+// AgentHeartbeatRequest è lo stato periodico di un agent
+type AgentHeartbeatRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Nodo dell'agent
+	NodeName string `protobuf:"bytes,1,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
+	// WolConfig dell'agent
+	WolConfig string `protobuf:"bytes,2,opt,name=wol_config,json=wolConfig,proto3" json:"wol_config,omitempty"`
+	// Versione dell'agent
+	Version string `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`
+	// Interfacce con un raw listener attivo
+	Interfaces []string `protobuf:"bytes,4,rep,name=interfaces,proto3" json:"interfaces,omitempty"`
+	// Magic packet validi ricevuti dall'avvio dell'agent
+	PacketsSeen uint64 `protobuf:"varint,5,opt,name=packets_seen,json=packetsSeen,proto3" json:"packets_seen,omitempty"`
+	// Avvio dell'agent
+	StartedAt *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	// Eventi che l'agent non è riuscito a riportare all'operatore dall'avvio
+	ReportFailures uint64 `protobuf:"varint,7,opt,name=report_failures,json=reportFailures,proto3" json:"report_failures,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *AgentHeartbeatRequest) Reset() {
+	*x = AgentHeartbeatRequest{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentHeartbeatRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentHeartbeatRequest) ProtoMessage() {}
+
+func (x *AgentHeartbeatRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentHeartbeatRequest.ProtoReflect.Descriptor instead.
+func (*AgentHeartbeatRequest) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *AgentHeartbeatRequest) GetNodeName() string {
+	if x != nil {
+		return x.NodeName
+	}
+	return ""
+}
+
+func (x *AgentHeartbeatRequest) GetWolConfig() string {
+	if x != nil {
+		return x.WolConfig
+	}
+	return ""
+}
+
+func (x *AgentHeartbeatRequest) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *AgentHeartbeatRequest) GetInterfaces() []string {
+	if x != nil {
+		return x.Interfaces
+	}
+	return nil
+}
+
+func (x *AgentHeartbeatRequest) GetPacketsSeen() uint64 {
+	if x != nil {
+		return x.PacketsSeen
+	}
+	return 0
+}
+
+func (x *AgentHeartbeatRequest) GetStartedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartedAt
+	}
+	return nil
+}
+
+func (x *AgentHeartbeatRequest) GetReportFailures() uint64 {
+	if x != nil {
+		return x.ReportFailures
+	}
+	return 0
+}
+
+// AgentHeartbeatResponse conferma la ricezione dell'heartbeat
+type AgentHeartbeatResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Accepted      bool                   `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentHeartbeatResponse) Reset() {
+	*x = AgentHeartbeatResponse{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentHeartbeatResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentHeartbeatResponse) ProtoMessage() {}
+
+func (x *AgentHeartbeatResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentHeartbeatResponse.ProtoReflect.Descriptor instead.
+func (*AgentHeartbeatResponse) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *AgentHeartbeatResponse) GetAccepted() bool {
+	if x != nil {
+		return x.Accepted
+	}
+	return false
+}
+
+// AgentConfigRequest richiede la configurazione di un agent
+type AgentConfigRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Nodo dell'agent
+	NodeName string `protobuf:"bytes,1,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
+	// WolConfig dell'agent
+	WolConfig     string `protobuf:"bytes,2,opt,name=wol_config,json=wolConfig,proto3" json:"wol_config,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentConfigRequest) Reset() {
+	*x = AgentConfigRequest{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentConfigRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentConfigRequest) ProtoMessage() {}
+
+func (x *AgentConfigRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentConfigRequest.ProtoReflect.Descriptor instead.
+func (*AgentConfigRequest) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *AgentConfigRequest) GetNodeName() string {
+	if x != nil {
+		return x.NodeName
+	}
+	return ""
+}
+
+func (x *AgentConfigRequest) GetWolConfig() string {
+	if x != nil {
+		return x.WolConfig
+	}
+	return ""
+}
+
+// AgentConfigResponse contiene le impostazioni applicate a caldo dall'agent
+type AgentConfigResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// false se l'operatore non conosce la WolConfig: l'agent non cambia nulla
+	Found bool `protobuf:"varint,1,opt,name=found,proto3" json:"found,omitempty"`
+	// Porte UDP dei magic packet (wolPorts)
+	Ports []int32 `protobuf:"varint,2,rep,packed,name=ports,proto3" json:"ports,omitempty"`
+	// Finestra di dedupe dell'agent in secondi (0 = default dell'agent)
+	DedupeWindowSeconds int32 `protobuf:"varint,3,opt,name=dedupe_window_seconds,json=dedupeWindowSeconds,proto3" json:"dedupe_window_seconds,omitempty"`
+	// Regole sulle interfacce dei raw listener (agent.interfaceSelector)
+	Interfaces    *InterfaceRules `protobuf:"bytes,4,opt,name=interfaces,proto3" json:"interfaces,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentConfigResponse) Reset() {
+	*x = AgentConfigResponse{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentConfigResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentConfigResponse) ProtoMessage() {}
+
+func (x *AgentConfigResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentConfigResponse.ProtoReflect.Descriptor instead.
+func (*AgentConfigResponse) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *AgentConfigResponse) GetFound() bool {
+	if x != nil {
+		return x.Found
+	}
+	return false
+}
+
+func (x *AgentConfigResponse) GetPorts() []int32 {
+	if x != nil {
+		return x.Ports
+	}
+	return nil
+}
+
+func (x *AgentConfigResponse) GetDedupeWindowSeconds() int32 {
+	if x != nil {
+		return x.DedupeWindowSeconds
+	}
+	return 0
+}
+
+func (x *AgentConfigResponse) GetInterfaces() *InterfaceRules {
+	if x != nil {
+		return x.Interfaces
+	}
+	return nil
+}
+
+// MACAllowlistRequest apre lo stream dei MAC gestiti
+type MACAllowlistRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Nodo dell'agent
+	NodeName string `protobuf:"bytes,1,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
+	// WolConfig dell'agent
+	WolConfig     string `protobuf:"bytes,2,opt,name=wol_config,json=wolConfig,proto3" json:"wol_config,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MACAllowlistRequest) Reset() {
+	*x = MACAllowlistRequest{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MACAllowlistRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MACAllowlistRequest) ProtoMessage() {}
+
+func (x *MACAllowlistRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MACAllowlistRequest.ProtoReflect.Descriptor instead.
+func (*MACAllowlistRequest) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *MACAllowlistRequest) GetNodeName() string {
+	if x != nil {
+		return x.NodeName
+	}
+	return ""
+}
+
+func (x *MACAllowlistRequest) GetWolConfig() string {
+	if x != nil {
+		return x.WolConfig
+	}
+	return ""
+}
+
+// MACAllowlist è l'insieme completo dei MAC gestiti, in minuscolo e ordinati
+type MACAllowlist struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MacAddresses  []string               `protobuf:"bytes,1,rep,name=mac_addresses,json=macAddresses,proto3" json:"mac_addresses,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MACAllowlist) Reset() {
+	*x = MACAllowlist{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MACAllowlist) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MACAllowlist) ProtoMessage() {}
+
+func (x *MACAllowlist) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MACAllowlist.ProtoReflect.Descriptor instead.
+func (*MACAllowlist) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *MACAllowlist) GetMacAddresses() []string {
+	if x != nil {
+		return x.MacAddresses
+	}
+	return nil
+}
+
+// VersionRequest chiede la versione del manager
+type VersionRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Nodo e versione dell'agent (vuoti per gli altri client, es. grpcurl)
+	NodeName      string `protobuf:"bytes,1,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
+	AgentVersion  string `protobuf:"bytes,2,opt,name=agent_version,json=agentVersion,proto3" json:"agent_version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VersionRequest) Reset() {
+	*x = VersionRequest{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VersionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VersionRequest) ProtoMessage() {}
+
+func (x *VersionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VersionRequest.ProtoReflect.Descriptor instead.
+func (*VersionRequest) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *VersionRequest) GetNodeName() string {
+	if x != nil {
+		return x.NodeName
+	}
+	return ""
+}
+
+func (x *VersionRequest) GetAgentVersion() string {
+	if x != nil {
+		return x.AgentVersion
+	}
+	return ""
+}
+
+// VersionResponse descrive la build del manager
+type VersionResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Versione del manager (es. v0.2.0)
+	Version string `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	// Commit da cui è compilato il manager (vuoto se non noto)
+	GitCommit string `protobuf:"bytes,2,opt,name=git_commit,json=gitCommit,proto3" json:"git_commit,omitempty"`
+	// Versione di Go usata per la build
+	GoVersion string `protobuf:"bytes,3,opt,name=go_version,json=goVersion,proto3" json:"go_version,omitempty"`
+	// Funzionalità supportate (es. "mac-allowlist", "agent-config")
+	Features      []string `protobuf:"bytes,4,rep,name=features,proto3" json:"features,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VersionResponse) Reset() {
+	*x = VersionResponse{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VersionResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VersionResponse) ProtoMessage() {}
+
+func (x *VersionResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VersionResponse.ProtoReflect.Descriptor instead.
+func (*VersionResponse) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *VersionResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *VersionResponse) GetGitCommit() string {
+	if x != nil {
+		return x.GitCommit
+	}
+	return ""
+}
+
+func (x *VersionResponse) GetGoVersion() string {
+	if x != nil {
+		return x.GoVersion
+	}
+	return ""
+}
+
+func (x *VersionResponse) GetFeatures() []string {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+// ForwardsRequest apre lo stream dei pacchetti da riemettere
+type ForwardsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Nodo dell'agent
+	NodeName string `protobuf:"bytes,1,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
+	// WolConfig dell'agent
+	WolConfig     string `protobuf:"bytes,2,opt,name=wol_config,json=wolConfig,proto3" json:"wol_config,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ForwardsRequest) Reset() {
+	*x = ForwardsRequest{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ForwardsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForwardsRequest) ProtoMessage() {}
+
+func (x *ForwardsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ForwardsRequest.ProtoReflect.Descriptor instead.
+func (*ForwardsRequest) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *ForwardsRequest) GetNodeName() string {
+	if x != nil {
+		return x.NodeName
+	}
+	return ""
+}
+
+func (x *ForwardsRequest) GetWolConfig() string {
+	if x != nil {
+		return x.WolConfig
+	}
+	return ""
+}
+
+// ForwardRequest chiede all'agent di riemettere un magic packet
+type ForwardRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// MAC address della macchina da svegliare
+	MacAddress string `protobuf:"bytes,1,opt,name=mac_address,json=macAddress,proto3" json:"mac_address,omitempty"`
+	// Password SecureOn del pacchetto originale (vuota = nessuna)
+	SecureOnPassword string `protobuf:"bytes,2,opt,name=secure_on_password,json=secureOnPassword,proto3" json:"secure_on_password,omitempty"`
+	// IP di destinazione del pacchetto UDP (vuoto = frame Ethernet raw su interface)
+	Address string `protobuf:"bytes,3,opt,name=address,proto3" json:"address,omitempty"`
+	// Porta UDP di destinazione
+	Port uint32 `protobuf:"varint,4,opt,name=port,proto3" json:"port,omitempty"`
+	// Interfaccia del nodo da cui inviare il pacchetto (vuota = scelta dalla tabella di routing)
+	Interface     string `protobuf:"bytes,5,opt,name=interface,proto3" json:"interface,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ForwardRequest) Reset() {
+	*x = ForwardRequest{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ForwardRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForwardRequest) ProtoMessage() {}
+
+func (x *ForwardRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ForwardRequest.ProtoReflect.Descriptor instead.
+func (*ForwardRequest) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *ForwardRequest) GetMacAddress() string {
+	if x != nil {
+		return x.MacAddress
+	}
+	return ""
+}
+
+func (x *ForwardRequest) GetSecureOnPassword() string {
+	if x != nil {
+		return x.SecureOnPassword
+	}
+	return ""
+}
+
+func (x *ForwardRequest) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *ForwardRequest) GetPort() uint32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *ForwardRequest) GetInterface() string {
+	if x != nil {
+		return x.Interface
+	}
+	return ""
+}
+
+// SendWOLRequest chiede l'invio di un magic packet a una macchina esterna
+type SendWOLRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// MAC address della macchina da svegliare
+	MacAddress string `protobuf:"bytes,1,opt,name=mac_address,json=macAddress,proto3" json:"mac_address,omitempty"`
+	// Password SecureOn da aggiungere al pacchetto (vuota = nessuna)
+	SecureOnPassword string `protobuf:"bytes,2,opt,name=secure_on_password,json=secureOnPassword,proto3" json:"secure_on_password,omitempty"`
+	// IP di destinazione del pacchetto UDP, anche broadcast (vuoto = frame
+	// Ethernet raw su interface)
+	Address string `protobuf:"bytes,3,opt,name=address,proto3" json:"address,omitempty"`
+	// Porta UDP di destinazione (0 = 9)
+	Port uint32 `protobuf:"varint,4,opt,name=port,proto3" json:"port,omitempty"`
+	// Interfaccia da cui inviare il pacchetto (vuota = scelta dalla tabella di routing)
+	Interface string `protobuf:"bytes,5,opt,name=interface,proto3" json:"interface,omitempty"`
+	// Nodo da cui inviare il pacchetto, tramite il suo agent (vuoto = dal manager)
+	NodeName string `protobuf:"bytes,6,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
+	// WolConfig dell'agent che invia il pacchetto (richiesta con node_name)
+	WolConfig string `protobuf:"bytes,7,opt,name=wol_config,json=wolConfig,proto3" json:"wol_config,omitempty"`
+	// Chi chiede l'invio (es. "api", "wolctl"), per i log
+	Source        string `protobuf:"bytes,8,opt,name=source,proto3" json:"source,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendWOLRequest) Reset() {
+	*x = SendWOLRequest{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendWOLRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendWOLRequest) ProtoMessage() {}
+
+func (x *SendWOLRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendWOLRequest.ProtoReflect.Descriptor instead.
+func (*SendWOLRequest) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *SendWOLRequest) GetMacAddress() string {
+	if x != nil {
+		return x.MacAddress
+	}
+	return ""
+}
+
+func (x *SendWOLRequest) GetSecureOnPassword() string {
+	if x != nil {
+		return x.SecureOnPassword
+	}
+	return ""
+}
+
+func (x *SendWOLRequest) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *SendWOLRequest) GetPort() uint32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *SendWOLRequest) GetInterface() string {
+	if x != nil {
+		return x.Interface
+	}
+	return ""
+}
+
+func (x *SendWOLRequest) GetNodeName() string {
+	if x != nil {
+		return x.NodeName
+	}
+	return ""
+}
+
+func (x *SendWOLRequest) GetWolConfig() string {
+	if x != nil {
+		return x.WolConfig
+	}
+	return ""
+}
+
+func (x *SendWOLRequest) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+// SendWOLResponse descrive il magic packet inviato
+type SendWOLResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Chi ha inviato il pacchetto: "manager" o "agent"
+	Sender string `protobuf:"bytes,1,opt,name=sender,proto3" json:"sender,omitempty"`
+	// Destinazione del pacchetto (es. 192.168.1.255:9 via eth1)
+	Destination string `protobuf:"bytes,2,opt,name=destination,proto3" json:"destination,omitempty"`
+	// Messaggio leggibile
+	Message       string `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendWOLResponse) Reset() {
+	*x = SendWOLResponse{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendWOLResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendWOLResponse) ProtoMessage() {}
+
+func (x *SendWOLResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendWOLResponse.ProtoReflect.Descriptor instead.
+func (*SendWOLResponse) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *SendWOLResponse) GetSender() string {
+	if x != nil {
+		return x.Sender
+	}
+	return ""
+}
+
+func (x *SendWOLResponse) GetDestination() string {
+	if x != nil {
+		return x.Destination
+	}
+	return ""
+}
+
+func (x *SendWOLResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+// SimulateWakeRequest descrive il magic packet sintetico da simulare
+type SimulateWakeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// MAC address del magic packet
+	MacAddress string `protobuf:"bytes,1,opt,name=mac_address,json=macAddress,proto3" json:"mac_address,omitempty"`
+	// Password SecureOn del pacchetto (vuota = nessuna)
+	SecureOnPassword string `protobuf:"bytes,2,opt,name=secure_on_password,json=secureOnPassword,proto3" json:"secure_on_password,omitempty"`
+	// Nodo che avrebbe ricevuto il pacchetto, per il rate limit per nodo
+	// (vuoto = nessuno)
+	NodeName string `protobuf:"bytes,3,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
+	// Chi chiede la simulazione (es. "api", "wolctl"), per i log
+	Source        string `protobuf:"bytes,4,opt,name=source,proto3" json:"source,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SimulateWakeRequest) Reset() {
+	*x = SimulateWakeRequest{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SimulateWakeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SimulateWakeRequest) ProtoMessage() {}
+
+func (x *SimulateWakeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SimulateWakeRequest.ProtoReflect.Descriptor instead.
+func (*SimulateWakeRequest) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *SimulateWakeRequest) GetMacAddress() string {
+	if x != nil {
+		return x.MacAddress
+	}
+	return ""
+}
+
+func (x *SimulateWakeRequest) GetSecureOnPassword() string {
+	if x != nil {
+		return x.SecureOnPassword
+	}
+	return ""
+}
+
+func (x *SimulateWakeRequest) GetNodeName() string {
+	if x != nil {
+		return x.NodeName
+	}
+	return ""
+}
+
+func (x *SimulateWakeRequest) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+// SimulateWakeResponse è l'esito che avrebbe avuto il magic packet
+type SimulateWakeResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Status che ReportWOLEvent avrebbe restituito
+	Status ResponseStatus `protobuf:"varint,1,opt,name=status,proto3,enum=wol.v1.ResponseStatus" json:"status,omitempty"`
+	// Messaggio leggibile
+	Message string `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	// VM trovata per il MAC, con lo stato letto da KubeVirt
+	VmInfo *VMInfo `protobuf:"bytes,3,opt,name=vm_info,json=vmInfo,proto3" json:"vm_info,omitempty"`
+	// WolConfig e tipo della mapping che hanno trovato la VM
+	WolConfig   string `protobuf:"bytes,4,opt,name=wol_config,json=wolConfig,proto3" json:"wol_config,omitempty"`
+	MappingType string `protobuf:"bytes,5,opt,name=mapping_type,json=mappingType,proto3" json:"mapping_type,omitempty"`
+	// Azione della WolPolicy (Start, Resume, RestartIfCrashed) o di
+	// Shutdown-on-LAN (Stop, Pause) che sarebbe applicata
+	Action string `protobuf:"bytes,6,opt,name=action,proto3" json:"action,omitempty"`
+	// Le decisioni prese, in ordine, dal lookup del MAC all'esito
+	Steps         []string `protobuf:"bytes,7,rep,name=steps,proto3" json:"steps,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SimulateWakeResponse) Reset() {
+	*x = SimulateWakeResponse{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SimulateWakeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SimulateWakeResponse) ProtoMessage() {}
+
+func (x *SimulateWakeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SimulateWakeResponse.ProtoReflect.Descriptor instead.
+func (*SimulateWakeResponse) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *SimulateWakeResponse) GetStatus() ResponseStatus {
+	if x != nil {
+		return x.Status
+	}
+	return ResponseStatus_UNKNOWN
+}
+
+func (x *SimulateWakeResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *SimulateWakeResponse) GetVmInfo() *VMInfo {
+	if x != nil {
+		return x.VmInfo
+	}
+	return nil
+}
+
+func (x *SimulateWakeResponse) GetWolConfig() string {
+	if x != nil {
+		return x.WolConfig
+	}
+	return ""
+}
+
+func (x *SimulateWakeResponse) GetMappingType() string {
+	if x != nil {
+		return x.MappingType
+	}
+	return ""
+}
+
+func (x *SimulateWakeResponse) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *SimulateWakeResponse) GetSteps() []string {
+	if x != nil {
+		return x.Steps
+	}
+	return nil
+}
+
+// VMInfo contiene informazioni sulla VM target
+type VMInfo struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Name      string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Namespace string                 `protobuf:"bytes,2,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	// Stato della VM letto da KubeVirt (Running, Starting, Stopped, Paused, ...)
+	// o quello atteso dopo l'azione richiesta (Starting, Stopping, Pausing)
+	CurrentState string `protobuf:"bytes,3,opt,name=current_state,json=currentState,proto3" json:"current_state,omitempty"`
+	// Nodo su cui gira l'istanza della VM (vuoto se non ha un'istanza)
+	NodeName      string `protobuf:"bytes,4,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VMInfo) Reset() {
+	*x = VMInfo{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VMInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VMInfo) ProtoMessage() {}
+
+func (x *VMInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VMInfo.ProtoReflect.Descriptor instead.
+func (*VMInfo) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *VMInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *VMInfo) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *VMInfo) GetCurrentState() string {
+	if x != nil {
+		return x.CurrentState
+	}
+	return ""
+}
+
+func (x *VMInfo) GetNodeName() string {
+	if x != nil {
+		return x.NodeName
+	}
+	return ""
+}
+
+// HealthCheckRequest per verificare stato server
+type HealthCheckRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Service       string                 `protobuf:"bytes,1,opt,name=service,proto3" json:"service,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HealthCheckRequest) Reset() {
+	*x = HealthCheckRequest{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HealthCheckRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HealthCheckRequest) ProtoMessage() {}
+
+func (x *HealthCheckRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HealthCheckRequest.ProtoReflect.Descriptor instead.
+func (*HealthCheckRequest) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *HealthCheckRequest) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+// HealthCheckResponse risposta health check
+type HealthCheckResponse struct {
+	state  protoimpl.MessageState            `protogen:"open.v1"`
+	Status HealthCheckResponse_ServingStatus `protobuf:"varint,1,opt,name=status,proto3,enum=wol.v1.HealthCheckResponse_ServingStatus" json:"status,omitempty"`
+	// Versione del manager, vuota se più vecchio
+	Version       string `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HealthCheckResponse) Reset() {
+	*x = HealthCheckResponse{}
+	mi := &file_api_wol_v1_wol_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HealthCheckResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HealthCheckResponse) ProtoMessage() {}
+
+func (x *HealthCheckResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_wol_v1_wol_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HealthCheckResponse.ProtoReflect.Descriptor instead.
+func (*HealthCheckResponse) Descriptor() ([]byte, []int) {
+	return file_api_wol_v1_wol_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *HealthCheckResponse) GetStatus() HealthCheckResponse_ServingStatus {
+	if x != nil {
+		return x.Status
+	}
+	return HealthCheckResponse_UNKNOWN
+}
+
+func (x *HealthCheckResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+var File_api_wol_v1_wol_proto protoreflect.FileDescriptor
+
+const file_api_wol_v1_wol_proto_rawDesc = "" +
+	"\n" +
+	"\x14api/wol/v1/wol.proto\x12\x06wol.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xb7\x03\n" +
+	"\bWOLEvent\x12\x1f\n" +
+	"\vmac_address\x18\x01 \x01(\tR\n" +
+	"macAddress\x128\n" +
+	"\ttimestamp\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12\x1b\n" +
+	"\tnode_name\x18\x03 \x01(\tR\bnodeName\x12\x1b\n" +
+	"\tsource_ip\x18\x04 \x01(\tR\bsourceIp\x12\x1f\n" +
+	"\vsource_port\x18\x05 \x01(\rR\n" +
+	"sourcePort\x12\x1f\n" +
+	"\vpacket_size\x18\x06 \x01(\rR\n" +
+	"packetSize\x12)\n" +
+	"\x10destination_port\x18\a \x01(\rR\x0fdestinationPort\x12,\n" +
+	"\x12secure_on_password\x18\b \x01(\tR\x10secureOnPassword\x12$\n" +
+	"\x0eagent_delay_us\x18\t \x01(\x04R\fagentDelayUs\x12\x14\n" +
+	"\x05sleep\x18\n" +
+	" \x01(\bR\x05sleep\x12\x1a\n" +
+	"\bsequence\x18\v \x01(\x04R\bsequence\x12#\n" +
+	"\ragent_version\x18\f \x01(\tR\fagentVersion\"\xb0\x02\n" +
+	"\x10WOLEventResponse\x12.\n" +
+	"\x06status\x18\x01 \x01(\x0e2\x16.wol.v1.ResponseStatusR\x06status\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12'\n" +
+	"\avm_info\x18\x03 \x01(\v2\x0e.wol.v1.VMInfoR\x06vmInfo\x12#\n" +
+	"\rwas_duplicate\x18\x04 \x01(\bR\fwasDuplicate\x12,\n" +
+	"\x12processing_time_ms\x18\x05 \x01(\x03R\x10processingTimeMs\x12\x1a\n" +
+	"\bsequence\x18\x06 \x01(\x04R\bsequence\x12:\n" +
+	"\fdependencies\x18\a \x03(\v2\x16.wol.v1.WakeDependencyR\fdependencies\"\x8c\x01\n" +
+	"\x0eWakeDependency\x12\x1c\n" +
+	"\tnamespace\x18\x01 \x01(\tR\tnamespace\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12.\n" +
+	"\x06status\x18\x03 \x01(\x0e2\x16.wol.v1.ResponseStatusR\x06status\x12\x18\n" +
+	"\amessage\x18\x04 \x01(\tR\amessage\"o\n" +
+	"\vWakeRequest\x12\x1c\n" +
+	"\tnamespace\x18\x01 \x01(\tR\tnamespace\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x16\n" +
+	"\x06source\x18\x03 \x01(\tR\x06source\x12\x16\n" +
+	"\x06reason\x18\x04 \x01(\tR\x06reason\"O\n" +
+	"\x11ARPTargetsRequest\x12\x1b\n" +
+	"\tnode_name\x18\x01 \x01(\tR\bnodeName\x12\x1d\n" +
+	"\n" +
+	"wol_config\x18\x02 \x01(\tR\twolConfig\"\xbe\x02\n" +
+	"\tARPTarget\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\x12\x1c\n" +
+	"\tnamespace\x18\x02 \x01(\tR\tnamespace\x12\x12\n" +
+	"\x04name\x18\x03 \x01(\tR\x04name\x12\x1c\n" +
+	"\tthreshold\x18\x04 \x01(\rR\tthreshold\x12%\n" +
+	"\x0ewindow_seconds\x18\x05 \x01(\rR\rwindowSeconds\x12\x1f\n" +
+	"\vmac_address\x18\x06 \x01(\tR\n" +
+	"macAddress\x12\x18\n" +
+	"\arespond\x18\a \x01(\bR\arespond\x12#\n" +
+	"\rtriggers_only\x18\b \x01(\bR\ftriggersOnly\x12\x1b\n" +
+	"\ticmp_echo\x18\t \x01(\bR\bicmpEcho\x12\x1b\n" +
+	"\ttcp_ports\x18\n" +
+	" \x03(\rR\btcpPorts\x12\x10\n" +
+	"\x03dns\x18\v \x01(\bR\x03dns\"A\n" +
+	"\x12ARPTargetsResponse\x12+\n" +
+	"\atargets\x18\x01 \x03(\v2\x11.wol.v1.ARPTargetR\atargets\"S\n" +
+	"\x15InterfaceHintsRequest\x12\x1b\n" +
+	"\tnode_name\x18\x01 \x01(\tR\bnodeName\x12\x1d\n" +
+	"\n" +
+	"wol_config\x18\x02 \x01(\tR\twolConfig\"\x8b\x01\n" +
+	"\x11NetworkAttachment\x12\x1c\n" +
+	"\tnamespace\x18\x01 \x01(\tR\tnamespace\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x12\n" +
+	"\x04type\x18\x03 \x01(\tR\x04type\x12\x1c\n" +
+	"\tinterface\x18\x04 \x01(\tR\tinterface\x12\x12\n" +
+	"\x04vlan\x18\x05 \x01(\rR\x04vlan\"\xac\x01\n" +
+	"\x16InterfaceHintsResponse\x12\x1e\n" +
+	"\n" +
+	"interfaces\x18\x01 \x03(\tR\n" +
+	"interfaces\x12;\n" +
+	"\vattachments\x18\x02 \x03(\v2\x19.wol.v1.NetworkAttachmentR\vattachments\x125\n" +
+	"\n" +
+	"node_rules\x18\x03 \x01(\v2\x16.wol.v1.InterfaceRulesR\tnodeRules\"D\n" +
+	"\x0eInterfaceRules\x12\x18\n" +
+	"\ainclude\x18\x01 \x03(\tR\ainclude\x12\x18\n" +
+	"\aexclude\x18\x02 \x03(\tR\aexclude\"\x81\x01\n" +
+	"\x0eListenerReport\x12\x1b\n" +
+	"\tnode_name\x18\x01 \x01(\tR\bnodeName\x12\x1d\n" +
+	"\n" +
+	"wol_config\x18\x02 \x01(\tR\twolConfig\x123\n" +
+	"\bbindings\x18\x03 \x03(\v2\x17.wol.v1.ListenerBindingR\bbindings\"\x8b\x01\n" +
+	"\x0fListenerBinding\x12\x1a\n" +
+	"\bprotocol\x18\x01 \x01(\tR\bprotocol\x12\x12\n" +
+	"\x04port\x18\x02 \x01(\rR\x04port\x12\x1c\n" +
+	"\tinterface\x18\x03 \x01(\tR\tinterface\x12\x14\n" +
+	"\x05bound\x18\x04 \x01(\bR\x05bound\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\"4\n" +
+	"\x16ListenerReportResponse\x12\x1a\n" +
+	"\baccepted\x18\x01 \x01(\bR\baccepted\"\x94\x02\n" +
+	"\x15AgentHeartbeatRequest\x12\x1b\n" +
+	"\tnode_name\x18\x01 \x01(\tR\bnodeName\x12\x1d\n" +
+	"\n" +
+	"wol_config\x18\x02 \x01(\tR\twolConfig\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\tR\aversion\x12\x1e\n" +
+	"\n" +
+	"interfaces\x18\x04 \x03(\tR\n" +
+	"interfaces\x12!\n" +
+	"\fpackets_seen\x18\x05 \x01(\x04R\vpacketsSeen\x129\n" +
+	"\n" +
+	"started_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tstartedAt\x12'\n" +
+	"\x0freport_failures\x18\a \x01(\x04R\x0ereportFailures\"4\n" +
+	"\x16AgentHeartbeatResponse\x12\x1a\n" +
+	"\baccepted\x18\x01 \x01(\bR\baccepted\"P\n" +
+	"\x12AgentConfigRequest\x12\x1b\n" +
+	"\tnode_name\x18\x01 \x01(\tR\bnodeName\x12\x1d\n" +
+	"\n" +
+	"wol_config\x18\x02 \x01(\tR\twolConfig\"\xad\x01\n" +
+	"\x13AgentConfigResponse\x12\x14\n" +
+	"\x05found\x18\x01 \x01(\bR\x05found\x12\x14\n" +
+	"\x05ports\x18\x02 \x03(\x05R\x05ports\x122\n" +
+	"\x15dedupe_window_seconds\x18\x03 \x01(\x05R\x13dedupeWindowSeconds\x126\n" +
+	"\n" +
+	"interfaces\x18\x04 \x01(\v2\x16.wol.v1.InterfaceRulesR\n" +
+	"interfaces\"Q\n" +
+	"\x13MACAllowlistRequest\x12\x1b\n" +
+	"\tnode_name\x18\x01 \x01(\tR\bnodeName\x12\x1d\n" +
+	"\n" +
+	"wol_config\x18\x02 \x01(\tR\twolConfig\"3\n" +
+	"\fMACAllowlist\x12#\n" +
+	"\rmac_addresses\x18\x01 \x03(\tR\fmacAddresses\"R\n" +
+	"\x0eVersionRequest\x12\x1b\n" +
+	"\tnode_name\x18\x01 \x01(\tR\bnodeName\x12#\n" +
+	"\ragent_version\x18\x02 \x01(\tR\fagentVersion\"\x85\x01\n" +
+	"\x0fVersionResponse\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12\x1d\n" +
+	"\n" +
+	"git_commit\x18\x02 \x01(\tR\tgitCommit\x12\x1d\n" +
+	"\n" +
+	"go_version\x18\x03 \x01(\tR\tgoVersion\x12\x1a\n" +
+	"\bfeatures\x18\x04 \x03(\tR\bfeatures\"M\n" +
+	"\x0fForwardsRequest\x12\x1b\n" +
+	"\tnode_name\x18\x01 \x01(\tR\bnodeName\x12\x1d\n" +
+	"\n" +
+	"wol_config\x18\x02 \x01(\tR\twolConfig\"\xab\x01\n" +
+	"\x0eForwardRequest\x12\x1f\n" +
+	"\vmac_address\x18\x01 \x01(\tR\n" +
+	"macAddress\x12,\n" +
+	"\x12secure_on_password\x18\x02 \x01(\tR\x10secureOnPassword\x12\x18\n" +
+	"\aaddress\x18\x03 \x01(\tR\aaddress\x12\x12\n" +
+	"\x04port\x18\x04 \x01(\rR\x04port\x12\x1c\n" +
+	"\tinterface\x18\x05 \x01(\tR\tinterface\"\xff\x01\n" +
+	"\x0eSendWOLRequest\x12\x1f\n" +
+	"\vmac_address\x18\x01 \x01(\tR\n" +
+	"macAddress\x12,\n" +
+	"\x12secure_on_password\x18\x02 \x01(\tR\x10secureOnPassword\x12\x18\n" +
+	"\aaddress\x18\x03 \x01(\tR\aaddress\x12\x12\n" +
+	"\x04port\x18\x04 \x01(\rR\x04port\x12\x1c\n" +
+	"\tinterface\x18\x05 \x01(\tR\tinterface\x12\x1b\n" +
+	"\tnode_name\x18\x06 \x01(\tR\bnodeName\x12\x1d\n" +
+	"\n" +
+	"wol_config\x18\a \x01(\tR\twolConfig\x12\x16\n" +
+	"\x06source\x18\b \x01(\tR\x06source\"e\n" +
+	"\x0fSendWOLResponse\x12\x16\n" +
+	"\x06sender\x18\x01 \x01(\tR\x06sender\x12 \n" +
+	"\vdestination\x18\x02 \x01(\tR\vdestination\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\"\x99\x01\n" +
+	"\x13SimulateWakeRequest\x12\x1f\n" +
+	"\vmac_address\x18\x01 \x01(\tR\n" +
+	"macAddress\x12,\n" +
+	"\x12secure_on_password\x18\x02 \x01(\tR\x10secureOnPassword\x12\x1b\n" +
+	"\tnode_name\x18\x03 \x01(\tR\bnodeName\x12\x16\n" +
+	"\x06source\x18\x04 \x01(\tR\x06source\"\xf9\x01\n" +
+	"\x14SimulateWakeResponse\x12.\n" +
+	"\x06status\x18\x01 \x01(\x0e2\x16.wol.v1.ResponseStatusR\x06status\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12'\n" +
+	"\avm_info\x18\x03 \x01(\v2\x0e.wol.v1.VMInfoR\x06vmInfo\x12\x1d\n" +
+	"\n" +
+	"wol_config\x18\x04 \x01(\tR\twolConfig\x12!\n" +
+	"\fmapping_type\x18\x05 \x01(\tR\vmappingType\x12\x16\n" +
+	"\x06action\x18\x06 \x01(\tR\x06action\x12\x14\n" +
+	"\x05steps\x18\a \x03(\tR\x05steps\"|\n" +
+	"\x06VMInfo\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1c\n" +
+	"\tnamespace\x18\x02 \x01(\tR\tnamespace\x12#\n" +
+	"\rcurrent_state\x18\x03 \x01(\tR\fcurrentState\x12\x1b\n" +
+	"\tnode_name\x18\x04 \x01(\tR\bnodeName\".\n" +
+	"\x12HealthCheckRequest\x12\x18\n" +
+	"\aservice\x18\x01 \x01(\tR\aservice\"\xae\x01\n" +
+	"\x13HealthCheckResponse\x12A\n" +
+	"\x06status\x18\x01 \x01(\x0e2).wol.v1.HealthCheckResponse.ServingStatusR\x06status\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\tR\aversion\":\n" +
+	"\rServingStatus\x12\v\n" +
+	"\aUNKNOWN\x10\x00\x12\v\n" +
+	"\aSERVING\x10\x01\x12\x0f\n" +
+	"\vNOT_SERVING\x10\x02*\xb6\x02\n" +
+	"\x0eResponseStatus\x12\v\n" +
+	"\aUNKNOWN\x10\x00\x12\f\n" +
+	"\bACCEPTED\x10\x01\x12\r\n" +
+	"\tDUPLICATE\x10\x02\x12\x10\n" +
+	"\fVM_NOT_FOUND\x10\x03\x12\x16\n" +
+	"\x12VM_START_INITIATED\x10\x04\x12\x16\n" +
+	"\x12VM_ALREADY_RUNNING\x10\x05\x12\t\n" +
+	"\x05ERROR\x10\x06\x12\x1e\n" +
+	"\x1aSECURE_ON_PASSWORD_MISSING\x10\a\x12\x1e\n" +
+	"\x1aSECURE_ON_PASSWORD_INVALID\x10\b\x12\x13\n" +
+	"\x0fPOLICY_REJECTED\x10\t\x12\r\n" +
+	"\tFORWARDED\x10\n" +
+	"\x12\x15\n" +
+	"\x11VM_STOP_INITIATED\x10\v\x12\x10\n" +
+	"\fRATE_LIMITED\x10\f\x12\x13\n" +
+	"\x0fNODE_UNVERIFIED\x10\r\x12\v\n" +
+	"\aDRY_RUN\x10\x0e2\xef\a\n" +
+	"\n" +
+	"WOLService\x12<\n" +
+	"\x0eReportWOLEvent\x12\x10.wol.v1.WOLEvent\x1a\x18.wol.v1.WOLEventResponse\x12F\n" +
+	"\x14ReportWOLEventStream\x12\x10.wol.v1.WOLEvent\x1a\x18.wol.v1.WOLEventResponse(\x010\x01\x12F\n" +
+	"\vHealthCheck\x12\x1a.wol.v1.HealthCheckRequest\x1a\x1b.wol.v1.HealthCheckResponse\x12<\n" +
+	"\vRequestWake\x12\x13.wol.v1.WakeRequest\x1a\x18.wol.v1.WOLEventResponse\x12F\n" +
+	"\rGetARPTargets\x12\x19.wol.v1.ARPTargetsRequest\x1a\x1a.wol.v1.ARPTargetsResponse\x12R\n" +
+	"\x11GetInterfaceHints\x12\x1d.wol.v1.InterfaceHintsRequest\x1a\x1e.wol.v1.InterfaceHintsResponse\x12I\n" +
+	"\x0fReportListeners\x12\x16.wol.v1.ListenerReport\x1a\x1e.wol.v1.ListenerReportResponse\x12B\n" +
+	"\rWatchForwards\x12\x17.wol.v1.ForwardsRequest\x1a\x16.wol.v1.ForwardRequest0\x01\x12O\n" +
+	"\x0eAgentHeartbeat\x12\x1d.wol.v1.AgentHeartbeatRequest\x1a\x1e.wol.v1.AgentHeartbeatResponse\x12I\n" +
+	"\x0eGetAgentConfig\x12\x1a.wol.v1.AgentConfigRequest\x1a\x1b.wol.v1.AgentConfigResponse\x12H\n" +
+	"\x11WatchMACAllowlist\x12\x1b.wol.v1.MACAllowlistRequest\x1a\x14.wol.v1.MACAllowlist0\x01\x12=\n" +
+	"\n" +
+	"GetVersion\x12\x16.wol.v1.VersionRequest\x1a\x17.wol.v1.VersionResponse\x12:\n" +
+	"\aSendWOL\x12\x16.wol.v1.SendWOLRequest\x1a\x17.wol.v1.SendWOLResponse\x12I\n" +
+	"\fSimulateWake\x12\x1b.wol.v1.SimulateWakeRequest\x1a\x1c.wol.v1.SimulateWakeResponseB2Z0github.com/gpillon/kubevirt-wol/api/wol/v1;wolv1b\x06proto3"
 
 var (
 	file_api_wol_v1_wol_proto_rawDescOnce sync.Once
@@ -517,33 +2618,91 @@ func file_api_wol_v1_wol_proto_rawDescGZIP() []byte {
 }
 
 var file_api_wol_v1_wol_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
-var file_api_wol_v1_wol_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_api_wol_v1_wol_proto_msgTypes = make([]protoimpl.MessageInfo, 31)
 var file_api_wol_v1_wol_proto_goTypes = []any{
 	(ResponseStatus)(0),                    // 0: wol.v1.ResponseStatus
 	(HealthCheckResponse_ServingStatus)(0), // 1: wol.v1.HealthCheckResponse.ServingStatus
 	(*WOLEvent)(nil),                       // 2: wol.v1.WOLEvent
 	(*WOLEventResponse)(nil),               // 3: wol.v1.WOLEventResponse
-	(*VMInfo)(nil),                         // 4: wol.v1.VMInfo
-	(*HealthCheckRequest)(nil),             // 5: wol.v1.HealthCheckRequest
-	(*HealthCheckResponse)(nil),            // 6: wol.v1.HealthCheckResponse
-	(*timestamppb.Timestamp)(nil),          // 7: google.protobuf.Timestamp
+	(*WakeDependency)(nil),                 // 4: wol.v1.WakeDependency
+	(*WakeRequest)(nil),                    // 5: wol.v1.WakeRequest
+	(*ARPTargetsRequest)(nil),              // 6: wol.v1.ARPTargetsRequest
+	(*ARPTarget)(nil),                      // 7: wol.v1.ARPTarget
+	(*ARPTargetsResponse)(nil),             // 8: wol.v1.ARPTargetsResponse
+	(*InterfaceHintsRequest)(nil),          // 9: wol.v1.InterfaceHintsRequest
+	(*NetworkAttachment)(nil),              // 10: wol.v1.NetworkAttachment
+	(*InterfaceHintsResponse)(nil),         // 11: wol.v1.InterfaceHintsResponse
+	(*InterfaceRules)(nil),                 // 12: wol.v1.InterfaceRules
+	(*ListenerReport)(nil),                 // 13: wol.v1.ListenerReport
+	(*ListenerBinding)(nil),                // 14: wol.v1.ListenerBinding
+	(*ListenerReportResponse)(nil),         // 15: wol.v1.ListenerReportResponse
+	(*AgentHeartbeatRequest)(nil),          // 16: wol.v1.AgentHeartbeatRequest
+	(*AgentHeartbeatResponse)(nil),         // 17: wol.v1.AgentHeartbeatResponse
+	(*AgentConfigRequest)(nil),             // 18: wol.v1.AgentConfigRequest
+	(*AgentConfigResponse)(nil),            // 19: wol.v1.AgentConfigResponse
+	(*MACAllowlistRequest)(nil),            // 20: wol.v1.MACAllowlistRequest
+	(*MACAllowlist)(nil),                   // 21: wol.v1.MACAllowlist
+	(*VersionRequest)(nil),                 // 22: wol.v1.VersionRequest
+	(*VersionResponse)(nil),                // 23: wol.v1.VersionResponse
+	(*ForwardsRequest)(nil),                // 24: wol.v1.ForwardsRequest
+	(*ForwardRequest)(nil),                 // 25: wol.v1.ForwardRequest
+	(*SendWOLRequest)(nil),                 // 26: wol.v1.SendWOLRequest
+	(*SendWOLResponse)(nil),                // 27: wol.v1.SendWOLResponse
+	(*SimulateWakeRequest)(nil),            // 28: wol.v1.SimulateWakeRequest
+	(*SimulateWakeResponse)(nil),           // 29: wol.v1.SimulateWakeResponse
+	(*VMInfo)(nil),                         // 30: wol.v1.VMInfo
+	(*HealthCheckRequest)(nil),             // 31: wol.v1.HealthCheckRequest
+	(*HealthCheckResponse)(nil),            // 32: wol.v1.HealthCheckResponse
+	(*timestamppb.Timestamp)(nil),          // 33: google.protobuf.Timestamp
 }
 var file_api_wol_v1_wol_proto_depIdxs = []int32{
-	7, // 0: wol.v1.WOLEvent.timestamp:type_name -> google.protobuf.Timestamp
-	0, // 1: wol.v1.WOLEventResponse.status:type_name -> wol.v1.ResponseStatus
-	4, // 2: wol.v1.WOLEventResponse.vm_info:type_name -> wol.v1.VMInfo
-	1, // 3: wol.v1.HealthCheckResponse.status:type_name -> wol.v1.HealthCheckResponse.ServingStatus
-	2, // 4: wol.v1.WOLService.ReportWOLEvent:input_type -> wol.v1.WOLEvent
-	2, // 5: wol.v1.WOLService.ReportWOLEventStream:input_type -> wol.v1.WOLEvent
-	5, // 6: wol.v1.WOLService.HealthCheck:input_type -> wol.v1.HealthCheckRequest
-	3, // 7: wol.v1.WOLService.ReportWOLEvent:output_type -> wol.v1.WOLEventResponse
-	3, // 8: wol.v1.WOLService.ReportWOLEventStream:output_type -> wol.v1.WOLEventResponse
-	6, // 9: wol.v1.WOLService.HealthCheck:output_type -> wol.v1.HealthCheckResponse
-	7, // [7:10] is the sub-list for method output_type
-	4, // [4:7] is the sub-list for method input_type
-	4, // [4:4] is the sub-list for extension type_name
-	4, // [4:4] is the sub-list for extension extendee
-	0, // [0:4] is the sub-list for field type_name
+	33, // 0: wol.v1.WOLEvent.timestamp:type_name -> google.protobuf.Timestamp
+	0,  // 1: wol.v1.WOLEventResponse.status:type_name -> wol.v1.ResponseStatus
+	30, // 2: wol.v1.WOLEventResponse.vm_info:type_name -> wol.v1.VMInfo
+	4,  // 3: wol.v1.WOLEventResponse.dependencies:type_name -> wol.v1.WakeDependency
+	0,  // 4: wol.v1.WakeDependency.status:type_name -> wol.v1.ResponseStatus
+	7,  // 5: wol.v1.ARPTargetsResponse.targets:type_name -> wol.v1.ARPTarget
+	10, // 6: wol.v1.InterfaceHintsResponse.attachments:type_name -> wol.v1.NetworkAttachment
+	12, // 7: wol.v1.InterfaceHintsResponse.node_rules:type_name -> wol.v1.InterfaceRules
+	14, // 8: wol.v1.ListenerReport.bindings:type_name -> wol.v1.ListenerBinding
+	33, // 9: wol.v1.AgentHeartbeatRequest.started_at:type_name -> google.protobuf.Timestamp
+	12, // 10: wol.v1.AgentConfigResponse.interfaces:type_name -> wol.v1.InterfaceRules
+	0,  // 11: wol.v1.SimulateWakeResponse.status:type_name -> wol.v1.ResponseStatus
+	30, // 12: wol.v1.SimulateWakeResponse.vm_info:type_name -> wol.v1.VMInfo
+	1,  // 13: wol.v1.HealthCheckResponse.status:type_name -> wol.v1.HealthCheckResponse.ServingStatus
+	2,  // 14: wol.v1.WOLService.ReportWOLEvent:input_type -> wol.v1.WOLEvent
+	2,  // 15: wol.v1.WOLService.ReportWOLEventStream:input_type -> wol.v1.WOLEvent
+	31, // 16: wol.v1.WOLService.HealthCheck:input_type -> wol.v1.HealthCheckRequest
+	5,  // 17: wol.v1.WOLService.RequestWake:input_type -> wol.v1.WakeRequest
+	6,  // 18: wol.v1.WOLService.GetARPTargets:input_type -> wol.v1.ARPTargetsRequest
+	9,  // 19: wol.v1.WOLService.GetInterfaceHints:input_type -> wol.v1.InterfaceHintsRequest
+	13, // 20: wol.v1.WOLService.ReportListeners:input_type -> wol.v1.ListenerReport
+	24, // 21: wol.v1.WOLService.WatchForwards:input_type -> wol.v1.ForwardsRequest
+	16, // 22: wol.v1.WOLService.AgentHeartbeat:input_type -> wol.v1.AgentHeartbeatRequest
+	18, // 23: wol.v1.WOLService.GetAgentConfig:input_type -> wol.v1.AgentConfigRequest
+	20, // 24: wol.v1.WOLService.WatchMACAllowlist:input_type -> wol.v1.MACAllowlistRequest
+	22, // 25: wol.v1.WOLService.GetVersion:input_type -> wol.v1.VersionRequest
+	26, // 26: wol.v1.WOLService.SendWOL:input_type -> wol.v1.SendWOLRequest
+	28, // 27: wol.v1.WOLService.SimulateWake:input_type -> wol.v1.SimulateWakeRequest
+	3,  // 28: wol.v1.WOLService.ReportWOLEvent:output_type -> wol.v1.WOLEventResponse
+	3,  // 29: wol.v1.WOLService.ReportWOLEventStream:output_type -> wol.v1.WOLEventResponse
+	32, // 30: wol.v1.WOLService.HealthCheck:output_type -> wol.v1.HealthCheckResponse
+	3,  // 31: wol.v1.WOLService.RequestWake:output_type -> wol.v1.WOLEventResponse
+	8,  // 32: wol.v1.WOLService.GetARPTargets:output_type -> wol.v1.ARPTargetsResponse
+	11, // 33: wol.v1.WOLService.GetInterfaceHints:output_type -> wol.v1.InterfaceHintsResponse
+	15, // 34: wol.v1.WOLService.ReportListeners:output_type -> wol.v1.ListenerReportResponse
+	25, // 35: wol.v1.WOLService.WatchForwards:output_type -> wol.v1.ForwardRequest
+	17, // 36: wol.v1.WOLService.AgentHeartbeat:output_type -> wol.v1.AgentHeartbeatResponse
+	19, // 37: wol.v1.WOLService.GetAgentConfig:output_type -> wol.v1.AgentConfigResponse
+	21, // 38: wol.v1.WOLService.WatchMACAllowlist:output_type -> wol.v1.MACAllowlist
+	23, // 39: wol.v1.WOLService.GetVersion:output_type -> wol.v1.VersionResponse
+	27, // 40: wol.v1.WOLService.SendWOL:output_type -> wol.v1.SendWOLResponse
+	29, // 41: wol.v1.WOLService.SimulateWake:output_type -> wol.v1.SimulateWakeResponse
+	28, // [28:42] is the sub-list for method output_type
+	14, // [14:28] is the sub-list for method input_type
+	14, // [14:14] is the sub-list for extension type_name
+	14, // [14:14] is the sub-list for extension extendee
+	0,  // [0:14] is the sub-list for field type_name
 }
 
 func init() { file_api_wol_v1_wol_proto_init() }
@@ -557,7 +2716,7 @@ func file_api_wol_v1_wol_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_wol_v1_wol_proto_rawDesc), len(file_api_wol_v1_wol_proto_rawDesc)),
 			NumEnums:      2,
-			NumMessages:   5,
+			NumMessages:   31,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -22,6 +22,17 @@ const (
 	WOLService_ReportWOLEvent_FullMethodName       = "/wol.v1.WOLService/ReportWOLEvent"
 	WOLService_ReportWOLEventStream_FullMethodName = "/wol.v1.WOLService/ReportWOLEventStream"
 	WOLService_HealthCheck_FullMethodName          = "/wol.v1.WOLService/HealthCheck"
+	WOLService_RequestWake_FullMethodName          = "/wol.v1.WOLService/RequestWake"
+	WOLService_GetARPTargets_FullMethodName        = "/wol.v1.WOLService/GetARPTargets"
+	WOLService_GetInterfaceHints_FullMethodName    = "/wol.v1.WOLService/GetInterfaceHints"
+	WOLService_ReportListeners_FullMethodName      = "/wol.v1.WOLService/ReportListeners"
+	WOLService_WatchForwards_FullMethodName        = "/wol.v1.WOLService/WatchForwards"
+	WOLService_AgentHeartbeat_FullMethodName       = "/wol.v1.WOLService/AgentHeartbeat"
+	WOLService_GetAgentConfig_FullMethodName       = "/wol.v1.WOLService/GetAgentConfig"
+	WOLService_WatchMACAllowlist_FullMethodName    = "/wol.v1.WOLService/WatchMACAllowlist"
+	WOLService_GetVersion_FullMethodName           = "/wol.v1.WOLService/GetVersion"
+	WOLService_SendWOL_FullMethodName              = "/wol.v1.WOLService/SendWOL"
+	WOLService_SimulateWake_FullMethodName         = "/wol.v1.WOLService/SimulateWake"
 )
 
 // WOLServiceClient is the client API for WOLService service.
@@ -32,10 +43,46 @@ const (
 type WOLServiceClient interface {
 	// ReportWOLEvent invia un evento WOL all'operator centrale
 	ReportWOLEvent(ctx context.Context, in *WOLEvent, opts ...grpc.CallOption) (*WOLEventResponse, error)
-	// ReportWOLEventStream supporta streaming per multiple events (opzionale per future)
+	// ReportWOLEventStream riporta gli eventi su uno stream di lunga durata:
+	// le risposte possono arrivare fuori ordine e portano la sequence dell'evento
 	ReportWOLEventStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[WOLEvent, WOLEventResponse], error)
 	// HealthCheck per verificare che il server gRPC sia attivo
 	HealthCheck(ctx context.Context, in *HealthCheckRequest, opts ...grpc.CallOption) (*HealthCheckResponse, error)
+	// RequestWake chiede l'avvio di una VM per nome, senza magic packet
+	// (usato dall'activator quando arriva una connessione per una VM spenta)
+	RequestWake(ctx context.Context, in *WakeRequest, opts ...grpc.CallOption) (*WOLEventResponse, error)
+	// GetARPTargets restituisce gli IP delle VM spente che una richiesta ARP può svegliare
+	GetARPTargets(ctx context.Context, in *ARPTargetsRequest, opts ...grpc.CallOption) (*ARPTargetsResponse, error)
+	// GetInterfaceHints restituisce le interfacce (bridge, master) delle
+	// NetworkAttachmentDefinition usate dalle VM gestite
+	GetInterfaceHints(ctx context.Context, in *InterfaceHintsRequest, opts ...grpc.CallOption) (*InterfaceHintsResponse, error)
+	// ReportListeners comunica le porte UDP e le interfacce raw su cui l'agent
+	// è in ascolto (e quelle che non è riuscito ad aprire)
+	ReportListeners(ctx context.Context, in *ListenerReport, opts ...grpc.CallOption) (*ListenerReportResponse, error)
+	// WatchForwards riceve i magic packet che l'agent deve riemettere sul
+	// proprio nodo verso le macchine fisiche (mapping Forward delle WolConfig)
+	WatchForwards(ctx context.Context, in *ForwardsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ForwardRequest], error)
+	// AgentHeartbeat riporta periodicamente lo stato dell'agent (versione,
+	// interfacce, contatori), mostrato per nodo nello status della WolConfig.
+	// Gli agent senza heartbeat recenti rendono la WolConfig AgentDegraded
+	AgentHeartbeat(ctx context.Context, in *AgentHeartbeatRequest, opts ...grpc.CallOption) (*AgentHeartbeatResponse, error)
+	// GetAgentConfig restituisce le impostazioni della WolConfig che l'agent
+	// applica senza riavvio (porta, finestra di dedupe, interfacce)
+	GetAgentConfig(ctx context.Context, in *AgentConfigRequest, opts ...grpc.CallOption) (*AgentConfigResponse, error)
+	// WatchMACAllowlist riceve i MAC su cui l'operatore agisce (VM, sleep e
+	// forward), reinviati a ogni cambio della mapping: l'agent scarta in locale
+	// i magic packet degli altri MAC
+	WatchMACAllowlist(ctx context.Context, in *MACAllowlistRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[MACAllowlist], error)
+	// GetVersion restituisce la build del manager e le funzionalità che
+	// supporta: l'agent non usa le RPC delle funzionalità assenti
+	GetVersion(ctx context.Context, in *VersionRequest, opts ...grpc.CallOption) (*VersionResponse, error)
+	// SendWOL invia un magic packet a una macchina fuori dal cluster, dal
+	// manager o dall'agent di un nodo (l'inverso della ricezione)
+	SendWOL(ctx context.Context, in *SendWOLRequest, opts ...grpc.CallOption) (*SendWOLResponse, error)
+	// SimulateWake fa passare un magic packet sintetico per un MAC da tutto il
+	// percorso dell'aggregator in dry-run: riporta quale VM sarebbe avviata e
+	// perché, senza avviare VM né chiamare KubeVirt
+	SimulateWake(ctx context.Context, in *SimulateWakeRequest, opts ...grpc.CallOption) (*SimulateWakeResponse, error)
 }
 
 type wOLServiceClient struct {
@@ -79,6 +126,134 @@ func (c *wOLServiceClient) HealthCheck(ctx context.Context, in *HealthCheckReque
 	return out, nil
 }
 
+func (c *wOLServiceClient) RequestWake(ctx context.Context, in *WakeRequest, opts ...grpc.CallOption) (*WOLEventResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WOLEventResponse)
+	err := c.cc.Invoke(ctx, WOLService_RequestWake_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wOLServiceClient) GetARPTargets(ctx context.Context, in *ARPTargetsRequest, opts ...grpc.CallOption) (*ARPTargetsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ARPTargetsResponse)
+	err := c.cc.Invoke(ctx, WOLService_GetARPTargets_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wOLServiceClient) GetInterfaceHints(ctx context.Context, in *InterfaceHintsRequest, opts ...grpc.CallOption) (*InterfaceHintsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InterfaceHintsResponse)
+	err := c.cc.Invoke(ctx, WOLService_GetInterfaceHints_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wOLServiceClient) ReportListeners(ctx context.Context, in *ListenerReport, opts ...grpc.CallOption) (*ListenerReportResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListenerReportResponse)
+	err := c.cc.Invoke(ctx, WOLService_ReportListeners_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wOLServiceClient) WatchForwards(ctx context.Context, in *ForwardsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ForwardRequest], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &WOLService_ServiceDesc.Streams[1], WOLService_WatchForwards_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ForwardsRequest, ForwardRequest]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type WOLService_WatchForwardsClient = grpc.ServerStreamingClient[ForwardRequest]
+
+func (c *wOLServiceClient) AgentHeartbeat(ctx context.Context, in *AgentHeartbeatRequest, opts ...grpc.CallOption) (*AgentHeartbeatResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AgentHeartbeatResponse)
+	err := c.cc.Invoke(ctx, WOLService_AgentHeartbeat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wOLServiceClient) GetAgentConfig(ctx context.Context, in *AgentConfigRequest, opts ...grpc.CallOption) (*AgentConfigResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AgentConfigResponse)
+	err := c.cc.Invoke(ctx, WOLService_GetAgentConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wOLServiceClient) WatchMACAllowlist(ctx context.Context, in *MACAllowlistRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[MACAllowlist], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &WOLService_ServiceDesc.Streams[2], WOLService_WatchMACAllowlist_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[MACAllowlistRequest, MACAllowlist]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type WOLService_WatchMACAllowlistClient = grpc.ServerStreamingClient[MACAllowlist]
+
+func (c *wOLServiceClient) GetVersion(ctx context.Context, in *VersionRequest, opts ...grpc.CallOption) (*VersionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VersionResponse)
+	err := c.cc.Invoke(ctx, WOLService_GetVersion_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wOLServiceClient) SendWOL(ctx context.Context, in *SendWOLRequest, opts ...grpc.CallOption) (*SendWOLResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SendWOLResponse)
+	err := c.cc.Invoke(ctx, WOLService_SendWOL_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wOLServiceClient) SimulateWake(ctx context.Context, in *SimulateWakeRequest, opts ...grpc.CallOption) (*SimulateWakeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SimulateWakeResponse)
+	err := c.cc.Invoke(ctx, WOLService_SimulateWake_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // WOLServiceServer is the server API for WOLService service.
 // All implementations must embed UnimplementedWOLServiceServer
 // for forward compatibility.
@@ -87,10 +262,46 @@ func (c *wOLServiceClient) HealthCheck(ctx context.Context, in *HealthCheckReque
 type WOLServiceServer interface {
 	// ReportWOLEvent invia un evento WOL all'operator centrale
 	ReportWOLEvent(context.Context, *WOLEvent) (*WOLEventResponse, error)
-	// ReportWOLEventStream supporta streaming per multiple events (opzionale per future)
+	// ReportWOLEventStream riporta gli eventi su uno stream di lunga durata:
+	// le risposte possono arrivare fuori ordine e portano la sequence dell'evento
 	ReportWOLEventStream(grpc.BidiStreamingServer[WOLEvent, WOLEventResponse]) error
 	// HealthCheck per verificare che il server gRPC sia attivo
 	HealthCheck(context.Context, *HealthCheckRequest) (*HealthCheckResponse, error)
+	// RequestWake chiede l'avvio di una VM per nome, senza magic packet
+	// (usato dall'activator quando arriva una connessione per una VM spenta)
+	RequestWake(context.Context, *WakeRequest) (*WOLEventResponse, error)
+	// GetARPTargets restituisce gli IP delle VM spente che una richiesta ARP può svegliare
+	GetARPTargets(context.Context, *ARPTargetsRequest) (*ARPTargetsResponse, error)
+	// GetInterfaceHints restituisce le interfacce (bridge, master) delle
+	// NetworkAttachmentDefinition usate dalle VM gestite
+	GetInterfaceHints(context.Context, *InterfaceHintsRequest) (*InterfaceHintsResponse, error)
+	// ReportListeners comunica le porte UDP e le interfacce raw su cui l'agent
+	// è in ascolto (e quelle che non è riuscito ad aprire)
+	ReportListeners(context.Context, *ListenerReport) (*ListenerReportResponse, error)
+	// WatchForwards riceve i magic packet che l'agent deve riemettere sul
+	// proprio nodo verso le macchine fisiche (mapping Forward delle WolConfig)
+	WatchForwards(*ForwardsRequest, grpc.ServerStreamingServer[ForwardRequest]) error
+	// AgentHeartbeat riporta periodicamente lo stato dell'agent (versione,
+	// interfacce, contatori), mostrato per nodo nello status della WolConfig.
+	// Gli agent senza heartbeat recenti rendono la WolConfig AgentDegraded
+	AgentHeartbeat(context.Context, *AgentHeartbeatRequest) (*AgentHeartbeatResponse, error)
+	// GetAgentConfig restituisce le impostazioni della WolConfig che l'agent
+	// applica senza riavvio (porta, finestra di dedupe, interfacce)
+	GetAgentConfig(context.Context, *AgentConfigRequest) (*AgentConfigResponse, error)
+	// WatchMACAllowlist riceve i MAC su cui l'operatore agisce (VM, sleep e
+	// forward), reinviati a ogni cambio della mapping: l'agent scarta in locale
+	// i magic packet degli altri MAC
+	WatchMACAllowlist(*MACAllowlistRequest, grpc.ServerStreamingServer[MACAllowlist]) error
+	// GetVersion restituisce la build del manager e le funzionalità che
+	// supporta: l'agent non usa le RPC delle funzionalità assenti
+	GetVersion(context.Context, *VersionRequest) (*VersionResponse, error)
+	// SendWOL invia un magic packet a una macchina fuori dal cluster, dal
+	// manager o dall'agent di un nodo (l'inverso della ricezione)
+	SendWOL(context.Context, *SendWOLRequest) (*SendWOLResponse, error)
+	// SimulateWake fa passare un magic packet sintetico per un MAC da tutto il
+	// percorso dell'aggregator in dry-run: riporta quale VM sarebbe avviata e
+	// perché, senza avviare VM né chiamare KubeVirt
+	SimulateWake(context.Context, *SimulateWakeRequest) (*SimulateWakeResponse, error)
 	mustEmbedUnimplementedWOLServiceServer()
 }
 
@@ -110,6 +321,39 @@ func (UnimplementedWOLServiceServer) ReportWOLEventStream(grpc.BidiStreamingServ
 func (UnimplementedWOLServiceServer) HealthCheck(context.Context, *HealthCheckRequest) (*HealthCheckResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method HealthCheck not implemented")
 }
+func (UnimplementedWOLServiceServer) RequestWake(context.Context, *WakeRequest) (*WOLEventResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestWake not implemented")
+}
+func (UnimplementedWOLServiceServer) GetARPTargets(context.Context, *ARPTargetsRequest) (*ARPTargetsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetARPTargets not implemented")
+}
+func (UnimplementedWOLServiceServer) GetInterfaceHints(context.Context, *InterfaceHintsRequest) (*InterfaceHintsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetInterfaceHints not implemented")
+}
+func (UnimplementedWOLServiceServer) ReportListeners(context.Context, *ListenerReport) (*ListenerReportResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportListeners not implemented")
+}
+func (UnimplementedWOLServiceServer) WatchForwards(*ForwardsRequest, grpc.ServerStreamingServer[ForwardRequest]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchForwards not implemented")
+}
+func (UnimplementedWOLServiceServer) AgentHeartbeat(context.Context, *AgentHeartbeatRequest) (*AgentHeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AgentHeartbeat not implemented")
+}
+func (UnimplementedWOLServiceServer) GetAgentConfig(context.Context, *AgentConfigRequest) (*AgentConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAgentConfig not implemented")
+}
+func (UnimplementedWOLServiceServer) WatchMACAllowlist(*MACAllowlistRequest, grpc.ServerStreamingServer[MACAllowlist]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchMACAllowlist not implemented")
+}
+func (UnimplementedWOLServiceServer) GetVersion(context.Context, *VersionRequest) (*VersionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetVersion not implemented")
+}
+func (UnimplementedWOLServiceServer) SendWOL(context.Context, *SendWOLRequest) (*SendWOLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendWOL not implemented")
+}
+func (UnimplementedWOLServiceServer) SimulateWake(context.Context, *SimulateWakeRequest) (*SimulateWakeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SimulateWake not implemented")
+}
 func (UnimplementedWOLServiceServer) mustEmbedUnimplementedWOLServiceServer() {}
 func (UnimplementedWOLServiceServer) testEmbeddedByValue()                    {}
 
@@ -174,6 +418,190 @@ func _WOLService_HealthCheck_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _WOLService_RequestWake_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WakeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WOLServiceServer).RequestWake(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WOLService_RequestWake_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WOLServiceServer).RequestWake(ctx, req.(*WakeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WOLService_GetARPTargets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ARPTargetsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WOLServiceServer).GetARPTargets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WOLService_GetARPTargets_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WOLServiceServer).GetARPTargets(ctx, req.(*ARPTargetsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WOLService_GetInterfaceHints_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InterfaceHintsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WOLServiceServer).GetInterfaceHints(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WOLService_GetInterfaceHints_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WOLServiceServer).GetInterfaceHints(ctx, req.(*InterfaceHintsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WOLService_ReportListeners_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListenerReport)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WOLServiceServer).ReportListeners(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WOLService_ReportListeners_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WOLServiceServer).ReportListeners(ctx, req.(*ListenerReport))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WOLService_WatchForwards_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ForwardsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WOLServiceServer).WatchForwards(m, &grpc.GenericServerStream[ForwardsRequest, ForwardRequest]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type WOLService_WatchForwardsServer = grpc.ServerStreamingServer[ForwardRequest]
+
+func _WOLService_AgentHeartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AgentHeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WOLServiceServer).AgentHeartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WOLService_AgentHeartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WOLServiceServer).AgentHeartbeat(ctx, req.(*AgentHeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WOLService_GetAgentConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AgentConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WOLServiceServer).GetAgentConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WOLService_GetAgentConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WOLServiceServer).GetAgentConfig(ctx, req.(*AgentConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WOLService_WatchMACAllowlist_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(MACAllowlistRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WOLServiceServer).WatchMACAllowlist(m, &grpc.GenericServerStream[MACAllowlistRequest, MACAllowlist]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type WOLService_WatchMACAllowlistServer = grpc.ServerStreamingServer[MACAllowlist]
+
+func _WOLService_GetVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VersionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WOLServiceServer).GetVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WOLService_GetVersion_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WOLServiceServer).GetVersion(ctx, req.(*VersionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WOLService_SendWOL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendWOLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WOLServiceServer).SendWOL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WOLService_SendWOL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WOLServiceServer).SendWOL(ctx, req.(*SendWOLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WOLService_SimulateWake_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SimulateWakeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WOLServiceServer).SimulateWake(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WOLService_SimulateWake_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WOLServiceServer).SimulateWake(ctx, req.(*SimulateWakeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // WOLService_ServiceDesc is the grpc.ServiceDesc for WOLService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -189,6 +617,42 @@ var WOLService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "HealthCheck",
 			Handler:    _WOLService_HealthCheck_Handler,
 		},
+		{
+			MethodName: "RequestWake",
+			Handler:    _WOLService_RequestWake_Handler,
+		},
+		{
+			MethodName: "GetARPTargets",
+			Handler:    _WOLService_GetARPTargets_Handler,
+		},
+		{
+			MethodName: "GetInterfaceHints",
+			Handler:    _WOLService_GetInterfaceHints_Handler,
+		},
+		{
+			MethodName: "ReportListeners",
+			Handler:    _WOLService_ReportListeners_Handler,
+		},
+		{
+			MethodName: "AgentHeartbeat",
+			Handler:    _WOLService_AgentHeartbeat_Handler,
+		},
+		{
+			MethodName: "GetAgentConfig",
+			Handler:    _WOLService_GetAgentConfig_Handler,
+		},
+		{
+			MethodName: "GetVersion",
+			Handler:    _WOLService_GetVersion_Handler,
+		},
+		{
+			MethodName: "SendWOL",
+			Handler:    _WOLService_SendWOL_Handler,
+		},
+		{
+			MethodName: "SimulateWake",
+			Handler:    _WOLService_SimulateWake_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -197,6 +661,16 @@ var WOLService_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "WatchForwards",
+			Handler:       _WOLService_WatchForwards_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchMACAllowlist",
+			Handler:       _WOLService_WatchMACAllowlist_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "api/wol/v1/wol.proto",
 }
@@ -0,0 +1,126 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/gpillon/kubevirt-wol/internal/wol"
+)
+
+var (
+	setupLog = ctrl.Log.WithName("setup")
+)
+
+func main() {
+	var operatorAddr string
+	var portsStr string
+	var opts wol.ActivatorOptions
+	var tlsFiles wol.ClientTLSFiles
+
+	flag.StringVar(&operatorAddr, "operator-address", "",
+		"Operator gRPC address (defaults to $OPERATOR_ADDRESS, then $OPERATOR_SERVICE, then the Service of the default install)")
+	flag.StringVar(&opts.VMNamespace, "vm-namespace", os.Getenv("POD_NAMESPACE"),
+		"Namespace of the VirtualMachine to wake (defaults to the pod namespace)")
+	flag.StringVar(&opts.VMName, "vm-name", "", "Name of the VirtualMachine to wake")
+	flag.StringVar(&opts.BackendHost, "backend", "",
+		"Address of the VM, usually a Service selecting the virt-launcher pod")
+	flag.StringVar(&portsStr, "ports", "",
+		"Ports to front, comma-separated <protocol>:<port>[:<targetPort>] (e.g. 22,tcp:80:8080,udp:53)")
+	flag.DurationVar(&opts.ReadyTimeout, "ready-timeout", 3*time.Minute,
+		"How long a connection is held while the VM starts")
+	flag.DurationVar(&opts.RetryInterval, "retry-interval", time.Second,
+		"Pause between connection attempts to the backend while the VM starts")
+	flag.DurationVar(&opts.WakeInterval, "wake-interval", 10*time.Second,
+		"Minimum interval between two wake requests")
+	flag.DurationVar(&opts.UDPIdleTimeout, "udp-idle-timeout", time.Minute,
+		"Close UDP flows whose client has been silent for this long")
+	flag.IntVar(&opts.MaxUDPFlows, "max-udp-flows", 1024,
+		"Maximum UDP flows per port; the least recently used one is closed to make room")
+	flag.IntVar(&opts.MaxPendingConnections, "max-pending-connections", 256,
+		"Maximum TCP connections held while the VM starts; further clients are closed right away")
+	flag.IntVar(&opts.HealthPort, "health-port", 8081, "Port for /healthz and /readyz (0 disables it)")
+	wol.BindClientTLSFlags(flag.CommandLine, &tlsFiles)
+	wol.BindTokenFileFlag(flag.CommandLine, &opts.TokenFile)
+
+	zapOpts := zap.Options{
+		Development: false,
+	}
+	zapOpts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&zapOpts)))
+
+	if opts.VMNamespace == "" || opts.VMName == "" || opts.BackendHost == "" {
+		setupLog.Error(nil, "vm-namespace, vm-name and backend are required")
+		os.Exit(1)
+	}
+
+	ports, err := wol.ParseActivatorPorts(portsStr)
+	if err != nil {
+		setupLog.Error(err, "Failed to parse ports", "portsStr", portsStr)
+		os.Exit(1)
+	}
+	opts.Ports = ports
+
+	if operatorAddr == "" {
+		// POD_NAMESPACE è il namespace della VM, non quello dell'operatore
+		getenv := func(key string) string {
+			if key == "POD_NAMESPACE" {
+				return ""
+			}
+			return os.Getenv(key)
+		}
+		resolveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		operatorAddr = wol.ResolveOperatorAddress(resolveCtx, getenv)
+		cancel()
+	}
+
+	setupLog.Info("Starting WOL activator",
+		"vm", opts.VMName,
+		"namespace", opts.VMNamespace,
+		"backend", opts.BackendHost,
+		"operator", operatorAddr,
+		"ports", portsStr)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if tlsFiles.Enabled() {
+		opts.TLS, err = wol.NewClientTLSConfig(ctx, tlsFiles)
+		if err != nil {
+			setupLog.Error(err, "Failed to configure TLS to the operator")
+			os.Exit(1)
+		}
+	}
+
+	activator := wol.NewActivator(operatorAddr, opts, ctrl.Log.WithName("activator"))
+	if err := activator.Start(ctx); err != nil {
+		setupLog.Error(err, "Activator failed to start")
+		os.Exit(1)
+	}
+
+	setupLog.Info("Activator stopped gracefully")
+}
@@ -25,7 +25,10 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	uberzap "go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
@@ -40,13 +43,102 @@ func main() {
 	var nodeName string
 	var operatorAddr string
 	var portsStr string
+	var arpWake, arpRespond, directedWake bool
+	var triggerICMP, triggerDNS bool
+	var triggerTCPPortsStr string
+	var promiscuous bool
+	var streamEvents, macFilter, watchInterfaces, redactPacketLogs bool
+	var packetLogRate float64
+	var drainTimeout time.Duration
+	var wolConfigName string
+	var udpReadBuffer, rawReadBuffer, metricsPort, eventBufferSize, reportWorkers, reportQueueSize int
+	var recvTimeout, dedupeCleanup, dedupeWindow, eventBufferMaxAge, configSync time.Duration
+	var configPath, ipFamilies, listenModes, sleepEtherType, captureBackend string
+	var rawUDPPortsStr, rawEtherTypesStr, vlansStr string
+	var interfaceInclude, interfaceExclude string
+	var chaos wol.ChaosOptions
+	var tlsFiles wol.ClientTLSFiles
+	var tokenFile string
 
 	flag.StringVar(&nodeName, "node-name", os.Getenv("NODE_NAME"),
 		"Kubernetes node name (from downward API or env)")
-	flag.StringVar(&operatorAddr, "operator-address",
-		"kubevirt-wol-grpc.kubevirt-wol-system.svc.cluster.local:9090",
-		"Operator gRPC address")
+	flag.StringVar(&operatorAddr, "operator-address", "",
+		"Operator gRPC address (default: $"+wol.EnvOperatorAddress+", the SRV record of $"+wol.EnvOperatorService+
+			" or the kubevirt-wol-grpc Service of $POD_NAMESPACE)")
 	flag.StringVar(&portsStr, "ports", "9", "UDP ports for WOL packets (comma-separated)")
+	flag.StringVar(&ipFamilies, "ip-families", "IPv4",
+		"IP families of the UDP listener, comma-separated (IPv4, IPv6); IPv6 joins the ff02::1 group")
+	flag.StringVar(&listenModes, "listen-modes", "Both",
+		"Listeners to start, comma-separated (Raw, UDP, Both); without Raw NET_RAW is not needed")
+	flag.BoolVar(&arpWake, "arp-wake", false,
+		"Wake stopped VMs when repeated ARP requests for their IPs are seen (requires raw listener)")
+	flag.BoolVar(&arpRespond, "arp-respond", false,
+		"Answer ARP requests and IPv6 neighbor solicitations for the IPs of stopped VMs with their MAC (requires --arp-wake)")
+	flag.BoolVar(&triggerICMP, "wake-trigger-icmp", false,
+		"Wake stopped VMs on pings to their IPs, for the VMs the operator enables it for (requires raw listener)")
+	flag.StringVar(&triggerTCPPortsStr, "wake-trigger-tcp-ports", "",
+		"Wake stopped VMs on TCP SYNs to these ports of their IPs (comma-separated, requires raw listener)")
+	flag.BoolVar(&triggerDNS, "wake-trigger-dns", false,
+		"Wake stopped VMs on DNS and mDNS queries for their names (requires raw listener)")
+	flag.BoolVar(&directedWake, "directed-wake", false,
+		"Capture magic packets sent as unicast UDP to the VMs on the --ports (requires raw listener)")
+	flag.StringVar(&rawUDPPortsStr, "raw-udp-ports", "",
+		"UDP ports whose IPv4 datagrams, broadcast included, the raw listeners parse for magic packets (comma-separated, e.g. 7)")
+	flag.StringVar(&rawEtherTypesStr, "raw-ethertypes", "",
+		"EtherTypes (0xNNNN, comma-separated) of the raw frames carrying wake magic packets, besides 0x0842")
+	flag.StringVar(&vlansStr, "vlans", "",
+		"VLAN IDs (outer or inner tag, comma-separated, 0 = untagged) the raw listeners accept frames from (empty = all)")
+	flag.StringVar(&sleepEtherType, "sleep-ethertype", "",
+		"EtherType (0xNNNN) of the raw frames reported as Shutdown-on-LAN sleep packets (empty = disabled)")
+	flag.BoolVar(&promiscuous, "promiscuous", true,
+		"Promiscuous capture on the raw listeners (false = broadcast 0x0842 frames only)")
+	flag.StringVar(&captureBackend, "capture-backend", "AFPacket",
+		"Capture backend of the raw listeners (AFPacket, or EBPF: an XDP program per interface, without promiscuous mode; requires BPF, PERFMON and NET_ADMIN)")
+	flag.BoolVar(&watchInterfaces, "watch-interfaces", true,
+		"Start and stop the raw listeners as interfaces are added to and removed from the node (netlink)")
+	flag.BoolVar(&macFilter, "mac-filter", true,
+		"Drop the magic packets of the MACs the operator does not manage, using the allowlist it pushes (no filtering until it is received)")
+	flag.BoolVar(&streamEvents, "stream-events", true,
+		"Report events on a long-lived gRPC stream, falling back to one call per packet when it fails")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 5*time.Second,
+		"On shutdown, how long to wait for in-flight reports to the operator")
+	flag.IntVar(&eventBufferSize, "event-buffer-size", wol.DefaultEventBufferSize,
+		"Events kept, and reported again with backoff, while the operator is unreachable (0 = disabled)")
+	flag.DurationVar(&eventBufferMaxAge, "event-buffer-max-age", wol.DefaultEventBufferMaxAge,
+		"How long a buffered event is reported again before it is dropped")
+	flag.Float64Var(&packetLogRate, "packet-log-rate", wol.DefaultPacketLogRate,
+		"Per-packet log lines written per second, the others are sampled out (0 = every packet)")
+	flag.BoolVar(&redactPacketLogs, "redact-packet-logs", false,
+		"Hash the source IPs and MACs in the per-packet logs")
+	flag.IntVar(&reportWorkers, "report-workers", wol.DefaultReportWorkers,
+		"Goroutines reporting the packets to the operator")
+	flag.IntVar(&reportQueueSize, "report-queue-size", wol.DefaultReportQueueSize,
+		"Packets waiting for a report worker; beyond it packets are dropped (wol_agent_packets_dropped_total)")
+	flag.StringVar(&wolConfigName, "wolconfig", os.Getenv("WOLCONFIG_NAME"),
+		"WolConfig served by this agent (selects ARP wake targets and interface hints)")
+	flag.IntVar(&udpReadBuffer, "udp-read-buffer", wol.DefaultUDPReadBuffer,
+		"Receive buffer (SO_RCVBUF) of the UDP listener, in bytes")
+	flag.IntVar(&rawReadBuffer, "raw-read-buffer", 0,
+		"Receive buffer (SO_RCVBUF) of the raw listeners, in bytes (0 = kernel default)")
+	flag.DurationVar(&recvTimeout, "recv-timeout", wol.DefaultReceiveTimeout,
+		"How long a listener read waits for a packet before checking for shutdown (whole seconds)")
+	flag.DurationVar(&dedupeCleanup, "dedupe-cleanup-interval", wol.DefaultDedupeCleanupInterval,
+		"How often expired entries are removed from the dedupe cache")
+	flag.DurationVar(&dedupeWindow, "dedupe-window", wol.DefaultDedupeWindow,
+		"How long a repeated packet is dropped by the local dedupe cache")
+	flag.IntVar(&metricsPort, "metrics-port", wol.DefaultAgentHealthPort,
+		"Port of the Prometheus /metrics endpoint (default: the health check port, 0 = disabled)")
+	flag.StringVar(&interfaceInclude, "interface-include", "",
+		"Raw listener interfaces to use, comma-separated shell patterns (e.g. bond0,vlan100,br-wol*), instead of those picked by name")
+	flag.StringVar(&interfaceExclude, "interface-exclude", "",
+		"Raw listener interfaces to drop, comma-separated shell patterns")
+	flag.DurationVar(&configSync, "config-sync-interval", wol.DefaultConfigSyncInterval,
+		"How often the ports, dedupe window and interface selector of the WolConfig are fetched from the operator and applied without a restart (0 = disabled)")
+	flag.StringVar(&configPath, "config", os.Getenv("WOL_AGENT_CONFIG"),
+		"Agent config file (YAML), watched for changes; flags set on the command line take precedence")
+	wol.BindClientTLSFlags(flag.CommandLine, &tlsFiles)
+	wol.BindTokenFileFlag(flag.CommandLine, &tokenFile)
+	wol.BindAgentChaosFlags(flag.CommandLine, &chaos)
 
 	opts := zap.Options{
 		Development: false,
@@ -54,14 +146,58 @@ func main() {
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	// Il file di configurazione riempie i flag non passati da riga di comando
+	var agentConfig *wol.AgentConfigFile
+	var configErr error
+	if configPath != "" {
+		agentConfig, configErr = wol.LoadAgentConfigFile(configPath)
+		if configErr == nil {
+			configErr = agentConfig.ApplyTo(flag.CommandLine)
+		}
+	}
+
+	// Livello di log modificabile a caldo, se non fissato da --zap-log-level
+	logLevel := uberzap.NewAtomicLevel()
+	if !explicit["zap-log-level"] {
+		if agentConfig != nil && agentConfig.LogLevel != "" {
+			level, _ := wol.ParseLogLevel(agentConfig.LogLevel)
+			logLevel.SetLevel(level)
+		}
+		opts.Level = logLevel
+	}
+
 	logger := zap.New(zap.UseFlagOptions(&opts))
 	ctrl.SetLogger(logger)
 
+	if configErr != nil {
+		setupLog.Error(configErr, "Failed to load agent config", "config", configPath)
+		os.Exit(1)
+	}
+
+	interfaceRules, err := wol.ParseInterfaceRules(interfaceInclude, interfaceExclude)
+	if err != nil {
+		setupLog.Error(err, "Invalid interface rules")
+		os.Exit(1)
+	}
+	// Le regole del file di configurazione sostituiscono quelle dei flag
+	if agentConfig != nil && !agentConfig.Interfaces.IsZero() {
+		interfaceRules = agentConfig.Interfaces
+	}
+
 	if nodeName == "" {
 		setupLog.Error(nil, "node-name is required (use --node-name flag or NODE_NAME env var)")
 		os.Exit(1)
 	}
 
+	if operatorAddr == "" {
+		resolveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		operatorAddr = wol.ResolveOperatorAddress(resolveCtx, os.Getenv)
+		cancel()
+	}
+
 	// Parse ports (for now use first port only, TODO: multi-port support)
 	ports, err := parsePorts(portsStr)
 	if err != nil {
@@ -71,11 +207,79 @@ func main() {
 
 	port := ports[0] // Use first port for now
 
+	ipv4, ipv6, err := wol.ParseIPFamilies(ipFamilies)
+	if err != nil {
+		setupLog.Error(err, "Failed to parse IP families", "ipFamilies", ipFamilies)
+		os.Exit(1)
+	}
+
+	rawMode, udpMode, err := wol.ParseListenModes(listenModes)
+	if err != nil {
+		setupLog.Error(err, "Failed to parse listen modes", "listenModes", listenModes)
+		os.Exit(1)
+	}
+
+	backend, err := wol.ParseCaptureBackend(captureBackend)
+	if err != nil {
+		setupLog.Error(err, "Failed to parse capture backend", "captureBackend", captureBackend)
+		os.Exit(1)
+	}
+
+	var sleepType uint16
+	if sleepEtherType != "" {
+		if sleepType, err = wol.ParseEtherType(sleepEtherType); err != nil {
+			setupLog.Error(err, "Failed to parse sleep EtherType", "sleepEtherType", sleepEtherType)
+			os.Exit(1)
+		}
+	}
+
+	var rawUDPPorts []int
+	if rawUDPPortsStr != "" {
+		if rawUDPPorts, err = parsePorts(rawUDPPortsStr); err != nil {
+			setupLog.Error(err, "Failed to parse raw UDP ports", "rawUDPPorts", rawUDPPortsStr)
+			os.Exit(1)
+		}
+	}
+	var triggerTCPPorts []int
+	if triggerTCPPortsStr != "" {
+		if triggerTCPPorts, err = parsePorts(triggerTCPPortsStr); err != nil {
+			setupLog.Error(err, "Failed to parse wake trigger TCP ports", "wakeTriggerTCPPorts", triggerTCPPortsStr)
+			os.Exit(1)
+		}
+	}
+	var rawEtherTypes []uint16
+	for _, value := range strings.Split(rawEtherTypesStr, ",") {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		etherType, err := wol.ParseWakeEtherType(value)
+		if err != nil {
+			setupLog.Error(err, "Failed to parse raw EtherTypes", "rawEtherTypes", rawEtherTypesStr)
+			os.Exit(1)
+		}
+		rawEtherTypes = append(rawEtherTypes, etherType)
+	}
+	vlans, err := wol.ParseVLANs(vlansStr)
+	if err != nil {
+		setupLog.Error(err, "Failed to parse VLANs", "vlans", vlansStr)
+		os.Exit(1)
+	}
+
+	if err := chaos.Validate(); err != nil {
+		setupLog.Error(err, "Invalid chaos flags")
+		os.Exit(1)
+	}
+	if chaos.Enabled() {
+		setupLog.Info("CHAOS MODE ENABLED: events are dropped, duplicated or delayed on purpose",
+			"dropPercent", chaos.DropPercent, "duplicatePercent", chaos.DuplicatePercent,
+			"delayPercent", chaos.DelayPercent, "delay", chaos.Delay)
+	}
+
 	setupLog.Info("Starting WOL Agent",
 		"node", nodeName,
 		"operator", operatorAddr,
 		"port", port,
-		"version", "v0.0.1")
+		"version", wol.Version)
 
 	// Context con signal handling per graceful shutdown
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
@@ -83,6 +287,57 @@ func main() {
 
 	// Crea e avvia agent
 	agent := wol.NewAgent(port, nodeName, operatorAddr, setupLog)
+	agent.SetWolConfigName(wolConfigName)
+	agent.SetARPWake(arpWake)
+	agent.SetARPRespond(arpRespond)
+	agent.SetWakeTriggers(triggerICMP, triggerTCPPorts, triggerDNS)
+	agent.SetSleepEtherType(sleepType)
+	if directedWake {
+		agent.SetDirectedWakePorts(ports)
+	}
+	agent.SetRawCapture(rawUDPPorts, rawEtherTypes)
+	agent.SetVLANs(vlans)
+	agent.SetWatchLinks(watchInterfaces)
+	agent.SetPromiscuous(promiscuous)
+	agent.SetCaptureBackend(backend)
+	agent.SetStreamEvents(streamEvents)
+	agent.SetIPFamilies(ipv4, ipv6)
+	agent.SetEnableRawWoL(rawMode)
+	agent.SetEnableUDP(udpMode)
+	agent.SetDrainTimeout(drainTimeout)
+	agent.SetEventBuffer(eventBufferSize, eventBufferMaxAge)
+	agent.SetReportQueue(reportWorkers, reportQueueSize)
+	agent.SetPacketLogging(packetLogRate, redactPacketLogs)
+	agent.SetUDPReadBuffer(udpReadBuffer)
+	agent.SetRawReadBuffer(rawReadBuffer)
+	agent.SetReceiveTimeout(recvTimeout)
+	agent.SetDedupeCleanupInterval(dedupeCleanup)
+	agent.SetDedupeWindow(dedupeWindow)
+	agent.SetMetricsPort(metricsPort)
+	agent.SetChaos(chaos)
+	agent.SetInterfaceRules(interfaceRules)
+	agent.SetMACFilter(macFilter)
+	agent.SetConfigSync(configSync, agentConfig != nil && !agentConfig.Interfaces.IsZero())
+
+	if tlsFiles.Enabled() {
+		tlsConfig, err := wol.NewClientTLSConfig(ctx, tlsFiles)
+		if err != nil {
+			setupLog.Error(err, "Failed to configure TLS to the operator")
+			os.Exit(1)
+		}
+		agent.SetTLS(tlsConfig)
+	}
+	agent.SetTokenFile(tokenFile)
+
+	if agentConfig != nil {
+		go wol.WatchAgentConfigFile(ctx, configPath, agentConfig, wol.DefaultAgentConfigPollInterval,
+			func(next *wol.AgentConfigFile) {
+				reloadAgentConfig(agent, agentConfig, next, logLevel, explicit)
+			},
+			func(err error) {
+				setupLog.Error(err, "Ignoring agent config change", "config", configPath)
+			})
+	}
 
 	if err := agent.Start(ctx); err != nil {
 		setupLog.Error(err, "Agent failed to start")
@@ -92,6 +347,31 @@ func main() {
 	setupLog.Info("Agent stopped gracefully")
 }
 
+// reloadAgentConfig applies the settings of a changed config file that can be
+// updated on the fly; flags set on the command line still take precedence
+func reloadAgentConfig(agent *wol.Agent, startup, next *wol.AgentConfigFile,
+	logLevel uberzap.AtomicLevel, explicit map[string]bool) {
+	if !explicit["zap-log-level"] {
+		level := zapcore.InfoLevel
+		if next.LogLevel != "" {
+			level, _ = wol.ParseLogLevel(next.LogLevel)
+		}
+		logLevel.SetLevel(level)
+	}
+	if !explicit["dedupe-window"] {
+		window := wol.DefaultDedupeWindow
+		if next.DedupeWindow != nil {
+			window = next.DedupeWindow.Duration
+		}
+		agent.SetDedupeWindow(window)
+	}
+
+	setupLog.Info("Agent config reloaded", "logLevel", logLevel.String())
+	if startup.RestartRequired(next) {
+		setupLog.Info("Agent config changed settings that are only applied at startup, restart the agent to use them")
+	}
+}
+
 func parsePorts(portsStr string) ([]int, error) {
 	parts := strings.Split(portsStr, ",")
 	ports := make([]int, 0, len(parts))
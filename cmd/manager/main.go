@@ -17,33 +17,46 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
+	// Time zones of the WolPolicy quiet hours, the image has no zoneinfo
+	_ "time/tzdata"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	kubevirtv1 "kubevirt.io/api/core/v1"
+	poolv1alpha1 "kubevirt.io/api/pool/v1alpha1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	apiv1 "github.com/gpillon/kubevirt-wol/api/v1"
 	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
 	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
 	"github.com/gpillon/kubevirt-wol/internal/controller"
+	"github.com/gpillon/kubevirt-wol/internal/migration"
+	webhookwolv1beta1 "github.com/gpillon/kubevirt-wol/internal/webhook/v1beta1"
 	"github.com/gpillon/kubevirt-wol/internal/wol"
 	// +kubebuilder:scaffold:imports
 )
@@ -53,11 +66,20 @@ var (
 	setupLog = ctrl.Log.WithName("setup")
 )
 
+// wakeDemandOnMetricsServer is the --wake-demand-bind-address value that serves
+// the wake demand on the metrics server
+const wakeDemandOnMetricsServer = "metrics"
+
+// serviceAccountNamespaceFile holds the namespace of the pod when POD_NAMESPACE is unset
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(wolv1beta1.AddToScheme(scheme))
+	utilruntime.Must(apiv1.AddToScheme(scheme))
 	utilruntime.Must(kubevirtv1.AddToScheme(scheme))
+	utilruntime.Must(poolv1alpha1.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -69,7 +91,20 @@ func main() {
 	var enableHTTP2 bool
 	var tlsOpts []func(*tls.Config)
 	var metricsCertPath, metricsCertName, metricsCertKey string
+	var wakeDemandAddr string
+	var wakeDemandWindow time.Duration
+	var saturationThresholds wol.SaturationThresholds
 	var webhookCertPath, webhookCertName, webhookCertKey string
+	var relayAddr, relayCertPath, relayCertName, relayCertKey string
+	var apiAddr, apiCertPath, apiCertName, apiCertKey string
+	var grpcCertPath, grpcCertName, grpcCertKey, grpcClientCAName, agentTLSSecret string
+	var grpcTokenAudience string
+	var nodeVerification string
+	var chaos wol.ChaosOptions
+	var retry wol.RetryOptions
+	var startLimit wol.StartLimitOptions
+	var dedupeBackend string
+	var migrateStorageVersion bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
@@ -92,6 +127,77 @@ func main() {
 		"The name of the metrics server certificate file.")
 	flag.StringVar(&metricsCertKey, "metrics-cert-key", "tls.key",
 		"The name of the metrics server key file.")
+	flag.StringVar(&wakeDemandAddr, "wake-demand-bind-address", "0",
+		"The address the wake demand endpoint (for KEDA or other autoscalers) binds to, e.g. :8082. "+
+			"The standalone endpoint is unauthenticated; use \""+wakeDemandOnMetricsServer+"\" to serve it "+
+			"on the metrics server instead, behind its authentication. Leave as 0 to disable it.")
+	flag.DurationVar(&wakeDemandWindow, "wake-demand-window", wol.DefaultWakeDemandWindow,
+		"Sliding window of the wake demand endpoint.")
+	flag.IntVar(&saturationThresholds.DedupeEntries, "saturation-dedupe-entries", wol.DefaultDedupeSaturation,
+		"Number of global dedupe entries above which WolConfigs are marked Degraded. 0 disables the check.")
+	flag.IntVar(&saturationThresholds.PendingStarts, "saturation-pending-starts", wol.DefaultStartSaturation,
+		"Number of pending VM starts above which WolConfigs are marked Degraded. 0 disables the check.")
+	flag.IntVar(&saturationThresholds.InFlightEvents, "saturation-inflight-events", wol.DefaultEventSaturation,
+		"Number of agent events processed concurrently by the gRPC server above which WolConfigs are marked Degraded. "+
+			"0 disables the check.")
+	flag.IntVar(&retry.MaxAttempts, "wake-retry-attempts", wol.DefaultWakeRetryAttempts,
+		"Number of retries of a VM start failed with a transient error (throttling, apiserver or webhook "+
+			"unavailable). The queued starts are attempted once more on shutdown. 0 disables the retries.")
+	flag.DurationVar(&retry.BaseDelay, "wake-retry-base-delay", wol.DefaultWakeRetryBaseDelay,
+		"Delay before the first retry of a failed VM start, doubled at each retry.")
+	flag.DurationVar(&retry.MaxDelay, "wake-retry-max-delay", wol.DefaultWakeRetryMaxDelay,
+		"Maximum delay between two retries of a failed VM start.")
+	flag.IntVar(&startLimit.MaxConcurrentStarts, "max-concurrent-starts", wol.DefaultMaxConcurrentStarts,
+		"Number of VM starts in progress at the same time, across the WolConfigs, so a broadcast of many MACs "+
+			"cannot overload the KubeVirt API. 0 disables the limit.")
+	flag.IntVar(&startLimit.StartsPerMinute, "starts-per-minute", 0,
+		"Rate of the VM starts, across the WolConfigs, with bursts of up to --max-concurrent-starts. "+
+			"0 disables the limit.")
+	flag.DurationVar(&startLimit.QueueTimeout, "start-queue-timeout", wol.DefaultStartQueueTimeout,
+		"How long a VM start over --max-concurrent-starts or --starts-per-minute waits before being "+
+			"rejected with RATE_LIMITED.")
+	flag.StringVar(&dedupeBackend, "dedupe-backend", wol.DedupeBackendMemory,
+		"Where the manager settles duplicate WOL events: \""+wol.DedupeBackendMemory+"\" (the leader alone serves "+
+			"the agents) or \""+wol.DedupeBackendLease+"\" (every replica serves them, claiming each event with a "+
+			"Lease in the manager namespace so only one replica starts the VM).")
+	flag.StringVar(&relayAddr, "relay-bind-address", "0",
+		"The address the gRPC endpoint for external relays binds to, e.g. :9443. Relays authenticate with the "+
+			"tokens provisioned in the WolConfigs and can only report events and listeners. Leave as 0 to disable it.")
+	flag.StringVar(&relayCertPath, "relay-cert-path", "",
+		"The directory that contains the relay endpoint certificate (required by --relay-bind-address).")
+	flag.StringVar(&relayCertName, "relay-cert-name", "tls.crt", "The name of the relay endpoint certificate file.")
+	flag.StringVar(&relayCertKey, "relay-cert-key", "tls.key", "The name of the relay endpoint key file.")
+	flag.StringVar(&apiAddr, "api-bind-address", "0",
+		"The address the REST API (manual wakes, MAC mappings) binds to, e.g. :8444. Callers authenticate "+
+			"with a Kubernetes bearer token. Leave as 0 to disable it.")
+	flag.StringVar(&apiCertPath, "api-cert-path", "",
+		"The directory that contains the REST API certificate (required by --api-bind-address).")
+	flag.StringVar(&apiCertName, "api-cert-name", "tls.crt", "The name of the REST API certificate file.")
+	flag.StringVar(&apiCertKey, "api-cert-key", "tls.key", "The name of the REST API key file.")
+	flag.StringVar(&grpcCertPath, "grpc-cert-path", "",
+		"The directory that contains the certificate of the agent gRPC server (port 9090). When set, the server "+
+			"requires mutual TLS: agents and activators must present a certificate signed by the client CA.")
+	flag.StringVar(&grpcCertName, "grpc-cert-name", "tls.crt", "The name of the agent gRPC server certificate file.")
+	flag.StringVar(&grpcCertKey, "grpc-cert-key", "tls.key", "The name of the agent gRPC server key file.")
+	flag.StringVar(&grpcClientCAName, "grpc-client-ca-name", "ca.crt",
+		"The name of the CA file, in --grpc-cert-path, that signs the agent client certificates.")
+	flag.StringVar(&agentTLSSecret, "agent-tls-secret", "",
+		"Secret (tls.crt, tls.key, ca.crt) in the operator namespace mounted into the agent DaemonSets as the "+
+			"client certificate for the agent gRPC server. Required by --grpc-cert-path for the agents to connect.")
+	flag.StringVar(&grpcTokenAudience, "grpc-token-audience", "",
+		"When set, the agent gRPC server requires a ServiceAccount token for this audience (e.g. "+
+			wol.DefaultTokenAudience+"), checked with a TokenReview, in addition to or instead of mutual TLS. "+
+			"The agents get a projected token: they can only report for their node, and the pods outside the "+
+			"operator namespace (activators) can only wake the VMs of their namespace.")
+	flag.StringVar(&nodeVerification, "node-verification", wol.NodeVerificationFlag,
+		"How the node reported with a WOL event is checked against its sender: the node of its ServiceAccount "+
+			"token, or else one of the addresses of the Node matching the source IP of the connection. "+
+			"\""+wol.NodeVerificationFlag+"\" logs and counts the mismatches in wol_spoofed_events_total, "+
+			"\""+wol.NodeVerificationReject+"\" also rejects the events, \""+wol.NodeVerificationOff+"\" disables the check.")
+	flag.BoolVar(&migrateStorageVersion, "migrate-storage-version", false,
+		"Rewrite the stored WolConfigs in the storage version of the CRD, drop the old versions from its "+
+			"storedVersions and exit, instead of running the manager. Run by the config/migration Job on upgrade.")
+	wol.BindManagerChaosFlags(flag.CommandLine, &chaos)
 	opts := zap.Options{
 		Development: false,
 	}
@@ -100,6 +206,20 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if migrateStorageVersion {
+		migrationClient, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+		if err != nil {
+			setupLog.Error(err, "unable to create client")
+			os.Exit(1)
+		}
+		migrator := &migration.StorageVersionMigrator{Client: migrationClient, Log: ctrl.Log.WithName("migration")}
+		if err := migrator.Migrate(ctrl.SetupSignalHandler(), migration.WolConfigCRD); err != nil {
+			setupLog.Error(err, "storage version migration failed")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -157,6 +277,16 @@ func main() {
 		TLSOpts: tlsOpts,
 	}
 
+	// The wake demand can be served by the metrics server, which applies the
+	// same authn/authz filter as /metrics when --metrics-secure is set
+	var wakeDemand *wol.WakeDemand
+	if wakeDemandAddr != "0" {
+		wakeDemand = wol.NewWakeDemand(wakeDemandWindow)
+	}
+	if wakeDemand != nil && wakeDemandAddr == wakeDemandOnMetricsServer {
+		metricsServerOptions.ExtraHandlers = map[string]http.Handler{"/wake-demand": wakeDemand}
+	}
+
 	if secureMetrics {
 		// FilterProvider is used to protect the metrics endpoint with authn/authz.
 		// These configurations ensure that only authorized users and service accounts
@@ -220,8 +350,27 @@ func main() {
 		setupLog.Info("Using agent image from environment", "image", agentImage)
 	}
 
-	// Get operator namespace from environment variable (set via downward API)
+	if dedupeBackend != wol.DedupeBackendMemory && dedupeBackend != wol.DedupeBackendLease {
+		setupLog.Error(nil, "Invalid --dedupe-backend", "backend", dedupeBackend)
+		os.Exit(1)
+	}
+	switch nodeVerification {
+	case wol.NodeVerificationOff, wol.NodeVerificationFlag, wol.NodeVerificationReject:
+	default:
+		setupLog.Error(nil, "Invalid --node-verification", "mode", nodeVerification)
+		os.Exit(1)
+	}
+	// With a shared dedupe, the aggregator runs on every replica instead of the leader only
+	allReplicas := dedupeBackend == wol.DedupeBackendLease
+
+	// Get operator namespace from environment variable (set via downward API),
+	// or from the namespace of the pod's ServiceAccount token
 	operatorNamespace := os.Getenv("POD_NAMESPACE")
+	if operatorNamespace == "" {
+		if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+			operatorNamespace = strings.TrimSpace(string(data))
+		}
+	}
 	if operatorNamespace == "" {
 		setupLog.Info("POD_NAMESPACE not set, will use default namespace")
 	} else {
@@ -230,25 +379,175 @@ func main() {
 
 	// Create MAC mapper
 	mapper := wol.NewMACMapper(mgr.GetClient(), ctrl.Log.WithName("mapper"))
+	// SecureOn password Secrets are read directly, without caching every Secret in the cluster
+	mapper.SetSecretReader(mgr.GetAPIReader())
+	// VM changes update their MACs right away, between the periodic full refreshes
+	if err := mgr.Add(aggregatorRunnable(allReplicas, func(ctx context.Context) error {
+		return mapper.WatchVMs(ctx, mgr.GetCache())
+	})); err != nil {
+		setupLog.Error(err, "unable to add the VirtualMachine watch of the MAC mapper")
+		os.Exit(1)
+	}
+	if allReplicas {
+		// The reconcilers only run on the leader: the standby replicas refresh
+		// their mapping on their own until they are elected
+		if err := mgr.Add(aggregatorRunnable(allReplicas, func(ctx context.Context) error {
+			mapper.FollowConfigs(ctx, wol.StandbyRefreshInterval, mgr.Elected())
+			return nil
+		})); err != nil {
+			setupLog.Error(err, "unable to add the standby refresh of the MAC mapper")
+			os.Exit(1)
+		}
+	}
 
 	// Create VM starter
 	vmStarter := wol.NewVMStarter(mgr.GetClient(), ctrl.Log.WithName("vmstarter"))
+	// Allow WolConfigs to bound VM starts to the RBAC of their own ServiceAccount
+	vmStarter.EnableImpersonation(mgr.GetConfig(), mgr.GetScheme())
+	// The wakes queued until their VM settles are dropped on shutdown, and the
+	// manager waits for them to stop polling the API server
+	if err := mgr.Add(aggregatorRunnable(allReplicas, func(ctx context.Context) error {
+		vmStarter.Run(ctx)
+		return nil
+	})); err != nil {
+		setupLog.Error(err, "unable to add the queued wakes of the VM starter")
+		os.Exit(1)
+	}
 
 	// Create WOL aggregator (gRPC server)
 	aggregator := wol.NewAggregator(mapper, vmStarter, ctrl.Log.WithName("aggregator"))
+	aggregator.SetSaturationThresholds(saturationThresholds)
+	if err := retry.Validate(); err != nil {
+		setupLog.Error(err, "Invalid wake retry flags")
+		os.Exit(1)
+	}
+	aggregator.SetRetry(retry)
+	if err := startLimit.Validate(); err != nil {
+		setupLog.Error(err, "Invalid start limit flags")
+		os.Exit(1)
+	}
+	aggregator.SetStartLimit(startLimit)
+	// The VMs started for a wake are followed until Running (wol_wake_to_running_seconds)
+	if err := mgr.Add(aggregatorRunnable(allReplicas, func(ctx context.Context) error {
+		return aggregator.WatchWakes(ctx, mgr.GetCache())
+	})); err != nil {
+		setupLog.Error(err, "unable to add the wake latency watch")
+		os.Exit(1)
+	}
+	// The manager waits for the runnables on shutdown: the queued starts are drained
+	if err := mgr.Add(aggregatorRunnable(allReplicas, func(ctx context.Context) error {
+		aggregator.RunRetries(ctx)
+		return nil
+	})); err != nil {
+		setupLog.Error(err, "unable to add the wake retry queue")
+		os.Exit(1)
+	}
+	if err := chaos.Validate(); err != nil {
+		setupLog.Error(err, "Invalid chaos flags")
+		os.Exit(1)
+	}
+	if chaos.Enabled() {
+		setupLog.Info("CHAOS MODE ENABLED: VM starts fail on purpose", "startFailurePercent", chaos.StartFailurePercent)
+		aggregator.SetChaos(chaos)
+	}
+	aggregator.SetNodeReader(mgr.GetClient())
+	aggregator.SetNodeVerification(nodeVerification)
+	aggregator.SetEventRecorder(mgr.GetEventRecorderFor("kubevirt-wol"))
+	// The WolPolicies of the VM namespaces choose the action of each wake
+	aggregator.SetPolicyEvaluator(wol.NewPolicyEvaluator(mgr.GetClient(), ctrl.Log.WithName("policy")))
+	// The replicas serving the agents write the audit records of their own decisions
+	auditor := wol.NewAuditor(mapper, ctrl.Log.WithName("audit"))
+	aggregator.SetAuditor(auditor)
+	if err := mgr.Add(aggregatorRunnable(allReplicas, func(ctx context.Context) error {
+		auditor.Run(ctx)
+		return nil
+	})); err != nil {
+		setupLog.Error(err, "unable to add the audit sinks")
+		os.Exit(1)
+	}
+
+	if wakeDemand != nil {
+		aggregator.SetWakeDemand(wakeDemand)
+	}
+	if allReplicas {
+		if operatorNamespace == "" {
+			setupLog.Error(nil, "POD_NAMESPACE is required by --dedupe-backend="+wol.DedupeBackendLease)
+			os.Exit(1)
+		}
+		identity, err := os.Hostname()
+		if err != nil {
+			setupLog.Error(err, "Failed to get the replica identity for the dedupe Leases")
+			os.Exit(1)
+		}
+		// The claims must see the Leases just created by the other replicas: no cache
+		leaseClient, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme()})
+		if err != nil {
+			setupLog.Error(err, "Failed to create the client of the dedupe Leases")
+			os.Exit(1)
+		}
+		aggregator.SetSharedDedupe(wol.NewLeaseDedupe(leaseClient, operatorNamespace, identity,
+			ctrl.Log.WithName("dedupe")))
+		setupLog.Info("Shared dedupe enabled, every replica serves the agents", "namespace", operatorNamespace,
+			"identity", identity)
+	}
+
+	// Agents too far from the manager version trigger a new image drift check
+	var imageRecheck chan struct{}
+	var onVersionSkew func()
+	if agentImage != "" {
+		imageRecheck = make(chan struct{}, 1)
+		onVersionSkew = func() {
+			select {
+			case imageRecheck <- struct{}{}:
+			default: // a check is already pending
+			}
+		}
+	}
+
+	// On OpenShift the controller creates the SCC of the agents
+	openShift, err := controller.IsOpenShift(mgr.GetRESTMapper())
+	if err != nil {
+		setupLog.Error(err, "unable to detect OpenShift, not managing the agent SecurityContextConstraints")
+	} else if openShift {
+		setupLog.Info("OpenShift detected, managing the agent SecurityContextConstraints", "scc", controller.AgentSCCName)
+	}
 
 	// Setup controller with WOL components (using Aggregator for gRPC)
 	if err = (&controller.WolConfigReconciler{
-		Client:            mgr.GetClient(),
-		Scheme:            mgr.GetScheme(),
-		Mapper:            mapper,
-		VMStarter:         vmStarter,
-		AgentImage:        agentImage,        // Pass agent image from environment
-		OperatorNamespace: operatorNamespace, // Pass operator namespace from environment
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		Mapper:             mapper,
+		VMStarter:          vmStarter,
+		Aggregator:         aggregator,
+		AgentImage:         agentImage,        // Pass agent image from environment
+		OperatorNamespace:  operatorNamespace, // Pass operator namespace from environment
+		AgentTLSSecret:     agentTLSSecret,
+		AgentTokenAudience: grpcTokenAudience,
+		OpenShift:          openShift,
+		OnVersionSkew:      onVersionSkew,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "WolConfig")
 		os.Exit(1)
 	}
+	if err = (&controller.WolScheduleReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		VMs:      vmStarter,
+		Recorder: mgr.GetEventRecorderFor("kubevirt-wol"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "WolSchedule")
+		os.Exit(1)
+	}
+	// The validating webhook needs a serving certificate: it is enabled by
+	// config/default/manager_webhook_patch.yaml, which sets ENABLE_WEBHOOKS=true.
+	// It also serves /convert, the conversion webhook between v1beta1 and v1
+	// that the API server calls for every WolConfig stored in v1
+	if os.Getenv("ENABLE_WEBHOOKS") == "true" {
+		if err = webhookwolv1beta1.SetupWolConfigWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "WolConfig")
+			os.Exit(1)
+		}
+	}
 
 	// Add startup reconciler to check and update DaemonSets if image doesn't match
 	if agentImage != "" {
@@ -257,6 +556,7 @@ func main() {
 			AgentImage:        agentImage,
 			OperatorNamespace: operatorNamespace,
 			Log:               ctrl.Log.WithName("startup-reconciler"),
+			Recheck:           imageRecheck,
 		}
 		if err := mgr.Add(startupReconciler); err != nil {
 			setupLog.Error(err, "unable to add startup reconciler")
@@ -296,38 +596,197 @@ func main() {
 	// Setup context for graceful shutdown
 	ctx := ctrl.SetupSignalHandler()
 
+	// The aggregator and its endpoints only run on the leader, unless a shared
+	// dedupe lets every replica serve the agents: the MAC mapping is built by
+	// the leader's reconcilers, and a replica not listening sends the agents
+	// (through the Service) to the leader. The status updates stay on the leader.
 	// Start aggregator cleanup routine
-	go aggregator.StartCleanup(ctx)
+	if err := mgr.Add(aggregatorRunnable(allReplicas, func(ctx context.Context) error {
+		aggregator.StartCleanup(ctx)
+		return nil
+	})); err != nil {
+		setupLog.Error(err, "unable to add the aggregator cleanup")
+		os.Exit(1)
+	}
+	idleMonitor := wol.NewIdleMonitor(mapper, vmStarter, ctrl.Log.WithName("idle"))
+	idleMonitor.SetEventRecorder(mgr.GetEventRecorderFor("kubevirt-wol"))
+	for name, loop := range map[string]func(context.Context){
+		// Export the aggregator saturation and mark WolConfigs Degraded when overloaded
+		"saturation monitor": aggregator.MonitorSaturation,
+		// Export the connected agents and mark WolConfigs AgentDegraded on stale agents
+		"agent monitor": aggregator.MonitorAgents,
+		// Wake the VMs on the messages of the MQTT triggers. Leader only: the
+		// replicas would share the client ID
+		"MQTT triggers": wol.NewMQTTTriggers(mapper, aggregator, ctrl.Log.WithName("mqtt")).Run,
+		// Stop or pause the VMs idle past the idleShutdown of their WolConfig
+		"idle monitor": idleMonitor.Run,
+	} {
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			loop(ctx)
+			return nil
+		})); err != nil {
+			setupLog.Error(err, "unable to add the "+name)
+			os.Exit(1)
+		}
+	}
 
 	// Start gRPC server for receiving WOL events from agents
 	grpcPort := 9090
-	grpcServer := grpc.NewServer(
+	grpcOpts := []grpc.ServerOption{
 		grpc.MaxRecvMsgSize(1024*1024),
 		grpc.MaxSendMsgSize(1024*1024),
-	)
+	}
+	if grpcCertPath != "" {
+		grpcCertWatcher, err := certwatcher.New(
+			filepath.Join(grpcCertPath, grpcCertName),
+			filepath.Join(grpcCertPath, grpcCertKey),
+		)
+		if err != nil {
+			setupLog.Error(err, "Failed to initialize agent gRPC certificate watcher")
+			os.Exit(1)
+		}
+		if err := mgr.Add(grpcCertWatcher); err != nil {
+			setupLog.Error(err, "Unable to add agent gRPC certificate watcher to manager")
+			os.Exit(1)
+		}
+		grpcTLS, err := wol.NewServerMTLSConfig(grpcCertWatcher.GetCertificate,
+			filepath.Join(grpcCertPath, grpcClientCAName))
+		if err != nil {
+			setupLog.Error(err, "Failed to configure mutual TLS for the agent gRPC server")
+			os.Exit(1)
+		}
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(grpcTLS)))
+		if agentTLSSecret == "" {
+			setupLog.Info("WARNING: --grpc-cert-path is set without --agent-tls-secret, " +
+				"the agent DaemonSets will not be able to connect")
+		}
+	} else if grpcTokenAudience == "" {
+		setupLog.Info("WARNING: the agent gRPC server is plaintext and unauthenticated, " +
+			"use --grpc-cert-path to require mutual TLS or --grpc-token-audience to require ServiceAccount tokens")
+	}
+	if grpcTokenAudience != "" {
+		if operatorNamespace == "" {
+			setupLog.Error(nil, "POD_NAMESPACE is required by --grpc-token-audience")
+			os.Exit(1)
+		}
+		// Il nodo dei pod è letto direttamente, senza una cache di tutti i pod del cluster
+		tokenAuth := wol.NewTokenAuthenticator(mgr.GetClient(), mgr.GetAPIReader(), grpcTokenAudience,
+			operatorNamespace, ctrl.Log.WithName("token-auth"))
+		grpcOpts = append(grpcOpts,
+			grpc.UnaryInterceptor(tokenAuth.UnaryInterceptor),
+			grpc.StreamInterceptor(tokenAuth.StreamInterceptor),
+		)
+	}
+	grpcServer := grpc.NewServer(grpcOpts...)
 	wolv1.RegisterWOLServiceServer(grpcServer, aggregator)
+	// Health e reflection standard, per i probe gRPC e grpcurl
+	wol.RegisterStandardServices(grpcServer)
 
-	go func() {
-		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", grpcPort))
+	if err := addGRPCServer(mgr, allReplicas, "gRPC server for WOL events", fmt.Sprintf(":%d", grpcPort), grpcServer,
+		aggregator.SetServing, "mtls", grpcCertPath != "", "tokenAuth", grpcTokenAudience != ""); err != nil {
+		setupLog.Error(err, "Unable to add the gRPC server to manager")
+		os.Exit(1)
+	}
+
+	// Start the TLS gRPC endpoint for external relays, authenticated by token
+	if relayAddr != "0" {
+		if relayCertPath == "" {
+			setupLog.Error(nil, "--relay-cert-path is required by --relay-bind-address: relay tokens are only sent over TLS")
+			os.Exit(1)
+		}
+		relayCertWatcher, err := certwatcher.New(
+			filepath.Join(relayCertPath, relayCertName),
+			filepath.Join(relayCertPath, relayCertKey),
+		)
 		if err != nil {
-			setupLog.Error(err, "Failed to listen for gRPC", "port", grpcPort)
+			setupLog.Error(err, "Failed to initialize relay certificate watcher")
+			os.Exit(1)
+		}
+		if err := mgr.Add(relayCertWatcher); err != nil {
+			setupLog.Error(err, "Unable to add relay certificate watcher to manager")
 			os.Exit(1)
 		}
 
-		setupLog.Info("Starting gRPC server for WOL events", "port", grpcPort)
+		relayServer := grpc.NewServer(
+			grpc.Creds(credentials.NewTLS(&tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: relayCertWatcher.GetCertificate,
+			})),
+			grpc.MaxRecvMsgSize(1024*1024),
+			grpc.MaxSendMsgSize(1024*1024),
+			grpc.UnaryInterceptor(aggregator.RelayUnaryInterceptor),
+			grpc.StreamInterceptor(aggregator.RelayStreamInterceptor),
+		)
+		wolv1.RegisterWOLServiceServer(relayServer, aggregator)
 
-		if err := grpcServer.Serve(lis); err != nil {
-			setupLog.Error(err, "gRPC server failed")
+		if err := addGRPCServer(mgr, allReplicas, "gRPC endpoint for relays", relayAddr, relayServer, nil); err != nil {
+			setupLog.Error(err, "Unable to add the relay gRPC server to manager")
 			os.Exit(1)
 		}
-	}()
+	}
 
-	// Graceful shutdown for gRPC server
-	go func() {
-		<-ctx.Done()
-		setupLog.Info("Shutting down gRPC server...")
-		grpcServer.GracefulStop()
-	}()
+	// Start the REST API for manual wakes and mapping inspection, authenticated
+	// by TokenReview and authorized by SubjectAccessReview
+	if apiAddr != "0" {
+		if apiCertPath == "" {
+			setupLog.Error(nil, "--api-cert-path is required by --api-bind-address: bearer tokens are only sent over TLS")
+			os.Exit(1)
+		}
+		apiCertWatcher, err := certwatcher.New(
+			filepath.Join(apiCertPath, apiCertName),
+			filepath.Join(apiCertPath, apiCertKey),
+		)
+		if err != nil {
+			setupLog.Error(err, "Failed to initialize REST API certificate watcher")
+			os.Exit(1)
+		}
+		if err := mgr.Add(apiCertWatcher); err != nil {
+			setupLog.Error(err, "Unable to add REST API certificate watcher to manager")
+			os.Exit(1)
+		}
+
+		apiServer := &http.Server{
+			Addr: apiAddr,
+			Handler: wol.NewAPIServer(mapper, aggregator, wol.NewAccessReviewer(mgr.GetClient()),
+				ctrl.Log.WithName("api")).Handler(),
+			ReadHeaderTimeout: 5 * time.Second,
+			TLSConfig: &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: apiCertWatcher.GetCertificate,
+			},
+		}
+
+		if err := addHTTPServer(mgr, allReplicas, "REST API", apiServer); err != nil {
+			setupLog.Error(err, "Unable to add the REST API to manager")
+			os.Exit(1)
+		}
+	}
+
+	if wakeDemand != nil {
+		if err := mgr.Add(aggregatorRunnable(allReplicas, func(ctx context.Context) error {
+			wakeDemand.StartCleanup(ctx)
+			return nil
+		})); err != nil {
+			setupLog.Error(err, "unable to add the wake demand cleanup")
+			os.Exit(1)
+		}
+	}
+
+	// Start the standalone wake demand endpoint for KEDA (metrics-api scaler) or other autoscalers
+	if wakeDemand != nil && wakeDemandAddr != wakeDemandOnMetricsServer {
+		mux := http.NewServeMux()
+		mux.Handle("/wake-demand", wakeDemand)
+		demandServer := &http.Server{
+			Addr:              wakeDemandAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+
+		if err := addHTTPServer(mgr, allReplicas, "wake demand endpoint", demandServer, "window", wakeDemandWindow); err != nil {
+			setupLog.Error(err, "Unable to add the wake demand endpoint to manager")
+			os.Exit(1)
+		}
+	}
 
 	setupLog.Info("starting manager",
 		"grpcPort", grpcPort,
@@ -338,3 +797,79 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// everyReplica is a runnable started on every replica, leader or not
+type everyReplica manager.RunnableFunc
+
+// Start implements manager.Runnable
+func (r everyReplica) Start(ctx context.Context) error {
+	return r(ctx)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (everyReplica) NeedLeaderElection() bool {
+	return false
+}
+
+// aggregatorRunnable runs fn once the manager is elected leader (or at once
+// without --leader-elect), or on every replica if allReplicas is set
+func aggregatorRunnable(allReplicas bool, fn manager.RunnableFunc) manager.Runnable {
+	if allReplicas {
+		return everyReplica(fn)
+	}
+	return fn
+}
+
+// addGRPCServer serves server on addr like aggregatorRunnable, and stops it
+// gracefully on shutdown. serving, if not nil, is told when the server
+// starts and stops serving.
+func addGRPCServer(mgr ctrl.Manager, allReplicas bool, name, addr string, server *grpc.Server, serving func(bool), keysAndValues ...any) error {
+	return mgr.Add(aggregatorRunnable(allReplicas, func(ctx context.Context) error {
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for the %s on %s: %w", name, addr, err)
+		}
+		if serving != nil {
+			serving(true)
+			defer serving(false)
+		}
+		go func() {
+			<-ctx.Done()
+			setupLog.Info("Shutting down the " + name)
+			server.GracefulStop()
+		}()
+
+		setupLog.Info("Starting "+name, append([]any{"address", addr}, keysAndValues...)...)
+		if err := server.Serve(lis); err != nil {
+			return fmt.Errorf("%s failed: %w", name, err)
+		}
+		return nil
+	}))
+}
+
+// addHTTPServer serves server (over TLS when it has a TLS config) like
+// aggregatorRunnable, and shuts it down on shutdown
+func addHTTPServer(mgr ctrl.Manager, allReplicas bool, name string, server *http.Server, keysAndValues ...any) error {
+	return mgr.Add(aggregatorRunnable(allReplicas, func(ctx context.Context) error {
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				setupLog.Error(err, "Failed to shutdown the "+name)
+			}
+		}()
+
+		setupLog.Info("Starting "+name, append([]any{"address", server.Addr}, keysAndValues...)...)
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			return fmt.Errorf("%s failed: %w", name, err)
+		}
+		return nil
+	}))
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/gpillon/kubevirt-wol/internal/wol"
+	"github.com/gpillon/kubevirt-wol/pkg/wolclient"
+)
+
+var (
+	setupLog = ctrl.Log.WithName("setup")
+)
+
+func main() {
+	var name string
+	var managerAddr string
+	var tokenFile string
+	var caFile string
+	var serverName string
+	var portsStr string
+	var bindAddr string
+
+	hostname, _ := os.Hostname()
+	flag.StringVar(&name, "name", hostname, "Name of the relay, as provisioned in the WolConfig (defaults to the hostname)")
+	flag.StringVar(&managerAddr, "manager-address", "",
+		"Address of the relay endpoint of the manager (--relay-bind-address), e.g. wol.example.com:9443")
+	flag.StringVar(&tokenFile, "token-file", "",
+		"File holding the relay token (defaults to the WOL_RELAY_TOKEN env var)")
+	flag.StringVar(&caFile, "ca-file", "",
+		"CA bundle that signed the certificate of the relay endpoint (defaults to the system roots)")
+	flag.StringVar(&serverName, "server-name", "",
+		"Name expected in the certificate of the relay endpoint (defaults to the host of --manager-address)")
+	flag.StringVar(&portsStr, "ports", "9", "UDP ports for WOL packets (comma-separated)")
+	flag.StringVar(&bindAddr, "bind-address", "", "Local IPv4 address to listen on (defaults to all interfaces)")
+
+	opts := zap.Options{
+		Development: false,
+	}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	if name == "" || managerAddr == "" {
+		setupLog.Error(nil, "name and manager-address are required")
+		os.Exit(1)
+	}
+
+	token, err := readToken(tokenFile)
+	if err != nil {
+		setupLog.Error(err, "Failed to read the relay token")
+		os.Exit(1)
+	}
+
+	tlsConfig, err := clientTLSConfig(caFile, serverName)
+	if err != nil {
+		setupLog.Error(err, "Failed to configure TLS")
+		os.Exit(1)
+	}
+
+	ports, err := parsePorts(portsStr)
+	if err != nil {
+		setupLog.Error(err, "Failed to parse ports", "portsStr", portsStr)
+		os.Exit(1)
+	}
+
+	client, err := wolclient.New(managerAddr, wolclient.Options{TLS: tlsConfig, Token: token, Source: name})
+	if err != nil {
+		setupLog.Error(err, "Failed to create the manager client")
+		os.Exit(1)
+	}
+	defer func() { _ = client.Close() }()
+
+	setupLog.Info("Starting WOL relay",
+		"name", name,
+		"manager", managerAddr,
+		"ports", ports)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	relay := wol.NewRelay(wol.RelayOptions{Ports: ports, BindAddress: bindAddr, Client: client}, ctrl.Log.WithName("relay"))
+	if err := relay.Start(ctx); err != nil {
+		setupLog.Error(err, "Relay failed to start")
+		os.Exit(1)
+	}
+
+	setupLog.Info("Relay stopped gracefully")
+}
+
+// readToken reads the token from file, or from WOL_RELAY_TOKEN if file is empty.
+// The token is not accepted as a flag, which would expose it in the process list.
+func readToken(file string) (string, error) {
+	token := os.Getenv("WOL_RELAY_TOKEN")
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		token = string(data)
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("no token: use --token-file or the WOL_RELAY_TOKEN env var")
+	}
+	return token, nil
+}
+
+func clientTLSConfig(caFile, serverName string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}
+	if caFile == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return config, nil
+}
+
+func parsePorts(portsStr string) ([]int, error) {
+	parts := strings.Split(portsStr, ",")
+	ports := make([]int, 0, len(parts))
+
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		port, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q: %w", part, err)
+		}
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("port %d out of range (must be 1-65535)", port)
+		}
+		ports = append(ports, port)
+	}
+
+	if len(ports) == 0 {
+		return []int{wol.DefaultWOLPort}, nil
+	}
+
+	return ports, nil
+}
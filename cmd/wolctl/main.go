@@ -0,0 +1,617 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// wolctl is the command line client of kubevirt-wol. Installed as kubectl-wol
+// in the PATH, it also works as a kubectl plugin (kubectl wol ...).
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/gpillon/kubevirt-wol/internal/wol"
+)
+
+const usage = `wolctl - kubevirt-wol command line client
+
+Usage:
+  wolctl mappings [--wolconfig NAME] [--mac MAC]    list the MAC to VM mappings (REST API)
+  wolctl wake MAC | --namespace NS --name VM         wake a VM through the manager (REST API)
+  wolctl send --node NODE | --address IP MAC         send a test magic packet to an agent
+  wolctl wake-external --address IP [--node NODE] MAC
+                                                     wake a machine outside the cluster (REST API)
+  wolctl simulate [--password PW] [--node NODE] MAC  show what a magic packet would do, without waking (REST API)
+  wolctl events [-n NS] [--since 1h] [-f]            show the Events recorded for WOL packets
+
+The REST API commands need the address of the manager API (--api-address or
+WOLCTL_API_ADDRESS, e.g. https://wol.example.com:8444) and a bearer token
+(--token-file, WOLCTL_TOKEN, or the token of the current kubeconfig user).
+Run "wolctl <command> -h" for the flags of a command.
+`
+
+// wolEventReasons are the reasons of the Events recorded by the manager
+var wolEventReasons = []string{
+	wol.EventReasonWoken, wol.EventReasonStopped, wol.EventReasonFailed, wol.EventReasonRejected,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var err error
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "mappings":
+		err = runMappings(ctx, args)
+	case "wake":
+		err = runWake(ctx, args)
+	case "send":
+		err = runSend(ctx, args)
+	case "wake-external":
+		err = runWakeExternal(ctx, args)
+	case "simulate":
+		err = runSimulate(ctx, args)
+	case "events":
+		err = runEvents(ctx, args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// kubeFlags are the flags selecting the cluster
+type kubeFlags struct {
+	kubeconfig string
+	context    string
+}
+
+func (f *kubeFlags) bind(fs *flag.FlagSet) {
+	fs.StringVar(&f.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file (defaults to KUBECONFIG or ~/.kube/config)")
+	fs.StringVar(&f.context, "context", "", "The kubeconfig context to use")
+}
+
+func (f *kubeFlags) restConfig() (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = f.kubeconfig
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules,
+		&clientcmd.ConfigOverrides{CurrentContext: f.context}).ClientConfig()
+}
+
+// apiFlags are the flags of the commands using the manager REST API
+type apiFlags struct {
+	kubeFlags
+	address   string
+	tokenFile string
+	caFile    string
+	insecure  bool
+}
+
+func (f *apiFlags) bind(fs *flag.FlagSet) {
+	f.kubeFlags.bind(fs)
+	fs.StringVar(&f.address, "api-address", os.Getenv("WOLCTL_API_ADDRESS"),
+		"Base URL of the manager REST API (--api-bind-address of the manager), e.g. https://wol.example.com:8444")
+	fs.StringVar(&f.tokenFile, "token-file", "",
+		"File holding the bearer token (defaults to WOLCTL_TOKEN, then to the token of the kubeconfig user)")
+	fs.StringVar(&f.caFile, "ca-file", "", "CA bundle that signed the certificate of the REST API")
+	fs.BoolVar(&f.insecure, "insecure-skip-tls-verify", false, "Do not verify the certificate of the REST API")
+}
+
+// apiClient calls the manager REST API
+type apiClient struct {
+	base  *url.URL
+	token string
+	http  *http.Client
+}
+
+func (f *apiFlags) client() (*apiClient, error) {
+	if f.address == "" {
+		return nil, fmt.Errorf("the address of the REST API is required (--api-address or WOLCTL_API_ADDRESS)")
+	}
+	base, err := url.Parse(strings.TrimSuffix(f.address, "/"))
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid REST API address %q", f.address)
+	}
+	if base.Scheme != "https" {
+		return nil, fmt.Errorf("the REST API address must be https: the bearer token is only sent over TLS")
+	}
+
+	token, err := f.token()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: f.insecure} //nolint:gosec // opt-in flag
+	if f.caFile != "" {
+		pem, err := os.ReadFile(f.caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", f.caFile)
+		}
+	}
+	return &apiClient{
+		base:  base,
+		token: token,
+		http: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+	}, nil
+}
+
+// token returns the bearer token from --token-file, WOLCTL_TOKEN or the kubeconfig.
+// The token is not accepted as a flag, which would expose it in the process list.
+func (f *apiFlags) token() (string, error) {
+	token := os.Getenv("WOLCTL_TOKEN")
+	if f.tokenFile != "" {
+		data, err := os.ReadFile(f.tokenFile)
+		if err != nil {
+			return "", err
+		}
+		token = string(data)
+	}
+	if token = strings.TrimSpace(token); token != "" {
+		return token, nil
+	}
+
+	if config, err := f.restConfig(); err == nil {
+		if config.BearerToken != "" {
+			return config.BearerToken, nil
+		}
+		if config.BearerTokenFile != "" {
+			if data, err := os.ReadFile(config.BearerTokenFile); err == nil && len(strings.TrimSpace(string(data))) > 0 {
+				return strings.TrimSpace(string(data)), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no bearer token: use --token-file, WOLCTL_TOKEN or a kubeconfig user with a token " +
+		"(e.g. WOLCTL_TOKEN=$(kubectl create token <serviceaccount>))")
+}
+
+// do sends a request to the REST API, with body as JSON if not nil, and
+// decodes the JSON response into out
+func (c *apiClient) do(ctx context.Context, method, path string, query url.Values, body, out any) (int, error) {
+	target := *c.base
+	target.Path += path
+	target.RawQuery = query.Encode()
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), reqBody)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusConflict {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Error != "" {
+			return resp.StatusCode, fmt.Errorf("%s (HTTP %d)", apiErr.Error, resp.StatusCode)
+		}
+		return resp.StatusCode, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return resp.StatusCode, fmt.Errorf("unexpected response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
+
+func runMappings(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("mappings", flag.ContinueOnError)
+	var api apiFlags
+	api.bind(fs)
+	configName := fs.String("wolconfig", "", "Only the mappings of this WolConfig")
+	mac := fs.String("mac", "", "Only the mapping of this MAC address")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	client, err := api.client()
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	if *configName != "" {
+		query.Set("wolconfig", *configName)
+	}
+	if *mac != "" {
+		query.Set("mac", *mac)
+	}
+	var mappings []struct {
+		MAC         string `json:"mac"`
+		Namespace   string `json:"namespace"`
+		Name        string `json:"name"`
+		WolConfig   string `json:"wolConfig"`
+		MappingType string `json:"mappingType"`
+	}
+	if _, err := client.do(ctx, http.MethodGet, "/api/v1/mappings", query, nil, &mappings); err != nil {
+		return err
+	}
+	if len(mappings) == 0 {
+		fmt.Println("No mappings found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "MAC\tNAMESPACE\tVM\tWOLCONFIG\tTYPE")
+	for _, m := range mappings {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", m.MAC, m.Namespace, m.Name, m.WolConfig, m.MappingType)
+	}
+	return w.Flush()
+}
+
+func runWake(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("wake", flag.ContinueOnError)
+	var api apiFlags
+	api.bind(fs)
+	namespace := fs.String("namespace", "", "Namespace of the VM (with --name, instead of a MAC)")
+	name := fs.String("name", "", "Name of the VM (with --namespace, instead of a MAC)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	switch {
+	case fs.NArg() == 1 && *name == "":
+		query.Set("mac", fs.Arg(0))
+	case fs.NArg() == 0 && *namespace != "" && *name != "":
+		query.Set("namespace", *namespace)
+		query.Set("name", *name)
+	default:
+		return fmt.Errorf("give either a MAC address or --namespace and --name")
+	}
+	client, err := api.client()
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Status    string `json:"status"`
+		Message   string `json:"message"`
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	}
+	code, err := client.do(ctx, http.MethodPost, "/api/v1/wake", query, nil, &result)
+	if err != nil {
+		return err
+	}
+	if result.Name != "" {
+		fmt.Printf("VM %s/%s: ", result.Namespace, result.Name)
+	}
+	fmt.Printf("%s\n%s\n", result.Status, result.Message)
+	if code >= http.StatusBadRequest {
+		return fmt.Errorf("wake refused (%s)", result.Status)
+	}
+	return nil
+}
+
+func runSend(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	var kube kubeFlags
+	kube.bind(fs)
+	node := fs.String("node", "", "Node to send the packet to (its InternalIP), where the agent listens")
+	address := fs.String("address", "", "IP address to send the packet to, instead of --node (broadcast allowed)")
+	port := fs.Int("port", wol.DefaultWOLPort, "UDP port")
+	password := fs.String("password", "", "SecureOn password to append (4 or 6 bytes, e.g. 01:02:03:04:05:06)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("give the MAC address to wake")
+	}
+	if (*node == "") == (*address == "") {
+		return fmt.Errorf("give either --node or --address")
+	}
+
+	target := *address
+	if *node != "" {
+		config, err := kube.restConfig()
+		if err != nil {
+			return err
+		}
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return err
+		}
+		n, err := clientset.CoreV1().Nodes().Get(ctx, *node, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for _, addr := range n.Status.Addresses {
+			if addr.Type == corev1.NodeInternalIP {
+				target = addr.Address
+				break
+			}
+		}
+		if target == "" {
+			return fmt.Errorf("node %s has no InternalIP", *node)
+		}
+	}
+
+	if err := wol.SendMagicPacket(target, *port, fs.Arg(0), *password); err != nil {
+		return err
+	}
+	fmt.Printf("Magic packet for %s sent to %s:%d\n", fs.Arg(0), target, *port)
+	fmt.Println("Run \"wolctl events -f\" to see the outcome")
+	return nil
+}
+
+func runWakeExternal(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("wake-external", flag.ContinueOnError)
+	var api apiFlags
+	api.bind(fs)
+	address := fs.String("address", "", "IP address to send the packet to (broadcast allowed, e.g. 192.168.1.255)")
+	port := fs.Int("port", wol.DefaultWOLPort, "UDP port")
+	iface := fs.String("interface", "", "Interface to send the packet from (without --address, as a raw Ethernet frame)")
+	node := fs.String("node", "", "Send the packet from the agent on this node (default: from the manager)")
+	configName := fs.String("wolconfig", "", "WolConfig of the agent sending the packet (required with --node)")
+	password := fs.String("password", "", "SecureOn password to append (4 or 6 bytes, e.g. 01:02:03:04:05:06)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("give the MAC address to wake")
+	}
+	if *address == "" && *iface == "" {
+		return fmt.Errorf("give --address or --interface")
+	}
+	if *node != "" && *configName == "" {
+		return fmt.Errorf("--node requires --wolconfig")
+	}
+	if *port <= 0 || *port > 65535 {
+		return fmt.Errorf("invalid port %d", *port)
+	}
+	client, err := api.client()
+	if err != nil {
+		return err
+	}
+
+	body := map[string]any{
+		"mac":       fs.Arg(0),
+		"password":  *password,
+		"address":   *address,
+		"port":      *port,
+		"interface": *iface,
+		"node":      *node,
+		"wolConfig": *configName,
+	}
+	var result struct {
+		Sender      string `json:"sender"`
+		Destination string `json:"destination"`
+		Message     string `json:"message"`
+	}
+	if _, err := client.do(ctx, http.MethodPost, "/api/v1/send", nil, body, &result); err != nil {
+		return err
+	}
+	fmt.Println(result.Message)
+	return nil
+}
+
+func runSimulate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	var api apiFlags
+	api.bind(fs)
+	password := fs.String("password", "", "SecureOn password of the simulated packet")
+	node := fs.String("node", "", "Node that would receive the packet, for the per-node rate limit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("give the MAC address to simulate")
+	}
+	client, err := api.client()
+	if err != nil {
+		return err
+	}
+
+	body := map[string]any{"mac": fs.Arg(0), "password": *password, "node": *node}
+	var result struct {
+		Status       string   `json:"status"`
+		Message      string   `json:"message"`
+		Namespace    string   `json:"namespace"`
+		Name         string   `json:"name"`
+		CurrentState string   `json:"currentState"`
+		WolConfig    string   `json:"wolConfig"`
+		MappingType  string   `json:"mappingType"`
+		Action       string   `json:"action"`
+		Steps        []string `json:"steps"`
+	}
+	if _, err := client.do(ctx, http.MethodPost, "/api/v1/simulate", nil, body, &result); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintf(w, "Outcome:\t%s\n", result.Status)
+	if result.Name != "" {
+		_, _ = fmt.Fprintf(w, "VM:\t%s/%s\n", result.Namespace, result.Name)
+	}
+	if result.CurrentState != "" {
+		_, _ = fmt.Fprintf(w, "State:\t%s\n", result.CurrentState)
+	}
+	if result.WolConfig != "" {
+		mapping := result.WolConfig
+		if result.MappingType != "" {
+			mapping += " (" + result.MappingType + ")"
+		}
+		_, _ = fmt.Fprintf(w, "WolConfig:\t%s\n", mapping)
+	}
+	if result.Action != "" {
+		_, _ = fmt.Fprintf(w, "Action:\t%s\n", result.Action)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Println(result.Message)
+	fmt.Println("\nSteps:")
+	for i, step := range result.Steps {
+		fmt.Printf("  %d. %s\n", i+1, step)
+	}
+	return nil
+}
+
+func runEvents(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	var kube kubeFlags
+	kube.bind(fs)
+	var namespace string
+	fs.StringVar(&namespace, "namespace", "", "Only the Events of the VMs of this namespace (default: all namespaces)")
+	fs.StringVar(&namespace, "n", "", "Shorthand for --namespace")
+	since := fs.Duration("since", time.Hour, "Only the Events seen in this period")
+	var follow bool
+	fs.BoolVar(&follow, "follow", false, "Keep watching for new Events")
+	fs.BoolVar(&follow, "f", false, "Shorthand for --follow")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := kube.restConfig()
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// Gli Event delle WolConfig (cluster-scoped) finiscono nel namespace default
+	events := clientset.CoreV1().Events(namespace)
+	list, err := events.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-*since)
+	var recent []corev1.Event
+	for _, event := range list.Items {
+		if isWOLEvent(&event) && eventTime(&event).After(cutoff) {
+			recent = append(recent, event)
+		}
+	}
+	sort.Slice(recent, func(i, j int) bool { return eventTime(&recent[i]).Before(eventTime(&recent[j])) })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "TIME\tTYPE\tREASON\tOBJECT\tMESSAGE")
+	for i := range recent {
+		printEvent(w, &recent[i])
+	}
+	if err := w.Flush(); err != nil || !follow {
+		return err
+	}
+
+	watcher, err := events.Watch(ctx, metav1.ListOptions{ResourceVersion: list.ResourceVersion})
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case change, ok := <-watcher.ResultChan():
+			if !ok {
+				return fmt.Errorf("the watch of the Events was closed")
+			}
+			event, isEvent := change.Object.(*corev1.Event)
+			if !isEvent || (change.Type != watch.Added && change.Type != watch.Modified) || !isWOLEvent(event) {
+				continue
+			}
+			printEvent(w, event)
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func isWOLEvent(event *corev1.Event) bool {
+	return slices.Contains(wolEventReasons, event.Reason)
+}
+
+// eventTime returns when the Event was last seen
+func eventTime(event *corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	default:
+		return event.CreationTimestamp.Time
+	}
+}
+
+func printEvent(w io.Writer, event *corev1.Event) {
+	object := strings.ToLower(event.InvolvedObject.Kind) + "/" + event.InvolvedObject.Name
+	if event.InvolvedObject.Namespace != "" {
+		object = event.InvolvedObject.Namespace + "/" + object
+	}
+	_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", eventTime(event).Local().Format(time.DateTime),
+		event.Type, event.Reason, object, event.Message)
+}
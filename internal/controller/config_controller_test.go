@@ -22,14 +22,20 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
 	"github.com/gpillon/kubevirt-wol/internal/wol"
 )
 
@@ -89,6 +95,10 @@ var _ = Describe("WolConfig Controller", func() {
 			configList := &wolv1beta1.WolConfigList{}
 			Expect(k8sClient.List(ctx, configList)).To(Succeed())
 			for _, config := range configList.Items {
+				// Nessun controller in envtest rimuove il finalizer
+				if controllerutil.RemoveFinalizer(&config, WolConfigFinalizer) {
+					Expect(k8sClient.Update(ctx, &config)).To(Succeed())
+				}
 				Expect(k8sClient.Delete(ctx, &config)).To(Succeed())
 			}
 		})
@@ -123,8 +133,11 @@ var _ = Describe("WolConfig Controller", func() {
 
 			By("Verifying the Ready condition")
 			Expect(config.Status.Conditions).NotTo(BeEmpty())
-			readyCondition := config.Status.Conditions[0]
-			Expect(readyCondition.Type).To(Equal(ConditionTypeReady))
+			readyCondition := apimeta.FindStatusCondition(config.Status.Conditions, ConditionTypeReady)
+			Expect(readyCondition).NotTo(BeNil())
+			// Nessun agent connesso in envtest: la WolConfig attende gli agent
+			Expect(readyCondition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(readyCondition.Reason).To(Equal(ReasonAgentsNotReady))
 		})
 
 		It("should successfully reconcile a WolConfig with Explicit discovery mode", func() {
@@ -207,6 +220,10 @@ var _ = Describe("WolConfig Controller", func() {
 			})
 			Expect(err).NotTo(HaveOccurred())
 
+			By("Checking the finalizer is added")
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: config.Name}, config)).To(Succeed())
+			Expect(config.Finalizers).To(ContainElement(WolConfigFinalizer))
+
 			By("Deleting the WolConfig")
 			Expect(k8sClient.Delete(ctx, config)).To(Succeed())
 
@@ -215,6 +232,15 @@ var _ = Describe("WolConfig Controller", func() {
 				NamespacedName: types.NamespacedName{Name: config.Name},
 			})
 			Expect(err).NotTo(HaveOccurred())
+
+			By("Checking the agent DaemonSet and the WolConfig are gone")
+			ds := &appsv1.DaemonSet{}
+			err = k8sClient.Get(ctx, types.NamespacedName{
+				Name: dedicatedDaemonSetName(config), Namespace: DefaultOperatorNamespace}, ds)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: config.Name}, config)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+			Expect(reconciler.Mapper.Mappings(config.Name)).To(BeEmpty())
 		})
 	})
 
@@ -275,5 +301,436 @@ var _ = Describe("WolConfig Controller", func() {
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("ExplicitMappings is required"))
 		})
+
+		It("should reject a forward mapping with an invalid address", func() {
+			config := &wolv1beta1.WolConfig{
+				Spec: wolv1beta1.WolConfigSpec{
+					ExplicitMappings: []wolv1beta1.MACVMMapping{{
+						MACAddress: "3c:ec:ef:00:11:22",
+						Forward:    &wolv1beta1.WOLForwardTarget{Address: "lab-broadcast"},
+					}},
+				},
+			}
+
+			err := reconciler.validateConfig(config)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid forward address"))
+		})
+
+		It("should pass the sleep EtherType to the agent", func() {
+			config := &wolv1beta1.WolConfig{
+				Spec: wolv1beta1.WolConfigSpec{
+					ShutdownOnLAN: &wolv1beta1.ShutdownOnLANSpec{Enabled: true, EtherType: "0x0842"},
+				},
+			}
+			config.Name = "sleepy"
+
+			err := reconciler.validateConfig(config)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("cannot be used for sleep packets"))
+
+			config.Spec.ShutdownOnLAN.EtherType = "0x88b5"
+			Expect(reconciler.validateConfig(config)).To(Succeed())
+			ds := reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--sleep-ethertype=0x88b5"))
+
+			config.Spec.RawCapture = &wolv1beta1.RawCaptureSpec{EtherTypes: []string{"0x88B5"}}
+			err = reconciler.validateConfig(config)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("both wake and sleep packets"))
+		})
+
+		It("should pass the ARP responder to the agent", func() {
+			config := &wolv1beta1.WolConfig{
+				Spec: wolv1beta1.WolConfigSpec{
+					ARPWake: &wolv1beta1.ARPWakeSpec{Enabled: true},
+				},
+			}
+			config.Name = "responder"
+
+			ds := reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--arp-wake"))
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).NotTo(ContainElement("--arp-respond"))
+
+			config.Spec.ARPWake.Respond = true
+			ds = reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElements("--arp-wake", "--arp-respond"))
+		})
+
+		It("should pass the wake triggers to the agent", func() {
+			config := &wolv1beta1.WolConfig{
+				Spec: wolv1beta1.WolConfigSpec{
+					WakeTriggers: &wolv1beta1.WakeTriggersSpec{ICMPEcho: true, TCPPorts: []int32{22, 3389}},
+				},
+			}
+			config.Name = "triggers"
+
+			ds := reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElements("--wake-trigger-icmp", "--wake-trigger-tcp-ports=22,3389"))
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).NotTo(ContainElement("--wake-trigger-dns"))
+
+			// Agent condivisi: i trigger di tutti i membri
+			other := &wolv1beta1.WolConfig{
+				Spec: wolv1beta1.WolConfigSpec{
+					WakeTriggers: &wolv1beta1.WakeTriggersSpec{TCPPorts: []int32{80, 22}, DNS: true},
+				},
+			}
+			shared := sharedAgentConfig([]*wolv1beta1.WolConfig{config, other})
+			Expect(shared.Spec.WakeTriggers).To(Equal(&wolv1beta1.WakeTriggersSpec{
+				ICMPEcho: true, TCPPorts: []int32{22, 3389, 80}, DNS: true,
+			}))
+			Expect(config.Spec.WakeTriggers.TCPPorts).To(Equal([]int32{22, 3389}))
+		})
+
+		It("should pass the raw capture ports and EtherTypes to the agent", func() {
+			config := &wolv1beta1.WolConfig{
+				Spec: wolv1beta1.WolConfigSpec{
+					RawCapture: &wolv1beta1.RawCaptureSpec{
+						UDPPorts:   []int32{7, 9},
+						EtherTypes: []string{"0x88b7"},
+					},
+				},
+			}
+			config.Name = "legacy"
+
+			Expect(reconciler.validateConfig(config)).To(Succeed())
+			ds := reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElements("--raw-udp-ports=7,9", "--raw-ethertypes=0x88b7"))
+
+			config.Spec.RawCapture.EtherTypes = []string{"0x0800"}
+			Expect(reconciler.validateConfig(config)).NotTo(Succeed())
+		})
+
+		It("should pass raw capture options to the agent", func() {
+			config := &wolv1beta1.WolConfig{
+				Spec: wolv1beta1.WolConfigSpec{
+					Agent: wolv1beta1.AgentSpec{
+						Promiscuous: pointer(false),
+					},
+				},
+			}
+			config.Name = "capture"
+
+			ds := reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--promiscuous=false"))
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).NotTo(ContainElement("--directed-wake"))
+
+			config.Spec.Agent.Promiscuous = pointer(true)
+			config.Spec.Agent.DirectedWake = true
+			ds = reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).NotTo(ContainElement("--promiscuous=false"))
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--directed-wake"))
+			Expect(ds.Spec.Template.Spec.Containers[0].SecurityContext.Capabilities.Add).NotTo(ContainElement(corev1.Capability("BPF")))
+
+			config.Spec.Agent.CaptureBackend = wolv1beta1.CaptureBackendEBPF
+			ds = reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--capture-backend=ebpf"))
+			Expect(ds.Spec.Template.Spec.Containers[0].SecurityContext.Capabilities.Add).To(
+				ContainElements(corev1.Capability("BPF"), corev1.Capability("PERFMON"), corev1.Capability("NET_ADMIN")))
+
+			config.Spec.Agent.VLANs = []int32{0, 100}
+			ds = reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--vlans=0,100"))
+		})
+
+		It("should render the agent tuning to agent flags", func() {
+			config := &wolv1beta1.WolConfig{
+				Spec: wolv1beta1.WolConfigSpec{
+					Agent: wolv1beta1.AgentSpec{
+						MetricsPort: pointer(int32(9100)),
+						Tuning: &wolv1beta1.AgentTuning{
+							UDPReadBufferBytes:           pointer(int32(1 << 20)),
+							ReceiveTimeoutSeconds:        pointer(int32(5)),
+							DedupeCleanupIntervalSeconds: pointer(int32(300)),
+						},
+					},
+				},
+			}
+			config.Name = "tuning"
+
+			ds := reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			args := ds.Spec.Template.Spec.Containers[0].Args
+			Expect(args).To(ContainElements("--udp-read-buffer=1048576", "--recv-timeout=5s", "--dedupe-cleanup-interval=300s",
+				"--metrics-port=9100"))
+			Expect(args).NotTo(ContainElement(ContainSubstring("--raw-read-buffer")))
+		})
+
+		It("should pass the agent dedupe window to the agent", func() {
+			config := &wolv1beta1.WolConfig{
+				Spec: wolv1beta1.WolConfigSpec{
+					Dedupe: &wolv1beta1.DedupeSpec{
+						AgentWindowSeconds:      pointer(int32(1)),
+						AggregatorWindowSeconds: pointer(int32(60)),
+					},
+				},
+			}
+			config.Name = "chatty"
+
+			ds := reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--dedupe-window=1s"))
+		})
+
+		It("should pass the IP families to the agent", func() {
+			config := &wolv1beta1.WolConfig{}
+			config.Name = "families"
+
+			ds := reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).NotTo(ContainElement(ContainSubstring("--ip-families")))
+
+			config.Spec.Agent.IPFamilies = []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol}
+			ds = reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--ip-families=IPv4,IPv6"))
+		})
+
+		It("should drop NET_RAW when the raw listeners are disabled", func() {
+			config := &wolv1beta1.WolConfig{}
+			config.Name = "modes"
+
+			ds := reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			container := ds.Spec.Template.Spec.Containers[0]
+			Expect(container.Args).NotTo(ContainElement(ContainSubstring("--listen-modes")))
+			Expect(container.SecurityContext.Capabilities.Add).To(ContainElement(corev1.Capability("NET_RAW")))
+
+			config.Spec.Agent.ListenModes = []wolv1beta1.AgentListenMode{wolv1beta1.ListenModeUDP}
+			ds = reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			container = ds.Spec.Template.Spec.Containers[0]
+			Expect(container.Args).To(ContainElement("--listen-modes=UDP"))
+			Expect(container.SecurityContext.Capabilities.Add).NotTo(ContainElement(corev1.Capability("NET_RAW")))
+
+			config.Spec.Agent.ListenModes = []wolv1beta1.AgentListenMode{wolv1beta1.ListenModeRaw}
+			ds = reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--listen-modes=Raw"))
+		})
+
+		It("should pass the operator address to the agents in their environment", func() {
+			config := &wolv1beta1.WolConfig{}
+			config.Name = "env"
+
+			ds := reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), "wol-grpc.custom.svc:9090", DefaultAgentServiceAccount)
+			container := ds.Spec.Template.Spec.Containers[0]
+			Expect(container.Args).NotTo(ContainElement(ContainSubstring("--operator-address")))
+			Expect(container.Env).To(ContainElement(corev1.EnvVar{Name: wol.EnvOperatorAddress, Value: "wol-grpc.custom.svc:9090"}))
+		})
+
+		It("should pass the interface selector to the agents", func() {
+			config := &wolv1beta1.WolConfig{}
+			config.Name = "ifaces"
+
+			ds := reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).NotTo(ContainElement(ContainSubstring("--interface-")))
+
+			config.Spec.Agent.InterfaceSelector = &wolv1beta1.InterfaceSelector{
+				Include: []string{"eth1", "br-wol*"},
+				Exclude: []string{"br-wol9"},
+			}
+			ds = reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElements(
+				"--interface-include=eth1,br-wol*",
+				"--interface-exclude=br-wol9",
+			))
+
+			config.Spec.Agent.InterfaceSelector.Include = []string{"eth["}
+			Expect(reconciler.validateConfig(config)).To(HaveOccurred())
+		})
+
+		It("should hash the desired DaemonSet spec", func() {
+			config := &wolv1beta1.WolConfig{}
+			config.Name = "hash"
+
+			ds := reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			hash := ds.Annotations[AnnotationSpecHash]
+			Expect(hash).NotTo(BeEmpty())
+			ds = reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Annotations[AnnotationSpecHash]).To(Equal(hash))
+
+			config.Spec.Agent.PriorityClassName = "system-node-critical"
+			ds = reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Annotations[AnnotationSpecHash]).NotTo(Equal(hash))
+		})
+
+		It("should not roll out the agents for the settings they fetch at runtime", func() {
+			config := &wolv1beta1.WolConfig{}
+			config.Name = "live"
+
+			ds := reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			hash := ds.Annotations[AnnotationSpecHash]
+
+			config.Spec.WOLPorts = []int{9, 7}
+			config.Spec.Dedupe = &wolv1beta1.DedupeSpec{AgentWindowSeconds: pointer(int32(5))}
+			config.Spec.Agent.InterfaceSelector = &wolv1beta1.InterfaceSelector{Include: []string{"bond*"}}
+			ds = reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElements("--ports=9,7", "--dedupe-window=5s", "--interface-include=bond*"))
+			Expect(ds.Annotations[AnnotationSpecHash]).To(Equal(hash))
+		})
+
+		It("should derive the agent ServiceAccount of a custom install", func() {
+			grpcService := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+				Name:      "acme-wol-grpc",
+				Namespace: "acme-system",
+				Labels: map[string]string{
+					"app.kubernetes.io/name":      "kubevirt-wol",
+					"app.kubernetes.io/component": "grpc",
+				},
+			}}
+			customReconciler := &WolConfigReconciler{
+				Client:            fake.NewClientBuilder().WithObjects(grpcService).Build(),
+				OperatorNamespace: "acme-system",
+			}
+			config := &wolv1beta1.WolConfig{}
+			config.Name = "custom"
+
+			name, err := customReconciler.discoverAgentServiceAccount(ctx, config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(Equal("acme-wol-wol-agent"))
+
+			config.Spec.Agent.ServiceAccountName = "my-agent"
+			name, err = customReconciler.discoverAgentServiceAccount(ctx, config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(Equal("my-agent"))
+		})
+
+		It("should mount the agent client certificate for mutual TLS", func() {
+			config := &wolv1beta1.WolConfig{}
+			config.Name = "mtls"
+
+			ds := reconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Volumes).To(BeEmpty())
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).NotTo(ContainElement(ContainSubstring("--client-cert")))
+
+			tlsReconciler := *reconciler
+			tlsReconciler.AgentTLSSecret = "wol-agent-client-cert"
+			ds = tlsReconciler.buildAgentDaemonSet(config, getDaemonSetName(config), DefaultOperatorAddress, DefaultAgentServiceAccount)
+			Expect(ds.Spec.Template.Spec.Volumes).To(HaveLen(1))
+			Expect(ds.Spec.Template.Spec.Volumes[0].Secret.SecretName).To(Equal("wol-agent-client-cert"))
+			Expect(ds.Spec.Template.Spec.Containers[0].VolumeMounts[0].MountPath).To(Equal(agentTLSMountPath))
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElements(
+				"--operator-ca="+agentTLSMountPath+"/ca.crt",
+				"--client-cert="+agentTLSMountPath+"/tls.crt",
+				"--client-key="+agentTLSMountPath+"/tls.key",
+			))
+		})
+
+		It("should run the shared agents on the ports of all their WolConfigs", func() {
+			lab := &wolv1beta1.WolConfig{ObjectMeta: metav1.ObjectMeta{Name: "lab", UID: "lab-uid"}}
+			lab.Spec.Agent.Shared = true
+			prod := &wolv1beta1.WolConfig{ObjectMeta: metav1.ObjectMeta{Name: "prod", UID: "prod-uid"}}
+			prod.Spec.WOLPorts = []int{7, 9}
+			prod.Spec.Agent.Shared = true
+			sharedClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(lab, prod).Build()
+			sharedReconciler := &WolConfigReconciler{Client: sharedClient, Scheme: scheme.Scheme}
+
+			Expect(sharedReconciler.reconcileAgentDaemonSet(ctx, prod)).To(Succeed())
+			ds := &appsv1.DaemonSet{}
+			key := types.NamespacedName{Name: SharedAgentDaemonSetName, Namespace: operatorNamespace("")}
+			Expect(sharedClient.Get(ctx, key, ds)).To(Succeed())
+			Expect(ds.OwnerReferences).To(HaveLen(2))
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElements("--ports=9", "--raw-udp-ports=7"))
+			Expect(ds.Spec.Template.Spec.Containers[0].Env).To(ContainElement(
+				corev1.EnvVar{Name: "WOLCONFIG_NAME", Value: "lab"}))
+
+			// Tornando all'agent dedicato prod esce dal DaemonSet condiviso
+			prod.Spec.Agent.Shared = false
+			Expect(sharedClient.Update(ctx, prod)).To(Succeed())
+			Expect(sharedReconciler.reconcileAgentDaemonSet(ctx, prod)).To(Succeed())
+			Expect(sharedClient.Get(ctx, key, ds)).To(Succeed())
+			Expect(ds.OwnerReferences).To(HaveLen(1))
+			Expect(ds.Spec.Template.Spec.Containers[0].Args).NotTo(ContainElement(HavePrefix("--raw-udp-ports")))
+			Expect(sharedClient.Get(ctx, types.NamespacedName{Name: "wol-agent-prod", Namespace: key.Namespace}, &appsv1.DaemonSet{})).To(Succeed())
+
+			// Senza WolConfig condivise il DaemonSet condiviso viene eliminato
+			Expect(sharedClient.Delete(ctx, lab)).To(Succeed())
+			Expect(sharedReconciler.reconcileSharedAgentDaemonSet(ctx)).To(Succeed())
+			Expect(errors.IsNotFound(sharedClient.Get(ctx, key, ds))).To(BeTrue())
+		})
+
+		It("should report the agents too far from the manager version", func() {
+			mapper := wol.NewMACMapper(nil, ctrl.Log.WithName("mapper"))
+			aggregator := wol.NewAggregator(mapper, wol.NewVMStarter(nil, ctrl.Log.WithName("vmstarter")), ctrl.Log.WithName("aggregator"))
+			rechecks := 0
+			skewReconciler := &WolConfigReconciler{Aggregator: aggregator, OnVersionSkew: func() { rechecks++ }}
+			config := &wolv1beta1.WolConfig{ObjectMeta: metav1.ObjectMeta{Name: "lab"}}
+
+			_, err := aggregator.AgentHeartbeat(ctx, &wolv1.AgentHeartbeatRequest{
+				NodeName: "node-a", WolConfig: "lab", Version: wol.Version})
+			Expect(err).NotTo(HaveOccurred())
+			skewReconciler.updateAgentVersionSkewStatus(config)
+			Expect(apimeta.IsStatusConditionFalse(config.Status.Conditions, ConditionTypeAgentVersionSkew)).To(BeTrue())
+
+			_, err = aggregator.AgentHeartbeat(ctx, &wolv1.AgentHeartbeatRequest{
+				NodeName: "node-b", WolConfig: "lab", Version: "v99.0.0"})
+			Expect(err).NotTo(HaveOccurred())
+			skewReconciler.updateAgentVersionSkewStatus(config)
+			condition := apimeta.FindStatusCondition(config.Status.Conditions, ConditionTypeAgentVersionSkew)
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+			Expect(condition.Message).To(ContainSubstring("node-b (v99.0.0)"))
+			Expect(rechecks).To(Equal(1))
+
+			// Il controllo delle immagini è richiesto solo al passaggio a True
+			skewReconciler.updateAgentVersionSkewStatus(config)
+			Expect(rechecks).To(Equal(1))
+		})
+
+		It("should be Ready only with a fresh mapping and enough connected agents", func() {
+			mapper := wol.NewMACMapper(nil, ctrl.Log.WithName("mapper"))
+			aggregator := wol.NewAggregator(mapper, wol.NewVMStarter(nil, ctrl.Log.WithName("vmstarter")), ctrl.Log.WithName("aggregator"))
+			readyReconciler := &WolConfigReconciler{Aggregator: aggregator}
+			config := &wolv1beta1.WolConfig{ObjectMeta: metav1.ObjectMeta{Name: "lab"}}
+			config.Spec.CacheTTL = 300
+			config.Spec.Agent.MinAgents = pointer(int32(2))
+			now := time.Now()
+			config.Status.LastSync = &metav1.Time{Time: now}
+
+			readyReconciler.updateAgentsReadyStatus(config)
+			readyReconciler.updateGRPCServingStatus(config)
+			Expect(apimeta.IsStatusConditionFalse(config.Status.Conditions, ConditionTypeAgentsReady)).To(BeTrue())
+			Expect(apimeta.IsStatusConditionFalse(config.Status.Conditions, ConditionTypeGRPCServing)).To(BeTrue())
+			ready, reason, _ := readyReconciler.readiness(config, now)
+			Expect(ready).To(BeFalse())
+			Expect(reason).To(Equal(ReasonAgentsNotReady))
+
+			aggregator.SetServing(true)
+			for _, node := range []string{"node-a", "node-b"} {
+				_, err := aggregator.AgentHeartbeat(ctx, &wolv1.AgentHeartbeatRequest{
+					NodeName: node, WolConfig: "lab", Version: wol.Version})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			readyReconciler.updateAgentsReadyStatus(config)
+			readyReconciler.updateGRPCServingStatus(config)
+			Expect(apimeta.IsStatusConditionTrue(config.Status.Conditions, ConditionTypeAgentsReady)).To(BeTrue())
+			Expect(apimeta.IsStatusConditionTrue(config.Status.Conditions, ConditionTypeGRPCServing)).To(BeTrue())
+			ready, reason, _ = readyReconciler.readiness(config, now)
+			Expect(ready).To(BeTrue())
+			Expect(reason).To(Equal(ReasonMappingUpdated))
+
+			// Mapping non aggiornato da più di due TTL
+			ready, reason, _ = readyReconciler.readiness(config, now.Add(11*time.Minute))
+			Expect(ready).To(BeFalse())
+			Expect(reason).To(Equal(ReasonMappingStale))
+		})
+
+		It("should list the mappings in the status up to maxEntries", func() {
+			mapper := wol.NewMACMapper(k8sClient, ctrl.Log.WithName("mapper"))
+			mappingReconciler := &WolConfigReconciler{Mapper: mapper}
+			config := &wolv1beta1.WolConfig{ObjectMeta: metav1.ObjectMeta{Name: "listed"}}
+			config.Spec.DiscoveryMode = wolv1beta1.DiscoveryModeExplicit
+			config.Spec.ExplicitMappings = []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:02", VMName: "vm2", Namespace: "default"},
+				{MACAddress: "52:54:00:00:00:01", VMName: "vm1", Namespace: "default"},
+			}
+			mapper.UpdateConfigs([]wolv1beta1.WolConfig{*config})
+			Expect(mapper.RefreshMapping(ctx)).To(Succeed())
+
+			mappingReconciler.updateMappingStatus(config)
+			Expect(config.Status.Mappings).To(BeEmpty())
+			Expect(config.Status.MappingCount).To(BeZero())
+
+			config.Spec.StatusMappings = &wolv1beta1.StatusMappingsSpec{Enabled: true, MaxEntries: 1}
+			mappingReconciler.updateMappingStatus(config)
+			Expect(config.Status.MappingCount).To(Equal(2))
+			Expect(config.Status.Mappings).To(Equal([]wolv1beta1.MACMappingStatus{{
+				MACAddress: "52:54:00:00:00:01", Namespace: "default", Name: "vm1",
+				MappingType: string(wol.MappingTypeExplicit),
+			}}))
+		})
 	})
 })
@@ -18,11 +18,17 @@ package controller
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
 	"strings"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -33,24 +39,59 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	"github.com/gpillon/kubevirt-wol/internal/wol"
 )
 
 const (
 	DefaultAgentImage          = "quay.io/kubevirtwol/kubevirt-wol-agent:latest"  // Fallback if AGENT_IMAGE env var not set
-	DefaultOperatorAddress     = "kubevirt-wol-grpc.kubevirt-wol-system.svc:9090" // Fallback if service not found
-	DefaultOperatorNamespace   = "kubevirt-wol-system"                            // Fallback if POD_NAMESPACE env var not set
-	DefaultAgentServiceAccount = "kubevirt-wol-wol-agent"                         // Fallback if ServiceAccount not found
+	DefaultOperatorAddress     = "kubevirt-wol-grpc.kubevirt-wol-system.svc:9090" // Service of the default install
+	DefaultOperatorNamespace   = "kubevirt-wol-system"                            // Fallback if the manager namespace is unknown
+	DefaultAgentServiceAccount = "kubevirt-wol-wol-agent"                         // Fallback if neither the ServiceAccount nor the gRPC Service is found
 )
 
-// discoverAgentServiceAccount finds the agent ServiceAccount using labels and returns its name
-func (r *WolConfigReconciler) discoverAgentServiceAccount(ctx context.Context) (string, error) {
-	log := ctrl.LoggerFrom(ctx)
+// SharedAgentDaemonSetName is the DaemonSet of the WolConfigs with shared agents
+// (spec.agent.shared), named outside the wol-agent-<wolconfig> pattern
+const SharedAgentDaemonSetName = "wol-shared-agent"
+
+// AnnotationSpecHash is the hash of the desired spec the agent DaemonSet was
+// last written with: the DaemonSet is updated only when it changes
+const AnnotationSpecHash = "wol.pillon.org/spec-hash"
+
+// agentTLSMountPath is where the agent client certificate Secret is mounted
+const agentTLSMountPath = "/etc/kubevirt-wol/tls"
 
-	// Determine namespace to search in
-	namespace := r.OperatorNamespace
+// agentTokenMountPath is where the projected ServiceAccount token of the agents is mounted
+const agentTokenMountPath = "/var/run/secrets/kubevirt-wol"
+
+// agentTokenExpirationSeconds is the lifetime of the projected token, rotated by the kubelet
+const agentTokenExpirationSeconds = 3600
+
+// Names of the gRPC Service and of the agent ServiceAccount in config/, before
+// the kustomize namePrefix
+const (
+	grpcServiceBaseName         = "grpc"
+	agentServiceAccountBaseName = "wol-agent"
+)
+
+// operatorNamespace returns the namespace of the manager and of the agent
+// DaemonSets: the pod namespace, or the one of the default install
+func operatorNamespace(namespace string) string {
 	if namespace == "" {
-		namespace = DefaultOperatorNamespace
+		return DefaultOperatorNamespace
+	}
+	return namespace
+}
+
+// discoverAgentServiceAccount returns the ServiceAccount of the agents: the one
+// set in the WolConfig, else the one found by labels, else the name derived
+// from the prefix of the gRPC Service (kustomize namePrefix)
+func (r *WolConfigReconciler) discoverAgentServiceAccount(ctx context.Context, wolConfig *wolv1beta1.WolConfig) (string, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	if name := wolConfig.Spec.Agent.ServiceAccountName; name != "" {
+		return name, nil
 	}
+	namespace := operatorNamespace(r.OperatorNamespace)
 
 	// List ServiceAccounts with the agent labels
 	saList := &corev1.ServiceAccountList{}
@@ -66,8 +107,16 @@ func (r *WolConfigReconciler) discoverAgentServiceAccount(ctx context.Context) (
 	}
 
 	if len(saList.Items) == 0 {
-		log.Info("Agent ServiceAccount not found, using default name", "default", DefaultAgentServiceAccount)
-		return DefaultAgentServiceAccount, nil
+		name := DefaultAgentServiceAccount
+		service, err := r.findGRPCService(ctx, namespace)
+		if err != nil {
+			return "", err
+		}
+		if service != nil && strings.HasSuffix(service.Name, grpcServiceBaseName) {
+			name = strings.TrimSuffix(service.Name, grpcServiceBaseName) + agentServiceAccountBaseName
+		}
+		log.Info("Agent ServiceAccount not found, using default name", "default", name)
+		return name, nil
 	}
 
 	// Use the first matching ServiceAccount
@@ -77,17 +126,8 @@ func (r *WolConfigReconciler) discoverAgentServiceAccount(ctx context.Context) (
 	return sa.Name, nil
 }
 
-// discoverOperatorAddress finds the gRPC service using labels and returns its address
-func (r *WolConfigReconciler) discoverOperatorAddress(ctx context.Context) (string, error) {
-	log := ctrl.LoggerFrom(ctx)
-
-	// Determine namespace to search in
-	namespace := r.OperatorNamespace
-	if namespace == "" {
-		namespace = DefaultOperatorNamespace
-	}
-
-	// List services with the gRPC labels
+// findGRPCService returns the gRPC Service of the operator, found by labels, or nil
+func (r *WolConfigReconciler) findGRPCService(ctx context.Context, namespace string) (*corev1.Service, error) {
 	serviceList := &corev1.ServiceList{}
 	err := r.List(ctx, serviceList,
 		client.InNamespace(namespace),
@@ -97,16 +137,29 @@ func (r *WolConfigReconciler) discoverOperatorAddress(ctx context.Context) (stri
 		},
 	)
 	if err != nil {
-		return "", fmt.Errorf("failed to list services: %w", err)
+		return nil, fmt.Errorf("failed to list services: %w", err)
 	}
-
 	if len(serviceList.Items) == 0 {
-		log.Info("gRPC service not found, using default address", "default", DefaultOperatorAddress)
-		return DefaultOperatorAddress, nil
+		return nil, nil
 	}
-
 	// Use the first matching service
-	service := serviceList.Items[0]
+	return &serviceList.Items[0], nil
+}
+
+// discoverOperatorAddress finds the gRPC service using labels and returns its address
+func (r *WolConfigReconciler) discoverOperatorAddress(ctx context.Context) (string, error) {
+	log := ctrl.LoggerFrom(ctx)
+	namespace := operatorNamespace(r.OperatorNamespace)
+
+	service, err := r.findGRPCService(ctx, namespace)
+	if err != nil {
+		return "", err
+	}
+	if service == nil {
+		address := wol.DefaultOperatorAddress(namespace)
+		log.Info("gRPC service not found, using default address", "default", address)
+		return address, nil
+	}
 
 	// Find the gRPC port
 	var port int32
@@ -122,7 +175,7 @@ func (r *WolConfigReconciler) discoverOperatorAddress(ctx context.Context) (stri
 	}
 	if port == 0 {
 		log.Info("No valid port found in gRPC service, using default address", "service", service.Name)
-		return DefaultOperatorAddress, nil
+		return wol.DefaultOperatorAddress(namespace), nil
 	}
 
 	// Build address: <service-name>.<namespace>.svc:<port>
@@ -134,7 +187,18 @@ func (r *WolConfigReconciler) discoverOperatorAddress(ctx context.Context) (stri
 
 // reconcileAgentDaemonSet creates or updates the agent DaemonSet for the given WolConfig
 func (r *WolConfigReconciler) reconcileAgentDaemonSet(ctx context.Context, wolConfig *wolv1beta1.WolConfig) error {
-	log := ctrl.LoggerFrom(ctx)
+	// Passando agli agent condivisi il DaemonSet dedicato non serve più;
+	// tornando a quello dedicato il DaemonSet condiviso perde le sue porte
+	if wolConfig.Spec.Agent.Shared {
+		if err := r.deleteAgentDaemonSet(ctx, dedicatedDaemonSetName(wolConfig)); err != nil {
+			return err
+		}
+		return r.reconcileSharedAgentDaemonSet(ctx)
+	}
+	if err := r.leaveSharedAgentDaemonSet(ctx, wolConfig); err != nil {
+		return err
+	}
+
 	daemonSetName := getDaemonSetName(wolConfig)
 
 	// Discover operator address dynamically
@@ -144,10 +208,13 @@ func (r *WolConfigReconciler) reconcileAgentDaemonSet(ctx context.Context, wolCo
 	}
 
 	// Discover agent ServiceAccount dynamically
-	serviceAccountName, err := r.discoverAgentServiceAccount(ctx)
+	serviceAccountName, err := r.discoverAgentServiceAccount(ctx, wolConfig)
 	if err != nil {
 		return fmt.Errorf("failed to discover agent service account: %w", err)
 	}
+	if err := r.reconcileAgentSCC(ctx, serviceAccountName); err != nil {
+		return err
+	}
 
 	// Build desired DaemonSet
 	desiredDS := r.buildAgentDaemonSet(wolConfig, daemonSetName, operatorAddress, serviceAccountName)
@@ -157,21 +224,26 @@ func (r *WolConfigReconciler) reconcileAgentDaemonSet(ctx context.Context, wolCo
 		return fmt.Errorf("failed to set owner reference: %w", err)
 	}
 
+	return r.applyAgentDaemonSet(ctx, desiredDS, wolConfig.Name)
+}
+
+// applyAgentDaemonSet creates the desired agent DaemonSet, or updates the
+// existing one if its spec or its owners changed
+func (r *WolConfigReconciler) applyAgentDaemonSet(ctx context.Context, desiredDS *appsv1.DaemonSet, configName string) error {
+	log := ctrl.LoggerFrom(ctx)
+	daemonSetName := desiredDS.Name
+
 	// Check if DaemonSet already exists
 	existingDS := &appsv1.DaemonSet{}
-	namespace := r.OperatorNamespace
-	if namespace == "" {
-		namespace = DefaultOperatorNamespace
-	}
-	err = r.Get(ctx, types.NamespacedName{
+	err := r.Get(ctx, types.NamespacedName{
 		Name:      daemonSetName,
-		Namespace: namespace,
+		Namespace: desiredDS.Namespace,
 	}, existingDS)
 
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Create new DaemonSet
-			log.Info("Creating agent DaemonSet", "name", daemonSetName, "wolconfig", wolConfig.Name)
+			log.Info("Creating agent DaemonSet", "name", daemonSetName, "wolconfig", configName)
 			if err := r.Create(ctx, desiredDS); err != nil {
 				return fmt.Errorf("failed to create DaemonSet: %w", err)
 			}
@@ -180,9 +252,20 @@ func (r *WolConfigReconciler) reconcileAgentDaemonSet(ctx context.Context, wolCo
 		return fmt.Errorf("failed to get DaemonSet: %w", err)
 	}
 
-	// Update existing DaemonSet
-	log.Info("Updating agent DaemonSet", "name", daemonSetName, "wolconfig", wolConfig.Name)
+	// Update existing DaemonSet, only if the desired spec or the owners changed
+	hash := desiredDS.Annotations[AnnotationSpecHash]
+	ownersChanged := !equality.Semantic.DeepEqual(existingDS.OwnerReferences, desiredDS.OwnerReferences)
+	if hash != "" && existingDS.Annotations[AnnotationSpecHash] == hash && !ownersChanged {
+		log.V(1).Info("Agent DaemonSet up to date", "name", daemonSetName, "wolconfig", configName)
+		return nil
+	}
+	log.Info("Updating agent DaemonSet", "name", daemonSetName, "wolconfig", configName)
 	existingDS.Spec = desiredDS.Spec
+	existingDS.OwnerReferences = desiredDS.OwnerReferences
+	if existingDS.Annotations == nil {
+		existingDS.Annotations = make(map[string]string)
+	}
+	existingDS.Annotations[AnnotationSpecHash] = hash
 	if err := r.Update(ctx, existingDS); err != nil {
 		return fmt.Errorf("failed to update DaemonSet: %w", err)
 	}
@@ -190,13 +273,155 @@ func (r *WolConfigReconciler) reconcileAgentDaemonSet(ctx context.Context, wolCo
 	return nil
 }
 
+// reconcileSharedAgentDaemonSet creates or updates the DaemonSet of the
+// WolConfigs with shared agents, owned by all of them, and deletes it when
+// none is left
+func (r *WolConfigReconciler) reconcileSharedAgentDaemonSet(ctx context.Context) error {
+	configList := &wolv1beta1.WolConfigList{}
+	if err := r.List(ctx, configList); err != nil {
+		return fmt.Errorf("failed to list WolConfigs: %w", err)
+	}
+	names := wol.SharedAgentConfigs(configList.Items)
+	if len(names) == 0 {
+		return r.deleteAgentDaemonSet(ctx, SharedAgentDaemonSetName)
+	}
+	members := make([]*wolv1beta1.WolConfig, 0, len(names))
+	for _, name := range names {
+		for i := range configList.Items {
+			if configList.Items[i].Name == name {
+				members = append(members, &configList.Items[i])
+			}
+		}
+	}
+	agentConfig := sharedAgentConfig(members)
+
+	operatorAddress, err := r.discoverOperatorAddress(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to discover operator address: %w", err)
+	}
+	serviceAccountName, err := r.discoverAgentServiceAccount(ctx, agentConfig)
+	if err != nil {
+		return fmt.Errorf("failed to discover agent service account: %w", err)
+	}
+	if err := r.reconcileAgentSCC(ctx, serviceAccountName); err != nil {
+		return err
+	}
+
+	desiredDS := r.buildAgentDaemonSet(agentConfig, SharedAgentDaemonSetName, operatorAddress, serviceAccountName)
+	for _, member := range members {
+		if err := controllerutil.SetOwnerReference(member, desiredDS, r.Scheme); err != nil {
+			return fmt.Errorf("failed to set owner reference: %w", err)
+		}
+	}
+	return r.applyAgentDaemonSet(ctx, desiredDS, agentConfig.Name)
+}
+
+// leaveSharedAgentDaemonSet updates the shared DaemonSet if it still serves a
+// WolConfig that no longer has shared agents
+func (r *WolConfigReconciler) leaveSharedAgentDaemonSet(ctx context.Context, wolConfig *wolv1beta1.WolConfig) error {
+	ds := &appsv1.DaemonSet{}
+	err := r.Get(ctx, types.NamespacedName{Name: SharedAgentDaemonSetName, Namespace: operatorNamespace(r.OperatorNamespace)}, ds)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get DaemonSet: %w", err)
+	}
+	if !slices.ContainsFunc(ds.OwnerReferences, func(ref metav1.OwnerReference) bool { return ref.UID == wolConfig.UID }) {
+		return nil
+	}
+	return r.reconcileSharedAgentDaemonSet(ctx)
+}
+
+// deleteAgentDaemonSet deletes an agent DaemonSet, if it exists
+func (r *WolConfigReconciler) deleteAgentDaemonSet(ctx context.Context, name string) error {
+	ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: operatorNamespace(r.OperatorNamespace)}}
+	if err := r.Delete(ctx, ds); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete DaemonSet %s: %w", name, err)
+	}
+	ctrl.LoggerFrom(ctx).Info("Deleted agent DaemonSet", "name", name)
+	return nil
+}
+
+// sharedAgentConfig returns the WolConfig the shared DaemonSet is built from:
+// the first member, listening on the WOLPorts of all the members (its own
+// first, bound by the UDP listener; the others captured by the raw listeners),
+// with the raw capture settings and wake triggers of all of them and ARP wake,
+// directed wake and sleep frames if any of them enables them
+func sharedAgentConfig(members []*wolv1beta1.WolConfig) *wolv1beta1.WolConfig {
+	config := members[0].DeepCopy()
+	ports := slices.Clone(effectiveWOLPorts(config))
+	rawCapture := config.Spec.RawCapture
+	if rawCapture == nil {
+		rawCapture = &wolv1beta1.RawCaptureSpec{}
+	}
+	for _, member := range members[1:] {
+		for _, port := range effectiveWOLPorts(member) {
+			if !slices.Contains(ports, port) {
+				ports = append(ports, port)
+				rawCapture.UDPPorts = appendMissing(rawCapture.UDPPorts, int32(port))
+			}
+		}
+		if spec := member.Spec.RawCapture; spec != nil {
+			for _, port := range spec.UDPPorts {
+				rawCapture.UDPPorts = appendMissing(rawCapture.UDPPorts, port)
+			}
+			for _, etherType := range spec.EtherTypes {
+				rawCapture.EtherTypes = appendMissing(rawCapture.EtherTypes, etherType)
+			}
+		}
+		if spec := member.Spec.ARPWake; spec != nil && spec.Enabled {
+			if config.Spec.ARPWake == nil || !config.Spec.ARPWake.Enabled {
+				config.Spec.ARPWake = spec.DeepCopy()
+			}
+			config.Spec.ARPWake.Respond = config.Spec.ARPWake.Respond || spec.Respond
+		}
+		if spec := member.Spec.WakeTriggers; spec != nil {
+			if config.Spec.WakeTriggers == nil {
+				config.Spec.WakeTriggers = &wolv1beta1.WakeTriggersSpec{}
+			}
+			triggers := config.Spec.WakeTriggers
+			triggers.ICMPEcho = triggers.ICMPEcho || spec.ICMPEcho
+			triggers.DNS = triggers.DNS || spec.DNS
+			for _, port := range spec.TCPPorts {
+				triggers.TCPPorts = appendMissing(triggers.TCPPorts, port)
+			}
+		}
+		if spec := member.Spec.ShutdownOnLAN; spec != nil && spec.Enabled && spec.EtherType != "" &&
+			(config.Spec.ShutdownOnLAN == nil || !config.Spec.ShutdownOnLAN.Enabled || config.Spec.ShutdownOnLAN.EtherType == "") {
+			config.Spec.ShutdownOnLAN = spec.DeepCopy()
+		}
+		config.Spec.Agent.DirectedWake = config.Spec.Agent.DirectedWake || member.Spec.Agent.DirectedWake
+	}
+	config.Spec.WOLPorts = ports
+	if len(rawCapture.UDPPorts) > 0 || len(rawCapture.EtherTypes) > 0 {
+		config.Spec.RawCapture = rawCapture
+	}
+	return config
+}
+
+// effectiveWOLPorts returns the WOL ports of a WolConfig (9 when none is set)
+func effectiveWOLPorts(wolConfig *wolv1beta1.WolConfig) []int {
+	if len(wolConfig.Spec.WOLPorts) == 0 {
+		return []int{wol.DefaultWOLPort}
+	}
+	return wolConfig.Spec.WOLPorts
+}
+
+// appendMissing appends value to values unless it is already there
+func appendMissing[T comparable](values []T, value T) []T {
+	if slices.Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
+
 // buildAgentDaemonSet constructs the DaemonSet spec for the agent
 func (r *WolConfigReconciler) buildAgentDaemonSet(wolConfig *wolv1beta1.WolConfig, name string, operatorAddress string, serviceAccountName string) *appsv1.DaemonSet {
-	// Determine namespace
-	namespace := r.OperatorNamespace
-	if namespace == "" {
-		namespace = DefaultOperatorNamespace
-	}
+	namespace := operatorNamespace(r.OperatorNamespace)
 
 	labels := map[string]string{
 		"app":                          "wol-agent",
@@ -234,17 +459,111 @@ func (r *WolConfigReconciler) buildAgentDaemonSet(wolConfig *wolv1beta1.WolConfi
 		portsStr[i] = fmt.Sprintf("%d", p)
 	}
 
+	args := []string{
+		"--node-name=$(NODE_NAME)",
+		"--ports=" + strings.Join(portsStr, ","),
+		"--zap-log-level=info",
+	}
+	if wolConfig.Spec.ARPWake != nil && wolConfig.Spec.ARPWake.Enabled {
+		args = append(args, "--arp-wake")
+		if wolConfig.Spec.ARPWake.Respond {
+			args = append(args, "--arp-respond")
+		}
+	}
+	if spec := wolConfig.Spec.WakeTriggers; spec != nil {
+		if spec.ICMPEcho {
+			args = append(args, "--wake-trigger-icmp")
+		}
+		if len(spec.TCPPorts) > 0 {
+			tcpPorts := make([]string, len(spec.TCPPorts))
+			for i, port := range spec.TCPPorts {
+				tcpPorts[i] = fmt.Sprintf("%d", port)
+			}
+			args = append(args, "--wake-trigger-tcp-ports="+strings.Join(tcpPorts, ","))
+		}
+		if spec.DNS {
+			args = append(args, "--wake-trigger-dns")
+		}
+	}
+	if spec := wolConfig.Spec.ShutdownOnLAN; spec != nil && spec.Enabled && spec.EtherType != "" {
+		args = append(args, "--sleep-ethertype="+spec.EtherType)
+	}
+	if wolConfig.Spec.Agent.DirectedWake {
+		args = append(args, "--directed-wake")
+	}
+	if spec := wolConfig.Spec.RawCapture; spec != nil {
+		if len(spec.UDPPorts) > 0 {
+			udpPorts := make([]string, len(spec.UDPPorts))
+			for i, port := range spec.UDPPorts {
+				udpPorts[i] = fmt.Sprintf("%d", port)
+			}
+			args = append(args, "--raw-udp-ports="+strings.Join(udpPorts, ","))
+		}
+		if len(spec.EtherTypes) > 0 {
+			args = append(args, "--raw-ethertypes="+strings.Join(spec.EtherTypes, ","))
+		}
+	}
+	if wolConfig.Spec.Agent.Promiscuous != nil && !*wolConfig.Spec.Agent.Promiscuous {
+		args = append(args, "--promiscuous=false")
+	}
+	ebpf := wolConfig.Spec.Agent.CaptureBackend == wolv1beta1.CaptureBackendEBPF
+	if ebpf {
+		args = append(args, "--capture-backend="+wol.CaptureBackendEBPF)
+	}
+	if families := wolConfig.Spec.Agent.IPFamilies; len(families) > 0 &&
+		!(len(families) == 1 && families[0] == corev1.IPv4Protocol) {
+		names := make([]string, len(families))
+		for i, family := range families {
+			names[i] = string(family)
+		}
+		args = append(args, "--ip-families="+strings.Join(names, ","))
+	}
+	if selector := wolConfig.Spec.Agent.InterfaceSelector; selector != nil {
+		if len(selector.Include) > 0 {
+			args = append(args, "--interface-include="+strings.Join(selector.Include, ","))
+		}
+		if len(selector.Exclude) > 0 {
+			args = append(args, "--interface-exclude="+strings.Join(selector.Exclude, ","))
+		}
+	}
+	rawMode, udpMode := listenModes(wolConfig.Spec.Agent.ListenModes)
+	switch {
+	case !rawMode:
+		args = append(args, "--listen-modes=UDP")
+	case !udpMode:
+		args = append(args, "--listen-modes=Raw")
+	}
+	if vlans := wolConfig.Spec.Agent.VLANs; len(vlans) > 0 {
+		ids := make([]string, len(vlans))
+		for i, vlan := range vlans {
+			ids[i] = fmt.Sprintf("%d", vlan)
+		}
+		args = append(args, "--vlans="+strings.Join(ids, ","))
+	}
+	if port := wolConfig.Spec.Agent.MetricsPort; port != nil {
+		args = append(args, fmt.Sprintf("--metrics-port=%d", *port))
+	}
+	if spec := wolConfig.Spec.Dedupe; spec != nil && spec.AgentWindowSeconds != nil {
+		args = append(args, fmt.Sprintf("--dedupe-window=%ds", *spec.AgentWindowSeconds))
+	}
+	args = append(args, tuningArgs(wolConfig.Spec.Agent.Tuning)...)
+	if r.AgentTLSSecret != "" {
+		args = append(args,
+			"--operator-ca="+agentTLSMountPath+"/ca.crt",
+			"--client-cert="+agentTLSMountPath+"/tls.crt",
+			"--client-key="+agentTLSMountPath+"/tls.key",
+		)
+	}
+	if r.AgentTokenAudience != "" {
+		args = append(args, "--token-file="+agentTokenMountPath+"/token")
+	}
+
 	// Build container
 	container := corev1.Container{
 		Name:            "agent",
 		Image:           image,
 		ImagePullPolicy: imagePullPolicy,
-		Args: []string{
-			"--node-name=$(NODE_NAME)",
-			"--operator-address=" + operatorAddress,
-			"--ports=" + strings.Join(portsStr, ","),
-			"--zap-log-level=info",
-		},
+		Args:            args,
 		Env: []corev1.EnvVar{
 			{
 				Name: "NODE_NAME",
@@ -266,12 +585,18 @@ func (r *WolConfigReconciler) buildAgentDaemonSet(wolConfig *wolv1beta1.WolConfi
 				Name:  "WOLCONFIG_NAME",
 				Value: wolConfig.Name,
 			},
+			{
+				// Letto dall'agent al posto di --operator-address: un file di
+				// configurazione può ancora sovrascriverlo
+				Name:  wol.EnvOperatorAddress,
+				Value: operatorAddress,
+			},
 		},
 		SecurityContext: &corev1.SecurityContext{
 			RunAsUser:                pointer(int64(0)),
 			AllowPrivilegeEscalation: pointer(false),
 			Capabilities: &corev1.Capabilities{
-				Add:  []corev1.Capability{"NET_BIND_SERVICE", "NET_RAW"},
+				Add:  agentCapabilities(rawMode, rawMode && ebpf),
 				Drop: []corev1.Capability{"ALL"},
 			},
 		},
@@ -318,6 +643,22 @@ func (r *WolConfigReconciler) buildAgentDaemonSet(wolConfig *wolv1beta1.WolConfi
 		}
 	}
 
+	// Restrict the agents to the nodes that provide the bridges of the NADs
+	if wolConfig.Spec.Agent.NetworkAwareScheduling && r.Mapper != nil {
+		if resources := wol.SchedulingResources(r.Mapper.AgentNetworkAttachments(wolConfig.Name)); len(resources) > 0 {
+			requests := corev1.ResourceList{}
+			limits := corev1.ResourceList{}
+			maps.Copy(requests, container.Resources.Requests)
+			maps.Copy(limits, container.Resources.Limits)
+			for _, name := range resources {
+				requests[corev1.ResourceName(name)] = resource.MustParse("1")
+				limits[corev1.ResourceName(name)] = resource.MustParse("1")
+			}
+			container.Resources.Requests = requests
+			container.Resources.Limits = limits
+		}
+	}
+
 	// Build pod spec
 	podSpec := corev1.PodSpec{
 		HostNetwork:                   true,
@@ -330,6 +671,41 @@ func (r *WolConfigReconciler) buildAgentDaemonSet(wolConfig *wolv1beta1.WolConfi
 		Containers: []corev1.Container{container},
 	}
 
+	// Client certificate for the mutual TLS with the operator gRPC server
+	if r.AgentTLSSecret != "" {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: "operator-tls",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: r.AgentTLSSecret},
+			},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "operator-tls",
+			MountPath: agentTLSMountPath,
+			ReadOnly:  true,
+		})
+	}
+	// Token del ServiceAccount per l'audience del gRPC server, legato al pod e al nodo
+	if r.AgentTokenAudience != "" {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: "operator-token",
+			VolumeSource: corev1.VolumeSource{
+				Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{{
+					ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+						Audience:          r.AgentTokenAudience,
+						ExpirationSeconds: pointer(int64(agentTokenExpirationSeconds)),
+						Path:              "token",
+					},
+				}}},
+			},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "operator-token",
+			MountPath: agentTokenMountPath,
+			ReadOnly:  true,
+		})
+	}
+
 	// Apply node selector if specified
 	if len(wolConfig.Spec.Agent.NodeSelector) > 0 {
 		podSpec.NodeSelector = wolConfig.Spec.Agent.NodeSelector
@@ -388,12 +764,44 @@ func (r *WolConfigReconciler) buildAgentDaemonSet(wolConfig *wolv1beta1.WolConfi
 			},
 		},
 	}
+	ds.Annotations = map[string]string{AnnotationSpecHash: specHash(&ds.Spec)}
 
 	return ds
 }
 
+// liveAgentArgs are the agent args the agents fetch from the operator at
+// runtime (GetAgentConfig): changing them does not roll out the DaemonSet
+var liveAgentArgs = []string{"--ports=", "--dedupe-window=", "--interface-include=", "--interface-exclude="}
+
+// specHash returns the hash of a desired DaemonSet spec, without the live
+// agent args. The JSON encoding sorts the map keys, so equal specs have equal hashes.
+func specHash(spec *appsv1.DaemonSetSpec) string {
+	spec = spec.DeepCopy()
+	for i := range spec.Template.Spec.Containers {
+		container := &spec.Template.Spec.Containers[i]
+		container.Args = slices.DeleteFunc(container.Args, func(arg string) bool {
+			return slices.ContainsFunc(liveAgentArgs, func(prefix string) bool { return strings.HasPrefix(arg, prefix) })
+		})
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		// Non succede con i tipi dell'API: l'hash vuoto forza l'update
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
 // getDaemonSetName returns the name of the DaemonSet for the given WolConfig
 func getDaemonSetName(wolConfig *wolv1beta1.WolConfig) string {
+	if wolConfig.Spec.Agent.Shared {
+		return SharedAgentDaemonSetName
+	}
+	return dedicatedDaemonSetName(wolConfig)
+}
+
+// dedicatedDaemonSetName returns the name of the DaemonSet of a WolConfig without shared agents
+func dedicatedDaemonSetName(wolConfig *wolv1beta1.WolConfig) string {
 	return fmt.Sprintf("wol-agent-%s", wolConfig.Name)
 }
 
@@ -401,3 +809,57 @@ func getDaemonSetName(wolConfig *wolv1beta1.WolConfig) string {
 func pointer[T any](v T) *T {
 	return &v
 }
+
+// listenModes returns whether the agents start the raw and the UDP listeners
+// (both when modes is empty)
+func listenModes(modes []wolv1beta1.AgentListenMode) (raw, udp bool) {
+	if len(modes) == 0 {
+		return true, true
+	}
+	for _, mode := range modes {
+		switch mode {
+		case wolv1beta1.ListenModeRaw:
+			raw = true
+		case wolv1beta1.ListenModeUDP:
+			udp = true
+		case wolv1beta1.ListenModeBoth:
+			raw, udp = true, true
+		}
+	}
+	return raw, udp
+}
+
+// agentCapabilities returns the capabilities added to the agent container:
+// NET_RAW is only requested when the raw listeners are enabled, BPF, PERFMON
+// and NET_ADMIN (loading and attaching the XDP programs) with the eBPF backend
+func agentCapabilities(raw, ebpf bool) []corev1.Capability {
+	caps := []corev1.Capability{"NET_BIND_SERVICE"}
+	if raw {
+		caps = append(caps, "NET_RAW")
+	}
+	if ebpf {
+		caps = append(caps, "BPF", "PERFMON", "NET_ADMIN")
+	}
+	return caps
+}
+
+// tuningArgs renders the agent tuning to agent flags (unset fields keep the agent defaults)
+func tuningArgs(tuning *wolv1beta1.AgentTuning) []string {
+	if tuning == nil {
+		return nil
+	}
+	var args []string
+	if tuning.UDPReadBufferBytes != nil {
+		args = append(args, fmt.Sprintf("--udp-read-buffer=%d", *tuning.UDPReadBufferBytes))
+	}
+	if tuning.RawReadBufferBytes != nil {
+		args = append(args, fmt.Sprintf("--raw-read-buffer=%d", *tuning.RawReadBufferBytes))
+	}
+	if tuning.ReceiveTimeoutSeconds != nil {
+		args = append(args, fmt.Sprintf("--recv-timeout=%ds", *tuning.ReceiveTimeoutSeconds))
+	}
+	if tuning.DedupeCleanupIntervalSeconds != nil {
+		args = append(args, fmt.Sprintf("--dedupe-cleanup-interval=%ds", *tuning.DedupeCleanupIntervalSeconds))
+	}
+	return args
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// WolConfigFinalizer holds the deletion of a WolConfig until the controller
+// has torn down what its owner references do not cover: the shared agent
+// DaemonSet, the MACs in the manager's mapping and the state of its agents
+const WolConfigFinalizer = "wol.pillon.org/cleanup"
+
+// finalizeConfig cleans up after a WolConfig being deleted, then removes its
+// finalizer. The agent Service and NetworkPolicies belong to the install, not
+// to a WolConfig, and are left alone.
+func (r *WolConfigReconciler) finalizeConfig(ctx context.Context, wolConfig *wolv1beta1.WolConfig) error {
+	if !controllerutil.ContainsFinalizer(wolConfig, WolConfigFinalizer) {
+		return nil
+	}
+	log := ctrl.LoggerFrom(ctx)
+
+	// Il DaemonSet dedicato sparirebbe col garbage collector, ma in un momento
+	// qualsiasi: lo cancelliamo subito, e gli agent condivisi perdono le sue porte
+	if err := r.deleteAgentDaemonSet(ctx, dedicatedDaemonSetName(wolConfig)); err != nil {
+		return err
+	}
+	if err := r.reconcileSharedAgentDaemonSet(ctx); err != nil {
+		return err
+	}
+
+	// The mapping is shared by all the WolConfigs: rebuild it without this one
+	if _, err := r.refreshAllConfigs(ctx); err != nil {
+		return fmt.Errorf("failed to refresh the mapping without the WolConfig: %w", err)
+	}
+	if r.Aggregator != nil {
+		r.Aggregator.ForgetConfig(wolConfig.Name)
+	}
+
+	controllerutil.RemoveFinalizer(wolConfig, WolConfigFinalizer)
+	if err := r.Update(ctx, wolConfig); err != nil {
+		return fmt.Errorf("failed to remove the finalizer: %w", err)
+	}
+	log.Info("Cleaned up the deleted WolConfig", "name", wolConfig.Name)
+	return nil
+}
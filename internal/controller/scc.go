@@ -0,0 +1,166 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// On OpenShift the agent pods (hostNetwork, running as root with
+// NET_BIND_SERVICE, NET_RAW and the eBPF capabilities) are rejected by the
+// default restricted SCC. Before writing an agent DaemonSet the controller
+// creates the AgentSCCName SecurityContextConstraints, allowing just what the
+// agents need, and adds the agent ServiceAccount to its users. An SCC with the
+// same name not created by the controller is left alone, except for the users.
+
+// AgentSCCName is the SecurityContextConstraints created for the agents on OpenShift
+const AgentSCCName = "kubevirt-wol-agent"
+
+// sccManagedByValue marks the SCC created by the controller, kept in sync with agentSCC
+const sccManagedByValue = "kubevirt-wol-controller"
+
+// SecurityContextConstraintsGVK is the kind of the OpenShift SCCs, read as
+// unstructured to avoid depending on the OpenShift API
+var SecurityContextConstraintsGVK = schema.GroupVersionKind{
+	Group:   "security.openshift.io",
+	Version: "v1",
+	Kind:    "SecurityContextConstraints",
+}
+
+// IsOpenShift returns true if the cluster serves the OpenShift SCC API
+func IsOpenShift(mapper meta.RESTMapper) (bool, error) {
+	_, err := mapper.RESTMapping(SecurityContextConstraintsGVK.GroupKind(), SecurityContextConstraintsGVK.Version)
+	if meta.IsNoMatchError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// agentSCC returns the desired SCC of the agents, without users and groups
+func agentSCC() *unstructured.Unstructured {
+	scc := &unstructured.Unstructured{Object: map[string]interface{}{
+		"allowHostNetwork":         true,
+		"allowHostPorts":           true,
+		"allowHostDirVolumePlugin": false,
+		"allowHostIPC":             false,
+		"allowHostPID":             false,
+		"allowPrivilegedContainer": false,
+		"allowPrivilegeEscalation": false,
+		"readOnlyRootFilesystem":   false,
+		// Quelle che agentCapabilities può aggiungere
+		"allowedCapabilities":      []interface{}{"NET_BIND_SERVICE", "NET_RAW", "BPF", "PERFMON", "NET_ADMIN"},
+		"defaultAddCapabilities":   []interface{}{},
+		"requiredDropCapabilities": []interface{}{"ALL"},
+		"runAsUser":                map[string]interface{}{"type": "RunAsAny"},
+		"seLinuxContext":           map[string]interface{}{"type": "RunAsAny"},
+		"fsGroup":                  map[string]interface{}{"type": "RunAsAny"},
+		"supplementalGroups":       map[string]interface{}{"type": "RunAsAny"},
+		"volumes":                  []interface{}{"configMap", "downwardAPI", "emptyDir", "projected", "secret"},
+	}}
+	scc.SetGroupVersionKind(SecurityContextConstraintsGVK)
+	scc.SetName(AgentSCCName)
+	scc.SetLabels(map[string]string{
+		"app.kubernetes.io/name":       "wol-agent",
+		"app.kubernetes.io/component":  "agent",
+		"app.kubernetes.io/managed-by": sccManagedByValue,
+	})
+	scc.SetAnnotations(map[string]string{
+		"kubernetes.io/description": "Allows the KubeVirt WOL agents to use the host network and the capabilities to receive Wake-on-LAN packets.",
+	})
+	return scc
+}
+
+// reconcileAgentSCC creates or updates the SCC of the agents and adds the
+// agent ServiceAccount to its users. Does nothing outside OpenShift.
+func (r *WolConfigReconciler) reconcileAgentSCC(ctx context.Context, serviceAccountName string) error {
+	if !r.OpenShift {
+		return nil
+	}
+	log := ctrl.LoggerFrom(ctx)
+	user := fmt.Sprintf("system:serviceaccount:%s:%s", operatorNamespace(r.OperatorNamespace), serviceAccountName)
+	desired := agentSCC()
+
+	// Unstructured: letto dall'API server, senza informer sulle SCC
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(SecurityContextConstraintsGVK)
+	err := r.Get(ctx, client.ObjectKey{Name: AgentSCCName}, existing)
+	if errors.IsNotFound(err) {
+		log.Info("Creating agent SecurityContextConstraints", "name", AgentSCCName, "user", user)
+		if err := unstructured.SetNestedStringSlice(desired.Object, []string{user}, "users"); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, desired); err != nil {
+			return fmt.Errorf("failed to create SecurityContextConstraints: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get SecurityContextConstraints: %w", err)
+	}
+
+	users, _, err := unstructured.NestedStringSlice(existing.Object, "users")
+	if err != nil {
+		return fmt.Errorf("invalid users in SecurityContextConstraints %s: %w", AgentSCCName, err)
+	}
+	managed := existing.GetLabels()["app.kubernetes.io/managed-by"] == sccManagedByValue
+	updated := existing.DeepCopy()
+	if managed {
+		// I campi gestiti tornano quelli attesi, utenti e gruppi aggiunti restano
+		for key, value := range desired.Object {
+			if key != "apiVersion" && key != "kind" && key != "metadata" {
+				updated.Object[key] = value
+			}
+		}
+		updated.SetLabels(mergeStringMaps(existing.GetLabels(), desired.GetLabels()))
+		updated.SetAnnotations(mergeStringMaps(existing.GetAnnotations(), desired.GetAnnotations()))
+	}
+	if !slices.Contains(users, user) {
+		users = append(users, user)
+	}
+	if err := unstructured.SetNestedStringSlice(updated.Object, users, "users"); err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(existing.Object, updated.Object) {
+		return nil
+	}
+	log.Info("Updating agent SecurityContextConstraints", "name", AgentSCCName, "user", user, "managed", managed)
+	if err := r.Update(ctx, updated); err != nil {
+		return fmt.Errorf("failed to update SecurityContextConstraints: %w", err)
+	}
+	return nil
+}
+
+// mergeStringMaps returns a copy of base with the entries of overrides
+func mergeStringMaps(base, overrides map[string]string) map[string]string {
+	merged := maps.Clone(base)
+	if merged == nil {
+		merged = make(map[string]string, len(overrides))
+	}
+	maps.Copy(merged, overrides)
+	return merged
+}
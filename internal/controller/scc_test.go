@@ -0,0 +1,130 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Agent SecurityContextConstraints", func() {
+	var (
+		ctx        context.Context
+		reconciler *WolConfigReconciler
+	)
+
+	getSCC := func() (*unstructured.Unstructured, error) {
+		scc := &unstructured.Unstructured{}
+		scc.SetGroupVersionKind(SecurityContextConstraintsGVK)
+		err := reconciler.Get(ctx, client.ObjectKey{Name: AgentSCCName}, scc)
+		return scc, err
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		// L'API delle SCC non c'è in envtest: basta il client fake
+		reconciler = &WolConfigReconciler{
+			Client:            fake.NewClientBuilder().Build(),
+			OperatorNamespace: "wol-system",
+			OpenShift:         true,
+		}
+	})
+
+	It("should detect OpenShift from the SCC API", func() {
+		mapper := meta.NewDefaultRESTMapper(nil)
+		Expect(IsOpenShift(mapper)).To(BeFalse())
+		mapper.Add(SecurityContextConstraintsGVK, meta.RESTScopeRoot)
+		Expect(IsOpenShift(mapper)).To(BeTrue())
+	})
+
+	It("should not create the SCC outside OpenShift", func() {
+		reconciler.OpenShift = false
+		Expect(reconciler.reconcileAgentSCC(ctx, "wol-agent")).To(Succeed())
+		_, err := getSCC()
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should create the SCC and add the agent ServiceAccounts to its users", func() {
+		Expect(reconciler.reconcileAgentSCC(ctx, "wol-agent")).To(Succeed())
+		scc, err := getSCC()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(scc.Object["allowHostNetwork"]).To(BeTrue())
+		Expect(scc.Object["allowPrivilegedContainer"]).To(BeFalse())
+		capabilities, _, _ := unstructured.NestedStringSlice(scc.Object, "allowedCapabilities")
+		Expect(capabilities).To(ContainElements("NET_BIND_SERVICE", "NET_RAW"))
+		users, _, _ := unstructured.NestedStringSlice(scc.Object, "users")
+		Expect(users).To(Equal([]string{"system:serviceaccount:wol-system:wol-agent"}))
+
+		// Un secondo ServiceAccount si aggiunge; lo stesso non aggiorna la SCC
+		Expect(reconciler.reconcileAgentSCC(ctx, "custom-agent")).To(Succeed())
+		Expect(reconciler.reconcileAgentSCC(ctx, "custom-agent")).To(Succeed())
+		updated, err := getSCC()
+		Expect(err).NotTo(HaveOccurred())
+		users, _, _ = unstructured.NestedStringSlice(updated.Object, "users")
+		Expect(users).To(Equal([]string{
+			"system:serviceaccount:wol-system:wol-agent",
+			"system:serviceaccount:wol-system:custom-agent",
+		}))
+		Expect(updated.GetResourceVersion()).NotTo(Equal(scc.GetResourceVersion()))
+		resourceVersion := updated.GetResourceVersion()
+		Expect(reconciler.reconcileAgentSCC(ctx, "wol-agent")).To(Succeed())
+		updated, err = getSCC()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.GetResourceVersion()).To(Equal(resourceVersion))
+	})
+
+	It("should restore the managed fields of its SCC", func() {
+		Expect(reconciler.reconcileAgentSCC(ctx, "wol-agent")).To(Succeed())
+		scc, err := getSCC()
+		Expect(err).NotTo(HaveOccurred())
+		scc.Object["allowPrivilegedContainer"] = true
+		Expect(unstructured.SetNestedStringSlice(scc.Object, []string{"admins"}, "groups")).To(Succeed())
+		Expect(reconciler.Update(ctx, scc)).To(Succeed())
+
+		Expect(reconciler.reconcileAgentSCC(ctx, "wol-agent")).To(Succeed())
+		scc, err = getSCC()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(scc.Object["allowPrivilegedContainer"]).To(BeFalse())
+		groups, _, _ := unstructured.NestedStringSlice(scc.Object, "groups")
+		Expect(groups).To(Equal([]string{"admins"}))
+	})
+
+	It("should only add the user to an SCC it did not create", func() {
+		foreign := &unstructured.Unstructured{Object: map[string]interface{}{
+			"allowHostNetwork":         true,
+			"allowPrivilegedContainer": true,
+		}}
+		foreign.SetGroupVersionKind(SecurityContextConstraintsGVK)
+		foreign.SetName(AgentSCCName)
+		Expect(reconciler.Create(ctx, foreign)).To(Succeed())
+
+		Expect(reconciler.reconcileAgentSCC(ctx, "wol-agent")).To(Succeed())
+		scc, err := getSCC()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(scc.Object["allowPrivilegedContainer"]).To(BeTrue())
+		Expect(scc.Object).NotTo(HaveKey("allowedCapabilities"))
+		users, _, _ := unstructured.NestedStringSlice(scc.Object, "users")
+		Expect(users).To(Equal([]string{"system:serviceaccount:wol-system:wol-agent"}))
+	})
+})
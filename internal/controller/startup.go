@@ -35,6 +35,10 @@ type StartupReconciler struct {
 	AgentImage        string
 	OperatorNamespace string
 	Log               logr.Logger
+
+	// Recheck, if set, triggers a new check at every receive, e.g. when the
+	// agents report a version too far from the manager
+	Recheck <-chan struct{}
 }
 
 // Start implements the Runnable interface
@@ -47,20 +51,27 @@ func (s *StartupReconciler) Start(ctx context.Context) error {
 	if err := s.checkAndUpdateDaemonSets(ctx); err != nil {
 		s.Log.Error(err, "Failed to check and update DaemonSets at startup")
 		// Don't fail the manager startup, just log the error
-		return nil
+	} else {
+		s.Log.Info("Completed DaemonSet image drift detection")
 	}
 
-	s.Log.Info("Completed DaemonSet image drift detection")
+	for s.Recheck != nil {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.Recheck:
+		}
+		s.Log.Info("Agent version skew reported, checking DaemonSet images again")
+		if err := s.checkAndUpdateDaemonSets(ctx); err != nil {
+			s.Log.Error(err, "Failed to check and update DaemonSets")
+		}
+	}
 	return nil
 }
 
 // checkAndUpdateDaemonSets lists all managed DaemonSets and updates them if image doesn't match
 func (s *StartupReconciler) checkAndUpdateDaemonSets(ctx context.Context) error {
-	// Determine namespace
-	namespace := s.OperatorNamespace
-	if namespace == "" {
-		namespace = DefaultOperatorNamespace
-	}
+	namespace := operatorNamespace(s.OperatorNamespace)
 
 	// List all DaemonSets in the agent namespace
 	dsList := &appsv1.DaemonSetList{}
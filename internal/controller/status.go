@@ -18,22 +18,43 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	"github.com/gpillon/kubevirt-wol/internal/wol"
 )
 
+// MaxStatusConflicts bounds the number of conflicts written to WolConfig status.
+// The status is not paginated: ConflictCount reports the total and the
+// mapper logs every conflict at each refresh.
+const MaxStatusConflicts = 20
+
+// MaxStatusListeners bounds the number of nodes listed in the WolConfig
+// listeners status. Nodes with failed listeners come first.
+const MaxStatusListeners = 20
+
+// MaxStatusAgentNodes bounds the number of nodes listed in the WolConfig
+// agentStatus.nodes. Agents not alive come first.
+const MaxStatusAgentNodes = 50
+
 // updateAgentStatus updates the WolConfig status with DaemonSet information
 func (r *WolConfigReconciler) updateAgentStatus(ctx context.Context, wolConfig *wolv1beta1.WolConfig) error {
 	daemonSetName := getDaemonSetName(wolConfig)
 
-	namespace := r.OperatorNamespace
-	if namespace == "" {
-		namespace = DefaultOperatorNamespace
-	}
+	namespace := operatorNamespace(r.OperatorNamespace)
 
 	ds := &appsv1.DaemonSet{}
 	err := r.Get(ctx, types.NamespacedName{
@@ -57,6 +78,447 @@ func (r *WolConfigReconciler) updateAgentStatus(ctx context.Context, wolConfig *
 		NumberReady:            ds.Status.NumberReady,
 		NumberAvailable:        ds.Status.NumberAvailable,
 	}
+	r.updateAgentNodes(wolConfig)
 
 	return nil
 }
+
+// updateAgentNodes copies the heartbeats of the agents of this WolConfig into
+// its agent status. Returns true if they changed.
+func (r *WolConfigReconciler) updateAgentNodes(wolConfig *wolv1beta1.WolConfig) bool {
+	if r.Aggregator == nil || wolConfig.Status.AgentStatus == nil {
+		return false
+	}
+
+	now := time.Now()
+	var nodes []wolv1beta1.NodeAgentStatus
+	for i, agent := range r.Aggregator.Agents(wolConfig.Name) {
+		if i >= MaxStatusAgentNodes {
+			break
+		}
+		node := wolv1beta1.NodeAgentStatus{
+			NodeName:       agent.NodeName,
+			Alive:          agent.Alive(now),
+			Version:        agent.Version,
+			LastHeartbeat:  metav1.NewTime(agent.LastHeartbeat),
+			Interfaces:     agent.Interfaces,
+			PacketsSeen:    agent.PacketsSeen,
+			ReportFailures: agent.ReportFailures,
+		}
+		if !agent.StartedAt.IsZero() {
+			startTime := metav1.NewTime(agent.StartedAt)
+			node.StartTime = &startTime
+		}
+		nodes = append(nodes, node)
+	}
+
+	changed := !equality.Semantic.DeepEqual(nodes, wolConfig.Status.AgentStatus.Nodes)
+	wolConfig.Status.AgentStatus.Nodes = nodes
+	return changed
+}
+
+// updateConflictStatus copies the mapping conflicts involving this WolConfig into its status
+func (r *WolConfigReconciler) updateConflictStatus(wolConfig *wolv1beta1.WolConfig) {
+	conflicts := r.Mapper.GetConflicts(wolConfig.Name)
+
+	wolConfig.Status.Conflicts = nil
+	wolConfig.Status.ConflictCount = len(conflicts)
+	for i, conflict := range conflicts {
+		if i >= MaxStatusConflicts {
+			break
+		}
+		entry := wolv1beta1.MappingConflict{
+			MACAddress: conflict.MAC,
+		}
+		for _, candidate := range conflict.Candidates {
+			entry.Candidates = append(entry.Candidates, candidate.String())
+		}
+		if conflict.Winner != nil {
+			entry.Winner = conflict.Winner.String()
+		}
+		for _, companion := range conflict.Companions {
+			entry.Companions = append(entry.Companions, companion.String())
+		}
+		wolConfig.Status.Conflicts = append(wolConfig.Status.Conflicts, entry)
+	}
+
+	condition := metav1.Condition{
+		Type:               ConditionTypeMappingConflict,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: wolConfig.Generation,
+		Reason:             ReasonNoConflicts,
+		Message:            "No MAC address is claimed by more than one VM",
+	}
+	if len(conflicts) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonDuplicateMAC
+		condition.Message = fmt.Sprintf("%d MAC addresses are claimed by more than one VM", len(conflicts))
+		if len(conflicts) > MaxStatusConflicts {
+			condition.Message += fmt.Sprintf(" (first %d listed in status)", MaxStatusConflicts)
+		}
+	}
+	apimeta.SetStatusCondition(&wolConfig.Status.Conditions, condition)
+}
+
+// defaultStatusMappings is the default number of mappings listed in the
+// WolConfig status with spec.statusMappings
+const defaultStatusMappings = 100
+
+// updateMappingStatus copies the MAC to VM mappings of this WolConfig into
+// its status, if spec.statusMappings is enabled
+func (r *WolConfigReconciler) updateMappingStatus(wolConfig *wolv1beta1.WolConfig) {
+	spec := wolConfig.Spec.StatusMappings
+	wolConfig.Status.Mappings = nil
+	wolConfig.Status.MappingCount = 0
+	if spec == nil || !spec.Enabled {
+		return
+	}
+	limit := int(spec.MaxEntries)
+	if limit <= 0 {
+		limit = defaultStatusMappings
+	}
+
+	mappings := r.Mapper.Mappings(wolConfig.Name)
+	wolConfig.Status.MappingCount = len(mappings)
+	for _, mapping := range mappings[:min(limit, len(mappings))] {
+		wolConfig.Status.Mappings = append(wolConfig.Status.Mappings, wolv1beta1.MACMappingStatus{
+			MACAddress:  mapping.MAC,
+			Namespace:   mapping.Namespace,
+			Name:        mapping.Name,
+			MappingType: string(mapping.MappingType),
+		})
+	}
+}
+
+// updateNetworkAttachmentStatus copies the NADs used by the VMs of this WolConfig
+// into its status, returning true if they changed since the last reconcile
+func (r *WolConfigReconciler) updateNetworkAttachmentStatus(wolConfig *wolv1beta1.WolConfig) bool {
+	var keys []string
+	for _, attachment := range r.Mapper.NetworkAttachments(wolConfig.Name) {
+		keys = append(keys, attachment.Key())
+	}
+
+	changed := !slices.Equal(keys, wolConfig.Status.NetworkAttachments)
+	wolConfig.Status.NetworkAttachments = keys
+	return changed
+}
+
+// updateDegradedStatus sets the Degraded condition from the saturation of the aggregator.
+// The aggregator is shared by all WolConfigs, so saturation affects every config.
+func (r *WolConfigReconciler) updateDegradedStatus(wolConfig *wolv1beta1.WolConfig) {
+	if r.Aggregator == nil {
+		return
+	}
+
+	condition := metav1.Condition{
+		Type:               ConditionTypeDegraded,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: wolConfig.Generation,
+		Reason:             ReasonNotSaturated,
+		Message:            "Aggregator resources are below their saturation thresholds",
+	}
+	if report := r.Aggregator.Saturation(); len(report.Saturated()) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonSaturated
+		condition.Message = fmt.Sprintf("Aggregator saturated (%s), WOL events may be delayed or lost", report.Message())
+	}
+	apimeta.SetStatusCondition(&wolConfig.Status.Conditions, condition)
+}
+
+// maxStaleAgentsInMessage bounds the nodes named in the AgentDegraded message
+const maxStaleAgentsInMessage = 5
+
+// updateAgentDegradedStatus sets the AgentDegraded condition from the heartbeats
+// of the agents of this WolConfig. Agents that never sent one are not counted:
+// the DaemonSet status already reports the pods that are not ready.
+func (r *WolConfigReconciler) updateAgentDegradedStatus(wolConfig *wolv1beta1.WolConfig) {
+	if r.Aggregator == nil {
+		return
+	}
+
+	now := time.Now()
+	var stale []string
+	for _, agent := range r.Aggregator.Agents(wolConfig.Name) {
+		if !agent.Alive(now) {
+			stale = append(stale, agent.NodeName)
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:               ConditionTypeAgentDegraded,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: wolConfig.Generation,
+		Reason:             ReasonAgentsAlive,
+		Message:            "All the agents sent a heartbeat recently",
+	}
+	if len(stale) > 0 {
+		nodes := strings.Join(stale[:min(len(stale), maxStaleAgentsInMessage)], ", ")
+		if len(stale) > maxStaleAgentsInMessage {
+			nodes += fmt.Sprintf(" and %d more", len(stale)-maxStaleAgentsInMessage)
+		}
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonAgentsStale
+		condition.Message = fmt.Sprintf("%d agents stopped sending heartbeats, WOL packets on their nodes may be lost: %s",
+			len(stale), nodes)
+	}
+	apimeta.SetStatusCondition(&wolConfig.Status.Conditions, condition)
+}
+
+// defaultMinAgents is the number of connected agents a WolConfig waits for
+// to be Ready when spec.agent.minAgents is not set
+const defaultMinAgents = 1
+
+// minAgents returns the number of connected agents the WolConfig needs to be Ready
+func minAgents(wolConfig *wolv1beta1.WolConfig) int32 {
+	if wolConfig.Spec.Agent.MinAgents != nil {
+		return *wolConfig.Spec.Agent.MinAgents
+	}
+	return defaultMinAgents
+}
+
+// updateAgentsReadyStatus sets the AgentsReady condition from the agents of
+// this WolConfig that sent a heartbeat recently or, without the aggregator,
+// from the ready pods of its DaemonSet
+func (r *WolConfigReconciler) updateAgentsReadyStatus(wolConfig *wolv1beta1.WolConfig) {
+	required := minAgents(wolConfig)
+	var connected int32
+	if r.Aggregator != nil {
+		now := time.Now()
+		for _, agent := range r.Aggregator.Agents(wolConfig.Name) {
+			if agent.Alive(now) {
+				connected++
+			}
+		}
+	} else if wolConfig.Status.AgentStatus != nil {
+		connected = wolConfig.Status.AgentStatus.NumberReady
+	}
+
+	message := fmt.Sprintf("%d agents connected, %d required", connected, required)
+	if agentStatus := wolConfig.Status.AgentStatus; agentStatus != nil {
+		message += fmt.Sprintf(" (%d of %d DaemonSet pods ready)", agentStatus.NumberReady, agentStatus.DesiredNumberScheduled)
+	}
+	condition := metav1.Condition{
+		Type:               ConditionTypeAgentsReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: wolConfig.Generation,
+		Reason:             ReasonAgentsConnected,
+		Message:            message,
+	}
+	if connected < required {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonNotEnoughAgents
+	}
+	apimeta.SetStatusCondition(&wolConfig.Status.Conditions, condition)
+}
+
+// updateGRPCServingStatus sets the GRPCServing condition from the gRPC server
+// of the aggregator: serving, or receiving the heartbeats of the agents
+func (r *WolConfigReconciler) updateGRPCServingStatus(wolConfig *wolv1beta1.WolConfig) {
+	if r.Aggregator == nil {
+		return
+	}
+
+	serving := r.Aggregator.Serving()
+	now := time.Now()
+	for _, agent := range r.Aggregator.Agents(wolConfig.Name) {
+		serving = serving || agent.Alive(now)
+	}
+
+	condition := metav1.Condition{
+		Type:               ConditionTypeGRPCServing,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: wolConfig.Generation,
+		Reason:             ReasonServing,
+		Message:            "The gRPC server is serving the agents",
+	}
+	if !serving {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonNotServing
+		condition.Message = "The gRPC server is not serving, the agents cannot report WOL packets"
+	}
+	apimeta.SetStatusCondition(&wolConfig.Status.Conditions, condition)
+}
+
+// readiness returns the Ready condition of a WolConfig whose mapping was
+// refreshed: True while the mapping is fresh (refreshed within two cache
+// TTLs) and the AgentsReady condition is not False
+func (r *WolConfigReconciler) readiness(wolConfig *wolv1beta1.WolConfig, now time.Time) (bool, string, string) {
+	ttl := time.Duration(wolConfig.Spec.CacheTTL) * time.Second
+	if ttl == 0 {
+		ttl = 5 * time.Minute
+	}
+	if lastSync := wolConfig.Status.LastSync; lastSync == nil || now.Sub(lastSync.Time) > 2*ttl {
+		return false, ReasonMappingStale, "VM mapping not refreshed for more than two cache TTLs"
+	}
+	if agents := apimeta.FindStatusCondition(wolConfig.Status.Conditions, ConditionTypeAgentsReady); agents != nil &&
+		agents.Status == metav1.ConditionFalse {
+		return false, ReasonAgentsNotReady, "Waiting for the agents: " + agents.Message
+	}
+	return true, ReasonMappingUpdated, "VM mapping refreshed successfully"
+}
+
+// updateReadyStatus recomputes the Ready condition of a WolConfig from its
+// mapping and agents, unless the last reconcile failed
+func (r *WolConfigReconciler) updateReadyStatus(wolConfig *wolv1beta1.WolConfig) {
+	ready := apimeta.FindStatusCondition(wolConfig.Status.Conditions, ConditionTypeReady)
+	if ready == nil || (ready.Reason != ReasonMappingUpdated && ready.Reason != ReasonMappingStale &&
+		ready.Reason != ReasonAgentsNotReady) {
+		return
+	}
+
+	isReady, reason, message := r.readiness(wolConfig, time.Now())
+	condition := metav1.Condition{
+		Type:               ConditionTypeReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: wolConfig.Generation,
+		Reason:             reason,
+		Message:            message,
+	}
+	if !isReady {
+		condition.Status = metav1.ConditionFalse
+	}
+	apimeta.SetStatusCondition(&wolConfig.Status.Conditions, condition)
+}
+
+// maxSkewedAgentsInMessage bounds the nodes named in the AgentVersionSkew message
+const maxSkewedAgentsInMessage = 5
+
+// updateAgentVersionSkewStatus sets the AgentVersionSkew condition from the
+// versions in the heartbeats of the agents of this WolConfig. When it becomes
+// True OnVersionSkew is called: the agents are usually left on an old image.
+func (r *WolConfigReconciler) updateAgentVersionSkewStatus(wolConfig *wolv1beta1.WolConfig) {
+	if r.Aggregator == nil {
+		return
+	}
+
+	var skewed []string
+	for _, agent := range r.Aggregator.Agents(wolConfig.Name) {
+		if wol.VersionSkewed(wol.Version, agent.Version) {
+			skewed = append(skewed, fmt.Sprintf("%s (%s)", agent.NodeName, agent.Version))
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:               ConditionTypeAgentVersionSkew,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: wolConfig.Generation,
+		Reason:             ReasonVersionsCompatible,
+		Message:            fmt.Sprintf("All the agents run a version close to the manager %s", wol.Version),
+	}
+	if len(skewed) > 0 {
+		nodes := strings.Join(skewed[:min(len(skewed), maxSkewedAgentsInMessage)], ", ")
+		if len(skewed) > maxSkewedAgentsInMessage {
+			nodes += fmt.Sprintf(" and %d more", len(skewed)-maxSkewedAgentsInMessage)
+		}
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonVersionSkew
+		condition.Message = fmt.Sprintf("%d agents run a version more than a minor release away from the manager %s: %s",
+			len(skewed), wol.Version, nodes)
+	}
+	wasSkewed := apimeta.IsStatusConditionTrue(wolConfig.Status.Conditions, ConditionTypeAgentVersionSkew)
+	apimeta.SetStatusCondition(&wolConfig.Status.Conditions, condition)
+	if len(skewed) > 0 && !wasSkewed && r.OnVersionSkew != nil {
+		r.OnVersionSkew()
+	}
+}
+
+// updateListenerStatus copies the listeners reported by the agents of this WolConfig
+// into its status, returning true if they changed (ignoring the report times)
+func (r *WolConfigReconciler) updateListenerStatus(wolConfig *wolv1beta1.WolConfig) bool {
+	if r.Aggregator == nil {
+		return false
+	}
+
+	var listeners []wolv1beta1.NodeListenerStatus
+	for i, report := range r.Aggregator.Listeners(wolConfig.Name) {
+		if i >= MaxStatusListeners {
+			break
+		}
+		listeners = append(listeners, wolv1beta1.NodeListenerStatus{
+			NodeName:   report.NodeName,
+			Relay:      report.Relay,
+			UDPPorts:   report.UDPPorts,
+			UDP6Ports:  report.UDP6Ports,
+			Interfaces: report.Interfaces,
+			Errors:     report.Errors,
+			LastReport: metav1.NewTime(report.ReportedAt),
+		})
+	}
+
+	changed := !slices.EqualFunc(listeners, wolConfig.Status.Listeners, func(a, b wolv1beta1.NodeListenerStatus) bool {
+		return a.NodeName == b.NodeName && a.Relay == b.Relay && slices.Equal(a.UDPPorts, b.UDPPorts) &&
+			slices.Equal(a.UDP6Ports, b.UDP6Ports) && slices.Equal(a.Interfaces, b.Interfaces) && slices.Equal(a.Errors, b.Errors)
+	})
+	wolConfig.Status.Listeners = listeners
+	return changed
+}
+
+// updateAggregatorStatus refreshes only the status fields that come from the
+// aggregator (Degraded, AgentDegraded, AgentVersionSkew, AgentsReady,
+// GRPCServing and the Ready they gate, listeners and agent nodes)
+// of every WolConfig.
+// Used on saturation, listener and agent changes: a full reconcile would list all
+// the VMs, exactly when the manager is overloaded.
+func (r *WolConfigReconciler) updateAggregatorStatus(ctx context.Context) error {
+	configList := &wolv1beta1.WolConfigList{}
+	if err := r.List(ctx, configList); err != nil {
+		return err
+	}
+
+	var errs []error
+	for i := range configList.Items {
+		key := types.NamespacedName{Name: configList.Items[i].Name}
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			config := &wolv1beta1.WolConfig{}
+			if err := r.Get(ctx, key, config); err != nil {
+				return client.IgnoreNotFound(err)
+			}
+			degraded := conditionSnapshot(config, ConditionTypeDegraded)
+			agentDegraded := conditionSnapshot(config, ConditionTypeAgentDegraded)
+			versionSkew := conditionSnapshot(config, ConditionTypeAgentVersionSkew)
+			agentsReady := conditionSnapshot(config, ConditionTypeAgentsReady)
+			grpcServing := conditionSnapshot(config, ConditionTypeGRPCServing)
+			ready := conditionSnapshot(config, ConditionTypeReady)
+			r.updateDegradedStatus(config)
+			r.updateAgentDegradedStatus(config)
+			r.updateAgentVersionSkewStatus(config)
+			r.updateAgentsReadyStatus(config)
+			r.updateGRPCServingStatus(config)
+			r.updateReadyStatus(config)
+			listenersChanged := r.updateListenerStatus(config)
+			agentsChanged := r.updateAgentNodes(config)
+
+			conditionsChanged := degraded.changed(config) || agentDegraded.changed(config) || versionSkew.changed(config) ||
+				agentsReady.changed(config) || grpcServing.changed(config) || ready.changed(config)
+			if !conditionsChanged && !listenersChanged && !agentsChanged {
+				return nil
+			}
+			return r.Status().Update(ctx, config)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("WolConfig %s: %w", key.Name, err))
+		}
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+// conditionState is a copy of a condition taken before updating it
+type conditionState struct {
+	conditionType string
+	previous      metav1.Condition
+}
+
+func conditionSnapshot(wolConfig *wolv1beta1.WolConfig, conditionType string) conditionState {
+	state := conditionState{conditionType: conditionType}
+	if before := apimeta.FindStatusCondition(wolConfig.Status.Conditions, conditionType); before != nil {
+		state.previous = *before
+	}
+	return state
+}
+
+// changed returns true if the condition status, reason or message differ from the snapshot
+func (c conditionState) changed(wolConfig *wolv1beta1.WolConfig) bool {
+	current := apimeta.FindStatusCondition(wolConfig.Status.Conditions, c.conditionType)
+	return current != nil && (current.Status != c.previous.Status ||
+		current.Reason != c.previous.Reason || current.Message != c.previous.Message)
+}
@@ -19,16 +19,24 @@ package controller
 import (
 	"context"
 	"fmt"
+	"net"
+	"slices"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	kubevirtv1 "kubevirt.io/api/core/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlbuilder "sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
 	"github.com/gpillon/kubevirt-wol/internal/wol"
@@ -45,6 +53,53 @@ const (
 	ReasonMappingUpdated = "MappingUpdated"
 	// ReasonAgentFailed indicates agent DaemonSet reconciliation failed
 	ReasonAgentFailed = "AgentFailed"
+	// ReasonMappingStale indicates the VM mapping was not refreshed for two cache TTLs
+	ReasonMappingStale = "MappingStale"
+	// ReasonAgentsNotReady indicates fewer agents than spec.agent.minAgents are connected
+	ReasonAgentsNotReady = "AgentsNotReady"
+
+	// ConditionTypeAgentsReady indicates at least spec.agent.minAgents agents are connected
+	ConditionTypeAgentsReady = "AgentsReady"
+	// ReasonAgentsConnected indicates enough agents sent a heartbeat recently
+	ReasonAgentsConnected = "AgentsConnected"
+	// ReasonNotEnoughAgents indicates fewer agents than required sent a heartbeat recently
+	ReasonNotEnoughAgents = "NotEnoughAgents"
+
+	// ConditionTypeGRPCServing indicates the gRPC server of the agents is serving
+	ConditionTypeGRPCServing = "GRPCServing"
+	// ReasonServing indicates the gRPC server is listening for the agents
+	ReasonServing = "Serving"
+	// ReasonNotServing indicates the gRPC server is not listening (yet)
+	ReasonNotServing = "NotServing"
+
+	// ConditionTypeMappingConflict indicates some MACs of the WolConfig are claimed by more than one VM
+	ConditionTypeMappingConflict = "MappingConflict"
+	// ReasonDuplicateMAC indicates MACs claimed by more than one VM were found and resolved by policy
+	ReasonDuplicateMAC = "DuplicateMAC"
+	// ReasonNoConflicts indicates no conflicting MACs were found
+	ReasonNoConflicts = "NoConflicts"
+
+	// ConditionTypeDegraded indicates the aggregator serving the WolConfig is overloaded
+	ConditionTypeDegraded = "Degraded"
+	// ReasonSaturated indicates some internal aggregator resources reached their threshold
+	ReasonSaturated = "Saturated"
+	// ReasonNotSaturated indicates all internal aggregator resources are below their threshold
+	ReasonNotSaturated = "NotSaturated"
+
+	// ConditionTypeAgentDegraded indicates some agents of the WolConfig stopped sending heartbeats
+	ConditionTypeAgentDegraded = "AgentDegraded"
+	// ReasonAgentsStale indicates some agents missed their heartbeats (dead, or unable to reach the manager)
+	ReasonAgentsStale = "AgentsStale"
+	// ReasonAgentsAlive indicates all the agents sent a heartbeat recently
+	ReasonAgentsAlive = "AgentsAlive"
+
+	// ConditionTypeAgentVersionSkew indicates some agents of the WolConfig run a
+	// version more than a minor release away from the manager
+	ConditionTypeAgentVersionSkew = "AgentVersionSkew"
+	// ReasonVersionSkew indicates some agents must be updated to the manager version
+	ReasonVersionSkew = "VersionSkew"
+	// ReasonVersionsCompatible indicates all the agents run a version close to the manager
+	ReasonVersionsCompatible = "VersionsCompatible"
 )
 
 // WolConfigReconciler reconciles a WolConfig object
@@ -53,20 +108,49 @@ type WolConfigReconciler struct {
 	Scheme            *runtime.Scheme
 	Mapper            *wol.MACMapper
 	VMStarter         *wol.VMStarter
-	AgentImage        string // Agent image to use for DaemonSets (from AGENT_IMAGE env var)
-	OperatorNamespace string // Namespace where operator is running (from POD_NAMESPACE env var)
+	Aggregator        *wol.Aggregator // Optional, sets the Degraded condition when saturated
+	AgentImage        string          // Agent image to use for DaemonSets (from AGENT_IMAGE env var)
+	OperatorNamespace string          // Namespace where operator is running (from POD_NAMESPACE env var)
+	AgentTLSSecret    string          // Optional, client certificate Secret mounted into the agents (mutual TLS)
+	// AgentTokenAudience, if set, projects a ServiceAccount token for this
+	// audience into the agents, sent to the gRPC server on every RPC
+	AgentTokenAudience string
+	// OpenShift creates the SecurityContextConstraints of the agents and adds
+	// their ServiceAccount to it (see IsOpenShift)
+	OpenShift bool
+
+	// OnVersionSkew is called when the AgentVersionSkew condition of a
+	// WolConfig becomes True, e.g. to check the DaemonSet images again. Optional.
+	OnVersionSkew func()
 }
 
 // +kubebuilder:rbac:groups=wol.pillon.org,resources=wolconfigs,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=wol.pillon.org,resources=wolconfigs/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=wol.pillon.org,resources=wolconfigs/finalizers,verbs=update
+// +kubebuilder:rbac:groups=wol.pillon.org,resources=wolpolicies,verbs=get;list;watch
 // +kubebuilder:rbac:groups=kubevirt.io,resources=virtualmachines,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=kubevirt.io,resources=virtualmachineinstances,verbs=get;list;watch
+// +kubebuilder:rbac:groups=pool.kubevirt.io,resources=virtualmachinepools,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=k8s.cni.cncf.io,resources=network-attachment-definitions,verbs=get;list;watch
 // +kubebuilder:rbac:groups=subresources.kubevirt.io,resources=virtualmachines/start,verbs=update
+// +kubebuilder:rbac:groups=subresources.kubevirt.io,resources=virtualmachines/restart,verbs=update
+// +kubebuilder:rbac:groups=subresources.kubevirt.io,resources=virtualmachineinstances/unpause,verbs=update
+// +kubebuilder:rbac:groups=subresources.kubevirt.io,resources=virtualmachines/stop,verbs=update
+// +kubebuilder:rbac:groups=subresources.kubevirt.io,resources=virtualmachineinstances/pause,verbs=update
+// +kubebuilder:rbac:groups=subresources.kubevirt.io,resources=virtualmachineinstances/userlist,verbs=get
 // +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps,resources=daemonsets/status,verbs=get
 // +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+// +kubebuilder:rbac:groups=security.openshift.io,resources=securitycontextconstraints,verbs=create
+// +kubebuilder:rbac:groups=security.openshift.io,resources=securitycontextconstraints,verbs=get;update,resourceNames=kubevirt-wol-agent
 
 // Reconcile handles WolConfig reconciliation
 func (r *WolConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -76,14 +160,33 @@ func (r *WolConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	config := &wolv1beta1.WolConfig{}
 	if err := r.Get(ctx, req.NamespacedName, config); err != nil {
 		if errors.IsNotFound(err) {
-			// Config deleted, nothing to do
+			// Config deleted: the shared agents may have to drop its ports
 			logger.Info("WolConfig deleted")
+			if err := r.reconcileSharedAgentDaemonSet(ctx); err != nil {
+				logger.Error(err, "Failed to reconcile the shared agent DaemonSet")
+				return ctrl.Result{}, err
+			}
 			return ctrl.Result{}, nil
 		}
 		logger.Error(err, "Failed to get WolConfig")
 		return ctrl.Result{}, err
 	}
 
+	if !config.DeletionTimestamp.IsZero() {
+		logger.Info("Cleaning up the deleted WolConfig", "name", config.Name)
+		if err := r.finalizeConfig(ctx, config); err != nil {
+			logger.Error(err, "Failed to clean up the deleted WolConfig")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	if controllerutil.AddFinalizer(config, WolConfigFinalizer) {
+		if err := r.Update(ctx, config); err != nil {
+			logger.Error(err, "Failed to add the finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
 	logger.Info("Reconciling WolConfig",
 		"name", config.Name,
 		"discoveryMode", config.Spec.DiscoveryMode,
@@ -122,14 +225,32 @@ func (r *WolConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	now := metav1.Now()
 	config.Status.ManagedVMs = managedVMs
 	config.Status.LastSync = &now
+	r.updateConflictStatus(config)
+	r.updateMappingStatus(config)
+	r.updateDegradedStatus(config)
+	r.updateAgentDegradedStatus(config)
+	r.updateAgentVersionSkewStatus(config)
+	r.updateListenerStatus(config)
+
+	// The DaemonSet was built from the previous refresh: rebuild it when the
+	// NADs used by the VMs changed, so the scheduling follows the bridges
+	if r.updateNetworkAttachmentStatus(config) && config.Spec.Agent.NetworkAwareScheduling {
+		if err := r.reconcileAgentDaemonSet(ctx, config); err != nil {
+			logger.Error(err, "Failed to update agent DaemonSet after network attachment change")
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, err
+		}
+	}
 
 	// Update agent status from DaemonSet
 	if err := r.updateAgentStatus(ctx, config); err != nil {
 		logger.Error(err, "Failed to update agent status")
 		// Non fatal, continua
 	}
+	r.updateAgentsReadyStatus(config)
+	r.updateGRPCServingStatus(config)
 
-	if err := r.updateStatus(ctx, config, true, ReasonMappingUpdated, "VM mapping refreshed successfully"); err != nil {
+	ready, reason, message := r.readiness(config, now.Time)
+	if err := r.updateStatus(ctx, config, ready, reason, message); err != nil {
 		logger.Error(err, "Failed to update status")
 		return ctrl.Result{}, err
 	}
@@ -172,6 +293,43 @@ func (r *WolConfigReconciler) validateConfig(config *wolv1beta1.WolConfig) error
 		return fmt.Errorf("invalid cache TTL: %d (must be >= 0)", config.Spec.CacheTTL)
 	}
 
+	// Validate the EtherType of the sleep frames
+	if spec := config.Spec.ShutdownOnLAN; spec != nil && spec.Enabled && spec.EtherType != "" {
+		if _, err := wol.ParseEtherType(spec.EtherType); err != nil {
+			return err
+		}
+	}
+
+	// Validate the interface patterns of the agents
+	if selector := config.Spec.Agent.InterfaceSelector; selector != nil {
+		rules := wol.InterfaceRules{Include: selector.Include, Exclude: selector.Exclude}
+		if err := rules.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate the EtherTypes of the raw wake frames
+	if spec := config.Spec.RawCapture; spec != nil {
+		for _, value := range spec.EtherTypes {
+			etherType, err := wol.ParseWakeEtherType(value)
+			if err != nil {
+				return err
+			}
+			if sleep := config.Spec.ShutdownOnLAN; sleep != nil && sleep.Enabled && sleep.EtherType != "" {
+				if sleepType, _ := wol.ParseEtherType(sleep.EtherType); sleepType == etherType {
+					return fmt.Errorf("EtherType %q is used for both wake and sleep packets", value)
+				}
+			}
+		}
+	}
+
+	// Validate the forward targets (the CRD cannot check IP addresses)
+	for _, mapping := range config.Spec.ExplicitMappings {
+		if mapping.Forward != nil && mapping.Forward.Address != "" && net.ParseIP(mapping.Forward.Address) == nil {
+			return fmt.Errorf("invalid forward address %q for MAC %s", mapping.Forward.Address, mapping.MACAddress)
+		}
+	}
+
 	// Validate based on discovery mode
 	switch config.Spec.DiscoveryMode {
 	case wolv1beta1.DiscoveryModeLabelSelector:
@@ -189,6 +347,12 @@ func (r *WolConfigReconciler) validateConfig(config *wolv1beta1.WolConfig) error
 
 // updateStatus updates the WolConfig status
 func (r *WolConfigReconciler) updateStatus(ctx context.Context, config *wolv1beta1.WolConfig, ready bool, reason, message string) error {
+	setReadyCondition(config, ready, reason, message)
+	return r.Status().Update(ctx, config)
+}
+
+// setReadyCondition sets the Ready condition of the WolConfig
+func setReadyCondition(config *wolv1beta1.WolConfig, ready bool, reason, message string) {
 	status := metav1.ConditionTrue
 	if !ready {
 		status = metav1.ConditionFalse
@@ -216,8 +380,6 @@ func (r *WolConfigReconciler) updateStatus(ctx context.Context, config *wolv1bet
 	if !found {
 		config.Status.Conditions = append(config.Status.Conditions, condition)
 	}
-
-	return r.Status().Update(ctx, config)
 }
 
 // SetupWithManager sets up the controller with the Manager
@@ -233,9 +395,106 @@ func (r *WolConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		handler.EnqueueRequestsFromMapFunc(r.mapVMToConfig),
 	)
 
+	// Watch Namespaces so the ones created or relabeled to match a
+	// NamespaceLabelSelector are watched without waiting for the next refresh
+	builder = builder.Watches(
+		&corev1.Namespace{},
+		handler.EnqueueRequestsFromMapFunc(r.mapNamespaceToConfig),
+		ctrlbuilder.WithPredicates(predicate.LabelChangedPredicate{}),
+	)
+
+	// Watch NetworkAttachmentDefinitions (if Multus is installed) so bridge or
+	// VLAN changes reach the agents and the DaemonSet scheduling
+	if _, err := mgr.GetRESTMapper().RESTMapping(wol.NetworkAttachmentDefinitionGVK.GroupKind(),
+		wol.NetworkAttachmentDefinitionGVK.Version); err == nil {
+		nad := &unstructured.Unstructured{}
+		nad.SetGroupVersionKind(wol.NetworkAttachmentDefinitionGVK)
+		builder = builder.Watches(nad, handler.EnqueueRequestsFromMapFunc(r.mapNADToConfig))
+	} else {
+		mgr.GetLogger().Info("NetworkAttachmentDefinition CRD not found, not watching NADs")
+	}
+
+	// Update the Degraded and AgentDegraded conditions, the listeners and the
+	// agent nodes of all WolConfigs when the aggregator becomes saturated or
+	// recovers, or an agent reports different listeners, (re)starts or becomes
+	// stale, without waiting for the next periodic refresh.
+	// No reconcile is enqueued: it would remap all the VMs while overloaded.
+	if r.Aggregator != nil {
+		aggregatorChanged := make(chan struct{}, 1)
+		notify := func() {
+			select {
+			case aggregatorChanged <- struct{}{}:
+			default: // an update of all configs is already pending
+			}
+		}
+		r.Aggregator.OnSaturationChange(notify)
+		r.Aggregator.OnListenersChange(notify)
+		r.Aggregator.OnAgentsChange(notify)
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-aggregatorChanged:
+					if err := r.updateAggregatorStatus(ctx); err != nil {
+						mgr.GetLogger().Error(err, "Failed to update the aggregator status of WolConfigs")
+					}
+				}
+			}
+		})); err != nil {
+			return err
+		}
+	}
+
 	return builder.Complete(r)
 }
 
+// mapNADToConfig maps NetworkAttachmentDefinition changes to the WolConfigs whose VMs use it
+func (r *WolConfigReconciler) mapNADToConfig(ctx context.Context, obj client.Object) []ctrl.Request {
+	configList := &wolv1beta1.WolConfigList{}
+	if err := r.List(ctx, configList); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list WolConfigs")
+		return []ctrl.Request{}
+	}
+
+	key := obj.GetNamespace() + "/" + obj.GetName()
+	var requests []ctrl.Request
+	for _, config := range configList.Items {
+		if slices.Contains(config.Status.NetworkAttachments, key) {
+			requests = append(requests, ctrl.Request{
+				NamespacedName: client.ObjectKey{
+					Name: config.Name,
+				},
+			})
+		}
+	}
+
+	return requests
+}
+
+// mapNamespaceToConfig maps Namespace changes to the WolConfigs selecting
+// namespaces by label
+func (r *WolConfigReconciler) mapNamespaceToConfig(ctx context.Context, _ client.Object) []ctrl.Request {
+	configList := &wolv1beta1.WolConfigList{}
+	if err := r.List(ctx, configList); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list WolConfigs")
+		return []ctrl.Request{}
+	}
+
+	var requests []ctrl.Request
+	for _, config := range configList.Items {
+		if config.Spec.NamespaceLabelSelector != nil {
+			requests = append(requests, ctrl.Request{
+				NamespacedName: client.ObjectKey{
+					Name: config.Name,
+				},
+			})
+		}
+	}
+
+	return requests
+}
+
 // mapVMToConfig maps VirtualMachine changes to WolConfig reconciliation requests
 func (r *WolConfigReconciler) mapVMToConfig(ctx context.Context, obj client.Object) []ctrl.Request {
 	// List all WolConfigs (should typically be just one)
@@ -259,7 +518,9 @@ func (r *WolConfigReconciler) mapVMToConfig(ctx context.Context, obj client.Obje
 }
 
 // refreshAllConfigs refreshes VM mappings from ALL WolConfigs and merges them
-// This allows multiple configs to work in OR mode
+// This allows multiple configs to work in OR mode: each config is discovered
+// on its own (keeping per-config settings such as the start ServiceAccount)
+// and the results are merged into the global mapping
 func (r *WolConfigReconciler) refreshAllConfigs(ctx context.Context) (int, error) {
 	// List all WolConfigs
 	configList := &wolv1beta1.WolConfigList{}
@@ -267,54 +528,22 @@ func (r *WolConfigReconciler) refreshAllConfigs(ctx context.Context) (int, error
 		return 0, fmt.Errorf("failed to list WolConfigs: %w", err)
 	}
 
-	// Create a synthetic merged config that combines all WolConfigs
-	// This implements OR logic: union of all configs
-	mergedConfig := &wolv1beta1.WolConfig{
-		Spec: wolv1beta1.WolConfigSpec{
-			DiscoveryMode: wolv1beta1.DiscoveryModeAll,
-		},
-	}
-
-	// Collect all namespace selectors from all configs
-	allNamespaces := make(map[string]bool)
-	allExplicitMappings := []wolv1beta1.MACVMMapping{}
-
-	for _, config := range configList.Items {
-		switch config.Spec.DiscoveryMode {
-		case wolv1beta1.DiscoveryModeAll:
-			// Add all namespaces from this config
-			for _, ns := range config.Spec.NamespaceSelectors {
-				allNamespaces[ns] = true
-			}
-		case wolv1beta1.DiscoveryModeExplicit:
-			// Add explicit mappings
-			allExplicitMappings = append(allExplicitMappings, config.Spec.ExplicitMappings...)
-		case wolv1beta1.DiscoveryModeLabelSelector:
-			// For label selectors, we need to discover in their namespaces
-			tempMapper := wol.NewMACMapper(r.Client, ctrl.Log.WithName("mapper"))
-			tempMapper.UpdateConfig(&config)
-			if err := tempMapper.RefreshMapping(ctx); err != nil {
-				ctrl.Log.Error(err, "Failed to refresh label selector config", "config", config.Name)
+	// Update the global mapper with all configs, except those being deleted
+	configList.Items = slices.DeleteFunc(configList.Items, func(config wolv1beta1.WolConfig) bool {
+		return !config.DeletionTimestamp.IsZero()
+	})
+	r.Mapper.UpdateConfigs(configList.Items)
+
+	// Forget the impersonated clients of ServiceAccounts no config uses anymore
+	if r.VMStarter != nil {
+		keep := make(map[string]bool)
+		for _, config := range configList.Items {
+			if sa := config.Spec.StartServiceAccount; sa != nil && sa.Name != "" {
+				keep[wol.ServiceAccountUsername(sa.Namespace, sa.Name)] = true
 			}
 		}
+		r.VMStarter.RetainImpersonation(keep)
 	}
-
-	// Convert namespace map to slice
-	if len(allNamespaces) > 0 {
-		mergedConfig.Spec.NamespaceSelectors = make([]string, 0, len(allNamespaces))
-		for ns := range allNamespaces {
-			mergedConfig.Spec.NamespaceSelectors = append(mergedConfig.Spec.NamespaceSelectors, ns)
-		}
-	}
-
-	// If we have explicit mappings, use them
-	if len(allExplicitMappings) > 0 {
-		mergedConfig.Spec.DiscoveryMode = wolv1beta1.DiscoveryModeExplicit
-		mergedConfig.Spec.ExplicitMappings = allExplicitMappings
-	}
-
-	// Update the global mapper with merged config
-	r.Mapper.UpdateConfig(mergedConfig)
 	if err := r.Mapper.RefreshMapping(ctx); err != nil {
 		return 0, fmt.Errorf("failed to refresh merged mapping: %w", err)
 	}
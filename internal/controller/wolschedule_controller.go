@@ -0,0 +1,306 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	"github.com/gpillon/kubevirt-wol/internal/wol"
+)
+
+const (
+	// ConditionTypeScheduled indicates the WolSchedule runs on its cron schedules
+	ConditionTypeScheduled = "Scheduled"
+	// ReasonScheduleActive indicates the schedule is valid and its last run succeeded
+	ReasonScheduleActive = "Active"
+	// ReasonScheduleSuspended indicates the schedule is suspended
+	ReasonScheduleSuspended = "Suspended"
+	// ReasonInvalidSchedule indicates a cron schedule or the time zone is invalid
+	ReasonInvalidSchedule = "InvalidSchedule"
+	// ReasonScheduleRunFailed indicates the last run failed for some VMs
+	ReasonScheduleRunFailed = "RunFailed"
+)
+
+// Reasons of the Kubernetes Events recorded for the WolSchedule runs
+const (
+	EventReasonScheduledWake        = "ScheduledWake"
+	EventReasonScheduledWakeFailed  = "ScheduledWakeFailed"
+	EventReasonScheduledSleep       = "ScheduledSleep"
+	EventReasonScheduledSleepFailed = "ScheduledSleepFailed"
+)
+
+// ScheduleActions starts, stops and pauses the VMs of the WolSchedules
+type ScheduleActions interface {
+	wol.Starter
+	wol.VMStopper
+}
+
+// WolScheduleReconciler runs the wake and sleep cron schedules of the WolSchedules
+type WolScheduleReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	VMs      ScheduleActions
+	Recorder record.EventRecorder // Optional, records an Event for each run
+
+	now func() time.Time // time.Now, replaced by the tests
+}
+
+// scheduleRun is a cron schedule of a WolSchedule and its last run
+type scheduleRun struct {
+	action string // wake, sleep
+	cron   *wol.CronSchedule
+	last   **metav1.Time
+	next   **metav1.Time
+}
+
+// +kubebuilder:rbac:groups=wol.pillon.org,resources=wolschedules,verbs=get;list;watch
+// +kubebuilder:rbac:groups=wol.pillon.org,resources=wolschedules/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=kubevirt.io,resources=virtualmachines,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile runs the wake and sleep schedules due since their last run, then
+// requeues at the next one
+func (r *WolScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	schedule := &wolv1beta1.WolSchedule{}
+	if err := r.Get(ctx, req.NamespacedName, schedule); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	original := schedule.Status.DeepCopy()
+	status := &schedule.Status
+	now := time.Now()
+	if r.now != nil {
+		now = r.now()
+	}
+
+	runs, err := parseSchedule(schedule)
+	if err != nil || schedule.Spec.Suspend {
+		status.NextWakeTime, status.NextSleepTime = nil, nil
+		reason, message := ReasonScheduleSuspended, "The schedule is suspended"
+		if err != nil {
+			logger.Info("Invalid WolSchedule", "error", err.Error())
+			reason, message = ReasonInvalidSchedule, err.Error()
+		}
+		apimeta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               ConditionTypeScheduled,
+			Status:             metav1.ConditionFalse,
+			Reason:             reason,
+			Message:            message,
+			ObservedGeneration: schedule.Generation,
+		})
+		// Nessun requeue: la modifica della spec riattiva la schedule
+		return ctrl.Result{}, r.updateScheduleStatus(ctx, schedule, original)
+	}
+
+	deadline := now.Add(-time.Duration(schedule.Spec.StartingDeadlineSeconds) * time.Second)
+	var failures []string
+	ran := false
+	for _, run := range runs {
+		from := schedule.CreationTimestamp.Time
+		if *run.last != nil {
+			from = (*run.last).Time
+		}
+		if from.Before(deadline) {
+			from = deadline // le esecuzioni più vecchie sono saltate
+		}
+		due := run.cron.Latest(from, now)
+		if due.IsZero() {
+			continue
+		}
+		ran = true
+		vms, errs := r.runSchedule(ctx, schedule, run.action)
+		failures = append(failures, errs...)
+		*run.last = &metav1.Time{Time: due}
+		status.ScheduledVMs = int32(vms)
+		logger.Info("Ran WolSchedule", "action", run.action, "scheduledTime", due, "vms", vms, "failures", len(errs))
+	}
+
+	condition := metav1.Condition{
+		Type:               ConditionTypeScheduled,
+		Status:             metav1.ConditionTrue,
+		Reason:             ReasonScheduleActive,
+		Message:            "The schedule is active",
+		ObservedGeneration: schedule.Generation,
+	}
+	if len(failures) > 0 {
+		condition.Reason = ReasonScheduleRunFailed
+		condition.Message = strings.Join(failures, "; ")
+	}
+	// Senza esecuzioni resta l'esito dell'ultima, se la schedule era già attiva
+	current := apimeta.FindStatusCondition(status.Conditions, ConditionTypeScheduled)
+	if ran || current == nil || current.Status != metav1.ConditionTrue {
+		apimeta.SetStatusCondition(&status.Conditions, condition)
+	} else {
+		current.ObservedGeneration = schedule.Generation
+	}
+
+	var requeue time.Duration
+	status.NextWakeTime, status.NextSleepTime = nil, nil
+	for _, run := range runs {
+		if next := run.cron.Next(now); !next.IsZero() {
+			*run.next = &metav1.Time{Time: next}
+			if wait := next.Sub(now); requeue == 0 || wait < requeue {
+				requeue = wait
+			}
+		}
+	}
+
+	if err := r.updateScheduleStatus(ctx, schedule, original); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
+
+// parseSchedule parses the cron schedules of a WolSchedule
+func parseSchedule(schedule *wolv1beta1.WolSchedule) ([]scheduleRun, error) {
+	status := &schedule.Status
+	var runs []scheduleRun
+	for _, run := range []struct {
+		action, spec string
+		last, next   **metav1.Time
+	}{
+		{"wake", schedule.Spec.WakeCron, &status.LastWakeTime, &status.NextWakeTime},
+		{"sleep", schedule.Spec.SleepCron, &status.LastSleepTime, &status.NextSleepTime},
+	} {
+		if run.spec == "" {
+			continue
+		}
+		cron, err := wol.ParseCron(run.spec, schedule.Spec.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", run.action, err)
+		}
+		runs = append(runs, scheduleRun{action: run.action, cron: cron, last: run.last, next: run.next})
+	}
+	return runs, nil
+}
+
+// runSchedule wakes or puts to sleep the VMs of a schedule, returning how many
+// VMs it acted on and the failures
+func (r *WolScheduleReconciler) runSchedule(ctx context.Context, schedule *wolv1beta1.WolSchedule, action string) (int, []string) {
+	names, err := r.scheduledVMs(ctx, schedule)
+	if err != nil {
+		r.recordRun(schedule, action, false, err.Error())
+		return 0, []string{fmt.Sprintf("%s: %v", action, err)}
+	}
+
+	var failures []string
+	for _, name := range names {
+		var err error
+		switch {
+		case action == "wake":
+			err = r.VMs.StartVMAs(ctx, "", schedule.Namespace, name)
+		case schedule.Spec.SleepAction == wolv1beta1.ShutdownActionPause:
+			err = r.VMs.PauseVMAs(ctx, "", schedule.Namespace, name)
+		default:
+			err = r.VMs.StopVMAs(ctx, "", schedule.Namespace, name)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s %s: %v", action, name, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		r.recordRun(schedule, action, false, strings.Join(failures, "; "))
+	} else {
+		r.recordRun(schedule, action, true, fmt.Sprintf("Scheduled %s of %d VMs", action, len(names)))
+	}
+	return len(names), failures
+}
+
+// scheduledVMs returns the names of the VMs of a schedule: VMNames and the VMs
+// matching VMSelector
+func (r *WolScheduleReconciler) scheduledVMs(ctx context.Context, schedule *wolv1beta1.WolSchedule) ([]string, error) {
+	names := slices.Clone(schedule.Spec.VMNames)
+	if schedule.Spec.VMSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(schedule.Spec.VMSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vmSelector: %w", err)
+		}
+		vmList := &kubevirtv1.VirtualMachineList{}
+		if err := r.List(ctx, vmList, client.InNamespace(schedule.Namespace),
+			client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, fmt.Errorf("failed to list VirtualMachines: %w", err)
+		}
+		for _, vm := range vmList.Items {
+			names = append(names, vm.Name)
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// recordRun counts a run and records its Event on the schedule
+func (r *WolScheduleReconciler) recordRun(schedule *wolv1beta1.WolSchedule, action string, success bool, message string) {
+	result := "success"
+	if !success {
+		result = "failed"
+	}
+	wol.ScheduleRunsTotal.WithLabelValues(action, result).Inc()
+	if r.Recorder == nil {
+		return
+	}
+
+	eventType, reason := corev1.EventTypeNormal, EventReasonScheduledWake
+	switch {
+	case action == "wake" && !success:
+		eventType, reason = corev1.EventTypeWarning, EventReasonScheduledWakeFailed
+	case action == "sleep" && success:
+		reason = EventReasonScheduledSleep
+	case action == "sleep":
+		eventType, reason = corev1.EventTypeWarning, EventReasonScheduledSleepFailed
+	}
+	r.Recorder.Event(schedule, eventType, reason, message)
+}
+
+// updateScheduleStatus writes the status of a schedule if it changed
+func (r *WolScheduleReconciler) updateScheduleStatus(ctx context.Context, schedule *wolv1beta1.WolSchedule, original *wolv1beta1.WolScheduleStatus) error {
+	if equality.Semantic.DeepEqual(original, &schedule.Status) {
+		return nil
+	}
+	if err := r.Status().Update(ctx, schedule); err != nil {
+		return fmt.Errorf("failed to update WolSchedule status: %w", err)
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager. The status updates
+// do not trigger reconciles: the next runs are requeued.
+func (r *WolScheduleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&wolv1beta1.WolSchedule{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Named("wol-wolschedule").
+		Complete(r)
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// scheduleActions records the actions of the WolSchedules by VM
+type scheduleActions struct {
+	actions map[string]string
+	failing string // VM whose actions fail
+}
+
+func (s *scheduleActions) do(action, name string) error {
+	if name == s.failing {
+		return fmt.Errorf("VM %s not found", name)
+	}
+	s.actions[name] = action
+	return nil
+}
+
+func (s *scheduleActions) StartVMAs(_ context.Context, _, _, name string) error {
+	return s.do("start", name)
+}
+
+func (s *scheduleActions) StopVMAs(_ context.Context, _, _, name string) error {
+	return s.do("stop", name)
+}
+
+func (s *scheduleActions) PauseVMAs(_ context.Context, _, _, name string) error {
+	return s.do("pause", name)
+}
+
+func (s *scheduleActions) QueuedWakes() int { return 0 }
+
+var _ = Describe("WolSchedule Controller", func() {
+	var (
+		ctx        context.Context
+		now        time.Time
+		vms        *scheduleActions
+		recorder   *record.FakeRecorder
+		reconciler *WolScheduleReconciler
+		key        types.NamespacedName
+	)
+
+	newReconciler := func(objects ...client.Object) {
+		s := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+		Expect(wolv1beta1.AddToScheme(s)).To(Succeed())
+		Expect(kubevirtv1.AddToScheme(s)).To(Succeed())
+		vms = &scheduleActions{actions: make(map[string]string)}
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &WolScheduleReconciler{
+			Client: fake.NewClientBuilder().WithScheme(s).WithObjects(objects...).
+				WithStatusSubresource(&wolv1beta1.WolSchedule{}).Build(),
+			Scheme:   s,
+			VMs:      vms,
+			Recorder: recorder,
+			now:      func() time.Time { return now },
+		}
+	}
+
+	vm := func(name string, labels map[string]string) *kubevirtv1.VirtualMachine {
+		return &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a", Labels: labels}}
+	}
+
+	reconcile := func() (ctrl.Result, *wolv1beta1.WolSchedule) {
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		schedule := &wolv1beta1.WolSchedule{}
+		Expect(reconciler.Get(ctx, key, schedule)).To(Succeed())
+		return result, schedule
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Name: "office-hours", Namespace: "team-a"}
+		now = time.Date(2025, time.March, 14, 7, 0, 0, 0, time.UTC) // venerdì
+	})
+
+	newSchedule := func() *wolv1beta1.WolSchedule {
+		return &wolv1beta1.WolSchedule{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: key.Name, Namespace: key.Namespace,
+				CreationTimestamp: metav1.NewTime(now.Add(-time.Hour)),
+			},
+			Spec: wolv1beta1.WolScheduleSpec{
+				VMNames:                 []string{"vm1"},
+				VMSelector:              &metav1.LabelSelector{MatchLabels: map[string]string{"office": "true"}},
+				WakeCron:                "30 7 * * 1-5",
+				SleepCron:               "0 20 * * 1-5",
+				SleepAction:             wolv1beta1.ShutdownActionStop,
+				TimeZone:                "UTC",
+				StartingDeadlineSeconds: 600,
+			},
+		}
+	}
+
+	It("should wake and stop the VMs on their schedules", func() {
+		newReconciler(newSchedule(), vm("vm2", map[string]string{"office": "true"}), vm("vm3", nil))
+
+		result, schedule := reconcile()
+		Expect(vms.actions).To(BeEmpty())
+		Expect(result.RequeueAfter).To(Equal(30 * time.Minute))
+		Expect(schedule.Status.NextWakeTime.Time).To(BeTemporally("==", time.Date(2025, time.March, 14, 7, 30, 0, 0, time.UTC)))
+		Expect(schedule.Status.NextSleepTime.Time).To(BeTemporally("==", time.Date(2025, time.March, 14, 20, 0, 0, 0, time.UTC)))
+		Expect(apimeta.IsStatusConditionTrue(schedule.Status.Conditions, ConditionTypeScheduled)).To(BeTrue())
+
+		now = time.Date(2025, time.March, 14, 7, 30, 5, 0, time.UTC)
+		result, schedule = reconcile()
+		Expect(vms.actions).To(Equal(map[string]string{"vm1": "start", "vm2": "start"}))
+		Expect(schedule.Status.LastWakeTime.Time).To(BeTemporally("==", time.Date(2025, time.March, 14, 7, 30, 0, 0, time.UTC)))
+		Expect(schedule.Status.NextWakeTime.Time).To(BeTemporally("==", time.Date(2025, time.March, 17, 7, 30, 0, 0, time.UTC)))
+		Expect(schedule.Status.ScheduledVMs).To(Equal(int32(2)))
+		Expect(result.RequeueAfter).To(Equal(12*time.Hour + 29*time.Minute + 55*time.Second))
+		Expect(recorder.Events).To(Receive(ContainSubstring(EventReasonScheduledWake)))
+
+		// Un secondo reconcile non ripete l'esecuzione
+		vms.actions = make(map[string]string)
+		_, _ = reconcile()
+		Expect(vms.actions).To(BeEmpty())
+
+		now = time.Date(2025, time.March, 14, 20, 0, 0, 0, time.UTC)
+		_, schedule = reconcile()
+		Expect(vms.actions).To(Equal(map[string]string{"vm1": "stop", "vm2": "stop"}))
+		Expect(schedule.Status.LastSleepTime.Time).To(BeTemporally("==", now))
+	})
+
+	It("should skip the runs missed for longer than the starting deadline", func() {
+		newReconciler(newSchedule())
+		now = time.Date(2025, time.March, 14, 7, 41, 0, 0, time.UTC)
+		_, schedule := reconcile()
+		Expect(vms.actions).To(BeEmpty())
+		Expect(schedule.Status.LastWakeTime).To(BeNil())
+	})
+
+	It("should report the failed runs", func() {
+		schedule := newSchedule()
+		schedule.Spec.VMNames = []string{"vm1", "missing"}
+		schedule.Spec.SleepAction = wolv1beta1.ShutdownActionPause
+		newReconciler(schedule)
+		vms.failing = "missing"
+
+		now = time.Date(2025, time.March, 14, 20, 1, 0, 0, time.UTC)
+		_, schedule = reconcile()
+		Expect(vms.actions).To(Equal(map[string]string{"vm1": "pause"}))
+		condition := apimeta.FindStatusCondition(schedule.Status.Conditions, ConditionTypeScheduled)
+		Expect(condition.Reason).To(Equal(ReasonScheduleRunFailed))
+		Expect(condition.Message).To(ContainSubstring("sleep missing"))
+		Expect(recorder.Events).To(Receive(ContainSubstring(EventReasonScheduledSleepFailed)))
+	})
+
+	It("should not run invalid or suspended schedules", func() {
+		schedule := newSchedule()
+		schedule.Spec.TimeZone = "Mars/Olympus_Mons"
+		newReconciler(schedule)
+		now = time.Date(2025, time.March, 14, 7, 30, 0, 0, time.UTC)
+
+		result, schedule := reconcile()
+		Expect(vms.actions).To(BeEmpty())
+		Expect(result.RequeueAfter).To(BeZero())
+		condition := apimeta.FindStatusCondition(schedule.Status.Conditions, ConditionTypeScheduled)
+		Expect(condition.Reason).To(Equal(ReasonInvalidSchedule))
+
+		schedule.Spec.TimeZone = "UTC"
+		schedule.Spec.Suspend = true
+		Expect(reconciler.Update(ctx, schedule)).To(Succeed())
+		result, schedule = reconcile()
+		Expect(vms.actions).To(BeEmpty())
+		Expect(result.RequeueAfter).To(BeZero())
+		Expect(schedule.Status.NextWakeTime).To(BeNil())
+		condition = apimeta.FindStatusCondition(schedule.Status.Conditions, ConditionTypeScheduled)
+		Expect(condition.Reason).To(Equal(ReasonScheduleSuspended))
+	})
+})
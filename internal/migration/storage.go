@@ -0,0 +1,142 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package migration moves the stored custom resources to the storage version
+// of their CRD, so the old versions can be dropped from the CRD.
+package migration
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// WolConfigCRD is the name of the WolConfig CRD
+const WolConfigCRD = "wolconfigs.wol.pillon.org"
+
+var crdGVK = schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}
+
+// StorageVersionMigrator rewrites every object of a CRD, so the API server
+// stores it again in the storage version, then records in the CRD status that
+// only the storage version is stored. The CRDs are read as unstructured
+// objects: no typed client is needed for them.
+type StorageVersionMigrator struct {
+	Client client.Client
+	Log    logr.Logger
+}
+
+// Migrate migrates the objects of the CRD. The storedVersions of the CRD are
+// left as they are if any object cannot be rewritten, so the migration can
+// be run again.
+func (m *StorageVersionMigrator) Migrate(ctx context.Context, crdName string) error {
+	crd := &unstructured.Unstructured{}
+	crd.SetGroupVersionKind(crdGVK)
+	if err := m.Client.Get(ctx, client.ObjectKey{Name: crdName}, crd); err != nil {
+		return fmt.Errorf("failed to get CRD %s: %w", crdName, err)
+	}
+	storageVersion, err := crdStorageVersion(crd)
+	if err != nil {
+		return err
+	}
+	storedVersions, _, _ := unstructured.NestedStringSlice(crd.Object, "status", "storedVersions")
+	if slices.Equal(storedVersions, []string{storageVersion}) {
+		m.Log.Info("Nothing to migrate", "crd", crdName, "storageVersion", storageVersion)
+		return nil
+	}
+	m.Log.Info("Migrating the stored objects", "crd", crdName, "storedVersions", storedVersions,
+		"storageVersion", storageVersion)
+
+	group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+	kind, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+	gvk := schema.GroupVersionKind{Group: group, Version: storageVersion, Kind: kind}
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(kind + "List"))
+	if err := m.Client.List(ctx, list); err != nil {
+		return fmt.Errorf("failed to list %s: %w", crdName, err)
+	}
+
+	failed := 0
+	for i := range list.Items {
+		if err := m.rewrite(ctx, &list.Items[i]); err != nil {
+			m.Log.Error(err, "Failed to migrate object", "kind", kind, "name", list.Items[i].GetName(),
+				"namespace", list.Items[i].GetNamespace())
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to migrate %d of %d %s, storedVersions left as %v",
+			failed, len(list.Items), crdName, storedVersions)
+	}
+
+	// Solo ora le versioni precedenti non hanno più oggetti in etcd
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := m.Client.Get(ctx, client.ObjectKey{Name: crdName}, crd); err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedStringSlice(crd.Object, []string{storageVersion}, "status", "storedVersions"); err != nil {
+			return err
+		}
+		return m.Client.Status().Update(ctx, crd)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update the storedVersions of CRD %s: %w", crdName, err)
+	}
+	m.Log.Info("Migration completed", "crd", crdName, "objects", len(list.Items), "storageVersion", storageVersion)
+	return nil
+}
+
+// rewrite updates the object unchanged: the API server writes it again in
+// the storage version. Objects deleted in the meantime need no migration.
+func (m *StorageVersionMigrator) rewrite(ctx context.Context, obj *unstructured.Unstructured) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		err := m.Client.Update(ctx, obj)
+		if apierrors.IsConflict(err) {
+			// Riletto per riscriverlo con il resourceVersion corrente
+			if getErr := m.Client.Get(ctx, client.ObjectKeyFromObject(obj), obj); getErr != nil {
+				return getErr
+			}
+		}
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// crdStorageVersion returns the version of the CRD marked as storage version
+func crdStorageVersion(crd *unstructured.Unstructured) (string, error) {
+	versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if storage, _ := version["storage"].(bool); storage {
+			if name, _ := version["name"].(string); name != "" {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("CRD %s has no storage version", crd.GetName())
+}
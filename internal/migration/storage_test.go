@@ -0,0 +1,127 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+var wolConfigGVK = schema.GroupVersionKind{Group: "wol.pillon.org", Version: "v1", Kind: "WolConfig"}
+
+func newTestCRD(storedVersions ...string) *unstructured.Unstructured {
+	crd := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": WolConfigCRD},
+		"spec": map[string]interface{}{
+			"group": "wol.pillon.org",
+			"names": map[string]interface{}{"kind": "WolConfig"},
+			"versions": []interface{}{
+				map[string]interface{}{"name": "v1", "served": true, "storage": true},
+				map[string]interface{}{"name": "v1beta1", "served": true, "storage": false},
+			},
+		},
+	}}
+	crd.SetGroupVersionKind(crdGVK)
+	_ = unstructured.SetNestedStringSlice(crd.Object, storedVersions, "status", "storedVersions")
+	return crd
+}
+
+func newTestWolConfig(name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(wolConfigGVK)
+	obj.SetName(name)
+	return obj
+}
+
+// newMigrationClient counts the WolConfigs updated, failing the ones named in fail
+func newMigrationClient(updated *[]string, fail string, objs ...client.Object) client.Client {
+	crd := newTestCRD()
+	return fake.NewClientBuilder().WithScheme(runtime.NewScheme()).
+		WithObjects(objs...).WithStatusSubresource(crd).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				if obj.GetObjectKind().GroupVersionKind() == wolConfigGVK {
+					if obj.GetName() == fail {
+						return errors.New("admission webhook denied the request")
+					}
+					*updated = append(*updated, obj.GetName())
+				}
+				return c.Update(ctx, obj, opts...)
+			},
+		}).Build()
+}
+
+func storedVersions(t *testing.T, c client.Client) []string {
+	crd := newTestCRD()
+	if err := c.Get(context.Background(), client.ObjectKey{Name: WolConfigCRD}, crd); err != nil {
+		t.Fatal(err)
+	}
+	versions, _, _ := unstructured.NestedStringSlice(crd.Object, "status", "storedVersions")
+	return versions
+}
+
+func TestStorageVersionMigrator_Migrate(t *testing.T) {
+	var updated []string
+	c := newMigrationClient(&updated, "", newTestCRD("v1beta1", "v1"),
+		newTestWolConfig("default"), newTestWolConfig("lab"))
+	migrator := &StorageVersionMigrator{Client: c, Log: logr.Discard()}
+
+	if err := migrator.Migrate(context.Background(), WolConfigCRD); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	slices.Sort(updated)
+	if !slices.Equal(updated, []string{"default", "lab"}) {
+		t.Errorf("Expected every WolConfig to be rewritten, got %v", updated)
+	}
+	if got := storedVersions(t, c); !slices.Equal(got, []string{"v1"}) {
+		t.Errorf("Expected storedVersions [v1], got %v", got)
+	}
+
+	// Già migrato: nessun oggetto riscritto
+	updated = nil
+	if err := migrator.Migrate(context.Background(), WolConfigCRD); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(updated) != 0 {
+		t.Errorf("Expected nothing to be rewritten once migrated, got %v", updated)
+	}
+}
+
+func TestStorageVersionMigrator_KeepsStoredVersionsOnFailure(t *testing.T) {
+	var updated []string
+	c := newMigrationClient(&updated, "lab", newTestCRD("v1beta1", "v1"),
+		newTestWolConfig("default"), newTestWolConfig("lab"))
+	migrator := &StorageVersionMigrator{Client: c, Log: logr.Discard()}
+
+	if err := migrator.Migrate(context.Background(), WolConfigCRD); err == nil {
+		t.Fatal("Expected an error when a WolConfig cannot be rewritten")
+	}
+	// lab è ancora salvato in v1beta1: la versione non può sparire dalla CRD
+	if got := storedVersions(t, c); !slices.Equal(got, []string{"v1beta1", "v1"}) {
+		t.Errorf("Expected storedVersions to be kept, got %v", got)
+	}
+}
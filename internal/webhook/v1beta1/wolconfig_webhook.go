@@ -0,0 +1,179 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+var wolconfiglog = logf.Log.WithName("wolconfig-resource")
+
+// SetupWolConfigWebhookWithManager registers the webhook for WolConfig in the manager.
+func SetupWolConfigWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&wolv1beta1.WolConfig{}).
+		WithValidator(&WolConfigCustomValidator{Client: mgr.GetClient()}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-wol-pillon-org-v1beta1-wolconfig,mutating=false,failurePolicy=fail,sideEffects=None,groups=wol.pillon.org,resources=wolconfigs,verbs=create;update,versions=v1beta1,name=vwolconfig-v1beta1.kb.io,admissionReviewVersions=v1
+
+// WolConfigCustomValidator rejects WolConfigs whose agents would bind the same
+// WOL ports as the agents of another WolConfig on the same nodes.
+// The agents use hostNetwork: two of them on a node cannot bind the same UDP port.
+type WolConfigCustomValidator struct {
+	Client client.Reader
+}
+
+var _ webhook.CustomValidator = &WolConfigCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type WolConfig.
+func (v *WolConfigCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	wolConfig, ok := obj.(*wolv1beta1.WolConfig)
+	if !ok {
+		return nil, fmt.Errorf("expected a WolConfig object but got %T", obj)
+	}
+	wolconfiglog.V(1).Info("Validation for WolConfig upon creation", "name", wolConfig.GetName())
+
+	return v.validatePortConflicts(ctx, wolConfig)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type WolConfig.
+func (v *WolConfigCustomValidator) ValidateUpdate(ctx context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	wolConfig, ok := newObj.(*wolv1beta1.WolConfig)
+	if !ok {
+		return nil, fmt.Errorf("expected a WolConfig object for the newObj but got %T", newObj)
+	}
+	wolconfiglog.V(1).Info("Validation for WolConfig upon update", "name", wolConfig.GetName())
+
+	return v.validatePortConflicts(ctx, wolConfig)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type WolConfig.
+func (v *WolConfigCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validatePortConflicts compares the WolConfig with the existing ones sharing a WOL port.
+// Configs whose node selectors currently match a common node are rejected; configs whose
+// selectors only could match a common node (no node has all the labels yet) get a warning.
+// Configs that both have shared agents never conflict: a single DaemonSet serves them.
+func (v *WolConfigCustomValidator) validatePortConflicts(ctx context.Context, wolConfig *wolv1beta1.WolConfig) (admission.Warnings, error) {
+	configList := &wolv1beta1.WolConfigList{}
+	if err := v.Client.List(ctx, configList); err != nil {
+		return nil, fmt.Errorf("failed to list WolConfigs: %w", err)
+	}
+
+	var warnings admission.Warnings
+	var conflicts []string
+	for i := range configList.Items {
+		other := &configList.Items[i]
+		if other.Name == wolConfig.Name || (wolConfig.Spec.Agent.Shared && other.Spec.Agent.Shared) {
+			continue
+		}
+		shared := sharedPorts(wolConfig, other)
+		if len(shared) == 0 || selectorsDisjoint(wolConfig.Spec.Agent.NodeSelector, other.Spec.Agent.NodeSelector) {
+			continue
+		}
+
+		nodes, err := v.commonNodes(ctx, wolConfig.Spec.Agent.NodeSelector, other.Spec.Agent.NodeSelector)
+		if err != nil {
+			return nil, err
+		}
+		if len(nodes) == 0 {
+			warnings = append(warnings, fmt.Sprintf(
+				"WolConfig %s also listens on ports %v: no node currently matches both node selectors, "+
+					"but a node labeled for both would run two agents on the same ports", other.Name, shared))
+			continue
+		}
+		conflicts = append(conflicts, fmt.Sprintf("WolConfig %s listens on ports %v on %d nodes (e.g. %s)",
+			other.Name, shared, len(nodes), nodes[0]))
+	}
+
+	if len(conflicts) > 0 {
+		return warnings, fmt.Errorf("WOL port conflict, the agents would fight over the host ports: %s; "+
+			"use different wolPorts or disjoint agent.nodeSelector", strings.Join(conflicts, "; "))
+	}
+	return warnings, nil
+}
+
+// commonNodes returns the names of the nodes matching both selectors, sorted
+func (v *WolConfigCustomValidator) commonNodes(ctx context.Context, a, b map[string]string) ([]string, error) {
+	merged := make(map[string]string, len(a)+len(b))
+	for k, val := range a {
+		merged[k] = val
+	}
+	for k, val := range b {
+		merged[k] = val
+	}
+
+	nodeList := &corev1.NodeList{}
+	if err := v.Client.List(ctx, nodeList, client.MatchingLabelsSelector{Selector: labels.SelectorFromSet(merged)}); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	names := make([]string, 0, len(nodeList.Items))
+	for _, node := range nodeList.Items {
+		names = append(names, node.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// sharedPorts returns the WOL ports used by both configs (9 when none is set)
+func sharedPorts(a, b *wolv1beta1.WolConfig) []int {
+	portsB := effectivePorts(b)
+	var shared []int
+	for _, port := range effectivePorts(a) {
+		if slices.Contains(portsB, port) && !slices.Contains(shared, port) {
+			shared = append(shared, port)
+		}
+	}
+	slices.Sort(shared)
+	return shared
+}
+
+func effectivePorts(wolConfig *wolv1beta1.WolConfig) []int {
+	if len(wolConfig.Spec.WOLPorts) == 0 {
+		return []int{9} // Default
+	}
+	return wolConfig.Spec.WOLPorts
+}
+
+// selectorsDisjoint returns true if no node can match both selectors,
+// i.e. they require different values for the same label
+func selectorsDisjoint(a, b map[string]string) bool {
+	for k, val := range a {
+		if other, found := b[k]; found && other != val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+func newWolConfig(name string, ports []int, nodeSelector map[string]string) *wolv1beta1.WolConfig {
+	return &wolv1beta1.WolConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: wolv1beta1.WolConfigSpec{
+			WOLPorts: ports,
+			Agent:    wolv1beta1.AgentSpec{NodeSelector: nodeSelector},
+		},
+	}
+}
+
+func newNode(name string, labels map[string]string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestWolConfigValidator_PortConflicts(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = wolv1beta1.AddToScheme(scheme)
+
+	existing := newWolConfig("rack-a", nil, map[string]string{"rack": "a"})
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		existing,
+		newNode("node-a", map[string]string{"rack": "a", "wol": "true"}),
+		newNode("node-b", map[string]string{"rack": "b", "wol": "true"}),
+	).Build()
+	validator := &WolConfigCustomValidator{Client: c}
+
+	tests := []struct {
+		name        string
+		config      *wolv1beta1.WolConfig
+		wantErr     bool
+		wantWarning bool
+	}{
+		{"default port on all nodes", newWolConfig("all", nil, nil), true, false},
+		{"same port on overlapping nodes", newWolConfig("wol", []int{7, 9}, map[string]string{"wol": "true"}), true, false},
+		{"different port", newWolConfig("other-port", []int{7}, nil), false, false},
+		{"disjoint selectors", newWolConfig("rack-b", nil, map[string]string{"rack": "b"}), false, false},
+		{"no common node yet", newWolConfig("gpu", nil, map[string]string{"gpu": "true"}), false, true},
+		{"update of the same config", newWolConfig("rack-a", nil, nil), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := validator.ValidateCreate(context.Background(), tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "rack-a") {
+				t.Errorf("Expected the error to name the conflicting config, got %v", err)
+			}
+			if (len(warnings) > 0) != tt.wantWarning {
+				t.Errorf("Expected warning %v, got %v", tt.wantWarning, warnings)
+			}
+		})
+	}
+}
+
+func TestWolConfigValidator_SharedAgents(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = wolv1beta1.AddToScheme(scheme)
+
+	existing := newWolConfig("lab", nil, nil)
+	existing.Spec.Agent.Shared = true
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing, newNode("node-a", nil)).Build()
+	validator := &WolConfigCustomValidator{Client: c}
+
+	// Gli agent condivisi servono entrambe: nessun conflitto
+	config := newWolConfig("prod", nil, nil)
+	config.Spec.Agent.Shared = true
+	if _, err := validator.ValidateCreate(context.Background(), config); err != nil {
+		t.Errorf("Expected shared configs on the same port to be accepted, got %v", err)
+	}
+
+	config.Spec.Agent.Shared = false
+	if _, err := validator.ValidateCreate(context.Background(), config); err == nil {
+		t.Error("Expected a dedicated config on the port of the shared agents to be rejected")
+	}
+}
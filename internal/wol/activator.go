@@ -0,0 +1,542 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// ActivatorWakeSource is the source reported to the operator by activator wake requests
+const ActivatorWakeSource = "activator"
+
+// ActivatorPort is a port the activator listens on and forwards to the VM
+type ActivatorPort struct {
+	// Protocol is "tcp" or "udp"
+	Protocol string
+	// Port the activator listens on
+	Port int
+	// TargetPort on the backend (defaults to Port)
+	TargetPort int
+}
+
+// String returns the <protocol>:<port>[:<targetPort>] form accepted by ParseActivatorPorts
+func (p ActivatorPort) String() string {
+	if p.TargetPort != 0 && p.TargetPort != p.Port {
+		return fmt.Sprintf("%s:%d:%d", p.Protocol, p.Port, p.TargetPort)
+	}
+	return fmt.Sprintf("%s:%d", p.Protocol, p.Port)
+}
+
+// ParseActivatorPorts parses a comma-separated list of <protocol>:<port>[:<targetPort>]
+// (protocol defaults to tcp), e.g. "22,tcp:80:8080,udp:53"
+func ParseActivatorPorts(spec string) ([]ActivatorPort, error) {
+	var ports []ActivatorPort
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		fields := strings.Split(part, ":")
+		port := ActivatorPort{Protocol: "tcp"}
+		if _, err := strconv.Atoi(fields[0]); err != nil {
+			port.Protocol = strings.ToLower(fields[0])
+			fields = fields[1:]
+		}
+		if port.Protocol != "tcp" && port.Protocol != "udp" {
+			return nil, fmt.Errorf("invalid protocol in %q (must be tcp or udp)", part)
+		}
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid port %q (expected <protocol>:<port>[:<targetPort>])", part)
+		}
+
+		numbers := make([]int, len(fields))
+		for i, field := range fields {
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port %q: %w", part, err)
+			}
+			if n < 1 || n > 65535 {
+				return nil, fmt.Errorf("port %d out of range (must be 1-65535)", n)
+			}
+			numbers[i] = n
+		}
+		port.Port = numbers[0]
+		port.TargetPort = numbers[len(numbers)-1]
+		ports = append(ports, port)
+	}
+
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no ports specified")
+	}
+	return ports, nil
+}
+
+// ActivatorOptions configures an Activator
+type ActivatorOptions struct {
+	// VMNamespace and VMName identify the VM fronted by the activator
+	VMNamespace string
+	VMName      string
+	// BackendHost is the address of the VM (usually a Service selecting the VM pod)
+	BackendHost string
+	// Ports to listen on and forward to the backend
+	Ports []ActivatorPort
+	// ReadyTimeout is how long a connection is held while the VM starts
+	ReadyTimeout time.Duration
+	// DialTimeout is the timeout of a single connection attempt to the backend
+	DialTimeout time.Duration
+	// RetryInterval is the pause between connection attempts while the VM starts
+	RetryInterval time.Duration
+	// WakeInterval is the minimum interval between two wake requests
+	WakeInterval time.Duration
+	// UDPIdleTimeout closes UDP flows without traffic for this long
+	UDPIdleTimeout time.Duration
+	// MaxUDPFlows caps the UDP flows of a port; the least recently used
+	// flow is closed to make room for a new client
+	MaxUDPFlows int
+	// MaxPendingConnections caps the TCP connections held while the VM
+	// starts; further clients are closed right away
+	MaxPendingConnections int
+	// HealthPort serves /healthz and /readyz (0 disables it)
+	HealthPort int
+	// TLS secures the connection to the operator (plaintext if nil)
+	TLS *tls.Config
+	// TokenFile is the ServiceAccount token sent to the operator on every RPC ("" sends none)
+	TokenFile string
+}
+
+// Activator fronts the service ports of a stopped VM: the first incoming
+// connection triggers a wake through the operator and is held until the VM
+// accepts it (scale-from-zero for VMs, without the client sending WOL).
+type Activator struct {
+	opts         ActivatorOptions
+	operatorAddr string
+	log          logr.Logger
+	grpcConn     *grpc.ClientConn
+	grpcClient   wolv1.WOLServiceClient
+
+	wakeMu   sync.Mutex
+	lastWake time.Time
+
+	// Connessioni TCP in attesa che la VM accetti
+	pending atomic.Int64
+
+	closersMu sync.Mutex
+	closers   []io.Closer
+	wg        sync.WaitGroup
+}
+
+// NewActivator creates a new activator, filling unset options with defaults
+func NewActivator(operatorAddr string, opts ActivatorOptions, log logr.Logger) *Activator {
+	if opts.ReadyTimeout <= 0 {
+		opts.ReadyTimeout = 3 * time.Minute
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 2 * time.Second
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = time.Second
+	}
+	if opts.WakeInterval <= 0 {
+		opts.WakeInterval = 10 * time.Second
+	}
+	if opts.UDPIdleTimeout <= 0 {
+		opts.UDPIdleTimeout = time.Minute
+	}
+	if opts.MaxUDPFlows <= 0 {
+		opts.MaxUDPFlows = 1024
+	}
+	if opts.MaxPendingConnections <= 0 {
+		opts.MaxPendingConnections = 256
+	}
+	for i := range opts.Ports {
+		if opts.Ports[i].TargetPort == 0 {
+			opts.Ports[i].TargetPort = opts.Ports[i].Port
+		}
+	}
+
+	return &Activator{
+		opts:         opts,
+		operatorAddr: operatorAddr,
+		log:          log,
+	}
+}
+
+// Start connects to the operator and serves the configured ports until ctx is cancelled
+func (a *Activator) Start(ctx context.Context) error {
+	a.log.Info("Connecting to operator gRPC server", "address", a.operatorAddr)
+
+	var err error
+	creds := insecure.NewCredentials()
+	if a.opts.TLS != nil {
+		creds = credentials.NewTLS(a.opts.TLS)
+	}
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if a.opts.TokenFile != "" {
+		dialOpts = append(dialOpts, TokenFileCredentials(a.opts.TokenFile))
+	}
+	a.grpcConn, err = grpc.NewClient(a.operatorAddr, dialOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to operator: %w", err)
+	}
+	a.grpcClient = wolv1.NewWOLServiceClient(a.grpcConn)
+
+	for _, port := range a.opts.Ports {
+		addr := fmt.Sprintf(":%d", port.Port)
+		switch port.Protocol {
+		case "udp":
+			conn, err := net.ListenPacket("udp", addr)
+			if err != nil {
+				a.Stop()
+				return fmt.Errorf("failed to listen on %s: %w", port, err)
+			}
+			a.addCloser(conn)
+			a.wg.Add(1)
+			go a.serveUDP(ctx, conn, port)
+		default:
+			listener, err := net.Listen("tcp", addr)
+			if err != nil {
+				a.Stop()
+				return fmt.Errorf("failed to listen on %s: %w", port, err)
+			}
+			a.addCloser(listener)
+			a.wg.Add(1)
+			go a.serveTCP(ctx, listener, port)
+		}
+	}
+
+	if a.opts.HealthPort > 0 {
+		a.wg.Add(1)
+		go a.startHealthServer(ctx)
+	}
+
+	a.log.Info("Activator started",
+		"vm", a.opts.VMName,
+		"namespace", a.opts.VMNamespace,
+		"backend", a.opts.BackendHost,
+		"ports", a.opts.Ports)
+
+	<-ctx.Done()
+	a.log.Info("Shutdown signal received, stopping activator...")
+	a.Stop()
+	a.wg.Wait()
+	return nil
+}
+
+// Stop closes the listeners and the gRPC connection
+func (a *Activator) Stop() {
+	a.closersMu.Lock()
+	closers := a.closers
+	a.closers = nil
+	a.closersMu.Unlock()
+
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			a.log.V(1).Info("Failed to close listener", "error", err.Error())
+		}
+	}
+	if a.grpcConn != nil {
+		if err := a.grpcConn.Close(); err != nil {
+			a.log.Error(err, "Failed to close gRPC connection")
+		}
+	}
+}
+
+func (a *Activator) addCloser(c io.Closer) {
+	a.closersMu.Lock()
+	defer a.closersMu.Unlock()
+	a.closers = append(a.closers, c)
+}
+
+// serveTCP accepts connections and proxies each of them to the backend
+func (a *Activator) serveTCP(ctx context.Context, listener net.Listener, port ActivatorPort) {
+	defer a.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			a.log.Error(err, "Failed to accept connection", "port", port.String())
+			continue
+		}
+		go a.handleTCP(ctx, conn, port)
+	}
+}
+
+// handleTCP holds the client connection until the backend accepts it, then
+// proxies both directions. At most MaxPendingConnections are held at a time.
+func (a *Activator) handleTCP(ctx context.Context, conn net.Conn, port ActivatorPort) {
+	defer func() { _ = conn.Close() }()
+
+	if a.pending.Add(1) > int64(a.opts.MaxPendingConnections) {
+		a.pending.Add(-1)
+		a.log.Info("Too many connections waiting for the VM, dropping connection",
+			"port", port.String(), "client", conn.RemoteAddr().String(), "max", a.opts.MaxPendingConnections)
+		return
+	}
+	reason := fmt.Sprintf("tcp %s -> :%d", conn.RemoteAddr(), port.Port)
+	backend, err := a.dialBackend(ctx, "tcp", port.TargetPort, reason)
+	a.pending.Add(-1)
+	if err != nil {
+		a.log.Info("Backend did not become ready, dropping connection",
+			"port", port.String(), "client", conn.RemoteAddr().String(), "error", err.Error())
+		return
+	}
+	defer func() { _ = backend.Close() }()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(backend, conn)
+		closeWrite(backend)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, backend)
+		closeWrite(conn)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// closeWrite half-closes a TCP connection so the peer sees EOF
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+	}
+}
+
+// dialBackend connects to the backend, requesting a wake and retrying until
+// ReadyTimeout while the VM is not accepting connections
+func (a *Activator) dialBackend(ctx context.Context, network string, port int, reason string) (net.Conn, error) {
+	addr := net.JoinHostPort(a.opts.BackendHost, strconv.Itoa(port))
+	deadline := time.Now().Add(a.opts.ReadyTimeout)
+	dialer := net.Dialer{Timeout: a.opts.DialTimeout}
+
+	for attempt := 0; ; attempt++ {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err == nil {
+			if attempt > 0 {
+				a.log.Info("Backend ready, forwarding held connection", "backend", addr, "attempts", attempt+1)
+			}
+			return conn, nil
+		}
+
+		a.wake(ctx, reason)
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("backend %s not ready after %s: %w", addr, a.opts.ReadyTimeout, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(a.opts.RetryInterval):
+		}
+	}
+}
+
+// wake asks the operator to start the VM, at most once per WakeInterval
+func (a *Activator) wake(ctx context.Context, reason string) {
+	a.wakeMu.Lock()
+	if time.Since(a.lastWake) < a.opts.WakeInterval {
+		a.wakeMu.Unlock()
+		return
+	}
+	a.lastWake = time.Now()
+	a.wakeMu.Unlock()
+
+	wakeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := a.grpcClient.RequestWake(wakeCtx, &wolv1.WakeRequest{
+		Namespace: a.opts.VMNamespace,
+		Name:      a.opts.VMName,
+		Source:    ActivatorWakeSource,
+		Reason:    reason,
+	})
+	if err != nil {
+		a.log.Error(err, "Failed to request wake from operator", "vm", a.opts.VMName, "namespace", a.opts.VMNamespace)
+		return
+	}
+
+	a.log.Info("Wake requested",
+		"vm", a.opts.VMName,
+		"namespace", a.opts.VMNamespace,
+		"reason", reason,
+		"status", resp.Status.String(),
+		"message", resp.Message)
+}
+
+// udpFlow is the backend socket of a UDP client
+type udpFlow struct {
+	backend  net.Conn
+	lastSeen time.Time
+}
+
+// serveUDP relays datagrams between clients and the backend, one backend socket per client.
+// UDP has no handshake to hold: the first datagram of a flow triggers a wake and
+// is forwarded right away, so clients are expected to retry while the VM starts.
+func (a *Activator) serveUDP(ctx context.Context, conn net.PacketConn, port ActivatorPort) {
+	defer a.wg.Done()
+
+	var mu sync.Mutex
+	flows := make(map[string]*udpFlow)
+	backendAddr := net.JoinHostPort(a.opts.BackendHost, strconv.Itoa(port.TargetPort))
+
+	buffer := make([]byte, 64*1024)
+	for {
+		n, client, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				mu.Lock()
+				for _, flow := range flows {
+					_ = flow.backend.Close()
+				}
+				mu.Unlock()
+				return
+			}
+			a.log.Error(err, "Failed to read UDP datagram", "port", port.String())
+			continue
+		}
+
+		mu.Lock()
+		flow, exists := flows[client.String()]
+		if !exists {
+			if len(flows) >= a.opts.MaxUDPFlows {
+				a.evictUDPFlow(flows)
+			}
+			backend, err := net.Dial("udp", backendAddr)
+			if err != nil {
+				mu.Unlock()
+				a.log.Error(err, "Failed to open UDP flow to backend", "backend", backendAddr)
+				continue
+			}
+			flow = &udpFlow{backend: backend}
+			flows[client.String()] = flow
+			go a.wake(ctx, fmt.Sprintf("udp %s -> :%d", client, port.Port))
+			go a.relayUDP(conn, client, flow, &mu, flows)
+		}
+		flow.lastSeen = time.Now()
+		mu.Unlock()
+
+		if _, err := flow.backend.Write(buffer[:n]); err != nil {
+			a.log.V(1).Info("Failed to forward UDP datagram", "backend", backendAddr, "error", err.Error())
+		}
+	}
+}
+
+// evictUDPFlow closes the least recently used flow. The caller holds the flows lock.
+// Its relay goroutine exits on the closed socket.
+func (a *Activator) evictUDPFlow(flows map[string]*udpFlow) {
+	var oldestKey string
+	var oldest *udpFlow
+	for key, flow := range flows {
+		if oldest == nil || flow.lastSeen.Before(oldest.lastSeen) {
+			oldestKey, oldest = key, flow
+		}
+	}
+	if oldest == nil {
+		return
+	}
+	a.log.V(1).Info("Too many UDP flows, closing the least recently used", "client", oldestKey)
+	delete(flows, oldestKey)
+	_ = oldest.backend.Close()
+}
+
+// relayUDP copies backend replies to the client until the client has been idle
+// for UDPIdleTimeout (a backend that keeps sending does not keep the flow open)
+func (a *Activator) relayUDP(conn net.PacketConn, client net.Addr, flow *udpFlow, mu *sync.Mutex, flows map[string]*udpFlow) {
+	buffer := make([]byte, 64*1024)
+	for {
+		_ = flow.backend.SetReadDeadline(time.Now().Add(a.opts.UDPIdleTimeout))
+		n, err := flow.backend.Read(buffer)
+
+		var netErr net.Error
+		mu.Lock()
+		idle := time.Since(flow.lastSeen) >= a.opts.UDPIdleTimeout
+		if err != nil && !idle && errors.As(err, &netErr) && netErr.Timeout() {
+			mu.Unlock()
+			continue
+		}
+		if err != nil || idle {
+			// Idle flow, closed socket or ICMP unreachable while the VM is down: drop the flow
+			if flows[client.String()] == flow {
+				delete(flows, client.String())
+			}
+			mu.Unlock()
+			_ = flow.backend.Close()
+			return
+		}
+		mu.Unlock()
+
+		if _, err := conn.WriteTo(buffer[:n], client); err != nil {
+			a.log.V(1).Info("Failed to relay UDP reply", "client", client.String(), "error", err.Error())
+		}
+	}
+}
+
+// startHealthServer serves /healthz and /readyz
+func (a *Activator) startHealthServer(ctx context.Context) {
+	defer a.wg.Done()
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte("ok")); err != nil {
+			a.log.Error(err, "Failed to write health check response")
+		}
+	}
+	mux.HandleFunc("/healthz", ok)
+	mux.HandleFunc("/readyz", ok)
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", a.opts.HealthPort),
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			a.log.Error(err, "Failed to shutdown health check server")
+		}
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		a.log.Error(err, "Health check server failed")
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// fakeWakeClient calls onWake for every RequestWake
+type fakeWakeClient struct {
+	wolv1.WOLServiceClient
+	mu     sync.Mutex
+	wakes  int
+	onWake func()
+}
+
+func (f *fakeWakeClient) RequestWake(_ context.Context, _ *wolv1.WakeRequest, _ ...grpc.CallOption) (*wolv1.WOLEventResponse, error) {
+	f.mu.Lock()
+	f.wakes++
+	first := f.wakes == 1
+	f.mu.Unlock()
+	if first && f.onWake != nil {
+		f.onWake()
+	}
+	return &wolv1.WOLEventResponse{Status: wolv1.ResponseStatus_VM_START_INITIATED}, nil
+}
+
+func TestParseActivatorPorts(t *testing.T) {
+	ports, err := ParseActivatorPorts("22, tcp:80:8080 ,udp:53")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []ActivatorPort{
+		{Protocol: "tcp", Port: 22, TargetPort: 22},
+		{Protocol: "tcp", Port: 80, TargetPort: 8080},
+		{Protocol: "udp", Port: 53, TargetPort: 53},
+	}
+	if len(ports) != len(want) {
+		t.Fatalf("Expected %d ports, got %d", len(want), len(ports))
+	}
+	for i := range want {
+		if ports[i] != want[i] {
+			t.Errorf("Port %d: expected %+v, got %+v", i, want[i], ports[i])
+		}
+	}
+
+	for _, invalid := range []string{"", "sctp:22", "tcp:0", "tcp:1:2:3", "tcp:ssh"} {
+		if _, err := ParseActivatorPorts(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestActivator_HoldsConnectionUntilBackendIsUp(t *testing.T) {
+	// Reserve a port for the backend, which only starts listening once the wake is requested
+	reserved, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	backendAddr := reserved.Addr().(*net.TCPAddr)
+	_ = reserved.Close()
+
+	backendUp := make(chan net.Listener, 1)
+	fake := &fakeWakeClient{onWake: func() {
+		l, err := net.Listen("tcp", backendAddr.String())
+		if err != nil {
+			t.Errorf("Failed to start backend: %v", err)
+			return
+		}
+		backendUp <- l
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer func() { _ = conn.Close() }()
+			_, _ = io.Copy(conn, conn) // echo
+		}()
+	}}
+
+	port := ActivatorPort{Protocol: "tcp", Port: 22, TargetPort: backendAddr.Port}
+	activator := NewActivator("", ActivatorOptions{
+		VMNamespace:   "default",
+		VMName:        "vm1",
+		BackendHost:   "127.0.0.1",
+		Ports:         []ActivatorPort{port},
+		ReadyTimeout:  5 * time.Second,
+		DialTimeout:   100 * time.Millisecond,
+		RetryInterval: 20 * time.Millisecond,
+	}, logr.Discard())
+	activator.grpcClient = fake
+
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go activator.handleTCP(context.Background(), server, port)
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	reply := make([]byte, 4)
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(reply) != "ping" {
+		t.Errorf("Expected echoed ping, got %q", reply)
+	}
+
+	select {
+	case l := <-backendUp:
+		_ = l.Close()
+	default:
+		t.Error("Expected the backend to be started by the wake request")
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.wakes != 1 {
+		t.Errorf("Expected a single (rate limited) wake request, got %d", fake.wakes)
+	}
+}
+
+func TestActivator_EvictUDPFlow(t *testing.T) {
+	a := NewActivator("", ActivatorOptions{}, logr.Discard())
+	flows := make(map[string]*udpFlow)
+	now := time.Now()
+	for i, age := range []time.Duration{time.Second, time.Minute, time.Millisecond} {
+		backend, peer := net.Pipe()
+		defer func() { _ = peer.Close() }()
+		flows[string(rune('a'+i))] = &udpFlow{backend: backend, lastSeen: now.Add(-age)}
+	}
+
+	a.evictUDPFlow(flows)
+	if len(flows) != 2 {
+		t.Fatalf("Expected 2 flows after eviction, got %d", len(flows))
+	}
+	if _, found := flows["b"]; found {
+		t.Error("Expected the least recently used flow to be evicted")
+	}
+}
+
+func TestActivator_MaxPendingConnections(t *testing.T) {
+	// Nessun backend in ascolto: le connessioni restano in attesa
+	reserved, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	backendAddr := reserved.Addr().(*net.TCPAddr)
+	_ = reserved.Close()
+
+	port := ActivatorPort{Protocol: "tcp", Port: 22, TargetPort: backendAddr.Port}
+	activator := NewActivator("", ActivatorOptions{
+		VMNamespace:           "default",
+		VMName:                "vm1",
+		BackendHost:           "127.0.0.1",
+		Ports:                 []ActivatorPort{port},
+		ReadyTimeout:          5 * time.Second,
+		DialTimeout:           100 * time.Millisecond,
+		RetryInterval:         20 * time.Millisecond,
+		MaxPendingConnections: 1,
+	}, logr.Discard())
+	activator.grpcClient = &fakeWakeClient{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	held, heldServer := net.Pipe()
+	defer func() { _ = held.Close() }()
+	go activator.handleTCP(ctx, heldServer, port)
+	deadline := time.Now().Add(5 * time.Second)
+	for activator.pending.Load() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the connection to be held")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Coda piena: il secondo client viene chiuso subito
+	dropped, droppedServer := net.Pipe()
+	defer func() { _ = dropped.Close() }()
+	go activator.handleTCP(ctx, droppedServer, port)
+	_ = dropped.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := dropped.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the second connection to be closed, got %v", err)
+	}
+
+	cancel()
+	deadline = time.Now().Add(5 * time.Second)
+	for activator.pending.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the held connection to be released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -18,38 +18,141 @@ package wol
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/go-logr/logr"
 	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
 )
 
+// Default socket and cache tuning of the agent (see AgentTuning in the WolConfig API)
+const (
+	// DefaultUDPReadBuffer is the default receive buffer of the UDP listener
+	DefaultUDPReadBuffer = 64 * 1024
+	// DefaultReceiveTimeout is the default wait of a listener read before checking for shutdown
+	DefaultReceiveTimeout = time.Second
+	// DefaultDedupeWindow is the default window of the local dedupe cache
+	DefaultDedupeWindow = 2 * time.Second
+	// DefaultDedupeCleanupInterval is the default interval between dedupe cache cleanups
+	DefaultDedupeCleanupInterval = 30 * time.Second
+)
+
 // Agent ascolta pacchetti WOL e li invia all'operatore centrale via gRPC
 type Agent struct {
-	port           int
-	nodeName       string
-	operatorAddr   string
-	rawListeners   []*RawListener
-	log            logr.Logger
-	conn           *net.UDPConn
-	grpcConn       *grpc.ClientConn
-	grpcClient     wolv1.WOLServiceClient
-	dedupeCache    map[string]time.Time
-	dedupeLock     sync.RWMutex
-	dedupeDuration time.Duration
-	enableRawWoL   bool           // Enable raw Ethernet WoL listener (Layer 2)
-	wg             sync.WaitGroup // WaitGroup per aspettare tutte le goroutine
+	port             atomic.Int32 // porta UDP, cambiata a caldo dalla config sync
+	nodeName         string
+	operatorAddr     string
+	tlsConfig        *tls.Config // mTLS verso l'operatore (nil = plaintext)
+	tokenFile        string      // token del ServiceAccount inviato all'operatore ("" = nessuno)
+	rawListeners     []*RawListener
+	log              logr.Logger
+	conn             *net.UDPConn
+	udpMu            sync.Mutex   // protegge conn e conn6 (ricreate dal watchdog)
+	udpHeartbeat     atomic.Int64 // ultimo giro del loop UDP (unix nano)
+	udpErrors        atomic.Int32 // errori di lettura consecutivi
+	udp4             bool         // listener UDP IPv4 (0.0.0.0)
+	udp6             bool         // listener UDP IPv6 (::, gruppo ff02::1)
+	conn6            *net.UDPConn
+	udp6Heartbeat    atomic.Int64
+	udp6Errors       atomic.Int32
+	grpcConn         *trackedConn
+	grpcClient       wolv1.WOLServiceClient
+	streamEvents     bool         // report degli eventi su ReportWOLEventStream
+	events           *eventStream // nil = una chiamata unary per pacchetto
+	buffer           *eventBuffer // eventi da riportare quando l'operatore torna raggiungibile (nil = disabilitato)
+	dedupeCache      map[string]localDedupeEntry
+	dedupeLock       sync.RWMutex
+	dedupeDuration   time.Duration
+	dedupeCleanup    time.Duration   // intervallo di pulizia della dedupeCache
+	interfaceRules   InterfaceRules  // filtro sulle interfacce candidate per i raw listener
+	nodeRules        *InterfaceRules // regole dalle annotazioni del nodo, al posto di interfaceRules (protette da rawMu)
+	configSync       time.Duration   // intervallo della config sync con l'operatore (0 = disabilitata)
+	keepInterfaces   bool            // la config sync non sostituisce interfaceRules (file di configurazione)
+	syncedDedupe     bool            // finestra di dedupe impostata dalla config sync
+	udpReadBuffer    int             // SO_RCVBUF del socket UDP
+	rawReadBuffer    int             // SO_RCVBUF dei raw socket (0 = default del kernel)
+	recvTimeout      time.Duration   // attesa massima di una read prima di ricontrollare lo shutdown
+	enableRawWoL     bool            // Enable raw Ethernet WoL listener (Layer 2)
+	enableUDP        bool            // Enable the UDP listeners (IPv4/IPv6)
+	promiscuous      bool            // Promiscuous capture on the raw listeners (off = broadcast/multicast only)
+	captureBackend   string          // Backend di cattura dei raw listener (CaptureBackendAFPacket o EBPF)
+	rawMu            sync.Mutex      // protegge rawListeners e hintedIfaces (aggiornati dagli interface hints)
+	hintedIfaces     map[string]bool // interfacce ascoltate solo perché suggerite dall'operator
+	hintNames        []string        // ultime interfacce suggerite, anche se assenti dal nodo
+	watchLinks       bool            // segue le interfacce aggiunte e rimosse (netlink)
+	rawPacketHandler func(mac string, payload []byte, srcMAC net.HardwareAddr)
+	wg               sync.WaitGroup // WaitGroup per aspettare tutte le goroutine
+	wolConfigName    string         // WolConfig servita (filtra ARP targets e interface hints)
+	watchdog         *agentWatchdog
+	metrics          *agentMetrics // metriche Prometheus dell'agent
+	packetLog        *PacketLogger // log per pacchetto, campionati e con sorgenti offuscate
+	metricsPort      int           // porta di /metrics (0 = disabilitate)
+	bindMu           sync.Mutex
+	bindErrors       map[string]bindError // listener che non sono partiti, riportati all'operatore
+	startedAt        time.Time            // avvio dell'agent, riportato nell'heartbeat
+	packetsSeen      atomic.Int64         // magic packet validi ricevuti, riportati nell'heartbeat
+	reportFailures   atomic.Int64         // eventi non riportati all'operatore, riportati nell'heartbeat
+
+	// Report in corso verso l'operatore, attesi (con timeout) allo shutdown.
+	// reportCtx non dipende dal segnale di shutdown: viene cancellato solo
+	// quando il drain scade
+	inflight      sync.WaitGroup
+	inflightMu    sync.RWMutex // serializza inflight.Add con l'inizio del drain
+	inflightCount atomic.Int64
+	reports       *reportQueue // worker che eseguono i report, con coda limitata
+	draining      bool
+	drainTimeout  time.Duration
+	reportCtx     context.Context
+	reportCancel  context.CancelFunc
+
+	// Wake su richiesta ARP per gli IP delle VM spente (richiede il raw listener)
+	arpWake      bool
+	arpTargetsMu sync.RWMutex
+	arpTargets   map[string]*wolv1.ARPTarget // IP -> VM
+	arpTracker   *arpWakeTracker
+	arpWakes     atomic.Int64
+
+	// Risposte ARP/NDP per gli IP delle VM spente, con i target del wake su ARP
+	arpRespond bool
+
+	// Wake su ping, SYN e query DNS verso le VM spente, con i target del wake su ARP
+	wakeTriggers WakeTriggers
+
+	// Allowlist dei MAC gestiti, inviata dall'operatore: i pacchetti degli
+	// altri MAC non vengono riportati (nil = nessun filtro)
+	macFilter   bool
+	allowedMACs atomic.Pointer[map[macKey]bool]
+
+	// Funzionalità annunciate dall'operatore con GetVersion (nil = non note)
+	managerFeatures map[string]bool
+
+	// EtherType dei frame di sleep (Shutdown-on-LAN, 0 = disabilitati)
+	sleepEtherType uint16
+
+	// Porte UDP dei magic packet unicast verso le VM catturati dai raw listener (nil = disabilitato)
+	directedPorts []uint16
+	// Porte UDP (qualsiasi destinazione) ed EtherType aggiuntivi catturati dai raw listener
+	rawUDPPorts   []uint16
+	rawEtherTypes []uint16
+	// VLAN accettate dai raw listener (0 = frame senza tag, nil = tutte)
+	vlans []uint16
+
+	chaos ChaosOptions // fault injection (solo per i test di resilienza)
 }
 
 // NewAgent crea un nuovo agente WOL
@@ -58,15 +161,41 @@ func NewAgent(port int, nodeName, operatorAddr string, log logr.Logger) *Agent {
 		port = DefaultWOLPort
 	}
 
-	return &Agent{
-		port:           port,
+	reportCtx, reportCancel := context.WithCancel(context.Background())
+	a := &Agent{
 		nodeName:       nodeName,
 		operatorAddr:   operatorAddr,
 		log:            log,
-		dedupeCache:    make(map[string]time.Time),
-		dedupeDuration: 2 * time.Second, // Deduplica locale veloce (2s)
-		enableRawWoL:   true,            // Enable raw Ethernet WoL by default
+		dedupeCache:    make(map[string]localDedupeEntry),
+		dedupeDuration: DefaultDedupeWindow, // Deduplica locale veloce
+		dedupeCleanup:  DefaultDedupeCleanupInterval,
+		udpReadBuffer:  DefaultUDPReadBuffer,
+		recvTimeout:    DefaultReceiveTimeout,
+		udp4:           true,
+		enableRawWoL:   true, // Enable raw Ethernet WoL by default
+		enableUDP:      true,
+		streamEvents:   true,
+		buffer:         newEventBuffer(DefaultEventBufferSize, DefaultEventBufferMaxAge),
+		promiscuous:    true, // Promiscuous capture by default
+		watchLinks:     true,
+		watchdog:       newAgentWatchdog(),
+		metricsPort:    DefaultAgentHealthPort,
+		drainTimeout:   5 * time.Second,
+		reports:        newReportQueue(DefaultReportWorkers, DefaultReportQueueSize),
+		packetLog:      NewPacketLogger(DefaultPacketLogRate, false),
+		configSync:     DefaultConfigSyncInterval,
+		macFilter:      true,
+		reportCtx:      reportCtx,
+		reportCancel:   reportCancel,
 	}
+	a.port.Store(int32(port))
+	a.metrics = newAgentMetrics(a)
+	return a
+}
+
+// udpPort returns the port of the UDP listeners
+func (a *Agent) udpPort() int {
+	return int(a.port.Load())
 }
 
 // SetEnableRawWoL enables or disables the raw Ethernet WoL listener
@@ -74,26 +203,193 @@ func (a *Agent) SetEnableRawWoL(enable bool) {
 	a.enableRawWoL = enable
 }
 
+// SetEnableUDP enables or disables the UDP listeners of every IP family
+func (a *Agent) SetEnableUDP(enable bool) {
+	a.enableUDP = enable
+}
+
+// ParseListenModes parses a comma-separated list of listen modes (Raw, UDP, Both)
+func ParseListenModes(value string) (raw, udp bool, err error) {
+	for _, mode := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(mode)) {
+		case "raw":
+			raw = true
+		case "udp":
+			udp = true
+		case "both":
+			raw, udp = true, true
+		case "":
+		default:
+			return false, false, fmt.Errorf("invalid listen mode %q (Raw, UDP or Both)", mode)
+		}
+	}
+	if !raw && !udp {
+		return false, false, fmt.Errorf("no listen mode in %q", value)
+	}
+	return raw, udp, nil
+}
+
+// SetPromiscuous enables or disables promiscuous capture on the raw listeners.
+// When disabled only broadcast, the interface's own MAC and the WoL multicast
+// groups are captured. Must be called before Start.
+func (a *Agent) SetPromiscuous(enable bool) {
+	a.promiscuous = enable
+}
+
+// SetVLANs restricts the raw listeners to the frames of the given VLAN IDs,
+// outer or inner tag (0 = untagged frames). Must be called before Start.
+func (a *Agent) SetVLANs(vlans []uint16) {
+	a.vlans = vlans
+}
+
+// SetCaptureBackend sets the capture backend of the raw listeners
+// (CaptureBackendAFPacket or CaptureBackendEBPF). Must be called before Start.
+func (a *Agent) SetCaptureBackend(backend string) {
+	a.captureBackend = backend
+}
+
+// ParseCaptureBackend parses a capture backend name (AFPacket or EBPF)
+func ParseCaptureBackend(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case CaptureBackendAFPacket, "":
+		return CaptureBackendAFPacket, nil
+	case CaptureBackendEBPF:
+		return CaptureBackendEBPF, nil
+	}
+	return "", fmt.Errorf("invalid capture backend %q (AFPacket or EBPF)", value)
+}
+
+// SetPacketLogging samples the per-packet logs to perSecond lines per second
+// (0 = every packet) and, with redact, hashes the source IPs and MACs in them.
+// Must be called before Start.
+func (a *Agent) SetPacketLogging(perSecond float64, redact bool) {
+	a.packetLog = NewPacketLogger(perSecond, redact)
+}
+
+// SetDrainTimeout sets how long shutdown waits for in-flight reports to the operator
+func (a *Agent) SetDrainTimeout(timeout time.Duration) {
+	a.drainTimeout = timeout
+}
+
+// SetMetricsPort serves /metrics on port instead of the health check port
+// (0 disables the metrics). Must be called before Start.
+func (a *Agent) SetMetricsPort(port int) {
+	if port >= 0 && port <= 65535 {
+		a.metricsPort = port
+	}
+}
+
+// SetUDPReadBuffer sets the receive buffer (SO_RCVBUF) of the UDP listener, in bytes
+func (a *Agent) SetUDPReadBuffer(size int) {
+	if size > 0 {
+		a.udpReadBuffer = size
+	}
+}
+
+// SetRawReadBuffer sets the receive buffer (SO_RCVBUF) of the raw listeners,
+// in bytes (0 keeps the kernel default)
+func (a *Agent) SetRawReadBuffer(size int) {
+	a.rawReadBuffer = size
+}
+
+// SetReceiveTimeout sets how long the listener reads wait for a packet before
+// checking for shutdown and refreshing the watchdog heartbeat (whole seconds,
+// at least 1s, since SO_RCVTIMEO is set in seconds on the raw sockets)
+func (a *Agent) SetReceiveTimeout(timeout time.Duration) {
+	a.recvTimeout = max(timeout.Truncate(time.Second), time.Second)
+}
+
+// SetDedupeCleanupInterval sets how often expired entries are removed from the dedupe cache
+func (a *Agent) SetDedupeCleanupInterval(interval time.Duration) {
+	if interval > 0 {
+		a.dedupeCleanup = interval
+	}
+}
+
+// SetDedupeWindow sets how long a repeated packet is dropped by the local
+// dedupe cache. Safe to call while the agent is running.
+func (a *Agent) SetDedupeWindow(window time.Duration) {
+	if window <= 0 {
+		return
+	}
+	a.dedupeLock.Lock()
+	a.dedupeDuration = window
+	a.dedupeLock.Unlock()
+}
+
+// dedupeWindow returns the dedupe window of the agent
+func (a *Agent) dedupeWindow() time.Duration {
+	a.dedupeLock.RLock()
+	defer a.dedupeLock.RUnlock()
+	return a.dedupeDuration
+}
+
+// SetInterfaceRules restricts the candidate interfaces of the raw listeners.
+// Interfaces hinted by the operator are not filtered, and the rules of the node
+// annotations, sent by the operator, replace these. Must be called before Start.
+func (a *Agent) SetInterfaceRules(rules InterfaceRules) {
+	a.interfaceRules = rules
+}
+
+// SetIPFamilies selects the IP families of the UDP listener (IPv4 only by
+// default). Must be called before Start.
+func (a *Agent) SetIPFamilies(ipv4, ipv6 bool) {
+	a.udp4, a.udp6 = ipv4, ipv6
+}
+
+// SetTLS makes the connection to the operator use TLS (nil keeps plaintext).
+// Must be called before Start.
+func (a *Agent) SetTLS(config *tls.Config) {
+	a.tlsConfig = config
+}
+
+// SetTokenFile sends the ServiceAccount token of path to the operator on every
+// RPC ("" sends none). Must be called before Start.
+func (a *Agent) SetTokenFile(path string) {
+	a.tokenFile = path
+}
+
+// SetChaos enables fault injection on the reported events (testing only)
+func (a *Agent) SetChaos(opts ChaosOptions) {
+	a.chaos = opts
+}
+
+// SetWolConfigName sets the WolConfig served by the agent, used to select the
+// ARP targets and interface hints (all configs if empty)
+func (a *Agent) SetWolConfigName(name string) {
+	a.wolConfigName = name
+}
+
+// SetARPWake enables wakes triggered by ARP requests for the IPs of stopped VMs
+func (a *Agent) SetARPWake(enable bool) {
+	a.arpWake = enable
+}
+
+// SetARPRespond makes the raw listeners answer the ARP requests and IPv6
+// neighbor solicitations for the IPs of stopped VMs with their MAC.
+// Requires SetARPWake, which syncs the IPs.
+func (a *Agent) SetARPRespond(enable bool) {
+	a.arpRespond = enable
+}
+
 // Start avvia l'agente
 func (a *Agent) Start(ctx context.Context) error {
+	a.startedAt = time.Now()
+
 	// Connetti a gRPC server con retry
 	a.log.Info("Connecting to operator gRPC server", "address", a.operatorAddr)
 
-	var err error
-	a.grpcConn, err = grpc.NewClient(
-		a.operatorAddr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithDefaultCallOptions(
-			grpc.MaxCallRecvMsgSize(1024*1024),
-			grpc.MaxCallSendMsgSize(1024*1024),
-		),
-	)
+	grpcConn, err := a.dialOperator()
 	if err != nil {
 		return fmt.Errorf("failed to connect to operator: %w", err)
 	}
 
+	a.grpcConn = newTrackedConn(grpcConn)
 	a.grpcClient = wolv1.NewWOLServiceClient(a.grpcConn)
-	a.log.Info("Connected to operator gRPC server")
+	if a.streamEvents {
+		a.events = newEventStream(a.reportCtx, a.grpcClient, a.log)
+	}
+	a.log.Info("Connected to operator gRPC server", "streamEvents", a.streamEvents)
 
 	// Test connection with health check
 	healthCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
@@ -103,29 +399,48 @@ func (a *Agent) Start(ctx context.Context) error {
 	if err != nil {
 		a.log.Error(err, "Failed to check operator health, but continuing anyway")
 	} else {
-		a.log.Info("Operator health check", "status", healthResp.Status.String())
+		a.log.Info("Operator health check", "status", healthResp.Status.String(), "version", healthResp.Version)
 	}
+	a.fetchVersion(ctx)
 
-	// Setup UDP listener
-	addr := &net.UDPAddr{
-		Port: a.port,
-		IP:   net.IPv4zero, // 0.0.0.0 - listen on all interfaces
+	// Impostazioni della WolConfig più recenti di quelle del DaemonSet,
+	// applicate prima di aprire i listener
+	if a.configSync > 0 && a.wolConfigName != "" && a.managerSupports(FeatureAgentConfig) {
+		a.fetchConfig(ctx)
 	}
 
-	conn, err := net.ListenUDP("udp4", addr)
-	if err != nil {
-		return fmt.Errorf("failed to listen on UDP port %d: %w", a.port, err)
+	// Setup UDP listener. A failure (e.g. the port is taken by another
+	// hostNetwork process) is reported to the operator and retried by the watchdog
+	var conn, conn6 *net.UDPConn
+	if !a.enableUDP {
+		a.log.Info("UDP listeners disabled by the listen modes")
 	}
-	a.conn = conn
-
-	// Configura socket options
-	if err := a.configureSocket(); err != nil {
-		a.log.Error(err, "Failed to configure socket (continuing anyway)")
+	if a.enableUDP && a.udp4 {
+		conn, err = a.openUDP()
+		if err != nil {
+			a.log.Error(err, "Failed to start UDP listener, the watchdog will retry")
+		} else {
+			a.udpMu.Lock()
+			a.conn = conn
+			a.udpMu.Unlock()
+			a.udpHeartbeat.Store(time.Now().UnixNano())
+		}
+	}
+	if a.enableUDP && a.udp6 {
+		conn6, err = a.openUDP6()
+		if err != nil {
+			a.log.Error(err, "Failed to start IPv6 UDP listener, the watchdog will retry")
+		} else {
+			a.udpMu.Lock()
+			a.conn6 = conn6
+			a.udpMu.Unlock()
+			a.udp6Heartbeat.Store(time.Now().UnixNano())
+		}
 	}
 
 	a.log.Info("WOL Agent started successfully",
 		"node", a.nodeName,
-		"port", a.port,
+		"port", a.udpPort(),
 		"operatorAddr", a.operatorAddr)
 
 	// Start raw Ethernet WoL listener (Layer 2) if enabled
@@ -139,17 +454,84 @@ func (a *Agent) Start(ctx context.Context) error {
 		}
 	}
 
+	if a.sleepEtherType != 0 && !a.enableRawWoL {
+		a.log.Info("Sleep frames require the raw Ethernet listener, ignoring them")
+	}
+	if len(a.directedPorts) > 0 && !a.enableRawWoL {
+		a.log.Info("Directed wakes require the raw Ethernet listener, ignoring them")
+	}
+	if (len(a.rawUDPPorts) > 0 || len(a.rawEtherTypes) > 0) && !a.enableRawWoL {
+		a.log.Info("Raw UDP ports and EtherTypes require the raw Ethernet listener, ignoring them")
+	}
+	if len(a.vlans) > 0 && !a.enableRawWoL {
+		a.log.Info("VLAN filtering requires the raw Ethernet listener, ignoring it")
+	}
+
+	// Sync the ARP targets from the operator, also used by the wake triggers
+	if a.arpWake || a.wakeTriggers.Enabled() {
+		if a.enableRawWoL {
+			a.arpTracker = newARPWakeTracker()
+			a.wg.Add(1)
+			go a.syncARPTargets(ctx)
+		} else {
+			a.log.Info("ARP wake and wake triggers require the raw Ethernet listener, ignoring them")
+		}
+	}
+	if a.arpRespond && !a.arpWake {
+		a.log.Info("The ARP responder requires ARP wake, ignoring it")
+	}
+
 	// Start health check server
 	a.wg.Add(1)
 	go a.startHealthServer(ctx)
 
 	// Start listeners
+	if conn != nil {
+		a.wg.Add(1)
+		go a.listen(ctx, conn)
+	}
+	if conn6 != nil {
+		a.wg.Add(1)
+		go a.listen6(ctx, conn6)
+	}
+
+	// Report the bound ports and interfaces, shown in the WolConfig status
+	a.wg.Add(1)
+	go a.syncListenerReport(ctx)
+
+	// Re-emit the packets of the Forward mappings targeting this node
 	a.wg.Add(1)
-	go a.listen(ctx)
+	go a.syncForwards(ctx)
+
+	// Heartbeat: versione, interfacce e pacchetti visti, per lo status per nodo
+	a.wg.Add(1)
+	go a.syncHeartbeat(ctx)
+
+	// MAC gestiti dall'operatore: gli altri pacchetti non vengono riportati
+	if a.macFilter && a.managerSupports(FeatureMACAllowlist) {
+		a.wg.Add(1)
+		go a.syncMACAllowlist(ctx)
+	}
+
+	// Porte, dedupe e interfacce cambiate nella WolConfig, senza riavvio
+	if a.configSync > 0 && a.wolConfigName != "" && a.managerSupports(FeatureAgentConfig) {
+		a.wg.Add(1)
+		go a.syncConfig(ctx)
+	}
 
 	a.wg.Add(1)
 	go a.cleanupCache(ctx)
 
+	// Replay degli eventi non riportati mentre l'operatore era irraggiungibile
+	if a.buffer != nil {
+		a.wg.Add(1)
+		go a.replayEvents(ctx)
+	}
+
+	// Watchdog: ricrea i componenti che smettono di funzionare
+	a.wg.Add(1)
+	go a.runWatchdog(ctx)
+
 	// Aspetta il segnale di shutdown
 	<-ctx.Done()
 	a.log.Info("Shutdown signal received, stopping agent...")
@@ -179,136 +561,267 @@ func (a *Agent) Start(ctx context.Context) error {
 	return nil
 }
 
-// configureSocket configura opzioni socket UDP per ricevere broadcast
-func (a *Agent) configureSocket() error {
-	file, err := a.conn.File()
-	if err != nil {
-		return err
+// dialOperator creates the gRPC connection to the operator (connects lazily)
+func (a *Agent) dialOperator() (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if a.tlsConfig != nil {
+		creds = credentials.NewTLS(a.tlsConfig)
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			a.log.Error(err, "Failed to close file descriptor")
-		}
-	}()
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(1024*1024),
+			grpc.MaxCallSendMsgSize(1024*1024),
+		),
+	}
+	if a.tokenFile != "" {
+		opts = append(opts, TokenFileCredentials(a.tokenFile))
+	}
+	return grpc.NewClient(a.operatorAddr, opts...)
+}
 
-	fd := int(file.Fd())
+// openUDP opens and configures the UDP socket for WOL packets
+func (a *Agent) openUDP() (*net.UDPConn, error) {
+	port := a.udpPort()
+	addr := &net.UDPAddr{
+		Port: port,
+		IP:   net.IPv4zero, // 0.0.0.0 - listen on all interfaces
+	}
 
-	// Enable SO_REUSEADDR
-	if err := syscall.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
-		a.log.Error(err, "Failed to enable SO_REUSEADDR")
-	} else {
-		a.log.V(1).Info("SO_REUSEADDR enabled")
+	binding := &wolv1.ListenerBinding{Protocol: ListenerProtocolUDP, Port: uint32(port)}
+	conn, err := net.ListenUDP("udp4", addr)
+	a.setBindError(binding, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on UDP port %d: %w", port, err)
 	}
 
-	// Enable SO_REUSEPORT (allows multiple processes to bind to same port)
-	if err := syscall.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
-		a.log.Error(err, "Failed to enable SO_REUSEPORT")
-	} else {
-		a.log.V(1).Info("SO_REUSEPORT enabled")
+	// Configura socket options
+	if err := a.configureSocket(conn); err != nil {
+		a.log.Error(err, "Failed to configure socket (continuing anyway)")
 	}
+	return conn, nil
+}
 
-	// Enable SO_BROADCAST (essential for WOL)
-	if err := syscall.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_BROADCAST, 1); err != nil {
-		return fmt.Errorf("SO_BROADCAST: %w", err)
+// configureSocket configura opzioni socket UDP per ricevere broadcast.
+// Usa SyscallConn: conn.File().Fd() metterebbe il socket in modalità bloccante,
+// disattivando le read deadline (e quindi l'heartbeat del watchdog e la Close)
+func (a *Agent) configureSocket(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
 	}
-	a.log.Info("SO_BROADCAST enabled")
 
-	// Enable IP_PKTINFO to receive broadcast packets sent to 255.255.255.255
-	// This is crucial for receiving global broadcast packets
-	if err := syscall.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_PKTINFO, 1); err != nil {
-		a.log.Error(err, "Failed to enable IP_PKTINFO (continuing anyway)")
-	} else {
-		a.log.Info("IP_PKTINFO enabled - can now receive global broadcast (255.255.255.255)")
+	var sockErr error
+	if err := raw.Control(func(fdPtr uintptr) {
+		fd := int(fdPtr)
+
+		// Enable SO_REUSEADDR
+		if err := syscall.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+			a.log.Error(err, "Failed to enable SO_REUSEADDR")
+		} else {
+			a.log.V(1).Info("SO_REUSEADDR enabled")
+		}
+
+		// Enable SO_REUSEPORT (allows multiple processes to bind to same port)
+		if err := syscall.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+			a.log.Error(err, "Failed to enable SO_REUSEPORT")
+		} else {
+			a.log.V(1).Info("SO_REUSEPORT enabled")
+		}
+
+		// Enable SO_BROADCAST (essential for WOL)
+		if err := syscall.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_BROADCAST, 1); err != nil {
+			sockErr = fmt.Errorf("SO_BROADCAST: %w", err)
+			return
+		}
+		a.log.Info("SO_BROADCAST enabled")
+
+		// Enable IP_PKTINFO to receive broadcast packets sent to 255.255.255.255
+		// This is crucial for receiving global broadcast packets
+		if err := syscall.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_PKTINFO, 1); err != nil {
+			a.log.Error(err, "Failed to enable IP_PKTINFO (continuing anyway)")
+		} else {
+			a.log.Info("IP_PKTINFO enabled - can now receive global broadcast (255.255.255.255)")
+		}
+	}); err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return sockErr
 	}
 
 	// Set larger read buffer
-	if err := a.conn.SetReadBuffer(1024 * 64); err != nil {
+	if err := conn.SetReadBuffer(a.udpReadBuffer); err != nil {
 		a.log.Error(err, "Failed to set read buffer size")
 	}
 
 	return nil
 }
 
-// listen loop principale per ricevere pacchetti UDP
-func (a *Agent) listen(ctx context.Context) {
+// listen loop principale per ricevere pacchetti UDP.
+// Il loop termina quando conn viene chiusa (il watchdog la ricrea) o dopo
+// troppi errori di lettura consecutivi.
+func (a *Agent) listen(ctx context.Context, conn *net.UDPConn) {
 	defer a.wg.Done()
-	buffer := make([]byte, 1024)
-
 	a.log.Info("UDP listener loop started, waiting for WOL packets...")
+	a.readUDP(ctx, conn, ListenerProtocolUDP, &a.udpHeartbeat, &a.udpErrors)
+}
+
+// readUDP legge i pacchetti di conn finché non viene chiusa, aggiornando
+// heartbeat ed errori del listener per il watchdog. protocol è il listener
+// (udp, udp6) nelle metriche
+func (a *Agent) readUDP(ctx context.Context, conn *net.UDPConn, protocol string, heartbeat *atomic.Int64, readErrors *atomic.Int32) {
+	buffer := make([]byte, 1024)
+	// La porta del socket, non quella corrente: può cambiare a caldo
+	port := conn.LocalAddr().(*net.UDPAddr).Port
 
 	for {
+		heartbeat.Store(time.Now().UnixNano())
+
 		select {
 		case <-ctx.Done():
 			a.log.Info("Context cancelled, stopping UDP listener")
 			return
 		default:
 			// Set read deadline per permettere check periodici del context
-			if err := a.conn.SetReadDeadline(time.Now().Add(1 * time.Second)); err != nil {
+			if err := conn.SetReadDeadline(time.Now().Add(a.recvTimeout)); err != nil {
 				a.log.Error(err, "Failed to set read deadline")
 			}
 
-			n, addr, err := a.conn.ReadFromUDP(buffer)
+			n, addr, err := conn.ReadFromUDP(buffer)
 			if err != nil {
 				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					readErrors.Store(0)
 					continue // Timeout normale, continua
 				}
 				if ctx.Err() != nil {
 					return // Context cancelled
 				}
+				if errors.Is(err, net.ErrClosed) {
+					a.log.Info("UDP socket closed, stopping UDP listener loop")
+					return
+				}
 				a.log.Error(err, "Error reading UDP packet")
 				ErrorsTotal.Inc()
+				if readErrors.Add(1) >= maxListenerReadErrors {
+					a.log.Error(err, "Too many consecutive UDP read errors, stopping UDP listener loop")
+					return
+				}
 				continue
 			}
-
-			a.log.V(1).Info("UDP packet received", "from", addr.String(), "size", n)
-
-			// Process packet in background to avoid blocking
-			go a.processPacket(ctx, buffer[:n], addr)
+			readErrors.Store(0)
+
+			a.packetLog.Info(a.log.V(1), "UDP packet received", "from", a.packetLog.SourceAddr(addr), "size", n)
+			a.metrics.packetReceived(protocol, port, "")
+
+			// Process packet in background to avoid blocking.
+			// Il buffer viene riutilizzato dalla prossima lettura: passa una copia
+			packet := append([]byte{}, buffer[:n]...)
+			receivedAt := time.Now()
+			a.report(func(reportCtx context.Context) {
+				a.processPacket(reportCtx, packet, addr, uint32(port), false, receivedAt)
+			})
 		}
 	}
 }
 
-// processPacket processa un pacchetto WOL ricevuto
-func (a *Agent) processPacket(ctx context.Context, packet []byte, addr *net.UDPAddr) {
-	startTime := time.Now()
+// processPacket processa un pacchetto WOL ricevuto.
+// dstPort è la porta UDP su cui è arrivato il pacchetto (0 per i frame Ethernet raw),
+// sleep indica un frame di sleep (Shutdown-on-LAN), receivedAt l'istante in
+// cui il listener lo ha letto
+func (a *Agent) processPacket(ctx context.Context, packet []byte, addr *net.UDPAddr, dstPort uint32, sleep bool, receivedAt time.Time) {
 
 	// Parse magic packet
 	mac, valid := parseMagicPacket(packet)
 	if !valid {
-		a.log.V(1).Info("Invalid WOL packet (not a magic packet)", "from", addr.String(), "size", len(packet))
+		a.packetLog.Info(a.log.V(1), "Invalid WOL packet (not a magic packet)", "from", a.packetLog.SourceAddr(addr),
+			"size", len(packet))
 		return
 	}
 
-	a.log.Info("Valid WOL magic packet received", "mac", mac, "from", addr.String())
+	password := parseSecureOnPassword(packet)
+	a.packetsSeen.Add(1)
+
+	if !a.macAllowed(mac) {
+		a.metrics.packetsFiltered.Inc()
+		a.packetLog.Info(a.log.V(1), "Skipping packet for a MAC not managed by the operator", "mac", mac, "port", dstPort)
+		return
+	}
 
-	// Deduplica locale (evita di inviare stesso MAC più volte in pochi secondi)
-	if !a.shouldProcess(mac) {
-		a.log.V(1).Info("Skipping duplicate packet (local dedupe cache)", "mac", mac)
+	a.packetLog.Info(a.log, "Valid WOL magic packet received",
+		"mac", mac,
+		"from", a.packetLog.SourceAddr(addr),
+		"port", dstPort,
+		"secureOn", password != "",
+		"sleep", sleep)
+
+	// Deduplica locale (evita di inviare stesso MAC più volte in pochi secondi).
+	// Porta e password fanno parte della chiave: un retry sulla porta "secure"
+	// non deve essere scartato come duplicato di un broadcast semplice
+	key := dedupeKey(mac, dstPort)
+	if sleep {
+		key = sleepDedupeKey(mac, dstPort)
+	}
+	if !a.shouldProcess(key, password) {
+		a.packetLog.Info(a.log.V(1), "Skipping duplicate packet (local dedupe cache)", "mac", mac, "port", dstPort)
 		return
 	}
 
 	// Crea evento gRPC
 	event := &wolv1.WOLEvent{
 		MacAddress: mac,
-		Timestamp:  timestamppb.Now(),
+		Timestamp:  timestamppb.New(receivedAt),
 		NodeName:   a.nodeName,
 		SourceIp:   addr.IP.String(),
 		SourcePort: uint32(addr.Port),
 		PacketSize: uint32(len(packet)),
+
+		DestinationPort:  dstPort,
+		SecureOnPassword: password,
+		Sleep:            sleep,
+		AgentVersion:     Version,
+	}
+
+	if a.injectChaos(ctx, mac) {
+		return
 	}
 
 	// Invia evento all'operatore via gRPC con timeout
 	grpcCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	resp, err := a.grpcClient.ReportWOLEvent(grpcCtx, event)
+	event.AgentDelayUs = uint64(time.Since(receivedAt).Microseconds())
+	resp, err := a.sendEvent(grpcCtx, event)
 	if err != nil {
 		a.log.Error(err, "Failed to report WOL event to operator", "mac", mac)
 		ErrorsTotal.Inc()
+		// Un operatore irraggiungibile (es. in riavvio) non perde il wake
+		if !a.bufferEvent(event, receivedAt, err) {
+			a.reportFailures.Add(1)
+		}
 		return
 	}
+	if a.buffer != nil {
+		a.buffer.wake()
+	}
+	if chaosHit(a.chaos.DuplicatePercent, "duplicate") {
+		a.log.Info("Chaos: reporting the event twice", "mac", mac)
+		if _, err := a.sendEvent(grpcCtx, event); err != nil {
+			a.log.Error(err, "Failed to report duplicated WOL event to operator", "mac", mac)
+		}
+	}
+
+	a.reportHandled(event, resp, receivedAt)
+}
+
+// reportHandled records the operator's response to an event received at receivedAt
+func (a *Agent) reportHandled(event *wolv1.WOLEvent, resp *wolv1.WOLEventResponse, receivedAt time.Time) {
+	mac := event.MacAddress
 
-	processingTime := time.Since(startTime)
+	// Latenza dalla ricezione del pacchetto alla risposta dell'operatore
+	processingTime := time.Since(receivedAt)
+	a.metrics.reportLatency.Observe(processingTime.Seconds())
+	a.metrics.eventsReported.WithLabelValues(resp.Status.String()).Inc()
 
 	a.log.Info("Event reported to operator successfully",
 		"mac", mac,
@@ -323,36 +836,60 @@ func (a *Agent) processPacket(ctx context.Context, packet []byte, addr *net.UDPA
 			"mac", mac,
 			"vm", resp.VmInfo.Name,
 			"namespace", resp.VmInfo.Namespace,
-			"state", resp.VmInfo.CurrentState)
+			"state", resp.VmInfo.CurrentState,
+			"vmNode", resp.VmInfo.NodeName)
 	}
+}
+
+// injectChaos applica i fault configurati prima del report: ritorna true se
+// l'evento va scartato, altrimenti attende l'eventuale ritardo
+func (a *Agent) injectChaos(ctx context.Context, mac string) bool {
+	if chaosHit(a.chaos.DropPercent, "drop") {
+		a.log.Info("Chaos: dropping the event", "mac", mac)
+		return true
+	}
+	if a.chaos.Delay > 0 && chaosHit(a.chaos.DelayPercent, "delay") {
+		a.log.Info("Chaos: delaying the event", "mac", mac, "delay", a.chaos.Delay)
+		select {
+		case <-ctx.Done():
+			return true
+		case <-time.After(a.chaos.Delay):
+		}
+	}
+	return false
+}
 
-	WOLPacketsTotal.Inc()
+// localDedupeEntry è una voce della cache di deduplica locale
+type localDedupeEntry struct {
+	lastSeen time.Time
+	password string
 }
 
-// shouldProcess verifica se processare un MAC (deduplica locale)
-func (a *Agent) shouldProcess(mac string) bool {
+// shouldProcess verifica se processare un evento (deduplica locale).
+// key è la chiave costruita con dedupeKey; un pacchetto con una password
+// SecureOn diversa da quella in cache non è un duplicato
+func (a *Agent) shouldProcess(key, password string) bool {
 	a.dedupeLock.Lock()
 	defer a.dedupeLock.Unlock()
 
-	if lastSeen, exists := a.dedupeCache[mac]; exists {
-		elapsed := time.Since(lastSeen)
+	if entry, exists := a.dedupeCache[key]; exists && entry.password == password {
+		elapsed := time.Since(entry.lastSeen)
 		if elapsed < a.dedupeDuration {
-			a.log.V(1).Info("Skipping duplicate MAC (dedupe)",
-				"mac", mac,
+			a.log.V(1).Info("Skipping duplicate event (dedupe)",
 				"lastSeenAgo", elapsed.String(),
 				"dedupeWindow", a.dedupeDuration.String())
 			return false
 		}
 	}
 
-	a.dedupeCache[mac] = time.Now()
+	a.dedupeCache[key] = localDedupeEntry{lastSeen: time.Now(), password: password}
 	return true
 }
 
 // cleanupCache pulisce periodicamente la cache di deduplica
 func (a *Agent) cleanupCache(ctx context.Context) {
 	defer a.wg.Done()
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(a.dedupeCleanup)
 	defer ticker.Stop()
 
 	for {
@@ -363,9 +900,9 @@ func (a *Agent) cleanupCache(ctx context.Context) {
 		case <-ticker.C:
 			a.dedupeLock.Lock()
 			now := time.Now()
-			for mac, lastSeen := range a.dedupeCache {
-				if now.Sub(lastSeen) > a.dedupeDuration*3 {
-					delete(a.dedupeCache, mac)
+			for key, entry := range a.dedupeCache {
+				if now.Sub(entry.lastSeen) > a.dedupeDuration*3 {
+					delete(a.dedupeCache, key)
 				}
 			}
 			a.dedupeLock.Unlock()
@@ -378,60 +915,43 @@ func (a *Agent) cleanupCache(ctx context.Context) {
 func (a *Agent) startRawListener(ctx context.Context) error {
 	a.log.Info("Starting Raw Ethernet WoL listeners (multi-interface mode)")
 
-	// 1️⃣ Trova tutte le interfacce candidate
-	interfaces, err := GetCandidateInterfaces(a.log)
-	if err != nil {
-		return fmt.Errorf("failed to detect network interfaces: %w", err)
-	}
-	if len(interfaces) == 0 {
-		return fmt.Errorf("no suitable network interfaces found for WoL listening")
-	}
-
-	// 2️⃣ Packet handler (riusa processPacket)
-	packetHandler := func(mac string, srcMAC net.HardwareAddr) {
+	// 1️⃣ Packet handler (riusa processPacket)
+	a.rawPacketHandler = func(mac string, payload []byte, srcMAC net.HardwareAddr) {
 		addr := &net.UDPAddr{IP: net.IPv4bcast, Port: 0}
 
-		packet := make([]byte, MagicPacketSize)
-		for i := 0; i < 6; i++ {
-			packet[i] = 0xFF
-		}
-		macBytes, _ := net.ParseMAC(mac)
-		for i := 0; i < 16; i++ {
-			copy(packet[6+i*6:6+(i+1)*6], macBytes)
-		}
-
-		a.log.V(7).Info("Raw Ethernet WoL packet forwarded to processing",
+		a.packetLog.Info(a.log.V(7), "Raw Ethernet WoL packet forwarded to processing",
 			"targetMAC", mac,
-			"sourceMAC", srcMAC.String())
+			"sourceMAC", a.packetLog.Source(srcMAC.String()))
 
 		// Usa la logica esistente per gestire l'evento
-		go a.processPacket(ctx, packet, addr)
+		// (porta 0: il frame L2 non ha una porta UDP di destinazione)
+		receivedAt := time.Now()
+		a.report(func(reportCtx context.Context) {
+			a.processPacket(reportCtx, payload, addr, 0, false, receivedAt)
+		})
+	}
+
+	// 2️⃣ Trova tutte le interfacce candidate, con le regole del nodo lette
+	// prima di aprire i socket
+	if resp, err := a.fetchInterfaceHints(ctx); err == nil {
+		a.setNodeRules(resp.NodeRules)
+	}
+	interfaces, err := a.candidateInterfaces()
+	if err != nil {
+		return err
+	}
+	if len(interfaces) == 0 {
+		return fmt.Errorf("no suitable network interfaces found for WoL listening")
 	}
 
 	// 3️⃣ Avvia un listener per ciascuna interfaccia
 	var started []string
-	a.rawListeners = nil // slice dei listener per stop futuro
-
 	for _, iface := range interfaces {
-		name := iface.Name
-		listener := NewRawListenerWithOptions(
-			name,
-			packetHandler,
-			a.log.WithValues("iface", name),
-			RawListenerOptions{
-				Promiscuous:    true, // cattura tutto il broadcast
-				AttachBPF:      true, // TEMP DISABLED FOR DEBUG
-				RecvTimeoutSec: 1,
-			},
-		)
-
-		if err := listener.Start(ctx); err != nil {
-			a.log.Error(err, "Failed to start WoL listener", "iface", name)
+		if err := a.startInterfaceListener(ctx, iface.Name); err != nil {
+			a.log.Error(err, "Failed to start WoL listener", "iface", iface.Name)
 			continue
 		}
-
-		a.rawListeners = append(a.rawListeners, listener)
-		started = append(started, name)
+		started = append(started, iface.Name)
 	}
 
 	// 4️⃣ Log riassuntivo
@@ -439,6 +959,23 @@ func (a *Agent) startRawListener(ctx context.Context) error {
 		return fmt.Errorf("no WoL listeners started successfully")
 	}
 
+	// 5️⃣ Interfacce suggerite dall'operator (NAD delle VM): ascoltate anche se
+	// non sono tra le candidate, l'elenco viene aggiornato periodicamente.
+	// Solo se i raw socket funzionano (es. NET_RAW presente)
+	a.wg.Add(1)
+	go a.syncInterfaceHints(ctx)
+
+	// 6️⃣ Interfacce aggiunte e rimosse dopo l'avvio (VF SR-IOV, bridge delle CNI)
+	if a.watchLinks {
+		monitor, err := openLinkMonitor(a.recvTimeout)
+		if err != nil {
+			a.log.Error(err, "Cannot follow the interfaces added to the node, raw listeners only on the current ones")
+		} else {
+			a.wg.Add(1)
+			go a.followLinks(ctx, monitor)
+		}
+	}
+
 	a.log.Info("Raw Ethernet WoL listeners started",
 		"count", len(started),
 		"interfaces", strings.Join(started, ", "))
@@ -446,19 +983,87 @@ func (a *Agent) startRawListener(ctx context.Context) error {
 	return nil
 }
 
-// Stop ferma l'agente
+// report queues fn as an in-flight report to the operator, run by the report
+// workers, unless the agent is draining or the queue is full. fn must use the
+// given context for gRPC calls.
+func (a *Agent) report(fn func(ctx context.Context)) {
+	a.inflightMu.RLock()
+	defer a.inflightMu.RUnlock()
+	if a.draining {
+		a.log.V(1).Info("Agent is shutting down, dropping report")
+		return
+	}
+
+	a.inflight.Add(1)
+	a.inflightCount.Add(1)
+	if !a.enqueueReport(fn) {
+		a.inflightCount.Add(-1)
+		a.inflight.Done()
+		a.metrics.packetsDropped.Inc()
+		a.log.V(1).Info("Report queue full, dropping packet")
+	}
+}
+
+// drain stops new reports and waits up to the drain timeout for the in-flight
+// ones, then cancels those still running
+func (a *Agent) drain() {
+	a.inflightMu.Lock()
+	a.draining = true
+	// I report già in coda vengono eseguiti, poi i worker terminano
+	close(a.reports.tasks)
+	a.inflightMu.Unlock()
+
+	pending := a.inflightCount.Load()
+	if pending > 0 {
+		a.log.Info("Draining in-flight reports", "count", pending, "timeout", a.drainTimeout.String())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		a.inflight.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(a.drainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		if pending > 0 {
+			a.log.Info("In-flight reports drained")
+		}
+	case <-timer.C:
+		a.log.Error(nil, "Drain timeout expired, cancelling in-flight reports", "count", a.inflightCount.Load())
+		a.reportCancel()
+		<-done
+	}
+	a.reportCancel()
+}
+
+// Stop ferma l'agente: smette di ricevere pacchetti, attende i report in
+// corso (drain) e solo dopo chiude la connessione gRPC
 func (a *Agent) Stop() {
 	a.log.Info("Stopping WOL Agent...")
 
+	a.udpMu.Lock()
 	if a.conn != nil {
 		if err := a.conn.Close(); err != nil {
 			a.log.Error(err, "Failed to close UDP connection")
 		}
 		a.log.Info("UDP listener stopped")
 	}
+	if a.conn6 != nil {
+		if err := a.conn6.Close(); err != nil {
+			a.log.Error(err, "Failed to close IPv6 UDP connection")
+		}
+		a.log.Info("IPv6 UDP listener stopped")
+	}
+	a.udpMu.Unlock()
 
 	a.stopRawListeners()
 
+	a.drain()
+
 	if a.grpcConn != nil {
 		if err := a.grpcConn.Close(); err != nil {
 			a.log.Error(err, "Failed to close gRPC connection")
@@ -469,7 +1074,203 @@ func (a *Agent) Stop() {
 	a.log.Info("WOL Agent stopped successfully")
 }
 
+// startInterfaceListener starts a raw listener on the named interface, unless one is already running
+func (a *Agent) startInterfaceListener(ctx context.Context, name string) error {
+	a.rawMu.Lock()
+	defer a.rawMu.Unlock()
+
+	for _, l := range a.rawListeners {
+		if l.interfaceName == name {
+			return nil
+		}
+	}
+
+	// Gli handler sono condivisi tra i listener: l'interfaccia è contata qui
+	packetHandler := func(mac string, payload []byte, srcMAC net.HardwareAddr) {
+		a.metrics.packetReceived(ListenerProtocolRaw, 0, name)
+		a.rawPacketHandler(mac, payload, srcMAC)
+	}
+	listener := NewRawListenerWithOptions(
+		name,
+		packetHandler,
+		a.log.WithValues("iface", name),
+		RawListenerOptions{
+			Promiscuous:     a.promiscuous, // cattura anche l'unicast verso le VM
+			AttachBPF:       true,          // TEMP DISABLED FOR DEBUG
+			RecvTimeoutSec:  int(a.recvTimeout / time.Second),
+			ReadBufferBytes: a.rawReadBuffer,
+			EtherTypes:      a.rawEtherTypes,
+			UDPPorts:        a.rawUDPPorts,
+			ReadErrors:      a.metrics.rawSocketErrors.WithLabelValues(name, rawSocketRead),
+			Backend:         a.captureBackend,
+			VLANs:           a.vlans,
+			VLANFrames:      a.metrics.interfaceVLANFrames(name),
+			PacketLog:       a.packetLog,
+		},
+	)
+
+	if a.arpWake {
+		listener.SetARPHandler(func(req ARPRequest) {
+			a.handleARPRequest(req, name)
+		})
+		if a.arpRespond {
+			listener.SetNeighborResponder(a.neighborMAC, func(protocol string, err error) {
+				a.metrics.neighborReplied(name, protocol, err)
+			})
+		}
+	}
+	if a.wakeTriggers.Enabled() {
+		listener.SetTriggerHandler(a.wakeTriggers, func(pkt TriggerPacket) {
+			a.handleTrigger(pkt, name)
+		})
+	}
+	if a.sleepEtherType != 0 {
+		listener.SetSleepHandler(a.sleepEtherType, func(mac string, payload []byte, srcMAC net.HardwareAddr) {
+			a.metrics.packetReceived(ListenerProtocolRaw, 0, name)
+			a.rawSleepHandler(mac, payload, srcMAC)
+		})
+	}
+	if len(a.directedPorts) > 0 || len(a.rawUDPPorts) > 0 {
+		listener.SetDirectedHandler(a.directedPorts, func(pkt DirectedPacket) {
+			a.metrics.packetReceived(ListenerProtocolRaw, int(pkt.Port), name)
+			a.rawDirectedHandler(pkt)
+		})
+	}
+
+	err := listener.Start(ctx)
+	a.setBindError(&wolv1.ListenerBinding{Protocol: ListenerProtocolRaw, Interface: name}, err)
+	if err != nil {
+		a.metrics.rawSocketErrors.WithLabelValues(name, rawSocketOpen).Inc()
+		return err
+	}
+
+	a.rawListeners = append(a.rawListeners, listener) // slice dei listener per stop futuro
+	a.log.Info("Raw listener capture mode", "iface", name, "mode", listener.CaptureMode())
+	return nil
+}
+
+// candidateInterfaces returns the candidate interfaces allowed by the interface rules
+func (a *Agent) candidateInterfaces() ([]net.Interface, error) {
+	a.rawMu.Lock()
+	rules := a.interfaceRules
+	if a.nodeRules != nil {
+		rules = *a.nodeRules
+	}
+	a.rawMu.Unlock()
+	interfaces, err := GetCandidateInterfaces(rules, a.log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect network interfaces: %w", err)
+	}
+	return interfaces, nil
+}
+
+// setNodeRules sets the interface rules of the node annotations (nil if the
+// node has none) and returns true if they changed
+func (a *Agent) setNodeRules(pb *wolv1.InterfaceRules) bool {
+	var rules *InterfaceRules
+	if pb != nil {
+		rules = &InterfaceRules{Include: pb.Include, Exclude: pb.Exclude}
+	}
+	a.rawMu.Lock()
+	defer a.rawMu.Unlock()
+	if rules == nil && a.nodeRules == nil ||
+		rules != nil && a.nodeRules != nil && slices.Equal(rules.Include, a.nodeRules.Include) &&
+			slices.Equal(rules.Exclude, a.nodeRules.Exclude) {
+		return false
+	}
+	a.nodeRules = rules
+	return true
+}
+
+// refreshCandidateListeners stops the raw listeners on the candidate
+// interfaces no longer allowed by the rules and starts the newly allowed ones.
+// The listeners on hinted interfaces are left alone.
+func (a *Agent) refreshCandidateListeners(ctx context.Context) {
+	if a.rawPacketHandler == nil {
+		return // raw listener non avviato
+	}
+	interfaces, err := a.candidateInterfaces()
+	if err != nil {
+		// Nessuna interfaccia ammessa dalle nuove regole: chiude le altre
+		a.log.Error(err, "No raw listener interface allowed by the interface rules")
+	}
+	wanted := make(map[string]bool, len(interfaces))
+	for _, iface := range interfaces {
+		wanted[iface.Name] = true
+	}
+
+	a.rawMu.Lock()
+	running := make(map[string]bool, len(a.rawListeners))
+	kept := a.rawListeners[:0]
+	for _, l := range a.rawListeners {
+		if !a.hintedIfaces[l.interfaceName] && !wanted[l.interfaceName] {
+			l.Stop()
+			a.log.Info("Stopped WoL listener on interface excluded by the node rules", "iface", l.interfaceName)
+			continue
+		}
+		running[l.interfaceName] = true
+		kept = append(kept, l)
+	}
+	a.rawListeners = kept
+	a.rawMu.Unlock()
+
+	for _, iface := range interfaces {
+		if running[iface.Name] {
+			continue
+		}
+		if err := a.startInterfaceListener(ctx, iface.Name); err != nil {
+			a.log.Error(err, "Failed to start WoL listener", "iface", iface.Name)
+		}
+	}
+}
+
+// startHintedListener starts a listener on an interface hinted by the operator,
+// remembering it so it can be stopped once the hint disappears. Interfaces
+// already listened on as candidates are left alone.
+func (a *Agent) startHintedListener(ctx context.Context, name string) error {
+	a.rawMu.Lock()
+	for _, l := range a.rawListeners {
+		if l.interfaceName == name {
+			a.rawMu.Unlock()
+			return nil
+		}
+	}
+	a.rawMu.Unlock()
+
+	if err := a.startInterfaceListener(ctx, name); err != nil {
+		return err
+	}
+
+	a.rawMu.Lock()
+	defer a.rawMu.Unlock()
+	if a.hintedIfaces == nil {
+		a.hintedIfaces = make(map[string]bool)
+	}
+	a.hintedIfaces[name] = true
+	return nil
+}
+
+// stopUnhintedListeners stops the listeners started for hints that are no longer wanted
+func (a *Agent) stopUnhintedListeners(wanted map[string]bool) {
+	a.rawMu.Lock()
+	defer a.rawMu.Unlock()
+
+	kept := a.rawListeners[:0]
+	for _, l := range a.rawListeners {
+		if a.hintedIfaces[l.interfaceName] && !wanted[l.interfaceName] {
+			l.Stop()
+			delete(a.hintedIfaces, l.interfaceName)
+			a.log.Info("Stopped WoL listener on interface no longer hinted", "iface", l.interfaceName)
+			continue
+		}
+		kept = append(kept, l)
+	}
+	a.rawListeners = kept
+}
+
 func (a *Agent) stopRawListeners() {
+	a.rawMu.Lock()
+	defer a.rawMu.Unlock()
 	for _, l := range a.rawListeners {
 		l.Stop()
 	}
@@ -477,6 +1278,7 @@ func (a *Agent) stopRawListeners() {
 }
 
 // startHealthServer starts HTTP server for health checks and metrics
+// (metrics on their own server when SetMetricsPort chose another port)
 func (a *Agent) startHealthServer(ctx context.Context) {
 	defer a.wg.Done()
 	mux := http.NewServeMux()
@@ -499,8 +1301,8 @@ func (a *Agent) startHealthServer(ctx context.Context) {
 
 	// Readiness check endpoint
 	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
-		// Check if UDP listener is active
-		if a.conn == nil {
+		// Check if UDP listener has been started
+		if a.udpHeartbeat.Load() == 0 && a.udp6Heartbeat.Load() == 0 {
 			w.WriteHeader(http.StatusServiceUnavailable)
 			if _, err := w.Write([]byte("UDP listener not active")); err != nil {
 				a.log.Error(err, "Failed to write readiness check response")
@@ -515,57 +1317,233 @@ func (a *Agent) startHealthServer(ctx context.Context) {
 			}
 			return
 		}
+		// Components still failing after repeated watchdog checks
+		if failing := a.watchdog.unhealthy(watchdogFailureThreshold); len(failing) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			if _, err := w.Write([]byte("components failing: " + strings.Join(failing, ", "))); err != nil {
+				a.log.Error(err, "Failed to write readiness check response")
+			}
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		if _, err := w.Write([]byte("ready")); err != nil {
 			a.log.Error(err, "Failed to write readiness check response")
 		}
 	})
 
-	// Metrics endpoint (basic Prometheus format)
-	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-		a.dedupeLock.RLock()
-		cacheSize := len(a.dedupeCache)
-		a.dedupeLock.RUnlock()
+	// Metriche Prometheus: sulla porta degli health check o su una dedicata
+	if a.metricsPort == DefaultAgentHealthPort {
+		mux.Handle("/metrics", a.metrics.handler())
+	} else if a.metricsPort > 0 {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", a.metrics.handler())
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			a.serveHTTP(ctx, "metrics", a.metricsPort, metricsMux)
+		}()
+	}
 
-		w.Header().Set("Content-Type", "text/plain")
-		if _, err := fmt.Fprintf(w, "# HELP wol_agent_dedupe_cache_size Number of entries in deduplication cache\n"); err != nil {
-			a.log.Error(err, "Failed to write metrics")
-		}
-		if _, err := fmt.Fprintf(w, "# TYPE wol_agent_dedupe_cache_size gauge\n"); err != nil {
-			a.log.Error(err, "Failed to write metrics")
-		}
-		if _, err := fmt.Fprintf(w, "wol_agent_dedupe_cache_size{node=\"%s\"} %d\n", a.nodeName, cacheSize); err != nil {
-			a.log.Error(err, "Failed to write metrics")
-		}
-		if _, err := fmt.Fprintf(w, "# HELP wol_agent_info Agent information\n"); err != nil {
-			a.log.Error(err, "Failed to write metrics")
-		}
-		if _, err := fmt.Fprintf(w, "# TYPE wol_agent_info gauge\n"); err != nil {
-			a.log.Error(err, "Failed to write metrics")
-		}
-		if _, err := fmt.Fprintf(w, "wol_agent_info{node=\"%s\",port=\"%d\",operator=\"%s\"} 1\n",
-			a.nodeName, a.port, a.operatorAddr); err != nil {
-			a.log.Error(err, "Failed to write metrics")
-		}
-	})
+	a.serveHTTP(ctx, "health check", DefaultAgentHealthPort, mux)
+}
 
+// serveHTTP serves handler on port until ctx is cancelled
+func (a *Agent) serveHTTP(ctx context.Context, name string, port int, handler http.Handler) {
 	server := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: handler,
 	}
 
-	a.log.Info("Starting health check server", "port", 8080)
+	a.log.Info("Starting "+name+" server", "port", port)
 
 	go func() {
 		<-ctx.Done()
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		if err := server.Shutdown(shutdownCtx); err != nil {
-			a.log.Error(err, "Failed to shutdown health check server")
+			a.log.Error(err, "Failed to shutdown "+name+" server")
 		}
 	}()
 
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		a.log.Error(err, "Health check server failed")
+		a.log.Error(err, name+" server failed")
+	}
+}
+
+// syncARPTargets periodically fetches from the operator the IPs of stopped VMs
+func (a *Agent) syncARPTargets(ctx context.Context) {
+	defer a.wg.Done()
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		a.fetchARPTargets(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.arpTracker.cleanup(time.Minute, time.Now())
+		}
+	}
+}
+
+func (a *Agent) fetchARPTargets(ctx context.Context) {
+	reqCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := a.grpcClient.GetARPTargets(reqCtx, &wolv1.ARPTargetsRequest{
+		NodeName:  a.nodeName,
+		WolConfig: a.wolConfigName,
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			a.log.Error(err, "Failed to fetch ARP targets from operator (keeping the previous ones)")
+		}
+		return
+	}
+
+	targets := make(map[string]*wolv1.ARPTarget, len(resp.Targets))
+	for _, target := range resp.Targets {
+		targets[target.Ip] = target
+	}
+
+	a.arpTargetsMu.Lock()
+	a.arpTargets = targets
+	a.arpTargetsMu.Unlock()
+	a.log.V(1).Info("ARP targets updated", "count", len(targets))
+}
+
+// neighborMAC returns the MAC to answer with for ip, nil if it is not the IP
+// of a stopped VM the operator asked to answer for
+func (a *Agent) neighborMAC(ip net.IP) net.HardwareAddr {
+	a.arpTargetsMu.RLock()
+	target := a.arpTargets[ip.String()]
+	a.arpTargetsMu.RUnlock()
+	if target == nil || !target.Respond {
+		return nil
+	}
+	mac, err := net.ParseMAC(target.MacAddress)
+	if err != nil {
+		return nil
+	}
+	return mac
+}
+
+// handleARPRequest requests a wake once enough ARP requests for the IP of a stopped VM are seen
+func (a *Agent) handleARPRequest(req ARPRequest, iface string) {
+	ip := req.TargetIP.String()
+
+	a.arpTargetsMu.RLock()
+	target := a.arpTargets[ip]
+	a.arpTargetsMu.RUnlock()
+	if target == nil || target.TriggersOnly {
+		return
+	}
+
+	window := time.Duration(target.WindowSeconds) * time.Second
+	if !a.arpTracker.observe(ip, int(target.Threshold), window, time.Now()) {
+		a.log.V(1).Info("ARP request for stopped VM (below threshold)",
+			"ip", ip, "vm", target.Name, "namespace", target.Namespace, "from", req.SenderIP.String())
+		return
+	}
+
+	a.arpWakes.Add(1)
+	reason := fmt.Sprintf("arp who-has %s from %s (%s) on %s/%s", ip, req.SenderIP, req.SenderMAC, a.nodeName, iface)
+	a.log.Info("ARP requests confirmed for stopped VM, requesting wake",
+		"ip", ip, "vm", target.Name, "namespace", target.Namespace, "from", req.SenderIP.String())
+
+	a.report(func(reportCtx context.Context) {
+		wakeCtx, cancel := context.WithTimeout(reportCtx, 5*time.Second)
+		defer cancel()
+
+		resp, err := a.grpcClient.RequestWake(wakeCtx, &wolv1.WakeRequest{
+			Namespace: target.Namespace,
+			Name:      target.Name,
+			Source:    ARPWakeSource,
+			Reason:    reason,
+		})
+		if err != nil {
+			a.log.Error(err, "Failed to request ARP wake", "vm", target.Name, "namespace", target.Namespace)
+			ErrorsTotal.Inc()
+			return
+		}
+		a.log.Info("ARP wake reported to operator",
+			"vm", target.Name,
+			"namespace", target.Namespace,
+			"status", resp.Status.String(),
+			"message", resp.Message)
+	})
+}
+
+// syncInterfaceHints periodically fetches from the operator the host interfaces
+// of the NetworkAttachmentDefinitions used by the VMs and listens on the ones
+// present on this node
+func (a *Agent) syncInterfaceHints(ctx context.Context) {
+	defer a.wg.Done()
+	ticker := time.NewTicker(60 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		a.applyInterfaceHints(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchInterfaceHints fetches from the operator the interface hints of this node
+func (a *Agent) fetchInterfaceHints(ctx context.Context) (*wolv1.InterfaceHintsResponse, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := a.grpcClient.GetInterfaceHints(reqCtx, &wolv1.InterfaceHintsRequest{
+		NodeName:  a.nodeName,
+		WolConfig: a.wolConfigName,
+	})
+	if err != nil && ctx.Err() == nil {
+		a.log.V(1).Info("Failed to fetch interface hints from operator", "error", err.Error())
+	}
+	return resp, err
+}
+
+func (a *Agent) applyInterfaceHints(ctx context.Context) {
+	resp, err := a.fetchInterfaceHints(ctx)
+	if err != nil {
+		return
+	}
+	if a.setNodeRules(resp.NodeRules) {
+		a.log.Info("Interface rules of the node changed", "include", resp.NodeRules.GetInclude(),
+			"exclude", resp.NodeRules.GetExclude())
+		a.refreshCandidateListeners(ctx)
+	}
+
+	a.rawMu.Lock()
+	a.hintNames = resp.Interfaces
+	a.rawMu.Unlock()
+
+	wanted := make(map[string]bool, len(resp.Interfaces))
+	for _, name := range resp.Interfaces {
+		if _, err := net.InterfaceByName(name); err != nil {
+			a.log.V(1).Info("Hinted interface not present on this node", "iface", name)
+			continue
+		}
+		wanted[name] = true
+		if err := a.startHintedListener(ctx, name); err != nil {
+			a.log.Error(err, "Failed to start WoL listener on hinted interface", "iface", name)
+			continue
+		}
+	}
+	a.stopUnhintedListeners(wanted)
+
+	for _, attachment := range resp.Attachments {
+		a.log.V(1).Info("Network attachment used by managed VMs",
+			"nad", attachment.Namespace+"/"+attachment.Name,
+			"type", attachment.Type,
+			"iface", attachment.Interface,
+			"vlan", attachment.Vlan)
 	}
 }
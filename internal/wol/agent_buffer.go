@@ -0,0 +1,244 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+const (
+	// DefaultEventBufferSize is the default number of events the agent keeps
+	// while the operator is unreachable
+	DefaultEventBufferSize = 256
+	// DefaultEventBufferMaxAge is the default age after which a buffered event
+	// is dropped: a wake reported later than this is no longer expected
+	DefaultEventBufferMaxAge = time.Minute
+
+	// eventBufferMinBackoff is the first wait before replaying the buffer after a failure
+	eventBufferMinBackoff = time.Second
+	// eventBufferMaxBackoff caps the wait between replays
+	eventBufferMaxBackoff = 30 * time.Second
+)
+
+// Reasons counted by wol_agent_event_buffer_dropped_total
+const (
+	bufferDropOverflow = "overflow"
+	bufferDropExpired  = "expired"
+	bufferDropRejected = "rejected"
+	bufferDropShutdown = "shutdown"
+)
+
+// eventBuffer keeps, in order, the events the operator could not be reached
+// for. They are replayed with exponential backoff until they are older than
+// maxAge; when full, the oldest event is dropped.
+type eventBuffer struct {
+	size   int
+	maxAge time.Duration
+	notify chan struct{} // sveglia il replay (nuovo evento o operatore tornato raggiungibile)
+
+	mu      sync.Mutex
+	events  []*bufferedEvent
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// bufferedEvent is an event waiting to be reported again
+type bufferedEvent struct {
+	event      *wolv1.WOLEvent
+	receivedAt time.Time
+	attempts   int
+}
+
+func newEventBuffer(size int, maxAge time.Duration) *eventBuffer {
+	if size <= 0 {
+		return nil
+	}
+	return &eventBuffer{size: size, maxAge: maxAge, notify: make(chan struct{}, 1)}
+}
+
+// SetEventBuffer sets how many events (0 disables the buffer) the agent keeps,
+// for at most maxAge, while the operator is unreachable. Must be called before Start.
+func (a *Agent) SetEventBuffer(size int, maxAge time.Duration) {
+	if maxAge <= 0 {
+		maxAge = DefaultEventBufferMaxAge
+	}
+	a.buffer = newEventBuffer(size, maxAge)
+}
+
+// retryableReportError returns true if a report failed because the operator
+// could not be reached (e.g. restarting), not because it refused the event
+func retryableReportError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+// len returns the number of buffered events
+func (b *eventBuffer) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.events)
+}
+
+// wake replays the buffer at once, e.g. when a report succeeded again
+func (b *eventBuffer) wake() {
+	b.mu.Lock()
+	b.backoff, b.retryAt = 0, time.Time{}
+	empty := len(b.events) == 0
+	b.mu.Unlock()
+	if !empty {
+		select {
+		case b.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// bufferEvent keeps an event whose report failed, and returns false if it is
+// dropped instead (buffer disabled or error not transient)
+func (a *Agent) bufferEvent(event *wolv1.WOLEvent, receivedAt time.Time, err error) bool {
+	b := a.buffer
+	if b == nil || !retryableReportError(err) {
+		return false
+	}
+
+	b.mu.Lock()
+	if len(b.events) >= b.size {
+		oldest := b.events[0]
+		b.events = b.events[1:]
+		a.dropBuffered(oldest, bufferDropOverflow)
+	}
+	b.events = append(b.events, &bufferedEvent{event: event, receivedAt: receivedAt, attempts: 1})
+	size := len(b.events)
+	b.mu.Unlock()
+
+	a.log.Info("Operator unreachable, event buffered", "mac", event.MacAddress, "buffered", size)
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// dropBuffered counts and logs a buffered event given up on
+func (a *Agent) dropBuffered(buffered *bufferedEvent, reason string) {
+	a.metrics.bufferDropped.WithLabelValues(reason).Inc()
+	a.reportFailures.Add(1)
+	a.log.Error(nil, "Dropping buffered WOL event", "mac", buffered.event.MacAddress, "reason", reason,
+		"attempts", buffered.attempts, "age", time.Since(buffered.receivedAt).String())
+}
+
+// replayEvents reports the buffered events until ctx is done; the events still
+// buffered then are dropped
+func (a *Agent) replayEvents(ctx context.Context) {
+	defer a.wg.Done()
+	b := a.buffer
+
+	timer := time.NewTimer(eventBufferMaxBackoff)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			b.mu.Lock()
+			events := b.events
+			b.events = nil
+			b.mu.Unlock()
+			for _, buffered := range events {
+				a.dropBuffered(buffered, bufferDropShutdown)
+			}
+			return
+		case <-b.notify:
+		case <-timer.C:
+		}
+		timer.Reset(a.replayBuffer())
+	}
+}
+
+// replayBuffer reports the buffered events in order, stopping at the first
+// transient failure, and returns how long to wait before the next replay
+func (a *Agent) replayBuffer() time.Duration {
+	b := a.buffer
+	for {
+		now := time.Now()
+		b.mu.Lock()
+		if now.Before(b.retryAt) {
+			wait := b.retryAt.Sub(now)
+			b.mu.Unlock()
+			return wait
+		}
+		for len(b.events) > 0 && now.Sub(b.events[0].receivedAt) > b.maxAge {
+			a.dropBuffered(b.events[0], bufferDropExpired)
+			b.events = b.events[1:]
+		}
+		if len(b.events) == 0 {
+			b.backoff = 0
+			b.mu.Unlock()
+			return eventBufferMaxBackoff
+		}
+		next := b.events[0]
+		b.mu.Unlock()
+
+		// Il ritardo riportato include il tempo passato nel buffer
+		event := next.event
+		event.AgentDelayUs = uint64(time.Since(next.receivedAt).Microseconds())
+		ctx, cancel := context.WithTimeout(a.reportCtx, 5*time.Second)
+		resp, err := a.sendEvent(ctx, event)
+		cancel()
+
+		b.mu.Lock()
+		if err != nil && retryableReportError(err) {
+			next.attempts++
+			if b.backoff == 0 {
+				b.backoff = eventBufferMinBackoff
+			} else {
+				b.backoff = min(2*b.backoff, eventBufferMaxBackoff)
+			}
+			b.retryAt = time.Now().Add(b.backoff)
+			a.log.V(1).Info("Operator still unreachable, retrying the buffered events later",
+				"buffered", len(b.events), "retryIn", b.backoff.String(), "error", err.Error())
+			backoff := b.backoff
+			b.mu.Unlock()
+			return backoff
+		}
+		// L'evento può essere già uscito dal buffer (overflow) durante il report
+		if len(b.events) > 0 && b.events[0] == next {
+			b.events = b.events[1:]
+		}
+		b.backoff = 0
+		b.mu.Unlock()
+
+		if err != nil {
+			a.log.Error(err, "Operator rejected buffered WOL event", "mac", event.MacAddress)
+			a.dropBuffered(next, bufferDropRejected)
+			continue
+		}
+		a.metrics.bufferReplayed.Inc()
+		a.log.Info("Buffered event reported", "mac", event.MacAddress, "attempts", next.attempts,
+			"age", time.Since(next.receivedAt).String())
+		a.reportHandled(event, resp, next.receivedAt)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// downClient fails the unary reports with err while it is set
+type downClient struct {
+	wolv1.WOLServiceClient
+
+	mu       sync.Mutex
+	err      error
+	reported []string
+}
+
+func (c *downClient) ReportWOLEvent(_ context.Context, event *wolv1.WOLEvent, _ ...grpc.CallOption) (*wolv1.WOLEventResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.reported = append(c.reported, event.MacAddress)
+	return &wolv1.WOLEventResponse{Status: wolv1.ResponseStatus_VM_START_INITIATED}, nil
+}
+
+func (c *downClient) set(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+func newBufferAgent(size int, err error) (*Agent, *downClient) {
+	client := &downClient{err: err}
+	agent := NewAgent(9, "node-a", "operator:9090", logr.Discard())
+	agent.SetEventBuffer(size, time.Minute)
+	agent.grpcClient = client
+	return agent, client
+}
+
+func receivePacket(t *testing.T, agent *Agent, mac string) {
+	t.Helper()
+	packet, err := newMagicPacket(mac, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.processPacket(context.Background(), packet, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 40000}, 9, false, time.Now())
+}
+
+func TestAgent_EventBufferReplay(t *testing.T) {
+	agent, client := newBufferAgent(4, status.Error(codes.Unavailable, "connection refused"))
+
+	receivePacket(t, agent, "52:54:00:00:00:01")
+	receivePacket(t, agent, "52:54:00:00:00:02")
+	if got := agent.buffer.len(); got != 2 {
+		t.Fatalf("Expected 2 buffered events, got %d", got)
+	}
+	if got := agent.reportFailures.Load(); got != 0 {
+		t.Errorf("Expected no report failure while buffered, got %d", got)
+	}
+
+	// Operatore ancora giù: backoff esponenziale
+	if wait := agent.replayBuffer(); wait != eventBufferMinBackoff {
+		t.Errorf("Expected a %s backoff, got %s", eventBufferMinBackoff, wait)
+	}
+	agent.buffer.mu.Lock()
+	agent.buffer.retryAt = time.Time{}
+	agent.buffer.mu.Unlock()
+	if wait := agent.replayBuffer(); wait != 2*eventBufferMinBackoff {
+		t.Errorf("Expected the backoff doubled, got %s", wait)
+	}
+
+	// L'operatore torna: un report riuscito fa ripartire subito il replay, in ordine
+	client.set(nil)
+	receivePacket(t, agent, "52:54:00:00:00:03")
+	select {
+	case <-agent.buffer.notify:
+	default:
+		t.Error("Expected a successful report to wake the replay")
+	}
+	agent.replayBuffer()
+	if got := agent.buffer.len(); got != 0 {
+		t.Errorf("Expected an empty buffer, got %d", got)
+	}
+	want := []string{"52:54:00:00:00:03", "52:54:00:00:00:01", "52:54:00:00:00:02"}
+	if fmt.Sprint(client.reported) != fmt.Sprint(want) {
+		t.Errorf("Expected the reports %v, got %v", want, client.reported)
+	}
+	if got := testutil.ToFloat64(agent.metrics.bufferReplayed); got != 2 {
+		t.Errorf("Expected 2 replayed events, got %v", got)
+	}
+}
+
+func TestAgent_EventBufferDrops(t *testing.T) {
+	agent, client := newBufferAgent(2, status.Error(codes.DeadlineExceeded, "timeout"))
+	for i := range 3 {
+		receivePacket(t, agent, fmt.Sprintf("52:54:00:00:00:%02x", i))
+	}
+	if got := testutil.ToFloat64(agent.metrics.bufferDropped.WithLabelValues(bufferDropOverflow)); got != 1 {
+		t.Errorf("Expected the oldest event dropped on overflow, got %v", got)
+	}
+
+	// Gli eventi troppo vecchi non vengono più riportati
+	agent.buffer.mu.Lock()
+	agent.buffer.events[0].receivedAt = time.Now().Add(-2 * time.Minute)
+	agent.buffer.mu.Unlock()
+	client.set(status.Error(codes.PermissionDenied, "denied"))
+	agent.replayBuffer()
+	if got := testutil.ToFloat64(agent.metrics.bufferDropped.WithLabelValues(bufferDropExpired)); got != 1 {
+		t.Errorf("Expected an expired event, got %v", got)
+	}
+	if got := testutil.ToFloat64(agent.metrics.bufferDropped.WithLabelValues(bufferDropRejected)); got != 1 {
+		t.Errorf("Expected a rejected event, got %v", got)
+	}
+
+	// Un errore non transitorio non va nel buffer
+	receivePacket(t, agent, "52:54:00:00:00:10")
+	if got := agent.buffer.len(); got != 0 {
+		t.Errorf("Expected a refused event not to be buffered, got %d", got)
+	}
+	if got := agent.reportFailures.Load(); got != 4 {
+		t.Errorf("Expected 4 report failures, got %d", got)
+	}
+
+	// Con il buffer disabilitato l'evento è perso subito
+	agent.SetEventBuffer(0, 0)
+	client.set(status.Error(codes.Unavailable, "down"))
+	receivePacket(t, agent, "52:54:00:00:00:11")
+	if got := agent.reportFailures.Load(); got != 5 {
+		t.Errorf("Expected the event lost without buffer, got %d failures", got)
+	}
+}
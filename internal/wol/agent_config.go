@@ -0,0 +1,420 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultAgentConfigPollInterval is how often the agent config file is checked for changes
+const DefaultAgentConfigPollInterval = 10 * time.Second
+
+// AgentConfigFile is the agent configuration file (YAML or JSON), usually
+// mounted from a ConfigMap. Unset fields keep the value of the matching flag;
+// flags set on the command line win over the file.
+type AgentConfigFile struct {
+	// OperatorAddress is the gRPC address of the manager (--operator-address)
+	OperatorAddress string `json:"operatorAddress,omitempty"`
+	// WolConfig served by the agent (--wolconfig)
+	WolConfig string `json:"wolConfig,omitempty"`
+	// Ports are the UDP ports for WOL packets (--ports)
+	Ports []int `json:"ports,omitempty"`
+	// IPFamilies of the UDP listener, IPv4 and/or IPv6 (--ip-families)
+	IPFamilies []string `json:"ipFamilies,omitempty"`
+	// ListenModes selects the listeners, Raw, UDP or Both (--listen-modes)
+	ListenModes []string `json:"listenModes,omitempty"`
+	// ARPWake enables ARP-triggered wakes (--arp-wake)
+	ARPWake *bool `json:"arpWake,omitempty"`
+	// ARPRespond answers ARP and neighbor solicitations for stopped VMs (--arp-respond)
+	ARPRespond *bool `json:"arpRespond,omitempty"`
+	// WakeTriggerICMP wakes stopped VMs on pings (--wake-trigger-icmp)
+	WakeTriggerICMP *bool `json:"wakeTriggerICMP,omitempty"`
+	// WakeTriggerTCPPorts wakes stopped VMs on TCP SYNs to these ports (--wake-trigger-tcp-ports)
+	WakeTriggerTCPPorts []int `json:"wakeTriggerTCPPorts,omitempty"`
+	// WakeTriggerDNS wakes stopped VMs on DNS queries for their names (--wake-trigger-dns)
+	WakeTriggerDNS *bool `json:"wakeTriggerDNS,omitempty"`
+	// DirectedWake captures magic packets unicast to the VMs (--directed-wake)
+	DirectedWake *bool `json:"directedWake,omitempty"`
+	// RawUDPPorts are the UDP ports parsed by the raw listeners (--raw-udp-ports)
+	RawUDPPorts []int `json:"rawUDPPorts,omitempty"`
+	// RawEtherTypes are the EtherTypes of the raw wake frames (--raw-ethertypes)
+	RawEtherTypes []string `json:"rawEtherTypes,omitempty"`
+	// VLANs restricts the raw listeners to these VLAN IDs, 0 = untagged (--vlans)
+	VLANs []int `json:"vlans,omitempty"`
+	// Promiscuous capture on the raw listeners (--promiscuous)
+	Promiscuous *bool `json:"promiscuous,omitempty"`
+	// CaptureBackend of the raw listeners, AFPacket or EBPF (--capture-backend)
+	CaptureBackend string `json:"captureBackend,omitempty"`
+	// StreamEvents reports the events on a long-lived gRPC stream (--stream-events)
+	StreamEvents *bool `json:"streamEvents,omitempty"`
+	// Interfaces restricts the interfaces picked for the raw listeners
+	Interfaces InterfaceRules `json:"interfaces,omitempty"`
+	// DedupeWindow is how long a repeated packet is dropped by the agent (--dedupe-window)
+	DedupeWindow *metav1.Duration `json:"dedupeWindow,omitempty"`
+	// DedupeCleanupInterval (--dedupe-cleanup-interval)
+	DedupeCleanupInterval *metav1.Duration `json:"dedupeCleanupInterval,omitempty"`
+	// DrainTimeout (--drain-timeout)
+	DrainTimeout *metav1.Duration `json:"drainTimeout,omitempty"`
+	// EventBufferSize is how many events are kept while the operator is unreachable (--event-buffer-size)
+	EventBufferSize *int `json:"eventBufferSize,omitempty"`
+	// EventBufferMaxAge (--event-buffer-max-age)
+	EventBufferMaxAge *metav1.Duration `json:"eventBufferMaxAge,omitempty"`
+	// ReportWorkers are the goroutines reporting the packets (--report-workers)
+	ReportWorkers *int `json:"reportWorkers,omitempty"`
+	// ReportQueueSize is how many packets wait for a report worker (--report-queue-size)
+	ReportQueueSize *int `json:"reportQueueSize,omitempty"`
+	// ReceiveTimeout (--recv-timeout)
+	ReceiveTimeout *metav1.Duration `json:"receiveTimeout,omitempty"`
+	// UDPReadBufferBytes (--udp-read-buffer)
+	UDPReadBufferBytes *int `json:"udpReadBufferBytes,omitempty"`
+	// RawReadBufferBytes (--raw-read-buffer)
+	RawReadBufferBytes *int `json:"rawReadBufferBytes,omitempty"`
+	// MetricsPort serves /metrics on its own port, 0 disables it (--metrics-port)
+	MetricsPort *int `json:"metricsPort,omitempty"`
+	// PacketLogRate is how many per-packet log lines are written per second (--packet-log-rate)
+	PacketLogRate *float64 `json:"packetLogRate,omitempty"`
+	// RedactPacketLogs hashes the source IPs and MACs in the per-packet logs (--redact-packet-logs)
+	RedactPacketLogs *bool `json:"redactPacketLogs,omitempty"`
+	// LogLevel is debug, info, error or a verbosity level (e.g. "2") (--zap-log-level)
+	LogLevel string `json:"logLevel,omitempty"`
+}
+
+// InterfaceRules selects interfaces by name, with shell patterns (e.g. "eth*")
+type InterfaceRules struct {
+	// Include, if not empty, selects the matching interfaces instead of those
+	// picked by name (see GetCandidateInterfaces)
+	Include []string `json:"include,omitempty"`
+	// Exclude drops the matching interfaces
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Allows returns true if an interface passes the rules
+func (r InterfaceRules) Allows(name string) bool {
+	for _, pattern := range r.Exclude {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+	if len(r.Include) == 0 {
+		return true
+	}
+	for _, pattern := range r.Include {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the patterns of the rules
+func (r InterfaceRules) Validate() error {
+	for _, pattern := range append(append([]string{}, r.Include...), r.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid interface pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// IsZero returns true if the rules allow every interface
+func (r InterfaceRules) IsZero() bool {
+	return len(r.Include) == 0 && len(r.Exclude) == 0
+}
+
+// ParseInterfaceRules parses comma-separated include and exclude patterns
+func ParseInterfaceRules(include, exclude string) (InterfaceRules, error) {
+	rules := InterfaceRules{Include: splitPatterns(include), Exclude: splitPatterns(exclude)}
+	return rules, rules.Validate()
+}
+
+func splitPatterns(list string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(list, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// LoadAgentConfigFile reads and validates an agent config file
+func LoadAgentConfigFile(file string) (*AgentConfigFile, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseAgentConfig(data)
+}
+
+func parseAgentConfig(data []byte) (*AgentConfigFile, error) {
+	config := &AgentConfigFile{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("invalid agent config: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid agent config: %w", err)
+	}
+	return config, nil
+}
+
+// Validate checks the values of the config file
+func (c *AgentConfigFile) Validate() error {
+	for _, port := range c.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("port %d out of range (must be 1-65535)", port)
+		}
+	}
+	if c.MetricsPort != nil && (*c.MetricsPort < 0 || *c.MetricsPort > 65535) {
+		return fmt.Errorf("metricsPort %d out of range (must be 0-65535)", *c.MetricsPort)
+	}
+	if c.EventBufferSize != nil && *c.EventBufferSize < 0 {
+		return fmt.Errorf("eventBufferSize must not be negative")
+	}
+	if c.PacketLogRate != nil && *c.PacketLogRate < 0 {
+		return fmt.Errorf("packetLogRate must not be negative")
+	}
+	if c.ReportWorkers != nil && *c.ReportWorkers < 1 {
+		return fmt.Errorf("reportWorkers must be at least 1")
+	}
+	if c.ReportQueueSize != nil && *c.ReportQueueSize < 0 {
+		return fmt.Errorf("reportQueueSize must not be negative")
+	}
+	if err := c.Interfaces.Validate(); err != nil {
+		return err
+	}
+	if c.OperatorAddress != "" {
+		if _, _, err := net.SplitHostPort(c.OperatorAddress); err != nil {
+			return fmt.Errorf("invalid operatorAddress %q: %w", c.OperatorAddress, err)
+		}
+	}
+	if c.LogLevel != "" {
+		if _, err := ParseLogLevel(c.LogLevel); err != nil {
+			return err
+		}
+	}
+	if len(c.IPFamilies) > 0 {
+		if _, _, err := ParseIPFamilies(strings.Join(c.IPFamilies, ",")); err != nil {
+			return err
+		}
+	}
+	if len(c.ListenModes) > 0 {
+		if _, _, err := ParseListenModes(strings.Join(c.ListenModes, ",")); err != nil {
+			return err
+		}
+	}
+	for _, vlan := range c.VLANs {
+		if vlan < 0 || vlan > 4094 {
+			return fmt.Errorf("VLAN ID %d out of range (must be 0-4094)", vlan)
+		}
+	}
+	if c.CaptureBackend != "" {
+		if _, err := ParseCaptureBackend(c.CaptureBackend); err != nil {
+			return err
+		}
+	}
+	for name, d := range map[string]*metav1.Duration{
+		"dedupeWindow": c.DedupeWindow, "dedupeCleanupInterval": c.DedupeCleanupInterval,
+		"drainTimeout": c.DrainTimeout, "receiveTimeout": c.ReceiveTimeout,
+		"eventBufferMaxAge": c.EventBufferMaxAge,
+	} {
+		if d != nil && d.Duration < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// ApplyTo sets the flags of fs from the config file. Flags set on the command
+// line are left alone, as are the interface rules and the log level, which
+// have no flag.
+func (c *AgentConfigFile) ApplyTo(fs *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	values := map[string]string{
+		"operator-address": c.OperatorAddress,
+		"wolconfig":        c.WolConfig,
+		"capture-backend":  c.CaptureBackend,
+	}
+	if len(c.Ports) > 0 {
+		values["ports"] = joinPorts(c.Ports)
+	}
+	if len(c.RawUDPPorts) > 0 {
+		values["raw-udp-ports"] = joinPorts(c.RawUDPPorts)
+	}
+	if len(c.WakeTriggerTCPPorts) > 0 {
+		values["wake-trigger-tcp-ports"] = joinPorts(c.WakeTriggerTCPPorts)
+	}
+	if len(c.RawEtherTypes) > 0 {
+		values["raw-ethertypes"] = strings.Join(c.RawEtherTypes, ",")
+	}
+	if len(c.VLANs) > 0 {
+		values["vlans"] = joinPorts(c.VLANs)
+	}
+	if len(c.IPFamilies) > 0 {
+		values["ip-families"] = strings.Join(c.IPFamilies, ",")
+	}
+	if len(c.ListenModes) > 0 {
+		values["listen-modes"] = strings.Join(c.ListenModes, ",")
+	}
+	if c.ARPWake != nil {
+		values["arp-wake"] = strconv.FormatBool(*c.ARPWake)
+	}
+	if c.ARPRespond != nil {
+		values["arp-respond"] = strconv.FormatBool(*c.ARPRespond)
+	}
+	if c.WakeTriggerICMP != nil {
+		values["wake-trigger-icmp"] = strconv.FormatBool(*c.WakeTriggerICMP)
+	}
+	if c.WakeTriggerDNS != nil {
+		values["wake-trigger-dns"] = strconv.FormatBool(*c.WakeTriggerDNS)
+	}
+	if c.DirectedWake != nil {
+		values["directed-wake"] = strconv.FormatBool(*c.DirectedWake)
+	}
+	if c.Promiscuous != nil {
+		values["promiscuous"] = strconv.FormatBool(*c.Promiscuous)
+	}
+	if c.PacketLogRate != nil {
+		values["packet-log-rate"] = strconv.FormatFloat(*c.PacketLogRate, 'f', -1, 64)
+	}
+	if c.RedactPacketLogs != nil {
+		values["redact-packet-logs"] = strconv.FormatBool(*c.RedactPacketLogs)
+	}
+	if c.StreamEvents != nil {
+		values["stream-events"] = strconv.FormatBool(*c.StreamEvents)
+	}
+	for name, d := range map[string]*metav1.Duration{
+		"dedupe-window": c.DedupeWindow, "dedupe-cleanup-interval": c.DedupeCleanupInterval,
+		"drain-timeout": c.DrainTimeout, "recv-timeout": c.ReceiveTimeout,
+		"event-buffer-max-age": c.EventBufferMaxAge,
+	} {
+		if d != nil {
+			values[name] = d.Duration.String()
+		}
+	}
+	for name, size := range map[string]*int{
+		"udp-read-buffer": c.UDPReadBufferBytes, "raw-read-buffer": c.RawReadBufferBytes,
+		"metrics-port": c.MetricsPort, "event-buffer-size": c.EventBufferSize,
+		"report-workers": c.ReportWorkers, "report-queue-size": c.ReportQueueSize,
+	} {
+		if size != nil {
+			values[name] = strconv.Itoa(*size)
+		}
+	}
+
+	for name, value := range values {
+		if value == "" || explicit[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid agent config value for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// RestartRequired returns true if next changes settings that are only read at
+// startup. The log level and the dedupe window are applied on the fly.
+func (c *AgentConfigFile) RestartRequired(next *AgentConfigFile) bool {
+	current, updated := *c, *next
+	current.LogLevel, updated.LogLevel = "", ""
+	current.DedupeWindow, updated.DedupeWindow = nil, nil
+	return !reflect.DeepEqual(current, updated)
+}
+
+// ParseLogLevel parses a level like the --zap-log-level flag: debug, info,
+// error or a positive verbosity (logr V level)
+func ParseLogLevel(level string) (zapcore.Level, error) {
+	switch level {
+	case "debug":
+		return zapcore.DebugLevel, nil
+	case "info":
+		return zapcore.InfoLevel, nil
+	case "error":
+		return zapcore.ErrorLevel, nil
+	}
+	v, err := strconv.Atoi(level)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid log level %q (debug, info, error or a verbosity >= 0)", level)
+	}
+	return zapcore.Level(-v), nil
+}
+
+// WatchAgentConfigFile polls file until ctx is done and calls onChange every
+// time its content differs from current, the config loaded at startup.
+// Invalid contents are reported to onError and the previous config stays in
+// effect. Polling follows the symlink swaps used by ConfigMap volumes, which
+// inotify on the file itself would miss.
+func WatchAgentConfigFile(ctx context.Context, file string, current *AgentConfigFile, interval time.Duration,
+	onChange func(*AgentConfigFile), onError func(error)) {
+	var last []byte
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			onError(err)
+			continue
+		}
+		if last != nil && bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+
+		config, err := parseAgentConfig(data)
+		if err != nil {
+			onError(err)
+			continue
+		}
+		if reflect.DeepEqual(config, current) {
+			continue
+		}
+		current = config
+		onChange(config)
+	}
+}
+
+// joinPorts formats ports as the comma-separated value of a flag
+func joinPorts(ports []int) string {
+	values := make([]string, len(ports))
+	for i, port := range ports {
+		values[i] = strconv.Itoa(port)
+	}
+	return strings.Join(values, ",")
+}
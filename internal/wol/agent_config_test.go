@@ -0,0 +1,236 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+const testAgentConfig = `
+operatorAddress: wol-grpc.example.svc:9090
+ports: [7, 9]
+arpWake: true
+dedupeWindow: 5s
+interfaces:
+  include: ["eth*", "bond*"]
+  exclude: ["eth9"]
+logLevel: debug
+`
+
+func TestParseAgentConfig(t *testing.T) {
+	config, err := parseAgentConfig([]byte(testAgentConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(config.Ports) != 2 || config.DedupeWindow.Duration != 5*time.Second || !*config.ARPWake {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	for _, invalid := range []string{
+		"ports: [70000]",
+		"logLevel: loud",
+		"operatorAddress: no-port",
+		"interfaces: {include: ['[']}",
+		"dedupeWindow: -1s",
+		"ipFamilies: [IPv5]",
+		"listenModes: [Sniff]",
+		"captureBackend: pcap",
+		"vlans: [4095]",
+		"reportWorkers: 0",
+		"packetLogRate: -1",
+		"unknownField: true",
+	} {
+		if _, err := parseAgentConfig([]byte(invalid)); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestInterfaceRules(t *testing.T) {
+	rules := InterfaceRules{Include: []string{"eth*", "bond*"}, Exclude: []string{"eth9"}}
+	for name, want := range map[string]bool{"eth0": true, "bond0": true, "eth9": false, "br-ex": false} {
+		if got := rules.Allows(name); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if !(InterfaceRules{}).Allows("anything") {
+		t.Error("Expected empty rules to allow every interface")
+	}
+}
+
+func TestParseInterfaceRules(t *testing.T) {
+	rules, err := ParseInterfaceRules(" eth1, br-wol* ,", "br-wol9")
+	if err != nil {
+		t.Fatalf("ParseInterfaceRules: %v", err)
+	}
+	if !slices.Equal(rules.Include, []string{"eth1", "br-wol*"}) || !slices.Equal(rules.Exclude, []string{"br-wol9"}) {
+		t.Errorf("Unexpected rules %+v", rules)
+	}
+	if rules, _ := ParseInterfaceRules("", ""); !rules.IsZero() {
+		t.Errorf("Expected empty rules, got %+v", rules)
+	}
+	if _, err := ParseInterfaceRules("eth[", ""); err == nil {
+		t.Error("Expected an invalid pattern to be rejected")
+	}
+}
+
+func TestGetCandidateInterfaces_Rules(t *testing.T) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatalf("net.Interfaces: %v", err)
+	}
+	var usable *net.Interface
+	for i, iface := range interfaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagBroadcast != 0 && iface.Flags&net.FlagLoopback == 0 {
+			usable = &interfaces[i]
+			break
+		}
+	}
+	if usable == nil {
+		t.Skip("No broadcast interface on this host")
+	}
+
+	got, err := GetCandidateInterfaces(InterfaceRules{Include: []string{usable.Name}}, logr.Discard())
+	if err != nil || len(got) != 1 || got[0].Name != usable.Name {
+		t.Errorf("Expected only %s to be selected, got %v (%v)", usable.Name, got, err)
+	}
+	got, _ = GetCandidateInterfaces(InterfaceRules{Include: []string{"*"}, Exclude: []string{usable.Name}}, logr.Discard())
+	for _, iface := range got {
+		if iface.Name == usable.Name {
+			t.Errorf("Expected %s to be excluded", usable.Name)
+		}
+	}
+}
+
+func TestAgent_SetNodeRules(t *testing.T) {
+	agent := NewAgent(9, "node-a", "operator:9090", logr.Discard())
+	if agent.setNodeRules(nil) {
+		t.Error("Expected no change without node rules")
+	}
+	if !agent.setNodeRules(&wolv1.InterfaceRules{Include: []string{"eth1"}}) {
+		t.Error("Expected the node rules to be set")
+	}
+	if agent.setNodeRules(&wolv1.InterfaceRules{Include: []string{"eth1"}}) {
+		t.Error("Expected no change with the same node rules")
+	}
+	if !agent.setNodeRules(nil) || agent.nodeRules != nil {
+		t.Error("Expected the node rules to be removed")
+	}
+}
+
+func TestAgentConfigApplyTo(t *testing.T) {
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	operator := fs.String("operator-address", "default:9090", "")
+	ports := fs.String("ports", "9", "")
+	arpWake := fs.Bool("arp-wake", false, "")
+	window := fs.Duration("dedupe-window", DefaultDedupeWindow, "")
+	if err := fs.Parse([]string{"--ports=10"}); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := parseAgentConfig([]byte(testAgentConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.ApplyTo(fs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if *operator != "wol-grpc.example.svc:9090" || !*arpWake || *window != 5*time.Second {
+		t.Errorf("Expected the file values, got %s %v %s", *operator, *arpWake, *window)
+	}
+	if *ports != "10" {
+		t.Errorf("Expected the command line ports to win, got %s", *ports)
+	}
+}
+
+func TestAgentConfigRestartRequired(t *testing.T) {
+	current, _ := parseAgentConfig([]byte(testAgentConfig))
+	next, _ := parseAgentConfig([]byte(testAgentConfig + "\n"))
+	next.LogLevel = "error"
+	next.DedupeWindow = nil
+	if current.RestartRequired(next) {
+		t.Error("Expected log level and dedupe window changes to be applied on the fly")
+	}
+	next.Ports = []int{9}
+	if !current.RestartRequired(next) {
+		t.Error("Expected a ports change to require a restart")
+	}
+}
+
+func TestWatchAgentConfigFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "agent.yaml")
+	if err := os.WriteFile(file, []byte("logLevel: info\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan *AgentConfigFile, 1)
+	errs := make(chan error, 1)
+	current, err := LoadAgentConfigFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go WatchAgentConfigFile(ctx, file, current, 10*time.Millisecond,
+		func(c *AgentConfigFile) { changes <- c },
+		func(err error) { errs <- err })
+
+	if err := os.WriteFile(file, []byte("logLevel: loud\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-errs:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the invalid config to be reported")
+	}
+
+	if err := os.WriteFile(file, []byte("logLevel: debug\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c := <-changes:
+		if c.LogLevel != "debug" {
+			t.Errorf("Expected the new log level, got %q", c.LogLevel)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the config change to be reported")
+	}
+}
+
+func TestAgent_SetDedupeWindow(t *testing.T) {
+	agent := NewAgent(0, "test-node", "", logr.Discard())
+	agent.SetDedupeWindow(time.Hour)
+	if !agent.shouldProcess("key", "") || agent.shouldProcess("key", "") {
+		t.Error("Expected the repeated packet to be dropped")
+	}
+	agent.SetDedupeWindow(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if !agent.shouldProcess("key", "") {
+		t.Error("Expected the shorter window to apply on the fly")
+	}
+}
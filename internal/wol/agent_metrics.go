@@ -0,0 +1,263 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultAgentHealthPort is the port of the agent health checks (and, by
+// default, of its metrics)
+const DefaultAgentHealthPort = 8080
+
+// Operations counted by wol_agent_raw_socket_errors_total
+const (
+	rawSocketOpen = "open"
+	rawSocketRead = "read"
+)
+
+// reportLatencyBuckets are the upper bounds (seconds) of the agent's
+// packet-to-report and gRPC latency histograms
+var reportLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// agentMetrics are the Prometheus metrics of the agent. The agent does not run
+// the controller-runtime manager: they live in a registry of their own, with
+// the node as a constant label.
+type agentMetrics struct {
+	registry        *prometheus.Registry
+	packetsReceived *prometheus.CounterVec
+	eventsReported  *prometheus.CounterVec
+	reportLatency   prometheus.Histogram
+	grpcDuration    *prometheus.HistogramVec
+	rawSocketErrors *prometheus.CounterVec
+	bufferDropped   *prometheus.CounterVec
+	bufferReplayed  prometheus.Counter
+	packetsFiltered prometheus.Counter
+	neighborReplies *prometheus.CounterVec
+	triggerWakes    *prometheus.CounterVec
+	vlanFrames      *prometheus.CounterVec
+	packetsDropped  prometheus.Counter
+}
+
+func newAgentMetrics(a *Agent) *agentMetrics {
+	m := &agentMetrics{
+		registry: prometheus.NewRegistry(),
+		packetsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wol_agent_packets_received_total",
+			Help: "Packets read by the listeners, by listener (udp, udp6, raw), destination UDP port (empty for Ethernet frames) and interface (empty for UDP)",
+		}, []string{"listener", "port", "iface"}),
+		eventsReported: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wol_agent_events_reported_total",
+			Help: "WOL events reported to the operator, by response status",
+		}, []string{"status"}),
+		reportLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "wol_agent_report_latency_seconds",
+			Help:    "Time from WOL packet receipt to the operator response",
+			Buckets: reportLatencyBuckets,
+		}),
+		grpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wol_agent_grpc_request_duration_seconds",
+			Help:    "Duration of the event reports to the operator, by transport (stream, unary), failures included",
+			Buckets: reportLatencyBuckets,
+		}, []string{"transport"}),
+		rawSocketErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wol_agent_raw_socket_errors_total",
+			Help: "Errors of the raw Ethernet sockets, by interface and operation (open, read)",
+		}, []string{"iface", "op"}),
+		bufferDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wol_agent_event_buffer_dropped_total",
+			Help: "Buffered events given up on, by reason (overflow, expired, rejected, shutdown)",
+		}, []string{"reason"}),
+		bufferReplayed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "wol_agent_event_buffer_replayed_total",
+			Help: "Buffered events reported once the operator was reachable again",
+		}),
+		packetsFiltered: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "wol_agent_packets_filtered_total",
+			Help: "Magic packets dropped without a report because their MAC is not in the allowlist pushed by the operator",
+		}),
+		neighborReplies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wol_agent_neighbor_replies_total",
+			Help: "ARP replies and IPv6 neighbor advertisements sent for stopped VMs, by interface, protocol (arp, ndp) and result (sent, failed)",
+		}, []string{"iface", "protocol", "result"}),
+		triggerWakes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wol_agent_trigger_wakes_total",
+			Help: "Wakes requested by the wake triggers, by interface and trigger (icmp, tcp, dns)",
+		}, []string{"iface", "trigger"}),
+		vlanFrames: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wol_agent_vlan_frames_total",
+			Help: "Frames captured by the raw listeners with VLAN filtering, by interface, VLAN (0 = untagged, other for the dropped frames) and result (accepted, dropped)",
+		}, []string{"iface", "vlan", "result"}),
+		packetsDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "wol_agent_packets_dropped_total",
+			Help: "Packets dropped without a report because the report queue was full",
+		}),
+	}
+
+	registerer := prometheus.WrapRegistererWith(prometheus.Labels{"node": a.nodeName}, m.registry)
+	registerer.MustRegister(
+		m.packetsReceived,
+		m.eventsReported,
+		m.reportLatency,
+		m.grpcDuration,
+		m.rawSocketErrors,
+		m.bufferDropped,
+		m.bufferReplayed,
+		m.packetsFiltered,
+		m.neighborReplies,
+		m.triggerWakes,
+		m.vlanFrames,
+		m.packetsDropped,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "wol_agent_report_failures_total",
+			Help: "WOL events the agent failed to report to the operator",
+		}, func() float64 { return float64(a.reportFailures.Load()) }),
+		&agentCollector{agent: a},
+	)
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// handler serves the metrics in the Prometheus exposition format
+func (m *agentMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// packetReceived counts a packet read by a listener
+func (m *agentMetrics) packetReceived(listener string, port int, iface string) {
+	portLabel := ""
+	if port > 0 {
+		portLabel = strconv.Itoa(port)
+	}
+	m.packetsReceived.WithLabelValues(listener, portLabel, iface).Inc()
+}
+
+// neighborReplied counts a reply of the neighbor responder
+func (m *agentMetrics) neighborReplied(iface, protocol string, err error) {
+	result := "sent"
+	if err != nil {
+		result = "failed"
+	}
+	m.neighborReplies.WithLabelValues(iface, protocol, result).Inc()
+}
+
+// interfaceVLANFrames returns the per-VLAN frame counters of a raw listener
+func (m *agentMetrics) interfaceVLANFrames(iface string) *prometheus.CounterVec {
+	return m.vlanFrames.MustCurryWith(prometheus.Labels{"iface": iface})
+}
+
+// observeReport records the duration of an event report with the given transport
+func (m *agentMetrics) observeReport(transport string, start time.Time) {
+	m.grpcDuration.WithLabelValues(transport).Observe(time.Since(start).Seconds())
+}
+
+// agentCollector exports the state of the agent (caches, watchdog, raw
+// listeners, ARP targets) when the metrics are scraped
+type agentCollector struct {
+	agent *Agent
+}
+
+var (
+	agentDedupeCacheDesc = prometheus.NewDesc("wol_agent_dedupe_cache_size",
+		"Number of entries in deduplication cache", nil, nil)
+	agentInfoDesc = prometheus.NewDesc("wol_agent_info",
+		"Agent information", []string{"port", "operator", "version"}, nil)
+	agentComponentRestartsDesc = prometheus.NewDesc("wol_agent_component_restarts_total",
+		"Number of agent component restarts done by the watchdog", []string{"component"}, nil)
+	agentComponentFailuresDesc = prometheus.NewDesc("wol_agent_component_failures",
+		"Consecutive failed watchdog checks per agent component", []string{"component"}, nil)
+	agentRawCaptureModeDesc = prometheus.NewDesc("wol_agent_raw_capture_mode",
+		"Capture mode active on each raw listener interface", []string{"iface", "mode"}, nil)
+	agentRawPromiscuousFailedDesc = prometheus.NewDesc("wol_agent_raw_promiscuous_failed",
+		"Raw listeners where promiscuous mode was requested but could not be enabled", []string{"iface"}, nil)
+	agentARPTargetsDesc = prometheus.NewDesc("wol_agent_arp_targets",
+		"Number of stopped VM IPs watched for ARP requests", nil, nil)
+	agentARPWakesDesc = prometheus.NewDesc("wol_agent_arp_wakes_total",
+		"Number of wakes requested after confirmed ARP requests", nil, nil)
+	agentEventBufferDesc = prometheus.NewDesc("wol_agent_event_buffer_size",
+		"Number of events buffered while the operator is unreachable", nil, nil)
+	agentMACAllowlistDesc = prometheus.NewDesc("wol_agent_mac_allowlist_size",
+		"Number of MACs in the allowlist pushed by the operator (absent when not filtering)", nil, nil)
+	agentReportQueueDesc = prometheus.NewDesc("wol_agent_report_queue_length",
+		"Number of packets waiting for a report worker", nil, nil)
+)
+
+// Describe implements prometheus.Collector
+func (c *agentCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		agentDedupeCacheDesc, agentInfoDesc, agentComponentRestartsDesc, agentComponentFailuresDesc,
+		agentRawCaptureModeDesc, agentRawPromiscuousFailedDesc, agentARPTargetsDesc, agentARPWakesDesc,
+		agentEventBufferDesc, agentMACAllowlistDesc, agentReportQueueDesc,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *agentCollector) Collect(ch chan<- prometheus.Metric) {
+	a := c.agent
+
+	a.dedupeLock.RLock()
+	cacheSize := len(a.dedupeCache)
+	a.dedupeLock.RUnlock()
+	ch <- prometheus.MustNewConstMetric(agentDedupeCacheDesc, prometheus.GaugeValue, float64(cacheSize))
+	ch <- prometheus.MustNewConstMetric(agentInfoDesc, prometheus.GaugeValue, 1,
+		strconv.Itoa(a.udpPort()), a.operatorAddr, Version)
+	ch <- prometheus.MustNewConstMetric(agentReportQueueDesc, prometheus.GaugeValue, float64(len(a.reports.tasks)))
+	if a.buffer != nil {
+		ch <- prometheus.MustNewConstMetric(agentEventBufferDesc, prometheus.GaugeValue, float64(a.buffer.len()))
+	}
+	if allowed := a.allowedMACs.Load(); allowed != nil {
+		ch <- prometheus.MustNewConstMetric(agentMACAllowlistDesc, prometheus.GaugeValue, float64(len(*allowed)))
+	}
+
+	names, restarts, failures := a.watchdog.snapshot()
+	for i, name := range names {
+		ch <- prometheus.MustNewConstMetric(agentComponentRestartsDesc, prometheus.CounterValue, float64(restarts[i]), name)
+		ch <- prometheus.MustNewConstMetric(agentComponentFailuresDesc, prometheus.GaugeValue, float64(failures[i]), name)
+	}
+
+	a.rawMu.Lock()
+	for _, l := range a.rawListeners {
+		ch <- prometheus.MustNewConstMetric(agentRawCaptureModeDesc, prometheus.GaugeValue, 1, l.interfaceName, l.CaptureMode())
+		if a.promiscuous {
+			failed := 0.0
+			if l.PromiscuousError() != nil {
+				failed = 1
+			}
+			ch <- prometheus.MustNewConstMetric(agentRawPromiscuousFailedDesc, prometheus.GaugeValue, failed, l.interfaceName)
+		}
+	}
+	a.rawMu.Unlock()
+
+	if a.arpWake || a.wakeTriggers.Enabled() {
+		a.arpTargetsMu.RLock()
+		targets := len(a.arpTargets)
+		a.arpTargetsMu.RUnlock()
+		ch <- prometheus.MustNewConstMetric(agentARPTargetsDesc, prometheus.GaugeValue, float64(targets))
+		ch <- prometheus.MustNewConstMetric(agentARPWakesDesc, prometheus.CounterValue, float64(a.arpWakes.Load()))
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestAgentMetrics(t *testing.T) {
+	agent := NewAgent(9, "node-a", "operator:9090", logr.Discard())
+	agent.SetARPWake(true)
+	agent.rawListeners = []*RawListener{NewRawListener("eth0", nil, logr.Discard())}
+
+	agent.metrics.packetReceived(ListenerProtocolUDP, 9, "")
+	agent.metrics.packetReceived(ListenerProtocolRaw, 0, "eth0")
+	agent.metrics.packetReceived(ListenerProtocolRaw, 0, "eth0")
+	agent.metrics.eventsReported.WithLabelValues(wolv1.ResponseStatus_VM_START_INITIATED.String()).Inc()
+	agent.metrics.observeReport("unary", time.Now().Add(-20*time.Millisecond))
+	agent.metrics.rawSocketErrors.WithLabelValues("eth1", rawSocketOpen).Inc()
+	agent.reportFailures.Add(2)
+	agent.arpWakes.Add(1)
+
+	if got := testutil.ToFloat64(agent.metrics.packetsReceived.WithLabelValues(ListenerProtocolRaw, "", "eth0")); got != 2 {
+		t.Errorf("Expected 2 raw packets on eth0, got %v", got)
+	}
+
+	recorder := httptest.NewRecorder()
+	agent.metrics.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, want := range []string{
+		`wol_agent_packets_received_total{iface="",listener="udp",node="node-a",port="9"} 1`,
+		`wol_agent_packets_received_total{iface="eth0",listener="raw",node="node-a",port=""} 2`,
+		`wol_agent_events_reported_total{node="node-a",status="VM_START_INITIATED"} 1`,
+		`wol_agent_report_failures_total{node="node-a"} 2`,
+		`wol_agent_grpc_request_duration_seconds_count{node="node-a",transport="unary"} 1`,
+		`wol_agent_raw_socket_errors_total{iface="eth1",node="node-a",op="open"} 1`,
+		`wol_agent_info{node="node-a",operator="operator:9090",port="9",version="` + Version + `"} 1`,
+		`wol_agent_dedupe_cache_size{node="node-a"} 0`,
+		`wol_agent_raw_capture_mode{iface="eth0",mode="",node="node-a"} 1`,
+		`wol_agent_raw_promiscuous_failed{iface="eth0",node="node-a"} 0`,
+		`wol_agent_arp_wakes_total{node="node-a"} 1`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in the metrics:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// DefaultConfigSyncInterval is how often the agents fetch the settings of
+// their WolConfig applied without a restart
+const DefaultConfigSyncInterval = 30 * time.Second
+
+// ---------------------------------------------------------------------------
+// Manager side: serving the live settings
+// ---------------------------------------------------------------------------
+
+// AgentLiveConfig returns the settings of a WolConfig the agents apply without
+// a restart: the UDP ports, the agent dedupe window and the interface selector
+func AgentLiveConfig(spec *wolv1beta1.WolConfigSpec) *wolv1.AgentConfigResponse {
+	resp := &wolv1.AgentConfigResponse{Found: true, Ports: []int32{DefaultWOLPort}}
+	if len(spec.WOLPorts) > 0 {
+		resp.Ports = make([]int32, len(spec.WOLPorts))
+		for i, port := range spec.WOLPorts {
+			resp.Ports[i] = int32(port)
+		}
+	}
+	if spec.Dedupe != nil && spec.Dedupe.AgentWindowSeconds != nil {
+		resp.DedupeWindowSeconds = *spec.Dedupe.AgentWindowSeconds
+	}
+	if selector := spec.Agent.InterfaceSelector; selector != nil {
+		resp.Interfaces = &wolv1.InterfaceRules{Include: selector.Include, Exclude: selector.Exclude}
+	}
+	return resp
+}
+
+// GetAgentConfig implementa il metodo gRPC con cui gli agent leggono le
+// impostazioni della propria WolConfig applicate a caldo
+func (a *Aggregator) GetAgentConfig(_ context.Context, req *wolv1.AgentConfigRequest) (*wolv1.AgentConfigResponse, error) {
+	config := a.mapper.wolConfig(req.WolConfig)
+	if config == nil {
+		return &wolv1.AgentConfigResponse{}, nil
+	}
+	return AgentLiveConfig(&config.Spec), nil
+}
+
+// ---------------------------------------------------------------------------
+// Agent side: applying the live settings
+// ---------------------------------------------------------------------------
+
+// SetConfigSync sets how often the agent fetches the live settings of its
+// WolConfig (0 disables it). With keepInterfaces the interface rules set by
+// SetInterfaceRules (e.g. from the agent config file) are not replaced.
+// Must be called before Start.
+func (a *Agent) SetConfigSync(interval time.Duration, keepInterfaces bool) {
+	a.configSync = interval
+	a.keepInterfaces = keepInterfaces
+}
+
+// syncConfig periodically fetches and applies the live settings of the WolConfig
+func (a *Agent) syncConfig(ctx context.Context) {
+	defer a.wg.Done()
+	ticker := time.NewTicker(a.configSync)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		a.fetchConfig(ctx)
+	}
+}
+
+// fetchConfig fetches the live settings of the WolConfig and applies them
+func (a *Agent) fetchConfig(ctx context.Context) {
+	reqCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := a.grpcClient.GetAgentConfig(reqCtx, &wolv1.AgentConfigRequest{
+		NodeName:  a.nodeName,
+		WolConfig: a.wolConfigName,
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			a.log.V(1).Info("Failed to fetch the agent config from operator", "error", err.Error())
+		}
+		return
+	}
+	a.applyConfig(ctx, resp)
+}
+
+// applyConfig applies the live settings of the WolConfig. Before Start opens
+// the listeners it only records them.
+func (a *Agent) applyConfig(ctx context.Context, resp *wolv1.AgentConfigResponse) {
+	if !resp.Found {
+		return
+	}
+	if len(resp.Ports) > 0 {
+		a.setUDPPort(ctx, int(resp.Ports[0]))
+	}
+
+	switch {
+	case resp.DedupeWindowSeconds > 0:
+		window := time.Duration(resp.DedupeWindowSeconds) * time.Second
+		a.syncedDedupe = true
+		if a.dedupeWindow() != window {
+			a.log.Info("Dedupe window changed", "window", window.String())
+			a.SetDedupeWindow(window)
+		}
+	case a.syncedDedupe:
+		// Tolta dalla WolConfig: torna al default
+		a.syncedDedupe = false
+		a.log.Info("Dedupe window changed", "window", DefaultDedupeWindow.String())
+		a.SetDedupeWindow(DefaultDedupeWindow)
+	}
+
+	if !a.keepInterfaces {
+		var rules InterfaceRules
+		if resp.Interfaces != nil {
+			rules = InterfaceRules{Include: resp.Interfaces.Include, Exclude: resp.Interfaces.Exclude}
+		}
+		if a.setInterfaceRules(rules) {
+			a.log.Info("Interface rules changed", "include", rules.Include, "exclude", rules.Exclude)
+			a.refreshCandidateListeners(ctx)
+		}
+	}
+}
+
+// setUDPPort moves the UDP listeners to another port. Listeners that are not
+// open (not started yet, or failed) use it when opened, e.g. by the watchdog.
+func (a *Agent) setUDPPort(ctx context.Context, port int) {
+	old := a.udpPort()
+	if port <= 0 || port > 65535 || port == old {
+		return
+	}
+	a.port.Store(int32(port))
+	a.setBindError(&wolv1.ListenerBinding{Protocol: ListenerProtocolUDP, Port: uint32(old)}, nil)
+	a.setBindError(&wolv1.ListenerBinding{Protocol: ListenerProtocolUDP6, Port: uint32(old)}, nil)
+
+	a.udpMu.Lock()
+	open4, open6 := a.conn != nil, a.conn6 != nil
+	a.udpMu.Unlock()
+	if !open4 && !open6 {
+		return
+	}
+	a.log.Info("WOL port changed, rebinding the UDP listeners", "old", old, "port", port)
+	if open4 {
+		if err := a.restartUDP(ctx); err != nil {
+			a.log.Error(err, "Failed to rebind the UDP listener, the watchdog will retry")
+		}
+	}
+	if open6 {
+		if err := a.restartUDP6(ctx); err != nil {
+			a.log.Error(err, "Failed to rebind the IPv6 UDP listener, the watchdog will retry")
+		}
+	}
+}
+
+// agentInterfaceRules returns the interface rules of the agent, without the node ones
+func (a *Agent) agentInterfaceRules() InterfaceRules {
+	a.rawMu.Lock()
+	defer a.rawMu.Unlock()
+	return a.interfaceRules
+}
+
+// setInterfaceRules replaces the interface rules of the agent and returns true if they changed
+func (a *Agent) setInterfaceRules(rules InterfaceRules) bool {
+	a.rawMu.Lock()
+	defer a.rawMu.Unlock()
+	if slices.Equal(rules.Include, a.interfaceRules.Include) && slices.Equal(rules.Exclude, a.interfaceRules.Exclude) {
+		return false
+	}
+	a.interfaceRules = rules
+	return true
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestAggregator_GetAgentConfig(t *testing.T) {
+	window := int32(5)
+	config := &wolv1beta1.WolConfig{}
+	config.Name = "live"
+	config.Spec.WOLPorts = []int{7, 9}
+	config.Spec.Dedupe = &wolv1beta1.DedupeSpec{AgentWindowSeconds: &window}
+	config.Spec.Agent.InterfaceSelector = &wolv1beta1.InterfaceSelector{Include: []string{"bond*"}}
+
+	mapper := NewMACMapper(nil, logr.Discard())
+	mapper.UpdateConfig(config)
+	agg := NewAggregator(mapper, NewVMStarter(nil, logr.Discard()), logr.Discard())
+
+	resp, err := agg.GetAgentConfig(context.Background(), &wolv1.AgentConfigRequest{NodeName: "node-a", WolConfig: "live"})
+	if err != nil {
+		t.Fatalf("GetAgentConfig: %v", err)
+	}
+	if !resp.Found || len(resp.Ports) != 2 || resp.Ports[0] != 7 || resp.DedupeWindowSeconds != 5 ||
+		resp.Interfaces.GetInclude()[0] != "bond*" {
+		t.Errorf("Unexpected agent config %v", resp)
+	}
+
+	resp, _ = agg.GetAgentConfig(context.Background(), &wolv1.AgentConfigRequest{NodeName: "node-a", WolConfig: "missing"})
+	if resp.Found {
+		t.Errorf("Expected no agent config for an unknown WolConfig, got %v", resp)
+	}
+
+	if ports := AgentLiveConfig(&wolv1beta1.WolConfigSpec{}).Ports; len(ports) != 1 || ports[0] != DefaultWOLPort {
+		t.Errorf("Expected the default WOL port, got %v", ports)
+	}
+}
+
+func TestAgent_ApplyConfig(t *testing.T) {
+	ctx := context.Background()
+	agent := NewAgent(9, "node-a", "operator:9090", logr.Discard())
+
+	// Listener non ancora aperti: la porta è solo registrata
+	agent.applyConfig(ctx, &wolv1.AgentConfigResponse{
+		Found:               true,
+		Ports:               []int32{7},
+		DedupeWindowSeconds: 5,
+		Interfaces:          &wolv1.InterfaceRules{Include: []string{"bond*"}},
+	})
+	if agent.udpPort() != 7 {
+		t.Errorf("Expected port 7, got %d", agent.udpPort())
+	}
+	if agent.dedupeWindow() != 5*time.Second {
+		t.Errorf("Expected a 5s dedupe window, got %s", agent.dedupeWindow())
+	}
+	if rules := agent.agentInterfaceRules(); len(rules.Include) != 1 || rules.Include[0] != "bond*" {
+		t.Errorf("Unexpected interface rules %v", rules)
+	}
+
+	// Impostazioni tolte dalla WolConfig: tornano ai default
+	agent.applyConfig(ctx, &wolv1.AgentConfigResponse{Found: true, Ports: []int32{7}})
+	if agent.dedupeWindow() != DefaultDedupeWindow {
+		t.Errorf("Expected the default dedupe window, got %s", agent.dedupeWindow())
+	}
+	if rules := agent.agentInterfaceRules(); !rules.IsZero() {
+		t.Errorf("Expected no interface rules, got %v", rules)
+	}
+
+	// WolConfig sconosciuta: nessuna modifica
+	agent.applyConfig(ctx, &wolv1.AgentConfigResponse{})
+	if agent.udpPort() != 7 {
+		t.Errorf("Expected port 7 to be kept, got %d", agent.udpPort())
+	}
+}
+
+func TestAgent_ApplyConfigKeepInterfaces(t *testing.T) {
+	agent := NewAgent(9, "node-a", "operator:9090", logr.Discard())
+	agent.SetInterfaceRules(InterfaceRules{Include: []string{"eth1"}})
+	agent.SetConfigSync(DefaultConfigSyncInterval, true)
+
+	agent.applyConfig(context.Background(), &wolv1.AgentConfigResponse{
+		Found:      true,
+		Interfaces: &wolv1.InterfaceRules{Include: []string{"bond*"}},
+	})
+	if rules := agent.agentInterfaceRules(); len(rules.Include) != 1 || rules.Include[0] != "eth1" {
+		t.Errorf("Expected the config file rules to be kept, got %v", rules)
+	}
+}
+
+func TestAgent_SetUDPPortRebinds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	agent := NewAgent(freeUDPPort(t), "node-a", "operator:9090", logr.Discard())
+	conn, err := agent.openUDP()
+	if err != nil {
+		t.Fatalf("Failed to open UDP socket: %v", err)
+	}
+	agent.conn = conn
+	agent.wg.Add(1)
+	go agent.listen(ctx, conn)
+
+	port := freeUDPPort(t)
+	agent.setUDPPort(ctx, port)
+
+	agent.udpMu.Lock()
+	rebound := agent.conn
+	agent.udpMu.Unlock()
+	if rebound == nil || rebound == conn {
+		t.Fatal("Expected a new UDP socket")
+	}
+	if got := rebound.LocalAddr().(*net.UDPAddr).Port; got != port {
+		t.Errorf("Expected the UDP socket on port %d, got %d", port, got)
+	}
+
+	cancel()
+	agent.Stop()
+	agent.wg.Wait()
+}
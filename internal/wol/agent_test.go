@@ -0,0 +1,207 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAgent_DrainWaitsForInflightReports(t *testing.T) {
+	agent := NewAgent(0, "test-node", "", logr.Discard())
+	agent.SetDrainTimeout(5 * time.Second)
+
+	release := make(chan struct{})
+	var completed atomic.Bool
+	agent.report(func(ctx context.Context) {
+		<-release
+		completed.Store(ctx.Err() == nil)
+	})
+
+	drained := make(chan struct{})
+	go func() {
+		agent.drain()
+		close(drained)
+	}()
+
+	// Una volta iniziato il drain i nuovi report vengono scartati
+	time.Sleep(50 * time.Millisecond)
+	var late atomic.Bool
+	agent.report(func(context.Context) { late.Store(true) })
+
+	select {
+	case <-drained:
+		t.Fatal("Drain returned while a report was in flight")
+	default:
+	}
+
+	close(release)
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("Drain did not return after the report completed")
+	}
+	if !completed.Load() {
+		t.Error("Expected the in-flight report to complete with a live context")
+	}
+	if late.Load() {
+		t.Error("Expected reports started while draining to be dropped")
+	}
+}
+
+func TestAgent_DrainTimeoutCancelsReports(t *testing.T) {
+	agent := NewAgent(0, "test-node", "", logr.Discard())
+	agent.SetDrainTimeout(50 * time.Millisecond)
+
+	agent.report(func(ctx context.Context) {
+		<-ctx.Done()
+	})
+
+	start := time.Now()
+	agent.drain()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected drain to give up after the timeout, took %s", elapsed)
+	}
+}
+
+func TestAgent_ReportQueueDropsWhenFull(t *testing.T) {
+	agent := NewAgent(0, "test-node", "", logr.Discard())
+	agent.SetReportQueue(1, 1)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var completed atomic.Int32
+	agent.report(func(context.Context) {
+		close(started)
+		<-release
+		completed.Add(1)
+	})
+	<-started
+
+	// Il worker è occupato: il secondo report resta in coda, il terzo è scartato
+	agent.report(func(context.Context) { completed.Add(1) })
+	agent.report(func(context.Context) { completed.Add(1) })
+	if got := testutil.ToFloat64(agent.metrics.packetsDropped); got != 1 {
+		t.Errorf("Expected 1 dropped packet, got %v", got)
+	}
+	if got := agent.inflightCount.Load(); got != 2 {
+		t.Errorf("Expected 2 in-flight reports, got %d", got)
+	}
+
+	close(release)
+	agent.drain()
+	if got := completed.Load(); got != 2 {
+		t.Errorf("Expected the queued report to run before the drain returned, got %d reports", got)
+	}
+}
+
+func TestAgent_StopUnhintedListeners(t *testing.T) {
+	agent := NewAgent(0, "test-node", "", logr.Discard())
+	for _, name := range []string{"eth0", "br1", "br2"} {
+		agent.rawListeners = append(agent.rawListeners,
+			NewRawListenerWithOptions(name, nil, logr.Discard(), RawListenerOptions{}))
+	}
+	agent.hintedIfaces = map[string]bool{"br1": true, "br2": true}
+
+	// br2 is still hinted, br1 is gone, eth0 is a candidate and never stopped
+	agent.stopUnhintedListeners(map[string]bool{"br2": true})
+
+	var names []string
+	for _, l := range agent.rawListeners {
+		names = append(names, l.interfaceName)
+	}
+	if len(names) != 2 || names[0] != "eth0" || names[1] != "br2" {
+		t.Errorf("Expected listeners [eth0 br2], got %v", names)
+	}
+	if agent.hintedIfaces["br1"] {
+		t.Error("Expected br1 to be forgotten")
+	}
+}
+
+func TestAgent_Tuning(t *testing.T) {
+	agent := NewAgent(0, "test-node", "", logr.Discard())
+
+	agent.SetReceiveTimeout(2500 * time.Millisecond)
+	if agent.recvTimeout != 2*time.Second {
+		t.Errorf("Expected the receive timeout to be truncated to 2s, got %s", agent.recvTimeout)
+	}
+	agent.SetReceiveTimeout(0)
+	if agent.recvTimeout != time.Second {
+		t.Errorf("Expected the receive timeout to be at least 1s, got %s", agent.recvTimeout)
+	}
+
+	agent.SetUDPReadBuffer(0)
+	agent.SetDedupeCleanupInterval(0)
+	if agent.udpReadBuffer != DefaultUDPReadBuffer || agent.dedupeCleanup != DefaultDedupeCleanupInterval {
+		t.Errorf("Expected unset values to keep the defaults, got %d and %s", agent.udpReadBuffer, agent.dedupeCleanup)
+	}
+}
+
+func TestParseListenModes(t *testing.T) {
+	for value, want := range map[string][2]bool{
+		"Raw":     {true, false},
+		"UDP":     {false, true},
+		"Both":    {true, true},
+		"raw,udp": {true, true},
+	} {
+		raw, udp, err := ParseListenModes(value)
+		if err != nil || raw != want[0] || udp != want[1] {
+			t.Errorf("ParseListenModes(%q) = %v, %v, %v", value, raw, udp, err)
+		}
+	}
+	for _, invalid := range []string{"", "Sniff", ","} {
+		if _, _, err := ParseListenModes(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestParseCaptureBackend(t *testing.T) {
+	for value, want := range map[string]string{
+		"":         CaptureBackendAFPacket,
+		"AFPacket": CaptureBackendAFPacket,
+		"EBPF":     CaptureBackendEBPF,
+		"ebpf":     CaptureBackendEBPF,
+	} {
+		if got, err := ParseCaptureBackend(value); err != nil || got != want {
+			t.Errorf("ParseCaptureBackend(%q) = %q, %v", value, got, err)
+		}
+	}
+	if _, err := ParseCaptureBackend("pcap"); err == nil {
+		t.Error("Expected an invalid backend to be rejected")
+	}
+}
+
+func TestAgent_WatchdogIgnoresDisabledUDP(t *testing.T) {
+	agent := NewAgent(0, "test-node", "", logr.Discard())
+	agent.SetEnableUDP(false)
+
+	agent.checkComponents(context.Background(), time.Now())
+	if names, _, _ := agent.watchdog.snapshot(); len(names) != 0 {
+		t.Errorf("Expected no UDP listener to be tracked, got %v", names)
+	}
+	agent.udpMu.Lock()
+	defer agent.udpMu.Unlock()
+	if agent.conn != nil {
+		t.Error("Expected the watchdog not to open a UDP socket")
+	}
+}
@@ -18,60 +18,185 @@ package wol
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
 	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
 )
 
+// DefaultAggregatorDedupeWindow is the default window of the global dedupe
+// cache, overridden per WolConfig by spec.dedupe.aggregatorWindowSeconds
+const DefaultAggregatorDedupeWindow = 10 * time.Second
+
+// streamEventConcurrency is the number of events of an agent stream processed
+// at the same time: past it the stream is not read until one completes
+const streamEventConcurrency = 16
+
 // Aggregator implementa il gRPC server per ricevere eventi WOL dagli agent
 type Aggregator struct {
 	wolv1.UnimplementedWOLServiceServer
 
 	mapper         *MACMapper
-	vmStarter      *VMStarter
+	vmStarter      Starter
 	log            logr.Logger
-	dedupeMap      map[string]*dedupeEntry
+	dedupeMap      map[string]*dedupeEntry // chiave: dedupeKey(mac, porta) o wakeDedupeKey
+	dedupeClaims   map[string]bool         // eventi in elaborazione (vedi claimEvent)
 	dedupeLock     sync.RWMutex
 	dedupeDuration time.Duration
+	demand         *WakeDemand          // opzionale, esposta per KEDA
+	nodes          client.Reader        // opzionale, valida i nomi dei nodi usati come label
+	policies       *PolicyEvaluator     // opzionale, applica le WolPolicy
+	rateLimiter    *eventRateLimiter    // token bucket per MAC e per nodo (spec.rateLimit)
+	recorder       record.EventRecorder // opzionale, Event sulle VM e sulle WolConfig
+	auditor        *Auditor             // opzionale, record di audit delle decisioni (vedi audit.go)
+
+	// Verifica del nodo dichiarato dagli agent (vedi node_verification.go)
+	nodeVerification string
+
+	// Saturazione delle risorse interne (vedi saturation.go)
+	thresholds       SaturationThresholds
+	startsInFlight   atomic.Int64
+	eventsInFlight   atomic.Int64
+	saturationNotify func()
+
+	// Ultimo report dei listener di ogni agent (vedi listeners.go)
+	listenersMu     sync.Mutex
+	listeners       map[string]NodeListeners // chiave: wolconfig/nodo
+	listenersNotify func()
+
+	// Ultimo heartbeat di ogni agent (vedi heartbeat.go)
+	agentsMu     sync.Mutex
+	agents       map[string]NodeAgent // chiave: wolconfig/nodo
+	agentsNotify func()
+	serving      atomic.Bool // il server gRPC degli agent è in ascolto
+
+	// Stream dei forward aperti dagli agent (vedi forward.go)
+	forwardsMu     sync.Mutex
+	forwardStreams map[string]chan *wolv1.ForwardRequest // chiave: wolconfig/nodo
+
+	chaos ChaosOptions // fault injection (solo per i test di resilienza)
+
+	// Start falliti da ritentare (vedi retry.go), nil se disabilitati
+	retries *wakeRetries
+
+	// Limiti globali degli start (vedi startlimit.go), nil se disabilitati
+	startLimit *startLimiter
+
+	// VM avviate per un wake in attesa di Running (vedi wakelatency.go)
+	wakes wakeTracker
+
+	// Deduplica condivisa tra le repliche del manager (vedi shared_dedupe.go)
+	shared SharedDedupe
+
+	// Versioni degli agent troppo distanti già segnalate (chiave: nodo/versione)
+	skewedVersions sync.Map
 }
 
 type dedupeEntry struct {
 	lastSeen     time.Time
+	window       time.Duration // finestra della WolConfig che ha gestito l'evento
+	password     string        // password SecureOn dell'evento registrato
 	count        int
 	nodes        []string
 	lastResponse *wolv1.WOLEventResponse
 }
 
 // NewAggregator creates a new aggregator
-func NewAggregator(mapper *MACMapper, vmStarter *VMStarter, log logr.Logger) *Aggregator {
+func NewAggregator(mapper *MACMapper, vmStarter Starter, log logr.Logger) *Aggregator {
 	return &Aggregator{
 		mapper:         mapper,
 		vmStarter:      vmStarter,
 		log:            log,
 		dedupeMap:      make(map[string]*dedupeEntry),
-		dedupeDuration: 10 * time.Second, // Deduplica globale per 10 secondi
+		dedupeClaims:   make(map[string]bool),
+		dedupeDuration: DefaultAggregatorDedupeWindow,
+		rateLimiter:    newEventRateLimiter(),
+		thresholds: SaturationThresholds{
+			DedupeEntries:  DefaultDedupeSaturation,
+			PendingStarts:  DefaultStartSaturation,
+			InFlightEvents: DefaultEventSaturation,
+		},
+	}
+}
+
+// SetWakeDemand enables tracking of the wake demand served to external autoscalers
+func (a *Aggregator) SetWakeDemand(demand *WakeDemand) {
+	a.demand = demand
+}
+
+// SetChaos enables fault injection on the VM starts (testing only)
+func (a *Aggregator) SetChaos(opts ChaosOptions) {
+	a.chaos = opts
+}
+
+// recordDemand registra una richiesta di wake per la VM, se il tracking è attivo
+func (a *Aggregator) recordDemand(vmInfo VMInfo) {
+	if a.demand != nil {
+		a.demand.Record(vmInfo, time.Now())
 	}
 }
 
 // ReportWOLEvent implementa il metodo gRPC unary
 func (a *Aggregator) ReportWOLEvent(ctx context.Context, event *wolv1.WOLEvent) (*wolv1.WOLEventResponse, error) {
+	resp, err := a.handleWOLEvent(ctx, event)
+	if resp != nil {
+		GRPCEventsTotal.WithLabelValues(resp.Status.String()).Inc()
+	}
+	return resp, err
+}
+
+// handleWOLEvent processa un evento WOL riportato da un agent o da un relay
+func (a *Aggregator) handleWOLEvent(ctx context.Context, event *wolv1.WOLEvent) (*wolv1.WOLEventResponse, error) {
 	startTime := time.Now()
+	a.eventsInFlight.Add(1)
+	defer a.eventsInFlight.Add(-1)
+
+	// Gli eventi dei relay sono attribuiti al relay autenticato, non al nome dichiarato
+	relay, fromRelay := relayFromContext(ctx)
+	if fromRelay {
+		event.NodeName = relay.Name
+	}
 
 	a.log.Info("Received WOL event via gRPC",
 		"mac", event.MacAddress,
 		"node", event.NodeName,
+		"relay", fromRelay,
 		"source", event.SourceIp,
 		"port", event.SourcePort,
+		"destinationPort", event.DestinationPort,
+		"secureOn", event.SecureOnPassword != "",
 		"packetSize", event.PacketSize)
 
-	WOLPacketsTotal.Inc()
+	a.checkEventVersion(event)
+	node := a.nodeLabel(ctx, event.NodeName)
+	port, listener := a.packetLabels(event, fromRelay)
+	WOLPacketsTotal.WithLabelValues(node, port, listener).Inc()
+	observeEventLatency(event, node, startTime)
+
+	// Il nodo dichiarato dall'agent deve essere quello da cui arriva l'evento
+	if !fromRelay {
+		if resp := a.verifyEventNode(ctx, event, node, startTime); resp != nil {
+			return resp, nil
+		}
+	}
 
 	// Deduplica globale
-	isDuplicate, cachedResp := a.checkDuplicate(event)
+	key := eventDedupeKey(event)
+	isDuplicate, cachedResp, release := a.claimEvent(key, event.SecureOnPassword, event.NodeName)
+	defer release()
 	if isDuplicate && cachedResp != nil {
+		DedupeHitsTotal.WithLabelValues(node).Inc()
 		a.log.V(1).Info("Duplicate WOL event (global dedupe)",
 			"mac", event.MacAddress,
 			"node", event.NodeName,
@@ -84,8 +209,33 @@ func (a *Aggregator) ReportWOLEvent(ctx context.Context, event *wolv1.WOLEvent)
 
 	// Lookup VM per questo MAC
 	vmInfo, found := a.mapper.Lookup(event.MacAddress)
+	sleep := event.Sleep
+	if !found && !sleep {
+		// Sleep-on-LAN: magic packet con il MAC della VM invertito
+		vmInfo, found = a.mapper.LookupSleep(event.MacAddress)
+		sleep = found
+	}
+	if !found && !sleep {
+		// MAC di un membro di un VirtualMachinePool rimosso da uno scale-down
+		vmInfo, found = a.mapper.LookupPoolMember(event.MacAddress)
+	}
+	// Un relay può svegliare solo le VM della propria WolConfig
+	if found && fromRelay && vmInfo.ConfigName != relay.WolConfig {
+		a.log.Info("Relay reported a MAC of another WolConfig", "mac", event.MacAddress,
+			"relay", relay.Name, "relayWolconfig", relay.WolConfig, "wolconfig", vmInfo.ConfigName)
+		found = false
+	}
 	if !found {
+		// Le mapping Forward svegliano le macchine esterne al cluster
+		if target, ok := a.mapper.LookupForward(event.MacAddress); ok && (!fromRelay || target.ConfigName == relay.WolConfig) {
+			resp := a.forward(event, target, startTime)
+			a.recordEvent(key, event.SecureOnPassword, event.NodeName, target.ConfigName, resp)
+			a.auditEvent(ctx, event, VMInfo{ConfigName: target.ConfigName}, false, resp)
+			return resp, nil
+		}
+
 		a.log.Info("No VM found for MAC address", "mac", event.MacAddress)
+		LookupMissesTotal.WithLabelValues(node).Inc()
 
 		resp := &wolv1.WOLEventResponse{
 			Status:           wolv1.ResponseStatus_VM_NOT_FOUND,
@@ -93,76 +243,184 @@ func (a *Aggregator) ReportWOLEvent(ctx context.Context, event *wolv1.WOLEvent)
 			ProcessingTimeMs: time.Since(startTime).Milliseconds(),
 		}
 
-		a.recordEvent(event, resp)
+		a.recordEvent(key, event.SecureOnPassword, event.NodeName, "", resp)
+		return resp, nil
+	}
+
+	ConfigMatchesTotal.WithLabelValues(vmInfo.ConfigName, string(vmInfo.MappingType)).Inc()
+
+	// Verifica la password SecureOn secondo la policy della mapping
+	if resp := a.enforceSecureOn(event, vmInfo, startTime); resp != nil {
+		a.recordEvent(key, event.SecureOnPassword, event.NodeName, vmInfo.ConfigName, resp)
+		a.recordKubeEvents(ctx, event, vmInfo, resp)
+		a.auditEvent(ctx, event, vmInfo, sleep, resp)
+		return resp, nil
+	}
+
+	// Il rate limit della WolConfig protegge l'API di KubeVirt dai flood. La
+	// risposta non va in cache: il prossimo pacchetto trova il bucket ricaricato
+	if resp := a.enforceRateLimit(event, vmInfo, startTime); resp != nil {
+		a.recordKubeEvents(ctx, event, vmInfo, resp)
+		a.auditEvent(ctx, event, vmInfo, sleep, resp)
+		return resp, nil
+	}
+
+	// Con più repliche, solo quella che reclama l'evento agisce sulla VM
+	if resp := a.claimShared(ctx, key, event.SecureOnPassword, event.NodeName, vmInfo, startTime); resp != nil {
+		return resp, nil
+	}
+
+	// I pacchetti di sleep fermano la VM (le WolPolicy valgono solo per i wake)
+	if sleep {
+		resp := a.handleSleep(ctx, event, vmInfo, startTime)
+		a.recordEvent(key, event.SecureOnPassword, event.NodeName, vmInfo.ConfigName, resp)
+		a.recordKubeEvents(ctx, event, vmInfo, resp)
+		a.auditEvent(ctx, event, vmInfo, sleep, resp)
 		return resp, nil
 	}
 
+	// La WolPolicy del namespace della VM decide l'azione (o rifiuta il wake)
+	action, resp := a.enforcePolicy(ctx, vmInfo, event.NodeName, startTime)
+	if resp != nil {
+		a.recordEvent(key, event.SecureOnPassword, event.NodeName, vmInfo.ConfigName, resp)
+		a.recordKubeEvents(ctx, event, vmInfo, resp)
+		a.auditEvent(ctx, event, vmInfo, sleep, resp)
+		return resp, nil
+	}
+
+	// Una WolConfig in dry-run registra il wake senza avviare la VM
+	if resp := a.dryRunWake(ctx, vmInfo, string(action), event.MacAddress, "node "+event.NodeName, startTime); resp != nil {
+		a.recordEvent(key, event.SecureOnPassword, event.NodeName, vmInfo.ConfigName, resp)
+		a.recordKubeEvents(ctx, event, vmInfo, resp)
+		a.auditEvent(ctx, event, vmInfo, sleep, resp)
+		return resp, nil
+	}
+
+	a.recordDemand(vmInfo)
+	ctx = withWakeOrigin(ctx, wakeOrigin{Source: "wol/" + event.SourceIp, Node: event.NodeName,
+		ReceivedAt: wakeReceivedAt(event, startTime)})
+
 	a.log.Info("Starting VM for WOL request",
 		"mac", event.MacAddress,
 		"vm", vmInfo.Name,
 		"namespace", vmInfo.Namespace,
 		"node", event.NodeName,
-		"source", event.SourceIp)
+		"source", event.SourceIp,
+		"wolconfig", vmInfo.ConfigName,
+		"mappingType", vmInfo.MappingType,
+		"startAs", vmInfo.StartAs,
+		"action", action)
 
-	// Avvia VM
-	err := a.vmStarter.StartVM(ctx, vmInfo.Namespace, vmInfo.Name)
+	// Avvia VM (impersonando il ServiceAccount della WolConfig, se configurato)
+	status, vm, err := a.wakeVM(ctx, vmInfo, action)
 	if err != nil {
-		a.log.Error(err, "Failed to start VM",
-			"vm", vmInfo.Name,
-			"namespace", vmInfo.Namespace,
-			"mac", event.MacAddress)
-		ErrorsTotal.Inc()
+		if !errors.Is(err, errStartsThrottled) {
+			a.log.Error(err, "Failed to start VM",
+				"vm", vmInfo.Name,
+				"namespace", vmInfo.Namespace,
+				"mac", event.MacAddress,
+				"wolconfig", vmInfo.ConfigName,
+				"mappingType", vmInfo.MappingType)
+			ErrorsTotal.Inc()
+		}
 
+		status, message := a.startFailure(ctx, vmInfo, action, err)
 		resp := &wolv1.WOLEventResponse{
-			Status:  wolv1.ResponseStatus_ERROR,
-			Message: fmt.Sprintf("Failed to start VM: %v", err),
-			VmInfo: &wolv1.VMInfo{
-				Name:      vmInfo.Name,
-				Namespace: vmInfo.Namespace,
-			},
+			Status:           status,
+			Message:          message,
+			VmInfo:           vm,
 			ProcessingTimeMs: time.Since(startTime).Milliseconds(),
 		}
 
-		a.recordEvent(event, resp)
+		a.recordEvent(key, event.SecureOnPassword, event.NodeName, vmInfo.ConfigName, resp)
+		a.recordKubeEvents(ctx, event, vmInfo, resp)
+		a.auditEvent(ctx, event, vmInfo, sleep, resp)
 		return resp, nil
 	}
 
-	VMStartedTotal.Inc()
+	message := fmt.Sprintf("VM start initiated successfully from node %s (matched by WolConfig %s, %s mapping)",
+		event.NodeName, vmInfo.ConfigName, vmInfo.MappingType)
+	if status == wolv1.ResponseStatus_VM_ALREADY_RUNNING {
+		message = fmt.Sprintf("VM already running on node %s (matched by WolConfig %s, %s mapping)",
+			vm.NodeName, vmInfo.ConfigName, vmInfo.MappingType)
+	}
+	// Con la policy StartAll partono anche le altre VM che hanno lo stesso MAC
+	companions := a.mapper.LookupCompanions(event.MacAddress)
+	if fromRelay {
+		companions = slices.DeleteFunc(slices.Clone(companions), func(c VMInfo) bool {
+			return c.ConfigName != relay.WolConfig
+		})
+	}
+	if len(companions) > 0 {
+		started := a.startCompanions(ctx, event, companions, startTime)
+		message += fmt.Sprintf("; %d of %d other VMs claiming the MAC started (StartAll)", started, len(companions))
+	}
+	// Le dipendenze dichiarate dalla VM (wake-with) partono dopo, in ordine
+	deps := a.startDependencies(ctx, vmInfo, event, event.NodeName, startTime)
+	if len(deps) > 0 {
+		message += dependenciesMessage(deps)
+	}
 
-	resp := &wolv1.WOLEventResponse{
-		Status:  wolv1.ResponseStatus_VM_START_INITIATED,
-		Message: fmt.Sprintf("VM start initiated successfully from node %s", event.NodeName),
-		VmInfo: &wolv1.VMInfo{
-			Name:         vmInfo.Name,
-			Namespace:    vmInfo.Namespace,
-			CurrentState: "Starting",
-		},
+	resp = &wolv1.WOLEventResponse{
+		Status:           status,
+		Message:          message,
+		VmInfo:           vm,
 		ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+		Dependencies:     deps,
 	}
 
-	a.recordEvent(event, resp)
+	a.recordEvent(key, event.SecureOnPassword, event.NodeName, vmInfo.ConfigName, resp)
+	a.recordKubeEvents(ctx, event, vmInfo, resp)
+	a.auditEvent(ctx, event, vmInfo, sleep, resp)
 	return resp, nil
 }
 
-// ReportWOLEventStream implementa streaming bidirezionale (opzionale per future)
+// ReportWOLEventStream riceve gli eventi di un agent su uno stream di lunga
+// durata. Ogni evento è processato in parallelo (uno start lento non blocca
+// lo stream) e la risposta porta la sequence dell'evento
 func (a *Aggregator) ReportWOLEventStream(stream wolv1.WOLService_ReportWOLEventStreamServer) error {
 	a.log.Info("Client opened WOL event stream")
 
+	var sendMu sync.Mutex // Send non è sicuro tra goroutine
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// Al massimo streamEventConcurrency eventi per stream in parallelo: oltre,
+	// lo stream non viene letto e il flow control di gRPC rallenta l'agent
+	slots := make(chan struct{}, streamEventConcurrency)
+
 	for {
 		event, err := stream.Recv()
-		if err != nil {
-			a.log.V(1).Info("Stream closed", "error", err)
-			return err
+		if err == io.EOF {
+			return nil
 		}
-
-		resp, err := a.ReportWOLEvent(stream.Context(), event)
 		if err != nil {
+			a.log.V(1).Info("Stream closed", "error", err)
 			return err
 		}
 
-		if err := stream.Send(resp); err != nil {
-			return err
+		select {
+		case slots <- struct{}{}:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
 		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			resp, err := a.ReportWOLEvent(stream.Context(), event)
+			if err != nil {
+				return
+			}
+			// La risposta può essere quella in cache della deduplica: non va modificata
+			resp = proto.Clone(resp).(*wolv1.WOLEventResponse)
+			resp.Sequence = event.Sequence
+
+			sendMu.Lock()
+			defer sendMu.Unlock()
+			if err := stream.Send(resp); err != nil {
+				a.log.V(1).Info("Failed to send stream response", "mac", event.MacAddress, "error", err)
+			}
+		}()
 	}
 }
 
@@ -180,23 +438,323 @@ func (a *Aggregator) HealthCheck(ctx context.Context, req *wolv1.HealthCheckRequ
 	}
 
 	return &wolv1.HealthCheckResponse{
-		Status: status,
+		Status:  status,
+		Version: Version,
 	}, nil
 }
 
-// checkDuplicate verifica se un evento è un duplicato (deduplica globale)
-func (a *Aggregator) checkDuplicate(event *wolv1.WOLEvent) (bool, *wolv1.WOLEventResponse) {
+// eventDedupeKey returns the global dedupe key of an event (MAC and destination port).
+// The SecureOn password is compared separately by checkDuplicate.
+func eventDedupeKey(event *wolv1.WOLEvent) string {
+	if event.Sleep {
+		return sleepDedupeKey(normalizeMACAddress(event.MacAddress), event.DestinationPort)
+	}
+	return dedupeKey(normalizeMACAddress(event.MacAddress), event.DestinationPort)
+}
+
+// RequestWake avvia una VM per nome, senza magic packet (es. richiesta dell'activator).
+// La VM deve essere gestita da una WolConfig: la richiesta usa la stessa
+// deduplica globale e la stessa identità (StartAs) di un pacchetto WOL
+func (a *Aggregator) RequestWake(ctx context.Context, req *wolv1.WakeRequest) (*wolv1.WOLEventResponse, error) {
+	startTime := time.Now()
+
+	a.log.Info("Received wake request via gRPC",
+		"vm", req.Name,
+		"namespace", req.Namespace,
+		"source", req.Source,
+		"reason", req.Reason)
+
+	if req.Source == "" {
+		req.Source = "unknown"
+	}
+
+	resp := a.requestWake(ctx, req, startTime)
+	WakeRequestsTotal.WithLabelValues(wakeSourceLabel(req.Source), resp.Status.String()).Inc()
+	return resp, nil
+}
+
+// wakeSourceLabel maps the client-provided source of a wake request to a fixed
+// set of metric label values, so callers cannot grow the metric cardinality
+func wakeSourceLabel(source string) string {
+	switch source {
+	case ActivatorWakeSource, ARPWakeSource, APIWakeSource, WakeHookWakeSource, MQTTWakeSource,
+		ICMPWakeSource, TCPWakeSource, DNSWakeSource:
+		return source
+	default:
+		return "other"
+	}
+}
+
+func (a *Aggregator) requestWake(ctx context.Context, req *wolv1.WakeRequest, startTime time.Time) *wolv1.WOLEventResponse {
+	key := wakeDedupeKey(req.Namespace, req.Name)
+	isDuplicate, cachedResp, release := a.claimEvent(key, "", req.Source)
+	if isDuplicate && cachedResp != nil {
+		cachedResp.ProcessingTimeMs = time.Since(startTime).Milliseconds()
+		return cachedResp
+	}
+	defer release()
+
+	vmInfo, found := a.mapper.LookupVM(req.Namespace, req.Name)
+	if !found {
+		a.log.Info("Wake request for a VM not managed by any WolConfig",
+			"vm", req.Name,
+			"namespace", req.Namespace,
+			"source", req.Source)
+
+		resp := &wolv1.WOLEventResponse{
+			Status:           wolv1.ResponseStatus_VM_NOT_FOUND,
+			Message:          fmt.Sprintf("VM %s/%s is not managed by any WolConfig", req.Namespace, req.Name),
+			ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+		}
+		a.recordEvent(key, "", req.Source, "", resp)
+		return resp
+	}
+
+	// Una richiesta senza magic packet non può portare la password SecureOn:
+	// le VM con policy Require si svegliano solo con un pacchetto valido
+	if vmInfo.SecureOnPolicy == wolv1beta1.SecureOnPolicyRequire {
+		SecureOnRejectedTotal.WithLabelValues(vmInfo.ConfigName, string(secureOnMissing)).Inc()
+		a.log.Info("Rejecting wake request: VM requires a SecureOn password",
+			"vm", vmInfo.Name,
+			"namespace", vmInfo.Namespace,
+			"source", req.Source,
+			"wolconfig", vmInfo.ConfigName)
+
+		resp := &wolv1.WOLEventResponse{
+			Status:  wolv1.ResponseStatus_SECURE_ON_PASSWORD_MISSING,
+			Message: fmt.Sprintf("VM %s/%s requires a SecureOn password and can only be woken by a magic packet", vmInfo.Namespace, vmInfo.Name),
+			VmInfo: &wolv1.VMInfo{
+				Name:      vmInfo.Name,
+				Namespace: vmInfo.Namespace,
+			},
+			ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+		}
+		a.recordEvent(key, "", req.Source, vmInfo.ConfigName, resp)
+		a.auditRequest(req, vmInfo, resp)
+		return resp
+	}
+
+	if resp := a.claimShared(ctx, key, "", req.Source, vmInfo, startTime); resp != nil {
+		return resp
+	}
+
+	action, resp := a.enforcePolicy(ctx, vmInfo, req.Source, startTime)
+	if resp != nil {
+		a.recordEvent(key, "", req.Source, vmInfo.ConfigName, resp)
+		a.auditRequest(req, vmInfo, resp)
+		return resp
+	}
+
+	if resp := a.dryRunWake(ctx, vmInfo, string(action), "", req.Source, startTime); resp != nil {
+		a.recordEvent(key, "", req.Source, vmInfo.ConfigName, resp)
+		a.auditRequest(req, vmInfo, resp)
+		return resp
+	}
+
+	a.recordDemand(vmInfo)
+	ctx = withWakeOrigin(ctx, wakeOrigin{Source: req.Source, Reason: req.Reason, ReceivedAt: startTime})
+
+	status, vm, err := a.wakeVM(ctx, vmInfo, action)
+	if err != nil {
+		if !errors.Is(err, errStartsThrottled) {
+			a.log.Error(err, "Failed to start VM for wake request",
+				"vm", vmInfo.Name,
+				"namespace", vmInfo.Namespace,
+				"source", req.Source,
+				"wolconfig", vmInfo.ConfigName)
+			ErrorsTotal.Inc()
+		}
+
+		status, message := a.startFailure(ctx, vmInfo, action, err)
+		resp := &wolv1.WOLEventResponse{
+			Status:           status,
+			Message:          message,
+			VmInfo:           vm,
+			ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+		}
+		a.recordEvent(key, "", req.Source, vmInfo.ConfigName, resp)
+		a.auditRequest(req, vmInfo, resp)
+		return resp
+	}
+
+	message := fmt.Sprintf("VM start initiated by %s (matched by WolConfig %s)", req.Source, vmInfo.ConfigName)
+	if status == wolv1.ResponseStatus_VM_ALREADY_RUNNING {
+		message = fmt.Sprintf("VM already running on node %s (matched by WolConfig %s)", vm.NodeName, vmInfo.ConfigName)
+	}
+	deps := a.startDependencies(ctx, vmInfo, nil, req.Source, startTime)
+	if len(deps) > 0 {
+		message += dependenciesMessage(deps)
+	}
+
+	resp = &wolv1.WOLEventResponse{
+		Status:           status,
+		Message:          message,
+		VmInfo:           vm,
+		ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+		Dependencies:     deps,
+	}
+	a.recordEvent(key, "", req.Source, vmInfo.ConfigName, resp)
+	a.auditRequest(req, vmInfo, resp)
+	return resp
+}
+
+// wakeVM avvia la VM, se non è già accesa, e ritorna lo status e la VMInfo
+// della risposta con lo stato letto da KubeVirt (se lo Starter lo supporta)
+func (a *Aggregator) wakeVM(ctx context.Context, vmInfo VMInfo, action wolv1beta1.WolPolicyAction) (wolv1.ResponseStatus, *wolv1.VMInfo, error) {
+	vm := &wolv1.VMInfo{Name: vmInfo.Name, Namespace: vmInfo.Namespace}
+	if reader, ok := a.vmStarter.(VMStateReader); ok {
+		state, node, err := reader.VMState(ctx, vmInfo.Namespace, vmInfo.Name)
+		if err != nil {
+			// Lo stato serve solo alla risposta: lo start decide da sé
+			a.log.V(1).Info("Cannot read the VM state", "vm", vmInfo.Name, "namespace", vmInfo.Namespace,
+				"error", err.Error())
+		}
+		vm.CurrentState, vm.NodeName = state, node
+	}
+	if vm.CurrentState == VMStateRunning {
+		a.log.Info("VM already running, not starting it", "vm", vmInfo.Name, "namespace", vmInfo.Namespace,
+			"node", vm.NodeName, "wolconfig", vmInfo.ConfigName)
+		return wolv1.ResponseStatus_VM_ALREADY_RUNNING, vm, nil
+	}
+
+	if err := a.startVM(ctx, vmInfo, action); err != nil {
+		return wolv1.ResponseStatus_ERROR, vm, err
+	}
+	VMStartedTotal.Inc()
+	// Una VM in pausa riparte subito, sullo stesso nodo
+	if vm.CurrentState == VMStatePaused {
+		vm.CurrentState = VMStateRunning
+	} else {
+		vm.CurrentState = VMStateStarting
+	}
+	return wolv1.ResponseStatus_VM_START_INITIATED, vm, nil
+}
+
+// startVM applica l'azione della WolPolicy alla VM con l'identità della sua
+// WolConfig, contando gli avvii in corso e misurandone la durata
+func (a *Aggregator) startVM(ctx context.Context, vmInfo VMInfo, action wolv1beta1.WolPolicyAction) error {
+	a.startsInFlight.Add(1)
+	defer a.startsInFlight.Add(-1)
+	release, err := a.startLimit.acquire(ctx)
+	if err != nil {
+		a.log.V(1).Info("VM start throttled", "vm", vmInfo.Name, "namespace", vmInfo.Namespace,
+			"wolconfig", vmInfo.ConfigName, "reason", err.Error())
+		return err
+	}
+	defer release()
+	if chaosHit(a.chaos.StartFailurePercent, "start_failure") {
+		return errChaosStartFailure
+	}
+
+	// Con spec.wakeMetadata la VM riporta l'ultimo wake; le annotazioni del
+	// template vanno scritte prima dello start per arrivare alla VMI
+	origin, hasOrigin := wakeOriginFromContext(ctx)
+	metadata := a.mapper.WakeMetadata(vmInfo.ConfigName)
+	if !hasOrigin {
+		metadata = nil
+	}
+	start := time.Now()
+	if metadata != nil && metadata.Guest {
+		a.annotateWake(ctx, vmInfo, origin, start, true)
+	}
+	// Con spec.placementHint la VM preferisce il nodo che ha ricevuto il pacchetto
+	if spec := a.mapper.PlacementHint(vmInfo.ConfigName); spec != nil {
+		node := origin.Node
+		if _, fromRelay := relayFromContext(ctx); fromRelay {
+			node = ""
+		}
+		a.placeWake(ctx, vmInfo, spec, node)
+	}
+
+	err = a.callStarter(ctx, vmInfo, action)
+	result := "started"
+	if err != nil {
+		result = "failed"
+	}
+	VMStartDurationSeconds.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	if err == nil && metadata != nil {
+		a.annotateWake(ctx, vmInfo, origin, start, false)
+	}
+	if err == nil {
+		a.trackWake(vmInfo, origin, time.Now())
+	}
+	return err
+}
+
+// callStarter chiama il VM starter con l'azione decisa dalla WolPolicy
+func (a *Aggregator) callStarter(ctx context.Context, vmInfo VMInfo, action wolv1beta1.WolPolicyAction) error {
+	// Un membro rimosso da uno scale-down va ricreato dal suo pool: non può
+	// essere in pausa né in crash, l'azione è sempre uno start
+	if vmInfo.MappingType == MappingTypePoolMember {
+		starter, ok := a.vmStarter.(PoolStarter)
+		if !ok {
+			return fmt.Errorf("the VM starter does not support VirtualMachinePools")
+		}
+		return starter.StartPoolMemberAs(ctx, vmInfo.StartAs, vmInfo.Namespace, vmInfo.Pool, vmInfo.Name)
+	}
+	switch action {
+	case wolv1beta1.WolPolicyActionResume, wolv1beta1.WolPolicyActionRestartIfCrashed:
+		starter, ok := a.vmStarter.(PolicyStarter)
+		if !ok {
+			return fmt.Errorf("the VM starter does not support the %s action", action)
+		}
+		if action == wolv1beta1.WolPolicyActionResume {
+			return starter.ResumeVMAs(ctx, vmInfo.StartAs, vmInfo.Namespace, vmInfo.Name)
+		}
+		return starter.RestartCrashedVMAs(ctx, vmInfo.StartAs, vmInfo.Namespace, vmInfo.Name)
+	default:
+		return a.vmStarter.StartVMAs(ctx, vmInfo.StartAs, vmInfo.Namespace, vmInfo.Name)
+	}
+}
+
+// wakeDedupeKey returns the global dedupe key of a wake request
+func wakeDedupeKey(namespace, name string) string {
+	return "vm|" + vmIndexKey(namespace, name)
+}
+
+// checkDuplicate verifica se un evento è un duplicato (deduplica globale).
+// Un evento con una password SecureOn diversa da quella registrata non è un
+// duplicato (es. retry con la password corretta dopo un broadcast semplice)
+func (a *Aggregator) checkDuplicate(key, password, nodeName string) (bool, *wolv1.WOLEventResponse) {
 	a.dedupeLock.Lock()
 	defer a.dedupeLock.Unlock()
+	return a.checkDuplicateLocked(key, password, nodeName)
+}
 
+// claimEvent è checkDuplicate, ma un evento nuovo resta reclamato finché non
+// si chiama release: le copie ricevute mentre il primo è in elaborazione (es.
+// su stream paralleli) sono duplicati anche prima che recordEvent lo registri
+func (a *Aggregator) claimEvent(key, password, nodeName string) (bool, *wolv1.WOLEventResponse, func()) {
+	a.dedupeLock.Lock()
+	defer a.dedupeLock.Unlock()
+	if duplicate, resp := a.checkDuplicateLocked(key, password, nodeName); duplicate {
+		return true, resp, func() {}
+	}
+	claim := key + "|" + password
+	if a.dedupeClaims[claim] {
+		return true, &wolv1.WOLEventResponse{
+			Status:       wolv1.ResponseStatus_DUPLICATE,
+			Message:      "Event already being processed",
+			WasDuplicate: true,
+		}, func() {}
+	}
+	a.dedupeClaims[claim] = true
+	return false, nil, func() {
+		a.dedupeLock.Lock()
+		defer a.dedupeLock.Unlock()
+		delete(a.dedupeClaims, claim)
+	}
+}
+
+// checkDuplicateLocked è checkDuplicate con dedupeLock già preso
+func (a *Aggregator) checkDuplicateLocked(key, password, nodeName string) (bool, *wolv1.WOLEventResponse) {
 	now := time.Now()
-	mac := event.MacAddress
 
-	if entry, exists := a.dedupeMap[mac]; exists {
-		if now.Sub(entry.lastSeen) < a.dedupeDuration {
+	if entry, exists := a.dedupeMap[key]; exists && entry.password == password {
+		if now.Sub(entry.lastSeen) < entry.window {
 			// Duplicato! Aggiorna stats
 			entry.count++
-			entry.nodes = append(entry.nodes, event.NodeName)
+			entry.nodes = append(entry.nodes, nodeName)
 			entry.lastSeen = now
 
 			// Crea response duplicate
@@ -218,19 +776,35 @@ func (a *Aggregator) checkDuplicate(event *wolv1.WOLEvent) (bool, *wolv1.WOLEven
 	return false, nil
 }
 
-// recordEvent registra un evento per la deduplica
-func (a *Aggregator) recordEvent(event *wolv1.WOLEvent, resp *wolv1.WOLEventResponse) {
+// recordEvent registra un evento per la deduplica, con la finestra della
+// WolConfig configName che lo ha gestito ("" se nessuna: finestra di default)
+func (a *Aggregator) recordEvent(key, password, nodeName, configName string, resp *wolv1.WOLEventResponse) {
+	window := a.dedupeWindow(configName)
+
 	a.dedupeLock.Lock()
 	defer a.dedupeLock.Unlock()
 
-	a.dedupeMap[event.MacAddress] = &dedupeEntry{
+	a.dedupeMap[key] = &dedupeEntry{
 		lastSeen:     time.Now(),
+		window:       window,
+		password:     password,
 		count:        1,
-		nodes:        []string{event.NodeName},
+		nodes:        []string{nodeName},
 		lastResponse: resp,
 	}
 }
 
+// dedupeWindow returns the dedupe window of the WolConfig configName ("" for
+// events no config handled: the default window)
+func (a *Aggregator) dedupeWindow(configName string) time.Duration {
+	if configName != "" {
+		if configWindow := a.mapper.DedupeWindow(configName); configWindow > 0 {
+			return configWindow
+		}
+	}
+	return a.dedupeDuration
+}
+
 // StartCleanup avvia la routine di pulizia della cache di deduplica
 func (a *Aggregator) StartCleanup(ctx context.Context) {
 	ticker := time.NewTicker(30 * time.Second)
@@ -245,6 +819,11 @@ func (a *Aggregator) StartCleanup(ctx context.Context) {
 			return
 		case <-ticker.C:
 			a.cleanup()
+			if a.shared != nil {
+				if err := a.shared.Cleanup(ctx); err != nil {
+					a.log.Error(err, "Failed to clean up the shared dedupe claims")
+				}
+			}
 		}
 	}
 }
@@ -256,13 +835,18 @@ func (a *Aggregator) cleanup() {
 	now := time.Now()
 	cleaned := 0
 
-	for mac, entry := range a.dedupeMap {
-		if now.Sub(entry.lastSeen) > a.dedupeDuration*2 {
-			delete(a.dedupeMap, mac)
+	for key, entry := range a.dedupeMap {
+		if now.Sub(entry.lastSeen) > entry.window*2 {
+			delete(a.dedupeMap, key)
 			cleaned++
 		}
 	}
 
+	if a.policies != nil {
+		a.policies.cleanup()
+	}
+	a.rateLimiter.cleanup()
+
 	if cleaned > 0 {
 		a.log.V(1).Info("Cleaned up dedupe cache",
 			"cleaned", cleaned,
@@ -19,8 +19,12 @@ package wol
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
 	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
 )
 
@@ -122,3 +126,202 @@ func TestAggregator_Deduplication(t *testing.T) {
 		t.Errorf("Expected DUPLICATE status, got %v", resp2.Status)
 	}
 }
+
+func TestAggregator_DeduplicationWhileProcessing(t *testing.T) {
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:01", VMName: "desktop", Namespace: "default"},
+			},
+		},
+	}
+	config.Name = "lab"
+	mapper.UpdateConfig(config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	starter := &heldStarter{release: make(chan struct{})}
+	agg := NewAggregator(mapper, starter, logr.Discard())
+	event := &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01", NodeName: "node-a", DestinationPort: 9}
+
+	done := make(chan *wolv1.WOLEventResponse)
+	go func() {
+		resp, _ := agg.ReportWOLEvent(context.Background(), event)
+		done <- resp
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for starter.starts.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A copy received while the first event is starting the VM is a duplicate
+	resp, _ := agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{
+		MacAddress: "52:54:00:00:00:01", NodeName: "node-b", DestinationPort: 9,
+	})
+	if resp.Status != wolv1.ResponseStatus_DUPLICATE {
+		t.Errorf("Expected the copy in flight to be a duplicate, got %v", resp.Status)
+	}
+	close(starter.release)
+	if resp := <-done; resp.Status != wolv1.ResponseStatus_VM_START_INITIATED {
+		t.Errorf("Expected the first event to start the VM, got %v: %s", resp.Status, resp.Message)
+	}
+	if starts := starter.starts.Load(); starts != 1 {
+		t.Errorf("Expected the VM to be started once, got %d", starts)
+	}
+	// Once recorded, the copies are answered from the dedupe cache
+	resp, _ = agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{
+		MacAddress: "52:54:00:00:00:01", NodeName: "node-c", DestinationPort: 9,
+	})
+	if resp.Status != wolv1.ResponseStatus_DUPLICATE || resp.VmInfo.GetName() != "desktop" {
+		t.Errorf("Expected a cached duplicate of the desktop, got %v (%v)", resp.Status, resp.VmInfo)
+	}
+}
+
+func TestAggregator_DeduplicationKeyedByPortAndPassword(t *testing.T) {
+	mapper := NewMACMapper(nil, logr.Discard())
+	vmStarter := NewVMStarter(nil, logr.Discard())
+	agg := NewAggregator(mapper, vmStarter, logr.Discard())
+
+	events := []*wolv1.WOLEvent{
+		{MacAddress: "52:54:00:12:34:56", NodeName: "test-node", DestinationPort: 9},
+		{MacAddress: "52:54:00:12:34:56", NodeName: "test-node", DestinationPort: 7},
+		{MacAddress: "52:54:00:12:34:56", NodeName: "test-node", DestinationPort: 7, SecureOnPassword: "01:02:03:04:05:06"},
+	}
+
+	for i, event := range events {
+		resp, err := agg.ReportWOLEvent(context.Background(), event)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if resp.WasDuplicate {
+			t.Errorf("Event %d should not be a duplicate of a different port/password", i)
+		}
+	}
+
+	// Same MAC, port and password is still a duplicate
+	resp, err := agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{
+		MacAddress:       "52:54:00:12:34:56",
+		NodeName:         "other-node",
+		DestinationPort:  7,
+		SecureOnPassword: "01:02:03:04:05:06",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !resp.WasDuplicate {
+		t.Error("Repeated event with same port and password should be a duplicate")
+	}
+
+	// The password is not part of the key: one entry per MAC and port
+	if size := agg.GetStats()["dedupe_cache_size"]; size != 2 {
+		t.Errorf("Expected 2 dedupe entries, got %v", size)
+	}
+}
+
+func TestAggregator_DedupeWindowPerConfig(t *testing.T) {
+	mapper := NewMACMapper(nil, logr.Discard())
+	window := int32(1)
+	mapper.UpdateConfigs([]wolv1beta1.WolConfig{
+		{ObjectMeta: metav1.ObjectMeta{Name: "fast"}, Spec: wolv1beta1.WolConfigSpec{
+			Dedupe: &wolv1beta1.DedupeSpec{AggregatorWindowSeconds: &window},
+		}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+	})
+	agg := NewAggregator(mapper, NewVMStarter(nil, logr.Discard()), logr.Discard())
+
+	agg.recordEvent("fast", "", "node", "fast", &wolv1.WOLEventResponse{})
+	agg.recordEvent("default", "", "node", "default", &wolv1.WOLEventResponse{})
+	agg.recordEvent("unmatched", "", "node", "", &wolv1.WOLEventResponse{})
+
+	// Due secondi dopo: fuori dalla finestra di "fast", dentro quella di default
+	agg.dedupeLock.Lock()
+	for _, entry := range agg.dedupeMap {
+		entry.lastSeen = entry.lastSeen.Add(-2 * time.Second)
+	}
+	agg.dedupeLock.Unlock()
+
+	for key, want := range map[string]bool{"fast": false, "default": true, "unmatched": true} {
+		if duplicate, _ := agg.checkDuplicate(key, "", "other-node"); duplicate != want {
+			t.Errorf("%s: expected duplicate %v, got %v", key, want, duplicate)
+		}
+	}
+}
+
+func TestAggregator_RequestWake_UnmanagedVM(t *testing.T) {
+	mapper := NewMACMapper(nil, logr.Discard())
+	agg := NewAggregator(mapper, NewVMStarter(nil, logr.Discard()), logr.Discard())
+
+	req := &wolv1.WakeRequest{Namespace: "default", Name: "vm1", Source: "activator"}
+	resp, err := agg.RequestWake(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Status != wolv1.ResponseStatus_VM_NOT_FOUND {
+		t.Errorf("Expected VM_NOT_FOUND for an unmanaged VM, got %v", resp.Status)
+	}
+
+	resp, err = agg.RequestWake(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !resp.WasDuplicate {
+		t.Error("Expected the second wake request to be deduplicated")
+	}
+}
+
+func TestWakeSourceLabel(t *testing.T) {
+	tests := map[string]string{
+		ActivatorWakeSource: "activator",
+		ARPWakeSource:       "arp",
+		"":                  "other",
+		"my-script-12345":   "other",
+	}
+	for source, want := range tests {
+		if got := wakeSourceLabel(source); got != want {
+			t.Errorf("wakeSourceLabel(%q) = %q, want %q", source, got, want)
+		}
+	}
+}
+
+// stateStarter is a policyStarter reading the VM states from a map
+type stateStarter struct {
+	policyStarter
+	states map[string][2]string // VM -> state, node
+}
+
+func (s *stateStarter) VMState(_ context.Context, _, name string) (string, string, error) {
+	state := s.states[name]
+	return state[0], state[1], nil
+}
+
+func TestAggregator_VMAlreadyRunning(t *testing.T) {
+	agg := newForwardAggregator(t, wolv1beta1.WOLForwardTarget{Address: "192.168.10.255", Port: 9})
+	starter := &stateStarter{
+		policyStarter: policyStarter{actions: make(map[string]string)},
+		states:        map[string][2]string{"vm1": {VMStateRunning, "node-1"}},
+	}
+	agg.vmStarter = starter
+	ctx := context.Background()
+
+	resp, err := agg.ReportWOLEvent(ctx, &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01", NodeName: "node-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != wolv1.ResponseStatus_VM_ALREADY_RUNNING || resp.VmInfo.GetCurrentState() != VMStateRunning ||
+		resp.VmInfo.GetNodeName() != "node-1" {
+		t.Errorf("Expected VM_ALREADY_RUNNING on node-1, got %v", resp)
+	}
+	if len(starter.actions) != 0 {
+		t.Errorf("Expected a running VM not to be started, got %v", starter.actions)
+	}
+
+	// Una VM in pausa viene ripresa e riparte sullo stesso nodo
+	starter.states["vm1"] = [2]string{VMStatePaused, "node-1"}
+	resp, _ = agg.RequestWake(ctx, &wolv1.WakeRequest{Namespace: "default", Name: "vm1", Source: "test"})
+	if resp.Status != wolv1.ResponseStatus_VM_START_INITIATED || resp.VmInfo.GetCurrentState() != VMStateRunning ||
+		resp.VmInfo.GetNodeName() != "node-1" || starter.actions["vm1"] != "start" {
+		t.Errorf("Expected the paused VM to be started, got %v (actions %v)", resp, starter.actions)
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+const (
+	// allowlistDebounce coalesces the mapping changes pushed to the agents
+	allowlistDebounce = time.Second
+	// allowlistRetryInterval is the wait before reopening a failed allowlist stream
+	allowlistRetryInterval = 5 * time.Second
+)
+
+// mappingChange returns a channel closed at the next change of the mapping
+func (m *MACMapper) mappingChange() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mappingChanged == nil {
+		m.mappingChanged = make(chan struct{})
+	}
+	return m.mappingChanged
+}
+
+// notifyMappingChange wakes up the watchers of the mapping
+func (m *MACMapper) notifyMappingChange() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mappingChanged != nil {
+		close(m.mappingChanged)
+		m.mappingChanged = nil
+	}
+}
+
+// ManagedMACs returns the sorted MACs the operator acts on: those of the VMs,
+// their reversed MACs when Sleep-on-LAN is enabled and the Forward mappings
+func (m *MACMapper) ManagedMACs() []string {
+	m.mu.RLock()
+	sleep := make(map[string]bool)
+	for i := range m.configs {
+		if spec := m.configs[i].Spec.ShutdownOnLAN; spec != nil && spec.Enabled {
+			sleep[m.configs[i].Name] = true
+		}
+	}
+	keys := make(map[macKey]bool, m.mapping.Len()+len(m.forwards))
+	for key := range m.forwards {
+		keys[key] = true
+	}
+	m.mu.RUnlock()
+
+	m.mapping.Range(func(key macKey, info VMInfo) bool {
+		keys[key] = true
+		if sleep[info.ConfigName] {
+			keys[key.reversed()] = true
+		}
+		return true
+	})
+
+	macs := make([]string, 0, len(keys))
+	for key := range keys {
+		macs = append(macs, key.String())
+	}
+	slices.Sort(macs)
+	return macs
+}
+
+// WatchMACAllowlist streams to an agent the MACs the operator acts on, again
+// after every change of the mapping. Nothing is sent before the first refresh
+// of the mapping: until then the agent does not filter.
+func (a *Aggregator) WatchMACAllowlist(req *wolv1.MACAllowlistRequest, stream wolv1.WOLService_WatchMACAllowlistServer) error {
+	if req.NodeName == "" {
+		return status.Error(codes.InvalidArgument, "node_name is required")
+	}
+	ctx := stream.Context()
+	a.log.V(1).Info("Agent watching the MAC allowlist", "node", req.NodeName, "wolconfig", req.WolConfig)
+
+	var sent []string
+	first := true
+	for {
+		changed := a.mapper.mappingChange()
+		if !a.mapper.GetLastSync().IsZero() {
+			macs := a.mapper.ManagedMACs()
+			if first || !slices.Equal(macs, sent) {
+				if err := stream.Send(&wolv1.MACAllowlist{MacAddresses: macs}); err != nil {
+					return err
+				}
+				sent, first = macs, false
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		}
+		// Un refresh cambia molti MAC in poco tempo: un solo invio
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(allowlistDebounce):
+		}
+	}
+}
+
+// SetMACFilter enables the MAC allowlist pushed by the operator: the magic
+// packets of the other MACs are dropped without reporting them
+func (a *Agent) SetMACFilter(enable bool) {
+	a.macFilter = enable
+}
+
+// syncMACAllowlist keeps open the stream of the MAC allowlist, reopening it
+// when it fails. Without a stream the agent does not filter.
+func (a *Agent) syncMACAllowlist(ctx context.Context) {
+	defer a.wg.Done()
+	for {
+		err := a.watchMACAllowlist(ctx)
+		// Una lista non più aggiornata scarterebbe i MAC delle nuove VM
+		a.allowedMACs.Store(nil)
+		if ctx.Err() != nil {
+			return
+		}
+		if status.Code(err) == codes.Unimplemented {
+			a.log.Info("Operator does not support the MAC allowlist, not filtering packets")
+			return
+		}
+		a.log.V(1).Info("MAC allowlist stream closed, reopening", "error", err.Error())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(allowlistRetryInterval):
+		}
+	}
+}
+
+func (a *Agent) watchMACAllowlist(ctx context.Context) error {
+	stream, err := a.grpcClient.WatchMACAllowlist(ctx, &wolv1.MACAllowlistRequest{
+		NodeName:  a.nodeName,
+		WolConfig: a.wolConfigName,
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		list, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		a.setMACAllowlist(list.MacAddresses)
+	}
+}
+
+// setMACAllowlist replaces the MACs whose packets are reported to the operator
+func (a *Agent) setMACAllowlist(macs []string) {
+	allowed := make(map[macKey]bool, len(macs))
+	for _, mac := range macs {
+		if key, ok := parseMACKey(mac); ok {
+			allowed[key] = true
+		}
+	}
+	if previous := a.allowedMACs.Swap(&allowed); previous == nil {
+		a.log.Info("MAC allowlist received, filtering the packets of unmanaged MACs", "macs", len(allowed))
+		return
+	}
+	a.log.V(1).Info("MAC allowlist updated", "macs", len(allowed))
+}
+
+// macAllowed returns false for the MACs the operator does not act on, once it
+// has pushed its allowlist
+func (a *Agent) macAllowed(mac string) bool {
+	allowed := a.allowedMACs.Load()
+	if allowed == nil {
+		return true
+	}
+	key, ok := parseMACKey(mac)
+	return !ok || (*allowed)[key]
+}
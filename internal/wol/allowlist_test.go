@@ -0,0 +1,140 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestMACMapper_ManagedMACs(t *testing.T) {
+	agg := newForwardAggregator(t, wolv1beta1.WOLForwardTarget{Address: "192.168.10.255", Port: 9})
+
+	want := []string{"52:54:00:00:00:01", "aa:bb:cc:00:00:01"}
+	if macs := agg.mapper.ManagedMACs(); !slices.Equal(macs, want) {
+		t.Errorf("Expected the VM and forward MACs %v, got %v", want, macs)
+	}
+
+	// Sleep-on-LAN: anche il MAC invertito delle VM è gestito
+	config := agg.mapper.wolConfig("lab")
+	config.Spec.ShutdownOnLAN = &wolv1beta1.ShutdownOnLANSpec{Enabled: true}
+	agg.mapper.UpdateConfig(config)
+	want = []string{"01:00:00:00:54:52", "52:54:00:00:00:01", "aa:bb:cc:00:00:01"}
+	if macs := agg.mapper.ManagedMACs(); !slices.Equal(macs, want) {
+		t.Errorf("Expected the reversed VM MAC too %v, got %v", want, macs)
+	}
+}
+
+func TestMACMapper_MappingChange(t *testing.T) {
+	agg := newForwardAggregator(t, wolv1beta1.WOLForwardTarget{Address: "192.168.10.255", Port: 9})
+
+	changed := agg.mapper.mappingChange()
+	select {
+	case <-changed:
+		t.Fatal("Expected no change before a refresh")
+	default:
+	}
+	if err := agg.mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Error("Expected a refresh to signal a mapping change")
+	}
+}
+
+func TestAgent_MACAllowlist(t *testing.T) {
+	agg := newForwardAggregator(t, wolv1beta1.WOLForwardTarget{Address: "192.168.10.255", Port: 9})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	wolv1.RegisterWOLServiceServer(server, agg)
+	go func() { _ = server.Serve(lis) }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	agent := NewAgent(0, "node-a", lis.Addr().String(), logr.Discard())
+	grpcConn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.grpcConn = newTrackedConn(grpcConn)
+	agent.grpcClient = wolv1.NewWOLServiceClient(agent.grpcConn)
+	agent.wg.Add(1)
+	go agent.syncMACAllowlist(ctx)
+	defer func() {
+		cancel()
+		agent.wg.Wait()
+		_ = grpcConn.Close()
+	}()
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	waitFor("the MAC allowlist", func() bool { return agent.allowedMACs.Load() != nil })
+
+	if !agent.macAllowed("52:54:00:00:00:01") || !agent.macAllowed("AA:BB:CC:00:00:01") {
+		t.Error("Expected the VM and forward MACs to be allowed")
+	}
+	if agent.macAllowed("52:54:00:00:00:02") {
+		t.Error("Expected an unmanaged MAC to be filtered")
+	}
+
+	packet, err := newMagicPacket("52:54:00:00:00:02", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.processPacket(ctx, packet, &net.UDPAddr{IP: net.IPv4(192, 168, 1, 10), Port: 40000}, 9, false, time.Now())
+	if filtered := testutil.ToFloat64(agent.metrics.packetsFiltered); filtered != 1 {
+		t.Errorf("Expected 1 filtered packet, got %v", filtered)
+	}
+
+	// Una nuova VM nella mapping arriva all'agent senza riconnessioni
+	config := agg.mapper.wolConfig("lab")
+	config.Spec.ExplicitMappings = append(config.Spec.ExplicitMappings,
+		wolv1beta1.MACVMMapping{MACAddress: "52:54:00:00:00:02", VMName: "vm1", Namespace: "default"})
+	agg.mapper.UpdateConfig(config)
+	if err := agg.mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("the updated MAC allowlist", func() bool { return agent.macAllowed("52:54:00:00:00:02") })
+
+	// Senza operatore l'agent non filtra
+	server.Stop()
+	waitFor("the agent to stop filtering", func() bool { return agent.allowedMACs.Load() == nil })
+}
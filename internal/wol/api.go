@@ -0,0 +1,496 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+const (
+	// APIWakeSource is the source reported by the wakes requested through the REST API
+	APIWakeSource = "api"
+	// apiSendMaxBody bounds the body of a send request
+	apiSendMaxBody = 4 << 10
+)
+
+// errUnauthenticated is returned by AccessReviewer.Authenticate for an invalid token
+var errUnauthenticated = errors.New("invalid bearer token")
+
+// AccessReviewer authenticates the callers of the REST API and checks their permissions
+type AccessReviewer interface {
+	// Authenticate returns the user of a bearer token
+	Authenticate(ctx context.Context, token string) (authenticationv1.UserInfo, error)
+	// Authorize returns true if the user is allowed the action described by attrs
+	Authorize(ctx context.Context, user authenticationv1.UserInfo, attrs authorizationv1.ResourceAttributes) (bool, error)
+}
+
+// kubeAccessReviewer delegates authentication and authorization to the API
+// server with TokenReviews and SubjectAccessReviews
+type kubeAccessReviewer struct {
+	client client.Client
+}
+
+// NewAccessReviewer returns an AccessReviewer backed by TokenReviews and SubjectAccessReviews
+func NewAccessReviewer(c client.Client) AccessReviewer {
+	return &kubeAccessReviewer{client: c}
+}
+
+func (r *kubeAccessReviewer) Authenticate(ctx context.Context, token string) (authenticationv1.UserInfo, error) {
+	review := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}
+	if err := r.client.Create(ctx, review); err != nil {
+		return authenticationv1.UserInfo{}, fmt.Errorf("token review failed: %w", err)
+	}
+	if !review.Status.Authenticated {
+		return authenticationv1.UserInfo{}, errUnauthenticated
+	}
+	return review.Status.User, nil
+}
+
+func (r *kubeAccessReviewer) Authorize(ctx context.Context, user authenticationv1.UserInfo,
+	attrs authorizationv1.ResourceAttributes) (bool, error) {
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	review := &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+		ResourceAttributes: &attrs,
+		User:               user.Username,
+		UID:                user.UID,
+		Groups:             user.Groups,
+		Extra:              extra,
+	}}
+	if err := r.client.Create(ctx, review); err != nil {
+		return false, fmt.Errorf("subject access review failed: %w", err)
+	}
+	return review.Status.Allowed, nil
+}
+
+// APIServer serves the REST API of the manager:
+//
+//	POST /api/v1/wake?mac=<mac> (or ?namespace=<ns>&name=<vm>) wakes a VM
+//	GET  /api/v1/mappings[?wolconfig=<name>][&mac=<mac>] lists the MAC to VM mappings
+//	POST /api/v1/hooks/wake wakes a VM of the WolConfig of a signed wake hook (see hooks.go)
+//	POST /api/v1/send sends a magic packet to a machine outside the cluster (see send.go)
+//	POST /api/v1/simulate simulates a magic packet in dry-run (see simulate.go)
+//
+// Callers authenticate with a bearer token. A wake requires the permission to
+// start the VM (update on virtualmachines/start in subresources.kubevirt.io),
+// the mappings the permission to read the WolConfigs, a send the permission
+// to create wolconfigs/send (for the WolConfig of the sending agent, any
+// WolConfig when the manager sends), a simulation the permission to list the
+// WolConfigs, since it reveals the mapping of any MAC.
+type APIServer struct {
+	mapper     *MACMapper
+	aggregator *Aggregator
+	reviewer   AccessReviewer
+	log        logr.Logger
+
+	// hookReplays refuses the wake hook requests already served
+	hookReplays wakeHookReplays
+}
+
+// NewAPIServer creates the REST API of the manager
+func NewAPIServer(mapper *MACMapper, aggregator *Aggregator, reviewer AccessReviewer, log logr.Logger) *APIServer {
+	return &APIServer{mapper: mapper, aggregator: aggregator, reviewer: reviewer, log: log}
+}
+
+// Handler returns the HTTP handler of the API
+func (s *APIServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/wake", s.serveWake)
+	mux.HandleFunc("/api/v1/mappings", s.serveMappings)
+	mux.HandleFunc("/api/v1/hooks/wake", s.serveWakeHook)
+	mux.HandleFunc("/api/v1/send", s.serveSend)
+	mux.HandleFunc("/api/v1/simulate", s.serveSimulate)
+	return mux
+}
+
+// apiMapping is a MAC to VM mapping as returned by /api/v1/mappings
+type apiMapping struct {
+	MAC         string      `json:"mac"`
+	Namespace   string      `json:"namespace"`
+	Name        string      `json:"name"`
+	WolConfig   string      `json:"wolConfig"`
+	MappingType MappingType `json:"mappingType"`
+}
+
+// apiWakeResponse is the outcome of a wake as returned by /api/v1/wake
+type apiWakeResponse struct {
+	Status    string `json:"status"`
+	Message   string `json:"message"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// Dependencies are the VMs started after this one (wake-with annotation)
+	Dependencies []apiWakeResponse `json:"dependencies,omitempty"`
+}
+
+// apiSendRequest is the body of /api/v1/send
+type apiSendRequest struct {
+	MAC       string `json:"mac"`
+	Password  string `json:"password,omitempty"`
+	Address   string `json:"address,omitempty"`
+	Port      uint32 `json:"port,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	// Node sends the packet from the agent of WolConfig on that node
+	Node      string `json:"node,omitempty"`
+	WolConfig string `json:"wolConfig,omitempty"`
+}
+
+// apiSendResponse is the outcome of a send as returned by /api/v1/send
+type apiSendResponse struct {
+	Sender      string `json:"sender"`
+	Destination string `json:"destination"`
+	Message     string `json:"message"`
+}
+
+// apiSimulateRequest is the body of /api/v1/simulate
+type apiSimulateRequest struct {
+	MAC      string `json:"mac"`
+	Password string `json:"password,omitempty"`
+	// Node is the node that would have received the packet (node rate limit)
+	Node string `json:"node,omitempty"`
+}
+
+// apiSimulateResponse is the outcome of a simulation as returned by /api/v1/simulate
+type apiSimulateResponse struct {
+	Status       string   `json:"status"`
+	Message      string   `json:"message"`
+	Namespace    string   `json:"namespace,omitempty"`
+	Name         string   `json:"name,omitempty"`
+	CurrentState string   `json:"currentState,omitempty"`
+	WolConfig    string   `json:"wolConfig,omitempty"`
+	MappingType  string   `json:"mappingType,omitempty"`
+	Action       string   `json:"action,omitempty"`
+	Steps        []string `json:"steps"`
+}
+
+// apiError is the body of the error responses
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func (s *APIServer) serveWake(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "use POST"})
+		return
+	}
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	namespace, name := query.Get("namespace"), query.Get("name")
+	mac := query.Get("mac")
+	if mac != "" {
+		info, found := s.mapper.Lookup(mac)
+		if !found {
+			writeJSON(w, http.StatusNotFound, apiError{Error: fmt.Sprintf("no VM configured for MAC %s", mac)})
+			return
+		}
+		namespace, name = info.Namespace, info.Name
+	} else if namespace == "" || name == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "either mac or namespace and name are required"})
+		return
+	}
+
+	attrs := authorizationv1.ResourceAttributes{
+		Group: "subresources.kubevirt.io", Resource: "virtualmachines", Subresource: "start",
+		Verb: "update", Namespace: namespace, Name: name,
+	}
+	allowed, ok := s.allowed(w, r, user, attrs)
+	if !ok {
+		return
+	}
+	if !allowed {
+		if mac != "" {
+			// Chi non può avviare la VM non deve scoprire a quale VM punta un MAC:
+			// risponde come per un MAC sconosciuto
+			writeJSON(w, http.StatusNotFound, apiError{Error: fmt.Sprintf("no VM configured for MAC %s", mac)})
+			return
+		}
+		writeForbidden(w, user, attrs)
+		return
+	}
+
+	resp, _ := s.aggregator.RequestWake(r.Context(), &wolv1.WakeRequest{
+		Namespace: namespace,
+		Name:      name,
+		Source:    APIWakeSource,
+		Reason:    "REST API, user " + user.Username,
+	})
+	writeWakeResponse(w, namespace, name, resp)
+}
+
+func (s *APIServer) serveSend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "use POST"})
+		return
+	}
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var body apiSendRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiSendMaxBody)).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: fmt.Sprintf("invalid body: %v", err)})
+		return
+	}
+	// Il permesso è sulla WolConfig dell'agent che invia: senza WolConfig
+	// (invio dal manager) serve su tutte
+	attrs := authorizationv1.ResourceAttributes{
+		Group: "wol.pillon.org", Resource: "wolconfigs", Subresource: "send",
+		Verb: "create", Name: body.WolConfig,
+	}
+	if allowed, ok := s.allowed(w, r, user, attrs); !ok {
+		return
+	} else if !allowed {
+		writeForbidden(w, user, attrs)
+		return
+	}
+
+	resp, err := s.aggregator.SendWOL(r.Context(), &wolv1.SendWOLRequest{
+		MacAddress:       body.MAC,
+		SecureOnPassword: body.Password,
+		Address:          body.Address,
+		Port:             body.Port,
+		Interface:        body.Interface,
+		NodeName:         body.Node,
+		WolConfig:        body.WolConfig,
+		Source:           "REST API, user " + user.Username,
+	})
+	if err != nil {
+		code := http.StatusInternalServerError
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			code = http.StatusBadRequest
+		case codes.NotFound:
+			code = http.StatusNotFound
+		case codes.Unavailable:
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, apiError{Error: status.Convert(err).Message()})
+		return
+	}
+	writeJSON(w, http.StatusOK, apiSendResponse{Sender: resp.Sender, Destination: resp.Destination, Message: resp.Message})
+}
+
+func (s *APIServer) serveSimulate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "use POST"})
+		return
+	}
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var body apiSimulateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiSendMaxBody)).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: fmt.Sprintf("invalid body: %v", err)})
+		return
+	}
+	attrs := authorizationv1.ResourceAttributes{Group: "wol.pillon.org", Resource: "wolconfigs", Verb: "list"}
+	if allowed, ok := s.allowed(w, r, user, attrs); !ok {
+		return
+	} else if !allowed {
+		writeForbidden(w, user, attrs)
+		return
+	}
+
+	resp, err := s.aggregator.SimulateWake(r.Context(), &wolv1.SimulateWakeRequest{
+		MacAddress:       body.MAC,
+		SecureOnPassword: body.Password,
+		NodeName:         body.Node,
+		Source:           "REST API, user " + user.Username,
+	})
+	if err != nil {
+		code := http.StatusInternalServerError
+		if status.Code(err) == codes.InvalidArgument {
+			code = http.StatusBadRequest
+		}
+		writeJSON(w, code, apiError{Error: status.Convert(err).Message()})
+		return
+	}
+	result := apiSimulateResponse{
+		Status:      resp.Status.String(),
+		Message:     resp.Message,
+		WolConfig:   resp.WolConfig,
+		MappingType: resp.MappingType,
+		Action:      resp.Action,
+		Steps:       resp.Steps,
+	}
+	if vm := resp.VmInfo; vm != nil {
+		result.Namespace, result.Name, result.CurrentState = vm.Namespace, vm.Name, vm.CurrentState
+	}
+	// La simulazione riuscita è un 200 anche quando il wake sarebbe rifiutato
+	writeJSON(w, http.StatusOK, result)
+}
+
+// writeWakeResponse writes the outcome of a wake, with the HTTP code of its status
+func writeWakeResponse(w http.ResponseWriter, namespace, name string, resp *wolv1.WOLEventResponse) {
+	code := http.StatusOK
+	switch resp.Status {
+	case wolv1.ResponseStatus_VM_NOT_FOUND:
+		code = http.StatusNotFound
+	case wolv1.ResponseStatus_ERROR:
+		code = http.StatusInternalServerError
+	case wolv1.ResponseStatus_SECURE_ON_PASSWORD_MISSING, wolv1.ResponseStatus_POLICY_REJECTED:
+		code = http.StatusConflict
+	}
+	body := apiWakeResponse{
+		Status:    resp.Status.String(),
+		Message:   resp.Message,
+		Namespace: namespace,
+		Name:      name,
+	}
+	for _, dep := range resp.Dependencies {
+		body.Dependencies = append(body.Dependencies, apiWakeResponse{
+			Status:    dep.Status.String(),
+			Message:   dep.Message,
+			Namespace: dep.Namespace,
+			Name:      dep.Name,
+		})
+	}
+	writeJSON(w, code, body)
+}
+
+func (s *APIServer) serveMappings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "use GET"})
+		return
+	}
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	configName := r.URL.Query().Get("wolconfig")
+	attrs := authorizationv1.ResourceAttributes{Group: "wol.pillon.org", Resource: "wolconfigs", Verb: "list"}
+	if configName != "" {
+		attrs.Verb, attrs.Name = "get", configName
+	}
+	if allowed, ok := s.allowed(w, r, user, attrs); !ok {
+		return
+	} else if !allowed {
+		writeForbidden(w, user, attrs)
+		return
+	}
+
+	var filter macKey
+	filterMAC := false
+	if mac := r.URL.Query().Get("mac"); mac != "" {
+		if filter, filterMAC = parseMACKey(mac); !filterMAC {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: fmt.Sprintf("invalid MAC %q", mac)})
+			return
+		}
+	}
+
+	mappings := []apiMapping{}
+	for _, mapping := range s.mapper.Mappings(configName) {
+		if !filterMAC || mapping.MAC == filter.String() {
+			mappings = append(mappings, apiMapping{
+				MAC:         mapping.MAC,
+				Namespace:   mapping.Namespace,
+				Name:        mapping.Name,
+				WolConfig:   mapping.ConfigName,
+				MappingType: mapping.MappingType,
+			})
+		}
+	}
+	writeJSON(w, http.StatusOK, mappings)
+}
+
+// authenticate returns the user of the bearer token of the request, or writes
+// the error response
+func (s *APIServer) authenticate(w http.ResponseWriter, r *http.Request) (authenticationv1.UserInfo, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || strings.TrimSpace(token) == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(w, http.StatusUnauthorized, apiError{Error: "bearer token required"})
+		return authenticationv1.UserInfo{}, false
+	}
+	user, err := s.reviewer.Authenticate(r.Context(), strings.TrimSpace(token))
+	if errors.Is(err, errUnauthenticated) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(w, http.StatusUnauthorized, apiError{Error: err.Error()})
+		return authenticationv1.UserInfo{}, false
+	}
+	if err != nil {
+		s.log.Error(err, "Failed to authenticate an API request", "path", r.URL.Path)
+		ErrorsTotal.Inc()
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "authentication failed"})
+		return authenticationv1.UserInfo{}, false
+	}
+	return user, true
+}
+
+// allowed returns whether the user is allowed attrs; ok is false, and the
+// error response written, if the access review failed
+func (s *APIServer) allowed(w http.ResponseWriter, r *http.Request, user authenticationv1.UserInfo,
+	attrs authorizationv1.ResourceAttributes) (allowed, ok bool) {
+	allowed, err := s.reviewer.Authorize(r.Context(), user, attrs)
+	if err != nil {
+		s.log.Error(err, "Failed to authorize an API request", "path", r.URL.Path, "user", user.Username)
+		ErrorsTotal.Inc()
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "authorization failed"})
+		return false, false
+	}
+	if !allowed {
+		s.log.Info("API request denied", "path", r.URL.Path, "user", user.Username,
+			"verb", attrs.Verb, "resource", attrs.Resource, "namespace", attrs.Namespace, "name", attrs.Name)
+	}
+	return allowed, true
+}
+
+func writeForbidden(w http.ResponseWriter, user authenticationv1.UserInfo, attrs authorizationv1.ResourceAttributes) {
+	resource := attrs.Resource
+	if attrs.Subresource != "" {
+		resource += "/" + attrs.Subresource
+	}
+	target := attrs.Name
+	if attrs.Namespace != "" {
+		target = attrs.Namespace + "/" + attrs.Name
+	}
+	writeJSON(w, http.StatusForbidden, apiError{Error: strings.TrimSpace(fmt.Sprintf("user %q cannot %s %s %s",
+		user.Username, attrs.Verb, resource, target))})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// apiReviewer accepts the tokens of users and allows them the listed
+// <verb> <resource> <namespace>/<name> attributes
+type apiReviewer struct {
+	users   map[string]string
+	allowed map[string][]string
+}
+
+func (r *apiReviewer) Authenticate(_ context.Context, token string) (authenticationv1.UserInfo, error) {
+	user, ok := r.users[token]
+	if !ok {
+		return authenticationv1.UserInfo{}, errUnauthenticated
+	}
+	return authenticationv1.UserInfo{Username: user}, nil
+}
+
+func (r *apiReviewer) Authorize(_ context.Context, user authenticationv1.UserInfo,
+	attrs authorizationv1.ResourceAttributes) (bool, error) {
+	want := attrs.Verb + " " + attrs.Resource + " " + attrs.Namespace + "/" + attrs.Name
+	for _, allowed := range r.allowed[user.Username] {
+		if allowed == want {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func newTestAPI(t *testing.T) (http.Handler, *policyStarter) {
+	t.Helper()
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:01", VMName: "vm1", Namespace: "team-a"},
+				{MACAddress: "52:54:00:00:00:02", VMName: "vm2", Namespace: "team-b"},
+			},
+		},
+	}
+	config.Name = "lab"
+	mapper.UpdateConfig(config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	starter := &policyStarter{actions: make(map[string]string)}
+	reviewer := &apiReviewer{
+		users: map[string]string{"token-alice": "alice", "token-bob": "bob", "token-carol": "carol"},
+		allowed: map[string][]string{
+			"alice": {"update virtualmachines team-a/vm1", "list wolconfigs /"},
+			"bob":   {"get wolconfigs /lab", "create wolconfigs /lab"},
+			"carol": {"create wolconfigs /"},
+		},
+	}
+	api := NewAPIServer(mapper, NewAggregator(mapper, starter, logr.Discard()), reviewer, logr.Discard())
+	return api.Handler(), starter
+}
+
+func apiRequest(handler http.Handler, method, url, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIServer_Wake(t *testing.T) {
+	handler, starter := newTestAPI(t)
+
+	tests := []struct {
+		name, method, url, token string
+		wantCode                 int
+	}{
+		{"no token", http.MethodPost, "/api/v1/wake?mac=52:54:00:00:00:01", "", http.StatusUnauthorized},
+		{"invalid token", http.MethodPost, "/api/v1/wake?mac=52:54:00:00:00:01", "wrong", http.StatusUnauthorized},
+		{"wrong method", http.MethodGet, "/api/v1/wake?mac=52:54:00:00:00:01", "token-alice", http.StatusMethodNotAllowed},
+		{"missing target", http.MethodPost, "/api/v1/wake", "token-alice", http.StatusBadRequest},
+		{"unknown MAC", http.MethodPost, "/api/v1/wake?mac=aa:bb:cc:dd:ee:ff", "token-alice", http.StatusNotFound},
+		// Un MAC di una VM che l'utente non può avviare sembra sconosciuto
+		{"MAC of a forbidden VM", http.MethodPost, "/api/v1/wake?mac=52:54:00:00:00:02", "token-alice", http.StatusNotFound},
+		{"forbidden VM", http.MethodPost, "/api/v1/wake?namespace=team-b&name=vm2", "token-alice", http.StatusForbidden},
+		{"wake by MAC", http.MethodPost, "/api/v1/wake?mac=52-54-00-00-00-01", "token-alice", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := apiRequest(handler, tt.method, tt.url, tt.token)
+			if rec.Code != tt.wantCode {
+				t.Errorf("Expected %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	if len(starter.actions) != 1 || starter.actions["vm1"] != "start" {
+		t.Errorf("Expected only vm1 to be started, got %v", starter.actions)
+	}
+
+	// La seconda richiesta è deduplicata come un wake dell'activator
+	rec := apiRequest(handler, http.MethodPost, "/api/v1/wake?namespace=team-a&name=vm1", "token-alice")
+	var resp apiWakeResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || resp.Status != "DUPLICATE" || resp.Name != "vm1" {
+		t.Errorf("Expected the wake by name to be a duplicate, got %d %+v", rec.Code, resp)
+	}
+}
+
+func TestAPIServer_Mappings(t *testing.T) {
+	handler, _ := newTestAPI(t)
+
+	rec := apiRequest(handler, http.MethodGet, "/api/v1/mappings", "token-alice")
+	var mappings []apiMapping
+	if err := json.Unmarshal(rec.Body.Bytes(), &mappings); err != nil {
+		t.Fatalf("Unexpected body %q: %v", rec.Body.String(), err)
+	}
+	if len(mappings) != 2 || mappings[0].MAC != "52:54:00:00:00:01" || mappings[1].Name != "vm2" ||
+		mappings[0].WolConfig != "lab" || mappings[0].MappingType != MappingTypeExplicit {
+		t.Errorf("Expected both mappings sorted by MAC, got %+v", mappings)
+	}
+
+	// bob può leggere solo la WolConfig lab
+	if rec := apiRequest(handler, http.MethodGet, "/api/v1/mappings", "token-bob"); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected bob not to list every WolConfig, got %d", rec.Code)
+	}
+	rec = apiRequest(handler, http.MethodGet, "/api/v1/mappings?wolconfig=lab&mac=52:54:00:00:00:02", "token-bob")
+	mappings = nil
+	if err := json.Unmarshal(rec.Body.Bytes(), &mappings); err != nil {
+		t.Fatalf("Unexpected body %q: %v", rec.Body.String(), err)
+	}
+	if len(mappings) != 1 || mappings[0].Namespace != "team-b" {
+		t.Errorf("Expected the mapping of the MAC, got %+v", mappings)
+	}
+
+	if rec := apiRequest(handler, http.MethodGet, "/api/v1/mappings?mac=nope", "token-alice"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid MAC to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestAPIServer_Send(t *testing.T) {
+	handler, _ := newTestAPI(t)
+	conn, port := listenForward(t)
+
+	send := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/send", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Solo chi può inviare da ogni WolConfig può inviare dal manager
+	manager := fmt.Sprintf(`{"mac":"aa:bb:cc:dd:ee:ff","address":"127.0.0.1","port":%d}`, port)
+	if rec := send("token-bob", manager); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected bob not to send from the manager, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := send("token-bob", `{"mac":`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid body to be rejected, got %d", rec.Code)
+	}
+	rec := send("token-bob", `{"mac":"aa:bb:cc:dd:ee:ff","address":"10.0.0.255","node":"edge","wolConfig":"lab"}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without agent on the node, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := send("token-bob", `{"mac":"nope","address":"10.0.0.255","node":"edge","wolConfig":"lab"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid MAC to be rejected, got %d", rec.Code)
+	}
+
+	rec = send("token-carol", manager)
+	var resp apiSendResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Unexpected body %q: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusOK || resp.Sender != "manager" {
+		t.Errorf("Expected the manager to send the packet, got %d %+v", rec.Code, resp)
+	}
+	if mac, _ := readForward(t, conn); mac != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("Unexpected packet for %s", mac)
+	}
+}
+
+func TestAPIServer_Simulate(t *testing.T) {
+	handler, starter := newTestAPI(t)
+
+	simulate := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/simulate", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// La simulazione rivela la mapping di ogni MAC: serve il list delle WolConfig
+	if rec := simulate("token-bob", `{"mac":"52:54:00:00:00:01"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected bob not to simulate, got %d", rec.Code)
+	}
+	if rec := simulate("token-alice", `{"mac":"nope"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid MAC to be rejected, got %d", rec.Code)
+	}
+
+	rec := simulate("token-alice", `{"mac":"52:54:00:00:00:02"}`)
+	var resp apiSimulateResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Unexpected body %q: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusOK || resp.Status != "VM_START_INITIATED" || resp.Name != "vm2" ||
+		resp.WolConfig != "lab" || len(resp.Steps) == 0 {
+		t.Errorf("Expected vm2 to be woken, got %d %+v", rec.Code, resp)
+	}
+	if len(starter.actions) != 0 {
+		t.Errorf("Expected no VM to be started, got %v", starter.actions)
+	}
+}
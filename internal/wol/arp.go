@@ -0,0 +1,394 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+const (
+	// AnnotationIPAddresses lists (comma-separated) the IPv4 addresses of a VM that
+	// wake it when ARP-requested while it is stopped. IPv6 addresses are only
+	// used by the responder (ARPWakeSpec.Respond).
+	AnnotationIPAddresses = "wol.pillon.org/ip-addresses"
+
+	// ARPWakeSource is the source reported to the operator by ARP-triggered wakes
+	ARPWakeSource = "arp"
+
+	// etherTypeARP is the EtherType of ARP frames
+	etherTypeARP = 0x0806
+
+	defaultARPThreshold = 3
+	defaultARPWindow    = 10 * time.Second
+)
+
+// ARPTarget is an IP of a stopped VM that wakes it when ARP-requested.
+// With Respond the agents answer for the IP with MAC, the MAC of the VM.
+type ARPTarget struct {
+	IP        string
+	VM        VMInfo
+	Threshold int
+	Window    time.Duration
+	MAC       string
+	Respond   bool
+
+	// Wake triggers of the config of the VM (nil = none). With TriggersOnly
+	// the config has no ARPWake: ARP requests for the IP do not wake the VM.
+	Triggers     *wolv1beta1.WakeTriggersSpec
+	TriggersOnly bool
+}
+
+// ---------------------------------------------------------------------------
+// Manager side: computing the targets
+// ---------------------------------------------------------------------------
+
+// refreshARPTargets computes the ARP targets of the VMs of configs with ARPWake or WakeTriggers enabled.
+// The IPs of running VMIs are remembered so that they can be used once the VM is stopped.
+// VMs with SecureOn Require are skipped: an ARP request cannot carry the password.
+// The VMs are listed once from the manager cache instead of being read one by one.
+// IPv6 addresses are targets only for the responder, which answers with a MAC of the
+// VM in mapping (the one its VMI had with the IP, if known).
+func (m *MACMapper) refreshARPTargets(ctx context.Context, configs []wolv1beta1.WolConfig, vms map[string]VMInfo,
+	mapping *macStore) []ARPTarget {
+	enabled := make(map[string]*wolv1beta1.ARPWakeSpec)
+	triggers := make(map[string]*wolv1beta1.WakeTriggersSpec)
+	respond := false
+	for i := range configs {
+		if spec := configs[i].Spec.ARPWake; spec != nil && spec.Enabled {
+			enabled[configs[i].Name] = spec
+			respond = respond || spec.Respond
+		}
+		if spec := configs[i].Spec.WakeTriggers; WakeTriggersEnabled(spec) {
+			triggers[configs[i].Name] = spec
+		}
+	}
+	if len(enabled) == 0 && len(triggers) == 0 {
+		return nil
+	}
+	var vmMACs map[string][]string
+	if respond {
+		vmMACs = mappedVMMACs(mapping)
+	}
+
+	vmList := &kubevirtv1.VirtualMachineList{}
+	if err := m.client.List(ctx, vmList); err != nil {
+		m.log.Error(err, "Failed to list VMs, keeping the previous ARP targets")
+		return m.ARPTargets("")
+	}
+	stopped := make(map[string]*kubevirtv1.VirtualMachine)
+	for i := range vmList.Items {
+		vm := &vmList.Items[i]
+		if vmIsStopped(vm) {
+			stopped[vmIndexKey(vm.Namespace, vm.Name)] = vm
+		}
+	}
+
+	m.rememberVMIIPs(ctx, vms)
+
+	m.ipsMu.Lock()
+	defer m.ipsMu.Unlock()
+
+	var targets []ARPTarget
+	for key, info := range vms {
+		spec, trigger := enabled[info.ConfigName], triggers[info.ConfigName]
+		if (spec == nil && trigger == nil) || info.SecureOnPolicy == wolv1beta1.SecureOnPolicyRequire {
+			continue
+		}
+		triggersOnly := spec == nil
+		if triggersOnly {
+			spec = &wolv1beta1.ARPWakeSpec{}
+		}
+		vm := stopped[key]
+		if vm == nil {
+			continue
+		}
+
+		for _, ip := range mergeIPs(parseIPAnnotation(vm.Annotations[AnnotationIPAddresses]), m.knownIPs[key]) {
+			ipv6 := net.ParseIP(ip).To4() == nil
+			if ipv6 && !spec.Respond {
+				continue
+			}
+			target := ARPTarget{
+				IP:           ip,
+				VM:           info,
+				Threshold:    int(spec.Threshold),
+				Window:       time.Duration(spec.WindowSeconds) * time.Second,
+				Triggers:     trigger,
+				TriggersOnly: triggersOnly,
+			}
+			if spec.Respond {
+				target.MAC = m.knownMAC(key, ip, vmMACs[key])
+				target.Respond = target.MAC != ""
+			}
+			targets = append(targets, target)
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool { return targets[i].IP < targets[j].IP })
+	return targets
+}
+
+// rememberVMIIPs records the IP addresses of the running VMIs of managed VMs,
+// with the MAC of their interface, and forgets the VMs that are no longer managed
+func (m *MACMapper) rememberVMIIPs(ctx context.Context, vms map[string]VMInfo) {
+	vmiList := &kubevirtv1.VirtualMachineInstanceList{}
+	if err := m.client.List(ctx, vmiList); err != nil {
+		m.log.Error(err, "Failed to list VMIs, ARP wake will use the last known IPs")
+		return
+	}
+
+	m.ipsMu.Lock()
+	defer m.ipsMu.Unlock()
+
+	if m.knownIPs == nil {
+		m.knownIPs = make(map[string][]string)
+		m.knownIPMACs = make(map[string]map[string]string)
+	}
+	for i := range vmiList.Items {
+		vmi := &vmiList.Items[i]
+		key := vmIndexKey(vmi.Namespace, vmi.Name)
+		if _, managed := vms[key]; !managed {
+			continue
+		}
+		var ips []string
+		macs := make(map[string]string)
+		for _, iface := range vmi.Status.Interfaces {
+			ifaceIPs := mergeIPs(nil, append([]string{iface.IP}, iface.IPs...))
+			ips = mergeIPs(ips, ifaceIPs)
+			if mac, ok := parseMACKey(iface.MAC); ok {
+				for _, ip := range ifaceIPs {
+					macs[ip] = mac.String()
+				}
+			}
+		}
+		if len(ips) > 0 {
+			m.knownIPs[key] = ips
+			m.knownIPMACs[key] = macs
+		}
+	}
+	for key := range m.knownIPs {
+		if _, managed := vms[key]; !managed {
+			delete(m.knownIPs, key)
+			delete(m.knownIPMACs, key)
+		}
+	}
+}
+
+// mappedVMMACs returns the sorted MACs of each VM (<namespace>/<vm>) in mapping
+func mappedVMMACs(mapping *macStore) map[string][]string {
+	macs := make(map[string][]string)
+	mapping.Range(func(key macKey, info VMInfo) bool {
+		vmKey := vmIndexKey(info.Namespace, info.Name)
+		macs[vmKey] = append(macs[vmKey], key.String())
+		return true
+	})
+	for _, list := range macs {
+		sort.Strings(list)
+	}
+	return macs
+}
+
+// knownMAC returns the MAC the VM key answers with for ip: the one of the VMI
+// interface that had the IP if it is still mapped, otherwise the first mapped MAC.
+// Must be called with ipsMu held.
+func (m *MACMapper) knownMAC(key, ip string, mapped []string) string {
+	if mac := m.knownIPMACs[key][ip]; mac != "" && slices.Contains(mapped, mac) {
+		return mac
+	}
+	if len(mapped) > 0 {
+		return mapped[0]
+	}
+	return ""
+}
+
+// ARPTargets returns the ARP targets computed during the last refresh
+// (only those of the given WolConfig if configName is not empty)
+func (m *MACMapper) ARPTargets(configName string) []ARPTarget {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var result []ARPTarget
+	for _, target := range m.arpTargets {
+		if configName == "" || target.VM.ConfigName == configName {
+			result = append(result, target)
+		}
+	}
+	return result
+}
+
+// vmIsStopped returns true if the VM is neither running nor on its way up
+func vmIsStopped(vm *kubevirtv1.VirtualMachine) bool {
+	if vm.Status.Ready {
+		return false
+	}
+	switch vm.Status.PrintableStatus {
+	case "", kubevirtv1.VirtualMachineStatusStopped:
+		return true
+	}
+	return false
+}
+
+// parseIPAnnotation parses the comma-separated IP addresses of AnnotationIPAddresses
+func parseIPAnnotation(value string) []string {
+	var ips []string
+	for _, part := range strings.Split(value, ",") {
+		ips = mergeIPs(ips, []string{strings.TrimSpace(part)})
+	}
+	return ips
+}
+
+// mergeIPs appends the valid, not yet present unicast IP addresses of add to ips.
+// ARP only resolves IPv4: IPv6 addresses are kept for the neighbor solicitations.
+func mergeIPs(ips []string, add []string) []string {
+	for _, candidate := range add {
+		ip := net.ParseIP(candidate)
+		if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsMulticast() {
+			continue
+		}
+		s := ip.String()
+		found := false
+		for _, existing := range ips {
+			if existing == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ips = append(ips, s)
+		}
+	}
+	return ips
+}
+
+// GetARPTargets implementa il metodo gRPC usato dagli agent con arp wake o trigger abilitati
+func (a *Aggregator) GetARPTargets(_ context.Context, req *wolv1.ARPTargetsRequest) (*wolv1.ARPTargetsResponse, error) {
+	targets := a.mapper.agentARPTargets(req.WolConfig)
+	resp := &wolv1.ARPTargetsResponse{Targets: make([]*wolv1.ARPTarget, 0, len(targets))}
+	for _, target := range targets {
+		t := &wolv1.ARPTarget{
+			Ip:            target.IP,
+			Namespace:     target.VM.Namespace,
+			Name:          target.VM.Name,
+			Threshold:     uint32(target.Threshold),
+			WindowSeconds: uint32(target.Window.Seconds()),
+			MacAddress:    target.MAC,
+			Respond:       target.Respond,
+			TriggersOnly:  target.TriggersOnly,
+		}
+		if spec := target.Triggers; spec != nil {
+			t.IcmpEcho, t.Dns = spec.ICMPEcho, spec.DNS
+			for _, port := range spec.TCPPorts {
+				t.TcpPorts = append(t.TcpPorts, uint32(port))
+			}
+		}
+		resp.Targets = append(resp.Targets, t)
+	}
+	a.log.V(1).Info("ARP targets requested", "node", req.NodeName, "wolconfig", req.WolConfig, "targets", len(resp.Targets))
+	return resp, nil
+}
+
+// ---------------------------------------------------------------------------
+// Agent side: parsing ARP requests and confirming wakes
+// ---------------------------------------------------------------------------
+
+// ARPRequest is an ARP who-has request seen on the wire
+type ARPRequest struct {
+	SenderMAC net.HardwareAddr
+	SenderIP  net.IP
+	TargetIP  net.IP
+}
+
+// parseARPRequest parses the payload of an Ethernet/IPv4 ARP request.
+// Returns false for replies, ARP probes (sender 0.0.0.0) and gratuitous ARP,
+// which are sent by a host about its own address and must not wake anything.
+func parseARPRequest(payload []byte) (ARPRequest, bool) {
+	if len(payload) < 28 {
+		return ARPRequest{}, false
+	}
+	htype := binary.BigEndian.Uint16(payload[0:2])
+	ptype := binary.BigEndian.Uint16(payload[2:4])
+	hlen, plen := payload[4], payload[5]
+	op := binary.BigEndian.Uint16(payload[6:8])
+	if htype != 1 || ptype != 0x0800 || hlen != 6 || plen != 4 || op != 1 {
+		return ARPRequest{}, false
+	}
+
+	req := ARPRequest{
+		SenderMAC: net.HardwareAddr(append([]byte{}, payload[8:14]...)),
+		SenderIP:  net.IP(append([]byte{}, payload[14:18]...)),
+		TargetIP:  net.IP(append([]byte{}, payload[24:28]...)),
+	}
+	if req.SenderIP.IsUnspecified() || req.SenderIP.Equal(req.TargetIP) {
+		return ARPRequest{}, false
+	}
+	return req, true
+}
+
+// arpWakeTracker counts ARP requests per target IP and confirms a wake once
+// threshold requests have been seen within the window
+type arpWakeTracker struct {
+	mu   sync.Mutex
+	hits map[string][]time.Time
+}
+
+func newARPWakeTracker() *arpWakeTracker {
+	return &arpWakeTracker{hits: make(map[string][]time.Time)}
+}
+
+// observe records a request for ip and returns true when it reaches the threshold
+func (t *arpWakeTracker) observe(ip string, threshold int, window time.Duration, now time.Time) bool {
+	if threshold <= 0 {
+		threshold = defaultARPThreshold
+	}
+	if window <= 0 {
+		window = defaultARPWindow
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hits := append(pruneWakes(t.hits[ip], now.Add(-window)), now)
+	if len(hits) >= threshold {
+		delete(t.hits, ip)
+		return true
+	}
+	t.hits[ip] = hits
+	return false
+}
+
+// cleanup drops the IPs without requests in the last window
+func (t *arpWakeTracker) cleanup(window time.Duration, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for ip, hits := range t.hits {
+		if len(pruneWakes(hits, now.Add(-window))) == 0 {
+			delete(t.hits, ip)
+		}
+	}
+}
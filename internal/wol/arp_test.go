@@ -0,0 +1,237 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// buildARPPayload creates an Ethernet/IPv4 ARP payload
+func buildARPPayload(op uint16, senderIP, targetIP string) []byte {
+	payload := []byte{0x00, 0x01, 0x08, 0x00, 6, 4, byte(op >> 8), byte(op)}
+	payload = append(payload, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01)
+	payload = append(payload, net.ParseIP(senderIP).To4()...)
+	payload = append(payload, make([]byte, 6)...)
+	payload = append(payload, net.ParseIP(targetIP).To4()...)
+	return payload
+}
+
+func TestParseARPRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		valid   bool
+	}{
+		{"request", buildARPPayload(1, "10.0.0.1", "10.0.0.20"), true},
+		{"reply", buildARPPayload(2, "10.0.0.1", "10.0.0.20"), false},
+		{"probe", buildARPPayload(1, "0.0.0.0", "10.0.0.20"), false},
+		{"gratuitous", buildARPPayload(1, "10.0.0.20", "10.0.0.20"), false},
+		{"truncated", buildARPPayload(1, "10.0.0.1", "10.0.0.20")[:20], false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, ok := parseARPRequest(tt.payload)
+			if ok != tt.valid {
+				t.Fatalf("Expected valid=%v, got %v", tt.valid, ok)
+			}
+			if ok && (req.TargetIP.String() != "10.0.0.20" || req.SenderIP.String() != "10.0.0.1") {
+				t.Errorf("Unexpected request %+v", req)
+			}
+		})
+	}
+}
+
+func TestARPWakeTracker_Threshold(t *testing.T) {
+	tracker := newARPWakeTracker()
+	start := time.Unix(1700000000, 0)
+
+	// Requests spread beyond the window never confirm a wake
+	for i := 0; i < 5; i++ {
+		if tracker.observe("10.0.0.20", 3, 10*time.Second, start.Add(time.Duration(i)*6*time.Second)) {
+			t.Fatalf("Unexpected wake at request %d", i)
+		}
+	}
+
+	now := start.Add(time.Minute)
+	if tracker.observe("10.0.0.20", 3, 10*time.Second, now) ||
+		tracker.observe("10.0.0.20", 3, 10*time.Second, now.Add(time.Second)) {
+		t.Fatal("Unexpected wake below threshold")
+	}
+	if !tracker.observe("10.0.0.20", 3, 10*time.Second, now.Add(2*time.Second)) {
+		t.Fatal("Expected wake at threshold")
+	}
+	if tracker.observe("10.0.0.20", 3, 10*time.Second, now.Add(3*time.Second)) {
+		t.Error("Expected the counter to restart after a wake")
+	}
+
+	tracker.cleanup(10*time.Second, now.Add(time.Minute))
+	if len(tracker.hits) != 0 {
+		t.Errorf("Expected cleanup to drop stale IPs, got %d", len(tracker.hits))
+	}
+}
+
+func TestMergeIPs(t *testing.T) {
+	got := mergeIPs(parseIPAnnotation(" 10.0.0.20, fd00::20,bogus"), []string{"10.0.0.20", "10.0.0.21", "127.0.0.1", "ff02::1", ""})
+	if len(got) != 3 || got[0] != "10.0.0.20" || got[1] != "fd00::20" || got[2] != "10.0.0.21" {
+		t.Errorf("Unexpected IPs %v", got)
+	}
+}
+
+func TestRefreshARPTargets(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := kubevirtv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := &kubevirtv1.VirtualMachine{}
+	stopped.Name, stopped.Namespace = "stopped", "default"
+	stopped.Annotations = map[string]string{AnnotationIPAddresses: "10.0.0.20"}
+	stopped.Status.PrintableStatus = kubevirtv1.VirtualMachineStatusStopped
+
+	// SecureOn Require VMs are never ARP targets
+	secure := &kubevirtv1.VirtualMachine{}
+	secure.Name, secure.Namespace = "secure", "default"
+	secure.Annotations = map[string]string{AnnotationIPAddresses: "10.0.0.40"}
+	secure.Status.PrintableStatus = kubevirtv1.VirtualMachineStatusStopped
+
+	running := &kubevirtv1.VirtualMachine{}
+	running.Name, running.Namespace = "running", "default"
+	running.Status.Ready = true
+	running.Status.PrintableStatus = kubevirtv1.VirtualMachineStatusRunning
+
+	vmi := &kubevirtv1.VirtualMachineInstance{}
+	vmi.Name, vmi.Namespace = "running", "default"
+	vmi.Status.Interfaces = []kubevirtv1.VirtualMachineInstanceNetworkInterface{{IP: "10.0.0.30"}}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(stopped, secure, running, vmi).Build()
+	mapper := NewMACMapper(c, logr.Discard())
+
+	configs := []wolv1beta1.WolConfig{{
+		Spec: wolv1beta1.WolConfigSpec{
+			ARPWake: &wolv1beta1.ARPWakeSpec{Enabled: true, Threshold: 2, WindowSeconds: 5},
+		},
+	}}
+	configs[0].Name = "arp"
+	vms := map[string]VMInfo{
+		"default/stopped": {Name: "stopped", Namespace: "default", ConfigName: "arp"},
+		"default/running": {Name: "running", Namespace: "default", ConfigName: "arp"},
+		"default/secure": {Name: "secure", Namespace: "default", ConfigName: "arp",
+			SecureOnPolicy: wolv1beta1.SecureOnPolicyRequire},
+	}
+
+	targets := mapper.refreshARPTargets(context.Background(), configs, vms, newMACStore())
+	if len(targets) != 1 || targets[0].IP != "10.0.0.20" || targets[0].VM.Name != "stopped" {
+		t.Fatalf("Unexpected targets %+v", targets)
+	}
+	if targets[0].Threshold != 2 || targets[0].Window != 5*time.Second {
+		t.Errorf("Unexpected threshold/window %d/%s", targets[0].Threshold, targets[0].Window)
+	}
+
+	// Once stopped, the running VM is reachable through the IP its VMI had
+	if err := c.Delete(context.Background(), vmi); err != nil {
+		t.Fatal(err)
+	}
+	running.Status.Ready = false
+	running.Status.PrintableStatus = kubevirtv1.VirtualMachineStatusStopped
+	if err := c.Update(context.Background(), running); err != nil {
+		t.Fatal(err)
+	}
+
+	targets = mapper.refreshARPTargets(context.Background(), configs, vms, newMACStore())
+	if len(targets) != 2 || targets[1].IP != "10.0.0.30" || targets[1].VM.Name != "running" {
+		t.Errorf("Expected the remembered VMI IP, got %+v", targets)
+	}
+}
+
+func TestRefreshARPTargets_Respond(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := kubevirtv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	vm := &kubevirtv1.VirtualMachine{}
+	vm.Name, vm.Namespace = "vm1", "default"
+	vm.Status.Ready = true
+	vm.Status.PrintableStatus = kubevirtv1.VirtualMachineStatusRunning
+	vmi := &kubevirtv1.VirtualMachineInstance{}
+	vmi.Name, vmi.Namespace = "vm1", "default"
+	vmi.Status.Interfaces = []kubevirtv1.VirtualMachineInstanceNetworkInterface{
+		{MAC: "52:54:00:00:00:01", IP: "10.0.0.20"},
+		{MAC: "52:54:00:00:00:02", IPs: []string{"10.0.1.20", "fd00::20"}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(vm, vmi).Build()
+	mapper := NewMACMapper(c, logr.Discard())
+
+	configs := []wolv1beta1.WolConfig{{
+		Spec: wolv1beta1.WolConfigSpec{ARPWake: &wolv1beta1.ARPWakeSpec{Enabled: true, Respond: true}},
+	}}
+	configs[0].Name = "arp"
+	info := VMInfo{Name: "vm1", Namespace: "default", ConfigName: "arp"}
+	vms := map[string]VMInfo{"default/vm1": info}
+	mapping := newMACStore()
+	for _, mac := range []string{"52:54:00:00:00:02", "52:54:00:00:00:01"} {
+		key, _ := parseMACKey(mac)
+		mapping.Set(key, info)
+	}
+
+	// VM accesa: gli IP della VMI sono ricordati, nessun target
+	if targets := mapper.refreshARPTargets(context.Background(), configs, vms, mapping); len(targets) != 0 {
+		t.Fatalf("Expected no targets for a running VM, got %+v", targets)
+	}
+
+	if err := c.Delete(context.Background(), vmi); err != nil {
+		t.Fatal(err)
+	}
+	vm.Status.Ready = false
+	vm.Status.PrintableStatus = kubevirtv1.VirtualMachineStatusStopped
+	if err := c.Update(context.Background(), vm); err != nil {
+		t.Fatal(err)
+	}
+
+	targets := mapper.refreshARPTargets(context.Background(), configs, vms, mapping)
+	want := map[string]string{
+		"10.0.0.20": "52:54:00:00:00:01",
+		"10.0.1.20": "52:54:00:00:00:02",
+		"fd00::20":  "52:54:00:00:00:02",
+	}
+	if len(targets) != len(want) {
+		t.Fatalf("Expected the IPv4 and IPv6 targets, got %+v", targets)
+	}
+	for _, target := range targets {
+		if !target.Respond || target.MAC != want[target.IP] {
+			t.Errorf("Expected %s to be answered with %s, got %+v", target.IP, want[target.IP], target)
+		}
+	}
+
+	// Senza Respond gli IPv6 non sono target
+	configs[0].Spec.ARPWake.Respond = false
+	targets = mapper.refreshARPTargets(context.Background(), configs, vms, mapping)
+	if len(targets) != 2 || targets[0].Respond || targets[0].MAC != "" {
+		t.Errorf("Expected the IPv4 wake targets only, got %+v", targets)
+	}
+}
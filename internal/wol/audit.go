@@ -0,0 +1,605 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// The aggregator writes an audit record of every decision on a packet or a
+// wake request matched by a WolConfig with spec.audit, to the file and the
+// webhook of the config. Duplicates answered from the dedupe cache and the
+// events handled by another replica are not decisions of this replica, so
+// they are not recorded.
+
+const (
+	// AuditDecisionAccepted, AuditDecisionRejected, AuditDecisionFailed and
+	// AuditDecisionDryRun (accepted by a WolConfig in dry-run, not acted on)
+	// are the decisions of the audit records
+	AuditDecisionAccepted = "accepted"
+	AuditDecisionRejected = "rejected"
+	AuditDecisionFailed   = "failed"
+	AuditDecisionDryRun   = "dryRun"
+
+	auditSinkFile    = "file"
+	auditSinkWebhook = "webhook"
+
+	defaultAuditMaxSizeMB = 100
+	// auditBatchSize and auditFlushInterval bound the records held before a
+	// webhook post
+	auditBatchSize     = 100
+	auditFlushInterval = time.Second
+	// auditQueueSize bounds the records waiting for a webhook: further ones are dropped
+	auditQueueSize = 4096
+	// auditPostTimeout bounds a webhook post
+	auditPostTimeout = 10 * time.Second
+)
+
+// AuditRecord is the JSON record of a wake or sleep decision
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	WolConfig string    `json:"wolconfig"`
+	// Action is wake or sleep
+	Action string `json:"action"`
+	// Decision is accepted, rejected or failed
+	Decision string `json:"decision"`
+	// Status is the status of the response, e.g. POLICY_REJECTED
+	Status string `json:"status"`
+	// Reason is the message of the response
+	Reason    string `json:"reason"`
+	MAC       string `json:"mac,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	VM        string `json:"vm,omitempty"`
+	// Source is packet, relay, or the source of a wake request (api, mqtt, arp...)
+	Source string `json:"source"`
+	// Node is the node of the agent or the relay that reported a packet
+	Node     string `json:"node,omitempty"`
+	SourceIP string `json:"sourceIP,omitempty"`
+	// Trigger describes what triggered a wake request, e.g. "mqtt message on <topic>"
+	Trigger   string `json:"trigger,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+// auditDecision classifies the status of a response
+func auditDecision(status wolv1.ResponseStatus) string {
+	switch status {
+	case wolv1.ResponseStatus_VM_START_INITIATED, wolv1.ResponseStatus_VM_ALREADY_RUNNING,
+		wolv1.ResponseStatus_VM_STOP_INITIATED, wolv1.ResponseStatus_FORWARDED,
+		wolv1.ResponseStatus_ACCEPTED: // start fallito ma in coda per un retry
+		return AuditDecisionAccepted
+	case wolv1.ResponseStatus_ERROR:
+		return AuditDecisionFailed
+	case wolv1.ResponseStatus_DRY_RUN:
+		return AuditDecisionDryRun
+	default:
+		return AuditDecisionRejected
+	}
+}
+
+// SetAuditor enables the audit records of the wake decisions
+func (a *Aggregator) SetAuditor(auditor *Auditor) {
+	a.auditor = auditor
+}
+
+// auditEvent records the decision on a packet reported by an agent or a relay
+func (a *Aggregator) auditEvent(ctx context.Context, event *wolv1.WOLEvent, vmInfo VMInfo, sleep bool, resp *wolv1.WOLEventResponse) {
+	if a.auditor == nil {
+		return
+	}
+	record := newAuditRecord(vmInfo, resp)
+	record.MAC = normalizeMACAddress(event.MacAddress)
+	record.Source = "packet"
+	if _, fromRelay := relayFromContext(ctx); fromRelay {
+		record.Source = "relay"
+	}
+	record.Node = event.NodeName
+	record.SourceIP = event.SourceIp
+	if sleep {
+		record.Action = "sleep"
+	}
+	a.auditor.Write(record)
+}
+
+// auditRequest records the decision on a wake request
+func (a *Aggregator) auditRequest(req *wolv1.WakeRequest, vmInfo VMInfo, resp *wolv1.WOLEventResponse) {
+	if a.auditor == nil {
+		return
+	}
+	record := newAuditRecord(vmInfo, resp)
+	record.Source = req.Source
+	record.Trigger = req.Reason
+	a.auditor.Write(record)
+}
+
+func newAuditRecord(vmInfo VMInfo, resp *wolv1.WOLEventResponse) AuditRecord {
+	return AuditRecord{
+		Time:      time.Now().UTC(),
+		WolConfig: vmInfo.ConfigName,
+		Action:    "wake",
+		Decision:  auditDecision(resp.Status),
+		Status:    resp.Status.String(),
+		Reason:    resp.Message,
+		Namespace: vmInfo.Namespace,
+		VM:        vmInfo.Name,
+		LatencyMs: resp.ProcessingTimeMs,
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Configuration
+// ---------------------------------------------------------------------------
+
+// auditSinks are the audit sinks of a WolConfig with their Secrets read.
+// It is comparable: a change of the spec or of the Secrets restarts the sinks.
+type auditSinks struct {
+	File    auditFileConfig
+	Webhook auditWebhookConfig
+}
+
+// auditFileConfig is an audit file, disabled if Path is empty
+type auditFileConfig struct {
+	Path       string
+	MaxBytes   int64
+	MaxBackups int
+}
+
+// auditWebhookConfig is an audit webhook, disabled if URL is empty
+type auditWebhookConfig struct {
+	URL   string
+	Token string
+	CA    string // PEM, "" for the system CAs
+}
+
+// loadAudit resolves the audit sinks of a config, reading their Secrets.
+// Returns false if the config has no audit.
+func (m *MACMapper) loadAudit(ctx context.Context, config *wolv1beta1.WolConfig) (auditSinks, bool, error) {
+	spec := config.Spec.Audit
+	if spec == nil || (spec.File == nil && spec.Webhook == nil) {
+		return auditSinks{}, false, nil
+	}
+
+	var sinks auditSinks
+	if file := spec.File; file != nil {
+		if !strings.HasPrefix(file.Path, "/") {
+			return auditSinks{}, false, fmt.Errorf("audit file path %q is not absolute", file.Path)
+		}
+		sizeMB := file.MaxSizeMB
+		if sizeMB <= 0 {
+			sizeMB = defaultAuditMaxSizeMB // same default as the CRD
+		}
+		sinks.File = auditFileConfig{
+			Path:       file.Path,
+			MaxBytes:   int64(sizeMB) << 20,
+			MaxBackups: int(max(file.MaxBackups, 0)),
+		}
+	}
+	if webhook := spec.Webhook; webhook != nil {
+		if !strings.HasPrefix(webhook.URL, "http://") && !strings.HasPrefix(webhook.URL, "https://") {
+			return auditSinks{}, false, fmt.Errorf("invalid audit webhook URL %q", webhook.URL)
+		}
+		sinks.Webhook.URL = webhook.URL
+		if ref := webhook.BearerTokenSecretRef; ref != nil {
+			token, err := m.readSecretKey(ctx, *ref)
+			if err != nil {
+				return auditSinks{}, false, fmt.Errorf("audit webhook token: %w", err)
+			}
+			sinks.Webhook.Token = strings.TrimSpace(string(token))
+		}
+		if ref := webhook.CASecretRef; ref != nil {
+			ca, err := m.readSecretKey(ctx, *ref)
+			if err != nil {
+				return auditSinks{}, false, fmt.Errorf("audit webhook CA: %w", err)
+			}
+			if !x509.NewCertPool().AppendCertsFromPEM(ca) {
+				return auditSinks{}, false, fmt.Errorf("audit webhook CA secret %s/%s holds no PEM certificate", ref.Namespace, ref.Name)
+			}
+			sinks.Webhook.CA = string(ca)
+		}
+	}
+	return sinks, true, nil
+}
+
+// auditConfigs returns the audit sinks by WolConfig
+func (m *MACMapper) auditConfigs() map[string]auditSinks {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return maps.Clone(m.audits)
+}
+
+// ---------------------------------------------------------------------------
+// Auditor
+// ---------------------------------------------------------------------------
+
+// Auditor keeps the audit sinks of the WolConfigs in sync with the mapping
+// and writes the records of the aggregator to them
+type Auditor struct {
+	mapper *MACMapper
+	log    logr.Logger
+
+	mu     sync.RWMutex
+	routes map[string]auditRoute // chiave: WolConfig
+}
+
+// auditRoute are the sinks the records of a WolConfig are written to
+type auditRoute struct {
+	file    *auditFile
+	webhook *auditWebhook
+}
+
+// NewAuditor creates the audit sinks of the manager
+func NewAuditor(mapper *MACMapper, log logr.Logger) *Auditor {
+	return &Auditor{mapper: mapper, log: log}
+}
+
+// Write writes a record to the sinks of its WolConfig, if any. The file is
+// written synchronously, the webhook records are queued.
+func (a *Auditor) Write(record AuditRecord) {
+	a.mu.RLock()
+	route, ok := a.routes[record.WolConfig]
+	a.mu.RUnlock()
+	if !ok {
+		return
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		a.log.Error(err, "Failed to encode audit record", "config", record.WolConfig)
+		ErrorsTotal.Inc()
+		return
+	}
+	line = append(line, '\n')
+
+	if route.file != nil {
+		if err := route.file.write(line); err != nil {
+			a.log.Error(err, "Failed to write audit record", "config", record.WolConfig, "path", route.file.config.Path)
+			ErrorsTotal.Inc()
+			AuditRecordsTotal.WithLabelValues(record.WolConfig, auditSinkFile, "dropped").Inc()
+		} else {
+			AuditRecordsTotal.WithLabelValues(record.WolConfig, auditSinkFile, "written").Inc()
+		}
+	}
+	if route.webhook != nil {
+		route.webhook.enqueue(line)
+	}
+}
+
+// Run keeps the sinks open until ctx is done, reopening the ones changed by
+// every mapping refresh. WolConfigs with the same file path share the file,
+// with the rotation settings of the first of them by name.
+func (a *Auditor) Run(ctx context.Context) {
+	files := make(map[string]*auditFile)       // chiave: path
+	webhooks := make(map[string]*auditWebhook) // chiave: WolConfig
+	defer func() {
+		a.mu.Lock()
+		a.routes = nil
+		a.mu.Unlock()
+		for _, f := range files {
+			f.close()
+		}
+		for _, w := range webhooks {
+			w.stop()
+		}
+	}()
+
+	for {
+		changed := a.mapper.mappingChange()
+		desired := a.mapper.auditConfigs()
+
+		fileConfigs := make(map[string]auditFileConfig)
+		for _, name := range slices.Sorted(maps.Keys(desired)) {
+			if file := desired[name].File; file.Path != "" {
+				if _, ok := fileConfigs[file.Path]; !ok {
+					fileConfigs[file.Path] = file
+				}
+			}
+		}
+		for path, f := range files {
+			if config, ok := fileConfigs[path]; !ok || config != f.config {
+				f.close()
+				delete(files, path)
+			}
+		}
+		for path, config := range fileConfigs {
+			if _, ok := files[path]; ok {
+				continue
+			}
+			f, err := openAuditFile(config)
+			if err != nil {
+				a.log.Error(err, "Failed to open audit file", "path", path)
+				ErrorsTotal.Inc()
+				continue
+			}
+			files[path] = f
+		}
+
+		for name, w := range webhooks {
+			if sinks, ok := desired[name]; !ok || sinks.Webhook != w.config {
+				w.stop()
+				delete(webhooks, name)
+			}
+		}
+		for name, sinks := range desired {
+			if _, ok := webhooks[name]; ok || sinks.Webhook.URL == "" {
+				continue
+			}
+			w, err := newAuditWebhook(name, sinks.Webhook, a.log.WithValues("config", name))
+			if err != nil {
+				a.log.Error(err, "Failed to set up audit webhook", "config", name)
+				ErrorsTotal.Inc()
+				continue
+			}
+			w.start(ctx)
+			webhooks[name] = w
+		}
+
+		routes := make(map[string]auditRoute, len(desired))
+		for name, sinks := range desired {
+			route := auditRoute{file: files[sinks.File.Path], webhook: webhooks[name]}
+			if route.file != nil || route.webhook != nil {
+				routes[name] = route
+			}
+		}
+		a.mu.Lock()
+		a.routes = routes
+		a.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// File sink
+// ---------------------------------------------------------------------------
+
+var errAuditFileClosed = errors.New("audit file closed")
+
+// auditFile appends records to a file, rotating it by size
+type auditFile struct {
+	config auditFileConfig
+
+	mu   sync.Mutex
+	file *os.File // nil once closed or if a rotation failed to reopen it
+	size int64
+}
+
+func openAuditFile(config auditFileConfig) (*auditFile, error) {
+	f := &auditFile{config: config}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *auditFile) open() error {
+	file, err := os.OpenFile(f.config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// write appends a line, rotating the file first if the line would exceed its size
+func (f *auditFile) write(line []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return errAuditFileClosed
+	}
+	if f.size > 0 && f.size+int64(len(line)) > f.config.MaxBytes {
+		if err := f.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate %s: %w", f.config.Path, err)
+		}
+	}
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return err
+}
+
+// rotate shifts <path>.1 ... <path>.<maxBackups-1> by one, moves the file to
+// <path>.1 (or removes it without backups) and opens a new one
+func (f *auditFile) rotate() error {
+	_ = f.file.Close()
+	f.file = nil
+
+	path := f.config.Path
+	if f.config.MaxBackups == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	} else {
+		for i := f.config.MaxBackups - 1; i >= 1; i-- {
+			err := os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if err := os.Rename(path, path+".1"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return f.open()
+}
+
+func (f *auditFile) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		_ = f.file.Close()
+		f.file = nil
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Webhook sink
+// ---------------------------------------------------------------------------
+
+// auditWebhook posts the queued records of a WolConfig in batches
+type auditWebhook struct {
+	configName string
+	config     auditWebhookConfig
+	client     *http.Client
+	log        logr.Logger
+
+	records chan []byte
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func newAuditWebhook(configName string, config auditWebhookConfig, log logr.Logger) (*auditWebhook, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.CA != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(config.CA)) {
+			return nil, errors.New("the CA holds no PEM certificate")
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	return &auditWebhook{
+		configName: configName,
+		config:     config,
+		client:     &http.Client{Transport: transport, Timeout: auditPostTimeout},
+		log:        log,
+		records:    make(chan []byte, auditQueueSize),
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// enqueue queues a record, dropping it if the queue is full
+func (w *auditWebhook) enqueue(line []byte) {
+	select {
+	case w.records <- line:
+	default:
+		AuditRecordsTotal.WithLabelValues(w.configName, auditSinkWebhook, "dropped").Inc()
+	}
+}
+
+func (w *auditWebhook) start(ctx context.Context) {
+	ctx, w.cancel = context.WithCancel(ctx)
+	go func() {
+		defer close(w.done)
+		w.run(ctx)
+	}()
+}
+
+// stop posts the queued records and waits for the sink to exit
+func (w *auditWebhook) stop() {
+	w.cancel()
+	<-w.done
+}
+
+// run posts a batch when it is full or every auditFlushInterval, and the
+// queued records once ctx is done
+func (w *auditWebhook) run(ctx context.Context) {
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+
+	var batch [][]byte
+	add := func(line []byte) {
+		batch = append(batch, line)
+		if len(batch) >= auditBatchSize {
+			w.post(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case line := <-w.records:
+			add(line)
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.post(batch)
+				batch = nil
+			}
+		case <-ctx.Done():
+			for {
+				select {
+				case line := <-w.records:
+					add(line)
+				default:
+					if len(batch) > 0 {
+						w.post(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// post sends a batch as newline-delimited JSON. It has its own timeout, so
+// the batches queued at shutdown are still sent.
+func (w *auditWebhook) post(batch [][]byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), auditPostTimeout)
+	defer cancel()
+
+	result := "written"
+	err := w.send(ctx, bytes.Join(batch, nil))
+	if err != nil {
+		w.log.Error(err, "Failed to post audit records", "url", w.config.URL, "records", len(batch))
+		ErrorsTotal.Inc()
+		result = "dropped"
+	}
+	AuditRecordsTotal.WithLabelValues(w.configName, auditSinkWebhook, result).Add(float64(len(batch)))
+}
+
+func (w *auditWebhook) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if w.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.config.Token)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit webhook answered %s", resp.Status)
+	}
+	return nil
+}
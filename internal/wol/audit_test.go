@@ -0,0 +1,227 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func readAuditRecords(t *testing.T, data []byte) []AuditRecord {
+	t.Helper()
+	var records []AuditRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid audit record %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditFile_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := openAuditFile(auditFileConfig{Path: path, MaxBytes: 10, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if err := f.write([]byte(line)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	// Ogni riga supera i 10 byte con la precedente: una rotazione per riga
+	for name, want := range map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"} {
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != want {
+			t.Errorf("Expected %s to hold %q, got %q (%v)", name, want, data, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups, got %s.3 (%v)", path, err)
+	}
+}
+
+func TestAuditDecision(t *testing.T) {
+	for status, want := range map[wolv1.ResponseStatus]string{
+		wolv1.ResponseStatus_VM_START_INITIATED:         AuditDecisionAccepted,
+		wolv1.ResponseStatus_VM_STOP_INITIATED:          AuditDecisionAccepted,
+		wolv1.ResponseStatus_ACCEPTED:                   AuditDecisionAccepted,
+		wolv1.ResponseStatus_POLICY_REJECTED:            AuditDecisionRejected,
+		wolv1.ResponseStatus_SECURE_ON_PASSWORD_MISSING: AuditDecisionRejected,
+		wolv1.ResponseStatus_RATE_LIMITED:               AuditDecisionRejected,
+		wolv1.ResponseStatus_ERROR:                      AuditDecisionFailed,
+	} {
+		if got := auditDecision(status); got != want {
+			t.Errorf("Expected %s for %s, got %s", want, status, got)
+		}
+	}
+}
+
+func TestAuditor(t *testing.T) {
+	var mu sync.Mutex
+	var posted []byte
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		posted = append(posted, body...)
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "siem", Namespace: "kubevirt-wol"},
+		Data:       map[string][]byte{"token": []byte("t0ken\n")},
+	}
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+	mapper.SetSecretReader(fake.NewClientBuilder().WithObjects(secret).Build())
+	path := filepath.Join(t.TempDir(), "audit.log")
+	lab := wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:01", VMName: "vm1", Namespace: "team-a"},
+			},
+			Audit: &wolv1beta1.AuditSpec{
+				File: &wolv1beta1.AuditFileSpec{Path: path},
+				Webhook: &wolv1beta1.AuditWebhookSpec{URL: server.URL, BearerTokenSecretRef: &wolv1beta1.SecretKeyReference{
+					Name: "siem", Namespace: "kubevirt-wol", Key: "token"}},
+			},
+		},
+	}
+	lab.Name = "lab"
+	// Stesso file di lab, nessun webhook
+	other := wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:02", VMName: "vm2", Namespace: "team-b"},
+			},
+			SecureOn: &wolv1beta1.SecureOnSpec{Policy: wolv1beta1.SecureOnPolicyRequire},
+			Audit:    &wolv1beta1.AuditSpec{File: &wolv1beta1.AuditFileSpec{Path: path}},
+		},
+	}
+	other.Name = "other"
+	// Nessun audit: i suoi wake non sono registrati
+	quiet := wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:03", VMName: "vm3", Namespace: "team-c"},
+			},
+		},
+	}
+	quiet.Name = "quiet"
+	mapper.UpdateConfigs([]wolv1beta1.WolConfig{lab, other, quiet})
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	auditor := NewAuditor(mapper, logr.Discard())
+	aggregator := NewAggregator(mapper, &policyStarter{actions: make(map[string]string)}, logr.Discard())
+	aggregator.SetAuditor(auditor)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		auditor.Run(ctx)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		auditor.mu.RLock()
+		ready := len(auditor.routes) == 2
+		auditor.mu.RUnlock()
+		if ready {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the audit sinks")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, mac := range []string{"52:54:00:00:00:01", "52:54:00:00:00:03"} {
+		if _, err := aggregator.ReportWOLEvent(ctx, &wolv1.WOLEvent{MacAddress: mac, NodeName: "node-1",
+			SourceIp: "10.0.0.5", DestinationPort: 9}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if _, err := aggregator.RequestWake(ctx, &wolv1.WakeRequest{Namespace: "team-b", Name: "vm2",
+		Source: APIWakeSource, Reason: "api call"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Lo stop posta i record ancora in coda
+	cancel()
+	<-done
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := readAuditRecords(t, data)
+	if len(records) != 2 {
+		t.Fatalf("Expected the records of lab and other in the file, got %s", data)
+	}
+	woken, rejected := records[0], records[1]
+	if woken.WolConfig != "lab" || woken.Decision != AuditDecisionAccepted || woken.Action != "wake" ||
+		woken.MAC != "52:54:00:00:00:01" || woken.VM != "vm1" || woken.Source != "packet" ||
+		woken.Node != "node-1" || woken.SourceIP != "10.0.0.5" {
+		t.Errorf("Unexpected record of the packet: %+v", woken)
+	}
+	if rejected.WolConfig != "other" || rejected.Decision != AuditDecisionRejected ||
+		rejected.Status != wolv1.ResponseStatus_SECURE_ON_PASSWORD_MISSING.String() ||
+		rejected.Source != APIWakeSource || rejected.Trigger != "api call" || rejected.Reason == "" {
+		t.Errorf("Unexpected record of the wake request: %+v", rejected)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if webhook := readAuditRecords(t, posted); len(webhook) != 1 || webhook[0].WolConfig != "lab" {
+		t.Errorf("Expected only the record of lab on the webhook, got %s", posted)
+	}
+	if authorization != "Bearer t0ken" {
+		t.Errorf("Expected the bearer token of the Secret, got %q", authorization)
+	}
+	if strings.Contains(string(data), "vm3") {
+		t.Errorf("Expected no record for a config without audit, got %s", data)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// chaosFlagPrefix marks the fault-injection flags, hidden from the usage
+const chaosFlagPrefix = "chaos-"
+
+// errChaosStartFailure is the error of an injected VM start failure
+var errChaosStartFailure = errors.New("chaos: injected VM start failure")
+
+// ChaosOptions injects faults to validate HA, dedupe and retries in staging.
+// Never enable them in production: they lose and delay wakes on purpose.
+type ChaosOptions struct {
+	// DropPercent of the agent events is never reported
+	DropPercent int
+	// DuplicatePercent of the agent events is reported twice
+	DuplicatePercent int
+	// DelayPercent of the agent events is reported after Delay
+	DelayPercent int
+	Delay        time.Duration
+	// StartFailurePercent of the VM starts fails on the aggregator
+	StartFailurePercent int
+}
+
+// Enabled returns true if any fault is injected
+func (c ChaosOptions) Enabled() bool {
+	return c.DropPercent > 0 || c.DuplicatePercent > 0 || (c.DelayPercent > 0 && c.Delay > 0) || c.StartFailurePercent > 0
+}
+
+// Validate checks the percentages
+func (c ChaosOptions) Validate() error {
+	for name, percent := range map[string]int{
+		"drop": c.DropPercent, "duplicate": c.DuplicatePercent,
+		"delay": c.DelayPercent, "start-failure": c.StartFailurePercent,
+	} {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("chaos %s percentage %d out of range (must be 0-100)", name, percent)
+		}
+	}
+	return nil
+}
+
+// chaosHit returns true for percent% of the calls, counting the injected fault
+func chaosHit(percent int, fault string) bool {
+	if percent <= 0 || rand.IntN(100) >= percent {
+		return false
+	}
+	ChaosFaultsTotal.WithLabelValues(fault).Inc()
+	return true
+}
+
+// BindAgentChaosFlags registers the hidden agent fault-injection flags on fs
+func BindAgentChaosFlags(fs *flag.FlagSet, opts *ChaosOptions) {
+	fs.IntVar(&opts.DropPercent, chaosFlagPrefix+"drop-percent", 0, "Percentage of events never reported (testing only)")
+	fs.IntVar(&opts.DuplicatePercent, chaosFlagPrefix+"duplicate-percent", 0, "Percentage of events reported twice (testing only)")
+	fs.IntVar(&opts.DelayPercent, chaosFlagPrefix+"delay-percent", 0, "Percentage of events reported late (testing only)")
+	fs.DurationVar(&opts.Delay, chaosFlagPrefix+"delay", time.Second, "Delay of the late events (testing only)")
+	hideChaosFlags(fs)
+}
+
+// BindManagerChaosFlags registers the hidden aggregator fault-injection flags on fs
+func BindManagerChaosFlags(fs *flag.FlagSet, opts *ChaosOptions) {
+	fs.IntVar(&opts.StartFailurePercent, chaosFlagPrefix+"start-failure-percent", 0,
+		"Percentage of VM starts failed on purpose (testing only)")
+	hideChaosFlags(fs)
+}
+
+// hideChaosFlags leaves the fault-injection flags out of the usage of fs
+func hideChaosFlags(fs *flag.FlagSet) {
+	fs.Usage = func() {
+		if fs.Name() == "" {
+			_, _ = fmt.Fprintf(fs.Output(), "Usage:\n")
+		} else {
+			_, _ = fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		}
+		visible := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+		visible.SetOutput(fs.Output())
+		fs.VisitAll(func(f *flag.Flag) {
+			if !strings.HasPrefix(f.Name, chaosFlagPrefix) {
+				visible.Var(f.Value, f.Name, f.Usage)
+				visible.Lookup(f.Name).DefValue = f.DefValue
+			}
+		})
+		visible.PrintDefaults()
+	}
+}
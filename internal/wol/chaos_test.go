@@ -0,0 +1,73 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestChaosFlagsAreHidden(t *testing.T) {
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	fs.Int("ports", 9, "UDP ports")
+	var opts ChaosOptions
+	BindAgentChaosFlags(fs, &opts)
+	BindManagerChaosFlags(fs, &opts)
+
+	var out bytes.Buffer
+	fs.SetOutput(&out)
+	fs.Usage()
+	if strings.Contains(out.String(), chaosFlagPrefix) || !strings.Contains(out.String(), "-ports") {
+		t.Errorf("Expected only the regular flags in the usage, got:\n%s", out.String())
+	}
+
+	if err := fs.Parse([]string{"--chaos-drop-percent=30", "--chaos-start-failure-percent=100"}); err != nil {
+		t.Fatalf("Expected the hidden flags to be accepted, got %v", err)
+	}
+	if opts.DropPercent != 30 || opts.StartFailurePercent != 100 || !opts.Enabled() {
+		t.Errorf("Unexpected options %+v", opts)
+	}
+	if err := (ChaosOptions{DuplicatePercent: 101}).Validate(); err == nil {
+		t.Error("Expected a percentage above 100 to be rejected")
+	}
+	if (ChaosOptions{DelayPercent: 50}).Enabled() {
+		t.Error("Expected a delay percentage without a delay not to enable chaos")
+	}
+}
+
+func TestAggregator_ChaosStartFailure(t *testing.T) {
+	mapper := NewMACMapper(nil, logr.Discard())
+	key, _ := parseMACKey("52:54:00:12:34:56")
+	mapper.mapping.Set(key, VMInfo{Name: "vm1", Namespace: "default", ConfigName: "cfg"})
+	agg := NewAggregator(mapper, noopStarter{}, logr.Discard())
+	agg.SetChaos(ChaosOptions{StartFailurePercent: 100})
+
+	resp, err := agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "52:54:00:12:34:56"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Status != wolv1.ResponseStatus_ERROR || !strings.Contains(resp.Message, "chaos") {
+		t.Errorf("Expected an injected start failure, got %v: %s", resp.Status, resp.Message)
+	}
+}
@@ -0,0 +1,338 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// MappingConflict describes a MAC address claimed by more than one VM
+type MappingConflict struct {
+	MAC        string
+	Candidates []VMInfo
+	// Winner is the VM the MAC resolves to, nil when the MAC was rejected
+	Winner *VMInfo
+	// Companions are the other VMs started with the winner (StartAll policy)
+	Companions []VMInfo
+}
+
+// InvolvesConfig returns true if one of the candidates comes from the given WolConfig
+func (c *MappingConflict) InvolvesConfig(configName string) bool {
+	for _, candidate := range c.Candidates {
+		if candidate.ConfigName == configName {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the <wolconfig>:<namespace>/<vm> form of a mapping entry
+func (v VMInfo) String() string {
+	return fmt.Sprintf("%s:%s/%s", v.ConfigName, v.Namespace, v.Name)
+}
+
+// sameVM returns true if both entries point to the same VirtualMachine
+func (v VMInfo) sameVM(other VMInfo) bool {
+	return v.Namespace == other.Namespace && v.Name == other.Name
+}
+
+// mappingBuilder accumulates the mapping during a refresh. Every claim on a MAC
+// is collected first and resolved once in build, according to WolConfig
+// precedence and conflict policy, so the result does not depend on the order
+// in which configs and VMs were discovered.
+type mappingBuilder struct {
+	store      *macStore
+	configs    map[string]*wolv1beta1.WolConfig
+	candidates map[macKey][]VMInfo
+	conflicts  map[macKey]*MappingConflict
+	// networks records the Multus networks used per config (config -> <namespace>/<nad>)
+	networks map[string]map[string]bool
+}
+
+func newMappingBuilder(configs []wolv1beta1.WolConfig) *mappingBuilder {
+	b := &mappingBuilder{
+		store:      newMACStore(),
+		configs:    make(map[string]*wolv1beta1.WolConfig, len(configs)),
+		candidates: make(map[macKey][]VMInfo),
+		conflicts:  make(map[macKey]*MappingConflict),
+		networks:   make(map[string]map[string]bool),
+	}
+	for i := range configs {
+		b.configs[configs[i].Name] = &configs[i]
+	}
+	return b
+}
+
+// add records that the VM claims the MAC
+func (b *mappingBuilder) add(key macKey, info VMInfo) {
+	b.candidates[key] = append(b.candidates[key], info)
+}
+
+// build resolves every collected MAC and returns the resulting store
+func (b *mappingBuilder) build() *macStore {
+	for key, candidates := range b.candidates {
+		winner, ok := b.resolve(candidates)
+		if ok {
+			b.store.Set(key, winner)
+		}
+		if conflict := b.newConflict(key, candidates, winner, ok); conflict != nil {
+			b.conflicts[key] = conflict
+		}
+	}
+	return b.store
+}
+
+// newConflict returns the conflict of a MAC claimed by more than one VM, nil if
+// the candidates are a single VM
+func (b *mappingBuilder) newConflict(key macKey, candidates []VMInfo, winner VMInfo, resolved bool) *MappingConflict {
+	if !distinctVMs(candidates) {
+		return nil
+	}
+	conflict := &MappingConflict{MAC: key.String(), Candidates: append([]VMInfo(nil), candidates...)}
+	sort.Slice(conflict.Candidates, func(i, j int) bool {
+		return conflict.Candidates[i].String() < conflict.Candidates[j].String()
+	})
+	if resolved {
+		conflict.Winner = &winner
+		conflict.Companions = b.companions(conflict.Candidates, winner)
+	}
+	return conflict
+}
+
+// companions returns the VMs started with the winner when a candidate with the
+// highest precedence has the StartAll policy, one entry per VM
+func (b *mappingBuilder) companions(candidates []VMInfo, winner VMInfo) []VMInfo {
+	top := b.topCandidates(candidates)
+	if !slices.ContainsFunc(top, func(c VMInfo) bool { return b.policy(c) == wolv1beta1.ConflictPolicyStartAll }) {
+		return nil
+	}
+	var companions []VMInfo
+	for _, c := range top {
+		if !c.sameVM(winner) && !slices.ContainsFunc(companions, c.sameVM) {
+			companions = append(companions, c)
+		}
+	}
+	return companions
+}
+
+// topCandidates returns the candidates of the configs with the highest precedence
+func (b *mappingBuilder) topCandidates(candidates []VMInfo) []VMInfo {
+	top := candidates[:0:0]
+	for _, c := range candidates {
+		switch {
+		case len(top) == 0 || b.precedence(c) > b.precedence(top[0]):
+			top = append(top[:0], c)
+		case b.precedence(c) == b.precedence(top[0]):
+			top = append(top, c)
+		}
+	}
+	return top
+}
+
+// resolve picks the entry that keeps the MAC, or returns false if the MAC must be rejected.
+// Only the candidates with the highest precedence take part; among them the
+// policies apply in the order Reject > PreferOldest > PreferExplicit, then
+// (for PreferExplicit) explicit over discovered, then the oldest config.
+// StartAll picks the winner as PreferExplicit; the other VMs are its companions.
+// A VM reached through several configs is not a conflict: it keeps the
+// provenance of the config with the highest precedence.
+func (b *mappingBuilder) resolve(candidates []VMInfo) (VMInfo, bool) {
+	top := b.topCandidates(candidates)
+
+	if distinctVMs(top) {
+		preferOldest := false
+		for _, c := range top {
+			switch b.policy(c) {
+			case wolv1beta1.ConflictPolicyReject:
+				return VMInfo{}, false
+			case wolv1beta1.ConflictPolicyPreferOldest:
+				preferOldest = true
+			}
+		}
+
+		if !preferOldest {
+			explicit := top[:0:0]
+			for _, c := range top {
+				if c.MappingType == MappingTypeExplicit {
+					explicit = append(explicit, c)
+				}
+			}
+			if len(explicit) > 0 {
+				top = explicit
+			}
+		}
+	}
+
+	winner := top[0]
+	for _, c := range top[1:] {
+		if b.olderConfig(c, winner) ||
+			(c.ConfigName == winner.ConfigName && c.String() < winner.String()) {
+			winner = c
+		}
+	}
+	return winner, true
+}
+
+// distinctVMs returns true if the entries point to more than one VirtualMachine
+func distinctVMs(entries []VMInfo) bool {
+	for _, e := range entries[1:] {
+		if !e.sameVM(entries[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *mappingBuilder) precedence(info VMInfo) int32 {
+	if config := b.configs[info.ConfigName]; config != nil {
+		return config.Spec.Precedence
+	}
+	return 0
+}
+
+func (b *mappingBuilder) policy(info VMInfo) wolv1beta1.ConflictPolicy {
+	if config := b.configs[info.ConfigName]; config != nil && config.Spec.ConflictPolicy != "" {
+		return config.Spec.ConflictPolicy
+	}
+	return wolv1beta1.ConflictPolicyPreferExplicit
+}
+
+// olderConfig returns true if a's WolConfig was created before b's (name breaks ties)
+func (b *mappingBuilder) olderConfig(a, o VMInfo) bool {
+	ca, co := b.configs[a.ConfigName], b.configs[o.ConfigName]
+	if ca == nil || co == nil {
+		return false
+	}
+	ta, to := ca.CreationTimestamp, co.CreationTimestamp
+	if !ta.Equal(&to) {
+		return ta.Before(&to)
+	}
+	return ca.Name < co.Name
+}
+
+// vmIndex returns the mapping indexed by <namespace>/<vm>. When the MACs of a VM
+// resolve to different configs, the entry of the config with the highest
+// precedence is kept (config name breaks ties) so the result is deterministic.
+func (b *mappingBuilder) vmIndex() map[string]VMInfo {
+	index := make(map[string]VMInfo, b.store.Len())
+	b.store.Range(func(_ macKey, info VMInfo) bool {
+		key := vmIndexKey(info.Namespace, info.Name)
+		if existing, found := index[key]; !found || b.indexBefore(info, existing) {
+			index[key] = info
+		}
+		return true
+	})
+	return index
+}
+
+// indexBefore returns true if info wins over existing in the VM index
+func (b *mappingBuilder) indexBefore(info, existing VMInfo) bool {
+	return b.precedence(info) > b.precedence(existing) ||
+		(b.precedence(info) == b.precedence(existing) && info.ConfigName < existing.ConfigName)
+}
+
+// vmMACs returns the MACs claimed by each VM, indexed by <namespace>/<vm>
+func (b *mappingBuilder) vmMACs() map[string]map[macKey]bool {
+	index := make(map[string]map[macKey]bool)
+	for key, candidates := range b.candidates {
+		for _, c := range candidates {
+			vm := vmIndexKey(c.Namespace, c.Name)
+			if index[vm] == nil {
+				index[vm] = make(map[macKey]bool)
+			}
+			index[vm][key] = true
+		}
+	}
+	return index
+}
+
+func vmIndexKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// conflictList returns the conflicts sorted by MAC address
+func (b *mappingBuilder) conflictList() []MappingConflict {
+	list := make([]MappingConflict, 0, len(b.conflicts))
+	for _, c := range b.conflicts {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].MAC < list[j].MAC
+	})
+	return list
+}
+
+// LookupCompanions returns the VMs to start along with the one returned by
+// Lookup, when the MAC is claimed by several VMs under the StartAll policy
+func (m *MACMapper) LookupCompanions(macAddress string) []VMInfo {
+	key, ok := parseMACKey(macAddress)
+	if !ok {
+		return nil
+	}
+	mac := key.String()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	i := sort.Search(len(m.conflicts), func(i int) bool { return m.conflicts[i].MAC >= mac })
+	if i == len(m.conflicts) || m.conflicts[i].MAC != mac {
+		return nil
+	}
+	return m.conflicts[i].Companions
+}
+
+// startCompanions starts the companions of a woken VM, each checked against
+// its own SecureOn policy and WolPolicy, and returns how many were started
+func (a *Aggregator) startCompanions(ctx context.Context, event *wolv1.WOLEvent, companions []VMInfo, startTime time.Time) int {
+	started := 0
+	for _, vmInfo := range companions {
+		if resp := a.enforceSecureOn(event, vmInfo, startTime); resp != nil {
+			a.recordKubeEvents(ctx, event, vmInfo, resp)
+			continue
+		}
+		action, resp := a.enforcePolicy(ctx, vmInfo, event.NodeName, startTime)
+		if resp == nil && a.mapper.DryRun(vmInfo.ConfigName) {
+			a.skipDryRun(vmInfo.ConfigName, string(action), vmIndexKey(vmInfo.Namespace, vmInfo.Name))
+			resp = &wolv1.WOLEventResponse{Status: wolv1.ResponseStatus_DRY_RUN,
+				Message: fmt.Sprintf("Dry run: VM would be woken with action %s (StartAll)", action)}
+		} else if resp == nil {
+			a.recordDemand(vmInfo)
+			resp = &wolv1.WOLEventResponse{Status: wolv1.ResponseStatus_VM_START_INITIATED}
+			if err := a.startVM(ctx, vmInfo, action); err != nil {
+				if !errors.Is(err, errStartsThrottled) {
+					a.log.Error(err, "Failed to start VM sharing the MAC", "vm", vmInfo.Name,
+						"namespace", vmInfo.Namespace, "mac", event.MacAddress, "wolconfig", vmInfo.ConfigName)
+					ErrorsTotal.Inc()
+				}
+				status, message := a.startFailure(ctx, vmInfo, action, err)
+				resp = &wolv1.WOLEventResponse{Status: status, Message: message}
+			} else {
+				VMStartedTotal.Inc()
+				started++
+			}
+		}
+		a.recordKubeEvents(ctx, event, vmInfo, resp)
+	}
+	return started
+}
@@ -0,0 +1,276 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func conflictTestConfig(name string, age time.Duration, precedence int32, policy wolv1beta1.ConflictPolicy) wolv1beta1.WolConfig {
+	config := wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			Precedence:     precedence,
+			ConflictPolicy: policy,
+		},
+	}
+	config.Name = name
+	config.CreationTimestamp = metav1.NewTime(time.Unix(1700000000, 0).Add(-age))
+	return config
+}
+
+func TestMappingBuilder_Resolution(t *testing.T) {
+	key, _ := parseMACKey("52:54:00:12:34:56")
+
+	tests := []struct {
+		name       string
+		configs    []wolv1beta1.WolConfig
+		entries    []VMInfo
+		wantWinner string // empty = rejected
+		// wantCompanions are the other VMs started (StartAll)
+		wantCompanions []string
+	}{
+		{
+			name: "higher precedence wins",
+			configs: []wolv1beta1.WolConfig{
+				conflictTestConfig("a", time.Hour, 0, ""),
+				conflictTestConfig("b", time.Minute, 10, ""),
+			},
+			entries: []VMInfo{
+				{Name: "vm-a", Namespace: "ns", ConfigName: "a", MappingType: MappingTypeExplicit},
+				{Name: "vm-b", Namespace: "ns", ConfigName: "b", MappingType: MappingTypeDiscovered},
+			},
+			wantWinner: "b:ns/vm-b",
+		},
+		{
+			name: "explicit preferred by default",
+			configs: []wolv1beta1.WolConfig{
+				conflictTestConfig("a", time.Hour, 0, ""),
+				conflictTestConfig("b", time.Minute, 0, ""),
+			},
+			entries: []VMInfo{
+				{Name: "vm-a", Namespace: "ns", ConfigName: "a", MappingType: MappingTypeDiscovered},
+				{Name: "vm-b", Namespace: "ns", ConfigName: "b", MappingType: MappingTypeExplicit},
+			},
+			wantWinner: "b:ns/vm-b",
+		},
+		{
+			name: "prefer oldest",
+			configs: []wolv1beta1.WolConfig{
+				conflictTestConfig("a", time.Minute, 0, ""),
+				conflictTestConfig("b", time.Hour, 0, wolv1beta1.ConflictPolicyPreferOldest),
+			},
+			entries: []VMInfo{
+				{Name: "vm-a", Namespace: "ns", ConfigName: "a", MappingType: MappingTypeExplicit},
+				{Name: "vm-b", Namespace: "ns", ConfigName: "b", MappingType: MappingTypeDiscovered},
+			},
+			wantWinner: "b:ns/vm-b",
+		},
+		{
+			name: "reject",
+			configs: []wolv1beta1.WolConfig{
+				conflictTestConfig("a", time.Hour, 0, wolv1beta1.ConflictPolicyReject),
+				conflictTestConfig("b", time.Minute, 0, ""),
+				conflictTestConfig("c", time.Minute, 0, ""),
+			},
+			entries: []VMInfo{
+				{Name: "vm-a", Namespace: "ns", ConfigName: "a", MappingType: MappingTypeExplicit},
+				{Name: "vm-b", Namespace: "ns", ConfigName: "b", MappingType: MappingTypeExplicit},
+				{Name: "vm-c", Namespace: "ns", ConfigName: "c", MappingType: MappingTypeExplicit},
+			},
+			wantWinner: "",
+		},
+		{
+			name: "reject overridden by a later higher precedence claim",
+			configs: []wolv1beta1.WolConfig{
+				conflictTestConfig("a", time.Hour, 0, wolv1beta1.ConflictPolicyReject),
+				conflictTestConfig("b", time.Minute, 0, ""),
+				conflictTestConfig("c", time.Minute, 10, ""),
+			},
+			entries: []VMInfo{
+				{Name: "vm-a", Namespace: "ns", ConfigName: "a", MappingType: MappingTypeExplicit},
+				{Name: "vm-b", Namespace: "ns", ConfigName: "b", MappingType: MappingTypeExplicit},
+				{Name: "vm-c", Namespace: "ns", ConfigName: "c", MappingType: MappingTypeDiscovered},
+			},
+			wantWinner: "c:ns/vm-c",
+		},
+		{
+			name: "reject only among the highest precedence",
+			configs: []wolv1beta1.WolConfig{
+				conflictTestConfig("a", time.Hour, 0, wolv1beta1.ConflictPolicyReject),
+				conflictTestConfig("b", time.Minute, 5, ""),
+				conflictTestConfig("c", time.Hour, 5, ""),
+			},
+			entries: []VMInfo{
+				{Name: "vm-a", Namespace: "ns", ConfigName: "a", MappingType: MappingTypeExplicit},
+				{Name: "vm-b", Namespace: "ns", ConfigName: "b", MappingType: MappingTypeExplicit},
+				{Name: "vm-c", Namespace: "ns", ConfigName: "c", MappingType: MappingTypeExplicit},
+			},
+			wantWinner: "c:ns/vm-c",
+		},
+		{
+			name: "start all among the highest precedence",
+			configs: []wolv1beta1.WolConfig{
+				conflictTestConfig("a", time.Minute, 5, wolv1beta1.ConflictPolicyStartAll),
+				conflictTestConfig("b", time.Hour, 5, ""),
+				conflictTestConfig("c", time.Hour, 0, ""),
+			},
+			entries: []VMInfo{
+				{Name: "vm-a", Namespace: "ns", ConfigName: "a", MappingType: MappingTypeDiscovered},
+				{Name: "vm-b", Namespace: "ns", ConfigName: "b", MappingType: MappingTypeExplicit},
+				{Name: "vm-a", Namespace: "ns", ConfigName: "b", MappingType: MappingTypeDiscovered},
+				{Name: "vm-c", Namespace: "ns", ConfigName: "c", MappingType: MappingTypeExplicit},
+			},
+			wantWinner:     "b:ns/vm-b",
+			wantCompanions: []string{"a:ns/vm-a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := newMappingBuilder(tt.configs)
+			for _, entry := range tt.entries {
+				builder.add(key, entry)
+			}
+
+			info, found := builder.build().Get(key)
+			if tt.wantWinner == "" {
+				if found {
+					t.Errorf("Expected MAC to be rejected, got %s", info.String())
+				}
+			} else if !found || info.String() != tt.wantWinner {
+				t.Errorf("Expected winner %s, got %s (found=%v)", tt.wantWinner, info.String(), found)
+			}
+
+			conflicts := builder.conflictList()
+			if len(conflicts) != 1 {
+				t.Fatalf("Expected 1 conflict, got %d", len(conflicts))
+			}
+			if len(conflicts[0].Candidates) != len(tt.entries) {
+				t.Errorf("Expected %d candidates, got %d", len(tt.entries), len(conflicts[0].Candidates))
+			}
+			var companions []string
+			for _, companion := range conflicts[0].Companions {
+				companions = append(companions, companion.String())
+			}
+			if !slices.Equal(companions, tt.wantCompanions) {
+				t.Errorf("Expected companions %v, got %v", tt.wantCompanions, companions)
+			}
+		})
+	}
+}
+
+func TestMappingBuilder_SameVMIsNotAConflict(t *testing.T) {
+	key, _ := parseMACKey("52:54:00:12:34:56")
+	builder := newMappingBuilder([]wolv1beta1.WolConfig{
+		conflictTestConfig("a", time.Hour, 0, ""),
+		conflictTestConfig("b", time.Minute, 5, ""),
+	})
+
+	builder.add(key, VMInfo{Name: "vm", Namespace: "ns", ConfigName: "a", MappingType: MappingTypeDiscovered})
+	builder.add(key, VMInfo{Name: "vm", Namespace: "ns", ConfigName: "b", MappingType: MappingTypeExplicit})
+
+	store := builder.build()
+	if len(builder.conflictList()) != 0 {
+		t.Error("Expected no conflict for the same VM")
+	}
+	info, _ := store.Get(key)
+	if info.ConfigName != "b" {
+		t.Errorf("Expected provenance of the higher precedence config, got %s", info.ConfigName)
+	}
+}
+
+func TestMappingBuilder_OrderIndependent(t *testing.T) {
+	key, _ := parseMACKey("52:54:00:12:34:56")
+	configs := []wolv1beta1.WolConfig{
+		conflictTestConfig("a", time.Hour, 0, wolv1beta1.ConflictPolicyReject),
+		conflictTestConfig("b", time.Minute, 5, ""),
+		conflictTestConfig("c", 2*time.Hour, 5, ""),
+		conflictTestConfig("d", 3*time.Hour, 5, ""),
+	}
+	entries := []VMInfo{
+		{Name: "vm-a", Namespace: "ns", ConfigName: "a", MappingType: MappingTypeExplicit},
+		{Name: "vm-b", Namespace: "ns", ConfigName: "b", MappingType: MappingTypeExplicit},
+		{Name: "vm-c", Namespace: "ns", ConfigName: "c", MappingType: MappingTypeExplicit},
+		{Name: "vm-d", Namespace: "ns", ConfigName: "d", MappingType: MappingTypeDiscovered},
+	}
+
+	// Every permutation of the claims must resolve to the same winner
+	var permute func(k int)
+	permute = func(k int) {
+		if k == len(entries) {
+			builder := newMappingBuilder(configs)
+			for _, entry := range entries {
+				builder.add(key, entry)
+			}
+			info, found := builder.build().Get(key)
+			if !found || info.String() != "c:ns/vm-c" {
+				t.Errorf("Order %v: expected winner c:ns/vm-c, got %s (found=%v)", entries, info.String(), found)
+			}
+			if conflicts := builder.conflictList(); len(conflicts) != 1 || conflicts[0].Candidates[0].ConfigName != "a" {
+				t.Errorf("Order %v: expected sorted candidates, got %+v", entries, conflicts)
+			}
+			return
+		}
+		for i := k; i < len(entries); i++ {
+			entries[k], entries[i] = entries[i], entries[k]
+			permute(k + 1)
+			entries[k], entries[i] = entries[i], entries[k]
+		}
+	}
+	permute(0)
+}
+
+func TestAggregator_StartAllCompanions(t *testing.T) {
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+	configs := []wolv1beta1.WolConfig{
+		conflictTestConfig("a", time.Hour, 0, wolv1beta1.ConflictPolicyStartAll),
+		conflictTestConfig("b", time.Minute, 0, ""),
+	}
+	for i, vm := range []string{"vm-a", "vm-b"} {
+		configs[i].Spec.DiscoveryMode = wolv1beta1.DiscoveryModeExplicit
+		configs[i].Spec.ExplicitMappings = []wolv1beta1.MACVMMapping{
+			{MACAddress: "52:54:00:12:34:56", VMName: vm, Namespace: "ns"},
+		}
+	}
+	mapper.UpdateConfigs(configs)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	starter := &policyStarter{actions: make(map[string]string)}
+	agg := NewAggregator(mapper, starter, logr.Discard())
+
+	resp, _ := agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "52:54:00:12:34:56"})
+	if resp.Status != wolv1.ResponseStatus_VM_START_INITIATED || resp.VmInfo.GetName() != "vm-a" {
+		t.Fatalf("Expected the oldest config to win, got %v for %s", resp.Status, resp.VmInfo.GetName())
+	}
+	if len(starter.actions) != 2 || starter.actions["vm-b"] != "start" {
+		t.Errorf("Expected both VMs to be started, got %v", starter.actions)
+	}
+	if !strings.Contains(resp.Message, "1 of 1 other VMs") {
+		t.Errorf("Expected the companions in the message, got %q", resp.Message)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMaxSearch bounds the search of the next run: a schedule like
+// "0 0 30 2 *" never matches
+const cronMaxSearch = 5 * 366 * 24 * time.Hour
+
+// cronMacros are the predefined schedules
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonths   = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronWeekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// CronSchedule is a standard five-field cron schedule (minute, hour, day of
+// month, month, day of week) in a time zone
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64 // bit i set if value i matches
+	// domAny and dowAny are true for "*": when both days are restricted, a
+	// day matching either of them matches (like Vixie cron)
+	domAny, dowAny bool
+	location       *time.Location
+}
+
+// ParseCron parses a cron schedule in the IANA time zone (UTC if empty)
+func ParseCron(spec, timeZone string) (*CronSchedule, error) {
+	location := time.UTC
+	if timeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(timeZone); err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", timeZone, err)
+		}
+	}
+	expr := strings.TrimSpace(spec)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron schedule %q: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	s := &CronSchedule{location: location}
+	var err error
+	if s.minute, _, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid minute in %q: %w", spec, err)
+	}
+	if s.hour, _, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid hour in %q: %w", spec, err)
+	}
+	if s.dom, s.domAny, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid day of month in %q: %w", spec, err)
+	}
+	if s.month, _, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("invalid month in %q: %w", spec, err)
+	}
+	// 7 è anche domenica
+	if s.dow, s.dowAny, err = parseCronField(fields[4], 0, 7, cronWeekdays); err != nil {
+		return nil, fmt.Errorf("invalid day of week in %q: %w", spec, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges (a-b), "*"
+// and steps (*/n, a-b/n, a/n) between min and max. names, if set, are the
+// names of the values from min.
+func parseCronField(field string, min, max int, names []string) (bits uint64, wildcard bool, err error) {
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return 0, false, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+
+		var low, high int
+		switch {
+		case rangePart == "*":
+			low, high = min, max
+			wildcard = wildcard || !hasStep
+		case strings.Contains(rangePart, "-"):
+			lowPart, highPart, _ := strings.Cut(rangePart, "-")
+			if low, err = parseCronValue(lowPart, min, max, names); err != nil {
+				return 0, false, err
+			}
+			if high, err = parseCronValue(highPart, min, max, names); err != nil {
+				return 0, false, err
+			}
+			if high < low {
+				return 0, false, fmt.Errorf("invalid range %q", rangePart)
+			}
+		default:
+			if low, err = parseCronValue(rangePart, min, max, names); err != nil {
+				return 0, false, err
+			}
+			high = low
+			if hasStep {
+				high = max
+			}
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, wildcard, nil
+}
+
+func parseCronValue(value string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(value, name) {
+			return min + i, nil
+		}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("value %q out of range %d-%d", value, min, max)
+	}
+	return n, nil
+}
+
+// dayMatches returns true if the day of t matches the day of month and day of week
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first run strictly after t, or the zero time if the
+// schedule never matches
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	// Tronca ai minuti senza time.Date, che nell'ora ripetuta al ritorno
+	// dell'ora solare sceglierebbe la prima e tornerebbe indietro
+	t = t.In(s.location)
+	t = t.Add(-time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond())).Add(time.Minute)
+	limit := t.Add(cronMaxSearch)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Latest returns the last run after from and not after now, or the zero time
+// if there is none
+func (s *CronSchedule) Latest(from, now time.Time) time.Time {
+	var latest time.Time
+	for next := s.Next(from); !next.IsZero() && !next.After(now); next = s.Next(next) {
+		latest = next
+	}
+	return latest
+}
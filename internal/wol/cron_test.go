@@ -0,0 +1,138 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"testing"
+	"time"
+)
+
+func mustParseCron(t *testing.T, spec, timeZone string) *CronSchedule {
+	t.Helper()
+	s, err := ParseCron(spec, timeZone)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing %q: %v", spec, err)
+	}
+	return s
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"@every 5m",
+	} {
+		if _, err := ParseCron(spec, ""); err == nil {
+			t.Errorf("Expected %q to be invalid", spec)
+		}
+	}
+	if _, err := ParseCron("@daily", "Mars/Olympus_Mons"); err == nil {
+		t.Error("Expected an unknown time zone to be invalid")
+	}
+}
+
+func TestCronSchedule_Next(t *testing.T) {
+	from := time.Date(2025, time.March, 14, 10, 17, 30, 0, time.UTC) // venerdì
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2025, time.March, 14, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, time.March, 14, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2025, time.March, 14, 13, 0, 0, 0, time.UTC)},
+		{"30 7 * * mon-fri", time.Date(2025, time.March, 17, 7, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2025, time.March, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan,jul *", time.Date(2025, time.July, 1, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2025, time.March, 16, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2025, time.March, 14, 11, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// Giorno del mese e della settimana: basta uno dei due
+		{"0 12 20 * 1", time.Date(2025, time.March, 17, 12, 0, 0, 0, time.UTC)},
+		{"0 12 15 * 1", time.Date(2025, time.March, 15, 12, 0, 0, 0, time.UTC)},
+		// Con "*" conta solo l'altro
+		{"0 12 * * 1", time.Date(2025, time.March, 17, 12, 0, 0, 0, time.UTC)},
+		{"0 12 15 * *", time.Date(2025, time.March, 15, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := mustParseCron(t, tt.spec, "").Next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.spec, tt.want, got)
+		}
+	}
+
+	if got := mustParseCron(t, "0 0 30 2 *", "").Next(from); !got.IsZero() {
+		t.Errorf("Expected a schedule that never matches to have no next run, got %v", got)
+	}
+}
+
+func TestCronSchedule_NextTimeZone(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Skip("Time zone database not available")
+	}
+	s := mustParseCron(t, "30 7 * * *", "Europe/Rome")
+	got := s.Next(time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC))
+	if want := time.Date(2025, time.June, 2, 5, 30, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// L'ora legale salta le 2:30 del 30 marzo
+	s = mustParseCron(t, "30 2 * * *", "Europe/Rome")
+	got = s.Next(time.Date(2025, time.March, 29, 12, 0, 0, 0, rome))
+	if want := time.Date(2025, time.March, 31, 2, 30, 0, 0, rome); !got.Equal(want) {
+		t.Errorf("Expected the skipped time to be skipped, got %v", got.In(rome))
+	}
+
+	// L'ora solare ripete le 2:30 del 26 ottobre: una sola esecuzione per ora
+	s = mustParseCron(t, "30 * * * *", "Europe/Rome")
+	from := time.Date(2025, time.October, 26, 1, 0, 0, 0, rome)
+	var runs []time.Time
+	for next := s.Next(from); len(runs) < 4; next = s.Next(next) {
+		runs = append(runs, next)
+	}
+	for i := 1; i < len(runs); i++ {
+		if !runs[i].After(runs[i-1]) {
+			t.Fatalf("Expected increasing runs, got %v", runs)
+		}
+	}
+	if want := time.Date(2025, time.October, 26, 3, 30, 0, 0, rome); !runs[3].Equal(want) {
+		t.Errorf("Expected the repeated hour to run twice before %v, got %v", want, runs)
+	}
+}
+
+func TestCronSchedule_Latest(t *testing.T) {
+	s := mustParseCron(t, "0 */6 * * *", "")
+	from := time.Date(2025, time.March, 14, 1, 0, 0, 0, time.UTC)
+	now := time.Date(2025, time.March, 14, 12, 0, 0, 0, time.UTC)
+	if got, want := s.Latest(from, now), now; !got.Equal(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got, want := s.Latest(from, now.Add(-time.Second)), time.Date(2025, time.March, 14, 6, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := s.Latest(now, now.Add(time.Hour)); !got.IsZero() {
+		t.Errorf("Expected no run after from, got %v", got)
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultWakeDemandWindow is the default sliding window of the wake demand
+const DefaultWakeDemandWindow = 5 * time.Minute
+
+// WakeDemand tracks recent wake requests per VM so that KEDA (metrics-api scaler)
+// or another external autoscaler can poll the wake demand over HTTP.
+// Only wakes of VMs mapped by a WolConfig are counted: a magic packet for an
+// unknown MAC carries no VM to attribute it to.
+type WakeDemand struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]*demandEntry // <namespace>/<vm> -> recent wakes
+}
+
+type demandEntry struct {
+	info  VMInfo
+	wakes []time.Time // oldest first
+}
+
+// WakeDemandReport is the JSON document served by WakeDemand
+type WakeDemandReport struct {
+	// RecentWakes is the number of wake requests in the window for the selected VMs
+	RecentWakes int `json:"recentWakes"`
+	// ActiveVMs is the number of selected VMs with at least one wake request in the window
+	ActiveVMs int `json:"activeVMs"`
+	// WindowSeconds is the length of the sliding window
+	WindowSeconds int `json:"windowSeconds"`
+	// VMs details the demand per VM
+	VMs []VMDemand `json:"vms"`
+}
+
+// VMDemand is the wake demand of a single VM
+type VMDemand struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	WolConfig string    `json:"wolconfig"`
+	Wakes     int       `json:"wakes"`
+	LastWake  time.Time `json:"lastWake"`
+}
+
+// WakeDemandFilter selects the VMs included in a report (empty fields match everything)
+type WakeDemandFilter struct {
+	Namespace string
+	Name      string
+	WolConfig string
+}
+
+// NewWakeDemand creates a tracker with the given sliding window
+func NewWakeDemand(window time.Duration) *WakeDemand {
+	if window <= 0 {
+		window = DefaultWakeDemandWindow
+	}
+	return &WakeDemand{
+		window:  window,
+		entries: make(map[string]*demandEntry),
+	}
+}
+
+// Record adds a wake request for the VM
+func (d *WakeDemand) Record(info VMInfo, at time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := vmIndexKey(info.Namespace, info.Name)
+	entry := d.entries[key]
+	if entry == nil {
+		entry = &demandEntry{}
+		d.entries[key] = entry
+	}
+	entry.info = info
+	entry.wakes = append(pruneWakes(entry.wakes, at.Add(-d.window)), at)
+}
+
+// Report returns the demand in the window ending at now, dropping expired entries
+func (d *WakeDemand) Report(filter WakeDemandFilter, now time.Time) WakeDemandReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	report := WakeDemandReport{
+		WindowSeconds: int(d.window.Seconds()),
+		VMs:           []VMDemand{},
+	}
+	d.prune(now)
+	for _, entry := range d.entries {
+		if !filter.matches(entry.info) {
+			continue
+		}
+		report.RecentWakes += len(entry.wakes)
+		report.ActiveVMs++
+		report.VMs = append(report.VMs, VMDemand{
+			Namespace: entry.info.Namespace,
+			Name:      entry.info.Name,
+			WolConfig: entry.info.ConfigName,
+			Wakes:     len(entry.wakes),
+			LastWake:  entry.wakes[len(entry.wakes)-1],
+		})
+	}
+
+	sort.Slice(report.VMs, func(i, j int) bool {
+		return vmIndexKey(report.VMs[i].Namespace, report.VMs[i].Name) <
+			vmIndexKey(report.VMs[j].Namespace, report.VMs[j].Name)
+	})
+	return report
+}
+
+// StartCleanup drops expired entries periodically until ctx is cancelled,
+// so VMs woken once do not stay in memory when nobody polls the endpoint
+func (d *WakeDemand) StartCleanup(ctx context.Context) {
+	ticker := time.NewTicker(d.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.mu.Lock()
+			d.prune(now)
+			d.mu.Unlock()
+		}
+	}
+}
+
+// prune drops the wakes outside the window ending at now. The caller holds d.mu.
+func (d *WakeDemand) prune(now time.Time) {
+	cutoff := now.Add(-d.window)
+	for key, entry := range d.entries {
+		entry.wakes = pruneWakes(entry.wakes, cutoff)
+		if len(entry.wakes) == 0 {
+			delete(d.entries, key)
+		}
+	}
+}
+
+// ServeHTTP serves the report as JSON. The namespace, name and wolconfig query
+// parameters select the VMs, e.g. /wake-demand?namespace=default&name=my-vm
+func (d *WakeDemand) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	report := d.Report(WakeDemandFilter{
+		Namespace: query.Get("namespace"),
+		Name:      query.Get("name"),
+		WolConfig: query.Get("wolconfig"),
+	}, time.Now())
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+func (f WakeDemandFilter) matches(info VMInfo) bool {
+	return (f.Namespace == "" || f.Namespace == info.Namespace) &&
+		(f.Name == "" || f.Name == info.Name) &&
+		(f.WolConfig == "" || f.WolConfig == info.ConfigName)
+}
+
+// pruneWakes drops the wakes older than cutoff
+func pruneWakes(wakes []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(wakes) && wakes[i].Before(cutoff) {
+		i++
+	}
+	return wakes[i:]
+}
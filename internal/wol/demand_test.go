@@ -0,0 +1,86 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWakeDemand_Report(t *testing.T) {
+	demand := NewWakeDemand(time.Minute)
+	now := time.Now()
+
+	vm1 := VMInfo{Name: "vm1", Namespace: "default", ConfigName: "cfg"}
+	vm2 := VMInfo{Name: "vm2", Namespace: "other", ConfigName: "cfg"}
+	demand.Record(vm1, now.Add(-2*time.Minute)) // outside the window
+	demand.Record(vm1, now.Add(-30*time.Second))
+	demand.Record(vm1, now.Add(-10*time.Second))
+	demand.Record(vm2, now.Add(-5*time.Second))
+
+	report := demand.Report(WakeDemandFilter{}, now)
+	if report.RecentWakes != 3 || report.ActiveVMs != 2 {
+		t.Errorf("Expected 3 wakes on 2 VMs, got %d on %d", report.RecentWakes, report.ActiveVMs)
+	}
+
+	report = demand.Report(WakeDemandFilter{Namespace: "default", Name: "vm1"}, now)
+	if report.RecentWakes != 2 || len(report.VMs) != 1 || report.VMs[0].Name != "vm1" {
+		t.Errorf("Expected 2 wakes for vm1, got %+v", report)
+	}
+
+	// Everything expires once the window has passed
+	report = demand.Report(WakeDemandFilter{}, now.Add(2*time.Minute))
+	if report.RecentWakes != 0 || len(demand.entries) != 0 {
+		t.Errorf("Expected expired demand to be dropped, got %+v", report)
+	}
+}
+
+func TestWakeDemand_ServeHTTP(t *testing.T) {
+	demand := NewWakeDemand(time.Minute)
+	demand.Record(VMInfo{Name: "vm1", Namespace: "default", ConfigName: "cfg"}, time.Now())
+
+	rec := httptest.NewRecorder()
+	demand.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/wake-demand?wolconfig=cfg", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	var report WakeDemandReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if report.RecentWakes != 1 || report.WindowSeconds != 60 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+}
+
+func TestWakeDemand_Prune(t *testing.T) {
+	demand := NewWakeDemand(time.Minute)
+	now := time.Now()
+	demand.Record(VMInfo{Name: "vm1", Namespace: "default"}, now.Add(-2*time.Minute))
+	demand.Record(VMInfo{Name: "vm2", Namespace: "default"}, now)
+
+	demand.mu.Lock()
+	demand.prune(now)
+	demand.mu.Unlock()
+	if len(demand.entries) != 1 {
+		t.Errorf("Expected only the recent VM to be kept, got %d entries", len(demand.entries))
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"slices"
+	"time"
+)
+
+// Many WoL clients (etherwake to an IP, wakeonlan -i <last IP>, router ARP
+// caches) send the magic packet as unicast UDP to the last-known IP of the
+// target instead of a broadcast. Such packets are addressed to a VM, not to the
+// node, so they never reach the UDP listener: the raw listeners capture them
+// (IPv4, UDP on the WOL ports) and report them like the broadcast ones.
+
+const etherTypeIPv4 = 0x0800
+
+// DirectedPacket is a magic packet carried by IPv4 UDP (unicast to a VM, or to
+// one of the raw UDP ports), captured by a raw listener
+type DirectedPacket struct {
+	TargetMAC   string
+	Payload     []byte // payload UDP (magic packet ed eventuale password SecureOn)
+	Source      *net.UDPAddr
+	Destination net.IP
+	Port        uint16 // porta UDP di destinazione
+}
+
+// parseDirectedUDP parses the payload of an Ethernet/IPv4 frame carrying a
+// magic packet as UDP to one of ports. Fragments are ignored: a magic packet
+// always fits in one datagram.
+func parseDirectedUDP(payload []byte, ports []uint16) (DirectedPacket, bool) {
+	if len(payload) < 20 || payload[0]>>4 != 4 || payload[9] != 17 {
+		return DirectedPacket{}, false
+	}
+	headerLen := int(payload[0]&0x0f) * 4
+	totalLen := int(binary.BigEndian.Uint16(payload[2:4]))
+	if headerLen < 20 || totalLen < headerLen+8 || totalLen > len(payload) {
+		return DirectedPacket{}, false
+	}
+	// MF o offset diverso da zero: frammento
+	if binary.BigEndian.Uint16(payload[6:8])&0x3fff != 0 {
+		return DirectedPacket{}, false
+	}
+
+	// Il frame può avere padding Ethernet oltre la lunghezza IP
+	udp := payload[headerLen:totalLen]
+	dstPort := binary.BigEndian.Uint16(udp[2:4])
+	if !slices.Contains(ports, dstPort) {
+		return DirectedPacket{}, false
+	}
+	data := udp[8:]
+	if udpLen := int(binary.BigEndian.Uint16(udp[4:6])); udpLen >= 8 && udpLen <= len(udp) {
+		data = udp[8:udpLen]
+	}
+	mac, valid := parseMagicPacket(data)
+	if !valid {
+		return DirectedPacket{}, false
+	}
+
+	return DirectedPacket{
+		TargetMAC: mac,
+		Payload:   append([]byte{}, data...),
+		Source: &net.UDPAddr{
+			IP:   net.IP(append([]byte{}, payload[12:16]...)),
+			Port: int(binary.BigEndian.Uint16(udp[0:2])),
+		},
+		Destination: net.IP(append([]byte{}, payload[16:20]...)),
+		Port:        dstPort,
+	}, true
+}
+
+// SetDirectedWakePorts enables the capture of magic packets sent as unicast
+// UDP to the given ports on the raw listeners (nil disables it). Packets unicast
+// to a VM MAC reach a NIC that is not a bridge port only in promiscuous mode.
+// Must be called before Start.
+func (a *Agent) SetDirectedWakePorts(ports []int) {
+	a.directedPorts = uniquePorts(ports)
+}
+
+// SetRawCapture makes the raw listeners also parse the IPv4 UDP datagrams to
+// udpPorts, broadcast included (e.g. 7 for routers that rewrite WoL into
+// UDP/7), and the frames with etherTypes besides 0x0842. Must be called before Start.
+func (a *Agent) SetRawCapture(udpPorts []int, etherTypes []uint16) {
+	a.rawUDPPorts = uniquePorts(udpPorts)
+	a.rawEtherTypes = etherTypes
+}
+
+// uniquePorts returns the valid ports, without duplicates
+func uniquePorts(ports []int) []uint16 {
+	var unique []uint16
+	for _, port := range ports {
+		if port > 0 && port <= 65535 && !slices.Contains(unique, uint16(port)) {
+			unique = append(unique, uint16(port))
+		}
+	}
+	return unique
+}
+
+// rawDirectedHandler reports a UDP magic packet captured by a raw listener,
+// on its UDP port so that the local dedupe matches the UDP listener's copy
+func (a *Agent) rawDirectedHandler(pkt DirectedPacket) {
+	a.packetLog.Info(a.log.V(7), "Directed WoL packet forwarded to processing",
+		"targetMAC", pkt.TargetMAC,
+		"destination", pkt.Destination.String(),
+		"port", pkt.Port)
+
+	receivedAt := time.Now()
+	a.report(func(reportCtx context.Context) {
+		a.processPacket(reportCtx, pkt.Payload, pkt.Source, uint32(pkt.Port), false, receivedAt)
+	})
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// udpFrame builds an Ethernet/IPv4/UDP frame to dstMAC, 10.0.0.42:port
+func udpFrame(dstMAC []byte, port uint16, data []byte) []byte {
+	udp := binary.BigEndian.AppendUint16(nil, 40000)
+	udp = binary.BigEndian.AppendUint16(udp, port)
+	udp = binary.BigEndian.AppendUint16(udp, uint16(8+len(data)))
+	udp = append(udp, 0, 0)
+	udp = append(udp, data...)
+
+	ip := []byte{0x45, 0}
+	ip = binary.BigEndian.AppendUint16(ip, uint16(20+len(udp)))
+	ip = append(ip, 0, 0, 0x40, 0, 64, 17, 0, 0, 192, 168, 1, 10, 10, 0, 0, 42)
+
+	frame := append(append([]byte{}, dstMAC...), 0x02, 0, 0, 0, 0, 1)
+	frame = binary.BigEndian.AppendUint16(frame, etherTypeIPv4)
+	return append(append(frame, ip...), udp...)
+}
+
+func TestParseDirectedUDP(t *testing.T) {
+	mac := []byte{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	magic := buildMagicPacket(mac, nil)
+	ports := []uint16{9, 7}
+
+	tests := []struct {
+		name  string
+		frame []byte
+		want  bool
+	}{
+		{"port 9", udpFrame(mac, 9, magic), true},
+		{"port 7", udpFrame(mac, 7, magic), true},
+		// Il padding Ethernet oltre la lunghezza IP viene ignorato
+		{"padded frame", append(udpFrame(mac, 9, magic), make([]byte, 20)...), true},
+		{"other port", udpFrame(mac, 53, magic), false},
+		{"not a magic packet", udpFrame(mac, 9, bytes.Repeat([]byte{0xaa}, 102)), false},
+		{"truncated", udpFrame(mac, 9, magic)[:60], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt, ok := parseDirectedUDP(tt.frame[14:], ports)
+			if ok != tt.want {
+				t.Fatalf("Expected %v, got %v", tt.want, ok)
+			}
+			if ok && (pkt.TargetMAC != "52:54:00:00:00:01" || pkt.Destination.String() != "10.0.0.42" ||
+				pkt.Source.String() != "192.168.1.10:40000" || len(pkt.Payload) != len(magic)) {
+				t.Errorf("Unexpected packet %+v", pkt)
+			}
+		})
+	}
+
+	fragment := udpFrame(mac, 9, magic)
+	fragment[14+6] = 0x20 // More Fragments
+	if _, ok := parseDirectedUDP(fragment[14:], ports); ok {
+		t.Error("Expected a fragment to be ignored")
+	}
+}
+
+func TestRawListener_DirectedPacket(t *testing.T) {
+	var wakes []DirectedPacket
+	listener := NewRawListener("test0", nil, logr.Discard())
+	listener.SetDirectedHandler([]uint16{9}, func(pkt DirectedPacket) {
+		wakes = append(wakes, pkt)
+	})
+
+	mac := []byte{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	listener.processEthernetFrame(udpFrame(mac, 9, buildMagicPacket(mac, nil)))
+	// Il broadcast è gestito dal listener UDP
+	listener.processEthernetFrame(udpFrame(bytes.Repeat([]byte{0xff}, 6), 9, buildMagicPacket(mac, nil)))
+
+	if len(wakes) != 1 || wakes[0].Port != 9 || wakes[0].TargetMAC != "52:54:00:00:00:01" {
+		t.Errorf("Expected one directed wake, got %+v", wakes)
+	}
+
+	// Senza handler i frame IPv4 sono ignorati
+	plain := NewRawListener("test0", func(string, []byte, net.HardwareAddr) {
+		t.Error("Expected no wake without the directed handler")
+	}, logr.Discard())
+	plain.processEthernetFrame(udpFrame(mac, 9, buildMagicPacket(mac, nil)))
+}
+
+func TestRawListener_CaptureOptions(t *testing.T) {
+	var wakes []string
+	var udp []DirectedPacket
+	listener := NewRawListenerWithOptions("test0", func(mac string, _ []byte, _ net.HardwareAddr) {
+		wakes = append(wakes, mac)
+	}, logr.Discard(), RawListenerOptions{EtherTypes: []uint16{0x88b7}, UDPPorts: []uint16{7}})
+	listener.SetDirectedHandler(nil, func(pkt DirectedPacket) {
+		udp = append(udp, pkt)
+	})
+
+	mac := []byte{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	broadcast := bytes.Repeat([]byte{0xff}, 6)
+	l2 := func(etherType uint16) []byte {
+		frame := append(append([]byte{}, broadcast...), 0x02, 0, 0, 0, 0, 1)
+		return append(binary.BigEndian.AppendUint16(frame, etherType), buildMagicPacket(mac, nil)...)
+	}
+	listener.processEthernetFrame(l2(etherTypeWoL))
+	listener.processEthernetFrame(l2(0x88b7))
+	listener.processEthernetFrame(l2(0x88b8))
+	// UDP/7 anche in broadcast, UDP/9 no (non è tra le porte)
+	listener.processEthernetFrame(udpFrame(broadcast, 7, buildMagicPacket(mac, nil)))
+	listener.processEthernetFrame(udpFrame(mac, 9, buildMagicPacket(mac, nil)))
+
+	if len(wakes) != 2 {
+		t.Errorf("Expected the 0x0842 and 0x88b7 frames, got %v", wakes)
+	}
+	if len(udp) != 1 || udp[0].Port != 7 {
+		t.Errorf("Expected only the UDP/7 broadcast, got %+v", udp)
+	}
+	if ports := listener.capturedUDPPorts(); len(ports) != 1 || ports[0] != 7 {
+		t.Errorf("Expected the BPF filter to capture UDP/7, got %v", ports)
+	}
+}
+
+func TestCaptureFilter(t *testing.T) {
+	mac := []byte{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	magic := buildMagicPacket(mac, nil)
+	l2 := func(etherType uint16) []byte {
+		frame := append(bytes.Repeat([]byte{0xff}, 6), 0x02, 0, 0, 0, 0, 1)
+		return append(binary.BigEndian.AppendUint16(frame, etherType), magic...)
+	}
+	tcp := udpFrame(mac, 9, magic)
+	tcp[14+9] = 6
+	fragment := udpFrame(mac, 9, magic)
+	fragment[14+7] = 0x10 // offset del frammento diverso da zero
+
+	run := func(t *testing.T, filter []bpf.RawInstruction, frame []byte) bool {
+		t.Helper()
+		vm, err := bpf.NewVM(mustDisassemble(t, filter))
+		if err != nil {
+			t.Fatalf("Invalid filter: %v", err)
+		}
+		n, err := vm.Run(frame)
+		if err != nil {
+			t.Fatalf("Filter failed: %v", err)
+		}
+		return n > 0
+	}
+
+	tests := []struct {
+		name           string
+		frame          []byte
+		plain, withUDP bool
+	}{
+		{"WoL frame", l2(etherTypeWoL), true, true},
+		{"ARP", l2(etherTypeARP), true, true},
+		{"other EtherType", l2(0x88b5), false, false},
+		{"UDP to port 9", udpFrame(mac, 9, magic), false, true},
+		{"UDP to port 7", udpFrame(mac, 7, magic), false, true},
+		{"UDP to port 53", udpFrame(mac, 53, magic), false, false},
+		{"TCP to port 9", tcp, false, false},
+		{"later fragment", fragment, false, false},
+	}
+	plain := toRaw(captureFilter([]uint16{etherTypeWoL, etherTypeARP}, nil, false))
+	withUDP := toRaw(captureFilter([]uint16{etherTypeWoL, etherTypeARP}, []uint16{9, 7}, false))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := run(t, plain, tt.frame); got != tt.plain {
+				t.Errorf("EtherType filter: expected %v, got %v", tt.plain, got)
+			}
+			if got := run(t, withUDP, tt.frame); got != tt.withUDP {
+				t.Errorf("Filter with UDP ports: expected %v, got %v", tt.withUDP, got)
+			}
+		})
+	}
+}
+
+// toRaw converts a socket filter to the instructions of the bpf package VM
+func toRaw(filter []unix.SockFilter) []bpf.RawInstruction {
+	raw := make([]bpf.RawInstruction, len(filter))
+	for i, ins := range filter {
+		raw[i] = bpf.RawInstruction{Op: ins.Code, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	return raw
+}
+
+func mustDisassemble(t *testing.T, raw []bpf.RawInstruction) []bpf.Instruction {
+	t.Helper()
+	instructions, ok := bpf.Disassemble(raw)
+	if !ok {
+		t.Fatalf("Filter has instructions the VM does not know: %v", instructions)
+	}
+	return instructions
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// EnvOperatorAddress is the gRPC address (host:port) of the operator,
+	// injected by the controller in the agent DaemonSets
+	EnvOperatorAddress = "OPERATOR_ADDRESS"
+	// EnvOperatorService is the DNS name of the operator gRPC Service, looked
+	// up with a _grpc._tcp SRV query (host:port is used as is)
+	EnvOperatorService = "OPERATOR_SERVICE"
+
+	// DefaultOperatorService is the name of the operator gRPC Service in the default install
+	DefaultOperatorService = "kubevirt-wol-grpc"
+	// DefaultOperatorPort is the port of the operator gRPC server
+	DefaultOperatorPort = 9090
+	// defaultOperatorNamespace is the namespace of the default install
+	defaultOperatorNamespace = "kubevirt-wol-system"
+)
+
+// srvLookup resolves a DNS SRV record, like net.Resolver.LookupSRV
+type srvLookup func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+
+// ResolveOperatorAddress returns the gRPC address of the operator for an agent
+// started without --operator-address: $OPERATOR_ADDRESS, then $OPERATOR_SERVICE
+// (resolved with its _grpc._tcp SRV record, or port 9090 without one), then the
+// default Service in the namespace of the agent ($POD_NAMESPACE).
+func ResolveOperatorAddress(ctx context.Context, getenv func(string) string) string {
+	return resolveOperatorAddress(ctx, getenv, net.DefaultResolver.LookupSRV)
+}
+
+func resolveOperatorAddress(ctx context.Context, getenv func(string) string, lookup srvLookup) string {
+	if address := strings.TrimSpace(getenv(EnvOperatorAddress)); address != "" {
+		return address
+	}
+	if service := strings.TrimSpace(getenv(EnvOperatorService)); service != "" {
+		if _, _, err := net.SplitHostPort(service); err == nil {
+			return service
+		}
+		// La porta del Service si chiama "grpc": _grpc._tcp.<service>
+		if _, records, err := lookup(ctx, "grpc", "tcp", service); err == nil && len(records) > 0 {
+			target := strings.TrimSuffix(records[0].Target, ".")
+			return net.JoinHostPort(target, strconv.Itoa(int(records[0].Port)))
+		}
+		return net.JoinHostPort(service, strconv.Itoa(DefaultOperatorPort))
+	}
+	return DefaultOperatorAddress(getenv("POD_NAMESPACE"))
+}
+
+// DefaultOperatorAddress returns the address of the default gRPC Service in
+// namespace (the namespace of the default install if empty)
+func DefaultOperatorAddress(namespace string) string {
+	if namespace == "" {
+		namespace = defaultOperatorNamespace
+	}
+	return net.JoinHostPort(DefaultOperatorService+"."+namespace+".svc", strconv.Itoa(DefaultOperatorPort))
+}
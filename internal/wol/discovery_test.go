@@ -0,0 +1,53 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestResolveOperatorAddress(t *testing.T) {
+	lookup := func(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if service != "grpc" || proto != "tcp" || name != "wol-grpc.wol.svc" {
+			return "", nil, errors.New("no such host")
+		}
+		return "", []*net.SRV{{Target: "wol-grpc.wol.svc.cluster.local.", Port: 19090}}, nil
+	}
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"address", map[string]string{EnvOperatorAddress: "op:9443", EnvOperatorService: "ignored"}, "op:9443"},
+		{"srv", map[string]string{EnvOperatorService: "wol-grpc.wol.svc"}, "wol-grpc.wol.svc.cluster.local:19090"},
+		{"service without srv", map[string]string{EnvOperatorService: "other.wol.svc"}, "other.wol.svc:9090"},
+		{"service with port", map[string]string{EnvOperatorService: "other.wol.svc:7000"}, "other.wol.svc:7000"},
+		{"pod namespace", map[string]string{"POD_NAMESPACE": "custom"}, "kubevirt-wol-grpc.custom.svc:9090"},
+		{"default", nil, "kubevirt-wol-grpc.kubevirt-wol-system.svc:9090"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolveOperatorAddress(context.Background(), func(key string) string { return tt.env[key] }, lookup)
+			if got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
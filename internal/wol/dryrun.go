@@ -0,0 +1,89 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// The packets and wake requests of a WolConfig with spec.dryRun go through
+// the whole pipeline (dedupe, lookup, SecureOn, rate limit, WolPolicy, metrics,
+// Events, audit) but the action on the VM (start, stop, pause) or the forward
+// is skipped: the response is DRY_RUN, with what would have been done.
+
+// DryRun returns true if the WolConfig is in dry-run
+func (m *MACMapper) DryRun(configName string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range m.configs {
+		if m.configs[i].Name == configName {
+			return m.configs[i].Spec.DryRun
+		}
+	}
+	return false
+}
+
+// skipDryRun records an action not taken on a VM (or a forward) because its
+// WolConfig is in dry-run
+func (a *Aggregator) skipDryRun(configName, action, target string) {
+	DryRunActionsTotal.WithLabelValues(configName, action).Inc()
+	a.log.Info("Dry run: action not taken", "wolconfig", configName, "action", action, "target", target)
+}
+
+// dryRunWake returns the response to a wake of a VM whose WolConfig is in
+// dry-run, nil if it is not. mac is the MAC of the packet ("" for a wake
+// request): the other VMs claiming it would be started too.
+func (a *Aggregator) dryRunWake(ctx context.Context, vmInfo VMInfo, action, mac, from string, startTime time.Time) *wolv1.WOLEventResponse {
+	if !a.mapper.DryRun(vmInfo.ConfigName) {
+		return nil
+	}
+	vm := &wolv1.VMInfo{Name: vmInfo.Name, Namespace: vmInfo.Namespace}
+	if reader, ok := a.vmStarter.(VMStateReader); ok {
+		state, node, err := reader.VMState(ctx, vmInfo.Namespace, vmInfo.Name)
+		if err != nil {
+			a.log.V(1).Info("Cannot read the VM state", "vm", vmInfo.Name, "namespace", vmInfo.Namespace,
+				"error", err.Error())
+		}
+		vm.CurrentState, vm.NodeName = state, node
+	}
+
+	resp := &wolv1.WOLEventResponse{VmInfo: vm}
+	if vm.CurrentState == VMStateRunning {
+		resp.Status = wolv1.ResponseStatus_VM_ALREADY_RUNNING
+		resp.Message = fmt.Sprintf("VM already running on node %s (matched by WolConfig %s in dry-run, %s mapping)",
+			vm.NodeName, vmInfo.ConfigName, vmInfo.MappingType)
+	} else {
+		a.skipDryRun(vmInfo.ConfigName, action, vmIndexKey(vmInfo.Namespace, vmInfo.Name))
+		resp.Status = wolv1.ResponseStatus_DRY_RUN
+		resp.Message = fmt.Sprintf("Dry run: VM would be woken with action %s from %s (matched by WolConfig %s, %s mapping)",
+			action, from, vmInfo.ConfigName, vmInfo.MappingType)
+	}
+	if mac != "" {
+		if companions := a.mapper.LookupCompanions(mac); len(companions) > 0 {
+			resp.Message += fmt.Sprintf("; %d other VMs claiming the MAC would be started (StartAll)", len(companions))
+		}
+	}
+	if vmInfo.WakeWith != "" {
+		resp.Message += fmt.Sprintf("; dependencies %s would be started (wake-with)", vmInfo.WakeWith)
+	}
+	resp.ProcessingTimeMs = time.Since(startTime).Milliseconds()
+	return resp
+}
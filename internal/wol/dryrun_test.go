@@ -0,0 +1,96 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestAggregator_DryRun(t *testing.T) {
+	ctx := context.Background()
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+	staged := wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:01", VMName: "desktop", Namespace: "default"},
+				{MACAddress: "aa:bb:cc:00:00:01", Forward: &wolv1beta1.WOLForwardTarget{Address: "127.0.0.1", Port: 9}},
+			},
+			ShutdownOnLAN: &wolv1beta1.ShutdownOnLANSpec{Enabled: true},
+			DryRun:        true,
+		},
+	}
+	staged.Name = "staged"
+	live := wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:02", VMName: "server", Namespace: "default"},
+			},
+		},
+	}
+	live.Name = "live"
+	mapper.UpdateConfigs([]wolv1beta1.WolConfig{staged, live})
+	if err := mapper.RefreshMapping(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	starter := &stopStarter{policyStarter{actions: make(map[string]string)}}
+	agg := NewAggregator(mapper, starter, logr.Discard())
+	skipped := testutil.ToFloat64(DryRunActionsTotal.WithLabelValues("staged", "Start"))
+
+	resp, _ := agg.ReportWOLEvent(ctx, &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01", NodeName: "node-a"})
+	if resp.Status != wolv1.ResponseStatus_DRY_RUN || resp.VmInfo.GetName() != "desktop" {
+		t.Fatalf("Expected a dry-run wake of the desktop, got %v: %s", resp.Status, resp.Message)
+	}
+	if got := testutil.ToFloat64(DryRunActionsTotal.WithLabelValues("staged", "Start")); got != skipped+1 {
+		t.Errorf("Expected the skipped start to be counted, got %v", got-skipped)
+	}
+	// Il pacchetto registrato in dry-run è deduplicato come gli altri
+	resp, _ = agg.ReportWOLEvent(ctx, &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01", NodeName: "node-b"})
+	if resp.Status != wolv1.ResponseStatus_DUPLICATE {
+		t.Errorf("Expected the copy of the packet to be a duplicate, got %v", resp.Status)
+	}
+
+	resp, _ = agg.ReportWOLEvent(ctx, &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01", Sleep: true})
+	if resp.Status != wolv1.ResponseStatus_DRY_RUN {
+		t.Errorf("Expected a dry-run stop of the desktop, got %v", resp.Status)
+	}
+	resp, _ = agg.ReportWOLEvent(ctx, &wolv1.WOLEvent{MacAddress: "aa:bb:cc:00:00:01"})
+	if resp.Status != wolv1.ResponseStatus_DRY_RUN {
+		t.Errorf("Expected a dry-run forward, got %v", resp.Status)
+	}
+	resp, _ = agg.RequestWake(ctx, &wolv1.WakeRequest{Namespace: "default", Name: "desktop", Source: "api"})
+	if resp.Status != wolv1.ResponseStatus_DRY_RUN {
+		t.Errorf("Expected a dry-run wake request, got %v", resp.Status)
+	}
+	if len(starter.actions) != 0 {
+		t.Fatalf("Expected no action on the VMs in dry-run, got %v", starter.actions)
+	}
+
+	// Le altre WolConfig agiscono normalmente
+	resp, _ = agg.ReportWOLEvent(ctx, &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:02"})
+	if resp.Status != wolv1.ResponseStatus_VM_START_INITIATED || starter.actions["server"] != "start" {
+		t.Errorf("Expected the server to be started, got %v (%v)", resp.Status, starter.actions)
+	}
+}
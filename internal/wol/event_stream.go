@@ -0,0 +1,207 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+const (
+	// eventStreamMinBackoff is the first wait before reopening a failed event stream
+	eventStreamMinBackoff = time.Second
+	// eventStreamMaxBackoff caps the wait between reopen attempts
+	eventStreamMaxBackoff = 30 * time.Second
+)
+
+var (
+	errEventStreamDisabled = errors.New("event stream not supported by the operator")
+	errEventStreamBackoff  = errors.New("event stream is reconnecting")
+	errEventStreamClosed   = errors.New("event stream closed before the response")
+)
+
+// eventStream reports WOL events on a long-lived ReportWOLEventStream. The
+// stream is opened on the first event and reopened with backoff after a
+// failure; the responses are matched to the events by sequence.
+type eventStream struct {
+	client wolv1.WOLServiceClient
+	ctx    context.Context // durata dello stream (reportCtx dell'agent)
+	log    logr.Logger
+
+	mu       sync.Mutex // serializza Send e protegge lo stato sotto
+	stream   grpc.BidiStreamingClient[wolv1.WOLEvent, wolv1.WOLEventResponse]
+	pending  map[uint64]chan *wolv1.WOLEventResponse
+	sequence uint64
+	backoff  time.Duration
+	retryAt  time.Time
+	disabled bool // l'operatore non implementa lo stream
+}
+
+func newEventStream(ctx context.Context, client wolv1.WOLServiceClient, log logr.Logger) *eventStream {
+	return &eventStream{
+		client:  client,
+		ctx:     ctx,
+		log:     log,
+		pending: make(map[uint64]chan *wolv1.WOLEventResponse),
+	}
+}
+
+// report sends an event on the stream and waits for its response. An error
+// means the stream could not be used and the caller should fall back to a
+// unary call, unless ctx itself expired.
+func (s *eventStream) report(ctx context.Context, event *wolv1.WOLEvent) (*wolv1.WOLEventResponse, error) {
+	s.mu.Lock()
+	if s.disabled {
+		s.mu.Unlock()
+		return nil, errEventStreamDisabled
+	}
+	if s.stream == nil {
+		if time.Now().Before(s.retryAt) {
+			s.mu.Unlock()
+			return nil, errEventStreamBackoff
+		}
+		stream, err := s.client.ReportWOLEventStream(s.ctx)
+		if err != nil {
+			s.closeLocked(nil, err)
+			s.mu.Unlock()
+			return nil, err
+		}
+		s.log.V(1).Info("Event stream opened")
+		s.stream = stream
+		go s.receive(stream)
+	}
+
+	s.sequence++
+	sequence := s.sequence
+	reply := make(chan *wolv1.WOLEventResponse, 1)
+	s.pending[sequence] = reply
+
+	streamed := proto.Clone(event).(*wolv1.WOLEvent)
+	streamed.Sequence = sequence
+	if err := s.stream.Send(streamed); err != nil {
+		// L'errore vero arriva da Recv: qui basta chiudere lo stream
+		s.closeLocked(s.stream, err)
+		s.mu.Unlock()
+		return nil, err
+	}
+	s.mu.Unlock()
+
+	select {
+	case resp, ok := <-reply:
+		if !ok {
+			return nil, errEventStreamClosed
+		}
+		return resp, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		delete(s.pending, sequence)
+		s.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// receive delivers the responses of a stream until it fails
+func (s *eventStream) receive(stream grpc.BidiStreamingClient[wolv1.WOLEvent, wolv1.WOLEventResponse]) {
+	for {
+		resp, err := stream.Recv()
+		s.mu.Lock()
+		if err != nil {
+			s.closeLocked(stream, err)
+			s.mu.Unlock()
+			return
+		}
+		s.backoff = 0
+		reply, ok := s.pending[resp.Sequence]
+		delete(s.pending, resp.Sequence)
+		s.mu.Unlock()
+
+		// Risposta a un evento già scaduto
+		if ok {
+			reply <- resp
+		}
+	}
+}
+
+// closeLocked drops a failed stream (nil if it could not be opened), fails
+// its pending events and schedules the next attempt. s.mu must be held.
+func (s *eventStream) closeLocked(stream grpc.BidiStreamingClient[wolv1.WOLEvent, wolv1.WOLEventResponse], err error) {
+	if status.Code(err) == codes.Unimplemented && !s.disabled {
+		s.log.Info("Operator does not support the event stream, using unary calls")
+		s.disabled = true
+	}
+	if s.stream != stream {
+		return
+	}
+	if stream != nil {
+		_ = stream.CloseSend()
+	}
+	s.stream = nil
+	for sequence, reply := range s.pending {
+		close(reply)
+		delete(s.pending, sequence)
+	}
+
+	if s.backoff == 0 {
+		s.backoff = eventStreamMinBackoff
+	} else {
+		s.backoff = min(2*s.backoff, eventStreamMaxBackoff)
+	}
+	s.retryAt = time.Now().Add(s.backoff)
+	if !s.disabled && s.ctx.Err() == nil {
+		s.log.V(1).Info("Event stream failed, using unary calls until it reopens",
+			"error", err.Error(), "retryIn", s.backoff.String())
+	}
+}
+
+// SetStreamEvents makes the agent report the events on a long-lived gRPC
+// stream instead of one unary call per packet
+func (a *Agent) SetStreamEvents(enable bool) {
+	a.streamEvents = enable
+}
+
+// sendEvent reports an event to the operator on the event stream, or with a
+// unary call when streaming is disabled or unavailable
+func (a *Agent) sendEvent(ctx context.Context, event *wolv1.WOLEvent) (*wolv1.WOLEventResponse, error) {
+	if a.events != nil {
+		start := time.Now()
+		resp, err := a.events.report(ctx, event)
+		// Con lo stream in backoff o disabilitato non c'è stata nessuna chiamata
+		notTried := errors.Is(err, errEventStreamBackoff) || errors.Is(err, errEventStreamDisabled)
+		if !notTried {
+			a.metrics.observeReport("stream", start)
+		}
+		if err == nil || ctx.Err() != nil {
+			return resp, err
+		}
+		if !notTried {
+			a.log.V(1).Info("Event stream unavailable, falling back to a unary call",
+				"mac", event.MacAddress, "error", err.Error())
+		}
+	}
+	defer a.metrics.observeReport("unary", time.Now())
+	return a.grpcClient.ReportWOLEvent(ctx, event)
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// streamService counts the unary reports and can refuse the event stream
+type streamService struct {
+	*Aggregator
+	unary    atomic.Int32
+	noStream bool
+}
+
+func (s *streamService) ReportWOLEvent(ctx context.Context, event *wolv1.WOLEvent) (*wolv1.WOLEventResponse, error) {
+	s.unary.Add(1)
+	return s.Aggregator.ReportWOLEvent(ctx, event)
+}
+
+func (s *streamService) ReportWOLEventStream(stream wolv1.WOLService_ReportWOLEventStreamServer) error {
+	if s.noStream {
+		return status.Error(codes.Unimplemented, "method ReportWOLEventStream not implemented")
+	}
+	return s.Aggregator.ReportWOLEventStream(stream)
+}
+
+// newStreamAgent serves an aggregator with count VMs and returns an agent
+// connected to it with the event stream enabled
+func newStreamAgent(t *testing.T, count int, noStream bool) (*Agent, *streamService) {
+	t.Helper()
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{DiscoveryMode: wolv1beta1.DiscoveryModeExplicit},
+	}
+	config.Name = "lab"
+	for i := range count {
+		config.Spec.ExplicitMappings = append(config.Spec.ExplicitMappings, wolv1beta1.MACVMMapping{
+			MACAddress: fmt.Sprintf("52:54:00:00:00:%02x", i), VMName: fmt.Sprintf("vm%d", i), Namespace: "default",
+		})
+	}
+	mapper.UpdateConfig(config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service := &streamService{
+		Aggregator: NewAggregator(mapper, &policyStarter{actions: make(map[string]string)}, logr.Discard()),
+		noStream:   noStream,
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	wolv1.RegisterWOLServiceServer(server, service)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	agent := NewAgent(0, "node1", lis.Addr().String(), logr.Discard())
+	grpcConn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = grpcConn.Close() })
+	agent.grpcConn = newTrackedConn(grpcConn)
+	agent.grpcClient = wolv1.NewWOLServiceClient(agent.grpcConn)
+	agent.events = newEventStream(context.Background(), agent.grpcClient, logr.Discard())
+	return agent, service
+}
+
+func TestAgent_StreamEvents(t *testing.T) {
+	agent, service := newStreamAgent(t, 8, false)
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := agent.sendEvent(context.Background(), &wolv1.WOLEvent{
+				MacAddress: fmt.Sprintf("52:54:00:00:00:%02x", i), NodeName: "node1", DestinationPort: 9,
+			})
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			// Ogni risposta torna all'evento giusto anche fuori ordine
+			if resp.VmInfo == nil || resp.VmInfo.Name != fmt.Sprintf("vm%d", i) {
+				t.Errorf("Event %d got the response of %v", i, resp.VmInfo)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if unary := service.unary.Load(); unary != 0 {
+		t.Errorf("Expected every event on the stream, got %d unary calls", unary)
+	}
+}
+
+func TestAgent_StreamEventsFallback(t *testing.T) {
+	agent, service := newStreamAgent(t, 1, true)
+
+	for range 2 {
+		resp, err := agent.sendEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:00", DestinationPort: 9})
+		if err != nil || resp.VmInfo == nil || resp.VmInfo.Name != "vm0" {
+			t.Fatalf("Unexpected response %v: %v", resp, err)
+		}
+	}
+	if unary := service.unary.Load(); unary != 2 {
+		t.Errorf("Expected 2 unary calls, got %d", unary)
+	}
+	agent.events.mu.Lock()
+	defer agent.events.mu.Unlock()
+	if !agent.events.disabled {
+		t.Error("Expected the stream to be disabled after Unimplemented")
+	}
+}
+
+func TestEventStream_Backoff(t *testing.T) {
+	s := newEventStream(context.Background(), nil, logr.Discard())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var waits []string
+	for range 7 {
+		s.closeLocked(nil, status.Error(codes.Unavailable, "down"))
+		waits = append(waits, s.backoff.String())
+	}
+	if fmt.Sprint(waits) != "[1s 2s 4s 8s 16s 30s 30s]" {
+		t.Errorf("Unexpected backoff %v", waits)
+	}
+	if s.disabled {
+		t.Error("Expected Unavailable not to disable the stream")
+	}
+}
+
+// heldStarter holds every start until release is closed, counting the
+// starts and the most in progress at the same time
+type heldStarter struct {
+	release    chan struct{}
+	starts     atomic.Int32
+	inProgress atomic.Int32
+	maxSeen    atomic.Int32
+}
+
+func (s *heldStarter) StartVMAs(ctx context.Context, _, _, _ string) error {
+	s.starts.Add(1)
+	n := s.inProgress.Add(1)
+	defer s.inProgress.Add(-1)
+	for {
+		seen := s.maxSeen.Load()
+		if n <= seen || s.maxSeen.CompareAndSwap(seen, n) {
+			break
+		}
+	}
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+	}
+	return nil
+}
+func (s *heldStarter) QueuedWakes() int { return 0 }
+
+func TestAggregator_StreamEventConcurrency(t *testing.T) {
+	count := 2 * streamEventConcurrency
+	agent, service := newStreamAgent(t, count, false)
+	starter := &heldStarter{release: make(chan struct{})}
+	service.vmStarter = starter
+
+	var wg sync.WaitGroup
+	for i := range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := agent.sendEvent(context.Background(), &wolv1.WOLEvent{
+				MacAddress: fmt.Sprintf("52:54:00:00:00:%02x", i), NodeName: "node1", DestinationPort: 9,
+			}); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for starter.starts.Load() < streamEventConcurrency && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Gli eventi oltre il limite aspettano che uno start finisca
+	time.Sleep(50 * time.Millisecond)
+	if starts := starter.starts.Load(); starts != streamEventConcurrency {
+		t.Errorf("Expected %d starts in progress on the stream, got %d", streamEventConcurrency, starts)
+	}
+	close(starter.release)
+	wg.Wait()
+	if starts := starter.starts.Load(); starts != int32(count) {
+		t.Errorf("Expected all the %d events to be processed, got %d starts", count, starts)
+	}
+	if maxSeen := starter.maxSeen.Load(); maxSeen > streamEventConcurrency {
+		t.Errorf("Expected at most %d starts at the same time, got %d", streamEventConcurrency, maxSeen)
+	}
+}
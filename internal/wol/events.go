@@ -0,0 +1,104 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// Reasons of the Kubernetes Events recorded for the WOL packets
+const (
+	// EventReasonWoken: a WOL packet started the VM
+	EventReasonWoken = "WokeByWOL"
+	// EventReasonStopped: a sleep packet stopped or paused the VM
+	EventReasonStopped = "StoppedByWOL"
+	// EventReasonFailed: the start (or stop) requested by a WOL packet failed
+	EventReasonFailed = "WOLActionFailed"
+	// EventReasonRejected: a WOL packet was refused (SecureOn, WolPolicy, rate limit)
+	EventReasonRejected = "WOLRejected"
+	// EventReasonDryRun: a WOL packet would have acted on the VM, but its WolConfig is in dry-run
+	EventReasonDryRun = "WOLDryRun"
+)
+
+// SetEventRecorder makes the aggregator record Kubernetes Events on the VM and
+// on the WolConfig for the outcome of each WOL packet
+func (a *Aggregator) SetEventRecorder(recorder record.EventRecorder) {
+	a.recorder = recorder
+}
+
+// wolConfig returns a copy of a WolConfig, nil if it is not known
+func (m *MACMapper) wolConfig(name string) *wolv1beta1.WolConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range m.configs {
+		if m.configs[i].Name == name {
+			return m.configs[i].DeepCopy()
+		}
+	}
+	return nil
+}
+
+// recordKubeEvents records the outcome of a WOL packet for a VM as Events on
+// the VM and on its WolConfig. Duplicates and unknown MACs have no Event.
+func (a *Aggregator) recordKubeEvents(ctx context.Context, event *wolv1.WOLEvent, vmInfo VMInfo, resp *wolv1.WOLEventResponse) {
+	if a.recorder == nil {
+		return
+	}
+	eventType, reason := corev1.EventTypeWarning, ""
+	switch resp.Status {
+	case wolv1.ResponseStatus_VM_START_INITIATED:
+		eventType, reason = corev1.EventTypeNormal, EventReasonWoken
+	case wolv1.ResponseStatus_VM_STOP_INITIATED:
+		eventType, reason = corev1.EventTypeNormal, EventReasonStopped
+	case wolv1.ResponseStatus_DRY_RUN:
+		eventType, reason = corev1.EventTypeNormal, EventReasonDryRun
+	case wolv1.ResponseStatus_ERROR:
+		reason = EventReasonFailed
+	case wolv1.ResponseStatus_SECURE_ON_PASSWORD_MISSING, wolv1.ResponseStatus_SECURE_ON_PASSWORD_INVALID,
+		wolv1.ResponseStatus_POLICY_REJECTED, wolv1.ResponseStatus_RATE_LIMITED:
+		reason = EventReasonRejected
+	default:
+		return
+	}
+
+	message := fmt.Sprintf("%s from node %s, source IP %s (MAC %s, WolConfig %s)",
+		reason, event.NodeName, event.SourceIp, event.MacAddress, vmInfo.ConfigName)
+	if eventType == corev1.EventTypeWarning || resp.Status == wolv1.ResponseStatus_DRY_RUN {
+		message += ": " + resp.Message
+	}
+
+	// kubectl describe cerca gli Event per UID: serve la VM vera, non solo il nome
+	vm := &kubevirtv1.VirtualMachine{}
+	if err := a.mapper.client.Get(ctx, client.ObjectKey{Namespace: vmInfo.Namespace, Name: vmInfo.Name}, vm); err != nil {
+		a.log.V(1).Info("Cannot record the Event on the VM", "vm", vmInfo.Name, "namespace", vmInfo.Namespace,
+			"error", err.Error())
+	} else {
+		a.recorder.Event(vm, eventType, reason, message)
+	}
+	if config := a.mapper.wolConfig(vmInfo.ConfigName); config != nil {
+		a.recorder.Eventf(config, eventType, reason, "VM %s/%s: %s", vmInfo.Namespace, vmInfo.Name, message)
+	}
+}
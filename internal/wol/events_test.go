@@ -0,0 +1,90 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestAggregator_KubeEvents(t *testing.T) {
+	vm := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "vm1", Namespace: "default"}}
+	mapper := NewMACMapper(newPolicyClient(t, vm), logr.Discard())
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:01", VMName: "vm1", Namespace: "default"},
+				{MACAddress: "52:54:00:00:00:02", VMName: "missing", Namespace: "default"},
+			},
+			RateLimit: &wolv1beta1.EventRateLimitSpec{
+				PerMAC: &wolv1beta1.TokenBucketSpec{RequestsPerMinute: 1, Burst: 1},
+			},
+		},
+	}
+	config.Name = "lab"
+	mapper.UpdateConfig(config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	agg := NewAggregator(mapper, &policyStarter{actions: make(map[string]string)}, logr.Discard())
+	recorder := record.NewFakeRecorder(10)
+	agg.SetEventRecorder(recorder)
+
+	report := func(mac string, port uint32) {
+		_, _ = agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{
+			MacAddress: mac, NodeName: "node1", SourceIp: "10.0.0.5", DestinationPort: port,
+		})
+	}
+	report("52:54:00:00:00:01", 9)
+	report("52:54:00:00:00:01", 9) // duplicato: nessun Event
+	report("52:54:00:00:00:01", 7) // rate limited
+	report("aa:bb:cc:dd:ee:ff", 9) // MAC sconosciuto: nessun Event
+	report("52:54:00:00:00:02", 9) // VM inesistente: Event solo sulla WolConfig
+
+	want := []string{
+		"Normal WokeByWOL WokeByWOL from node node1, source IP 10.0.0.5 (MAC 52:54:00:00:00:01, WolConfig lab)",
+		"Normal WokeByWOL VM default/vm1: WokeByWOL from node node1",
+		"Warning WOLRejected WOLRejected from node node1, source IP 10.0.0.5 (MAC 52:54:00:00:00:01, WolConfig lab): Rate limit",
+		"Warning WOLRejected VM default/vm1: WOLRejected",
+		"Normal WokeByWOL VM default/missing: WokeByWOL",
+	}
+	for _, prefix := range want {
+		select {
+		case got := <-recorder.Events:
+			if !strings.HasPrefix(got, prefix) {
+				t.Errorf("Expected an Event starting with %q, got %q", prefix, got)
+			}
+		default:
+			t.Fatalf("Missing Event %q", prefix)
+		}
+	}
+	select {
+	case got := <-recorder.Events:
+		t.Errorf("Unexpected Event %q", got)
+	default:
+	}
+}
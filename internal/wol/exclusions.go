@@ -0,0 +1,101 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// discoveryExclusions are the parsed Exclusions of a WolConfig. A nil
+// *discoveryExclusions excludes nothing.
+type discoveryExclusions struct {
+	namespaces  []string
+	vmSelector  labels.Selector
+	macPrefixes [][]byte
+}
+
+// newDiscoveryExclusions parses the Exclusions of the config (nil if none)
+func newDiscoveryExclusions(config *wolv1beta1.WolConfig) (*discoveryExclusions, error) {
+	spec := config.Spec.Exclusions
+	if spec == nil {
+		return nil, nil
+	}
+	exclusions := &discoveryExclusions{namespaces: spec.Namespaces}
+	if spec.VMSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(spec.VMSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclusion VM selector: %w", err)
+		}
+		exclusions.vmSelector = selector
+	}
+	for _, prefix := range spec.MACPrefixes {
+		parsed, err := parseMACPrefix(prefix)
+		if err != nil {
+			return nil, err
+		}
+		exclusions.macPrefixes = append(exclusions.macPrefixes, parsed)
+	}
+	return exclusions, nil
+}
+
+// parseMACPrefix parses the first 1 to 6 bytes of a MAC (e.g. "00:50:56")
+func parseMACPrefix(prefix string) ([]byte, error) {
+	octets := strings.Split(normalizeMACAddress(prefix), ":")
+	if len(octets) > 6 {
+		return nil, fmt.Errorf("invalid MAC prefix %q", prefix)
+	}
+	parsed := make([]byte, 0, len(octets))
+	for _, octet := range octets {
+		b, err := hex.DecodeString(octet)
+		if err != nil || len(b) != 1 {
+			return nil, fmt.Errorf("invalid MAC prefix %q", prefix)
+		}
+		parsed = append(parsed, b[0])
+	}
+	return parsed, nil
+}
+
+// excludesVM returns true if the VM is left out of the discovery
+func (e *discoveryExclusions) excludesVM(vm *kubevirtv1.VirtualMachine) bool {
+	if e == nil {
+		return false
+	}
+	if slices.Contains(e.namespaces, vm.Namespace) {
+		return true
+	}
+	return e.vmSelector != nil && e.vmSelector.Matches(labels.Set(vm.Labels))
+}
+
+// excludesMAC returns true if the MAC is left out of the discovery
+func (e *discoveryExclusions) excludesMAC(key macKey) bool {
+	if e == nil {
+		return false
+	}
+	return slices.ContainsFunc(e.macPrefixes, func(prefix []byte) bool {
+		return bytes.HasPrefix(key[:], prefix)
+	})
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+func TestParseMACPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   []byte
+		ok     bool
+	}{
+		{"00:50:56", []byte{0x00, 0x50, 0x56}, true},
+		{"52:54:00:FF", []byte{0x52, 0x54, 0x00, 0xff}, true},
+		{"aa:bb:cc:dd:ee:ff", []byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}, true},
+		{"aa:bb:cc:dd:ee:ff:00", nil, false},
+		{"0:50:56", nil, false},
+		{"00:5g", nil, false},
+		{"", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := parseMACPrefix(tt.prefix)
+		if (err == nil) != tt.ok || !bytes.Equal(got, tt.want) {
+			t.Errorf("parseMACPrefix(%q) = %x, %v, want %x (ok=%v)", tt.prefix, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMACMapper_Exclusions(t *testing.T) {
+	appliance := newNamespaceVM("tenant", "appliance", "52:54:00:00:00:02")
+	appliance.Labels = map[string]string{"role": "appliance"}
+	c := newPolicyClient(t,
+		newNamespaceVM("tenant", "vm1", "52:54:00:00:00:01"),
+		appliance,
+		newNamespaceVM("tenant", "vmware", "00:50:56:00:00:03"),
+		newNamespaceVM("infra", "vm4", "52:54:00:00:00:04"))
+	mapper := NewMACMapper(c, logr.Discard())
+	config := conflictTestConfig("all", 0, 0, "")
+	config.Spec.Exclusions = &wolv1beta1.DiscoveryExclusions{
+		Namespaces:  []string{"infra"},
+		VMSelector:  &metav1.LabelSelector{MatchLabels: map[string]string{"role": "appliance"}},
+		MACPrefixes: []string{"00:50:56"},
+	}
+	mapper.UpdateConfig(&config)
+	ctx := context.Background()
+	if err := mapper.RefreshMapping(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, found := mapper.Lookup("52:54:00:00:00:01"); !found {
+		t.Error("Expected vm1 to be mapped")
+	}
+	for _, mac := range []string{"52:54:00:00:00:02", "00:50:56:00:00:03", "52:54:00:00:00:04"} {
+		if _, found := mapper.Lookup(mac); found {
+			t.Errorf("Expected the excluded %s not to be mapped", mac)
+		}
+	}
+
+	// Le esclusioni valgono anche per le VM aggiornate senza refresh
+	mapper.ApplyVM(ctx, newNamespaceVM("infra", "vm5", "52:54:00:00:00:05"))
+	mapper.ApplyVM(ctx, newNamespaceVM("tenant", "vm6", "00:50:56:00:00:06"))
+	if mapper.GetMappingCount() != 1 {
+		t.Errorf("Expected only vm1 to be mapped, got %d MACs", mapper.GetMappingCount())
+	}
+}
+
+func TestMACMapper_InvalidExclusions(t *testing.T) {
+	mapper := NewMACMapper(newPolicyClient(t, newNamespaceVM("tenant", "vm1", "52:54:00:00:00:01")), logr.Discard())
+	config := conflictTestConfig("all", 0, 0, "")
+	config.Spec.Exclusions = &wolv1beta1.DiscoveryExclusions{MACPrefixes: []string{"not-a-prefix"}}
+	mapper.UpdateConfig(&config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Esclusioni non valide: nessuna VM scoperta piuttosto che quelle da escludere
+	if mapper.GetMappingCount() != 0 {
+		t.Errorf("Expected no VM mapped with invalid exclusions, got %d", mapper.GetMappingCount())
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// An explicit mapping with a VMSelector maps the MACs matching its
+// MACAddress, which may have wildcard octets (52:54:00:aa:*:*), to the VMs of
+// its namespace selected by the labels that have them. The MACs come from the
+// VMs, as with the discovery: spec or, when assigned at boot, VMI status.
+
+// macPattern is a MAC whose wildcard octets match any value
+type macPattern struct {
+	value macKey
+	// mask has 0xff for the fixed octets, 0 for the wildcards
+	mask macKey
+}
+
+// parseMACPattern parses a MAC with wildcard octets (e.g. 52:54:00:aa:*:*)
+func parseMACPattern(pattern string) (macPattern, bool) {
+	var p macPattern
+	octets := strings.Split(normalizeMACAddress(pattern), ":")
+	if len(octets) != len(p.value) {
+		return p, false
+	}
+	for i, octet := range octets {
+		if octet == "*" {
+			continue
+		}
+		b, err := hex.DecodeString(octet)
+		if err != nil || len(b) != 1 {
+			return p, false
+		}
+		p.value[i], p.mask[i] = b[0], 0xff
+	}
+	return p, true
+}
+
+// matches returns true if the MAC matches the pattern
+func (p macPattern) matches(key macKey) bool {
+	for i := range key {
+		if key[i]&p.mask[i] != p.value[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hasSelectorMappings returns true if the config has explicit mappings with a VMSelector
+func hasSelectorMappings(config *wolv1beta1.WolConfig) bool {
+	if config.Spec.DiscoveryMode != wolv1beta1.DiscoveryModeExplicit {
+		return false
+	}
+	for _, explicit := range config.Spec.ExplicitMappings {
+		if explicit.VMSelector != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// discoverSelectorMappings adds the MACs of the VMs selected by the explicit
+// mappings of the config with a VMSelector and returns how many were added
+func (m *MACMapper) discoverSelectorMappings(ctx context.Context, config *wolv1beta1.WolConfig, mapping *mappingBuilder) int {
+	count := 0
+	for i := range config.Spec.ExplicitMappings {
+		explicit := &config.Spec.ExplicitMappings[i]
+		if explicit.VMSelector == nil {
+			continue
+		}
+		selector, pattern, ok := m.parseSelectorMapping(config, explicit)
+		if !ok {
+			continue
+		}
+		vmList := &kubevirtv1.VirtualMachineList{}
+		if err := m.client.List(ctx, vmList, &client.ListOptions{
+			Namespace:     explicit.Namespace,
+			LabelSelector: selector,
+		}); err != nil {
+			m.log.Error(err, "Failed to list the VMs of an explicit mapping", "mac", explicit.MACAddress,
+				"namespace", explicit.Namespace, "config", config.Name)
+			ErrorsTotal.Inc()
+			continue
+		}
+		for j := range vmList.Items {
+			count += m.addSelectorMapping(config, explicit, pattern, &vmList.Items[j], mapping)
+		}
+	}
+	return count
+}
+
+// addSelectorVMMappings adds the MACs of a single VM selected by the explicit
+// mappings of the config with a VMSelector
+func (m *MACMapper) addSelectorVMMappings(config *wolv1beta1.WolConfig, vm *kubevirtv1.VirtualMachine, mapping *mappingBuilder) {
+	for i := range config.Spec.ExplicitMappings {
+		explicit := &config.Spec.ExplicitMappings[i]
+		if explicit.VMSelector == nil || explicit.Namespace != vm.Namespace {
+			continue
+		}
+		selector, pattern, ok := m.parseSelectorMapping(config, explicit)
+		if ok && selector.Matches(labels.Set(vm.Labels)) {
+			m.addSelectorMapping(config, explicit, pattern, vm, mapping)
+		}
+	}
+}
+
+// parseSelectorMapping parses the VMSelector and the MAC pattern of an
+// explicit mapping, logging the invalid ones
+func (m *MACMapper) parseSelectorMapping(config *wolv1beta1.WolConfig,
+	explicit *wolv1beta1.MACVMMapping) (labels.Selector, macPattern, bool) {
+	pattern, ok := parseMACPattern(explicit.MACAddress)
+	if !ok {
+		m.log.Info("Skipping explicit mapping with invalid MAC", "mac", explicit.MACAddress, "config", config.Name)
+		return nil, pattern, false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(explicit.VMSelector)
+	if err != nil {
+		m.log.Info("Skipping explicit mapping with invalid VM selector", "mac", explicit.MACAddress,
+			"config", config.Name, "error", err.Error())
+		return nil, pattern, false
+	}
+	return selector, pattern, true
+}
+
+// addSelectorMapping adds the MACs of the VM matching the pattern of an
+// explicit mapping and returns how many were added. m.refreshMu must be held.
+func (m *MACMapper) addSelectorMapping(config *wolv1beta1.WolConfig, explicit *wolv1beta1.MACVMMapping,
+	pattern macPattern, vm *kubevirtv1.VirtualMachine, mapping *mappingBuilder) int {
+	if vm.Spec.Template == nil || vmOptedOut(vm) {
+		return 0
+	}
+	info := newVMInfo(config, MappingTypeExplicit, vm.Namespace, vm.Name)
+	if explicit.PasswordSecretRef != nil {
+		info.withPassword(*explicit.PasswordSecretRef)
+	}
+	if explicit.SecureOnPolicy != "" {
+		info.SecureOnPolicy = explicit.SecureOnPolicy
+	}
+	info.WakeWith = vmWakeWith(vm)
+
+	count := 0
+	for _, key := range m.interfaceMACs(vm) {
+		if pattern.matches(key) {
+			mapping.add(key, info)
+			count++
+		}
+	}
+	return count
+}
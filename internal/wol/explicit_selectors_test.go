@@ -0,0 +1,104 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+func TestMACPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		mac     string
+		ok      bool
+		want    bool
+	}{
+		{"52:54:00:aa:*:*", "52:54:00:aa:01:02", true, true},
+		{"52:54:00:AA:*:*", "52:54:00:ab:01:02", true, false},
+		{"*:*:*:*:*:01", "aa:bb:cc:dd:ee:01", true, true},
+		{"52:54:00:aa:01:02", "52:54:00:aa:01:02", true, true},
+		{"52:54:00:*:*", "", false, false},
+		{"52:54:00:a*:*:*", "", false, false},
+	}
+	for _, tt := range tests {
+		pattern, ok := parseMACPattern(tt.pattern)
+		if ok != tt.ok {
+			t.Errorf("parseMACPattern(%q) ok = %v, want %v", tt.pattern, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		key, _ := parseMACKey(tt.mac)
+		if got := pattern.matches(key); got != tt.want {
+			t.Errorf("%q matches %s = %v, want %v", tt.pattern, tt.mac, got, tt.want)
+		}
+	}
+}
+
+func TestMACMapper_SelectorMappings(t *testing.T) {
+	batch := map[string]string{"app": "batch"}
+	mapper := NewMACMapper(newPolicyClient(t,
+		newWatchVM("batch-1", batch, "52:54:00:aa:00:01", "52:54:00:bb:00:01"),
+		newWatchVM("batch-2", batch, ""), // MAC assegnato al boot
+		newStatusVMI("batch-2", map[string]string{"default": "52:54:00:aa:00:02"}),
+		newWatchVM("other", nil, "52:54:00:aa:00:03")), logr.Discard())
+	config := conflictTestConfig("batch", 0, 0, "")
+	config.Spec.DiscoveryMode = wolv1beta1.DiscoveryModeExplicit
+	config.Spec.ExplicitMappings = []wolv1beta1.MACVMMapping{
+		{MACAddress: "52:54:00:aa:*:*", Namespace: "tenant",
+			VMSelector: &metav1.LabelSelector{MatchLabels: batch}, SecureOnPolicy: wolv1beta1.SecureOnPolicyAudit},
+		{MACAddress: "52:54:00:00:00:09", Namespace: "tenant", VMName: "fixed"},
+	}
+	mapper.UpdateConfig(&config)
+	ctx := context.Background()
+	if err := mapper.RefreshMapping(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for mac, want := range map[string]string{"52:54:00:aa:00:01": "batch-1", "52:54:00:aa:00:02": "batch-2",
+		"52:54:00:00:00:09": "fixed"} {
+		if info, found := mapper.Lookup(mac); !found || info.Name != want || info.MappingType != MappingTypeExplicit {
+			t.Errorf("Expected %s to map to %s, got %+v (found=%v)", mac, want, info, found)
+		}
+	}
+	if info, _ := mapper.Lookup("52:54:00:aa:00:01"); info.SecureOnPolicy != wolv1beta1.SecureOnPolicyAudit {
+		t.Errorf("Expected the SecureOn policy of the mapping, got %q", info.SecureOnPolicy)
+	}
+	// Fuori dall'intervallo o senza le label
+	for _, mac := range []string{"52:54:00:bb:00:01", "52:54:00:aa:00:03"} {
+		if _, found := mapper.Lookup(mac); found {
+			t.Errorf("Expected %s not to be mapped", mac)
+		}
+	}
+
+	// Una VM di breve durata è mappata appena creata e dimenticata appena eliminata
+	mapper.ApplyVM(ctx, newWatchVM("batch-3", batch, "52:54:00:aa:00:04"))
+	if info, found := mapper.Lookup("52:54:00:aa:00:04"); !found || info.Name != "batch-3" {
+		t.Errorf("Expected batch-3 to be mapped when applied, got %+v (found=%v)", info, found)
+	}
+	mapper.RemoveVM(ctx, "tenant", "batch-3")
+	if _, found := mapper.Lookup("52:54:00:aa:00:04"); found {
+		t.Error("Expected the MAC of the deleted batch-3 to be removed")
+	}
+}
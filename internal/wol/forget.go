@@ -0,0 +1,81 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import "strings"
+
+// ForgetConfig drops the state the aggregator keeps for a deleted WolConfig:
+// the heartbeats and listener reports of its agents, its rate limit buckets,
+// the starts of its VMs still queued for a retry and the wakes still waiting
+// for their VM to be Running. Its MACs are dropped by the next refresh of the
+// mapper without it.
+func (a *Aggregator) ForgetConfig(configName string) {
+	a.agentsMu.Lock()
+	for key, agent := range a.agents {
+		if agent.WolConfig == configName {
+			delete(a.agents, key)
+		}
+	}
+	a.agentsMu.Unlock()
+
+	a.listenersMu.Lock()
+	for key, report := range a.listeners {
+		if report.WolConfig == configName {
+			delete(a.listeners, key)
+		}
+	}
+	a.listenersMu.Unlock()
+
+	a.wakes.mu.Lock()
+	for key, wake := range a.wakes.pending {
+		if wake.vmInfo.ConfigName == configName {
+			delete(a.wakes.pending, key)
+		}
+	}
+	a.wakes.mu.Unlock()
+
+	a.rateLimiter.forget(configName)
+	if a.retries != nil {
+		a.retries.forget(configName)
+	}
+	a.log.V(1).Info("Forgot the state of the deleted WolConfig", "wolconfig", configName)
+}
+
+// forget drops the buckets of a WolConfig
+func (l *eventRateLimiter) forget(configName string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range l.buckets {
+		// chiave: scope|wolconfig|MAC o nodo
+		if parts := strings.SplitN(key, "|", 3); len(parts) == 3 && parts[1] == configName {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// forget drops the queued starts of the VMs of a WolConfig: the queue skips
+// the keys no longer pending
+func (r *wakeRetries) forget(configName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, retry := range r.pending {
+		if retry.vmInfo.ConfigName == configName {
+			delete(r.pending, key)
+		}
+	}
+	WakeRetryQueueSize.Set(float64(len(r.pending)))
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestAggregator_ForgetConfig(t *testing.T) {
+	ctx := context.Background()
+	agg := NewAggregator(NewMACMapper(nil, logr.Discard()), NewVMStarter(nil, logr.Discard()), logr.Discard())
+	for _, config := range []string{"deleted", "kept"} {
+		if _, err := agg.AgentHeartbeat(ctx, &wolv1.AgentHeartbeatRequest{NodeName: "node-a", WolConfig: config}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := agg.ReportListeners(ctx, &wolv1.ListenerReport{NodeName: "node-a", WolConfig: config}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	spec := &wolv1beta1.EventRateLimitSpec{PerMAC: &wolv1beta1.TokenBucketSpec{RequestsPerMinute: 1, Burst: 1}}
+	agg.rateLimiter.allow("deleted", spec, "52:54:00:00:00:01", "node-a")
+	agg.rateLimiter.allow("kept", spec, "52:54:00:00:00:01", "node-a")
+
+	agg.ForgetConfig("deleted")
+
+	if agents := agg.Agents("deleted"); len(agents) != 0 {
+		t.Errorf("Expected the agents of the deleted WolConfig to be forgotten, got %+v", agents)
+	}
+	if listeners := agg.Listeners("deleted"); len(listeners) != 0 {
+		t.Errorf("Expected the listeners of the deleted WolConfig to be forgotten, got %+v", listeners)
+	}
+	if len(agg.Agents("kept")) != 1 || len(agg.Listeners("kept")) != 1 {
+		t.Error("Expected the other WolConfigs to keep their agents")
+	}
+	if scope := agg.rateLimiter.allow("deleted", spec, "52:54:00:00:00:01", "node-a"); scope != "" {
+		t.Errorf("Expected the buckets of the deleted WolConfig to be dropped, got %q", scope)
+	}
+	if scope := agg.rateLimiter.allow("kept", spec, "52:54:00:00:00:01", "node-a"); scope != rateLimitMAC {
+		t.Errorf("Expected the other WolConfigs to keep their buckets, got %q", scope)
+	}
+}
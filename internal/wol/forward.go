@@ -0,0 +1,404 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+const (
+	// etherTypeWoL is the EtherType of raw Wake-on-LAN frames
+	etherTypeWoL = 0x0842
+	// forwardQueueSize is the number of forwards buffered for each agent stream
+	forwardQueueSize = 64
+	// forwardRetryInterval is the wait before reopening a failed forward stream
+	forwardRetryInterval = 5 * time.Second
+)
+
+// ForwardTarget is an external machine the magic packets of a MAC are re-emitted to
+type ForwardTarget struct {
+	// MAC is the MAC address of the machine
+	MAC string
+	// ConfigName is the WolConfig listing the Forward mapping
+	ConfigName string
+	// Target is where the packet is re-emitted
+	Target wolv1beta1.WOLForwardTarget
+}
+
+// collectForwards adds the Forward mappings of a config to forwards. A MAC
+// already forwarded by another config keeps its first target.
+func (m *MACMapper) collectForwards(config *wolv1beta1.WolConfig, forwards map[macKey]ForwardTarget) {
+	for _, mapping := range config.Spec.ExplicitMappings {
+		if mapping.Forward == nil {
+			continue
+		}
+		key, ok := parseMACKey(mapping.MACAddress)
+		if !ok {
+			m.log.Info("Skipping forward mapping with invalid MAC", "mac", mapping.MACAddress, "config", config.Name)
+			continue
+		}
+		if mapping.Forward.Address != "" && net.ParseIP(mapping.Forward.Address) == nil {
+			m.log.Info("Skipping forward mapping with invalid address", "mac", mapping.MACAddress,
+				"address", mapping.Forward.Address, "config", config.Name)
+			continue
+		}
+		if existing, found := forwards[key]; found {
+			m.log.Info("MAC forwarded by more than one WolConfig", "mac", key.String(),
+				"config", config.Name, "winner", existing.ConfigName)
+			continue
+		}
+		forwards[key] = ForwardTarget{MAC: key.String(), ConfigName: config.Name, Target: *mapping.Forward}
+	}
+}
+
+// LookupForward returns the forward target of a MAC that is not a VM
+func (m *MACMapper) LookupForward(macAddress string) (ForwardTarget, bool) {
+	key, ok := parseMACKey(macAddress)
+	if !ok {
+		return ForwardTarget{}, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	target, found := m.forwards[key]
+	return target, found
+}
+
+// forward re-emits the magic packet of an event to the external machine of
+// the MAC, from the manager or from the agent of the target node
+func (a *Aggregator) forward(event *wolv1.WOLEvent, target ForwardTarget, startTime time.Time) *wolv1.WOLEventResponse {
+	port := target.Target.Port
+	if port == 0 {
+		port = DefaultWOLPort
+	}
+	req := &wolv1.ForwardRequest{
+		MacAddress:       target.MAC,
+		SecureOnPassword: event.SecureOnPassword,
+		Address:          target.Target.Address,
+		Port:             uint32(port),
+		Interface:        target.Target.Interface,
+	}
+
+	sender := "manager"
+	if target.Target.NodeName != "" {
+		sender = "agent"
+	}
+	if a.mapper.DryRun(target.ConfigName) {
+		destination := forwardDestination(req)
+		a.skipDryRun(target.ConfigName, "Forward", destination)
+		return &wolv1.WOLEventResponse{
+			Status: wolv1.ResponseStatus_DRY_RUN,
+			Message: fmt.Sprintf("Dry run: magic packet for %s would be forwarded to %s by the %s (WolConfig %s)",
+				target.MAC, destination, sender, target.ConfigName),
+			ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+		}
+	}
+
+	var err error
+	if target.Target.NodeName == "" {
+		err = sendForward(req)
+	} else {
+		err = a.pushForward(target.ConfigName, target.Target.NodeName, req)
+	}
+	if err != nil {
+		a.log.Error(err, "Failed to forward WOL packet", "mac", target.MAC, "wolconfig", target.ConfigName,
+			"address", req.Address, "interface", req.Interface, "targetNode", target.Target.NodeName)
+		ErrorsTotal.Inc()
+		ForwardedPacketsTotal.WithLabelValues(target.ConfigName, sender, "error").Inc()
+		return &wolv1.WOLEventResponse{
+			Status:           wolv1.ResponseStatus_ERROR,
+			Message:          fmt.Sprintf("Failed to forward magic packet for %s: %v", target.MAC, err),
+			ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+		}
+	}
+
+	ForwardedPacketsTotal.WithLabelValues(target.ConfigName, sender, "ok").Inc()
+	destination := forwardDestination(req)
+	a.log.Info("Forwarded WOL packet to external machine",
+		"mac", target.MAC,
+		"node", event.NodeName,
+		"wolconfig", target.ConfigName,
+		"destination", destination,
+		"targetNode", target.Target.NodeName)
+	return &wolv1.WOLEventResponse{
+		Status: wolv1.ResponseStatus_FORWARDED,
+		Message: fmt.Sprintf("Magic packet for %s forwarded to %s by the %s (WolConfig %s)",
+			target.MAC, destination, sender, target.ConfigName),
+		ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+	}
+}
+
+// forwardDestination describes where a forward is sent, for logs and responses
+func forwardDestination(req *wolv1.ForwardRequest) string {
+	if req.Address == "" {
+		return "raw frame on " + req.Interface
+	}
+	destination := net.JoinHostPort(req.Address, fmt.Sprint(req.Port))
+	if req.Interface != "" {
+		destination += " via " + req.Interface
+	}
+	return destination
+}
+
+// pushForward queues a forward on the stream of the agent of the config on the node
+func (a *Aggregator) pushForward(configName, nodeName string, req *wolv1.ForwardRequest) error {
+	// Le WolConfig con agent condivisi sono servite dagli agent di una sola di esse
+	a.forwardsMu.Lock()
+	stream, found := a.forwardStreams[a.mapper.agentConfigName(configName)+"/"+nodeName]
+	a.forwardsMu.Unlock()
+	if !found {
+		return fmt.Errorf("no agent of WolConfig %s connected on node %s", configName, nodeName)
+	}
+	select {
+	case stream <- req:
+		return nil
+	default:
+		return fmt.Errorf("forward queue of the agent on node %s is full", nodeName)
+	}
+}
+
+// WatchForwards streams to an agent the magic packets to re-emit on its node
+func (a *Aggregator) WatchForwards(req *wolv1.ForwardsRequest, stream wolv1.WOLService_WatchForwardsServer) error {
+	if req.NodeName == "" {
+		return status.Error(codes.InvalidArgument, "node_name is required")
+	}
+	key := req.WolConfig + "/" + req.NodeName
+	queue := make(chan *wolv1.ForwardRequest, forwardQueueSize)
+
+	a.forwardsMu.Lock()
+	if a.forwardStreams == nil {
+		a.forwardStreams = make(map[string]chan *wolv1.ForwardRequest)
+	}
+	// Un agent che si riconnette sostituisce il proprio stream precedente
+	a.forwardStreams[key] = queue
+	a.forwardsMu.Unlock()
+	defer func() {
+		a.forwardsMu.Lock()
+		if a.forwardStreams[key] == queue {
+			delete(a.forwardStreams, key)
+		}
+		a.forwardsMu.Unlock()
+	}()
+
+	a.log.V(1).Info("Agent watching forwards", "node", req.NodeName, "wolconfig", req.WolConfig)
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case forward := <-queue:
+			if err := stream.Send(forward); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// syncForwards keeps open the stream of the packets the operator asks this
+// agent to re-emit, reopening it when it fails
+func (a *Agent) syncForwards(ctx context.Context) {
+	defer a.wg.Done()
+	for {
+		err := a.watchForwards(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if status.Code(err) == codes.Unimplemented {
+			a.log.Info("Operator does not support forwards, not watching them")
+			return
+		}
+		a.log.V(1).Info("Forward stream closed, reopening", "error", err.Error())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(forwardRetryInterval):
+		}
+	}
+}
+
+func (a *Agent) watchForwards(ctx context.Context) error {
+	stream, err := a.grpcClient.WatchForwards(ctx, &wolv1.ForwardsRequest{
+		NodeName:  a.nodeName,
+		WolConfig: a.wolConfigName,
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		a.handleForward(req)
+	}
+}
+
+// handleForward re-emits a magic packet on this node
+func (a *Agent) handleForward(req *wolv1.ForwardRequest) {
+	// Il pacchetto riemesso può tornare ai listener di questo nodo: la
+	// dedupe locale evita di riportarlo all'operatore
+	a.shouldProcess(dedupeKey(req.MacAddress, req.Port), req.SecureOnPassword)
+	a.shouldProcess(dedupeKey(req.MacAddress, 0), req.SecureOnPassword)
+
+	if err := sendForward(req); err != nil {
+		a.log.Error(err, "Failed to forward WOL packet", "mac", req.MacAddress, "destination", forwardDestination(req))
+		ErrorsTotal.Inc()
+		return
+	}
+	a.log.Info("Forwarded WOL packet", "mac", req.MacAddress, "destination", forwardDestination(req))
+}
+
+// sendForward sends the magic packet of a forward over UDP, or as a raw
+// Ethernet frame when it has no address
+func sendForward(req *wolv1.ForwardRequest) error {
+	packet, err := newMagicPacket(req.MacAddress, req.SecureOnPassword)
+	if err != nil {
+		return err
+	}
+	if req.Address == "" {
+		return sendRawMagicPacket(req.Interface, packet)
+	}
+	return sendUDPMagicPacket(req.Address, int(req.Port), req.Interface, packet)
+}
+
+// newMagicPacket builds the magic packet of a MAC, followed by the SecureOn password if any
+func newMagicPacket(mac, password string) ([]byte, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return nil, fmt.Errorf("invalid MAC address %q", mac)
+	}
+	packet := bytes.Repeat([]byte{0xff}, 6)
+	packet = append(packet, bytes.Repeat(hw, 16)...)
+	if password == "" {
+		return packet, nil
+	}
+
+	normalized, ok := normalizeSecureOnPassword(password)
+	if !ok {
+		return nil, fmt.Errorf("invalid SecureOn password")
+	}
+	trailer, err := hex.DecodeString(strings.ReplaceAll(normalized, ":", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SecureOn password")
+	}
+	return append(packet, trailer...), nil
+}
+
+// SendMagicPacket sends the magic packet of a MAC, with the SecureOn password
+// if any, over UDP to address (e.g. a node running an agent, or a broadcast)
+func SendMagicPacket(address string, port int, mac, password string) error {
+	packet, err := newMagicPacket(mac, password)
+	if err != nil {
+		return err
+	}
+	return sendUDPMagicPacket(address, port, "", packet)
+}
+
+// sendUDPMagicPacket sends a magic packet to address (broadcast allowed),
+// optionally from the given interface
+func sendUDPMagicPacket(address string, port int, iface string, packet []byte) error {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return fmt.Errorf("invalid address %q", address)
+	}
+	if port == 0 {
+		port = DefaultWOLPort
+	}
+	network := "udp4"
+	if ip.To4() == nil {
+		network = "udp6"
+	}
+
+	lc := net.ListenConfig{Control: func(_, _ string, c syscall.RawConn) error {
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			if network == "udp4" {
+				sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_BROADCAST, 1)
+			}
+			if sockErr == nil && iface != "" {
+				sockErr = unix.BindToDevice(int(fd), iface)
+			}
+		}); err != nil {
+			return err
+		}
+		return sockErr
+	}}
+	conn, err := lc.ListenPacket(context.Background(), network, ":0")
+	if err != nil {
+		return fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	addr := &net.UDPAddr{IP: ip, Port: port}
+	if iface != "" && (ip.IsLinkLocalMulticast() || ip.IsLinkLocalUnicast()) {
+		addr.Zone = iface
+	}
+	if _, err := conn.WriteTo(packet, addr); err != nil {
+		return fmt.Errorf("failed to send magic packet to %s: %w", addr, err)
+	}
+	return nil
+}
+
+// sendRawMagicPacket broadcasts a magic packet as a raw Ethernet frame
+// (EtherType 0x0842) on the given interface
+func sendRawMagicPacket(ifaceName string, packet []byte) error {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return fmt.Errorf("interface %q not found: %w", ifaceName, err)
+	}
+	if len(iface.HardwareAddr) != 6 {
+		return fmt.Errorf("interface %s has no Ethernet address", ifaceName)
+	}
+
+	// Protocollo 0: il socket serve solo a trasmettere, non riceve frame
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open raw socket (requires NET_RAW): %w", err)
+	}
+	defer func() { _ = unix.Close(fd) }()
+
+	broadcast := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	frame := make([]byte, 0, 14+len(packet))
+	frame = append(frame, broadcast...)
+	frame = append(frame, iface.HardwareAddr...)
+	frame = append(frame, etherTypeWoL>>8, etherTypeWoL&0xff)
+	frame = append(frame, packet...)
+
+	addr := &unix.SockaddrLinklayer{
+		Protocol: htons(etherTypeWoL),
+		Ifindex:  iface.Index,
+		Halen:    6,
+	}
+	copy(addr.Addr[:], broadcast)
+	if err := unix.Sendto(fd, frame, 0, addr); err != nil {
+		return fmt.Errorf("failed to send raw frame on %s: %w", ifaceName, err)
+	}
+	return nil
+}
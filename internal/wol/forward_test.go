@@ -0,0 +1,217 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// newForwardAggregator returns an aggregator whose WolConfig forwards the MAC to target
+func newForwardAggregator(t *testing.T, target wolv1beta1.WOLForwardTarget) *Aggregator {
+	t.Helper()
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:01", VMName: "vm1", Namespace: "default"},
+				{MACAddress: "AA:BB:CC:00:00:01", Forward: &target},
+			},
+		},
+	}
+	config.Name = "lab"
+	mapper.UpdateConfig(config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return NewAggregator(mapper, &policyStarter{actions: make(map[string]string)}, logr.Discard())
+}
+
+// listenForward opens the UDP socket standing in for the external machine
+func listenForward(t *testing.T) (*net.UDPConn, int32) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn, int32(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// readForward waits for a magic packet and returns its MAC and SecureOn password
+func readForward(t *testing.T, conn *net.UDPConn) (string, string) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 256)
+	n, _, err := conn.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("No forwarded packet received: %v", err)
+	}
+	mac, ok := parseMagicPacket(buffer[:n])
+	if !ok {
+		t.Fatalf("Forwarded packet is not a magic packet: %x", buffer[:n])
+	}
+	return mac, parseSecureOnPassword(buffer[:n])
+}
+
+func TestMACMapper_Forwards(t *testing.T) {
+	agg := newForwardAggregator(t, wolv1beta1.WOLForwardTarget{Address: "192.168.10.255", Port: 9})
+
+	if _, found := agg.mapper.Lookup("aa:bb:cc:00:00:01"); found {
+		t.Error("Expected the forward mapping not to be a VM")
+	}
+	if agg.mapper.GetMappingCount() != 1 {
+		t.Errorf("Expected only the VM in the mapping, got %d entries", agg.mapper.GetMappingCount())
+	}
+	target, found := agg.mapper.LookupForward("aa:bb:cc:00:00:01")
+	if !found || target.ConfigName != "lab" || target.Target.Address != "192.168.10.255" {
+		t.Errorf("Unexpected forward target %+v (found=%v)", target, found)
+	}
+	if _, found := agg.mapper.LookupForward("52:54:00:00:00:01"); found {
+		t.Error("Expected the VM not to be a forward target")
+	}
+}
+
+func TestAggregator_ForwardFromManager(t *testing.T) {
+	conn, port := listenForward(t)
+	agg := newForwardAggregator(t, wolv1beta1.WOLForwardTarget{Address: "127.0.0.1", Port: port})
+
+	resp, _ := agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{
+		MacAddress:       "aa:bb:cc:00:00:01",
+		SecureOnPassword: "01:02:03:04:05:06",
+	})
+	if resp.Status != wolv1.ResponseStatus_FORWARDED {
+		t.Fatalf("Expected the packet to be forwarded, got %v: %s", resp.Status, resp.Message)
+	}
+	mac, password := readForward(t, conn)
+	if mac != "aa:bb:cc:00:00:01" || password != "01:02:03:04:05:06" {
+		t.Errorf("Unexpected forwarded packet for %s (password %q)", mac, password)
+	}
+}
+
+func TestAggregator_ForwardThroughAgent(t *testing.T) {
+	conn, port := listenForward(t)
+	agg := newForwardAggregator(t, wolv1beta1.WOLForwardTarget{Address: "127.0.0.1", Port: port, NodeName: "edge"})
+
+	// Senza agent sul nodo il forward fallisce
+	resp, _ := agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "aa:bb:cc:00:00:01", DestinationPort: 9})
+	if resp.Status != wolv1.ResponseStatus_ERROR {
+		t.Errorf("Expected an error without agent on the node, got %v", resp.Status)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	wolv1.RegisterWOLServiceServer(server, agg)
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	agent := NewAgent(0, "edge", lis.Addr().String(), logr.Discard())
+	agent.SetWolConfigName("lab")
+	grpcConn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.grpcConn = newTrackedConn(grpcConn)
+	agent.grpcClient = wolv1.NewWOLServiceClient(agent.grpcConn)
+	agent.wg.Add(1)
+	go agent.syncForwards(ctx)
+	defer func() {
+		cancel()
+		agent.wg.Wait()
+		_ = grpcConn.Close()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		agg.forwardsMu.Lock()
+		_, watching := agg.forwardStreams["lab/edge"]
+		agg.forwardsMu.Unlock()
+		if watching {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the agent to watch forwards")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	resp, _ = agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "aa:bb:cc:00:00:01", DestinationPort: 7})
+	if resp.Status != wolv1.ResponseStatus_FORWARDED {
+		t.Fatalf("Expected the packet to be forwarded, got %v: %s", resp.Status, resp.Message)
+	}
+	if mac, password := readForward(t, conn); mac != "aa:bb:cc:00:00:01" || password != "" {
+		t.Errorf("Unexpected forwarded packet for %s (password %q)", mac, password)
+	}
+	if agent.shouldProcess(dedupeKey("aa:bb:cc:00:00:01", uint32(port)), "") {
+		t.Error("Expected the agent to ignore the echo of the forwarded packet")
+	}
+}
+
+func TestNewMagicPacket(t *testing.T) {
+	packet, err := newMagicPacket("aa:bb:cc:dd:ee:ff", "c0:a8:01:0a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if mac, ok := parseMagicPacket(packet); !ok || mac != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("Unexpected magic packet for %s", mac)
+	}
+	if password := parseSecureOnPassword(packet); password != "c0:a8:01:0a" {
+		t.Errorf("Expected the short SecureOn password, got %q", password)
+	}
+	if _, err := newMagicPacket("aa:bb:cc:dd:ee:ff", "nope"); err == nil {
+		t.Error("Expected an invalid password to be rejected")
+	}
+}
+
+func TestSendMagicPacket(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	if err := SendMagicPacket("127.0.0.1", port, "aa-bb-cc-dd-ee-ff", ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	buf := make([]byte, 256)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mac, ok := parseMagicPacket(buf[:n]); !ok || mac != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("Unexpected magic packet for %s", mac)
+	}
+	if err := SendMagicPacket("127.0.0.1", port, "nope", ""); err == nil {
+		t.Error("Expected an invalid MAC to be rejected")
+	}
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// Version is the version of the manager and agent binaries, set at build time
+// with -ldflags "-X github.com/gpillon/kubevirt-wol/internal/wol.Version=..."
+var Version = "v0.0.1"
+
+const (
+	// agentHeartbeatInterval is how often agents send a heartbeat
+	agentHeartbeatInterval = 30 * time.Second
+	// agentHeartbeatTimeout is how long an agent is considered alive after its
+	// last heartbeat: after it the agent is stale and its WolConfig AgentDegraded
+	agentHeartbeatTimeout = 3 * agentHeartbeatInterval
+	// agentHeartbeatTTL is how long a silent agent is still reported (as not
+	// alive), so a dead agent stays visible in the status for a while
+	agentHeartbeatTTL = 10 * time.Minute
+)
+
+// NodeAgent is the last heartbeat of the agent on a node
+type NodeAgent struct {
+	NodeName       string
+	WolConfig      string
+	Version        string
+	Interfaces     []string
+	PacketsSeen    int64
+	ReportFailures int64
+	StartedAt      time.Time
+	LastHeartbeat  time.Time
+}
+
+// Alive returns true if the agent sent a heartbeat recently
+func (n NodeAgent) Alive(now time.Time) bool {
+	return now.Sub(n.LastHeartbeat) <= agentHeartbeatTimeout
+}
+
+// ---------------------------------------------------------------------------
+// Manager side: collecting the heartbeats
+// ---------------------------------------------------------------------------
+
+// AgentHeartbeat implementa il metodo gRPC con cui gli agent riportano il proprio stato
+func (a *Aggregator) AgentHeartbeat(_ context.Context, req *wolv1.AgentHeartbeatRequest) (*wolv1.AgentHeartbeatResponse, error) {
+	now := time.Now()
+	agent := NodeAgent{
+		NodeName:       req.NodeName,
+		WolConfig:      req.WolConfig,
+		Version:        req.Version,
+		Interfaces:     slices.Sorted(slices.Values(req.Interfaces)),
+		PacketsSeen:    int64(req.PacketsSeen),
+		ReportFailures: int64(req.ReportFailures),
+		LastHeartbeat:  now,
+	}
+	if req.StartedAt != nil {
+		agent.StartedAt = req.StartedAt.AsTime()
+	}
+	key := req.WolConfig + "/" + req.NodeName
+
+	a.agentsMu.Lock()
+	if a.agents == nil {
+		a.agents = make(map[string]NodeAgent)
+	}
+	previous, found := a.agents[key]
+	a.agents[key] = agent
+	a.agentsMu.Unlock()
+
+	// Lo status viene aggiornato subito solo se l'agent è nuovo, riavviato,
+	// tornato vivo o ha cambiato interfacce: non a ogni heartbeat
+	changed := !found || !previous.Alive(now) || previous.Version != agent.Version ||
+		!previous.StartedAt.Equal(agent.StartedAt) || !slices.Equal(previous.Interfaces, agent.Interfaces)
+	if changed {
+		a.log.V(1).Info("Agent heartbeat changed", "node", agent.NodeName, "wolconfig", agent.WolConfig,
+			"version", agent.Version, "interfaces", agent.Interfaces)
+		if a.agentsNotify != nil {
+			a.agentsNotify()
+		}
+	}
+	return &wolv1.AgentHeartbeatResponse{Accepted: true}, nil
+}
+
+// OnAgentsChange registers a callback invoked when an agent appears, restarts,
+// comes back, changes interfaces or (from MonitorAgents) becomes stale.
+// Must be called before the gRPC server starts.
+func (a *Aggregator) OnAgentsChange(fn func()) {
+	a.agentsNotify = fn
+}
+
+// SetServing records whether the gRPC server of the agents is serving,
+// notifying the OnAgentsChange callback when it changes
+func (a *Aggregator) SetServing(serving bool) {
+	if a.serving.Swap(serving) != serving && a.agentsNotify != nil {
+		a.agentsNotify()
+	}
+}
+
+// Serving returns true if the gRPC server of the agents is serving
+func (a *Aggregator) Serving() bool {
+	return a.serving.Load()
+}
+
+// Agents returns the last heartbeats of the agents of the given WolConfig
+// (agents without a WolConfig, and the shared agents serving it, are
+// included), the ones not alive first.
+// Agents silent for longer than agentHeartbeatTTL are dropped.
+func (a *Aggregator) Agents(configName string) []NodeAgent {
+	now := time.Now()
+	agentConfig := a.mapper.agentConfigName(configName)
+
+	a.agentsMu.Lock()
+	defer a.agentsMu.Unlock()
+
+	var result []NodeAgent
+	for key, agent := range a.agents {
+		if now.Sub(agent.LastHeartbeat) > agentHeartbeatTTL {
+			delete(a.agents, key)
+			continue
+		}
+		if agent.WolConfig == "" || agent.WolConfig == agentConfig {
+			result = append(result, agent)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		aliveI, aliveJ := result[i].Alive(now), result[j].Alive(now)
+		if aliveI != aliveJ {
+			return !aliveI
+		}
+		return result[i].NodeName < result[j].NodeName
+	})
+	return result
+}
+
+// MonitorAgents periodically exports the number of connected agents and
+// notifies the OnAgentsChange callback when agents become stale or are dropped
+func (a *Aggregator) MonitorAgents(ctx context.Context) {
+	ticker := time.NewTicker(agentHeartbeatInterval)
+	defer ticker.Stop()
+
+	var previous string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			previous = a.checkAgents(previous)
+		}
+	}
+}
+
+// checkAgents updates the metric and returns the stale agents, comma-separated,
+// calling the notifier if they differ from previous
+func (a *Aggregator) checkAgents(previous string) string {
+	now := time.Now()
+	connected := make(map[string]int)
+	var stale []string
+
+	a.agentsMu.Lock()
+	for key, agent := range a.agents {
+		if now.Sub(agent.LastHeartbeat) > agentHeartbeatTTL {
+			delete(a.agents, key)
+			continue
+		}
+		// Una WolConfig con soli agent stale è esportata a zero
+		count := connected[agent.WolConfig]
+		if agent.Alive(now) {
+			count++
+		} else {
+			stale = append(stale, key)
+		}
+		connected[agent.WolConfig] = count
+	}
+	a.agentsMu.Unlock()
+
+	// Reset: le WolConfig senza più agent spariscono dalla metrica
+	AgentsConnected.Reset()
+	for config, count := range connected {
+		AgentsConnected.WithLabelValues(config).Set(float64(count))
+	}
+
+	slices.Sort(stale)
+	current := strings.Join(stale, ",")
+	if current == previous {
+		return current
+	}
+
+	if current != "" {
+		a.log.Info("Agents stopped sending heartbeats", "agents", current)
+	}
+	if a.agentsNotify != nil {
+		a.agentsNotify()
+	}
+	return current
+}
+
+// ---------------------------------------------------------------------------
+// Agent side: sending the heartbeats
+// ---------------------------------------------------------------------------
+
+// syncHeartbeat periodically sends the agent heartbeat to the operator
+func (a *Agent) syncHeartbeat(ctx context.Context) {
+	defer a.wg.Done()
+	ticker := time.NewTicker(agentHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		a.sendHeartbeat(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Agent) sendHeartbeat(ctx context.Context) {
+	reqCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := a.grpcClient.AgentHeartbeat(reqCtx, a.heartbeat()); err != nil && ctx.Err() == nil {
+		a.log.V(1).Info("Failed to send heartbeat to operator", "error", err.Error())
+	}
+}
+
+// heartbeat returns the current state of the agent
+func (a *Agent) heartbeat() *wolv1.AgentHeartbeatRequest {
+	var interfaces []string
+	a.rawMu.Lock()
+	for _, l := range a.rawListeners {
+		interfaces = append(interfaces, l.interfaceName)
+	}
+	a.rawMu.Unlock()
+
+	return &wolv1.AgentHeartbeatRequest{
+		NodeName:       a.nodeName,
+		WolConfig:      a.wolConfigName,
+		Version:        Version,
+		Interfaces:     interfaces,
+		PacketsSeen:    uint64(a.packetsSeen.Load()),
+		ReportFailures: uint64(a.reportFailures.Load()),
+		StartedAt:      timestamppb.New(a.startedAt),
+	}
+}
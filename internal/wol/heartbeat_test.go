@@ -0,0 +1,159 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestAggregator_AgentHeartbeat(t *testing.T) {
+	agg := NewAggregator(NewMACMapper(nil, logr.Discard()), NewVMStarter(nil, logr.Discard()), logr.Discard())
+	notified := 0
+	agg.OnAgentsChange(func() { notified++ })
+
+	started := time.Now().Add(-time.Hour)
+	heartbeat := func(node, config string, packets uint64, interfaces ...string) {
+		t.Helper()
+		resp, err := agg.AgentHeartbeat(context.Background(), &wolv1.AgentHeartbeatRequest{
+			NodeName: node, WolConfig: config, Version: "v1.2.3", Interfaces: interfaces,
+			PacketsSeen: packets, StartedAt: timestamppb.New(started),
+		})
+		if err != nil || !resp.Accepted {
+			t.Fatalf("Expected the heartbeat to be accepted, got %v, %v", resp, err)
+		}
+	}
+
+	heartbeat("node-a", "cfg", 0, "eth1", "eth0")
+	heartbeat("node-b", "cfg", 0)
+	heartbeat("node-c", "other", 0)
+	if notified != 3 {
+		t.Fatalf("Expected a notification per new agent, got %d", notified)
+	}
+
+	// Solo il contatore dei pacchetti cambia: nessuna notifica
+	heartbeat("node-a", "cfg", 5, "eth0", "eth1")
+	if notified != 3 {
+		t.Errorf("Expected no notification for a routine heartbeat, got %d", notified)
+	}
+	heartbeat("node-a", "cfg", 5, "eth0")
+	if notified != 4 {
+		t.Errorf("Expected a notification for changed interfaces, got %d", notified)
+	}
+
+	agents := agg.Agents("cfg")
+	if len(agents) != 2 || agents[0].NodeName != "node-a" || agents[0].PacketsSeen != 5 ||
+		agents[0].Version != "v1.2.3" || !agents[0].StartedAt.Equal(started.Truncate(0)) {
+		t.Fatalf("Unexpected agents of cfg: %+v", agents)
+	}
+
+	// Un agent silenzioso resta visibile come non vivo, poi scade
+	agg.agentsMu.Lock()
+	silent := agg.agents["cfg/node-b"]
+	silent.LastHeartbeat = time.Now().Add(-agentHeartbeatTimeout - time.Second)
+	agg.agents["cfg/node-b"] = silent
+	agg.agentsMu.Unlock()
+	agents = agg.Agents("cfg")
+	if len(agents) != 2 || agents[0].NodeName != "node-b" || agents[0].Alive(time.Now()) {
+		t.Errorf("Expected the silent agent first, not alive, got %+v", agents)
+	}
+
+	heartbeat("node-b", "cfg", 0)
+	if notified != 5 {
+		t.Errorf("Expected a notification when the agent comes back, got %d", notified)
+	}
+
+	agg.agentsMu.Lock()
+	silent = agg.agents["cfg/node-b"]
+	silent.LastHeartbeat = time.Now().Add(-agentHeartbeatTTL - time.Second)
+	agg.agents["cfg/node-b"] = silent
+	agg.agentsMu.Unlock()
+	if agents := agg.Agents("cfg"); len(agents) != 1 || agents[0].NodeName != "node-a" {
+		t.Errorf("Expected the dead agent to be dropped, got %+v", agents)
+	}
+}
+
+func TestAggregator_CheckAgents(t *testing.T) {
+	agg := NewAggregator(NewMACMapper(nil, logr.Discard()), NewVMStarter(nil, logr.Discard()), logr.Discard())
+	notified := 0
+	agg.OnAgentsChange(func() { notified++ })
+
+	now := time.Now()
+	agg.agents = map[string]NodeAgent{
+		"cfg/node-a":   {NodeName: "node-a", WolConfig: "cfg", LastHeartbeat: now},
+		"cfg/node-b":   {NodeName: "node-b", WolConfig: "cfg", LastHeartbeat: now},
+		"other/node-a": {NodeName: "node-a", WolConfig: "other", LastHeartbeat: now},
+	}
+
+	if stale := agg.checkAgents(""); stale != "" || notified != 0 {
+		t.Fatalf("Expected no stale agents and no notification, got %q, %d", stale, notified)
+	}
+	if got := testutil.ToFloat64(AgentsConnected.WithLabelValues("cfg")); got != 2 {
+		t.Errorf("Expected 2 agents connected for cfg, got %v", got)
+	}
+
+	agg.agentsMu.Lock()
+	silent := agg.agents["cfg/node-b"]
+	silent.LastHeartbeat = now.Add(-agentHeartbeatTimeout - time.Second)
+	agg.agents["cfg/node-b"] = silent
+	agg.agentsMu.Unlock()
+
+	stale := agg.checkAgents("")
+	if stale != "cfg/node-b" || notified != 1 {
+		t.Fatalf("Expected node-b stale and a notification, got %q, %d", stale, notified)
+	}
+	if got := testutil.ToFloat64(AgentsConnected.WithLabelValues("cfg")); got != 1 {
+		t.Errorf("Expected 1 agent connected for cfg, got %v", got)
+	}
+	// Nessuna nuova notifica finché l'insieme degli agent stale non cambia
+	agg.checkAgents(stale)
+	if notified != 1 {
+		t.Errorf("Expected no notification for the same stale agents, got %d", notified)
+	}
+
+	// Scaduto il TTL l'agent viene rimosso: lo status va aggiornato
+	agg.agentsMu.Lock()
+	silent.LastHeartbeat = now.Add(-agentHeartbeatTTL - time.Second)
+	agg.agents["cfg/node-b"] = silent
+	agg.agentsMu.Unlock()
+	if stale := agg.checkAgents(stale); stale != "" || notified != 2 {
+		t.Errorf("Expected the expired agent dropped with a notification, got %q, %d", stale, notified)
+	}
+}
+
+func TestAgent_Heartbeat(t *testing.T) {
+	agent := NewAgent(9, "node-a", "", logr.Discard())
+	agent.SetWolConfigName("cfg")
+	agent.startedAt = time.Now()
+	agent.rawListeners = []*RawListener{NewRawListener("eth0", nil, logr.Discard())}
+	agent.packetsSeen.Add(3)
+	agent.reportFailures.Add(1)
+
+	req := agent.heartbeat()
+	if req.NodeName != "node-a" || req.WolConfig != "cfg" || req.Version != Version ||
+		req.PacketsSeen != 3 || req.ReportFailures != 1 || len(req.Interfaces) != 1 || req.Interfaces[0] != "eth0" ||
+		!req.StartedAt.AsTime().Equal(agent.startedAt.Truncate(0)) {
+		t.Errorf("Unexpected heartbeat %v", req)
+	}
+}
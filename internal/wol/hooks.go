@@ -0,0 +1,247 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// Wake hooks let callers without a Kubernetes identity nor L2 access (home
+// automation, CI jobs, cloud schedulers) wake the VMs of a WolConfig. Each
+// hook has its own HMAC key; a request is signed as
+//
+//	X-WOL-Hook:      <wolconfig>/<hook>
+//	X-WOL-Timestamp: <unix seconds>
+//	X-WOL-Signature: sha256=<hex HMAC-SHA256(key, "<timestamp>.<body>")>
+
+// WakeHookWakeSource is the source reported by the wakes requested by wake hooks
+const WakeHookWakeSource = "webhook"
+
+// Headers of the wake hook requests
+const (
+	WakeHookHeader          = "X-WOL-Hook"
+	WakeHookTimestampHeader = "X-WOL-Timestamp"
+	WakeHookSignatureHeader = "X-WOL-Signature"
+)
+
+const (
+	// minWakeHookKeyLength rejects keys too short for HMAC-SHA256
+	minWakeHookKeyLength = 32
+	// wakeHookMaxSkew bounds the age of a signed request, and how long its
+	// signature is remembered to refuse replays
+	wakeHookMaxSkew = 5 * time.Minute
+	// wakeHookMaxBody bounds the body of a wake hook request
+	wakeHookMaxBody = 4 << 10
+)
+
+// wakeTargetRequest is the VM a wake hook request or an MQTT message asks to
+// wake: a MAC, or the name and namespace of the VM
+type wakeTargetRequest struct {
+	MAC       string `json:"mac,omitempty"`
+	VM        string `json:"vm,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// valid returns true if the request names a VM
+func (req wakeTargetRequest) valid() bool {
+	return req.MAC != "" || (req.VM != "" && req.Namespace != "")
+}
+
+func (req wakeTargetRequest) String() string {
+	if req.MAC != "" {
+		return req.MAC
+	}
+	return req.Namespace + "/" + req.VM
+}
+
+// resolve returns the VM of the request if configName maps it: the VMs of the
+// other WolConfigs look unknown
+func (req wakeTargetRequest) resolve(mapper *MACMapper, configName string) (VMInfo, bool) {
+	var info VMInfo
+	var found bool
+	if req.MAC != "" {
+		info, found = mapper.Lookup(req.MAC)
+	} else {
+		info, found = mapper.LookupVM(req.Namespace, req.VM)
+	}
+	return info, found && info.ConfigName == configName
+}
+
+// loadWakeHookKeys reads the HMAC keys of the wake hooks of a config from their
+// Secrets. A hook whose key cannot be read is left out (it cannot authenticate).
+func (m *MACMapper) loadWakeHookKeys(ctx context.Context, config *wolv1beta1.WolConfig, keys map[string][]byte) error {
+	var errs []string
+	for _, hook := range config.Spec.WakeHooks {
+		ref := hook.KeySecretRef
+		key := ref.Key
+		if key == "" {
+			key = defaultSecureOnSecretKey // same default as the CRD
+		}
+
+		secret := &corev1.Secret{}
+		if err := m.secretReader.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, secret); err != nil {
+			errs = append(errs, fmt.Sprintf("wake hook %s: failed to get key secret %s/%s: %v", hook.Name, ref.Namespace, ref.Name, err))
+			continue
+		}
+		hmacKey := strings.TrimSpace(string(secret.Data[key]))
+		if len(hmacKey) < minWakeHookKeyLength {
+			errs = append(errs, fmt.Sprintf("wake hook %s: key %q of secret %s/%s must hold a key of at least %d characters",
+				hook.Name, key, ref.Namespace, ref.Name, minWakeHookKeyLength))
+			continue
+		}
+		keys[config.Name+"/"+hook.Name] = []byte(hmacKey)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// wakeHookKey returns the HMAC key of a hook (<wolconfig>/<hook>)
+func (m *MACMapper) wakeHookKey(hook string) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	key, ok := m.wakeHookKeys[hook]
+	return key, ok
+}
+
+// signWakeHook returns the X-WOL-Signature of a request sent at timestamp
+func signWakeHook(key []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// wakeHookReplays remembers the signatures accepted within the allowed skew,
+// so a captured request cannot be sent again
+type wakeHookReplays struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// remember records a signature, returning false if it was already seen
+func (r *wakeHookReplays) remember(signature string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for sig, expires := range r.seen {
+		if now.After(expires) {
+			delete(r.seen, sig)
+		}
+	}
+	if _, found := r.seen[signature]; found {
+		return false
+	}
+	if r.seen == nil {
+		r.seen = make(map[string]time.Time)
+	}
+	// Il timestamp può essere nel futuro fino allo skew: la firma resta valida
+	// per al massimo due volte lo skew
+	r.seen[signature] = now.Add(2 * wakeHookMaxSkew)
+	return true
+}
+
+// verifyWakeHook checks the headers and signature of a wake hook request and
+// returns the hook it comes from, or why it was refused
+func (s *APIServer) verifyWakeHook(r *http.Request, body []byte, now time.Time) (string, error) {
+	hook := r.Header.Get(WakeHookHeader)
+	key, found := s.mapper.wakeHookKey(hook)
+	if !found {
+		return "", fmt.Errorf("unknown wake hook %q", hook)
+	}
+	timestamp := r.Header.Get(WakeHookTimestampHeader)
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+	if skew := now.Sub(time.Unix(sent, 0)); skew > wakeHookMaxSkew || skew < -wakeHookMaxSkew {
+		return "", fmt.Errorf("timestamp %s outside the allowed skew", timestamp)
+	}
+	signature := r.Header.Get(WakeHookSignatureHeader)
+	if !hmac.Equal([]byte(signature), []byte(signWakeHook(key, timestamp, body))) {
+		return "", fmt.Errorf("invalid signature")
+	}
+	if !s.hookReplays.remember(signature, now) {
+		return "", fmt.Errorf("replayed request")
+	}
+	return hook, nil
+}
+
+// serveWakeHook wakes a VM of the WolConfig of a signed wake hook. Every
+// authentication failure gets the same answer, and a VM outside the
+// WolConfig of the hook answers as an unknown one.
+func (s *APIServer) serveWakeHook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "use POST"})
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, wakeHookMaxBody))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, apiError{Error: "request body too large"})
+		return
+	}
+	hook, err := s.verifyWakeHook(r, body, time.Now())
+	if err != nil {
+		s.log.Info("Wake hook request rejected", "hook", r.Header.Get(WakeHookHeader), "remote", r.RemoteAddr,
+			"reason", err.Error())
+		writeJSON(w, http.StatusUnauthorized, apiError{Error: "invalid wake hook signature"})
+		return
+	}
+	configName, _, _ := strings.Cut(hook, "/")
+
+	var req wakeTargetRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: fmt.Sprintf("invalid body: %v", err)})
+		return
+	}
+	if !req.valid() {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "either mac or vm and namespace are required"})
+		return
+	}
+	info, found := req.resolve(s.mapper, configName)
+	if !found {
+		writeJSON(w, http.StatusNotFound, apiError{Error: fmt.Sprintf("no VM %s in WolConfig %s", req, configName)})
+		return
+	}
+
+	resp, _ := s.aggregator.RequestWake(r.Context(), &wolv1.WakeRequest{
+		Namespace: info.Namespace,
+		Name:      info.Name,
+		Source:    WakeHookWakeSource,
+		Reason:    "wake hook " + hook,
+	})
+	writeWakeResponse(w, info.Namespace, info.Name, resp)
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+const testWakeHookKey = "0123456789abcdef0123456789abcdef"
+
+func newWakeHookAPI(t *testing.T) (*MACMapper, http.Handler, *policyStarter) {
+	t.Helper()
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "wake-hooks", Namespace: "kubevirt-wol"},
+		Data: map[string][]byte{
+			"ha":    []byte(testWakeHookKey + "\n"),
+			"short": []byte("0123456789abcdef"),
+		},
+	}
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+	mapper.SetSecretReader(fake.NewClientBuilder().WithObjects(secret).Build())
+	hooks := []wolv1beta1.WakeHookSpec{
+		{Name: "ha", KeySecretRef: wolv1beta1.SecretKeyReference{Name: "wake-hooks", Namespace: "kubevirt-wol", Key: "ha"}},
+		{Name: "weak", KeySecretRef: wolv1beta1.SecretKeyReference{Name: "wake-hooks", Namespace: "kubevirt-wol", Key: "short"}},
+	}
+	lab := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:01", VMName: "vm1", Namespace: "team-a"},
+			},
+			WakeHooks: hooks,
+		},
+	}
+	lab.Name = "lab"
+	other := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:02", VMName: "vm2", Namespace: "team-b"},
+			},
+		},
+	}
+	other.Name = "other"
+	mapper.UpdateConfigs([]wolv1beta1.WolConfig{*lab, *other})
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	starter := &policyStarter{actions: make(map[string]string)}
+	api := NewAPIServer(mapper, NewAggregator(mapper, starter, logr.Discard()), &apiReviewer{}, logr.Discard())
+	return mapper, api.Handler(), starter
+}
+
+func signedHookRequest(handler http.Handler, hook string, key []byte, sent time.Time, body string) *httptest.ResponseRecorder {
+	timestamp := strconv.FormatInt(sent.Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/hooks/wake", bytes.NewBufferString(body))
+	req.Header.Set(WakeHookHeader, hook)
+	req.Header.Set(WakeHookTimestampHeader, timestamp)
+	req.Header.Set(WakeHookSignatureHeader, signWakeHook(key, timestamp, []byte(body)))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMACMapper_WakeHookKeys(t *testing.T) {
+	mapper, _, _ := newWakeHookAPI(t)
+
+	if key, ok := mapper.wakeHookKey("lab/ha"); !ok || string(key) != testWakeHookKey {
+		t.Errorf("Expected the trimmed key of lab/ha, got %q (ok=%v)", key, ok)
+	}
+	if _, ok := mapper.wakeHookKey("lab/weak"); ok {
+		t.Error("Expected a key shorter than the minimum length to be ignored")
+	}
+	if _, ok := mapper.wakeHookKey("other/ha"); ok {
+		t.Error("Expected no hook for a WolConfig without wake hooks")
+	}
+}
+
+func TestAPIServer_WakeHook(t *testing.T) {
+	_, handler, starter := newWakeHookAPI(t)
+	key := []byte(testWakeHookKey)
+	now := time.Now()
+
+	tests := []struct {
+		name, hook string
+		key        []byte
+		sent       time.Time
+		body       string
+		wantCode   int
+	}{
+		{"unknown hook", "lab/nope", key, now, `{"mac":"52:54:00:00:00:01"}`, http.StatusUnauthorized},
+		{"wrong key", "lab/ha", []byte("fedcba9876543210fedcba9876543210"), now, `{"mac":"52:54:00:00:00:01"}`, http.StatusUnauthorized},
+		{"stale timestamp", "lab/ha", key, now.Add(-10 * time.Minute), `{"mac":"52:54:00:00:00:01"}`, http.StatusUnauthorized},
+		{"future timestamp", "lab/ha", key, now.Add(10 * time.Minute), `{"mac":"52:54:00:00:00:01"}`, http.StatusUnauthorized},
+		{"invalid body", "lab/ha", key, now, `{"mac":`, http.StatusBadRequest},
+		{"missing target", "lab/ha", key, now, `{"vm":"vm1"}`, http.StatusBadRequest},
+		{"unknown MAC", "lab/ha", key, now, `{"mac":"aa:bb:cc:dd:ee:ff"}`, http.StatusNotFound},
+		// Le VM di altre WolConfig sembrano sconosciute
+		{"VM of another WolConfig", "lab/ha", key, now, `{"vm":"vm2","namespace":"team-b"}`, http.StatusNotFound},
+		{"wake by MAC", "lab/ha", key, now, `{"mac":"52-54-00-00-00-01"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := signedHookRequest(handler, tt.hook, tt.key, tt.sent, tt.body)
+			if rec.Code != tt.wantCode {
+				t.Errorf("Expected %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	if len(starter.actions) != 1 || starter.actions["vm1"] != "start" {
+		t.Errorf("Expected only vm1 to be started, got %v", starter.actions)
+	}
+
+	// La stessa richiesta firmata non può essere rispedita
+	rec := signedHookRequest(handler, "lab/ha", key, now, `{"mac":"52-54-00-00-00-01"}`)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a replayed request to be rejected, got %d", rec.Code)
+	}
+
+	get := httptest.NewRequest(http.MethodGet, "/api/v1/hooks/wake", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, get)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected %d for GET, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
@@ -0,0 +1,418 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// AnnotationIdleShutdown set to "false" keeps a VM running when idle
+const AnnotationIdleShutdown = "wol.pillon.org/idle-shutdown"
+
+// Reasons of the Kubernetes Events recorded for the idle VMs
+const (
+	// EventReasonIdleStopped: the VM was stopped or paused after being idle
+	EventReasonIdleStopped = "StoppedWhenIdle"
+	// EventReasonIdleFailed: the stop of an idle VM failed
+	EventReasonIdleFailed = "IdleShutdownFailed"
+)
+
+const (
+	// idleCheckInterval is how often the VMs are checked
+	idleCheckInterval = time.Minute
+	// idlePrometheusTimeout bounds a Prometheus query
+	idlePrometheusTimeout = 10 * time.Second
+	// idlePrometheusMaxBody bounds the Prometheus responses read
+	idlePrometheusMaxBody = 16 << 20
+	// idleCPUQuery is the CPU usage, in cores, of each VM instance
+	idleCPUQuery = `sum by (namespace, name) (rate(kubevirt_vmi_cpu_usage_seconds_total[5m]))`
+)
+
+// idleShutdown is the IdleShutdown of a WolConfig with its Secrets read
+type idleShutdown struct {
+	idleAfter       time.Duration
+	action          wolv1beta1.ShutdownAction
+	noLoggedInUsers bool
+	prometheus      *idlePrometheus // nil without a CPU threshold
+}
+
+// idlePrometheus is the Prometheus the CPU usage of the VMs is read from
+type idlePrometheus struct {
+	url          string
+	cpuThreshold float64 // cores
+	token        string
+	ca           string // PEM, "" for the system CAs
+}
+
+// loadIdleShutdown resolves the IdleShutdown of a config, reading its Secrets.
+// Returns false if the config does not stop idle VMs.
+func (m *MACMapper) loadIdleShutdown(ctx context.Context, config *wolv1beta1.WolConfig) (idleShutdown, bool, error) {
+	spec := config.Spec.IdleShutdown
+	if spec == nil {
+		return idleShutdown{}, false, nil
+	}
+	idle := idleShutdown{
+		idleAfter:       spec.IdleAfter.Duration,
+		action:          spec.Action,
+		noLoggedInUsers: spec.NoLoggedInUsers,
+	}
+	if idle.idleAfter <= 0 {
+		idle.idleAfter = time.Hour // same default as the CRD
+	}
+	if idle.action == "" {
+		idle.action = wolv1beta1.ShutdownActionStop
+	}
+	if spec.Prometheus == nil && !spec.NoLoggedInUsers {
+		return idleShutdown{}, false, fmt.Errorf("idle shutdown requires prometheus or noLoggedInUsers")
+	}
+
+	if p := spec.Prometheus; p != nil {
+		if u, err := url.Parse(p.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return idleShutdown{}, false, fmt.Errorf("invalid Prometheus URL %q", p.URL)
+		}
+		idle.prometheus = &idlePrometheus{
+			url:          strings.TrimSuffix(p.URL, "/"),
+			cpuThreshold: float64(p.CPUThresholdMillicores) / 1000,
+		}
+		if p.CPUThresholdMillicores <= 0 {
+			idle.prometheus.cpuThreshold = 0.05 // same default as the CRD
+		}
+		if ref := p.BearerTokenSecretRef; ref != nil {
+			token, err := m.readSecretKey(ctx, *ref)
+			if err != nil {
+				return idleShutdown{}, false, fmt.Errorf("Prometheus token: %w", err)
+			}
+			idle.prometheus.token = strings.TrimSpace(string(token))
+		}
+		if ref := p.CASecretRef; ref != nil {
+			ca, err := m.readSecretKey(ctx, *ref)
+			if err != nil {
+				return idleShutdown{}, false, fmt.Errorf("Prometheus CA: %w", err)
+			}
+			if !x509.NewCertPool().AppendCertsFromPEM(ca) {
+				return idleShutdown{}, false, fmt.Errorf("Prometheus CA secret %s/%s holds no PEM certificate", ref.Namespace, ref.Name)
+			}
+			idle.prometheus.ca = string(ca)
+		}
+	}
+	return idle, true, nil
+}
+
+// idleShutdownTargets returns the idle shutdown of each config and the VMs of
+// these configs, sorted
+func (m *MACMapper) idleShutdownTargets() (map[string]idleShutdown, []VMInfo) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.idleShutdowns) == 0 {
+		return nil, nil
+	}
+	var vms []VMInfo
+	for _, info := range m.vms {
+		if _, ok := m.idleShutdowns[info.ConfigName]; ok {
+			vms = append(vms, info)
+		}
+	}
+	slices.SortFunc(vms, func(a, b VMInfo) int {
+		return strings.Compare(vmIndexKey(a.Namespace, a.Name), vmIndexKey(b.Namespace, b.Name))
+	})
+	return m.idleShutdowns, vms
+}
+
+// IdleActions stops the idle VMs and reads their logged-in users
+type IdleActions interface {
+	VMStopper
+	GuestUserReader
+}
+
+// idleInstance is a VM instance seen idle
+type idleInstance struct {
+	uid   types.UID
+	since time.Time
+}
+
+// IdleMonitor stops or pauses the running VMs that stay idle for longer than
+// the IdleShutdown of their WolConfig
+type IdleMonitor struct {
+	mapper   *MACMapper
+	vms      IdleActions
+	log      logr.Logger
+	recorder record.EventRecorder // opzionale, Event sulle VM fermate
+
+	interval time.Duration
+	now      func() time.Time
+	// idle are the instances seen idle at the last checks (<namespace>/<vm>).
+	// A new instance (e.g. a VM woken again) starts idle from scratch.
+	idle map[string]idleInstance
+}
+
+// NewIdleMonitor creates the monitor of the idle VMs
+func NewIdleMonitor(mapper *MACMapper, vms IdleActions, log logr.Logger) *IdleMonitor {
+	return &IdleMonitor{
+		mapper:   mapper,
+		vms:      vms,
+		log:      log,
+		interval: idleCheckInterval,
+		now:      time.Now,
+		idle:     make(map[string]idleInstance),
+	}
+}
+
+// SetEventRecorder makes the monitor record an Event on the VMs it stops
+func (m *IdleMonitor) SetEventRecorder(recorder record.EventRecorder) {
+	m.recorder = recorder
+}
+
+// Run checks the VMs periodically until ctx is done
+func (m *IdleMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check(ctx)
+		}
+	}
+}
+
+// check updates the idle VMs and stops the ones idle for too long
+func (m *IdleMonitor) check(ctx context.Context) {
+	settings, vms := m.mapper.idleShutdownTargets()
+	IdleVMs.Reset()
+
+	// Una query per WolConfig: senza risposta nessuna VM è idle
+	usage := make(map[string]map[string]float64)
+	for name, idle := range settings {
+		if idle.prometheus == nil {
+			continue
+		}
+		cpu, err := queryCPUUsage(ctx, idle.prometheus)
+		if err != nil {
+			m.log.Error(err, "Failed to read the CPU usage of the VMs", "config", name)
+			ErrorsTotal.Inc()
+			continue
+		}
+		usage[name] = cpu
+	}
+
+	now := m.now()
+	seen := make(map[string]bool)
+	for _, info := range vms {
+		idle := settings[info.ConfigName]
+		key := vmIndexKey(info.Namespace, info.Name)
+		vm, uid, ok := m.vmIdle(ctx, info, idle, usage[info.ConfigName])
+		if !ok {
+			continue
+		}
+		seen[key] = true
+		state, found := m.idle[key]
+		if !found || state.uid != uid {
+			state = idleInstance{uid: uid, since: now}
+			m.idle[key] = state
+		}
+		IdleVMs.WithLabelValues(info.ConfigName).Inc()
+
+		if idleFor := now.Sub(state.since); idleFor >= idle.idleAfter {
+			m.shutdown(ctx, info, vm, idle.action, idleFor)
+			delete(seen, key)
+		}
+	}
+	for key := range m.idle {
+		if !seen[key] {
+			delete(m.idle, key)
+		}
+	}
+}
+
+// vmIdle returns the VM and the UID of its instance if the VM is running and
+// every configured signal says it is idle. usage is nil if it could not be read.
+func (m *IdleMonitor) vmIdle(ctx context.Context, info VMInfo, idle idleShutdown, usage map[string]float64) (*kubevirtv1.VirtualMachine, types.UID, bool) {
+	key := client.ObjectKey{Namespace: info.Namespace, Name: info.Name}
+	vm := &kubevirtv1.VirtualMachine{}
+	if err := m.mapper.client.Get(ctx, key, vm); err != nil {
+		if !apierrors.IsNotFound(err) {
+			m.log.V(1).Info("Cannot get the VM", "vm", info.Name, "namespace", info.Namespace, "error", err.Error())
+		}
+		return nil, "", false
+	}
+	if vm.Annotations[AnnotationIdleShutdown] == "false" {
+		return nil, "", false
+	}
+	vmi := &kubevirtv1.VirtualMachineInstance{}
+	if err := m.mapper.client.Get(ctx, key, vmi); err != nil {
+		return nil, "", false
+	}
+	if vmi.Status.Phase != kubevirtv1.Running || vmiPaused(vmi) {
+		return nil, "", false
+	}
+
+	if idle.prometheus != nil {
+		cores, found := usage[key.String()]
+		if !found || cores >= idle.prometheus.cpuThreshold {
+			return nil, "", false
+		}
+	}
+	if idle.noLoggedInUsers {
+		if !vmiAgentConnected(vmi) {
+			return nil, "", false
+		}
+		users, err := m.vms.LoggedInUsers(ctx, info.Namespace, info.Name)
+		if err != nil {
+			m.log.V(1).Info("Cannot read the logged-in users", "vm", info.Name, "namespace", info.Namespace,
+				"error", err.Error())
+			return nil, "", false
+		}
+		if users > 0 {
+			return nil, "", false
+		}
+	}
+	return vm, vmi.UID, true
+}
+
+// shutdown stops or pauses an idle VM, as the user starting it
+func (m *IdleMonitor) shutdown(ctx context.Context, info VMInfo, vm *kubevirtv1.VirtualMachine, action wolv1beta1.ShutdownAction, idleFor time.Duration) {
+	// In dry-run la VM resta accesa: l'istanza riparte da zero come dopo uno stop
+	if m.mapper.DryRun(info.ConfigName) {
+		m.log.Info("Dry run: not shutting down idle VM", "vm", info.Name, "namespace", info.Namespace,
+			"action", action, "idleFor", idleFor.Round(time.Minute), "wolconfig", info.ConfigName)
+		DryRunActionsTotal.WithLabelValues(info.ConfigName, string(action)).Inc()
+		return
+	}
+	var err error
+	if action == wolv1beta1.ShutdownActionPause {
+		err = m.vms.PauseVMAs(ctx, info.StartAs, info.Namespace, info.Name)
+	} else {
+		err = m.vms.StopVMAs(ctx, info.StartAs, info.Namespace, info.Name)
+	}
+
+	idleFor = idleFor.Round(time.Minute)
+	if err != nil {
+		m.log.Error(err, "Failed to shut down idle VM", "vm", info.Name, "namespace", info.Namespace, "action", action)
+		IdleShutdownsTotal.WithLabelValues(string(action), "failed").Inc()
+		if m.recorder != nil {
+			m.recorder.Eventf(vm, corev1.EventTypeWarning, EventReasonIdleFailed,
+				"%s after %s idle (WolConfig %s) failed: %v", action, idleFor, info.ConfigName, err)
+		}
+		return
+	}
+	m.log.Info("Shut down idle VM", "vm", info.Name, "namespace", info.Namespace, "action", action, "idleFor", idleFor)
+	IdleShutdownsTotal.WithLabelValues(string(action), "success").Inc()
+	if m.recorder != nil {
+		m.recorder.Eventf(vm, corev1.EventTypeNormal, EventReasonIdleStopped,
+			"%s after %s idle (WolConfig %s)", action, idleFor, info.ConfigName)
+	}
+}
+
+// vmiAgentConnected returns true if the guest agent of the instance is connected
+func vmiAgentConnected(vmi *kubevirtv1.VirtualMachineInstance) bool {
+	for _, condition := range vmi.Status.Conditions {
+		if condition.Type == kubevirtv1.VirtualMachineInstanceAgentConnected && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// prometheusResponse is the answer of the Prometheus instant query API
+type prometheusResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []any             `json:"value"` // [timestamp, "value"]
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// queryCPUUsage returns the CPU usage, in cores, of the running VMs
+// (<namespace>/<vm> -> cores)
+func queryCPUUsage(ctx context.Context, p *idlePrometheus) (map[string]float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, idlePrometheusTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		p.url+"/api/v1/query?query="+url.QueryEscape(idleCPUQuery), nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	transport := &http.Transport{DisableKeepAlives: true}
+	if p.ca != "" {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM([]byte(p.ca))
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var answer prometheusResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, idlePrometheusMaxBody)).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("invalid Prometheus response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if answer.Status != "success" {
+		return nil, fmt.Errorf("Prometheus query failed (HTTP %d): %s", resp.StatusCode, answer.Error)
+	}
+	if answer.Data.ResultType != "vector" {
+		return nil, fmt.Errorf("unexpected Prometheus result type %q", answer.Data.ResultType)
+	}
+
+	usage := make(map[string]float64, len(answer.Data.Result))
+	for _, sample := range answer.Data.Result {
+		if len(sample.Value) != 2 {
+			continue
+		}
+		value, ok := sample.Value[1].(string)
+		if !ok {
+			continue
+		}
+		cores, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		usage[vmIndexKey(sample.Metric["namespace"], sample.Metric["name"])] = cores
+	}
+	return usage, nil
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// idleActions records the idle VMs stopped and reports the logged-in users
+type idleActions struct {
+	actions map[string]string
+	users   map[string]int
+}
+
+func (a *idleActions) StopVMAs(_ context.Context, _, _, name string) error {
+	a.actions[name] = "stop"
+	return nil
+}
+
+func (a *idleActions) PauseVMAs(_ context.Context, _, _, name string) error {
+	a.actions[name] = "pause"
+	return nil
+}
+
+func (a *idleActions) LoggedInUsers(_ context.Context, _, name string) (int, error) {
+	users, ok := a.users[name]
+	if !ok {
+		return 0, fmt.Errorf("guest agent of %s not responding", name)
+	}
+	return users, nil
+}
+
+func runningVMI(name, uid string) *kubevirtv1.VirtualMachineInstance {
+	vmi := &kubevirtv1.VirtualMachineInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a", UID: types.UID(uid)},
+	}
+	vmi.Status.Phase = kubevirtv1.Running
+	vmi.Status.Conditions = []kubevirtv1.VirtualMachineInstanceCondition{
+		{Type: kubevirtv1.VirtualMachineInstanceAgentConnected, Status: corev1.ConditionTrue},
+	}
+	return vmi
+}
+
+// prometheusServer answers the CPU usage queries with the given usage by VM
+// (in cores)
+func prometheusServer(t *testing.T, usage map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" || r.URL.Query().Get("query") != idleCPUQuery {
+			http.Error(w, `{"status":"error","error":"unexpected query"}`, http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, `{"status":"error","error":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		result := ""
+		for name, cores := range usage {
+			if result != "" {
+				result += ","
+			}
+			result += fmt.Sprintf(`{"metric":{"namespace":"team-a","name":%q},"value":[1741939200,%q]}`, name, cores)
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[%s]}}`, result)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestQueryCPUUsage(t *testing.T) {
+	server := prometheusServer(t, map[string]string{"vm1": "0.012", "vm2": "1.5", "vm3": "NaN?"})
+	usage, err := queryCPUUsage(context.Background(), &idlePrometheus{url: server.URL, token: "s3cret"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(usage) != 2 || usage["team-a/vm1"] != 0.012 || usage["team-a/vm2"] != 1.5 {
+		t.Errorf("Expected the usage of vm1 and vm2, got %v", usage)
+	}
+
+	if _, err := queryCPUUsage(context.Background(), &idlePrometheus{url: server.URL}); err == nil {
+		t.Error("Expected the query without the token to fail")
+	}
+}
+
+func TestIdleMonitor(t *testing.T) {
+	server := prometheusServer(t, map[string]string{
+		"vm1": "0.01", "vm2": "0.9", "vm3": "0.01", "vm4": "0.01", "vm5": "0.01",
+	})
+	optOut := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "vm4", Namespace: "team-a",
+		Annotations: map[string]string{AnnotationIdleShutdown: "false"}}}
+	objects := []client.Object{optOut, runningVMI("vm1", "uid-1"), runningVMI("vm2", "uid-2"),
+		runningVMI("vm3", "uid-3"), runningVMI("vm4", "uid-4")}
+	for _, name := range []string{"vm1", "vm2", "vm3", "vm5"} {
+		objects = append(objects, &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a"}})
+	}
+	c := newPolicyClient(t, objects...)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "prometheus", Namespace: "kubevirt-wol"},
+		Data:       map[string][]byte{"token": []byte("s3cret\n")},
+	}
+
+	mapper := NewMACMapper(c, logr.Discard())
+	mapper.SetSecretReader(fake.NewClientBuilder().WithObjects(secret).Build())
+	config := wolv1beta1.WolConfig{Spec: wolv1beta1.WolConfigSpec{
+		DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+		IdleShutdown: &wolv1beta1.IdleShutdownSpec{
+			IdleAfter: metav1.Duration{Duration: time.Hour},
+			Action:    wolv1beta1.ShutdownActionStop,
+			Prometheus: &wolv1beta1.IdlePrometheusSpec{
+				URL:                    server.URL + "/",
+				CPUThresholdMillicores: 50,
+				BearerTokenSecretRef: &wolv1beta1.SecretKeyReference{
+					Name: "prometheus", Namespace: "kubevirt-wol", Key: "token"},
+			},
+			NoLoggedInUsers: true,
+		},
+	}}
+	config.Name = "lab"
+	for i := 1; i <= 5; i++ {
+		config.Spec.ExplicitMappings = append(config.Spec.ExplicitMappings, wolv1beta1.MACVMMapping{
+			MACAddress: fmt.Sprintf("52:54:00:00:00:%02d", i), VMName: fmt.Sprintf("vm%d", i), Namespace: "team-a"})
+	}
+	mapper.UpdateConfig(&config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// vm2 lavora, vm3 ha un utente collegato, vm4 è esclusa, vm5 è spenta
+	actions := &idleActions{actions: make(map[string]string), users: map[string]int{"vm1": 0, "vm3": 1}}
+	monitor := NewIdleMonitor(mapper, actions, logr.Discard())
+	now := time.Date(2025, time.March, 14, 10, 0, 0, 0, time.UTC)
+	monitor.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	monitor.check(ctx)
+	if len(monitor.idle) != 1 || monitor.idle["team-a/vm1"].since != now {
+		t.Fatalf("Expected only vm1 to be idle, got %v", monitor.idle)
+	}
+	now = now.Add(59 * time.Minute)
+	monitor.check(ctx)
+	if len(actions.actions) != 0 {
+		t.Fatalf("Expected no VM to be stopped before idleAfter, got %v", actions.actions)
+	}
+	now = now.Add(time.Minute)
+	monitor.check(ctx)
+	if len(actions.actions) != 1 || actions.actions["vm1"] != "stop" {
+		t.Fatalf("Expected only vm1 to be stopped, got %v", actions.actions)
+	}
+	if len(monitor.idle) != 0 {
+		t.Errorf("Expected the stopped VM to be forgotten, got %v", monitor.idle)
+	}
+
+	// Una nuova istanza (VM svegliata di nuovo) riparte da zero
+	monitor.check(ctx)
+	vmi := &kubevirtv1.VirtualMachineInstance{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "team-a", Name: "vm1"}, vmi); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, vmi); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create(ctx, runningVMI("vm1", "uid-1b")); err != nil {
+		t.Fatal(err)
+	}
+	actions.actions = make(map[string]string)
+	now = now.Add(time.Hour)
+	monitor.check(ctx)
+	if len(actions.actions) != 0 || monitor.idle["team-a/vm1"].uid != "uid-1b" {
+		t.Errorf("Expected the new instance to be idle from now, got %v and %v", actions.actions, monitor.idle)
+	}
+}
+
+func TestIdleMonitor_SignalUnavailable(t *testing.T) {
+	c := newPolicyClient(t, &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "vm1", Namespace: "team-a"}},
+		runningVMI("vm1", "uid-1"))
+	mapper := NewMACMapper(c, logr.Discard())
+	config := wolv1beta1.WolConfig{Spec: wolv1beta1.WolConfigSpec{
+		DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+		ExplicitMappings: []wolv1beta1.MACVMMapping{
+			{MACAddress: "52:54:00:00:00:01", VMName: "vm1", Namespace: "team-a"},
+		},
+		IdleShutdown: &wolv1beta1.IdleShutdownSpec{
+			Action:     wolv1beta1.ShutdownActionPause,
+			Prometheus: &wolv1beta1.IdlePrometheusSpec{URL: "http://127.0.0.1:1"},
+		},
+	}}
+	config.Name = "lab"
+	mapper.UpdateConfig(&config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	monitor := NewIdleMonitor(mapper, &idleActions{actions: make(map[string]string)}, logr.Discard())
+	monitor.idle["team-a/vm1"] = idleInstance{uid: "uid-1", since: time.Now().Add(-2 * time.Hour)}
+	monitor.check(context.Background())
+	if len(monitor.idle) != 0 {
+		t.Errorf("Expected a VM whose CPU usage cannot be read not to be idle, got %v", monitor.idle)
+	}
+}
@@ -18,157 +18,622 @@ package wol
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	kubevirtv1 "kubevirt.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// Starter starts the VMs woken by the aggregator. VMStarter is the KubeVirt
+// implementation; embedders may provide their own (see pkg/wol).
+type Starter interface {
+	// StartVMAs starts the VM, impersonating username if not empty
+	StartVMAs(ctx context.Context, username, namespace, name string) error
+	// QueuedWakes returns the wakes waiting for their VM to settle (migrating
+	// or terminating), counted for saturation
+	QueuedWakes() int
+}
+
+// PolicyStarter is implemented by the Starters supporting the WolPolicy
+// actions other than Start
+type PolicyStarter interface {
+	// ResumeVMAs unpauses the VM if it is paused, and starts it otherwise
+	ResumeVMAs(ctx context.Context, username, namespace, name string) error
+	// RestartCrashedVMAs restarts the VM if it crashed, and starts it otherwise
+	RestartCrashedVMAs(ctx context.Context, username, namespace, name string) error
+}
+
+// VMStopper is implemented by the Starters that can act on the sleep packets
+// of Shutdown-on-LAN
+type VMStopper interface {
+	// StopVMAs gracefully stops the VM if it is running
+	StopVMAs(ctx context.Context, username, namespace, name string) error
+	// PauseVMAs pauses the instance of the VM if it is running
+	PauseVMAs(ctx context.Context, username, namespace, name string) error
+}
+
+// VMStateReader is implemented by the Starters that can read the state of a
+// VM, so the VMs already running are not started again
+type VMStateReader interface {
+	// VMState returns the state of the VM (see VMStateRunning) and the node of
+	// its instance, empty if it has none
+	VMState(ctx context.Context, namespace, name string) (state, node string, err error)
+}
+
+// GuestUserReader is implemented by the Starters that can list the users
+// logged in a VM through its guest agent
+type GuestUserReader interface {
+	// LoggedInUsers returns the number of users logged in the guest
+	LoggedInUsers(ctx context.Context, namespace, name string) (int, error)
+}
+
+// States of a VM returned by VMState; the others are the KubeVirt printable
+// status as is (e.g. Migrating, CrashLoopBackOff)
+const (
+	VMStateRunning  = "Running"
+	VMStateStarting = "Starting"
+	VMStateStopped  = "Stopped"
+	VMStatePaused   = "Paused"
 )
 
+var _ Starter = &VMStarter{}
+var _ PolicyStarter = &VMStarter{}
+var _ VMStopper = &VMStarter{}
+var _ VMStateReader = &VMStarter{}
+var _ GuestUserReader = &VMStarter{}
+
 // VMStarter handles starting VirtualMachines
 type VMStarter struct {
 	client client.Client
 	log    logr.Logger
+
+	// Impersonation support (optional, see EnableImpersonation)
+	restConfig         *rest.Config
+	scheme             *runtime.Scheme
+	impersonatedMu     sync.Mutex
+	impersonatedClient map[string]client.Client // username -> client
+
+	// queuedCount counts the queued wakes (see queueWake)
+	queuedCount atomic.Int64
+
+	// queuedWakes are the VMs whose wake waits for a migration or a
+	// termination to end (<namespace>/<vm>)
+	queuedMu    sync.Mutex
+	queuedWakes map[string]bool
+	// queuedCtx is cancelled when Run returns, dropping the queued wakes;
+	// queued tracks their goroutines
+	queuedCtx    context.Context
+	cancelQueued context.CancelFunc
+	queued       sync.WaitGroup
+	// settleInterval and settleTimeout bound the wait of a queued wake
+	settleInterval time.Duration
+	settleTimeout  time.Duration
 }
 
 // NewVMStarter creates a new VM starter
 func NewVMStarter(k8sClient client.Client, log logr.Logger) *VMStarter {
+	queuedCtx, cancelQueued := context.WithCancel(context.Background())
 	return &VMStarter{
-		client: k8sClient,
-		log:    log,
+		client:         k8sClient,
+		log:            log,
+		queuedWakes:    make(map[string]bool),
+		queuedCtx:      queuedCtx,
+		cancelQueued:   cancelQueued,
+		settleInterval: 5 * time.Second,
+		settleTimeout:  5 * time.Minute,
 	}
 }
 
-// StartVM starts a VirtualMachine using KubeVirt subresource API
-func (s *VMStarter) StartVM(ctx context.Context, namespace, name string) error {
-	vm := &kubevirtv1.VirtualMachine{}
-	key := client.ObjectKey{Namespace: namespace, Name: name}
+// Run waits until ctx is done, then drops the queued wakes and waits for
+// their goroutines to return. Wakes queued afterwards are dropped at once.
+func (s *VMStarter) Run(ctx context.Context) {
+	<-ctx.Done()
+	s.cancelQueued()
+	s.queued.Wait()
+}
 
-	// Get the VM to check current state
-	if err := s.client.Get(ctx, key, vm); err != nil {
-		ErrorsTotal.Inc()
-		return fmt.Errorf("failed to get VM %s/%s: %w", namespace, name, err)
-	}
+// EnableImpersonation allows StartVMAs to build clients that impersonate
+// other users (typically per-WolConfig ServiceAccounts)
+func (s *VMStarter) EnableImpersonation(restConfig *rest.Config, scheme *runtime.Scheme) {
+	s.impersonatedMu.Lock()
+	defer s.impersonatedMu.Unlock()
+
+	s.restConfig = restConfig
+	s.scheme = scheme
+	s.impersonatedClient = make(map[string]client.Client)
+}
 
-	// Check if VM is already running by looking at actual status
-	if vm.Spec.RunStrategy != nil {
-		// VM uses RunStrategy (modern approach)
+// ServiceAccountUsername returns the username Kubernetes assigns to a ServiceAccount
+func ServiceAccountUsername(namespace, name string) string {
+	return fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name)
+}
 
-		// Check if VM is actually running (not just configured to run)
-		isRunning := vm.Status.Ready ||
-			(vm.Status.PrintableStatus != "" &&
-				(vm.Status.PrintableStatus == kubevirtv1.VirtualMachineStatusRunning ||
-					vm.Status.PrintableStatus == kubevirtv1.VirtualMachineStatusStarting))
+// serviceAccountNamespace returns the namespace of a ServiceAccount username
+// (false if the username is not a ServiceAccount)
+func serviceAccountNamespace(username string) (string, bool) {
+	rest, ok := strings.CutPrefix(username, "system:serviceaccount:")
+	if !ok {
+		return "", false
+	}
+	namespace, _, ok := strings.Cut(rest, ":")
+	return namespace, ok
+}
 
-		if isRunning {
-			s.log.Info("VM is already running", "vm", name, "namespace", namespace, "runStrategy", *vm.Spec.RunStrategy)
-			return nil
+// RetainImpersonation drops the cached clients of the users not in keep,
+// e.g. the ServiceAccounts of deleted WolConfigs
+func (s *VMStarter) RetainImpersonation(keep map[string]bool) {
+	s.impersonatedMu.Lock()
+	defer s.impersonatedMu.Unlock()
+	for username := range s.impersonatedClient {
+		if !keep[username] {
+			delete(s.impersonatedClient, username)
+			s.log.V(1).Info("Dropped impersonated client", "user", username)
 		}
+	}
+}
 
-		// For strategies that need temporary change to start the VM
-		needsRestore := *vm.Spec.RunStrategy == kubevirtv1.RunStrategyOnce ||
-			*vm.Spec.RunStrategy == kubevirtv1.RunStrategyRerunOnFailure ||
-			*vm.Spec.RunStrategy == kubevirtv1.RunStrategyManual
+// evictImpersonation drops the cached client of a user, e.g. after its
+// ServiceAccount was deleted or lost its rights
+func (s *VMStarter) evictImpersonation(username string) {
+	s.impersonatedMu.Lock()
+	defer s.impersonatedMu.Unlock()
+	delete(s.impersonatedClient, username)
+}
 
-		if needsRestore {
-			// Save original strategy
-			originalStrategy := *vm.Spec.RunStrategy
+// clientFor returns the client used to act as the given user.
+// An empty username means the operator's own identity.
+func (s *VMStarter) clientFor(username string) (client.Client, error) {
+	if username == "" {
+		return s.client, nil
+	}
 
-			// Set to Always to start the VM
-			patch := client.MergeFrom(vm.DeepCopy())
-			runStrategy := kubevirtv1.RunStrategyAlways
-			vm.Spec.RunStrategy = &runStrategy
+	s.impersonatedMu.Lock()
+	defer s.impersonatedMu.Unlock()
 
-			if err := s.client.Patch(ctx, vm, patch); err != nil {
-				ErrorsTotal.Inc()
-				return fmt.Errorf("failed to start VM %s/%s: %w", namespace, name, err)
-			}
+	if s.restConfig == nil {
+		return nil, fmt.Errorf("impersonation of %s requested but not enabled", username)
+	}
+	if c, ok := s.impersonatedClient[username]; ok {
+		return c, nil
+	}
+
+	cfg := rest.CopyConfig(s.restConfig)
+	cfg.Impersonate = rest.ImpersonationConfig{UserName: username}
+	c, err := client.New(cfg, client.Options{Scheme: s.scheme})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client impersonating %s: %w", username, err)
+	}
+	s.impersonatedClient[username] = c
+	return c, nil
+}
 
-			s.log.Info("Temporarily changed RunStrategy to start VM", "vm", name, "namespace", namespace, "originalStrategy", originalStrategy)
-			VMStartedTotal.Inc()
+// StartVM starts a VirtualMachine using the operator's own identity
+func (s *VMStarter) StartVM(ctx context.Context, namespace, name string) error {
+	return s.StartVMAs(ctx, "", namespace, name)
+}
 
-			// Start goroutine to restore original strategy after VM is running
-			go s.restoreStrategyWhenRunning(context.Background(), namespace, name, originalStrategy)
+// StartVMAs starts a VirtualMachine impersonating the given user, so the start is
+// bounded by that user's RBAC. An empty username uses the operator's identity.
+// A ServiceAccount can only start VMs of its own namespace.
+func (s *VMStarter) StartVMAs(ctx context.Context, username, namespace, name string) error {
+	return s.runAs(username, namespace, name, func(c client.Client) error {
+		return s.startVM(ctx, c, username, namespace, name)
+	})
+}
 
-			return nil
+// ResumeVMAs unpauses a paused VirtualMachine impersonating the given user,
+// and starts it if it is not paused
+func (s *VMStarter) ResumeVMAs(ctx context.Context, username, namespace, name string) error {
+	return s.runAs(username, namespace, name, func(c client.Client) error {
+		vm := &kubevirtv1.VirtualMachine{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, vm); err != nil {
+			ErrorsTotal.Inc()
+			return fmt.Errorf("failed to get VM %s/%s: %w", namespace, name, err)
+		}
+		if vm.Status.PrintableStatus != kubevirtv1.VirtualMachineStatusPaused {
+			return s.startVM(ctx, c, username, namespace, name)
+		}
+
+		if err := s.putSubresource(ctx, username, namespace, "virtualmachineinstances", name, "unpause"); err != nil {
+			ErrorsTotal.Inc()
+			return fmt.Errorf("failed to unpause VM %s/%s: %w", namespace, name, err)
+		}
+		s.log.Info("Unpaused VM", "vm", name, "namespace", namespace)
+		return nil
+	})
+}
+
+// RestartCrashedVMAs restarts a crashed VirtualMachine (crash loop or failed
+// instance) impersonating the given user, and starts it if it did not crash
+func (s *VMStarter) RestartCrashedVMAs(ctx context.Context, username, namespace, name string) error {
+	return s.runAs(username, namespace, name, func(c client.Client) error {
+		crashed, err := vmCrashed(ctx, c, namespace, name)
+		if err != nil {
+			ErrorsTotal.Inc()
+			return err
+		}
+		if !crashed {
+			return s.startVM(ctx, c, username, namespace, name)
 		}
 
-		// For other strategies (Always, Halted), just ensure it's set correctly
-		if *vm.Spec.RunStrategy != kubevirtv1.RunStrategyAlways {
-			patch := client.MergeFrom(vm.DeepCopy())
-			runStrategy := kubevirtv1.RunStrategyAlways
-			vm.Spec.RunStrategy = &runStrategy
+		if err := s.putSubresource(ctx, username, namespace, "virtualmachines", name, "restart"); err != nil {
+			ErrorsTotal.Inc()
+			return fmt.Errorf("failed to restart VM %s/%s: %w", namespace, name, err)
+		}
+		s.log.Info("Restarted crashed VM", "vm", name, "namespace", namespace)
+		VMStartedTotal.Inc()
+		return nil
+	})
+}
 
-			if err := s.client.Patch(ctx, vm, patch); err != nil {
-				ErrorsTotal.Inc()
-				return fmt.Errorf("failed to start VM %s/%s: %w", namespace, name, err)
+// StopVMAs gracefully stops a running VirtualMachine impersonating the given user
+func (s *VMStarter) StopVMAs(ctx context.Context, username, namespace, name string) error {
+	return s.runAs(username, namespace, name, func(c client.Client) error {
+		vmi := &kubevirtv1.VirtualMachineInstance{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, vmi); err != nil {
+			if apierrors.IsNotFound(err) {
+				s.log.Info("VM is already stopped", "vm", name, "namespace", namespace)
+				return nil
 			}
+			ErrorsTotal.Inc()
+			return fmt.Errorf("failed to get VMI %s/%s: %w", namespace, name, err)
+		}
 
-			s.log.Info("Changed RunStrategy to start VM", "vm", name, "namespace", namespace)
-			VMStartedTotal.Inc()
+		if err := s.putSubresource(ctx, username, namespace, "virtualmachines", name, "stop"); err != nil {
+			ErrorsTotal.Inc()
+			return fmt.Errorf("failed to stop VM %s/%s: %w", namespace, name, err)
+		}
+		s.log.Info("Stopped VM", "vm", name, "namespace", namespace)
+		VMStoppedTotal.WithLabelValues(string(wolv1beta1.ShutdownActionStop)).Inc()
+		return nil
+	})
+}
+
+// PauseVMAs pauses the running instance of a VirtualMachine impersonating the given user
+func (s *VMStarter) PauseVMAs(ctx context.Context, username, namespace, name string) error {
+	return s.runAs(username, namespace, name, func(c client.Client) error {
+		vmi := &kubevirtv1.VirtualMachineInstance{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, vmi); err != nil {
+			if apierrors.IsNotFound(err) {
+				s.log.Info("VM is not running, nothing to pause", "vm", name, "namespace", namespace)
+				return nil
+			}
+			ErrorsTotal.Inc()
+			return fmt.Errorf("failed to get VMI %s/%s: %w", namespace, name, err)
+		}
+		if vmi.Status.Phase != kubevirtv1.Running || vmiPaused(vmi) {
+			s.log.Info("VM is not running or already paused", "vm", name, "namespace", namespace, "phase", vmi.Status.Phase)
+			return nil
 		}
 
+		if err := s.putSubresource(ctx, username, namespace, "virtualmachineinstances", name, "pause"); err != nil {
+			ErrorsTotal.Inc()
+			return fmt.Errorf("failed to pause VM %s/%s: %w", namespace, name, err)
+		}
+		s.log.Info("Paused VM", "vm", name, "namespace", namespace)
+		VMStoppedTotal.WithLabelValues(string(wolv1beta1.ShutdownActionPause)).Inc()
 		return nil
+	})
+}
+
+// vmiPaused returns true if the instance has the Paused condition
+func vmiPaused(vmi *kubevirtv1.VirtualMachineInstance) bool {
+	for _, condition := range vmi.Status.Conditions {
+		if condition.Type == kubevirtv1.VirtualMachineInstancePaused && condition.Status == corev1.ConditionTrue {
+			return true
+		}
 	}
+	return false
+}
 
-	// Fallback to deprecated Running field if RunStrategy not set
-	if vm.Spec.Running != nil && *vm.Spec.Running {
+// runAs runs fn with the client of the given user. A ServiceAccount can only
+// act on the VMs of its own namespace.
+func (s *VMStarter) runAs(username, namespace, name string, fn func(c client.Client) error) error {
+	if saNamespace, ok := serviceAccountNamespace(username); ok && saNamespace != namespace {
+		ErrorsTotal.Inc()
+		return fmt.Errorf("refusing to start VM %s/%s as %s: the ServiceAccount must be in the namespace of the VM",
+			namespace, name, username)
+	}
+
+	c, err := s.clientFor(username)
+	if err != nil {
+		ErrorsTotal.Inc()
+		return err
+	}
+
+	err = fn(c)
+	if username != "" && (apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err)) {
+		// The ServiceAccount may have been deleted or its rights revoked:
+		// rebuild the client at the next start
+		s.evictImpersonation(username)
+	}
+	return err
+}
+
+// putSubresource calls a KubeVirt subresource (e.g. virtualmachines/restart)
+// impersonating the given user. It needs the REST config of EnableImpersonation.
+func (s *VMStarter) putSubresource(ctx context.Context, username, namespace, resource, name, subresource string) error {
+	restClient, err := s.subresourceClient(username, resource, subresource)
+	if err != nil {
+		return err
+	}
+	return restClient.Put().
+		Namespace(namespace).
+		Resource(resource).
+		Name(name).
+		SubResource(subresource).
+		Body([]byte("{}")).
+		Do(ctx).
+		Error()
+}
+
+// subresourceClient returns a client of the KubeVirt subresources API acting
+// as the given user
+func (s *VMStarter) subresourceClient(username, resource, subresource string) (*rest.RESTClient, error) {
+	s.impersonatedMu.Lock()
+	cfg := s.restConfig
+	s.impersonatedMu.Unlock()
+	if cfg == nil {
+		return nil, fmt.Errorf("the %s/%s subresource requires EnableImpersonation", resource, subresource)
+	}
+
+	cfg = rest.CopyConfig(cfg)
+	if username != "" {
+		cfg.Impersonate = rest.ImpersonationConfig{UserName: username}
+	}
+	cfg.APIPath = "/apis"
+	cfg.GroupVersion = &schema.GroupVersion{Group: "subresources.kubevirt.io", Version: "v1"}
+	cfg.NegotiatedSerializer = clientgoscheme.Codecs.WithoutConversion()
+	return rest.RESTClientFor(cfg)
+}
+
+// LoggedInUsers returns the users logged in the guest of a running VM, as
+// reported by its guest agent
+func (s *VMStarter) LoggedInUsers(ctx context.Context, namespace, name string) (int, error) {
+	restClient, err := s.subresourceClient("", "virtualmachineinstances", "userlist")
+	if err != nil {
+		return 0, err
+	}
+	body, err := restClient.Get().
+		Namespace(namespace).
+		Resource("virtualmachineinstances").
+		Name(name).
+		SubResource("userlist").
+		Do(ctx).
+		Raw()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the users of VM %s/%s: %w", namespace, name, err)
+	}
+	users := &kubevirtv1.VirtualMachineInstanceGuestOSUserList{}
+	if err := json.Unmarshal(body, users); err != nil {
+		return 0, fmt.Errorf("invalid user list of VM %s/%s: %w", namespace, name, err)
+	}
+	return len(users.Items), nil
+}
+
+// vmCrashed returns true if the VM is in a crash loop or its instance failed
+func vmCrashed(ctx context.Context, c client.Client, namespace, name string) (bool, error) {
+	key := client.ObjectKey{Namespace: namespace, Name: name}
+	vm := &kubevirtv1.VirtualMachine{}
+	if err := c.Get(ctx, key, vm); err != nil {
+		return false, fmt.Errorf("failed to get VM %s/%s: %w", namespace, name, err)
+	}
+	if vm.Status.PrintableStatus == kubevirtv1.VirtualMachineStatusCrashLoopBackOff {
+		return true, nil
+	}
+
+	vmi := &kubevirtv1.VirtualMachineInstance{}
+	if err := c.Get(ctx, key, vmi); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get VMI %s/%s: %w", namespace, name, err)
+	}
+	return vmi.Status.Phase == kubevirtv1.Failed, nil
+}
+
+// vmiSettling returns why the instance cannot be woken right now (migrating or
+// terminating), empty if it can
+func vmiSettling(vmi *kubevirtv1.VirtualMachineInstance) string {
+	if vmi.DeletionTimestamp != nil {
+		return "terminating"
+	}
+	if m := vmi.Status.MigrationState; m != nil && !m.Completed && !m.Failed {
+		return "migrating"
+	}
+	return ""
+}
+
+// startVM wakes a VirtualMachine with the given client, like a real machine
+// resuming: a paused instance is unpaused, a migrating or terminating one
+// gets the wake queued until it settles, a stopped VM is started through the
+// virtualmachines/start subresource (KubeVirt applies its RunStrategy)
+func (s *VMStarter) startVM(ctx context.Context, c client.Client, username, namespace, name string) error {
+	key := client.ObjectKey{Namespace: namespace, Name: name}
+
+	vmi := &kubevirtv1.VirtualMachineInstance{}
+	if err := c.Get(ctx, key, vmi); err == nil {
+		if reason := vmiSettling(vmi); reason != "" {
+			s.queueWake(c, username, namespace, name, reason)
+			return nil
+		}
+		if vmiPaused(vmi) {
+			if err := s.putSubresource(ctx, username, namespace, "virtualmachineinstances", name, "unpause"); err != nil {
+				ErrorsTotal.Inc()
+				return fmt.Errorf("failed to unpause VM %s/%s: %w", namespace, name, err)
+			}
+			s.log.Info("Unpaused VM", "vm", name, "namespace", namespace)
+			return nil
+		}
+	} else if !apierrors.IsNotFound(err) {
+		ErrorsTotal.Inc()
+		return fmt.Errorf("failed to get VMI %s/%s: %w", namespace, name, err)
+	}
+
+	vm := &kubevirtv1.VirtualMachine{}
+	if err := c.Get(ctx, key, vm); err != nil {
+		ErrorsTotal.Inc()
+		return fmt.Errorf("failed to get VM %s/%s: %w", namespace, name, err)
+	}
+	if vm.Status.Ready ||
+		vm.Status.PrintableStatus == kubevirtv1.VirtualMachineStatusRunning ||
+		vm.Status.PrintableStatus == kubevirtv1.VirtualMachineStatusStarting {
 		s.log.Info("VM is already running", "vm", name, "namespace", namespace)
 		return nil
 	}
 
-	// Start the VM by setting Running to true (deprecated but still supported)
-	patch := client.MergeFrom(vm.DeepCopy())
-	running := true
-	vm.Spec.Running = &running
-
-	if err := s.client.Patch(ctx, vm, patch); err != nil {
+	if err := s.putSubresource(ctx, username, namespace, "virtualmachines", name, "start"); err != nil {
+		// KubeVirt rifiuta lo start di una VM che sta già partendo
+		if apierrors.IsConflict(err) {
+			s.log.Info("VM is already running", "vm", name, "namespace", namespace, "reason", err.Error())
+			return nil
+		}
 		ErrorsTotal.Inc()
 		return fmt.Errorf("failed to start VM %s/%s: %w", namespace, name, err)
 	}
-
-	s.log.Info("Successfully started VM via Running field", "vm", name, "namespace", namespace)
+	s.log.Info("Started VM", "vm", name, "namespace", namespace)
 	VMStartedTotal.Inc()
 	return nil
 }
 
-// restoreStrategyWhenRunning waits for VM to be running, then restores original RunStrategy
-func (s *VMStarter) restoreStrategyWhenRunning(ctx context.Context, namespace, name string, originalStrategy kubevirtv1.VirtualMachineRunStrategy) {
-	maxAttempts := 60 // 5 minutes max wait (5 seconds * 60)
-
-	for attempt := 0; attempt < maxAttempts; attempt++ {
-		time.Sleep(5 * time.Second)
+// queueWake wakes the VM once its instance is no longer migrating or
+// terminating. A VM has at most one queued wake.
+func (s *VMStarter) queueWake(c client.Client, username, namespace, name, reason string) {
+	key := vmIndexKey(namespace, name)
+	s.queuedMu.Lock()
+	defer s.queuedMu.Unlock()
+	if s.queuedCtx.Err() != nil {
+		s.log.Info("VM is "+reason+", shutting down: wake dropped", "vm", name, "namespace", namespace)
+		return
+	}
+	if s.queuedWakes[key] {
+		s.log.Info("Wake already queued for VM", "vm", name, "namespace", namespace, "reason", reason)
+		return
+	}
+	s.queuedWakes[key] = true
+	s.log.Info("VM is "+reason+", wake queued until it settles", "vm", name, "namespace", namespace)
+
+	s.queuedCount.Add(1)
+	s.queued.Add(1)
+	go func() {
+		defer s.queued.Done()
+		defer s.queuedCount.Add(-1)
+		s.startWhenSettled(s.queuedCtx, c, username, namespace, name)
+	}()
+}
 
-		vm := &kubevirtv1.VirtualMachine{}
-		key := client.ObjectKey{Namespace: namespace, Name: name}
+// startWhenSettled polls the instance of a VM with a queued wake and starts the
+// VM when it settles, giving up after settleTimeout or when ctx is done
+func (s *VMStarter) startWhenSettled(ctx context.Context, c client.Client, username, namespace, name string) {
+	key := client.ObjectKey{Namespace: namespace, Name: name}
+	timer := time.NewTimer(s.settleInterval)
+	defer timer.Stop()
+	for deadline := time.Now().Add(s.settleTimeout); time.Now().Before(deadline); timer.Reset(s.settleInterval) {
+		select {
+		case <-ctx.Done():
+			s.dequeueWake(namespace, name)
+			s.log.Info("Shutting down, dropping the queued wake", "vm", name, "namespace", namespace)
+			return
+		case <-timer.C:
+		}
 
-		if err := s.client.Get(ctx, key, vm); err != nil {
-			s.log.Error(err, "Failed to get VM for strategy restore", "vm", name, "namespace", namespace)
+		vmi := &kubevirtv1.VirtualMachineInstance{}
+		if err := c.Get(ctx, key, vmi); err != nil && !apierrors.IsNotFound(err) {
+			s.log.Error(err, "Failed to get VMI for queued wake", "vm", name, "namespace", namespace)
+			continue
+		} else if err == nil && vmiSettling(vmi) != "" {
+			continue
+		}
+		// Il membro di un VirtualMachinePool scalato può non essere ancora ricreato
+		if err := c.Get(ctx, key, &kubevirtv1.VirtualMachine{}); apierrors.IsNotFound(err) {
 			continue
 		}
 
-		// Check if VM is running
-		isRunning := vm.Status.Ready ||
-			(vm.Status.PrintableStatus != "" &&
-				vm.Status.PrintableStatus == kubevirtv1.VirtualMachineStatusRunning)
+		// La VM può tornare in migrazione: startVM rimette in coda il wake
+		s.dequeueWake(namespace, name)
+		if err := s.startVM(ctx, c, username, namespace, name); err != nil {
+			s.log.Error(err, "Failed to start VM for queued wake", "vm", name, "namespace", namespace)
+		}
+		return
+	}
 
-		if isRunning {
-			// VM is running, restore original strategy
-			patch := client.MergeFrom(vm.DeepCopy())
-			vm.Spec.RunStrategy = &originalStrategy
+	s.dequeueWake(namespace, name)
+	ErrorsTotal.Inc()
+	s.log.Info("Timeout waiting for VM to settle, dropping the queued wake", "vm", name, "namespace", namespace)
+}
 
-			if err := s.client.Patch(ctx, vm, patch); err != nil {
-				s.log.Error(err, "Failed to restore original RunStrategy", "vm", name, "namespace", namespace, "originalStrategy", originalStrategy)
-				return
-			}
+// dequeueWake forgets the queued wake of a VM
+func (s *VMStarter) dequeueWake(namespace, name string) {
+	s.queuedMu.Lock()
+	defer s.queuedMu.Unlock()
+	delete(s.queuedWakes, vmIndexKey(namespace, name))
+}
 
-			s.log.Info("Restored original RunStrategy after VM started", "vm", name, "namespace", namespace, "strategy", originalStrategy)
-			return
-		}
+// QueuedWakes returns the number of wakes queued until their VM settles
+func (s *VMStarter) QueuedWakes() int {
+	return int(s.queuedCount.Load())
+}
+
+// VMState returns the state of a VirtualMachine and the node of its instance,
+// read with the operator's identity
+func (s *VMStarter) VMState(ctx context.Context, namespace, name string) (string, string, error) {
+	key := client.ObjectKey{Namespace: namespace, Name: name}
+	vm := &kubevirtv1.VirtualMachine{}
+	if err := s.client.Get(ctx, key, vm); err != nil {
+		return "", "", fmt.Errorf("failed to get VM %s/%s: %w", namespace, name, err)
+	}
+	vmi := &kubevirtv1.VirtualMachineInstance{}
+	if err := s.client.Get(ctx, key, vmi); apierrors.IsNotFound(err) {
+		return vmState(vm, nil), "", nil
+	} else if err != nil {
+		return "", "", fmt.Errorf("failed to get VMI %s/%s: %w", namespace, name, err)
 	}
+	return vmState(vm, vmi), vmi.Status.NodeName, nil
+}
 
-	s.log.Info("Timeout waiting for VM to start, keeping Always strategy", "vm", name, "namespace", namespace)
+// vmState maps the status of a VM and of its instance (nil if none) to a state
+func vmState(vm *kubevirtv1.VirtualMachine, vmi *kubevirtv1.VirtualMachineInstance) string {
+	if vmi != nil && vmiPaused(vmi) {
+		return VMStatePaused
+	}
+	switch vm.Status.PrintableStatus {
+	case kubevirtv1.VirtualMachineStatusRunning:
+		return VMStateRunning
+	case kubevirtv1.VirtualMachineStatusPaused:
+		return VMStatePaused
+	case kubevirtv1.VirtualMachineStatusStarting, kubevirtv1.VirtualMachineStatusProvisioning,
+		kubevirtv1.VirtualMachineStatusWaitingForVolumeBinding:
+		return VMStateStarting
+	case kubevirtv1.VirtualMachineStatusStopped:
+		return VMStateStopped
+	case "":
+		// Status non ancora calcolato da KubeVirt: decide l'istanza
+		switch {
+		case vmi == nil:
+			return VMStateStopped
+		case vmi.Status.Phase == kubevirtv1.Running:
+			return VMStateRunning
+		case vmi.Status.Phase == kubevirtv1.Pending, vmi.Status.Phase == kubevirtv1.Scheduling,
+			vmi.Status.Phase == kubevirtv1.Scheduled:
+			return VMStateStarting
+		}
+		return string(vmi.Status.Phase)
+	}
+	return string(vm.Status.PrintableStatus)
 }
 
 // IsVMRunning checks if a VM is currently running
@@ -17,9 +17,20 @@ limitations under the License.
 package wol
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	kubevirtv1 "kubevirt.io/api/core/v1"
 )
 
 func TestNewVMStarter(t *testing.T) {
@@ -38,3 +49,283 @@ func TestNewVMStarter(t *testing.T) {
 		t.Error("Expected logger to be stored")
 	}
 }
+
+func TestVMStarter_StartVMAsRejectsForeignServiceAccount(t *testing.T) {
+	starter := NewVMStarter(nil, logr.Discard())
+
+	err := starter.StartVMAs(context.Background(), ServiceAccountUsername("kube-system", "admin"), "team-a", "vm1")
+	if err == nil || !strings.Contains(err.Error(), "namespace of the VM") {
+		t.Fatalf("Expected a namespace mismatch error, got %v", err)
+	}
+}
+
+func TestVMStarter_RetainImpersonation(t *testing.T) {
+	starter := NewVMStarter(nil, logr.Discard())
+	starter.EnableImpersonation(&rest.Config{Host: "https://127.0.0.1:6443"}, runtime.NewScheme())
+
+	kept := ServiceAccountUsername("team-a", "waker")
+	dropped := ServiceAccountUsername("team-b", "waker")
+	for _, user := range []string{kept, dropped} {
+		if _, err := starter.clientFor(user); err != nil {
+			t.Fatalf("Failed to create client for %s: %v", user, err)
+		}
+	}
+
+	starter.RetainImpersonation(map[string]bool{kept: true})
+	if _, ok := starter.impersonatedClient[kept]; !ok {
+		t.Error("Expected the client of a configured ServiceAccount to be kept")
+	}
+	if _, ok := starter.impersonatedClient[dropped]; ok {
+		t.Error("Expected the client of an unused ServiceAccount to be dropped")
+	}
+}
+
+func TestVMStarter_PolicyActions(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	paused := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "paused", Namespace: "tenant"}}
+	paused.Status.PrintableStatus = kubevirtv1.VirtualMachineStatusPaused
+	crashed := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "crashed", Namespace: "tenant"}}
+	crashed.Status.PrintableStatus = kubevirtv1.VirtualMachineStatusCrashLoopBackOff
+	c := newPolicyClient(t, paused, crashed)
+
+	starter := NewVMStarter(c, logr.Discard())
+	starter.EnableImpersonation(&rest.Config{Host: server.URL}, c.Scheme())
+	if err := starter.ResumeVMAs(context.Background(), "", "tenant", "paused"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := starter.RestartCrashedVMAs(context.Background(), "", "tenant", "crashed"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := []string{
+		"PUT /apis/subresources.kubevirt.io/v1/namespaces/tenant/virtualmachineinstances/paused/unpause",
+		"PUT /apis/subresources.kubevirt.io/v1/namespaces/tenant/virtualmachines/crashed/restart",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected %v, got %v", want, calls)
+	}
+}
+
+func TestVMStarter_WakePausedAndSettlingVMs(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	halted := kubevirtv1.RunStrategyHalted
+	always := kubevirtv1.RunStrategyAlways
+	pausedVM := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "paused", Namespace: "tenant"}}
+	pausedVM.Spec.RunStrategy = &always
+	pausedVMI := &kubevirtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{Name: "paused", Namespace: "tenant"}}
+	pausedVMI.Status.Conditions = []kubevirtv1.VirtualMachineInstanceCondition{
+		{Type: kubevirtv1.VirtualMachineInstancePaused, Status: corev1.ConditionTrue},
+	}
+	migratingVM := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "migrating", Namespace: "tenant"}}
+	migratingVM.Spec.RunStrategy = &halted
+	migratingVMI := &kubevirtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{Name: "migrating", Namespace: "tenant"}}
+	migratingVMI.Status.MigrationState = &kubevirtv1.VirtualMachineInstanceMigrationState{}
+	c := newPolicyClient(t, pausedVM, pausedVMI, migratingVM, migratingVMI)
+
+	starter := NewVMStarter(c, logr.Discard())
+	starter.EnableImpersonation(&rest.Config{Host: server.URL}, c.Scheme())
+	starter.settleInterval = 10 * time.Millisecond
+	ctx := context.Background()
+
+	// Una VMI in pausa viene ripresa, senza toccare la RunStrategy
+	if err := starter.StartVMAs(ctx, "", "tenant", "paused"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	mu.Lock()
+	if want := "PUT /apis/subresources.kubevirt.io/v1/namespaces/tenant/virtualmachineinstances/paused/unpause"; len(calls) != 1 || calls[0] != want {
+		t.Errorf("Expected %s, got %v", want, calls)
+	}
+	mu.Unlock()
+
+	// Durante la migrazione il wake resta in coda, una sola volta
+	for range 2 {
+		if err := starter.StartVMAs(ctx, "", "tenant", "migrating"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if starter.QueuedWakes() != 1 {
+		t.Errorf("Expected one queued wake, got %d", starter.QueuedWakes())
+	}
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	if len(calls) != 1 {
+		t.Errorf("Expected the VM untouched during the migration, got %v", calls)
+	}
+	mu.Unlock()
+
+	// A migrazione conclusa parte il wake in coda
+	migratingVMI.Status.MigrationState.Completed = true
+	if err := c.Update(ctx, migratingVMI); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for starter.QueuedWakes() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := "PUT /apis/subresources.kubevirt.io/v1/namespaces/tenant/virtualmachines/migrating/start"; len(calls) != 2 || calls[1] != want {
+		t.Errorf("Expected the queued wake to call %s, got %v", want, calls)
+	}
+}
+
+func TestVMStarter_RunDropsQueuedWakes(t *testing.T) {
+	migratingVM := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "migrating", Namespace: "tenant"}}
+	migratingVMI := &kubevirtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{Name: "migrating", Namespace: "tenant"}}
+	migratingVMI.Status.MigrationState = &kubevirtv1.VirtualMachineInstanceMigrationState{}
+	starter := NewVMStarter(newPolicyClient(t, migratingVM, migratingVMI), logr.Discard())
+	starter.settleInterval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		starter.Run(ctx)
+	}()
+	if err := starter.StartVMAs(context.Background(), "", "tenant", "migrating"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if starter.QueuedWakes() != 1 {
+		t.Fatalf("Expected one queued wake, got %d", starter.QueuedWakes())
+	}
+
+	// Allo spegnimento il wake in coda viene scartato senza aspettare l'intervallo
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Run to return once the queued wake is dropped")
+	}
+	if starter.QueuedWakes() != 0 {
+		t.Errorf("Expected no queued wake after shutdown, got %d", starter.QueuedWakes())
+	}
+	// I wake successivi non vengono più messi in coda
+	if err := starter.StartVMAs(context.Background(), "", "tenant", "migrating"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if starter.QueuedWakes() != 0 {
+		t.Errorf("Expected the wake after shutdown to be dropped, got %d queued", starter.QueuedWakes())
+	}
+}
+
+func TestVMStarter_StartSubresource(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(r.URL.Path, "/starting/") {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Conflict","code":409}`))
+			return
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	stopped := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "stopped", Namespace: "tenant"}}
+	running := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "tenant"}}
+	running.Status.PrintableStatus = kubevirtv1.VirtualMachineStatusRunning
+	starting := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "starting", Namespace: "tenant"}}
+	c := newPolicyClient(t, stopped, running, starting)
+
+	starter := NewVMStarter(c, logr.Discard())
+	starter.EnableImpersonation(&rest.Config{Host: server.URL}, c.Scheme())
+	for _, name := range []string{"stopped", "running", "starting"} {
+		if err := starter.StartVM(context.Background(), "tenant", name); err != nil {
+			t.Errorf("Unexpected error starting %s: %v", name, err)
+		}
+	}
+
+	want := []string{
+		"/apis/subresources.kubevirt.io/v1/namespaces/tenant/virtualmachines/stopped/start",
+		"/apis/subresources.kubevirt.io/v1/namespaces/tenant/virtualmachines/starting/start",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected %v, got %v", want, calls)
+	}
+}
+
+func TestVMState(t *testing.T) {
+	pausedVMI := &kubevirtv1.VirtualMachineInstance{}
+	pausedVMI.Status.Phase = kubevirtv1.Running
+	pausedVMI.Status.Conditions = []kubevirtv1.VirtualMachineInstanceCondition{
+		{Type: kubevirtv1.VirtualMachineInstancePaused, Status: corev1.ConditionTrue},
+	}
+	runningVMI := &kubevirtv1.VirtualMachineInstance{}
+	runningVMI.Status.Phase = kubevirtv1.Running
+	scheduledVMI := &kubevirtv1.VirtualMachineInstance{}
+	scheduledVMI.Status.Phase = kubevirtv1.Scheduled
+
+	tests := []struct {
+		name      string
+		printable kubevirtv1.VirtualMachinePrintableStatus
+		vmi       *kubevirtv1.VirtualMachineInstance
+		want      string
+	}{
+		{"running", kubevirtv1.VirtualMachineStatusRunning, runningVMI, VMStateRunning},
+		{"paused instance", kubevirtv1.VirtualMachineStatusRunning, pausedVMI, VMStatePaused},
+		{"provisioning", kubevirtv1.VirtualMachineStatusProvisioning, nil, VMStateStarting},
+		{"stopped", kubevirtv1.VirtualMachineStatusStopped, nil, VMStateStopped},
+		{"no status, no instance", "", nil, VMStateStopped},
+		{"no status, running instance", "", runningVMI, VMStateRunning},
+		{"no status, scheduled instance", "", scheduledVMI, VMStateStarting},
+		{"migrating", kubevirtv1.VirtualMachineStatusMigrating, runningVMI, "Migrating"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := &kubevirtv1.VirtualMachine{}
+			vm.Status.PrintableStatus = tt.printable
+			if got := vmState(vm, tt.vmi); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestVMStarter_VMState(t *testing.T) {
+	vm := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "vm1", Namespace: "tenant"}}
+	vm.Status.PrintableStatus = kubevirtv1.VirtualMachineStatusRunning
+	vmi := &kubevirtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{Name: "vm1", Namespace: "tenant"}}
+	vmi.Status.NodeName = "worker-1"
+	stopped := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: "vm2", Namespace: "tenant"}}
+	starter := NewVMStarter(newPolicyClient(t, vm, vmi, stopped), logr.Discard())
+
+	state, node, err := starter.VMState(context.Background(), "tenant", "vm1")
+	if err != nil || state != VMStateRunning || node != "worker-1" {
+		t.Errorf("Expected Running on worker-1, got %q %q %v", state, node, err)
+	}
+	state, node, err = starter.VMState(context.Background(), "tenant", "vm2")
+	if err != nil || state != VMStateStopped || node != "" {
+		t.Errorf("Expected Stopped without a node, got %q %q %v", state, node, err)
+	}
+	if _, _, err := starter.VMState(context.Background(), "tenant", "missing"); err == nil {
+		t.Error("Expected an error for a missing VM")
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// unknownNodeLabel is the node label of events whose node is not a cluster node
+const unknownNodeLabel = "unknown"
+
+// observeEventLatency records on the manager how long the event waited on the
+// agent and how long it took to reach the manager. node is the validated node label.
+// The transit compares the agent and manager clocks, so it is only meaningful
+// when the nodes are NTP-synchronized.
+func observeEventLatency(event *wolv1.WOLEvent, node string, receivedAt time.Time) {
+	if event.AgentDelayUs == 0 || event.Timestamp == nil {
+		return // agent senza timing
+	}
+	agentDelay := time.Duration(event.AgentDelayUs) * time.Microsecond
+	AgentDelaySeconds.WithLabelValues(node).Observe(agentDelay.Seconds())
+
+	// Con orologi non sincronizzati il transito può risultare negativo: scartalo
+	if transit := receivedAt.Sub(event.Timestamp.AsTime().Add(agentDelay)); transit >= 0 {
+		EventTransitSeconds.WithLabelValues(node).Observe(transit.Seconds())
+	}
+}
+
+// SetNodeReader sets the reader (usually the manager cache) used to check that
+// the node names reported by the agents are nodes of the cluster
+func (a *Aggregator) SetNodeReader(r client.Reader) {
+	a.nodes = r
+}
+
+// nodeLabel returns the node name reported by an agent if it is a node of the
+// cluster, unknownNodeLabel otherwise. The name comes from an unauthenticated
+// client: used as is, it would let anyone create unbounded metric series.
+func (a *Aggregator) nodeLabel(ctx context.Context, name string) string {
+	if a.nodes == nil || name == "" {
+		return unknownNodeLabel
+	}
+	if err := a.nodes.Get(ctx, client.ObjectKey{Name: name}, &corev1.Node{}); err != nil {
+		return unknownNodeLabel
+	}
+	return name
+}
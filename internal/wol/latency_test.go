@@ -0,0 +1,72 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestObserveEventLatency(t *testing.T) {
+	now := time.Now()
+	node := "latency-test-node"
+
+	// Agent senza timing: nessuna osservazione
+	observeEventLatency(&wolv1.WOLEvent{NodeName: node, Timestamp: timestamppb.New(now)}, node, now)
+	if got := testutil.CollectAndCount(AgentDelaySeconds, "wol_agent_delay_seconds"); got != 0 {
+		t.Fatalf("Expected no observation without agent delay, got %d series", got)
+	}
+
+	observeEventLatency(&wolv1.WOLEvent{
+		NodeName:     node,
+		Timestamp:    timestamppb.New(now.Add(-30 * time.Millisecond)),
+		AgentDelayUs: 10000,
+	}, node, now)
+	if got := testutil.CollectAndCount(AgentDelaySeconds, "wol_agent_delay_seconds"); got != 1 {
+		t.Errorf("Expected an agent delay series, got %d", got)
+	}
+	if got := testutil.CollectAndCount(EventTransitSeconds, "wol_event_transit_seconds"); got != 1 {
+		t.Errorf("Expected a transit series, got %d", got)
+	}
+}
+
+func TestAggregator_NodeLabel(t *testing.T) {
+	node := &corev1.Node{}
+	node.Name = "worker-1"
+	agg := NewAggregator(NewMACMapper(nil, logr.Discard()), NewVMStarter(nil, logr.Discard()), logr.Discard())
+
+	if got := agg.nodeLabel(context.Background(), "worker-1"); got != unknownNodeLabel {
+		t.Errorf("Expected %q without a node reader, got %q", unknownNodeLabel, got)
+	}
+
+	agg.SetNodeReader(fake.NewClientBuilder().WithObjects(node).Build())
+	if got := agg.nodeLabel(context.Background(), "worker-1"); got != "worker-1" {
+		t.Errorf("Expected the known node, got %q", got)
+	}
+	if got := agg.nodeLabel(context.Background(), "attacker-chosen-name"); got != unknownNodeLabel {
+		t.Errorf("Expected %q for a node that does not exist, got %q", unknownNodeLabel, got)
+	}
+}
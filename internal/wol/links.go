@@ -0,0 +1,214 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// linkSettleDelay: i cambi di link sono applicati dopo un secondo senza
+	// altri cambi (le CNI creano, rinominano e attivano i link in più passi)
+	linkSettleDelay = time.Second
+	// linkMaxDelay: con cambi continui (es. veth dei pod) si applicano comunque
+	linkMaxDelay = 10 * time.Second
+)
+
+// linkChange is a link added, changed or removed on the node
+type linkChange struct {
+	Name    string
+	Index   int
+	Up      bool
+	Removed bool
+}
+
+// linkMonitor receives the RTM_NEWLINK and RTM_DELLINK notifications of the
+// kernel on a NETLINK_ROUTE socket
+type linkMonitor struct {
+	fd int
+}
+
+// openLinkMonitor subscribes to the link notifications. Reads return EAGAIN
+// after timeout, so the reader can check for shutdown.
+func openLinkMonitor(timeout time.Duration) (*linkMonitor, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create netlink socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: unix.RTMGRP_LINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to subscribe to link notifications: %w", err)
+	}
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to set SO_RCVTIMEO: %w", err)
+	}
+	return &linkMonitor{fd: fd}, nil
+}
+
+// read returns the link changes of the next notifications
+func (m *linkMonitor) read(buf []byte) ([]linkChange, error) {
+	n, _, err := unix.Recvfrom(m.fd, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	return parseLinkChanges(buf[:n]), nil
+}
+
+func (m *linkMonitor) close() {
+	unix.Close(m.fd)
+}
+
+// parseLinkChanges parses the RTM_NEWLINK and RTM_DELLINK messages of a
+// netlink datagram, ignoring the others
+func parseLinkChanges(b []byte) []linkChange {
+	var changes []linkChange
+	for len(b) >= unix.SizeofNlMsghdr {
+		// nlmsghdr: len, type, flags, seq, pid
+		msgLen := int(binary.NativeEndian.Uint32(b[0:4]))
+		msgType := binary.NativeEndian.Uint16(b[4:6])
+		if msgLen < unix.SizeofNlMsghdr || msgLen > len(b) {
+			break
+		}
+		if (msgType == unix.RTM_NEWLINK || msgType == unix.RTM_DELLINK) &&
+			msgLen >= unix.SizeofNlMsghdr+unix.SizeofIfInfomsg {
+			// ifinfomsg: family, pad, type, index, flags, change
+			info := b[unix.SizeofNlMsghdr:msgLen]
+			change := linkChange{
+				Index:   int(int32(binary.NativeEndian.Uint32(info[4:8]))),
+				Up:      binary.NativeEndian.Uint32(info[8:12])&unix.IFF_UP != 0,
+				Removed: msgType == unix.RTM_DELLINK,
+			}
+			attrs := info[unix.SizeofIfInfomsg:]
+			for len(attrs) >= unix.SizeofRtAttr {
+				attrLen := int(binary.NativeEndian.Uint16(attrs[0:2]))
+				if attrLen < unix.SizeofRtAttr || attrLen > len(attrs) {
+					break
+				}
+				if binary.NativeEndian.Uint16(attrs[2:4]) == unix.IFLA_IFNAME {
+					change.Name = string(bytes.TrimRight(attrs[unix.SizeofRtAttr:attrLen], "\x00"))
+				}
+				attrs = attrs[min(netlinkAlign(attrLen), len(attrs)):]
+			}
+			changes = append(changes, change)
+		}
+		b = b[min(netlinkAlign(msgLen), len(b)):]
+	}
+	return changes
+}
+
+// netlinkAlign rounds a netlink message or attribute length up to 4 bytes
+func netlinkAlign(n int) int {
+	return (n + 3) &^ 3
+}
+
+// SetWatchLinks enables or disables following the interfaces added to and
+// removed from the node. Must be called before Start.
+func (a *Agent) SetWatchLinks(enable bool) {
+	a.watchLinks = enable
+}
+
+// followLinks starts and stops the raw listeners as the interfaces of the
+// node come and go (SR-IOV VFs, bridges created by CNI plugins), once their
+// changes settle
+func (a *Agent) followLinks(ctx context.Context, monitor *linkMonitor) {
+	defer a.wg.Done()
+	defer monitor.close()
+	buf := make([]byte, 64*1024)
+	var first, last time.Time // primo e ultimo cambio non ancora applicati
+	var added []string
+	var reload bool
+
+	for ctx.Err() == nil {
+		changes, err := monitor.read(buf)
+		now := time.Now()
+		switch {
+		case err == nil:
+			for _, change := range changes {
+				a.log.V(1).Info("Link changed", "iface", change.Name, "index", change.Index,
+					"up", change.Up, "removed", change.Removed)
+				if !change.Removed && change.Name != "" && !slices.Contains(added, change.Name) {
+					added = append(added, change.Name)
+				}
+			}
+			if len(changes) > 0 {
+				if first.IsZero() {
+					first = now
+				}
+				last = now
+			}
+		case errors.Is(err, unix.ENOBUFS):
+			// Notifiche perse: le interfacce sono rilette comunque
+			a.log.Info("Link notifications lost, reloading the interfaces")
+			if first.IsZero() {
+				first = now
+			}
+			last, reload = now, true
+		case errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR):
+		default:
+			if ctx.Err() == nil {
+				a.log.Error(err, "Failed to read link notifications, interface hotplug disabled")
+			}
+			return
+		}
+
+		if !first.IsZero() && (now.Sub(last) >= linkSettleDelay || now.Sub(first) >= linkMaxDelay) {
+			a.refreshLinks(ctx, added, reload)
+			first, last, added, reload = time.Time{}, time.Time{}, nil, false
+		}
+	}
+}
+
+// refreshLinks drops the raw listeners of the interfaces removed (or
+// recreated) since they started, starts the new candidates and, when a
+// hinted interface was added (or notifications were lost), applies the hints
+// again
+func (a *Agent) refreshLinks(ctx context.Context, added []string, reload bool) {
+	a.rawMu.Lock()
+	listeners := slices.Clone(a.rawListeners)
+	a.rawMu.Unlock()
+	for _, l := range listeners {
+		if iface, err := net.InterfaceByName(l.interfaceName); err == nil && iface.Index == l.ifIndex {
+			continue
+		}
+		a.log.Info("Interface removed, stopping its raw listener", "iface", l.interfaceName)
+		a.removeRawListener(l)
+		a.watchdog.forget(componentRawPrefix + l.interfaceName)
+		a.rawMu.Lock()
+		delete(a.hintedIfaces, l.interfaceName)
+		a.rawMu.Unlock()
+	}
+
+	a.refreshCandidateListeners(ctx)
+
+	a.rawMu.Lock()
+	hinted := slices.ContainsFunc(added, func(name string) bool { return slices.Contains(a.hintNames, name) })
+	a.rawMu.Unlock()
+	if hinted || reload {
+		a.applyInterfaceHints(ctx)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/sys/unix"
+)
+
+// linkMessage builds a netlink link notification of an interface
+func linkMessage(msgType uint16, index int32, flags uint32, name string) []byte {
+	attr := binary.NativeEndian.AppendUint16(nil, uint16(unix.SizeofRtAttr+len(name)+1))
+	attr = binary.NativeEndian.AppendUint16(attr, unix.IFLA_IFNAME)
+	attr = append(append(attr, name...), 0)
+	for len(attr)%4 != 0 {
+		attr = append(attr, 0)
+	}
+
+	info := []byte{unix.AF_UNSPEC, 0}
+	info = binary.NativeEndian.AppendUint16(info, 1) // ARPHRD_ETHER
+	info = binary.NativeEndian.AppendUint32(info, uint32(index))
+	info = binary.NativeEndian.AppendUint32(info, flags)
+	info = binary.NativeEndian.AppendUint32(info, 0xffffffff)
+	// Un attributo prima del nome, da saltare
+	info = append(info, binary.NativeEndian.AppendUint16(nil, 8)...)
+	info = append(binary.NativeEndian.AppendUint16(info, unix.IFLA_MTU), 0xdc, 0x05, 0, 0)
+	info = append(info, attr...)
+
+	msg := binary.NativeEndian.AppendUint32(nil, uint32(unix.SizeofNlMsghdr+len(info)))
+	msg = binary.NativeEndian.AppendUint16(msg, msgType)
+	msg = append(msg, make([]byte, 10)...)
+	return append(msg, info...)
+}
+
+func TestParseLinkChanges(t *testing.T) {
+	datagram := linkMessage(unix.RTM_NEWLINK, 7, unix.IFF_UP|unix.IFF_BROADCAST, "ens1f0v3")
+	datagram = append(datagram, linkMessage(unix.RTM_NEWADDR, 7, 0, "ignored")...)
+	datagram = append(datagram, linkMessage(unix.RTM_DELLINK, 8, 0, "br-wol")...)
+
+	changes := parseLinkChanges(datagram)
+	want := []linkChange{
+		{Name: "ens1f0v3", Index: 7, Up: true},
+		{Name: "br-wol", Index: 8, Removed: true},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Expected %d changes, got %+v", len(want), changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("Expected %+v, got %+v", want[i], changes[i])
+		}
+	}
+
+	// Un messaggio troncato è scartato
+	if changes := parseLinkChanges(datagram[:len(datagram)-4]); len(changes) != 1 {
+		t.Errorf("Expected the truncated message to be dropped, got %+v", changes)
+	}
+}
+
+func TestAgent_RefreshLinks(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("No loopback interface: %v", err)
+	}
+	agent := NewAgent(0, "test-node", "", logr.Discard())
+	gone := NewRawListener("gone0", nil, logr.Discard())
+	recreated := NewRawListener("lo", nil, logr.Discard())
+	recreated.ifIndex = lo.Index + 1000
+	current := NewRawListener("lo", nil, logr.Discard())
+	current.ifIndex = lo.Index
+	agent.rawListeners = []*RawListener{gone, recreated, current}
+	agent.hintedIfaces = map[string]bool{"gone0": true}
+
+	agent.refreshLinks(context.Background(), []string{"eth5"}, false)
+
+	if len(agent.rawListeners) != 1 || agent.rawListeners[0] != current {
+		t.Errorf("Expected only the listener of the current interface, got %d listeners", len(agent.rawListeners))
+	}
+	if agent.hintedIfaces["gone0"] {
+		t.Error("Expected the removed interface to be no longer hinted")
+	}
+}
@@ -0,0 +1,321 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+const (
+	// ListenerProtocolUDP is the protocol of the UDP listener bindings
+	ListenerProtocolUDP = "udp"
+	// ListenerProtocolUDP6 is the protocol of the IPv6 UDP listener bindings
+	ListenerProtocolUDP6 = "udp6"
+	// ListenerProtocolRaw is the protocol of the raw Ethernet listener bindings
+	ListenerProtocolRaw = "raw"
+
+	// listenerReportInterval is how often agents report their listeners
+	listenerReportInterval = 60 * time.Second
+	// listenerReportTTL is how long a report is kept without a refresh
+	// (e.g. the agent pod was deleted)
+	listenerReportTTL = 3 * listenerReportInterval
+)
+
+// NodeListeners is the last listener report of the agent on a node
+type NodeListeners struct {
+	NodeName  string
+	WolConfig string
+	// Relay is true for the report of an external relay (NodeName is the relay name)
+	Relay      bool
+	UDPPorts   []int
+	UDP6Ports  []int
+	Interfaces []string
+	// Errors are the listeners that could not be started, e.g. "udp/9: address already in use"
+	Errors     []string
+	ReportedAt time.Time
+}
+
+// Equal compares two reports ignoring when they were received
+func (n NodeListeners) Equal(other NodeListeners) bool {
+	return n.NodeName == other.NodeName && n.WolConfig == other.WolConfig && n.Relay == other.Relay &&
+		slices.Equal(n.UDPPorts, other.UDPPorts) && slices.Equal(n.UDP6Ports, other.UDP6Ports) &&
+		slices.Equal(n.Interfaces, other.Interfaces) &&
+		slices.Equal(n.Errors, other.Errors)
+}
+
+// ---------------------------------------------------------------------------
+// Manager side: collecting the reports
+// ---------------------------------------------------------------------------
+
+// ReportListeners implementa il metodo gRPC con cui gli agent comunicano i propri listener
+// (per i relay il report vale anche come registrazione)
+func (a *Aggregator) ReportListeners(ctx context.Context, req *wolv1.ListenerReport) (*wolv1.ListenerReportResponse, error) {
+	report := listenersFromReport(req, time.Now())
+	key := req.WolConfig + "/" + req.NodeName
+	if relay, ok := relayFromContext(ctx); ok {
+		report.NodeName = relay.Name
+		report.WolConfig = relay.WolConfig
+		report.Relay = true
+		key = relay.WolConfig + "/relay/" + relay.Name
+	}
+
+	a.listenersMu.Lock()
+	if a.listeners == nil {
+		a.listeners = make(map[string]NodeListeners)
+	}
+	previous, found := a.listeners[key]
+	a.listeners[key] = report
+	a.listenersMu.Unlock()
+
+	if found && previous.Equal(report) {
+		return &wolv1.ListenerReportResponse{Accepted: true}, nil
+	}
+	if report.Relay && !found {
+		a.log.Info("Relay registered", "relay", report.NodeName, "wolconfig", report.WolConfig,
+			"udpPorts", report.UDPPorts, "interfaces", report.Interfaces)
+	}
+	if len(report.Errors) > 0 {
+		a.log.Info("Agent failed to start some listeners",
+			"node", report.NodeName, "wolconfig", report.WolConfig, "errors", report.Errors)
+	}
+	if a.listenersNotify != nil {
+		a.listenersNotify()
+	}
+	return &wolv1.ListenerReportResponse{Accepted: true}, nil
+}
+
+// OnListenersChange registers a callback invoked when the listeners reported
+// by an agent change. Must be called before the gRPC server starts.
+func (a *Aggregator) OnListenersChange(fn func()) {
+	a.listenersNotify = fn
+}
+
+// Listeners returns the listener reports of the agents of the given WolConfig
+// (reports without a WolConfig, and those of the shared agents serving it,
+// are included), the ones with failures first and the node agents before the
+// relays.
+// Reports not refreshed within listenerReportTTL are dropped.
+func (a *Aggregator) Listeners(configName string) []NodeListeners {
+	now := time.Now()
+	agentConfig := a.mapper.agentConfigName(configName)
+
+	a.listenersMu.Lock()
+	defer a.listenersMu.Unlock()
+
+	var result []NodeListeners
+	for key, report := range a.listeners {
+		if now.Sub(report.ReportedAt) > listenerReportTTL {
+			delete(a.listeners, key)
+			continue
+		}
+		if report.WolConfig == "" || report.WolConfig == configName || (!report.Relay && report.WolConfig == agentConfig) {
+			result = append(result, report)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		failedI, failedJ := len(result[i].Errors) > 0, len(result[j].Errors) > 0
+		if failedI != failedJ {
+			return failedI
+		}
+		if result[i].Relay != result[j].Relay {
+			return !result[i].Relay
+		}
+		return result[i].NodeName < result[j].NodeName
+	})
+	return result
+}
+
+// listenersFromReport converts a gRPC report, sorting its bindings
+func listenersFromReport(req *wolv1.ListenerReport, now time.Time) NodeListeners {
+	report := NodeListeners{
+		NodeName:   req.NodeName,
+		WolConfig:  req.WolConfig,
+		ReportedAt: now,
+	}
+	for _, binding := range req.Bindings {
+		switch {
+		case !binding.Bound:
+			report.Errors = append(report.Errors, bindingName(binding)+": "+binding.Error)
+		case binding.Protocol == ListenerProtocolUDP:
+			report.UDPPorts = append(report.UDPPorts, int(binding.Port))
+		case binding.Protocol == ListenerProtocolUDP6:
+			report.UDP6Ports = append(report.UDP6Ports, int(binding.Port))
+		case binding.Protocol == ListenerProtocolRaw:
+			report.Interfaces = append(report.Interfaces, binding.Interface)
+		}
+	}
+	slices.Sort(report.UDPPorts)
+	slices.Sort(report.UDP6Ports)
+	slices.Sort(report.Interfaces)
+	slices.Sort(report.Errors)
+	return report
+}
+
+// bindingName identifies a binding, e.g. "udp/9", "udp6/9" or "raw/eth0"
+func bindingName(binding *wolv1.ListenerBinding) string {
+	if binding.Protocol == ListenerProtocolUDP || binding.Protocol == ListenerProtocolUDP6 {
+		return binding.Protocol + "/" + strconv.Itoa(int(binding.Port))
+	}
+	return binding.Protocol + "/" + binding.Interface
+}
+
+// ---------------------------------------------------------------------------
+// Agent side: reporting the bindings
+// ---------------------------------------------------------------------------
+
+// bindError is a listener that could not be started
+type bindError struct {
+	binding *wolv1.ListenerBinding
+	err     string
+}
+
+// setBindError records the outcome of the last attempt to start a listener
+// (a nil err clears the previous failure)
+func (a *Agent) setBindError(binding *wolv1.ListenerBinding, err error) {
+	a.bindMu.Lock()
+	defer a.bindMu.Unlock()
+	name := bindingName(binding)
+	if err == nil {
+		delete(a.bindErrors, name)
+		return
+	}
+	if a.bindErrors == nil {
+		a.bindErrors = make(map[string]bindError)
+	}
+	a.bindErrors[name] = bindError{binding: binding, err: err.Error()}
+}
+
+// listenerBindings returns the running listeners and the ones that failed to start.
+// Failures on interfaces that no longer exist are not reported.
+func (a *Agent) listenerBindings() []*wolv1.ListenerBinding {
+	var bindings []*wolv1.ListenerBinding
+
+	a.udpMu.Lock()
+	udpBound, udp6Bound := a.conn != nil, a.conn6 != nil
+	a.udpMu.Unlock()
+	if udpBound {
+		bindings = append(bindings, &wolv1.ListenerBinding{
+			Protocol: ListenerProtocolUDP,
+			Port:     uint32(a.udpPort()),
+			Bound:    true,
+		})
+	}
+	if udp6Bound {
+		bindings = append(bindings, &wolv1.ListenerBinding{
+			Protocol: ListenerProtocolUDP6,
+			Port:     uint32(a.udpPort()),
+			Bound:    true,
+		})
+	}
+
+	running := make(map[string]bool)
+	a.rawMu.Lock()
+	for _, l := range a.rawListeners {
+		running[l.interfaceName] = true
+		bindings = append(bindings, &wolv1.ListenerBinding{
+			Protocol:  ListenerProtocolRaw,
+			Interface: l.interfaceName,
+			Bound:     true,
+		})
+	}
+	a.rawMu.Unlock()
+
+	a.bindMu.Lock()
+	for _, failed := range a.bindErrors {
+		switch failed.binding.Protocol {
+		case ListenerProtocolUDP:
+			if udpBound {
+				continue
+			}
+		case ListenerProtocolUDP6:
+			if udp6Bound {
+				continue
+			}
+		case ListenerProtocolRaw:
+			if running[failed.binding.Interface] {
+				continue
+			}
+			if _, err := net.InterfaceByName(failed.binding.Interface); err != nil {
+				continue
+			}
+		}
+		bindings = append(bindings, &wolv1.ListenerBinding{
+			Protocol:  failed.binding.Protocol,
+			Port:      failed.binding.Port,
+			Interface: failed.binding.Interface,
+			Error:     failed.err,
+		})
+	}
+	a.bindMu.Unlock()
+
+	sort.Slice(bindings, func(i, j int) bool { return bindingName(bindings[i]) < bindingName(bindings[j]) })
+	return bindings
+}
+
+// syncListenerReport periodically reports the listener bindings to the operator
+func (a *Agent) syncListenerReport(ctx context.Context) {
+	defer a.wg.Done()
+	ticker := time.NewTicker(listenerReportInterval)
+	defer ticker.Stop()
+
+	for {
+		a.reportListeners(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Agent) reportListeners(ctx context.Context) {
+	reqCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	bindings := a.listenerBindings()
+	if _, err := a.grpcClient.ReportListeners(reqCtx, &wolv1.ListenerReport{
+		NodeName:  a.nodeName,
+		WolConfig: a.wolConfigName,
+		Bindings:  bindings,
+	}); err != nil {
+		if ctx.Err() == nil {
+			a.log.V(1).Info("Failed to report listeners to operator", "error", err.Error())
+		}
+		return
+	}
+
+	var failed []string
+	for _, binding := range bindings {
+		if !binding.Bound {
+			failed = append(failed, fmt.Sprintf("%s: %s", bindingName(binding), binding.Error))
+		}
+	}
+	if len(failed) > 0 {
+		a.log.V(1).Info("Reported listener failures to operator", "failures", strings.Join(failed, "; "))
+	}
+}
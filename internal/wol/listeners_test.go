@@ -0,0 +1,117 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestAggregator_ReportListeners(t *testing.T) {
+	agg := NewAggregator(NewMACMapper(nil, logr.Discard()), NewVMStarter(nil, logr.Discard()), logr.Discard())
+	notified := 0
+	agg.OnListenersChange(func() { notified++ })
+
+	report := func(node, config string, bindings ...*wolv1.ListenerBinding) {
+		t.Helper()
+		resp, err := agg.ReportListeners(context.Background(), &wolv1.ListenerReport{
+			NodeName: node, WolConfig: config, Bindings: bindings,
+		})
+		if err != nil || !resp.Accepted {
+			t.Fatalf("Expected the report to be accepted, got %v, %v", resp, err)
+		}
+	}
+	udp := &wolv1.ListenerBinding{Protocol: ListenerProtocolUDP, Port: 9, Bound: true}
+	eth0 := &wolv1.ListenerBinding{Protocol: ListenerProtocolRaw, Interface: "eth0", Bound: true}
+	blocked := &wolv1.ListenerBinding{Protocol: ListenerProtocolUDP, Port: 9, Error: "address already in use"}
+
+	report("node-a", "cfg", eth0, udp)
+	report("node-b", "cfg", blocked, eth0)
+	report("node-c", "other", udp)
+	if notified != 3 {
+		t.Fatalf("Expected a notification per new report, got %d", notified)
+	}
+
+	report("node-a", "cfg", udp, eth0)
+	if notified != 3 {
+		t.Errorf("Expected no notification for an unchanged report, got %d", notified)
+	}
+
+	listeners := agg.Listeners("cfg")
+	if len(listeners) != 2 {
+		t.Fatalf("Expected the reports of cfg only, got %+v", listeners)
+	}
+	if listeners[0].NodeName != "node-b" || len(listeners[0].Errors) != 1 ||
+		listeners[0].Errors[0] != "udp/9: address already in use" {
+		t.Errorf("Expected the failing node first, got %+v", listeners[0])
+	}
+	if got := listeners[1]; got.NodeName != "node-a" || len(got.UDPPorts) != 1 || got.UDPPorts[0] != 9 ||
+		len(got.Interfaces) != 1 || got.Interfaces[0] != "eth0" {
+		t.Errorf("Unexpected listeners for node-a: %+v", got)
+	}
+
+	// I report non aggiornati (agent rimosso) scadono
+	agg.listenersMu.Lock()
+	stale := agg.listeners["cfg/node-a"]
+	stale.ReportedAt = time.Now().Add(-listenerReportTTL - time.Second)
+	agg.listeners["cfg/node-a"] = stale
+	agg.listenersMu.Unlock()
+	if listeners := agg.Listeners("cfg"); len(listeners) != 1 || listeners[0].NodeName != "node-b" {
+		t.Errorf("Expected the stale report to be dropped, got %+v", listeners)
+	}
+}
+
+func TestAgent_ListenerBindingsReportsBlockedPort(t *testing.T) {
+	blocker, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := blocker.LocalAddr().(*net.UDPAddr).Port
+
+	agent := NewAgent(port, "test-node", "", logr.Discard())
+	if _, err := agent.openUDP(); err == nil {
+		t.Fatal("Expected the bind to fail while the port is taken")
+	}
+
+	bindings := agent.listenerBindings()
+	if len(bindings) != 1 || bindings[0].Bound || bindings[0].Port != uint32(port) ||
+		!strings.Contains(bindings[0].Error, "address already in use") {
+		t.Fatalf("Expected the blocked UDP port to be reported, got %v", bindings)
+	}
+
+	if err := blocker.Close(); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := agent.openUDP()
+	if err != nil {
+		t.Fatalf("Failed to open UDP socket: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	agent.conn = conn
+
+	bindings = agent.listenerBindings()
+	if len(bindings) != 1 || !bindings[0].Bound {
+		t.Errorf("Expected the UDP port to be reported as bound, got %v", bindings)
+	}
+}
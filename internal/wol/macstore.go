@@ -0,0 +1,170 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// macStoreShards is the number of independent shards in a macStore.
+// Must be a power of two so the shard index can be computed with a mask.
+const macStoreShards = 64
+
+// macKey is the compact binary form of a MAC address used as map key.
+// It avoids keeping a 17-byte string (plus header) per entry on large fleets.
+type macKey [6]byte
+
+// String returns the canonical lowercase colon-separated representation
+func (k macKey) String() string {
+	return fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", k[0], k[1], k[2], k[3], k[4], k[5])
+}
+
+// parseMACKey converts a MAC address string to its compact key.
+// Accepts any format supported by net.ParseMAC as long as it is 6 bytes long.
+func parseMACKey(mac string) (macKey, bool) {
+	var key macKey
+	hw, err := net.ParseMAC(normalizeMACAddress(mac))
+	if err != nil || len(hw) != 6 {
+		return key, false
+	}
+	copy(key[:], hw)
+	return key, true
+}
+
+type macStoreShard struct {
+	mu      sync.RWMutex
+	entries map[macKey]VMInfo
+}
+
+// macStore is a sharded MAC -> VMInfo map.
+// Sharding keeps lock contention low when lookups from many agents
+// run concurrently with incremental updates, and the binary key keeps
+// the per-entry footprint small for 50k+ VM fleets.
+type macStore struct {
+	shards [macStoreShards]macStoreShard
+	size   atomic.Int64
+}
+
+// newMACStore creates an empty store
+func newMACStore() *macStore {
+	s := &macStore{}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[macKey]VMInfo)
+	}
+	return s
+}
+
+// shard returns the shard responsible for the given key.
+// The last byte is the most random part of a MAC (the OUI prefix is shared
+// by all VMs of the same vendor), so it is used to spread the keys.
+func (s *macStore) shard(key macKey) *macStoreShard {
+	return &s.shards[int(key[5]^key[4])&(macStoreShards-1)]
+}
+
+// Get returns the VM info stored for the key
+func (s *macStore) Get(key macKey) (VMInfo, bool) {
+	sh := s.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	info, ok := sh.entries[key]
+	return info, ok
+}
+
+// Set stores the VM info for the key, returning true if the key was new
+func (s *macStore) Set(key macKey, info VMInfo) bool {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	_, exists := sh.entries[key]
+	sh.entries[key] = info
+	if !exists {
+		s.size.Add(1)
+	}
+	return !exists
+}
+
+// Delete removes the key, returning true if it was present
+func (s *macStore) Delete(key macKey) bool {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, exists := sh.entries[key]; !exists {
+		return false
+	}
+	delete(sh.entries, key)
+	s.size.Add(-1)
+	return true
+}
+
+// Len returns the number of entries in the store
+func (s *macStore) Len() int {
+	return int(s.size.Load())
+}
+
+// Sync makes the store hold the same entries as next, one shard at a time.
+// Only the keys that were added, changed or removed are written, so lookups
+// of unchanged MACs are never blocked for longer than a single shard diff.
+// Returns the number of added, updated and removed keys.
+func (s *macStore) Sync(next *macStore) (added, updated, removed int) {
+	for i := range s.shards {
+		sh, nsh := &s.shards[i], &next.shards[i]
+		nsh.mu.RLock()
+		sh.mu.Lock()
+		for k := range sh.entries {
+			if _, keep := nsh.entries[k]; !keep {
+				delete(sh.entries, k)
+				removed++
+			}
+		}
+		for k, v := range nsh.entries {
+			old, exists := sh.entries[k]
+			switch {
+			case !exists:
+				added++
+			case old != v:
+				updated++
+			default:
+				continue
+			}
+			sh.entries[k] = v
+		}
+		sh.mu.Unlock()
+		nsh.mu.RUnlock()
+	}
+	s.size.Add(int64(added - removed))
+	return added, updated, removed
+}
+
+// Range calls fn for every entry until fn returns false.
+// Each shard is read-locked while it is being visited, so fn must not
+// modify the store.
+func (s *macStore) Range(fn func(key macKey, info VMInfo) bool) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for k, v := range sh.entries {
+			if !fn(k, v) {
+				sh.mu.RUnlock()
+				return
+			}
+		}
+		sh.mu.RUnlock()
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestParseMACKey(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		valid bool
+	}{
+		{"52:54:00:12:34:56", "52:54:00:12:34:56", true},
+		{"52:54:00:AB:CD:EF", "52:54:00:ab:cd:ef", true},
+		{" 52-54-00-ab-cd-ef ", "52:54:00:ab:cd:ef", true},
+		{"52:54:00:12:34", "", false},
+		{"not-a-mac", "", false},
+		{"00:00:00:00:fe:80:00:00:00:00:00:00:02:00:5e:10:00:00:00:01", "", false},
+	}
+
+	for _, tt := range tests {
+		key, ok := parseMACKey(tt.in)
+		if ok != tt.valid {
+			t.Errorf("parseMACKey(%q) valid = %v, want %v", tt.in, ok, tt.valid)
+			continue
+		}
+		if ok && key.String() != tt.want {
+			t.Errorf("parseMACKey(%q) = %s, want %s", tt.in, key.String(), tt.want)
+		}
+	}
+}
+
+func TestMACStore_SetGetDelete(t *testing.T) {
+	store := newMACStore()
+	key, _ := parseMACKey("52:54:00:12:34:56")
+
+	if !store.Set(key, VMInfo{Name: "vm1", Namespace: "default"}) {
+		t.Error("Expected first Set to report a new key")
+	}
+	if store.Set(key, VMInfo{Name: "vm2", Namespace: "default"}) {
+		t.Error("Expected second Set to report an existing key")
+	}
+	if store.Len() != 1 {
+		t.Errorf("Expected length 1, got %d", store.Len())
+	}
+
+	info, found := store.Get(key)
+	if !found || info.Name != "vm2" {
+		t.Errorf("Expected vm2, got %+v (found=%v)", info, found)
+	}
+
+	if !store.Delete(key) {
+		t.Error("Expected Delete to report a removed key")
+	}
+	if store.Delete(key) {
+		t.Error("Expected second Delete to be a no-op")
+	}
+	if store.Len() != 0 {
+		t.Errorf("Expected length 0, got %d", store.Len())
+	}
+}
+
+func TestMACStore_Range(t *testing.T) {
+	store := fillMACStore(1000)
+
+	seen := 0
+	store.Range(func(_ macKey, _ VMInfo) bool {
+		seen++
+		return true
+	})
+	if seen != 1000 {
+		t.Errorf("Expected to visit 1000 entries, got %d", seen)
+	}
+
+	seen = 0
+	store.Range(func(_ macKey, _ VMInfo) bool {
+		seen++
+		return seen < 10
+	})
+	if seen != 10 {
+		t.Errorf("Expected Range to stop after 10 entries, got %d", seen)
+	}
+}
+
+func TestMACStore_Sync(t *testing.T) {
+	store := fillMACStore(100)
+
+	next := fillMACStore(100)
+	next.Delete(syntheticMACKey(0))                                         // removed
+	next.Set(syntheticMACKey(1), VMInfo{Name: "moved", Namespace: "bench"}) // updated
+	next.Set(syntheticMACKey(500), VMInfo{Name: "new", Namespace: "bench"}) // added
+
+	added, updated, removed := store.Sync(next)
+	if added != 1 || updated != 1 || removed != 1 {
+		t.Errorf("Expected 1 added, 1 updated, 1 removed, got %d, %d, %d", added, updated, removed)
+	}
+	if store.Len() != 100 {
+		t.Errorf("Expected length 100, got %d", store.Len())
+	}
+	if _, found := store.Get(syntheticMACKey(0)); found {
+		t.Error("Expected removed key to be gone")
+	}
+	if info, _ := store.Get(syntheticMACKey(1)); info.Name != "moved" {
+		t.Errorf("Expected updated entry, got %+v", info)
+	}
+	if info, _ := store.Get(syntheticMACKey(500)); info.Name != "new" {
+		t.Errorf("Expected added entry, got %+v", info)
+	}
+
+	if added, updated, removed := store.Sync(next); added+updated+removed != 0 {
+		t.Errorf("Expected no changes on a second sync, got %d, %d, %d", added, updated, removed)
+	}
+}
+
+// fillMACStore creates a store with n synthetic entries in the 52:54:00 OUI
+func fillMACStore(n int) *macStore {
+	store := newMACStore()
+	for i := 0; i < n; i++ {
+		key := syntheticMACKey(i)
+		store.Set(key, VMInfo{Name: fmt.Sprintf("vm-%d", i), Namespace: "bench"})
+	}
+	return store
+}
+
+func syntheticMACKey(i int) macKey {
+	return macKey{0x52, 0x54, 0x00, byte(i >> 16), byte(i >> 8), byte(i)}
+}
+
+func BenchmarkMACStore_Lookup50k(b *testing.B) {
+	store := fillMACStore(50000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store.Get(syntheticMACKey(i % 50000))
+	}
+}
+
+func BenchmarkMACStore_ParallelLookup50k(b *testing.B) {
+	store := fillMACStore(50000)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			store.Get(syntheticMACKey(i % 50000))
+			i++
+		}
+	})
+}
+
+func BenchmarkMACStore_Churn(b *testing.B) {
+	store := fillMACStore(50000)
+	info := VMInfo{Name: "churn", Namespace: "bench"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := syntheticMACKey(50000 + i%1000)
+		store.Set(key, info)
+		store.Delete(key)
+	}
+}
+
+func BenchmarkMACMapper_Lookup50k(b *testing.B) {
+	mapper := NewMACMapper(nil, logr.Discard())
+	mapper.mapping = fillMACStore(50000)
+	macs := make([]string, 1024)
+	for i := range macs {
+		macs[i] = syntheticMACKey(i * 37).String()
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mapper.Lookup(macs[i%len(macs)])
+	}
+}
+
+func BenchmarkMACStore_Sync50kChurn1k(b *testing.B) {
+	store := fillMACStore(50000)
+	base := fillMACStore(50000)
+	churned := fillMACStore(50000)
+	for i := 0; i < 1000; i++ {
+		churned.Set(syntheticMACKey(i*50), VMInfo{Name: "churn", Namespace: "bench"})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%2 == 0 {
+			store.Sync(churned)
+		} else {
+			store.Sync(base)
+		}
+	}
+}
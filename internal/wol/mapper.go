@@ -19,11 +19,13 @@ package wol
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	kubevirtv1 "kubevirt.io/api/core/v1"
@@ -32,10 +34,41 @@ import (
 	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
 )
 
+// AnnotationEnabled set to "false" on a VirtualMachine excludes it from WOL
+// management, whatever the discovery mode of the WolConfigs selecting it
+const AnnotationEnabled = "wol.pillon.org/enabled"
+
+// MappingType tells how a MAC to VM mapping was obtained
+type MappingType string
+
+const (
+	// MappingTypeExplicit is a mapping listed in a WolConfig's ExplicitMappings
+	MappingTypeExplicit MappingType = "explicit"
+	// MappingTypeDiscovered is a mapping found by listing VirtualMachines
+	MappingTypeDiscovered MappingType = "discovered"
+)
+
 // VMInfo stores information about a discovered VM
 type VMInfo struct {
 	Name      string
 	Namespace string
+	// StartAs is the user the manager impersonates when starting this VM
+	// (empty = operator identity)
+	StartAs string
+	// ConfigName is the WolConfig that produced this mapping
+	ConfigName string
+	// MappingType tells whether the mapping is explicit or discovered
+	MappingType MappingType
+	// SecureOnPolicy is the SecureOn enforcement applied to WOL events for this VM
+	SecureOnPolicy wolv1beta1.SecureOnPolicy
+	// SecureOnPasswordRef is the Secret of the VM's own SecureOn password
+	// (empty Name = the password of the config)
+	SecureOnPasswordRef wolv1beta1.SecretKeyReference
+	// WakeWith are the VMs started after this one (comma-separated
+	// <namespace>/<vm>, in order, see wakewith.go). A string keeps VMInfo comparable.
+	WakeWith string
+	// Pool is the VirtualMachinePool the VM is a member of (see pools.go)
+	Pool string
 }
 
 // MACMapper manages the mapping between MAC addresses and VMs
@@ -43,93 +76,380 @@ type MACMapper struct {
 	client   client.Client
 	log      logr.Logger
 	mu       sync.RWMutex
-	mapping  map[string]VMInfo // MAC address (lowercase) -> VM info
+	mapping  *macStore         // MAC address -> VM info
+	vms      map[string]VMInfo // <namespace>/<vm> -> VM info
 	lastSync time.Time
 	cacheTTL time.Duration
-	config   *wolv1beta1.WolConfig
+	configs  []wolv1beta1.WolConfig
+	// conflicts found during the last refresh, sorted by MAC
+	conflicts []MappingConflict
+	// secretReader reads SecureOn password Secrets (defaults to client)
+	secretReader client.Reader
+	// secureOnPasswords maps WolConfig name -> expected SecureOn password
+	secureOnPasswords map[string]string
+	// vmPasswords maps the Secret reference of per-VM passwords -> expected
+	// password ("" if unreadable, so the check fails closed)
+	vmPasswords map[wolv1beta1.SecretKeyReference]string
+	// relayTokens maps the hash of a relay token -> relay (see relays.go)
+	relayTokens map[relayTokenHash]RelayIdentity
+	// wakeHookKeys maps <wolconfig>/<hook> -> HMAC key of the hook (see hooks.go)
+	wakeHookKeys map[string][]byte
+	// mqttTriggers maps WolConfig name -> MQTT subscription (see mqtt.go)
+	mqttTriggers map[string]mqttSubscription
+	// audits maps WolConfig name -> audit sinks (see audit.go)
+	audits map[string]auditSinks
+	// idleShutdowns maps WolConfig name -> idle VM shutdown (see idle.go)
+	idleShutdowns map[string]idleShutdown
+	// arpTargets are the IPs of stopped VMs that wake them when ARP-requested
+	arpTargets []ARPTarget
+	// knownIPs remembers the IPs of managed VMs seen while running (<namespace>/<vm> -> IPs)
+	knownIPs map[string][]string
+	ipsMu    sync.Mutex
+	// knownIPMACs remembers the MAC of the VMI interface of each known IP
+	// (<namespace>/<vm> -> IP -> MAC), answered by the ARP responder
+	knownIPMACs map[string]map[string]string
+	// networkAttachments are the NADs used by the VMs of each config (config -> NADs)
+	networkAttachments map[string][]NetworkAttachment
+	// forwards are the MACs of external machines the magic packets are re-emitted to
+	forwards map[macKey]ForwardTarget
+	// mappingChanged is closed when the mapping changes (see allowlist.go)
+	mappingChanged chan struct{}
+
+	// refreshMu serializes RefreshMapping and the updates of single VMs (vmwatch.go)
+	refreshMu sync.Mutex
+	// claims are the VMs claiming each MAC, before conflict resolution
+	claims map[macKey][]VMInfo
+	// vmMACs are the MACs claimed by each VM (<namespace>/<vm> -> MACs)
+	vmMACs map[string]map[macKey]bool
+	// statusMACs are the auto-assigned MACs reported by the VMIs
+	// (<namespace>/<vm> -> interface -> MAC, see statusmacs.go)
+	statusMACs map[string]map[string]macKey
+	// labelNamespaces are the namespaces matching the NamespaceLabelSelector
+	// of each config (config -> namespaces, see namespaces.go)
+	labelNamespaces map[string]map[string]bool
+	// poolMembers are the MACs of the VirtualMachinePool members seen, kept
+	// after a scale-down removes them (see pools.go)
+	poolMembers map[macKey]poolMember
 }
 
 // NewMACMapper creates a new MAC to VM mapper
 func NewMACMapper(k8sClient client.Client, log logr.Logger) *MACMapper {
 	return &MACMapper{
-		client:   k8sClient,
-		log:      log,
-		mapping:  make(map[string]VMInfo),
-		cacheTTL: 300 * time.Second, // default 5 minutes
+		client:       k8sClient,
+		log:          log,
+		mapping:      newMACStore(),
+		cacheTTL:     300 * time.Second, // default 5 minutes
+		secretReader: k8sClient,
 	}
 }
 
-// UpdateConfig updates the mapper configuration
+// SetSecretReader sets the reader used to fetch SecureOn password Secrets.
+// Use an uncached reader to avoid watching every Secret in the cluster.
+func (m *MACMapper) SetSecretReader(reader client.Reader) {
+	m.secretReader = reader
+}
+
+// UpdateConfig updates the mapper configuration with a single WolConfig
 func (m *MACMapper) UpdateConfig(config *wolv1beta1.WolConfig) {
+	m.UpdateConfigs([]wolv1beta1.WolConfig{*config})
+}
+
+// UpdateConfigs updates the mapper configuration with all WolConfigs.
+// The resulting mapping is the union of what each config discovers (OR mode).
+// The cache TTL is the shortest positive CacheTTL among the configs.
+func (m *MACMapper) UpdateConfigs(configs []wolv1beta1.WolConfig) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.config = config
-	if config.Spec.CacheTTL > 0 {
-		m.cacheTTL = time.Duration(config.Spec.CacheTTL) * time.Second
+	m.configs = configs
+
+	var minTTL time.Duration
+	for _, config := range configs {
+		ttl := time.Duration(config.Spec.CacheTTL) * time.Second
+		if ttl > 0 && (minTTL == 0 || ttl < minTTL) {
+			minTTL = ttl
+		}
+	}
+	if minTTL > 0 {
+		m.cacheTTL = minTTL
 	}
 }
 
-// RefreshMapping refreshes the MAC to VM mapping based on current config
+// RefreshMapping refreshes the MAC to VM mapping based on current configs
 func (m *MACMapper) RefreshMapping(ctx context.Context) error {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+
 	m.mu.Lock()
-	config := m.config
+	configs := m.configs
 	m.mu.Unlock()
 
-	if config == nil {
+	if configs == nil {
 		return fmt.Errorf("no config set")
 	}
 
-	newMapping := make(map[string]VMInfo)
+	builder := newMappingBuilder(configs)
+	passwords := make(map[string]string)
+	relayTokens := make(map[relayTokenHash]RelayIdentity)
+	wakeHookKeys := make(map[string][]byte)
+	mqttTriggers := make(map[string]mqttSubscription)
+	audits := make(map[string]auditSinks)
+	idleShutdowns := make(map[string]idleShutdown)
+	forwards := make(map[macKey]ForwardTarget)
+	m.resolveLabelNamespaces(ctx, configs)
+	m.learnVMIMACs(ctx, configs)
+
+	for i := range configs {
+		config := &configs[i]
+		// A failing config must not prevent the others from being wakeable
+		if err := m.discoverConfig(ctx, config, builder); err != nil {
+			m.log.Error(err, "Failed to discover VMs for WolConfig", "config", config.Name)
+			ErrorsTotal.Inc()
+		}
+
+		// Without a readable password, Require rejects every packet (fail closed)
+		password, ok, err := m.loadSecureOnPassword(ctx, config)
+		if err != nil {
+			m.log.Error(err, "Failed to load SecureOn password", "config", config.Name)
+			ErrorsTotal.Inc()
+		} else if ok {
+			passwords[config.Name] = password
+		}
+
+		if err := m.loadRelayTokens(ctx, config, relayTokens); err != nil {
+			m.log.Error(err, "Failed to load relay tokens", "config", config.Name)
+			ErrorsTotal.Inc()
+		}
+		if err := m.loadWakeHookKeys(ctx, config, wakeHookKeys); err != nil {
+			m.log.Error(err, "Failed to load wake hook keys", "config", config.Name)
+			ErrorsTotal.Inc()
+		}
+		if sub, ok, err := m.loadMQTTTrigger(ctx, config); err != nil {
+			m.log.Error(err, "Failed to load MQTT trigger", "config", config.Name)
+			ErrorsTotal.Inc()
+		} else if ok {
+			mqttTriggers[config.Name] = sub
+		}
+		if sinks, ok, err := m.loadAudit(ctx, config); err != nil {
+			m.log.Error(err, "Failed to load audit sinks", "config", config.Name)
+			ErrorsTotal.Inc()
+		} else if ok {
+			audits[config.Name] = sinks
+		}
+		if idle, ok, err := m.loadIdleShutdown(ctx, config); err != nil {
+			m.log.Error(err, "Failed to load idle shutdown", "config", config.Name)
+			ErrorsTotal.Inc()
+		} else if ok {
+			idleShutdowns[config.Name] = idle
+		}
+
+		m.collectForwards(config, forwards)
+	}
+
+	newMapping := builder.build()
+	vms := builder.vmIndex()
+	m.forgetStatusMACs(vms)
+	m.forgetPoolMembers(ctx, configs)
+	vmPasswords := m.loadVMPasswords(ctx, newMapping)
+	arpTargets := m.refreshARPTargets(ctx, configs, vms, newMapping)
+	attachments := m.resolveNetworkAttachments(ctx, builder.networks)
+	conflicts := builder.conflictList()
+	for _, conflict := range conflicts {
+		winner := "none (rejected)"
+		if conflict.Winner != nil {
+			winner = conflict.Winner.String()
+		}
+		m.log.Info("MAC claimed by more than one VM", "mac", conflict.MAC,
+			"candidates", len(conflict.Candidates), "winner", winner)
+	}
+
+	// Apply the differences shard by shard instead of swapping the whole
+	// store, so a refresh with little churn touches few entries
+	added, updated, removed := m.mapping.Sync(newMapping)
+
+	m.mu.Lock()
+	m.vms = vms
+	m.arpTargets = arpTargets
+	m.networkAttachments = attachments
+	m.conflicts = conflicts
+	m.secureOnPasswords = passwords
+	m.vmPasswords = vmPasswords
+	m.relayTokens = relayTokens
+	m.wakeHookKeys = wakeHookKeys
+	m.mqttTriggers = mqttTriggers
+	m.audits = audits
+	m.idleShutdowns = idleShutdowns
+	m.forwards = forwards
+	m.claims = builder.candidates
+	m.vmMACs = builder.vmMACs()
+	m.lastSync = time.Now()
+	m.mu.Unlock()
+
+	// Update metrics
+	ManagedVMs.Set(float64(m.mapping.Len()))
+
+	m.log.Info("MAC mapping refreshed", "vmCount", m.mapping.Len(), "configs", len(configs), "conflicts", len(conflicts),
+		"added", added, "updated", updated, "removed", removed)
+	m.notifyMappingChange()
+	return nil
+}
 
+// StandbyRefreshInterval is how often a standby replica serving the agents
+// (shared dedupe) refreshes its mapping from the WolConfigs
+const StandbyRefreshInterval = 30 * time.Second
+
+// FollowConfigs keeps the mapping of a standby manager replica, whose
+// reconcilers do not run, up to date: it refreshes it from all the WolConfigs
+// every interval until elected is closed and the reconcilers take over
+func (m *MACMapper) FollowConfigs(ctx context.Context, interval time.Duration, elected <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-elected:
+			return
+		default:
+		}
+
+		configList := &wolv1beta1.WolConfigList{}
+		if err := m.client.List(ctx, configList); err != nil {
+			m.log.Error(err, "Failed to list WolConfigs for the standby mapping")
+		} else {
+			m.UpdateConfigs(configList.Items)
+			if err := m.RefreshMapping(ctx); err != nil {
+				m.log.Error(err, "Failed to refresh the standby mapping")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-elected:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// discoverConfig adds the VMs selected by a single WolConfig to the mapping
+func (m *MACMapper) discoverConfig(ctx context.Context, config *wolv1beta1.WolConfig, mapping *mappingBuilder) error {
+	if config.Spec.DiscoveryMode != wolv1beta1.DiscoveryModeExplicit {
+		// Senza esclusioni valide non si scopre alcuna VM (vedi extractMACsFromVMs)
+		if _, err := newDiscoveryExclusions(config); err != nil {
+			return err
+		}
+	}
 	switch config.Spec.DiscoveryMode {
 	case wolv1beta1.DiscoveryModeExplicit:
 		// Use explicit mappings from config
-		for _, mapping := range config.Spec.ExplicitMappings {
-			mac := normalizeMACAddress(mapping.MACAddress)
-			newMapping[mac] = VMInfo{
-				Name:      mapping.VMName,
-				Namespace: mapping.Namespace,
-			}
-		}
-		m.log.Info("Using explicit MAC mappings", "count", len(newMapping))
+		count := m.addExplicitMappings(config, mapping, func(namespace, name string) (*kubevirtv1.VirtualMachine, bool) {
+			vm := m.explicitVM(ctx, namespace, name)
+			return vm, vm == nil || !vmOptedOut(vm)
+		})
+		count += m.discoverSelectorMappings(ctx, config, mapping)
+		m.log.Info("Using explicit MAC mappings", "config", config.Name, "count", count)
 
 	case wolv1beta1.DiscoveryModeLabelSelector:
 		// Discover VMs using label selector
-		if err := m.discoverVMsWithSelector(ctx, config, newMapping); err != nil {
+		if err := m.discoverVMsWithSelector(ctx, config, mapping); err != nil {
 			return fmt.Errorf("failed to discover VMs with selector: %w", err)
 		}
 
 	default: // DiscoveryModeAll
 		// Discover all VMs in selected namespaces
-		if err := m.discoverAllVMs(ctx, config, newMapping); err != nil {
+		if err := m.discoverAllVMs(ctx, config, mapping); err != nil {
 			return fmt.Errorf("failed to discover all VMs: %w", err)
 		}
 	}
+	return nil
+}
 
-	// Update mapping
-	m.mu.Lock()
-	m.mapping = newMapping
-	m.lastSync = time.Now()
-	m.mu.Unlock()
+// addExplicitMappings adds the explicit mappings of the config to the mapping
+// and returns how many were added. lookup returns the VM of a mapping (nil if
+// unknown, for the mapping without its annotations) and false to skip it.
+func (m *MACMapper) addExplicitMappings(config *wolv1beta1.WolConfig, mapping *mappingBuilder,
+	lookup func(namespace, name string) (*kubevirtv1.VirtualMachine, bool)) int {
+	count := 0
+	for _, explicit := range config.Spec.ExplicitMappings {
+		if explicit.Forward != nil {
+			continue // vedi forward.go
+		}
+		if explicit.VMSelector != nil {
+			continue // vedi explicit_selectors.go
+		}
+		vm, ok := lookup(explicit.Namespace, explicit.VMName)
+		if !ok {
+			continue
+		}
+		key, ok := parseMACKey(explicit.MACAddress)
+		if !ok {
+			m.log.Info("Skipping explicit mapping with invalid MAC", "mac", explicit.MACAddress, "vm", explicit.VMName)
+			continue
+		}
+		info := newVMInfo(config, MappingTypeExplicit, explicit.Namespace, explicit.VMName)
+		if explicit.PasswordSecretRef != nil {
+			info.withPassword(*explicit.PasswordSecretRef)
+		}
+		if explicit.SecureOnPolicy != "" {
+			info.SecureOnPolicy = explicit.SecureOnPolicy
+		}
+		if vm != nil {
+			info.WakeWith = vmWakeWith(vm)
+		}
+		mapping.add(key, info)
+		count++
+	}
+	return count
+}
 
-	// Update metrics
-	ManagedVMs.Set(float64(len(newMapping)))
+// vmOptedOut returns true if the owner of the VM excluded it from WOL
+// management with the AnnotationEnabled annotation
+func vmOptedOut(vm *kubevirtv1.VirtualMachine) bool {
+	return strings.EqualFold(strings.TrimSpace(vm.Annotations[AnnotationEnabled]), "false")
+}
 
-	m.log.Info("MAC mapping refreshed", "vmCount", len(newMapping))
-	return nil
+// explicitVM reads the VM of an explicit mapping for its annotations. A VM that
+// does not exist (yet) or cannot be read is nil: the mapping is kept as is.
+func (m *MACMapper) explicitVM(ctx context.Context, namespace, name string) *kubevirtv1.VirtualMachine {
+	vm := &kubevirtv1.VirtualMachine{}
+	if err := m.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, vm); err != nil {
+		if !apierrors.IsNotFound(err) {
+			m.log.V(1).Info("Cannot read the VM of an explicit mapping", "vm", name, "namespace", namespace, "error", err.Error())
+		}
+		return nil
+	}
+	if vmOptedOut(vm) {
+		m.log.V(1).Info("Skipping explicit mapping of an opted-out VM", "vm", name, "namespace", namespace)
+	}
+	return vm
+}
+
+// newVMInfo builds the mapping entry for a VM selected by the given config
+func newVMInfo(config *wolv1beta1.WolConfig, mappingType MappingType, namespace, name string) VMInfo {
+	info := VMInfo{
+		Name:           name,
+		Namespace:      namespace,
+		ConfigName:     config.Name,
+		MappingType:    mappingType,
+		SecureOnPolicy: secureOnPolicyFor(config, namespace),
+	}
+	if sa := config.Spec.StartServiceAccount; sa != nil && sa.Name != "" {
+		info.StartAs = ServiceAccountUsername(sa.Namespace, sa.Name)
+	}
+	return info
 }
 
 // discoverAllVMs discovers all VMs in selected namespaces
-func (m *MACMapper) discoverAllVMs(ctx context.Context, config *wolv1beta1.WolConfig, mapping map[string]VMInfo) error {
-	namespaces := config.Spec.NamespaceSelectors
-	if len(namespaces) == 0 {
+func (m *MACMapper) discoverAllVMs(ctx context.Context, config *wolv1beta1.WolConfig, mapping *mappingBuilder) error {
+	namespaces, all := m.configNamespaces(config)
+	if all {
 		// If no namespaces specified, list all VMs across all namespaces
 		vmList := &kubevirtv1.VirtualMachineList{}
 		if err := m.client.List(ctx, vmList); err != nil {
 			return fmt.Errorf("failed to list VMs: %w", err)
 		}
-		m.extractMACsFromVMs(vmList.Items, mapping)
+		m.extractMACsFromVMs(config, vmList.Items, mapping)
 	} else {
 		// List VMs in each specified namespace
 		for _, ns := range namespaces {
@@ -138,14 +458,14 @@ func (m *MACMapper) discoverAllVMs(ctx context.Context, config *wolv1beta1.WolCo
 				m.log.Error(err, "Failed to list VMs in namespace", "namespace", ns)
 				continue
 			}
-			m.extractMACsFromVMs(vmList.Items, mapping)
+			m.extractMACsFromVMs(config, vmList.Items, mapping)
 		}
 	}
 	return nil
 }
 
 // discoverVMsWithSelector discovers VMs matching the label selector
-func (m *MACMapper) discoverVMsWithSelector(ctx context.Context, config *wolv1beta1.WolConfig, mapping map[string]VMInfo) error {
+func (m *MACMapper) discoverVMsWithSelector(ctx context.Context, config *wolv1beta1.WolConfig, mapping *mappingBuilder) error {
 	if config.Spec.VMSelector == nil {
 		return fmt.Errorf("VMSelector is nil in LabelSelector mode")
 	}
@@ -155,8 +475,8 @@ func (m *MACMapper) discoverVMsWithSelector(ctx context.Context, config *wolv1be
 		return fmt.Errorf("invalid label selector: %w", err)
 	}
 
-	namespaces := config.Spec.NamespaceSelectors
-	if len(namespaces) == 0 {
+	namespaces, all := m.configNamespaces(config)
+	if all {
 		// List across all namespaces with label selector
 		vmList := &kubevirtv1.VirtualMachineList{}
 		if err := m.client.List(ctx, vmList, &client.ListOptions{
@@ -164,7 +484,7 @@ func (m *MACMapper) discoverVMsWithSelector(ctx context.Context, config *wolv1be
 		}); err != nil {
 			return fmt.Errorf("failed to list VMs with selector: %w", err)
 		}
-		m.extractMACsFromVMs(vmList.Items, mapping)
+		m.extractMACsFromVMs(config, vmList.Items, mapping)
 	} else {
 		// List in each namespace with label selector
 		for _, ns := range namespaces {
@@ -176,52 +496,204 @@ func (m *MACMapper) discoverVMsWithSelector(ctx context.Context, config *wolv1be
 				m.log.Error(err, "Failed to list VMs in namespace with selector", "namespace", ns)
 				continue
 			}
-			m.extractMACsFromVMs(vmList.Items, mapping)
+			m.extractMACsFromVMs(config, vmList.Items, mapping)
 		}
 	}
 	return nil
 }
 
-// extractMACsFromVMs extracts MAC addresses from VM specs
-func (m *MACMapper) extractMACsFromVMs(vms []kubevirtv1.VirtualMachine, mapping map[string]VMInfo) {
-	for _, vm := range vms {
+// extractMACsFromVMs extracts MAC addresses from VM specs, and from the VMI
+// status for the interfaces without a MAC in the spec, except the VMs and MACs
+// excluded by the config
+func (m *MACMapper) extractMACsFromVMs(config *wolv1beta1.WolConfig, vms []kubevirtv1.VirtualMachine, mapping *mappingBuilder) {
+	exclusions, err := newDiscoveryExclusions(config)
+	if err != nil {
+		return // segnalato da discoverConfig
+	}
+	for i := range vms {
+		vm := &vms[i]
 		if vm.Spec.Template == nil {
 			continue
 		}
+		if vmOptedOut(vm) {
+			m.log.V(1).Info("Skipping opted-out VM", "vm", vm.Name, "namespace", vm.Namespace)
+			m.forgetPoolMember(vm.Namespace, vm.Name)
+			continue
+		}
+		if exclusions.excludesVM(vm) {
+			m.log.V(1).Info("Skipping excluded VM", "vm", vm.Name, "namespace", vm.Namespace, "config", config.Name)
+			continue
+		}
+		mapping.addNetworks(config.Name, vm)
+		info := newVMInfo(config, MappingTypeDiscovered, vm.Namespace, vm.Name)
+		if ref, ok := vmPasswordAnnotation(vm); ok {
+			info.withPassword(ref)
+		}
+		info.WakeWith = vmWakeWith(vm)
+		info.Pool = vmPool(vm)
+		var macs []macKey
+
+		for _, key := range m.interfaceMACs(vm) {
+			if exclusions.excludesMAC(key) {
+				m.log.V(1).Info("Skipping excluded MAC", "mac", key.String(), "vm", vm.Name,
+					"namespace", vm.Namespace, "config", config.Name)
+				continue
+			}
+			mapping.add(key, info)
+			macs = append(macs, key)
+			m.log.V(1).Info("Discovered VM MAC",
+				"mac", key.String(),
+				"vm", vm.Name,
+				"namespace", vm.Namespace)
+		}
+		if info.Pool != "" {
+			m.rememberPoolMember(vm, info, macs)
+		}
+	}
+}
 
-		// Extract MAC addresses from network interfaces
-		networks := vm.Spec.Template.Spec.Domain.Devices.Interfaces
-		for _, iface := range networks {
-			if iface.MacAddress != "" {
-				mac := normalizeMACAddress(iface.MacAddress)
-				mapping[mac] = VMInfo{
-					Name:      vm.Name,
-					Namespace: vm.Namespace,
-				}
-				m.log.V(1).Info("Discovered VM MAC",
-					"mac", mac,
-					"vm", vm.Name,
-					"namespace", vm.Namespace)
+// interfaceMACs returns the MACs of the network interfaces of a VM, from the
+// spec or, when assigned at boot, from the VMI status. m.refreshMu must be held.
+func (m *MACMapper) interfaceMACs(vm *kubevirtv1.VirtualMachine) []macKey {
+	var macs []macKey
+	for _, iface := range vm.Spec.Template.Spec.Domain.Devices.Interfaces {
+		if iface.MacAddress == "" {
+			// MAC assegnato al boot: quello dello status, se già visto
+			if learned, ok := m.statusMAC(vm, iface.Name); ok {
+				macs = append(macs, learned)
 			}
+			continue
 		}
+		key, ok := parseMACKey(iface.MacAddress)
+		if !ok {
+			m.log.V(1).Info("Skipping interface with invalid MAC",
+				"mac", iface.MacAddress,
+				"vm", vm.Name,
+				"namespace", vm.Namespace)
+			continue
+		}
+		macs = append(macs, key)
 	}
+	return macs
 }
 
 // Lookup returns the VM info for a given MAC address
 func (m *MACMapper) Lookup(macAddress string) (VMInfo, bool) {
+	key, ok := parseMACKey(macAddress)
+	if !ok {
+		return VMInfo{}, false
+	}
+
 	m.mu.RLock()
-	defer m.mu.RUnlock()
+	mapping := m.mapping
+	m.mu.RUnlock()
+
+	return mapping.Get(key)
+}
 
-	mac := normalizeMACAddress(macAddress)
-	vmInfo, found := m.mapping[mac]
-	return vmInfo, found
+// LookupVM returns the VM info of a managed VM by namespace and name
+func (m *MACMapper) LookupVM(namespace, name string) (VMInfo, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	info, found := m.vms[vmIndexKey(namespace, name)]
+	return info, found
 }
 
 // GetMappingCount returns the number of MAC addresses in the mapping
 func (m *MACMapper) GetMappingCount() int {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return len(m.mapping)
+	return m.mapping.Len()
+}
+
+// Mapping is a MAC address and the VM it wakes
+type Mapping struct {
+	MAC string
+	VMInfo
+}
+
+// Mappings returns the MAC to VM mappings of the given WolConfig (all mappings
+// if configName is empty), sorted by MAC
+func (m *MACMapper) Mappings(configName string) []Mapping {
+	m.mu.RLock()
+	mapping := m.mapping
+	m.mu.RUnlock()
+
+	var result []Mapping
+	mapping.Range(func(key macKey, info VMInfo) bool {
+		if configName == "" || info.ConfigName == configName {
+			result = append(result, Mapping{MAC: key.String(), VMInfo: info})
+		}
+		return true
+	})
+	slices.SortFunc(result, func(a, b Mapping) int { return strings.Compare(a.MAC, b.MAC) })
+	return result
+}
+
+// GetConflicts returns the conflicts found during the last refresh that involve
+// the given WolConfig (all conflicts if configName is empty)
+func (m *MACMapper) GetConflicts(configName string) []MappingConflict {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var result []MappingConflict
+	for i := range m.conflicts {
+		if configName == "" || m.conflicts[i].InvolvesConfig(configName) {
+			result = append(result, m.conflicts[i])
+		}
+	}
+	return result
+}
+
+// SecureOnPassword returns the expected SecureOn password of a WolConfig
+// (empty if not configured)
+func (m *MACMapper) SecureOnPassword(configName string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.secureOnPasswords[configName]
+}
+
+// DedupeWindow returns the aggregator dedupe window of a WolConfig, 0 if it
+// is not set
+func (m *MACMapper) DedupeWindow(configName string) time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range m.configs {
+		if m.configs[i].Name != configName {
+			continue
+		}
+		if spec := m.configs[i].Spec.Dedupe; spec != nil && spec.AggregatorWindowSeconds != nil {
+			return time.Duration(*spec.AggregatorWindowSeconds) * time.Second
+		}
+		return 0
+	}
+	return 0
+}
+
+// listensOn returns true if a WolConfig listens on the UDP port, as a WOL port
+// or a raw capture port (9 for the WolConfigs without WOLPorts)
+func (m *MACMapper) listensOn(port uint32) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range m.configs {
+		spec := &m.configs[i].Spec
+		if len(spec.WOLPorts) == 0 && port == DefaultWOLPort {
+			return true
+		}
+		for _, p := range spec.WOLPorts {
+			if uint32(p) == port {
+				return true
+			}
+		}
+		if spec.RawCapture != nil {
+			for _, p := range spec.RawCapture.UDPPorts {
+				if uint32(p) == port {
+					return true
+				}
+			}
+		}
+	}
+	return false
 }
 
 // NeedRefresh returns true if the mapping needs to be refreshed
@@ -17,9 +17,13 @@ limitations under the License.
 package wol
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
 	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
 )
 
@@ -77,3 +81,192 @@ func TestMACMapper_GetLastSync(t *testing.T) {
 		t.Errorf("Expected zero time for lastSync, got %v", lastSync)
 	}
 }
+
+func TestMACMapper_StartServiceAccount(t *testing.T) {
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+
+	mapper.UpdateConfigs([]wolv1beta1.WolConfig{
+		{
+			Spec: wolv1beta1.WolConfigSpec{
+				DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+				ExplicitMappings: []wolv1beta1.MACVMMapping{
+					{MACAddress: "52:54:00:12:34:56", VMName: "vm1", Namespace: "team-a"},
+				},
+				StartServiceAccount: &wolv1beta1.ServiceAccountReference{Name: "waker", Namespace: "team-a"},
+			},
+		},
+		{
+			Spec: wolv1beta1.WolConfigSpec{
+				DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+				ExplicitMappings: []wolv1beta1.MACVMMapping{
+					{MACAddress: "52:54:00:ab:cd:ef", VMName: "vm2", Namespace: "team-b"},
+				},
+			},
+		},
+	})
+
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	info, found := mapper.Lookup("52:54:00:12:34:56")
+	if !found {
+		t.Fatal("Expected vm1 to be mapped")
+	}
+	if info.StartAs != "system:serviceaccount:team-a:waker" {
+		t.Errorf("Expected vm1 to be started as team-a/waker, got %q", info.StartAs)
+	}
+
+	info, found = mapper.Lookup("52:54:00:AB:CD:EF")
+	if !found {
+		t.Fatal("Expected vm2 to be mapped")
+	}
+	if info.StartAs != "" {
+		t.Errorf("Expected vm2 to use the operator identity, got %q", info.StartAs)
+	}
+}
+
+func TestMACMapper_Provenance(t *testing.T) {
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:12:34:56", VMName: "vm1", Namespace: "default"},
+			},
+		},
+	}
+	config.Name = "explicit-config"
+	mapper.UpdateConfig(config)
+
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	info, found := mapper.Lookup("52:54:00:12:34:56")
+	if !found {
+		t.Fatal("Expected vm1 to be mapped")
+	}
+	if info.ConfigName != "explicit-config" {
+		t.Errorf("Expected config explicit-config, got %q", info.ConfigName)
+	}
+	if info.MappingType != MappingTypeExplicit {
+		t.Errorf("Expected explicit mapping type, got %q", info.MappingType)
+	}
+}
+
+func TestMACMapper_LookupVM(t *testing.T) {
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:12:34:56", VMName: "vm1", Namespace: "default"},
+			},
+		},
+	}
+	config.Name = "explicit-config"
+	mapper.UpdateConfig(config)
+
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	info, found := mapper.LookupVM("default", "vm1")
+	if !found || info.ConfigName != "explicit-config" {
+		t.Errorf("Expected vm1 from explicit-config, got %+v (found=%v)", info, found)
+	}
+	if _, found := mapper.LookupVM("other", "vm1"); found {
+		t.Error("Expected VM in another namespace not to be found")
+	}
+}
+
+func TestMACMapper_Mappings(t *testing.T) {
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+
+	first := wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:02", VMName: "vm2", Namespace: "default"},
+				{MACAddress: "52:54:00:00:00:01", VMName: "vm1", Namespace: "default"},
+			},
+		},
+	}
+	first.Name = "first"
+	second := wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:03", VMName: "vm3", Namespace: "other"},
+			},
+		},
+	}
+	second.Name = "second"
+	mapper.UpdateConfigs([]wolv1beta1.WolConfig{first, second})
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	mappings := mapper.Mappings("first")
+	if len(mappings) != 2 {
+		t.Fatalf("Expected 2 mappings of the first WolConfig, got %+v", mappings)
+	}
+	if mappings[0].MAC != "52:54:00:00:00:01" || mappings[0].Name != "vm1" || mappings[1].Name != "vm2" {
+		t.Errorf("Expected the mappings sorted by MAC, got %+v", mappings)
+	}
+	if mappings[0].MappingType != MappingTypeExplicit {
+		t.Errorf("Expected explicit mappings, got %q", mappings[0].MappingType)
+	}
+	if all := mapper.Mappings(""); len(all) != 3 {
+		t.Errorf("Expected 3 mappings in total, got %d", len(all))
+	}
+}
+
+func TestMACMapper_MixedDiscoveryModes(t *testing.T) {
+	labelled := newWatchVM("vm2", map[string]string{"wol": "on"}, "52:54:00:00:00:02")
+	elsewhere := newWatchVM("vm3", map[string]string{"wol": "on"}, "52:54:00:00:00:03")
+	elsewhere.Namespace = "lab"
+	ignored := newWatchVM("vm5", nil, "52:54:00:00:00:05")
+	ignored.Namespace = "lab"
+	mapper := NewMACMapper(newPolicyClient(t, newWatchVM("vm1", nil, "52:54:00:00:00:01"), labelled, elsewhere, ignored),
+		logr.Discard())
+
+	all := conflictTestConfig("all", time.Hour, 0, "")
+	all.Spec.NamespaceSelectors = []string{"tenant"}
+	selected := conflictTestConfig("selected", 0, 10, "")
+	selected.Spec.DiscoveryMode = wolv1beta1.DiscoveryModeLabelSelector
+	selected.Spec.VMSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"wol": "on"}}
+	explicit := conflictTestConfig("explicit", 0, 0, "")
+	explicit.Spec.DiscoveryMode = wolv1beta1.DiscoveryModeExplicit
+	explicit.Spec.ExplicitMappings = []wolv1beta1.MACVMMapping{
+		{MACAddress: "52:54:00:00:00:04", VMName: "vm4", Namespace: "lab"},
+	}
+	mapper.UpdateConfigs([]wolv1beta1.WolConfig{all, selected, explicit})
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Every config adds its VMs to the global mapping (a union)
+	for mac, want := range map[string]string{
+		"52:54:00:00:00:01": "all",
+		"52:54:00:00:00:02": "selected", // also matched by all, the higher precedence wins
+		"52:54:00:00:00:03": "selected",
+		"52:54:00:00:00:04": "explicit",
+	} {
+		if info, found := mapper.Lookup(mac); !found || info.ConfigName != want {
+			t.Errorf("Expected %s to be mapped by %s, got %+v (found=%v)", mac, want, info, found)
+		}
+	}
+	if _, found := mapper.Lookup("52:54:00:00:00:05"); found {
+		t.Error("Expected a VM selected by no config not to be mapped")
+	}
+	if info, _ := mapper.Lookup("52:54:00:00:00:03"); info.MappingType != MappingTypeDiscovered {
+		t.Errorf("Expected a discovered mapping, got %q", info.MappingType)
+	}
+	if count := mapper.GetMappingCount(); count != 4 {
+		t.Errorf("Expected 4 MACs, got %d", count)
+	}
+}
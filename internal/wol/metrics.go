@@ -17,17 +17,59 @@ limitations under the License.
 package wol
 
 import (
+	"strconv"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
 )
 
 var (
-	// WOLPacketsTotal counts the number of Wake-on-LAN packets received
-	WOLPacketsTotal = prometheus.NewCounter(
+	// WOLPacketsTotal counts the WOL events reported to the manager
+	WOLPacketsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "wol_packets_total",
-			Help: "Number of Wake-on-LAN packets received",
+			Help: "Number of Wake-on-LAN packets reported to the manager, by node, destination port and listener (udp, raw, relay)",
+		},
+		[]string{"node", "port", "listener"},
+	)
+
+	// VMStartDurationSeconds observes the calls starting (or resuming) a VM for a WOL event
+	VMStartDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "wol_vm_start_duration_seconds",
+			Help:    "Duration of the KubeVirt calls starting a VM for a WOL event, by result (started, failed)",
+			Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		},
+		[]string{"result"},
+	)
+
+	// LookupMissesTotal counts the WOL events whose MAC matches no VM nor Forward mapping
+	LookupMissesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_lookup_misses_total",
+			Help: "Number of WOL events whose MAC matches no VM nor Forward mapping, by node",
 		},
+		[]string{"node"},
+	)
+
+	// DedupeHitsTotal counts the WOL events dropped as duplicates by the manager
+	DedupeHitsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_dedupe_hits_total",
+			Help: "Number of WOL events answered from the dedupe cache of the manager, by node",
+		},
+		[]string{"node"},
+	)
+
+	// GRPCEventsTotal counts the WOL events reported by the agents and relays, by response status
+	GRPCEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_grpc_events_total",
+			Help: "Number of WOL events reported via gRPC, by response status",
+		},
+		[]string{"status"},
 	)
 
 	// VMStartedTotal counts the number of VMs started via WOL
@@ -38,6 +80,51 @@ var (
 		},
 	)
 
+	// VMStoppedTotal counts the VMs stopped or paused by sleep packets
+	VMStoppedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_vm_stopped_total",
+			Help: "Number of VMs stopped or paused by Shutdown-on-LAN sleep packets, by action (Stop, Pause)",
+		},
+		[]string{"action"},
+	)
+
+	// ScheduleRunsTotal counts the runs of the WolSchedules
+	ScheduleRunsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_schedule_runs_total",
+			Help: "Number of WolSchedule runs, by action (wake, sleep) and result (success, failed)",
+		},
+		[]string{"action", "result"},
+	)
+
+	// IdleVMs tracks the running VMs currently idle, by WolConfig
+	IdleVMs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wol_idle_vms",
+			Help: "Running VMs currently idle, by WolConfig (see spec.idleShutdown)",
+		},
+		[]string{"wolconfig"},
+	)
+
+	// IdleShutdownsTotal counts the idle VMs stopped or paused
+	IdleShutdownsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_idle_shutdowns_total",
+			Help: "Number of idle VMs stopped or paused, by action (Stop, Pause) and result (success, failed)",
+		},
+		[]string{"action", "result"},
+	)
+
+	// DryRunActionsTotal counts the actions skipped by the WolConfigs in dry-run
+	DryRunActionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_dry_run_actions_total",
+			Help: "Number of actions not taken because the WolConfig is in dry-run, by WolConfig and action (Start, Resume, RestartIfCrashed, Stop, Pause, Forward)",
+		},
+		[]string{"wolconfig", "action"},
+	)
+
 	// ErrorsTotal counts the number of errors during WOL handling
 	ErrorsTotal = prometheus.NewCounter(
 		prometheus.CounterOpts{
@@ -46,6 +133,249 @@ var (
 		},
 	)
 
+	// ConfigMatchesTotal counts WOL events matched to a VM, by the WolConfig
+	// and mapping type (explicit or discovered) that produced the match
+	ConfigMatchesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_config_matches_total",
+			Help: "Number of WOL events matched to a VM, by WolConfig and mapping type",
+		},
+		[]string{"wolconfig", "mapping_type"},
+	)
+
+	// SecureOnChecksTotal counts SecureOn password checks for VMs with an Audit or Require policy
+	SecureOnChecksTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_secureon_checks_total",
+			Help: "Number of SecureOn password checks, by WolConfig, policy and result (ok, missing, invalid)",
+		},
+		[]string{"wolconfig", "policy", "result"},
+	)
+
+	// SecureOnRejectedTotal counts WOL events rejected by a Require SecureOn policy
+	SecureOnRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_secureon_rejected_total",
+			Help: "Number of WOL events rejected because of a missing or wrong SecureOn password",
+		},
+		[]string{"wolconfig", "reason"},
+	)
+
+	// ForwardedPacketsTotal counts the magic packets re-emitted to external machines
+	ForwardedPacketsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_forwarded_packets_total",
+			Help: "Number of magic packets forwarded to external machines, by WolConfig, sender (manager or agent) and result",
+		},
+		[]string{"wolconfig", "sender", "result"},
+	)
+
+	// SentPacketsTotal counts the magic packets sent to external machines on request (SendWOL)
+	SentPacketsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_sent_packets_total",
+			Help: "Number of magic packets sent to external machines through SendWOL, by sender (manager or agent) and result",
+		},
+		[]string{"sender", "result"},
+	)
+
+	// RateLimitedTotal counts the packets rejected by the rate limit of a WolConfig
+	RateLimitedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_rate_limited_total",
+			Help: "Number of WOL packets rejected by the rate limit of their WolConfig, by WolConfig and bucket (mac or node)",
+		},
+		[]string{"wolconfig", "scope"},
+	)
+
+	// PolicyDecisionsTotal counts the wakes checked against a WolPolicy
+	PolicyDecisionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_policy_decisions_total",
+			Help: "Number of wakes checked against a WolPolicy, by action and result",
+		},
+		[]string{"action", "result"},
+	)
+
+	// WakeRequestsTotal counts wake requests received without a magic packet (e.g. from the activator)
+	WakeRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_wake_requests_total",
+			Help: "Number of wake requests by VM name, by source (activator, arp, api or other) and response status",
+		},
+		[]string{"source", "status"},
+	)
+
+	// WakeDependenciesTotal counts the dependencies started along with a woken VM
+	WakeDependenciesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_wake_dependencies_total",
+			Help: "Number of dependencies (wol.pillon.org/wake-with) of woken VMs, by response status",
+		},
+		[]string{"status"},
+	)
+
+	// WakeRetryQueueSize is the number of failed VM starts waiting for a retry
+	WakeRetryQueueSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "wol_wake_retry_queue_size",
+			Help: "Number of failed VM starts waiting for a retry",
+		},
+	)
+
+	// StartsThrottledTotal counts the VM starts rejected by the global start limits
+	StartsThrottledTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_starts_throttled_total",
+			Help: "Number of VM starts rejected by the global start limits of the manager, by limit (concurrency or rate)",
+		},
+		[]string{"limit"},
+	)
+
+	// WakeRetriesTotal counts the retries of failed VM starts
+	WakeRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_wake_retries_total",
+			Help: "Retries of failed VM starts, by result (started, failed, exhausted, dropped on shutdown)",
+		},
+		[]string{"result"},
+	)
+
+	// SharedDedupeClaimsTotal counts the claims of events on the shared dedupe backend
+	SharedDedupeClaimsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_shared_dedupe_claims_total",
+			Help: "Events claimed on the shared dedupe backend, by result (claimed, duplicate, error)",
+		},
+		[]string{"result"},
+	)
+
+	// AgentDelaySeconds observes the time events spend on the agent before being reported
+	AgentDelaySeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "wol_agent_delay_seconds",
+			Help:    "Time between packet receipt on the agent and the report of the event, by node (unknown if not a cluster node)",
+			Buckets: []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+		},
+		[]string{"node"},
+	)
+
+	// EventTransitSeconds observes the time from the agent sending an event to the manager
+	// receiving it. It subtracts timestamps of two different clocks: the nodes must be
+	// NTP-synchronized, and the agent-side round trip is the skew-free alternative.
+	EventTransitSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "wol_event_transit_seconds",
+			Help:    "Time between an agent sending a WOL event and the manager receiving it, by node (requires NTP-synchronized nodes)",
+			Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+		},
+		[]string{"node"},
+	)
+
+	// WakeToRunningSeconds observes the time from a wake packet captured by an agent
+	// (or a wake request received) to the VM started for it being Running
+	WakeToRunningSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "wol_wake_to_running_seconds",
+			Help:    "Time from the capture of a wake packet (or the arrival of a wake request) to the VM started for it being Running, by WolConfig",
+			Buckets: []float64{1, 2.5, 5, 10, 15, 20, 30, 45, 60, 90, 120, 180, 300, 600},
+		},
+		[]string{"wolconfig"},
+	)
+
+	// WakeFailuresTotal counts the VMs started for a wake that did not reach Running
+	// within the timeout of spec.wakeVerification
+	WakeFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_wake_failures_total",
+			Help: "VMs started for a wake not Running within the spec.wakeVerification timeout, by WolConfig and reason (VM status, or VMIFailed)",
+		},
+		[]string{"wolconfig", "reason"},
+	)
+
+	// AggregatorSaturation is the usage of the aggregator internal resources relative
+	// to their saturation threshold (>= 1 means saturated)
+	AggregatorSaturation = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wol_aggregator_saturation_ratio",
+			Help: "Usage of the aggregator internal resources relative to their saturation threshold, by resource",
+		},
+		[]string{"resource"},
+	)
+
+	// RelayRequestsTotal counts the requests received on the relay endpoint
+	RelayRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_relay_requests_total",
+			Help: "Number of requests received on the relay endpoint, by relay (empty if not authenticated) and result (ok, denied, unauthenticated)",
+		},
+		[]string{"relay", "result"},
+	)
+
+	// MQTTConnected reports whether the MQTT trigger of each WolConfig is subscribed
+	MQTTConnected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wol_mqtt_connected",
+			Help: "Whether the MQTT trigger of a WolConfig is connected and subscribed to its broker (1) or not (0)",
+		},
+		[]string{"wolconfig"},
+	)
+
+	// MQTTMessagesTotal counts the messages received by the MQTT triggers
+	MQTTMessagesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_mqtt_messages_total",
+			Help: "Number of messages received by the MQTT triggers, by WolConfig and result (wake, unknown, invalid, retained)",
+		},
+		[]string{"wolconfig", "result"},
+	)
+
+	// GRPCTokenAuthTotal counts the ServiceAccount token checks of the gRPC server
+	GRPCTokenAuthTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_grpc_token_auth_total",
+			Help: "Number of ServiceAccount token checks of the agent gRPC server, by result (ok, unauthenticated, denied)",
+		},
+		[]string{"result"},
+	)
+
+	// SpoofedEventsTotal counts the events whose reported node is not their sender
+	SpoofedEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_spoofed_events_total",
+			Help: "Number of WOL events whose reported node does not match the address or the identity of the sender, " +
+				"by node (unknown if not a cluster node) and reason (unknown-node, address-mismatch)",
+		},
+		[]string{"node", "reason"},
+	)
+
+	// AuditRecordsTotal counts the audit records by sink and result
+	AuditRecordsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_audit_records_total",
+			Help: "Number of audit records of the wake decisions, by WolConfig, sink (file, webhook) and result (written, dropped)",
+		},
+		[]string{"wolconfig", "sink", "result"},
+	)
+
+	// ChaosFaultsTotal counts the faults injected by the chaos flags
+	ChaosFaultsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wol_chaos_faults_total",
+			Help: "Number of faults injected on purpose for resilience testing, by fault (drop, duplicate, delay, start_failure)",
+		},
+		[]string{"fault"},
+	)
+
+	// AgentsConnected is the number of agents with a recent heartbeat
+	AgentsConnected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wol_agents_connected",
+			Help: "Number of agents that sent a heartbeat within the timeout, by WolConfig (empty for agents without one)",
+		},
+		[]string{"wolconfig"},
+	)
+
 	// ManagedVMs is a gauge for the number of currently managed VMs
 	ManagedVMs = prometheus.NewGauge(
 		prometheus.GaugeOpts{
@@ -59,8 +389,71 @@ func init() {
 	// Register metrics with controller-runtime's registry
 	metrics.Registry.MustRegister(
 		WOLPacketsTotal,
+		VMStartDurationSeconds,
+		LookupMissesTotal,
+		DedupeHitsTotal,
+		GRPCEventsTotal,
 		VMStartedTotal,
+		VMStoppedTotal,
+		ScheduleRunsTotal,
+		IdleVMs,
+		IdleShutdownsTotal,
+		DryRunActionsTotal,
 		ErrorsTotal,
+		ConfigMatchesTotal,
+		SecureOnChecksTotal,
+		SecureOnRejectedTotal,
+		PolicyDecisionsTotal,
+		ForwardedPacketsTotal,
+		SentPacketsTotal,
+		RateLimitedTotal,
+		WakeRequestsTotal,
+		WakeDependenciesTotal,
+		WakeRetryQueueSize,
+		WakeRetriesTotal,
+		StartsThrottledTotal,
+		SharedDedupeClaimsTotal,
+		AgentDelaySeconds,
+		EventTransitSeconds,
+		WakeToRunningSeconds,
+		WakeFailuresTotal,
+		AggregatorSaturation,
+		RelayRequestsTotal,
+		MQTTConnected,
+		MQTTMessagesTotal,
+		AuditRecordsTotal,
+		GRPCTokenAuthTotal,
+		SpoofedEventsTotal,
+		ChaosFaultsTotal,
+		AgentsConnected,
 		ManagedVMs,
 	)
 }
+
+const (
+	// packetListenerUDP, packetListenerRaw and packetListenerRelay are the
+	// listener label values of wol_packets_total
+	packetListenerUDP   = "udp"
+	packetListenerRaw   = "raw"
+	packetListenerRelay = "relay"
+	// otherPortLabel is the port label of the packets on a port no WolConfig listens on
+	otherPortLabel = "other"
+)
+
+// packetLabels returns the port and listener labels of a WOL event. Only the
+// ports of the WolConfigs are used as is: the others, set by the client, would
+// create unbounded metric series.
+func (a *Aggregator) packetLabels(event *wolv1.WOLEvent, fromRelay bool) (string, string) {
+	listener := packetListenerUDP
+	switch {
+	case fromRelay:
+		listener = packetListenerRelay
+	case event.DestinationPort == 0:
+		// Frame Ethernet raw (EtherType 0x0842 o di sleep)
+		return "0", packetListenerRaw
+	}
+	if !a.mapper.listensOn(event.DestinationPort) {
+		return otherPortLabel, listener
+	}
+	return strconv.FormatUint(uint64(event.DestinationPort), 10), listener
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestAggregator_PacketLabels(t *testing.T) {
+	agg := newForwardAggregator(t, wolv1beta1.WOLForwardTarget{Address: "192.168.10.255", Port: 9})
+
+	tests := []struct {
+		name         string
+		event        *wolv1.WOLEvent
+		relay        bool
+		wantPort     string
+		wantListener string
+	}{
+		{"default port", &wolv1.WOLEvent{DestinationPort: 9}, false, "9", packetListenerUDP},
+		{"raw frame", &wolv1.WOLEvent{}, false, "0", packetListenerRaw},
+		{"port of no WolConfig", &wolv1.WOLEvent{DestinationPort: 40000}, false, otherPortLabel, packetListenerUDP},
+		{"relay", &wolv1.WOLEvent{DestinationPort: 9}, true, "9", packetListenerRelay},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port, listener := agg.packetLabels(tt.event, tt.relay)
+			if port != tt.wantPort || listener != tt.wantListener {
+				t.Errorf("Expected labels %s/%s, got %s/%s", tt.wantPort, tt.wantListener, port, listener)
+			}
+		})
+	}
+}
+
+func TestAggregator_EventMetrics(t *testing.T) {
+	agg := newForwardAggregator(t, wolv1beta1.WOLForwardTarget{Address: "192.168.10.255", Port: 9})
+	ctx := context.Background()
+
+	packets := WOLPacketsTotal.WithLabelValues(unknownNodeLabel, "9", packetListenerUDP)
+	misses := LookupMissesTotal.WithLabelValues(unknownNodeLabel)
+	dedupeHits := DedupeHitsTotal.WithLabelValues(unknownNodeLabel)
+	notFound := GRPCEventsTotal.WithLabelValues(wolv1.ResponseStatus_VM_NOT_FOUND.String())
+	basePackets, baseMisses := testutil.ToFloat64(packets), testutil.ToFloat64(misses)
+	baseDedupe, baseNotFound := testutil.ToFloat64(dedupeHits), testutil.ToFloat64(notFound)
+
+	event := &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:99", NodeName: "metrics-node", DestinationPort: 9}
+	for range 2 {
+		if _, err := agg.ReportWOLEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := testutil.ToFloat64(packets) - basePackets; got != 2 {
+		t.Errorf("Expected 2 packets, got %v", got)
+	}
+	if got := testutil.ToFloat64(misses) - baseMisses; got != 1 {
+		t.Errorf("Expected 1 lookup miss, got %v", got)
+	}
+	if got := testutil.ToFloat64(dedupeHits) - baseDedupe; got != 1 {
+		t.Errorf("Expected 1 dedupe hit, got %v", got)
+	}
+	if got := testutil.ToFloat64(notFound) - baseNotFound; got != 1 {
+		t.Errorf("Expected 1 VM_NOT_FOUND event (the other is a DUPLICATE), got %v", got)
+	}
+
+	// L'avvio della VM è misurato
+	if _, err := agg.ReportWOLEvent(ctx, &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01", NodeName: "metrics-node", DestinationPort: 9}); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.CollectAndCount(VMStartDurationSeconds, "wol_vm_start_duration_seconds"); got == 0 {
+		t.Error("Expected a VM start duration series")
+	}
+}
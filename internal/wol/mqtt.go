@@ -0,0 +1,570 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// The leader subscribes to the MQTT topics of the WolConfigs with an MQTT
+// trigger, with a minimal MQTT 3.1.1 client (clean session, QoS 1), and
+// requests the wakes their messages ask for like the REST API does.
+
+// MQTTWakeSource is the source reported by the wakes requested by MQTT messages
+const MQTTWakeSource = "mqtt"
+
+const (
+	defaultMQTTTopic = "kubevirt-wol/wake"
+
+	// mqttKeepAlive is the keep alive announced to the brokers: a PINGREQ is
+	// sent every half of it, and a connection silent for longer is dropped
+	mqttKeepAlive = 60 * time.Second
+	// mqttConnectTimeout bounds the dial and the CONNECT/SUBSCRIBE handshake
+	mqttConnectTimeout = 10 * time.Second
+	// mqttMinBackoff and mqttMaxBackoff bound the wait before reconnecting
+	mqttMinBackoff = time.Second
+	mqttMaxBackoff = time.Minute
+	// mqttMaxPacket bounds the packets read; larger ones are skipped
+	mqttMaxPacket = 64 << 10
+	// mqttMaxMessage bounds the payloads parsed as wake messages
+	mqttMaxMessage = 4 << 10
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttPuback     = 4
+	mqttSubscribe  = 8
+	mqttSuback     = 9
+	mqttPingreq    = 12
+	mqttPingresp   = 13
+	mqttDisconnect = 14
+)
+
+// mqttConnackErrors are the reasons of the refused connections, by return code
+var mqttConnackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad username or password",
+	5: "not authorized",
+}
+
+// mqttSubscription is the MQTT trigger of a WolConfig with its Secrets read.
+// It is comparable: a change of the spec or of the Secrets restarts the subscription.
+type mqttSubscription struct {
+	Broker   string // host:port
+	TLS      bool
+	Topic    string
+	ClientID string
+	Username string
+	Password string
+	CA       string // PEM, "" for the system CAs
+}
+
+// loadMQTTTrigger resolves the MQTT trigger of a config, reading its Secrets.
+// Returns false if the config has no MQTT trigger.
+func (m *MACMapper) loadMQTTTrigger(ctx context.Context, config *wolv1beta1.WolConfig) (mqttSubscription, bool, error) {
+	if config.Spec.WakeTriggers == nil || config.Spec.WakeTriggers.MQTT == nil {
+		return mqttSubscription{}, false, nil
+	}
+	spec := config.Spec.WakeTriggers.MQTT
+
+	broker, err := url.Parse(spec.BrokerURL)
+	if err != nil || broker.Host == "" {
+		return mqttSubscription{}, false, fmt.Errorf("invalid MQTT broker URL %q", spec.BrokerURL)
+	}
+	sub := mqttSubscription{
+		Broker:   broker.Host,
+		Topic:    spec.Topic,
+		ClientID: spec.ClientID,
+		Username: spec.Username,
+	}
+	port := "1883"
+	switch broker.Scheme {
+	case "ssl", "tls", "mqtts":
+		sub.TLS, port = true, "8883"
+	}
+	if broker.Port() == "" {
+		sub.Broker = net.JoinHostPort(broker.Hostname(), port)
+	}
+	if sub.Topic == "" {
+		sub.Topic = defaultMQTTTopic // same default as the CRD
+	}
+	if sub.ClientID == "" {
+		sub.ClientID = "kubevirt-wol-" + config.Name
+	}
+
+	if ref := spec.PasswordSecretRef; ref != nil {
+		if sub.Username == "" {
+			return mqttSubscription{}, false, fmt.Errorf("MQTT passwordSecretRef requires a username")
+		}
+		password, err := m.readSecretKey(ctx, *ref)
+		if err != nil {
+			return mqttSubscription{}, false, fmt.Errorf("MQTT password: %w", err)
+		}
+		sub.Password = strings.TrimSpace(string(password))
+	}
+	if ref := spec.CASecretRef; ref != nil {
+		ca, err := m.readSecretKey(ctx, *ref)
+		if err != nil {
+			return mqttSubscription{}, false, fmt.Errorf("MQTT CA: %w", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(ca) {
+			return mqttSubscription{}, false, fmt.Errorf("MQTT CA secret %s/%s holds no PEM certificate", ref.Namespace, ref.Name)
+		}
+		sub.CA = string(ca)
+	}
+	return sub, true, nil
+}
+
+// readSecretKey reads a Secret key, defaulting the key like the CRD
+func (m *MACMapper) readSecretKey(ctx context.Context, ref wolv1beta1.SecretKeyReference) ([]byte, error) {
+	key := ref.Key
+	if key == "" {
+		key = defaultSecureOnSecretKey
+	}
+	secret := &corev1.Secret{}
+	if err := m.secretReader.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, secret); err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	value, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s has no key %q", ref.Namespace, ref.Name, key)
+	}
+	return value, nil
+}
+
+// mqttSubscriptions returns the MQTT triggers by WolConfig
+func (m *MACMapper) mqttSubscriptions() map[string]mqttSubscription {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return maps.Clone(m.mqttTriggers)
+}
+
+// ---------------------------------------------------------------------------
+// Subscriptions
+// ---------------------------------------------------------------------------
+
+// MQTTTriggers keeps the MQTT subscriptions of the WolConfigs in sync with the
+// mapping and wakes the VMs their messages ask for
+type MQTTTriggers struct {
+	mapper     *MACMapper
+	aggregator *Aggregator
+	log        logr.Logger
+}
+
+// NewMQTTTriggers creates the MQTT subscriptions of the manager
+func NewMQTTTriggers(mapper *MACMapper, aggregator *Aggregator, log logr.Logger) *MQTTTriggers {
+	return &MQTTTriggers{mapper: mapper, aggregator: aggregator, log: log}
+}
+
+// Run subscribes until ctx is done, restarting the subscriptions changed by
+// every mapping refresh
+func (t *MQTTTriggers) Run(ctx context.Context) {
+	type running struct {
+		sub    mqttSubscription
+		cancel context.CancelFunc
+		done   chan struct{}
+	}
+	subs := make(map[string]running)
+	stop := func(r running) {
+		r.cancel()
+		<-r.done
+	}
+	defer func() {
+		for _, r := range subs {
+			stop(r)
+		}
+	}()
+
+	for {
+		changed := t.mapper.mappingChange()
+		desired := t.mapper.mqttSubscriptions()
+		for name, r := range subs {
+			if sub, ok := desired[name]; !ok || sub != r.sub {
+				stop(r)
+				delete(subs, name)
+			}
+		}
+		for name, sub := range desired {
+			if _, ok := subs[name]; ok {
+				continue
+			}
+			subCtx, cancel := context.WithCancel(ctx)
+			r := running{sub: sub, cancel: cancel, done: make(chan struct{})}
+			subs[name] = r
+			go func() {
+				defer close(r.done)
+				t.subscribe(subCtx, name, sub)
+			}()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+// subscribe keeps the subscription of a WolConfig connected until ctx is done
+func (t *MQTTTriggers) subscribe(ctx context.Context, configName string, sub mqttSubscription) {
+	log := t.log.WithValues("config", configName, "broker", sub.Broker, "topic", sub.Topic)
+	connected := MQTTConnected.WithLabelValues(configName)
+	defer MQTTConnected.DeleteLabelValues(configName)
+
+	backoff := mqttMinBackoff
+	for {
+		connected.Set(0)
+		started := time.Now()
+		err := runMQTTSession(ctx, sub, func() {
+			connected.Set(1)
+			log.Info("Subscribed to the MQTT wake topic")
+		}, func(topic string, payload []byte, retained bool) {
+			t.handleMessage(ctx, configName, topic, payload, retained)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		// Una sessione rimasta su a lungo riparte dal backoff minimo
+		if time.Since(started) > mqttMaxBackoff {
+			backoff = mqttMinBackoff
+		}
+		log.Error(err, "MQTT subscription lost, reconnecting", "retryIn", backoff)
+		ErrorsTotal.Inc()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, mqttMaxBackoff)
+	}
+}
+
+// handleMessage requests the wake a message on the topic of a WolConfig asks for
+func (t *MQTTTriggers) handleMessage(ctx context.Context, configName, topic string, payload []byte, retained bool) {
+	if retained {
+		MQTTMessagesTotal.WithLabelValues(configName, "retained").Inc()
+		return
+	}
+	req, ok := parseMQTTWake(payload)
+	if !ok {
+		MQTTMessagesTotal.WithLabelValues(configName, "invalid").Inc()
+		t.log.V(1).Info("Ignoring MQTT message that is not a wake", "config", configName, "topic", topic)
+		return
+	}
+	info, found := req.resolve(t.mapper, configName)
+	if !found {
+		MQTTMessagesTotal.WithLabelValues(configName, "unknown").Inc()
+		t.log.Info("MQTT wake for a VM not mapped by the WolConfig", "config", configName, "topic", topic,
+			"target", req.String())
+		return
+	}
+
+	MQTTMessagesTotal.WithLabelValues(configName, "wake").Inc()
+	resp, _ := t.aggregator.RequestWake(ctx, &wolv1.WakeRequest{
+		Namespace: info.Namespace,
+		Name:      info.Name,
+		Source:    MQTTWakeSource,
+		Reason:    "mqtt message on " + topic,
+	})
+	t.log.Info("MQTT wake requested", "config", configName, "topic", topic,
+		"vm", info.Name, "namespace", info.Namespace, "status", resp.Status.String())
+}
+
+// parseMQTTWake parses a wake message: the JSON of a wake hook request, or a bare MAC
+func parseMQTTWake(payload []byte) (wakeTargetRequest, bool) {
+	payload = bytes.TrimSpace(payload)
+	if len(payload) == 0 || len(payload) > mqttMaxMessage {
+		return wakeTargetRequest{}, false
+	}
+	var req wakeTargetRequest
+	if payload[0] == '{' {
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return wakeTargetRequest{}, false
+		}
+		return req, req.valid()
+	}
+	if _, ok := parseMACKey(string(payload)); !ok {
+		return wakeTargetRequest{}, false
+	}
+	return wakeTargetRequest{MAC: string(payload)}, true
+}
+
+// ---------------------------------------------------------------------------
+// MQTT 3.1.1 client
+// ---------------------------------------------------------------------------
+
+// runMQTTSession connects to the broker of sub and subscribes to its topic,
+// calling connected once subscribed and handle for every message received,
+// until ctx is done or the connection fails
+func runMQTTSession(ctx context.Context, sub mqttSubscription, connected func(),
+	handle func(topic string, payload []byte, retained bool)) error {
+	dialer := &net.Dialer{Timeout: mqttConnectTimeout}
+	var conn net.Conn
+	var err error
+	if sub.TLS {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if sub.CA != "" {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			tlsConfig.RootCAs.AppendCertsFromPEM([]byte(sub.CA))
+		}
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", sub.Broker)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", sub.Broker)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+	session := &mqttConn{conn: conn, reader: bufio.NewReader(conn)}
+	defer conn.Close()
+	// La cancellazione del contesto sblocca le letture chiudendo la connessione
+	stopClose := context.AfterFunc(ctx, func() {
+		_ = session.write(mqttDisconnect<<4, nil)
+		_ = conn.Close()
+	})
+	defer stopClose()
+
+	_ = conn.SetDeadline(time.Now().Add(mqttConnectTimeout))
+	if err := session.connect(sub); err != nil {
+		return err
+	}
+	if err := session.subscribe(sub.Topic); err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	connected()
+
+	done := make(chan struct{})
+	defer close(done)
+	go session.keepAlive(done)
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(mqttKeepAlive * 3 / 2))
+		header, body, err := session.read()
+		if err != nil {
+			return err
+		}
+		if header>>4 != mqttPublish || body == nil {
+			continue // PINGRESP, o pacchetti troppo grandi
+		}
+		topic, id, payload, ok := parsePublish(header, body)
+		if !ok {
+			return fmt.Errorf("malformed PUBLISH packet")
+		}
+		if qos := (header >> 1) & 3; qos == 1 {
+			if err := session.write(mqttPuback<<4, binary.BigEndian.AppendUint16(nil, id)); err != nil {
+				return err
+			}
+		}
+		handle(topic, payload, header&1 == 1)
+	}
+}
+
+// mqttConn is an MQTT connection. Writes are serialized, since the keep
+// alive and the acknowledgments of the reader share the connection.
+type mqttConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+}
+
+// write sends a packet with the given fixed header byte and body
+func (c *mqttConn) write(header byte, body []byte) error {
+	packet := appendMQTTLength([]byte{header}, len(body))
+	packet = append(packet, body...)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+// read returns the next packet; the body is nil for packets over mqttMaxPacket,
+// which are skipped
+func (c *mqttConn) read() (byte, []byte, error) {
+	header, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var length int
+	for i := 0; ; i++ {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed MQTT remaining length")
+		}
+	}
+	if length > mqttMaxPacket {
+		_, err := io.CopyN(io.Discard, c.reader, int64(length))
+		return header, nil, err
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// connect sends CONNECT and checks the CONNACK
+func (c *mqttConn) connect(sub mqttSubscription) error {
+	flags := byte(0x02) // clean session
+	if sub.Username != "" {
+		flags |= 0x80
+		if sub.Password != "" {
+			flags |= 0x40
+		}
+	}
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4, flags) // livello di protocollo 4 (3.1.1)
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = appendMQTTString(body, sub.ClientID)
+	if flags&0x80 != 0 {
+		body = appendMQTTString(body, sub.Username)
+	}
+	if flags&0x40 != 0 {
+		body = appendMQTTString(body, sub.Password)
+	}
+	if err := c.write(mqttConnect<<4, body); err != nil {
+		return fmt.Errorf("failed to send CONNECT: %w", err)
+	}
+
+	header, ack, err := c.read()
+	if err != nil {
+		return fmt.Errorf("failed to read CONNACK: %w", err)
+	}
+	if header>>4 != mqttConnack || len(ack) != 2 {
+		return fmt.Errorf("unexpected MQTT packet type %d instead of CONNACK", header>>4)
+	}
+	if ack[1] != 0 {
+		reason, ok := mqttConnackErrors[ack[1]]
+		if !ok {
+			reason = fmt.Sprintf("return code %d", ack[1])
+		}
+		return fmt.Errorf("MQTT connection refused: %s", reason)
+	}
+	return nil
+}
+
+// subscribe subscribes to topic with QoS 1 and checks the SUBACK
+func (c *mqttConn) subscribe(topic string) error {
+	const packetID = 1
+	body := binary.BigEndian.AppendUint16(nil, packetID)
+	body = appendMQTTString(body, topic)
+	body = append(body, 1)
+	if err := c.write(mqttSubscribe<<4|0x02, body); err != nil {
+		return fmt.Errorf("failed to send SUBSCRIBE: %w", err)
+	}
+
+	header, ack, err := c.read()
+	if err != nil {
+		return fmt.Errorf("failed to read SUBACK: %w", err)
+	}
+	if header>>4 != mqttSuback || len(ack) != 3 || binary.BigEndian.Uint16(ack) != packetID {
+		return fmt.Errorf("unexpected MQTT packet type %d instead of SUBACK", header>>4)
+	}
+	if ack[2] == 0x80 {
+		return fmt.Errorf("MQTT subscription to %q refused by the broker", topic)
+	}
+	return nil
+}
+
+// keepAlive sends a PINGREQ every half keep alive until done is closed
+func (c *mqttConn) keepAlive(done <-chan struct{}) {
+	ticker := time.NewTicker(mqttKeepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.write(mqttPingreq<<4, nil); err != nil {
+				return // il reader vede la connessione chiusa
+			}
+		}
+	}
+}
+
+// parsePublish splits the body of a PUBLISH packet
+func parsePublish(header byte, body []byte) (topic string, id uint16, payload []byte, ok bool) {
+	if len(body) < 2 {
+		return "", 0, nil, false
+	}
+	length := int(binary.BigEndian.Uint16(body))
+	if 2+length > len(body) {
+		return "", 0, nil, false
+	}
+	topic, body = string(body[2:2+length]), body[2+length:]
+	if (header>>1)&3 > 0 {
+		if len(body) < 2 {
+			return "", 0, nil, false
+		}
+		id, body = binary.BigEndian.Uint16(body), body[2:]
+	}
+	return topic, id, body, true
+}
+
+// appendMQTTString appends a length-prefixed UTF-8 string
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// appendMQTTLength appends the variable-length encoding of the remaining length
+func appendMQTTLength(b []byte, length int) []byte {
+	for {
+		digit := byte(length & 0x7f)
+		length >>= 7
+		if length > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if length == 0 {
+			return b
+		}
+	}
+}
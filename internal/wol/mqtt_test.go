@@ -0,0 +1,257 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+type mqttMessage struct {
+	topic    string
+	payload  string
+	retained bool
+}
+
+// fakeBroker accepts one MQTT client, answers its CONNECT with returnCode and,
+// if accepted, its SUBSCRIBE, then publishes packets and closes the
+// connection. The CONNECT and SUBSCRIBE bodies and the packets received
+// afterwards are sent to received.
+func fakeBroker(t *testing.T, returnCode byte, packets [][]byte) (string, <-chan []byte) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	received := make(chan []byte, 16)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		broker := &mqttConn{conn: conn, reader: bufio.NewReader(conn)}
+
+		_, connect, err := broker.read()
+		if err != nil {
+			return
+		}
+		received <- connect
+		_ = broker.write(mqttConnack<<4, []byte{0, returnCode})
+		if returnCode != 0 {
+			return
+		}
+		_, subscribe, err := broker.read()
+		if err != nil {
+			return
+		}
+		received <- subscribe
+		_ = broker.write(mqttSuback<<4, append(subscribe[:2:2], 1))
+		for _, packet := range packets {
+			_ = broker.write(packet[0], packet[1:])
+		}
+		// Aspetta gli ack dei messaggi QoS 1
+		if header, body, err := broker.read(); err == nil {
+			received <- append([]byte{header}, body...)
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func publishPacket(topic, payload string, qos byte, retained bool, id uint16) []byte {
+	header := byte(mqttPublish<<4) | qos<<1
+	if retained {
+		header |= 1
+	}
+	packet := appendMQTTString([]byte{header}, topic)
+	if qos > 0 {
+		packet = binary.BigEndian.AppendUint16(packet, id)
+	}
+	return append(packet, payload...)
+}
+
+func TestRunMQTTSession(t *testing.T) {
+	addr, received := fakeBroker(t, 0, [][]byte{
+		publishPacket("kubevirt-wol/wake", `{"mac":"52:54:00:00:00:01"}`, 0, true, 0),
+		publishPacket("kubevirt-wol/wake", "52:54:00:00:00:01", 1, false, 7),
+	})
+	sub := mqttSubscription{Broker: addr, Topic: "kubevirt-wol/#", ClientID: "kubevirt-wol-lab",
+		Username: "wol", Password: "s3cret"}
+
+	var messages []mqttMessage
+	connected := false
+	err := runMQTTSession(context.Background(), sub, func() { connected = true },
+		func(topic string, payload []byte, retained bool) {
+			messages = append(messages, mqttMessage{topic, string(payload), retained})
+		})
+	if err == nil {
+		t.Fatal("Expected the session to end with the connection")
+	}
+	if !connected {
+		t.Error("Expected the session to report the subscription")
+	}
+
+	connect := <-received
+	for _, field := range []string{"MQTT", "kubevirt-wol-lab", "wol", "s3cret"} {
+		if !bytes.Contains(connect, appendMQTTString(nil, field)) {
+			t.Errorf("Expected %q in CONNECT % x", field, connect)
+		}
+	}
+	if flags := connect[7]; flags != 0xc2 {
+		t.Errorf("Expected clean session, username and password flags, got %#x", flags)
+	}
+	if subscribe := <-received; !bytes.Contains(subscribe, append(appendMQTTString(nil, "kubevirt-wol/#"), 1)) {
+		t.Errorf("Expected a QoS 1 subscription to the topic, got % x", subscribe)
+	}
+	if ack := <-received; !bytes.Equal(ack, []byte{mqttPuback << 4, 0, 7}) {
+		t.Errorf("Expected the PUBACK of packet 7, got % x", ack)
+	}
+
+	want := []mqttMessage{
+		{"kubevirt-wol/wake", `{"mac":"52:54:00:00:00:01"}`, true},
+		{"kubevirt-wol/wake", "52:54:00:00:00:01", false},
+	}
+	if len(messages) != len(want) || messages[0] != want[0] || messages[1] != want[1] {
+		t.Errorf("Expected messages %+v, got %+v", want, messages)
+	}
+}
+
+func TestRunMQTTSession_Refused(t *testing.T) {
+	addr, _ := fakeBroker(t, 4, nil)
+	err := runMQTTSession(context.Background(), mqttSubscription{Broker: addr, Topic: "wake", ClientID: "c"},
+		func() { t.Error("Expected no subscription") }, func(string, []byte, bool) {})
+	if err == nil || !strings.Contains(err.Error(), "bad username or password") {
+		t.Errorf("Expected the connection to be refused, got %v", err)
+	}
+}
+
+func TestAppendMQTTLength(t *testing.T) {
+	tests := map[int][]byte{
+		0:       {0x00},
+		127:     {0x7f},
+		128:     {0x80, 0x01},
+		16383:   {0xff, 0x7f},
+		2097152: {0x80, 0x80, 0x80, 0x01},
+	}
+	for length, want := range tests {
+		if got := appendMQTTLength(nil, length); !bytes.Equal(got, want) {
+			t.Errorf("Length %d: expected % x, got % x", length, want, got)
+		}
+	}
+}
+
+func TestParseMQTTWake(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    wakeTargetRequest
+		ok      bool
+	}{
+		{`{"mac":"52:54:00:00:00:01"}`, wakeTargetRequest{MAC: "52:54:00:00:00:01"}, true},
+		{` {"vm":"vm1","namespace":"team-a"}` + "\n", wakeTargetRequest{VM: "vm1", Namespace: "team-a"}, true},
+		{"52-54-00-00-00-01\n", wakeTargetRequest{MAC: "52-54-00-00-00-01"}, true},
+		{`{"vm":"vm1"}`, wakeTargetRequest{}, false},
+		{`{"mac":`, wakeTargetRequest{}, false},
+		{"ON", wakeTargetRequest{}, false},
+		{"", wakeTargetRequest{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseMQTTWake([]byte(tt.payload))
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("Payload %q: expected %+v (ok=%v), got %+v (ok=%v)", tt.payload, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestMQTTTriggers(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "mqtt", Namespace: "kubevirt-wol"},
+		Data:       map[string][]byte{"password": []byte("s3cret\n")},
+	}
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+	mapper.SetSecretReader(fake.NewClientBuilder().WithObjects(secret).Build())
+	lab := wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:01", VMName: "vm1", Namespace: "team-a"},
+			},
+			WakeTriggers: &wolv1beta1.WakeTriggersSpec{MQTT: &wolv1beta1.MQTTTriggerSpec{
+				BrokerURL: "mqtts://broker.example.com",
+				Username:  "wol",
+				PasswordSecretRef: &wolv1beta1.SecretKeyReference{
+					Name: "mqtt", Namespace: "kubevirt-wol"},
+			}},
+		},
+	}
+	lab.Name = "lab"
+	other := wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:02", VMName: "vm2", Namespace: "team-b"},
+			},
+			// Password senza username: il trigger non viene caricato
+			WakeTriggers: &wolv1beta1.WakeTriggersSpec{MQTT: &wolv1beta1.MQTTTriggerSpec{
+				BrokerURL: "tcp://broker:1884",
+				PasswordSecretRef: &wolv1beta1.SecretKeyReference{
+					Name: "mqtt", Namespace: "kubevirt-wol"},
+			}},
+		},
+	}
+	other.Name = "other"
+	mapper.UpdateConfigs([]wolv1beta1.WolConfig{lab, other})
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	subs := mapper.mqttSubscriptions()
+	want := mqttSubscription{Broker: "broker.example.com:8883", TLS: true, Topic: defaultMQTTTopic,
+		ClientID: "kubevirt-wol-lab", Username: "wol", Password: "s3cret"}
+	if len(subs) != 1 || subs["lab"] != want {
+		t.Errorf("Expected only the subscription %+v, got %+v", want, subs)
+	}
+
+	starter := &policyStarter{actions: make(map[string]string)}
+	triggers := NewMQTTTriggers(mapper, NewAggregator(mapper, starter, logr.Discard()), logr.Discard())
+	ctx := context.Background()
+	triggers.handleMessage(ctx, "lab", "kubevirt-wol/wake", []byte("52:54:00:00:00:01"), true)
+	triggers.handleMessage(ctx, "lab", "kubevirt-wol/wake", []byte(`{"vm":"vm2","namespace":"team-b"}`), false)
+	triggers.handleMessage(ctx, "lab", "kubevirt-wol/wake", []byte("ON"), false)
+	if len(starter.actions) != 0 {
+		t.Fatalf("Expected retained, out of scope and invalid messages to wake nothing, got %v", starter.actions)
+	}
+	triggers.handleMessage(ctx, "lab", "kubevirt-wol/wake", []byte(`{"vm":"vm1","namespace":"team-a"}`), false)
+	if starter.actions["vm1"] != "start" {
+		t.Errorf("Expected vm1 to be started, got %v", starter.actions)
+	}
+}
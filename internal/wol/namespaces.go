@@ -0,0 +1,92 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// Besides the names in NamespaceSelectors, a WolConfig selects the namespaces
+// of its VMs by their labels with NamespaceLabelSelector. The matching
+// namespaces are resolved on every refresh; the WolConfig controller refreshes
+// the mapping when a namespace is created or relabeled.
+
+// resolveLabelNamespaces lists the namespaces matching the
+// NamespaceLabelSelector of each config. A config whose namespaces cannot be
+// listed keeps the ones resolved before. m.refreshMu must be held.
+func (m *MACMapper) resolveLabelNamespaces(ctx context.Context, configs []wolv1beta1.WolConfig) {
+	resolved := make(map[string]map[string]bool)
+	for i := range configs {
+		config := &configs[i]
+		if config.Spec.NamespaceLabelSelector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(config.Spec.NamespaceLabelSelector)
+		if err != nil {
+			// Un selettore non valido non seleziona alcun namespace
+			m.log.Error(err, "Invalid namespace label selector", "config", config.Name)
+			ErrorsTotal.Inc()
+			resolved[config.Name] = nil
+			continue
+		}
+		nsList := &corev1.NamespaceList{}
+		if err := m.client.List(ctx, nsList, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			m.log.Error(err, "Failed to list namespaces by label, keeping the ones known", "config", config.Name)
+			ErrorsTotal.Inc()
+			resolved[config.Name] = m.labelNamespaces[config.Name]
+			continue
+		}
+		namespaces := make(map[string]bool, len(nsList.Items))
+		for _, ns := range nsList.Items {
+			namespaces[ns.Name] = true
+		}
+		resolved[config.Name] = namespaces
+	}
+	m.labelNamespaces = resolved
+}
+
+// configNamespaces returns the namespaces watched by the config, sorted, or
+// all true if it watches every namespace. m.refreshMu must be held.
+func (m *MACMapper) configNamespaces(config *wolv1beta1.WolConfig) ([]string, bool) {
+	if config.Spec.NamespaceLabelSelector == nil {
+		return config.Spec.NamespaceSelectors, len(config.Spec.NamespaceSelectors) == 0
+	}
+	namespaces := slices.Clone(config.Spec.NamespaceSelectors)
+	for ns := range m.labelNamespaces[config.Name] {
+		if !slices.Contains(namespaces, ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	slices.Sort(namespaces)
+	return namespaces, false
+}
+
+// configWatchesNamespace returns true if the config watches the VMs of the
+// namespace. m.refreshMu must be held.
+func (m *MACMapper) configWatchesNamespace(config *wolv1beta1.WolConfig, namespace string) bool {
+	if len(config.Spec.NamespaceSelectors) == 0 && config.Spec.NamespaceLabelSelector == nil {
+		return true
+	}
+	return slices.Contains(config.Spec.NamespaceSelectors, namespace) || m.labelNamespaces[config.Name][namespace]
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+func newNamespaceVM(namespace, name, mac string) *kubevirtv1.VirtualMachine {
+	vm := newWatchVM(name, nil, mac)
+	vm.Namespace = namespace
+	return vm
+}
+
+func newTestNamespace(name string, nsLabels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nsLabels}}
+}
+
+func TestMACMapper_NamespaceLabelSelector(t *testing.T) {
+	c := newPolicyClient(t,
+		newTestNamespace("lab-1", map[string]string{"env": "lab"}),
+		newTestNamespace("prod", map[string]string{"env": "prod"}),
+		newTestNamespace("tenant", nil),
+		newNamespaceVM("lab-1", "vm1", "52:54:00:00:00:01"),
+		newNamespaceVM("prod", "vm2", "52:54:00:00:00:02"),
+		newNamespaceVM("tenant", "vm3", "52:54:00:00:00:03"))
+	mapper := NewMACMapper(c, logr.Discard())
+	config := conflictTestConfig("lab", 0, 0, "")
+	config.Spec.NamespaceSelectors = []string{"tenant"}
+	config.Spec.NamespaceLabelSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"env": "lab"}}
+	mapper.UpdateConfig(&config)
+	ctx := context.Background()
+	if err := mapper.RefreshMapping(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for mac, want := range map[string]bool{"52:54:00:00:00:01": true, "52:54:00:00:00:02": false, "52:54:00:00:00:03": true} {
+		if _, found := mapper.Lookup(mac); found != want {
+			t.Errorf("Expected %s mapped=%v, got %v", mac, want, found)
+		}
+	}
+
+	// Un namespace creato dopo con le label giuste viene osservato dal refresh
+	if err := c.Create(ctx, newTestNamespace("lab-2", map[string]string{"env": "lab"})); err != nil {
+		t.Fatal(err)
+	}
+	vm4 := newNamespaceVM("lab-2", "vm4", "52:54:00:00:00:04")
+	if err := c.Create(ctx, vm4); err != nil {
+		t.Fatal(err)
+	}
+	if err := mapper.RefreshMapping(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info, found := mapper.Lookup("52:54:00:00:00:04"); !found || info.Namespace != "lab-2" {
+		t.Errorf("Expected vm4 of the new namespace to be mapped, got %+v (found=%v)", info, found)
+	}
+
+	// Le VM dei namespace selezionati si aggiornano senza refresh
+	mapper.ApplyVM(ctx, newNamespaceVM("lab-2", "vm5", "52:54:00:00:00:05"))
+	mapper.ApplyVM(ctx, newNamespaceVM("prod", "vm6", "52:54:00:00:00:06"))
+	if _, found := mapper.Lookup("52:54:00:00:00:05"); !found {
+		t.Error("Expected a VM of a labeled namespace to be mapped when applied")
+	}
+	if _, found := mapper.Lookup("52:54:00:00:00:06"); found {
+		t.Error("Expected a VM of an unselected namespace not to be mapped when applied")
+	}
+}
+
+func TestMACMapper_NamespaceLabelSelectorNoMatch(t *testing.T) {
+	c := newPolicyClient(t, newTestNamespace("prod", map[string]string{"env": "prod"}),
+		newNamespaceVM("prod", "vm1", "52:54:00:00:00:01"))
+	mapper := NewMACMapper(c, logr.Discard())
+	config := conflictTestConfig("lab", 0, 0, "")
+	config.Spec.DiscoveryMode = wolv1beta1.DiscoveryModeLabelSelector
+	config.Spec.VMSelector = &metav1.LabelSelector{}
+	config.Spec.NamespaceLabelSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"env": "lab"}}
+	mapper.UpdateConfig(&config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Nessun namespace selezionato non vuol dire tutti i namespace
+	if mapper.GetMappingCount() != 0 {
+		t.Errorf("Expected no VM mapped without a matching namespace, got %d", mapper.GetMappingCount())
+	}
+}
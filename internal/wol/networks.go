@@ -0,0 +1,291 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// NetworkAttachmentDefinitionGVK is the Multus NetworkAttachmentDefinition kind.
+// It is read as unstructured so the operator works on clusters without Multus.
+var NetworkAttachmentDefinitionGVK = schema.GroupVersionKind{
+	Group:   "k8s.cni.cncf.io",
+	Version: "v1",
+	Kind:    "NetworkAttachmentDefinition",
+}
+
+// AnnotationNADResourceName is the Multus annotation declaring the node resource
+// a NetworkAttachmentDefinition requires
+const AnnotationNADResourceName = "k8s.v1.cni.cncf.io/resourceName"
+
+// markerResourcePrefixes are the node resources advertised by bridge-marker and
+// ovs-cni for each bridge. They have a large capacity and only tell where a
+// bridge exists, so the agents can request them without consuming devices.
+var markerResourcePrefixes = []string{
+	"bridge.network.kubevirt.io/",
+	"ovs-cni.network.kubevirt.io/",
+}
+
+// NetworkAttachment is a NetworkAttachmentDefinition used by managed VMs
+type NetworkAttachment struct {
+	Namespace string
+	Name      string
+	// Type is the CNI plugin type (bridge, ovs, macvlan, ...)
+	Type string
+	// Interface is the host interface the VM traffic goes through (bridge or master)
+	Interface string
+	// VLAN is the VLAN tag configured on the attachment (0 = none)
+	VLAN int
+	// ResourceName is the node resource required by the attachment
+	ResourceName string
+}
+
+// Key returns the <namespace>/<name> form of the attachment
+func (n NetworkAttachment) Key() string {
+	return n.Namespace + "/" + n.Name
+}
+
+// addNetworks records the Multus networks used by a VM selected by the given config
+func (b *mappingBuilder) addNetworks(configName string, vm *kubevirtv1.VirtualMachine) {
+	if vm.Spec.Template == nil {
+		return
+	}
+	for _, network := range vm.Spec.Template.Spec.Networks {
+		if network.Multus == nil || network.Multus.NetworkName == "" {
+			continue
+		}
+		if b.networks[configName] == nil {
+			b.networks[configName] = make(map[string]bool)
+		}
+		b.networks[configName][multusNetworkKey(vm.Namespace, network.Multus.NetworkName)] = true
+	}
+}
+
+// multusNetworkKey resolves a Multus networkName (<name> or <namespace>/<name>)
+// to the <namespace>/<name> of its NetworkAttachmentDefinition
+func multusNetworkKey(vmNamespace, networkName string) string {
+	if strings.Contains(networkName, "/") {
+		return networkName
+	}
+	return vmNamespace + "/" + networkName
+}
+
+// resolveNetworkAttachments reads the NetworkAttachmentDefinitions referenced by
+// the VMs of each config. Attachments that cannot be read are kept without
+// interface details, so that the status still lists them.
+func (m *MACMapper) resolveNetworkAttachments(ctx context.Context, refs map[string]map[string]bool) map[string][]NetworkAttachment {
+	if len(refs) == 0 {
+		return nil
+	}
+
+	cache := make(map[string]NetworkAttachment)
+	missingCRD := false
+	result := make(map[string][]NetworkAttachment, len(refs))
+	for configName, keys := range refs {
+		for key := range keys {
+			attachment, found := cache[key]
+			if !found {
+				namespace, name, _ := strings.Cut(key, "/")
+				attachment = NetworkAttachment{Namespace: namespace, Name: name}
+				if !missingCRD {
+					nad := &unstructured.Unstructured{}
+					nad.SetGroupVersionKind(NetworkAttachmentDefinitionGVK)
+					err := m.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, nad)
+					switch {
+					case meta.IsNoMatchError(err):
+						m.log.V(1).Info("NetworkAttachmentDefinition CRD not installed, skipping interface hints")
+						missingCRD = true
+					case err != nil:
+						m.log.V(1).Info("Failed to read NetworkAttachmentDefinition", "nad", key, "error", err.Error())
+					default:
+						config, _, _ := unstructured.NestedString(nad.Object, "spec", "config")
+						attachment.Type, attachment.Interface, attachment.VLAN = parseNADConfig(config)
+						attachment.ResourceName = nad.GetAnnotations()[AnnotationNADResourceName]
+					}
+				}
+				cache[key] = attachment
+			}
+			result[configName] = append(result[configName], attachment)
+		}
+		sort.Slice(result[configName], func(i, j int) bool {
+			return result[configName][i].Key() < result[configName][j].Key()
+		})
+	}
+	return result
+}
+
+// cniConfig holds the CNI configuration fields that tell where the VM traffic goes
+type cniConfig struct {
+	Type    string      `json:"type"`
+	Bridge  string      `json:"bridge"`
+	Master  string      `json:"master"`
+	VLAN    int         `json:"vlan"`
+	VLANID  int         `json:"vlanId"`
+	Plugins []cniConfig `json:"plugins"`
+}
+
+// parseNADConfig extracts the plugin type, host interface and VLAN from the CNI
+// configuration of a NetworkAttachmentDefinition (single plugin or conflist)
+func parseNADConfig(config string) (pluginType, iface string, vlan int) {
+	var parsed cniConfig
+	if err := json.Unmarshal([]byte(config), &parsed); err != nil {
+		return "", "", 0
+	}
+
+	plugins := append([]cniConfig{parsed}, parsed.Plugins...)
+	for _, plugin := range plugins {
+		iface = plugin.Bridge
+		if iface == "" {
+			iface = plugin.Master
+		}
+		if iface == "" && plugin.Type == "bridge" {
+			iface = "cni0" // default of the bridge plugin
+		}
+		if iface == "" {
+			continue
+		}
+		vlan = plugin.VLAN
+		if vlan == 0 {
+			vlan = plugin.VLANID
+		}
+		return plugin.Type, iface, vlan
+	}
+	if len(parsed.Plugins) > 0 {
+		return parsed.Plugins[0].Type, "", 0
+	}
+	return parsed.Type, "", 0
+}
+
+// NetworkAttachments returns the NetworkAttachmentDefinitions used by the VMs of
+// the given WolConfig during the last refresh (all configs if configName is empty)
+func (m *MACMapper) NetworkAttachments(configName string) []NetworkAttachment {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if configName != "" {
+		return append([]NetworkAttachment(nil), m.networkAttachments[configName]...)
+	}
+
+	seen := make(map[string]bool)
+	var result []NetworkAttachment
+	for _, attachments := range m.networkAttachments {
+		for _, attachment := range attachments {
+			if !seen[attachment.Key()] {
+				seen[attachment.Key()] = true
+				result = append(result, attachment)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key() < result[j].Key() })
+	return result
+}
+
+// SchedulingResources returns the bridge marker resources required by every
+// attachment, which restrict the agents to the nodes providing the bridges.
+// A pod must get all the resources it requests, so a resource needed by only
+// some attachments would keep the agents off the nodes that provide just the
+// other bridges: in that case (or when an attachment has no marker) nothing
+// is returned and the agents are not restricted.
+func SchedulingResources(attachments []NetworkAttachment) []string {
+	var common string
+	for _, attachment := range attachments {
+		name := attachment.ResourceName
+		if !isMarkerResource(name) || (common != "" && name != common) {
+			return nil
+		}
+		common = name
+	}
+	if common == "" {
+		return nil
+	}
+	return []string{common}
+}
+
+// isMarkerResource returns true if the resource is exposed by bridge-marker or ovs-cni
+func isMarkerResource(name string) bool {
+	for _, prefix := range markerResourcePrefixes {
+		if name != "" && strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Node annotations overriding the interface rules of the agent on the node:
+// comma-separated shell patterns (e.g. "eth1,br-wol*")
+const (
+	AnnotationInterfaceInclude = "wol.pillon.org/interface-include"
+	AnnotationInterfaceExclude = "wol.pillon.org/interface-exclude"
+)
+
+// nodeInterfaceRules returns the interface rules of the node annotations, or
+// nil if the node has none or they are invalid
+func (a *Aggregator) nodeInterfaceRules(ctx context.Context, nodeName string) *wolv1.InterfaceRules {
+	if a.nodes == nil || nodeName == "" {
+		return nil
+	}
+	node := &corev1.Node{}
+	if err := a.nodes.Get(ctx, client.ObjectKey{Name: nodeName}, node); err != nil {
+		return nil
+	}
+	include, hasInclude := node.Annotations[AnnotationInterfaceInclude]
+	exclude, hasExclude := node.Annotations[AnnotationInterfaceExclude]
+	if !hasInclude && !hasExclude {
+		return nil
+	}
+	rules, err := ParseInterfaceRules(include, exclude)
+	if err != nil {
+		a.log.Error(err, "Ignoring the interface rules of the node", "node", nodeName)
+		return nil
+	}
+	return &wolv1.InterfaceRules{Include: rules.Include, Exclude: rules.Exclude}
+}
+
+// GetInterfaceHints implementa il metodo gRPC usato dagli agent per scegliere le interfacce
+func (a *Aggregator) GetInterfaceHints(ctx context.Context, req *wolv1.InterfaceHintsRequest) (*wolv1.InterfaceHintsResponse, error) {
+	attachments := a.mapper.AgentNetworkAttachments(req.WolConfig)
+	resp := &wolv1.InterfaceHintsResponse{Attachments: make([]*wolv1.NetworkAttachment, 0, len(attachments))}
+	seen := make(map[string]bool)
+	for _, attachment := range attachments {
+		resp.Attachments = append(resp.Attachments, &wolv1.NetworkAttachment{
+			Namespace: attachment.Namespace,
+			Name:      attachment.Name,
+			Type:      attachment.Type,
+			Interface: attachment.Interface,
+			Vlan:      uint32(attachment.VLAN),
+		})
+		if attachment.Interface != "" && !seen[attachment.Interface] {
+			seen[attachment.Interface] = true
+			resp.Interfaces = append(resp.Interfaces, attachment.Interface)
+		}
+	}
+	resp.NodeRules = a.nodeInterfaceRules(ctx, req.NodeName)
+	a.log.V(1).Info("Interface hints requested", "node", req.NodeName, "wolconfig", req.WolConfig, "interfaces", resp.Interfaces)
+	return resp, nil
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestParseNADConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		wantType  string
+		wantIface string
+		wantVLAN  int
+	}{
+		{"bridge", `{"cniVersion":"0.3.1","type":"bridge","bridge":"br1","vlan":100}`, "bridge", "br1", 100},
+		{"bridge default", `{"type":"bridge"}`, "bridge", "cni0", 0},
+		{"ovs", `{"type":"ovs","bridge":"br-vms","vlan":20}`, "ovs", "br-vms", 20},
+		{"macvlan", `{"type":"macvlan","master":"eth1"}`, "macvlan", "eth1", 0},
+		{"vlan", `{"type":"vlan","master":"eth0","vlanId":30}`, "vlan", "eth0", 30},
+		{"conflist", `{"name":"net","plugins":[{"type":"cnv-bridge","bridge":"br2"},{"type":"tuning"}]}`, "cnv-bridge", "br2", 0},
+		{"sriov", `{"type":"sriov"}`, "sriov", "", 0},
+		{"invalid", `not json`, "", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ, iface, vlan := parseNADConfig(tt.config)
+			if typ != tt.wantType || iface != tt.wantIface || vlan != tt.wantVLAN {
+				t.Errorf("parseNADConfig() = %q, %q, %d; want %q, %q, %d",
+					typ, iface, vlan, tt.wantType, tt.wantIface, tt.wantVLAN)
+			}
+		})
+	}
+}
+
+func TestMultusNetworkKey(t *testing.T) {
+	if got := multusNetworkKey("vms", "br1-net"); got != "vms/br1-net" {
+		t.Errorf("Expected VM namespace to be used, got %s", got)
+	}
+	if got := multusNetworkKey("vms", "shared/br1-net"); got != "shared/br1-net" {
+		t.Errorf("Expected explicit namespace to be kept, got %s", got)
+	}
+}
+
+func TestResolveNetworkAttachments(t *testing.T) {
+	nad := &unstructured.Unstructured{}
+	nad.SetGroupVersionKind(NetworkAttachmentDefinitionGVK)
+	nad.SetNamespace("vms")
+	nad.SetName("br1-net")
+	nad.SetAnnotations(map[string]string{AnnotationNADResourceName: "bridge.network.kubevirt.io/br1"})
+	if err := unstructured.SetNestedField(nad.Object, `{"type":"cnv-bridge","bridge":"br1","vlan":10}`, "spec", "config"); err != nil {
+		t.Fatal(err)
+	}
+
+	mapper := NewMACMapper(fake.NewClientBuilder().WithObjects(nad).Build(), logr.Discard())
+	result := mapper.resolveNetworkAttachments(context.Background(), map[string]map[string]bool{
+		"lab": {"vms/br1-net": true, "vms/missing": true},
+	})
+
+	attachments := result["lab"]
+	if len(attachments) != 2 {
+		t.Fatalf("Expected 2 attachments, got %+v", attachments)
+	}
+	if a := attachments[0]; a.Key() != "vms/br1-net" || a.Interface != "br1" || a.VLAN != 10 || a.Type != "cnv-bridge" {
+		t.Errorf("Unexpected attachment %+v", a)
+	}
+	if a := attachments[1]; a.Key() != "vms/missing" || a.Interface != "" {
+		t.Errorf("Expected unreadable NAD to be kept without details, got %+v", a)
+	}
+}
+
+func TestSchedulingResources(t *testing.T) {
+	br1 := NetworkAttachment{ResourceName: "bridge.network.kubevirt.io/br1"}
+	br2 := NetworkAttachment{ResourceName: "bridge.network.kubevirt.io/br2"}
+	ovs := NetworkAttachment{ResourceName: "ovs-cni.network.kubevirt.io/br1"}
+	sriov := NetworkAttachment{ResourceName: "intel.com/sriov_netdevice"}
+
+	tests := []struct {
+		name        string
+		attachments []NetworkAttachment
+		want        []string
+	}{
+		{"no attachments", nil, nil},
+		{"single bridge", []NetworkAttachment{br1, br1}, []string{"bridge.network.kubevirt.io/br1"}},
+		{"ovs bridge", []NetworkAttachment{ovs}, []string{"ovs-cni.network.kubevirt.io/br1"}},
+		{"different bridges", []NetworkAttachment{br1, br2}, nil},
+		{"non marker resource", []NetworkAttachment{br1, sriov}, nil},
+		{"attachment without resource", []NetworkAttachment{br1, {}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SchedulingResources(tt.attachments)
+			if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAggregator_InterfaceHintsNodeRules(t *testing.T) {
+	annotated := &corev1.Node{}
+	annotated.Name = "worker-1"
+	annotated.Annotations = map[string]string{
+		AnnotationInterfaceInclude: "eth1,br-wol*",
+		AnnotationInterfaceExclude: "br-wol9",
+	}
+	invalid := &corev1.Node{}
+	invalid.Name = "worker-2"
+	invalid.Annotations = map[string]string{AnnotationInterfaceInclude: "eth["}
+	plain := &corev1.Node{}
+	plain.Name = "worker-3"
+
+	agg := NewAggregator(NewMACMapper(nil, logr.Discard()), NewVMStarter(nil, logr.Discard()), logr.Discard())
+	agg.SetNodeReader(fake.NewClientBuilder().WithObjects(annotated, invalid, plain).Build())
+
+	resp, err := agg.GetInterfaceHints(context.Background(), &wolv1.InterfaceHintsRequest{NodeName: "worker-1"})
+	if err != nil {
+		t.Fatalf("GetInterfaceHints: %v", err)
+	}
+	if rules := resp.NodeRules; rules == nil || len(rules.Include) != 2 || rules.Include[1] != "br-wol*" ||
+		len(rules.Exclude) != 1 || rules.Exclude[0] != "br-wol9" {
+		t.Errorf("Unexpected node rules %v", resp.NodeRules)
+	}
+	for _, node := range []string{"worker-2", "worker-3", "missing"} {
+		resp, _ := agg.GetInterfaceHints(context.Background(), &wolv1.InterfaceHintsRequest{NodeName: node})
+		if resp.NodeRules != nil {
+			t.Errorf("Expected no node rules for %s, got %v", node, resp.NodeRules)
+		}
+	}
+}
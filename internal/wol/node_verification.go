@@ -0,0 +1,122 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"google.golang.org/grpc/peer"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// Node verification modes of the events reported by the agents
+const (
+	NodeVerificationOff    = "off"
+	NodeVerificationFlag   = "flag"
+	NodeVerificationReject = "reject"
+)
+
+// Reasons of the events whose reported node cannot be their sender
+const (
+	spoofedUnknownNode     = "unknown-node"
+	spoofedAddressMismatch = "address-mismatch"
+)
+
+// SetNodeVerification sets how the node name reported with an event is
+// checked against its sender (NodeVerificationOff, Flag or Reject). Requires
+// SetNodeReader.
+func (a *Aggregator) SetNodeVerification(mode string) {
+	a.nodeVerification = mode
+}
+
+// spoofedNodeReason returns why the node reported with an event cannot be its
+// sender, "" if it is verified or cannot be checked. Events authenticated by
+// a ServiceAccount token are already bound to the node of the agent; the
+// others must come from an address of the node (the agents use the host
+// network) or from its pod CIDRs (the address of the CNI bridge, when the
+// manager runs on the same node).
+func (a *Aggregator) spoofedNodeReason(ctx context.Context, nodeName string) (string, net.IP) {
+	if _, ok := tokenIdentityFromContext(ctx); ok {
+		return "", nil
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "", nil // in-process, senza un chiamante da verificare
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", nil
+	}
+
+	node := &corev1.Node{}
+	if err := a.nodes.Get(ctx, client.ObjectKey{Name: nodeName}, node); err != nil {
+		if apierrors.IsNotFound(err) || nodeName == "" {
+			return spoofedUnknownNode, ip
+		}
+		a.log.V(1).Info("Cannot verify the node of the event", "node", nodeName, "error", err.Error())
+		return "", ip
+	}
+	for _, addr := range node.Status.Addresses {
+		if addr.Type != corev1.NodeInternalIP && addr.Type != corev1.NodeExternalIP {
+			continue
+		}
+		if nodeIP := net.ParseIP(addr.Address); nodeIP != nil && nodeIP.Equal(ip) {
+			return "", ip
+		}
+	}
+	for _, cidr := range node.Spec.PodCIDRs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return "", ip
+		}
+	}
+	return spoofedAddressMismatch, ip
+}
+
+// verifyEventNode counts the events whose reported node is not their sender,
+// and returns a NODE_UNVERIFIED response for them in the Reject mode, nil if
+// the event can go on. node is the validated node label.
+func (a *Aggregator) verifyEventNode(ctx context.Context, event *wolv1.WOLEvent, node string, startTime time.Time) *wolv1.WOLEventResponse {
+	if a.nodes == nil || a.nodeVerification == "" || a.nodeVerification == NodeVerificationOff {
+		return nil
+	}
+	reason, ip := a.spoofedNodeReason(ctx, event.NodeName)
+	if reason == "" {
+		return nil
+	}
+	SpoofedEventsTotal.WithLabelValues(node, reason).Inc()
+	a.log.Info("WOL event reported for a node that is not its sender", "node", event.NodeName, "peer", ip.String(),
+		"reason", reason, "mac", event.MacAddress, "rejected", a.nodeVerification == NodeVerificationReject)
+	if a.nodeVerification != NodeVerificationReject {
+		return nil
+	}
+	return &wolv1.WOLEventResponse{
+		Status:           wolv1.ResponseStatus_NODE_UNVERIFIED,
+		Message:          fmt.Sprintf("Node %s is not the sender of the event (%s from %s)", event.NodeName, reason, ip),
+		ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+	}
+}
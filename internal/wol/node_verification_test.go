@@ -0,0 +1,109 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/peer"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func peerContext(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}})
+}
+
+func newVerifyingAggregator(mode string) *Aggregator {
+	node := &corev1.Node{Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+		{Type: corev1.NodeHostName, Address: "worker-1"},
+		{Type: corev1.NodeInternalIP, Address: "10.0.0.11"},
+		{Type: corev1.NodeInternalIP, Address: "fd00::11"},
+	}}}
+	node.Name = "worker-1"
+	node.Spec.PodCIDRs = []string{"10.244.1.0/24"}
+	agg := NewAggregator(NewMACMapper(nil, logr.Discard()), NewVMStarter(nil, logr.Discard()), logr.Discard())
+	agg.SetNodeReader(fake.NewClientBuilder().WithObjects(node).Build())
+	agg.SetNodeVerification(mode)
+	return agg
+}
+
+func TestAggregator_SpoofedNodeReason(t *testing.T) {
+	agg := newVerifyingAggregator(NodeVerificationFlag)
+	tokenCtx := context.WithValue(peerContext("10.0.0.99"), tokenIdentityContextKey{},
+		TokenIdentity{Namespace: "kubevirt-wol", Pod: "agent-abc", Node: "worker-1"})
+
+	for _, tc := range []struct {
+		name   string
+		ctx    context.Context
+		node   string
+		reason string
+	}{
+		{"address of the node", peerContext("10.0.0.11"), "worker-1", ""},
+		{"IPv6 address of the node", peerContext("fd00::11"), "worker-1", ""},
+		{"CNI bridge of the node", peerContext("10.244.1.1"), "worker-1", ""},
+		{"address of another host", peerContext("10.0.0.99"), "worker-1", spoofedAddressMismatch},
+		{"node that does not exist", peerContext("10.0.0.11"), "worker-9", spoofedUnknownNode},
+		{"no node", peerContext("10.0.0.11"), "", spoofedUnknownNode},
+		{"authenticated by a token", tokenCtx, "worker-1", ""},
+		{"in-process call", context.Background(), "worker-9", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if reason, _ := agg.spoofedNodeReason(tc.ctx, tc.node); reason != tc.reason {
+				t.Errorf("Expected reason %q, got %q", tc.reason, reason)
+			}
+		})
+	}
+}
+
+func TestAggregator_VerifyEventNode(t *testing.T) {
+	event := func() *wolv1.WOLEvent {
+		return &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01", NodeName: "worker-1", DestinationPort: 9}
+	}
+	before := testutil.ToFloat64(SpoofedEventsTotal.WithLabelValues("worker-1", spoofedAddressMismatch))
+
+	// Flag: l'evento prosegue, ma è contato
+	resp, err := newVerifyingAggregator(NodeVerificationFlag).ReportWOLEvent(peerContext("10.0.0.99"), event())
+	if err != nil || resp.Status != wolv1.ResponseStatus_VM_NOT_FOUND {
+		t.Errorf("Expected the flagged event to go on, got %v (%v)", resp, err)
+	}
+	if got := testutil.ToFloat64(SpoofedEventsTotal.WithLabelValues("worker-1", spoofedAddressMismatch)); got != before+1 {
+		t.Errorf("Expected the spoofed event to be counted, got %v", got-before)
+	}
+
+	reject := newVerifyingAggregator(NodeVerificationReject)
+	resp, err = reject.ReportWOLEvent(peerContext("10.0.0.99"), event())
+	if err != nil || resp.Status != wolv1.ResponseStatus_NODE_UNVERIFIED {
+		t.Errorf("Expected NODE_UNVERIFIED, got %v (%v)", resp, err)
+	}
+	resp, err = reject.ReportWOLEvent(peerContext("10.0.0.11"), event())
+	if err != nil || resp.Status != wolv1.ResponseStatus_VM_NOT_FOUND {
+		t.Errorf("Expected the event of the node to go on, got %v (%v)", resp, err)
+	}
+
+	// Off: nessuna verifica
+	resp, err = newVerifyingAggregator(NodeVerificationOff).ReportWOLEvent(peerContext("10.0.0.99"), event())
+	if err != nil || resp.Status != wolv1.ResponseStatus_VM_NOT_FOUND {
+		t.Errorf("Expected no verification, got %v (%v)", resp, err)
+	}
+}
@@ -16,14 +16,20 @@ limitations under the License.
 
 package wol
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 const (
 	// DefaultWOLPort is the standard Wake-on-LAN UDP port
 	DefaultWOLPort = 9
 	// MagicPacketSize is the minimum size of a WOL magic packet (6 + 6*16 = 102 bytes)
 	MagicPacketSize = 6 + 16*6 // 6x0xFF + 16 repetitions of MAC
-
+	// SecureOnPasswordSize is the size of the optional SecureOn password appended to a magic packet
+	SecureOnPasswordSize = 6
+	// secureOnShortPasswordSize is the 4-byte variant (an IPv4 address) accepted by some NICs
+	secureOnShortPasswordSize = 4
 )
 
 // parseMagicPacket validates and extracts the MAC address from a WOL magic packet
@@ -63,3 +69,33 @@ func parseMagicPacket(packet []byte) (string, bool) {
 
 	return mac, true
 }
+
+// parseSecureOnPassword returns the SecureOn password that follows the 16 MAC
+// repetitions, formatted as colon-separated hex (e.g. "01:02:03:04:05:06").
+// Returns an empty string if the packet carries no password.
+// The packet must already have been validated with parseMagicPacket.
+func parseSecureOnPassword(packet []byte) string {
+	trailer := packet[MagicPacketSize:]
+	if len(trailer) != SecureOnPasswordSize && len(trailer) != secureOnShortPasswordSize {
+		return ""
+	}
+	return formatSecureOnPassword(trailer)
+}
+
+// formatSecureOnPassword formats password bytes as lowercase colon-separated hex
+func formatSecureOnPassword(password []byte) string {
+	parts := make([]string, len(password))
+	for i, b := range password {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// dedupeKey builds the key used by the agent and the aggregator to detect duplicate
+// WOL events. A packet sent to a different port is a distinct request (e.g. a retry
+// on the secure port right after a plain broadcast). The SecureOn password is not
+// part of the key, so spraying passwords cannot grow the caches: it is compared
+// against the cached entry instead.
+func dedupeKey(mac string, port uint32) string {
+	return fmt.Sprintf("%s|%d", mac, port)
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
+)
+
+// DefaultPacketLogRate is the default number of per-packet log lines written
+// per second by the agent
+const DefaultPacketLogRate = 10
+
+// PacketLogger writes the per-packet logs of the listeners (packets received,
+// filtered, duplicated). They are sampled to a rate, so that a noisy broadcast
+// network does not flood the log storage, and the source addresses can be
+// hashed. A nil PacketLogger logs every packet as is.
+type PacketLogger struct {
+	now        func() time.Time
+	limiter    *rate.Limiter // nil = nessun campionamento
+	suppressed atomic.Int64  // log scartati dall'ultimo scritto
+	redact     bool
+	key        []byte // chiave HMAC degli indirizzi, casuale per processo
+}
+
+// NewPacketLogger returns a PacketLogger writing up to perSecond lines per
+// second (bursts of twice as many; 0 = no limit), hashing the source
+// addresses if redact is set
+func NewPacketLogger(perSecond float64, redact bool) *PacketLogger {
+	p := &PacketLogger{now: time.Now, redact: redact}
+	if perSecond > 0 {
+		p.limiter = rate.NewLimiter(rate.Limit(perSecond), max(1, int(math.Ceil(2*perSecond))))
+	}
+	if redact {
+		p.key = make([]byte, 32)
+		_, _ = rand.Read(p.key) // da Go 1.24 non ritorna errori
+	}
+	return p
+}
+
+// Info writes a per-packet log on log, unless its level is disabled or the
+// rate is exceeded. The first line after sampled ones reports how many were
+// dropped ("sampledOut").
+func (p *PacketLogger) Info(log logr.Logger, msg string, keysAndValues ...any) {
+	if !log.Enabled() {
+		return
+	}
+	if p == nil || p.limiter == nil {
+		log.Info(msg, keysAndValues...)
+		return
+	}
+	if !p.limiter.AllowN(p.now(), 1) {
+		p.suppressed.Add(1)
+		return
+	}
+	if n := p.suppressed.Swap(0); n > 0 {
+		keysAndValues = append(keysAndValues, "sampledOut", n)
+	}
+	log.Info(msg, keysAndValues...)
+}
+
+// Source returns a source address (IP, MAC, host:port) for the logs: hashed
+// when redacting, so that the packets of a sender can still be correlated
+// within an agent run without logging it
+func (p *PacketLogger) Source(addr string) string {
+	if p == nil || !p.redact || addr == "" {
+		return addr
+	}
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(addr))
+	return "hash:" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+// SourceAddr returns a UDP source address for the logs, with the IP hashed
+// when redacting
+func (p *PacketLogger) SourceAddr(addr *net.UDPAddr) string {
+	if p == nil || !p.redact || addr == nil {
+		return addr.String()
+	}
+	return p.Source(addr.IP.String()) + ":" + strconv.Itoa(addr.Port)
+}
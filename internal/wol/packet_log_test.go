@@ -0,0 +1,89 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+)
+
+func TestPacketLogger_Sampling(t *testing.T) {
+	var lines []string
+	log := funcr.New(func(_, args string) { lines = append(lines, args) }, funcr.Options{})
+
+	// 1 riga al secondo, burst di 2: le altre sono scartate e contate
+	now := time.Now()
+	p := NewPacketLogger(1, false)
+	p.now = func() time.Time { return now }
+	for range 5 {
+		p.Info(log, "packet")
+	}
+	if len(lines) != 2 {
+		t.Fatalf("Expected the burst of 2 lines, got %d", len(lines))
+	}
+	if n := p.suppressed.Load(); n != 3 {
+		t.Errorf("Expected 3 sampled out lines, got %d", n)
+	}
+
+	// La prossima riga scritta riporta quante ne sono state scartate
+	now = now.Add(time.Second)
+	p.Info(log, "packet")
+	if len(lines) != 3 || !strings.Contains(lines[2], `"sampledOut"=3`) {
+		t.Errorf("Expected the sampled out count, got %v", lines)
+	}
+
+	// I livelli disabilitati non consumano la quota
+	p.Info(log.V(1), "debug packet")
+	if p.suppressed.Load() != 0 || len(lines) != 3 {
+		t.Error("Expected a disabled level to be skipped without sampling")
+	}
+
+	var unlimited *PacketLogger
+	for range 5 {
+		unlimited.Info(log, "packet")
+	}
+	if len(lines) != 8 {
+		t.Errorf("Expected every line without sampling, got %d", len(lines))
+	}
+}
+
+func TestPacketLogger_Redaction(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.10"), Port: 40000}
+	plain := NewPacketLogger(0, false)
+	if got := plain.SourceAddr(addr); got != "192.0.2.10:40000" {
+		t.Errorf("Expected the address as is, got %s", got)
+	}
+
+	p := NewPacketLogger(0, true)
+	hashed := p.Source("192.0.2.10")
+	if !strings.HasPrefix(hashed, "hash:") || strings.Contains(hashed, "192.0.2") {
+		t.Errorf("Expected a hashed IP, got %s", hashed)
+	}
+	if p.Source("192.0.2.10") != hashed || p.Source("192.0.2.11") == hashed {
+		t.Error("Expected the hash to identify the address")
+	}
+	if got := p.SourceAddr(addr); got != hashed+":40000" {
+		t.Errorf("Expected the hashed IP with the port, got %s", got)
+	}
+	if NewPacketLogger(0, true).Source("192.0.2.10") == hashed {
+		t.Error("Expected a key of its own for every logger")
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func buildMagicPacket(mac []byte, password []byte) []byte {
+	packet := make([]byte, 0, MagicPacketSize+len(password))
+	for i := 0; i < 6; i++ {
+		packet = append(packet, 0xFF)
+	}
+	for i := 0; i < 16; i++ {
+		packet = append(packet, mac...)
+	}
+	return append(packet, password...)
+}
+
+func TestParseMagicPacket_SecureOn(t *testing.T) {
+	mac := []byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+
+	tests := []struct {
+		name     string
+		password []byte
+		want     string
+	}{
+		{"no password", nil, ""},
+		{"6-byte password", []byte{1, 2, 3, 4, 5, 6}, "01:02:03:04:05:06"},
+		{"4-byte password", []byte{192, 168, 1, 10}, "c0:a8:01:0a"},
+		{"unexpected trailer", []byte{1, 2}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet := buildMagicPacket(mac, tt.password)
+
+			got, ok := parseMagicPacket(packet)
+			if !ok || got != "52:54:00:12:34:56" {
+				t.Fatalf("parseMagicPacket() = %q, %v", got, ok)
+			}
+			if password := parseSecureOnPassword(packet); password != tt.want {
+				t.Errorf("parseSecureOnPassword() = %q, want %q", password, tt.want)
+			}
+		})
+	}
+}
+
+func TestDedupeKey(t *testing.T) {
+	if dedupeKey("52:54:00:12:34:56", 9) == dedupeKey("52:54:00:12:34:56", 7) {
+		t.Error("Different ports must produce different keys")
+	}
+}
+
+func TestShouldProcess_Password(t *testing.T) {
+	agent := &Agent{
+		log:            logr.Discard(),
+		dedupeCache:    make(map[string]localDedupeEntry),
+		dedupeDuration: time.Minute,
+	}
+	key := dedupeKey("52:54:00:12:34:56", 9)
+
+	if !agent.shouldProcess(key, "") {
+		t.Fatal("Expected the first packet to be processed")
+	}
+	if agent.shouldProcess(key, "") {
+		t.Error("Expected the same packet to be a duplicate")
+	}
+	if !agent.shouldProcess(key, "01:02:03:04:05:06") {
+		t.Error("Expected a packet with a different SecureOn password to be processed")
+	}
+
+	// Spraying passwords must not grow the cache
+	for i := 0; i < 100; i++ {
+		agent.shouldProcess(key, fmt.Sprintf("00:00:00:00:00:%02x", i))
+	}
+	if len(agent.dedupeCache) != 1 {
+		t.Errorf("Expected 1 cache entry, got %d", len(agent.dedupeCache))
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// With spec.placementHint, before starting a stopped VM for a magic packet the
+// aggregator adds to its template a preferred node affinity term for the
+// nodes sharing the topology label of the node that received the packet. The
+// term is recorded in the AnnotationPlacementHint annotation of the template,
+// so the next wake replaces it; a wake without a receiving node (API, ARP,
+// schedules, relays) removes it, so the VM is placed as usual again.
+
+const (
+	// AnnotationPlacementHint records on the VM template the node affinity
+	// term added by the last wake, as <topology key>=<value>
+	AnnotationPlacementHint = "wol.pillon.org/placement-hint"
+
+	defaultPlacementHintWeight = 100
+)
+
+// PlacementHint returns the placement hint settings of a WolConfig, nil if disabled
+func (m *MACMapper) PlacementHint(configName string) *wolv1beta1.PlacementHintSpec {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range m.configs {
+		if m.configs[i].Name != configName {
+			continue
+		}
+		if spec := m.configs[i].Spec.PlacementHint; spec != nil && spec.Enabled {
+			return spec
+		}
+		return nil
+	}
+	return nil
+}
+
+// placementHint returns the <topology key>=<value> of the node that received
+// a packet, "" if there is none (a wake request, a relay, a node without the label)
+func (a *Aggregator) placementHint(ctx context.Context, spec *wolv1beta1.PlacementHintSpec, nodeName string) string {
+	if a.nodes == nil || nodeName == "" {
+		return ""
+	}
+	node := &corev1.Node{}
+	if err := a.nodes.Get(ctx, client.ObjectKey{Name: nodeName}, node); err != nil {
+		return ""
+	}
+	key := spec.TopologyKey
+	if key == "" {
+		key = corev1.LabelHostname
+	}
+	value, ok := node.Labels[key]
+	if !ok {
+		return ""
+	}
+	return key + "=" + value
+}
+
+// setPlacementHint replaces in affinity the term of the previous hint with
+// the one of hint ("" only removes it) and returns the updated affinity
+func setPlacementHint(affinity *corev1.Affinity, previous, hint string, weight int32) *corev1.Affinity {
+	if affinity == nil {
+		affinity = &corev1.Affinity{}
+	}
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	nodeAffinity := affinity.NodeAffinity
+	if previous != "" {
+		terms := nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution[:0]
+		for _, term := range nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			if !isPlacementHintTerm(term, previous) {
+				terms = append(terms, term)
+			}
+		}
+		nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = terms
+	}
+	if hint != "" {
+		key, value, _ := strings.Cut(hint, "=")
+		nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+			nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution, corev1.PreferredSchedulingTerm{
+				Weight: weight,
+				Preference: corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{
+					{Key: key, Operator: corev1.NodeSelectorOpIn, Values: []string{value}},
+				}},
+			})
+	}
+	if len(nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution) == 0 {
+		nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = nil
+	}
+	if nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil &&
+		nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution == nil {
+		affinity.NodeAffinity = nil
+	}
+	if affinity.NodeAffinity == nil && affinity.PodAffinity == nil && affinity.PodAntiAffinity == nil {
+		return nil
+	}
+	return affinity
+}
+
+// isPlacementHintTerm returns true if term is the one added for hint
+func isPlacementHintTerm(term corev1.PreferredSchedulingTerm, hint string) bool {
+	key, value, _ := strings.Cut(hint, "=")
+	expressions := term.Preference.MatchExpressions
+	return len(expressions) == 1 && len(term.Preference.MatchFields) == 0 &&
+		expressions[0].Key == key && expressions[0].Operator == corev1.NodeSelectorOpIn &&
+		len(expressions[0].Values) == 1 && expressions[0].Values[0] == value
+}
+
+// placeWake sets the placement hint of a wake from nodeName on the template
+// of the VM, unless the VM already has a VMI (the template would only apply
+// at the next start). Failures are logged: the wake goes on without the hint.
+func (a *Aggregator) placeWake(ctx context.Context, vmInfo VMInfo, spec *wolv1beta1.PlacementHintSpec, nodeName string) {
+	c := a.mapper.client
+	key := client.ObjectKey{Namespace: vmInfo.Namespace, Name: vmInfo.Name}
+	vm := &kubevirtv1.VirtualMachine{}
+	if err := c.Get(ctx, key, vm); err != nil || vm.Spec.Template == nil {
+		return
+	}
+	hint := a.placementHint(ctx, spec, nodeName)
+	previous := vm.Spec.Template.ObjectMeta.Annotations[AnnotationPlacementHint]
+	if hint == previous {
+		return
+	}
+	if err := c.Get(ctx, key, &kubevirtv1.VirtualMachineInstance{}); !apierrors.IsNotFound(err) {
+		return
+	}
+
+	patch := client.MergeFrom(vm.DeepCopy())
+	weight := spec.Weight
+	if weight == 0 {
+		weight = defaultPlacementHintWeight
+	}
+	template := vm.Spec.Template
+	template.Spec.Affinity = setPlacementHint(template.Spec.Affinity, previous, hint, weight)
+	if hint == "" {
+		delete(template.ObjectMeta.Annotations, AnnotationPlacementHint)
+	} else {
+		if template.ObjectMeta.Annotations == nil {
+			template.ObjectMeta.Annotations = make(map[string]string)
+		}
+		template.ObjectMeta.Annotations[AnnotationPlacementHint] = hint
+	}
+	if err := c.Patch(ctx, vm, patch); err != nil {
+		a.log.Error(err, "Failed to set the placement hint on the VM", "vm", vmInfo.Name,
+			"namespace", vmInfo.Namespace, "hint", hint)
+		return
+	}
+	a.log.V(1).Info("Set the placement hint on the VM", "vm", vmInfo.Name, "namespace", vmInfo.Namespace,
+		"hint", hint, "previous", previous)
+}
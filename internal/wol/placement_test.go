@@ -0,0 +1,110 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestSetPlacementHint(t *testing.T) {
+	gpu := corev1.PreferredSchedulingTerm{Weight: 10, Preference: corev1.NodeSelectorTerm{
+		MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "gpu", Operator: corev1.NodeSelectorOpExists}}}}
+	affinity := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{gpu}}}
+
+	affinity = setPlacementHint(affinity, "", "rack=r1", 100)
+	affinity = setPlacementHint(affinity, "rack=r1", "rack=r2", 50)
+	terms := affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	if len(terms) != 2 || terms[0].Weight != 10 || !isPlacementHintTerm(terms[1], "rack=r2") || terms[1].Weight != 50 {
+		t.Fatalf("Expected the VM term and the new hint, got %+v", terms)
+	}
+	affinity = setPlacementHint(affinity, "rack=r2", "", 0)
+	if terms := affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution; len(terms) != 1 || terms[0].Weight != 10 {
+		t.Errorf("Expected only the VM term to be left, got %+v", terms)
+	}
+
+	if affinity := setPlacementHint(setPlacementHint(nil, "", "rack=r1", 100), "rack=r1", "", 0); affinity != nil {
+		t.Errorf("Expected an affinity with only the hint to be removed, got %+v", affinity)
+	}
+}
+
+func TestAggregator_PlacementHint(t *testing.T) {
+	vm := &kubevirtv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "vm1", Namespace: "default"},
+		Spec:       kubevirtv1.VirtualMachineSpec{Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{}},
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1",
+		Labels: map[string]string{"topology.kubernetes.io/zone": "rack-a"}}}
+	c := newPolicyClient(t, vm, node)
+	mapper := NewMACMapper(c, logr.Discard())
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:01", VMName: "vm1", Namespace: "default"},
+			},
+			PlacementHint: &wolv1beta1.PlacementHintSpec{Enabled: true, TopologyKey: "topology.kubernetes.io/zone"},
+		},
+	}
+	config.Name = "lab"
+	mapper.UpdateConfig(config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	agg := NewAggregator(mapper, &policyStarter{actions: make(map[string]string)}, logr.Discard())
+	agg.SetNodeReader(c)
+	ctx := context.Background()
+	get := func() *kubevirtv1.VirtualMachine {
+		got := &kubevirtv1.VirtualMachine{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(vm), got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if _, err := agg.ReportWOLEvent(ctx, &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01", NodeName: "node1"}); err != nil {
+		t.Fatal(err)
+	}
+	template := get().Spec.Template
+	if template.ObjectMeta.Annotations[AnnotationPlacementHint] != "topology.kubernetes.io/zone=rack-a" {
+		t.Errorf("Expected the hint to be recorded, got %v", template.ObjectMeta.Annotations)
+	}
+	if affinity := template.Spec.Affinity; affinity == nil || affinity.NodeAffinity == nil ||
+		len(affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 1 ||
+		affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].Weight != defaultPlacementHintWeight {
+		t.Fatalf("Expected a preferred node affinity for rack-a, got %+v", affinity)
+	}
+
+	// Un wake senza nodo di ricezione rimuove l'hint
+	if resp, _ := agg.RequestWake(ctx, &wolv1.WakeRequest{Namespace: "default", Name: "vm1", Source: "api"}); resp.Status != wolv1.ResponseStatus_VM_START_INITIATED {
+		t.Fatalf("Unexpected response %v", resp)
+	}
+	template = get().Spec.Template
+	if _, ok := template.ObjectMeta.Annotations[AnnotationPlacementHint]; ok || template.Spec.Affinity != nil {
+		t.Errorf("Expected the hint to be removed, got %v and %+v", template.ObjectMeta.Annotations, template.Spec.Affinity)
+	}
+}
@@ -0,0 +1,331 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// policyResult is the outcome of a WolPolicy check, used as metric label
+type policyResult string
+
+const (
+	policyAllowed     policyResult = "allowed"
+	policyIgnored     policyResult = "ignored"
+	policyQuietHours  policyResult = "quiet_hours"
+	policyRateLimited policyResult = "rate_limited"
+)
+
+// PolicyDecision is what the WolPolicy of a VM decides for a wake
+type PolicyDecision struct {
+	// Policy is the WolPolicy selecting the VM (empty if none)
+	Policy string
+	// Action is the action to take on the VM (Start if no policy selects it)
+	Action wolv1beta1.WolPolicyAction
+
+	result policyResult
+}
+
+// Allowed returns true if the wake must go on with Action
+func (d PolicyDecision) Allowed() bool {
+	return d.result == policyAllowed
+}
+
+// PolicyEvaluator applies the WolPolicies of the namespaces of the woken VMs
+type PolicyEvaluator struct {
+	reader client.Reader
+	log    logr.Logger
+	now    func() time.Time
+
+	mu    sync.Mutex
+	wakes map[string]*wakeHistory // chiave: vmIndexKey
+}
+
+// wakeHistory holds the wakes of a VM accepted in the rate limit period
+type wakeHistory struct {
+	period time.Duration
+	times  []time.Time
+}
+
+// NewPolicyEvaluator creates a policy evaluator reading the WolPolicies and VMs from reader
+func NewPolicyEvaluator(reader client.Reader, log logr.Logger) *PolicyEvaluator {
+	return &PolicyEvaluator{
+		reader: reader,
+		log:    log,
+		now:    time.Now,
+		wakes:  make(map[string]*wakeHistory),
+	}
+}
+
+// Evaluate returns the decision of the WolPolicy selecting the VM. If several
+// policies select it, the first by name applies; a VM selected by no policy is started.
+func (e *PolicyEvaluator) Evaluate(ctx context.Context, namespace, name string) (PolicyDecision, error) {
+	return e.evaluate(ctx, namespace, name, true)
+}
+
+// preview returns the decision Evaluate would return, without recording the
+// wake in the rate limit of the policy nor in the metrics
+func (e *PolicyEvaluator) preview(ctx context.Context, namespace, name string) (PolicyDecision, error) {
+	return e.evaluate(ctx, namespace, name, false)
+}
+
+func (e *PolicyEvaluator) evaluate(ctx context.Context, namespace, name string, record bool) (PolicyDecision, error) {
+	policy, err := e.policyFor(ctx, namespace, name)
+	if err != nil {
+		return PolicyDecision{}, err
+	}
+	if policy == nil {
+		return PolicyDecision{Action: wolv1beta1.WolPolicyActionStart, result: policyAllowed}, nil
+	}
+
+	decision := PolicyDecision{Policy: policy.Name, Action: policy.Spec.Action, result: policyAllowed}
+	if decision.Action == "" {
+		decision.Action = wolv1beta1.WolPolicyActionStart
+	}
+
+	now := e.now()
+	quiet, err := inQuietHours(policy.Spec.QuietHours, now)
+	if err != nil {
+		return PolicyDecision{}, fmt.Errorf("invalid quiet hours in WolPolicy %s/%s: %w", namespace, policy.Name, err)
+	}
+	switch {
+	case decision.Action == wolv1beta1.WolPolicyActionIgnore:
+		decision.result = policyIgnored
+	case quiet:
+		decision.result = policyQuietHours
+	case !e.allowWake(vmIndexKey(namespace, name), policy.Spec.RateLimit, now, record):
+		decision.result = policyRateLimited
+	}
+	if record {
+		PolicyDecisionsTotal.WithLabelValues(string(decision.Action), string(decision.result)).Inc()
+	}
+	return decision, nil
+}
+
+// policyFor returns the first WolPolicy (by name) of the namespace selecting the VM
+func (e *PolicyEvaluator) policyFor(ctx context.Context, namespace, name string) (*wolv1beta1.WolPolicy, error) {
+	policies := &wolv1beta1.WolPolicyList{}
+	if err := e.reader.List(ctx, policies, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list WolPolicies in %s: %w", namespace, err)
+	}
+	if len(policies.Items) == 0 {
+		return nil, nil
+	}
+	sort.Slice(policies.Items, func(i, j int) bool { return policies.Items[i].Name < policies.Items[j].Name })
+
+	// Le label della VM servono solo per i selettori non vuoti
+	var vmLabels labels.Set
+	var vmFetched bool
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		selector := labels.Everything()
+		if policy.Spec.VMSelector != nil {
+			var err error
+			if selector, err = metav1.LabelSelectorAsSelector(policy.Spec.VMSelector); err != nil {
+				e.log.Error(err, "Ignoring WolPolicy with an invalid VM selector", "policy", policy.Name, "namespace", namespace)
+				continue
+			}
+		}
+		if !selector.Empty() && !vmFetched {
+			vm := &kubevirtv1.VirtualMachine{}
+			err := e.reader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, vm)
+			if err != nil && !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("failed to get VM %s/%s: %w", namespace, name, err)
+			}
+			vmLabels, vmFetched = vm.Labels, true
+		}
+		if selector.Matches(vmLabels) {
+			return policy, nil
+		}
+	}
+	return nil, nil
+}
+
+// allowWake records a wake of the VM (unless record is false), returning
+// false if it exceeds the rate limit
+func (e *PolicyEvaluator) allowWake(key string, limit *wolv1beta1.WakeRateLimit, now time.Time, record bool) bool {
+	if limit == nil {
+		return true
+	}
+	period := time.Duration(limit.PeriodSeconds) * time.Second
+	if period <= 0 {
+		period = time.Hour
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	history, ok := e.wakes[key]
+	if !ok {
+		history = &wakeHistory{}
+		e.wakes[key] = history
+	}
+	history.period = period
+	recent := history.times[:0]
+	for _, wake := range history.times {
+		if now.Sub(wake) < period {
+			recent = append(recent, wake)
+		}
+	}
+	history.times = recent
+	if len(recent) >= int(limit.MaxWakes) {
+		return false
+	}
+	if record {
+		history.times = append(history.times, now)
+	}
+	return true
+}
+
+// cleanup drops the wake history of the VMs not woken in their rate limit period
+func (e *PolicyEvaluator) cleanup() {
+	now := e.now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for key, history := range e.wakes {
+		if len(history.times) == 0 || now.Sub(history.times[len(history.times)-1]) >= history.period {
+			delete(e.wakes, key)
+		}
+	}
+}
+
+// weekdays maps the day names of QuietHours.Days
+var weekdays = map[string]time.Weekday{
+	"Sunday": time.Sunday, "Monday": time.Monday, "Tuesday": time.Tuesday, "Wednesday": time.Wednesday,
+	"Thursday": time.Thursday, "Friday": time.Friday, "Saturday": time.Saturday,
+}
+
+// inQuietHours returns true if now falls in one of the windows
+func inQuietHours(windows []wolv1beta1.QuietHours, now time.Time) (bool, error) {
+	for _, window := range windows {
+		location := time.UTC
+		if window.TimeZone != "" {
+			var err error
+			if location, err = time.LoadLocation(window.TimeZone); err != nil {
+				return false, err
+			}
+		}
+		start, err := parseClock(window.Start)
+		if err != nil {
+			return false, err
+		}
+		end, err := parseClock(window.End)
+		if err != nil {
+			return false, err
+		}
+
+		local := now.In(location)
+		minute := local.Hour()*60 + local.Minute()
+		startsOn := func(day time.Weekday) bool {
+			if len(window.Days) == 0 {
+				return true
+			}
+			for _, name := range window.Days {
+				if weekdays[name] == day {
+					return true
+				}
+			}
+			return false
+		}
+
+		if start <= end {
+			if minute >= start && minute < end && startsOn(local.Weekday()) {
+				return true, nil
+			}
+			continue
+		}
+		// La finestra attraversa la mezzanotte: dopo mezzanotte conta il giorno prima
+		if minute >= start && startsOn(local.Weekday()) {
+			return true, nil
+		}
+		if minute < end && startsOn((local.Weekday()+6)%7) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// parseClock parses a HH:MM time of day into minutes after midnight
+func parseClock(value string) (int, error) {
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (HH:MM)", value)
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// SetPolicyEvaluator makes the wakes follow the WolPolicies of the VM namespaces
+func (a *Aggregator) SetPolicyEvaluator(policies *PolicyEvaluator) {
+	a.policies = policies
+}
+
+// enforcePolicy applies the WolPolicy of the VM. It returns the action to take,
+// or the response of a rejected wake.
+func (a *Aggregator) enforcePolicy(ctx context.Context, vmInfo VMInfo, source string,
+	startTime time.Time) (wolv1beta1.WolPolicyAction, *wolv1.WOLEventResponse) {
+	if a.policies == nil {
+		return wolv1beta1.WolPolicyActionStart, nil
+	}
+
+	decision, err := a.policies.Evaluate(ctx, vmInfo.Namespace, vmInfo.Name)
+	if err != nil {
+		a.log.Error(err, "Failed to evaluate the WolPolicy of the VM", "vm", vmInfo.Name, "namespace", vmInfo.Namespace)
+		ErrorsTotal.Inc()
+		return "", &wolv1.WOLEventResponse{
+			Status:  wolv1.ResponseStatus_ERROR,
+			Message: fmt.Sprintf("Failed to evaluate WolPolicy: %v", err),
+			VmInfo: &wolv1.VMInfo{
+				Name:      vmInfo.Name,
+				Namespace: vmInfo.Namespace,
+			},
+			ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+		}
+	}
+	if decision.Allowed() {
+		return decision.Action, nil
+	}
+
+	a.log.Info("Wake rejected by WolPolicy",
+		"vm", vmInfo.Name,
+		"namespace", vmInfo.Namespace,
+		"policy", decision.Policy,
+		"reason", decision.result,
+		"source", source)
+	return "", &wolv1.WOLEventResponse{
+		Status: wolv1.ResponseStatus_POLICY_REJECTED,
+		Message: fmt.Sprintf("Wake of VM %s/%s rejected by WolPolicy %s (%s)",
+			vmInfo.Namespace, vmInfo.Name, decision.Policy, decision.result),
+		VmInfo: &wolv1.VMInfo{
+			Name:      vmInfo.Name,
+			Namespace: vmInfo.Namespace,
+		},
+		ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+	}
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	poolv1alpha1 "kubevirt.io/api/pool/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// policyStarter records the action used for each VM
+type policyStarter struct {
+	mu      sync.Mutex
+	actions map[string]string
+}
+
+func (s *policyStarter) record(name, action string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.actions[name] = action
+	return nil
+}
+
+func (s *policyStarter) StartVMAs(_ context.Context, _, _, name string) error {
+	return s.record(name, "start")
+}
+func (s *policyStarter) ResumeVMAs(_ context.Context, _, _, name string) error {
+	return s.record(name, "resume")
+}
+func (s *policyStarter) RestartCrashedVMAs(_ context.Context, _, _, name string) error {
+	return s.record(name, "restart")
+}
+func (s *policyStarter) QueuedWakes() int { return 0 }
+
+func newPolicyClient(t *testing.T, objects ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := wolv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := kubevirtv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := poolv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+}
+
+func newTestPolicy(name string, spec wolv1beta1.WolPolicySpec) *wolv1beta1.WolPolicy {
+	return &wolv1beta1.WolPolicy{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "tenant"}, Spec: spec}
+}
+
+func TestPolicyEvaluator_Selection(t *testing.T) {
+	desktop := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{
+		Name: "desktop", Namespace: "tenant", Labels: map[string]string{"role": "desktop"}}}
+	c := newPolicyClient(t, desktop,
+		newTestPolicy("b-desktops", wolv1beta1.WolPolicySpec{
+			VMSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"role": "desktop"}},
+			Action:     wolv1beta1.WolPolicyActionResume,
+		}),
+		newTestPolicy("c-all", wolv1beta1.WolPolicySpec{Action: wolv1beta1.WolPolicyActionIgnore}),
+		newTestPolicy("a-servers", wolv1beta1.WolPolicySpec{
+			VMSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"role": "server"}},
+		}),
+	)
+	evaluator := NewPolicyEvaluator(c, logr.Discard())
+
+	decision, err := evaluator.Evaluate(context.Background(), "tenant", "desktop")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if decision.Policy != "b-desktops" || decision.Action != wolv1beta1.WolPolicyActionResume || !decision.Allowed() {
+		t.Errorf("Expected the desktop policy to resume the VM, got %+v", decision)
+	}
+
+	decision, _ = evaluator.Evaluate(context.Background(), "tenant", "other")
+	if decision.Policy != "c-all" || decision.Allowed() {
+		t.Errorf("Expected the catch-all policy to ignore the wake, got %+v", decision)
+	}
+
+	decision, _ = evaluator.Evaluate(context.Background(), "elsewhere", "desktop")
+	if decision.Policy != "" || decision.Action != wolv1beta1.WolPolicyActionStart || !decision.Allowed() {
+		t.Errorf("Expected a VM without policy to be started, got %+v", decision)
+	}
+}
+
+func TestPolicyEvaluator_RateLimit(t *testing.T) {
+	c := newPolicyClient(t, newTestPolicy("limited", wolv1beta1.WolPolicySpec{
+		RateLimit: &wolv1beta1.WakeRateLimit{MaxWakes: 2, PeriodSeconds: 60},
+	}))
+	evaluator := NewPolicyEvaluator(c, logr.Discard())
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	evaluator.now = func() time.Time { return now }
+
+	for i, want := range []bool{true, true, false} {
+		decision, err := evaluator.Evaluate(context.Background(), "tenant", "vm1")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if decision.Allowed() != want {
+			t.Errorf("Wake %d: expected allowed=%v, got %+v", i+1, want, decision)
+		}
+	}
+	if decision, _ := evaluator.Evaluate(context.Background(), "tenant", "vm2"); !decision.Allowed() {
+		t.Error("Expected the rate limit to be per VM")
+	}
+
+	now = now.Add(time.Minute)
+	if decision, _ := evaluator.Evaluate(context.Background(), "tenant", "vm1"); !decision.Allowed() {
+		t.Error("Expected the wake to be accepted after the period")
+	}
+	now = now.Add(2 * time.Minute)
+	evaluator.cleanup()
+	if len(evaluator.wakes) != 0 {
+		t.Errorf("Expected the expired history to be dropped, got %d entries", len(evaluator.wakes))
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	overnight := []wolv1beta1.QuietHours{{Start: "22:00", End: "06:00", Days: []string{"Friday"}, TimeZone: "Europe/Rome"}}
+	for _, tc := range []struct {
+		at   time.Time
+		want bool
+	}{
+		// 2025-01-03 is a Friday; Rome is UTC+1 in January
+		{time.Date(2025, 1, 3, 21, 30, 0, 0, time.UTC), true},  // Friday 22:30
+		{time.Date(2025, 1, 4, 4, 0, 0, 0, time.UTC), true},    // Saturday 05:00, window started on Friday
+		{time.Date(2025, 1, 4, 5, 0, 0, 0, time.UTC), false},   // Saturday 06:00
+		{time.Date(2025, 1, 4, 21, 30, 0, 0, time.UTC), false}, // Saturday 22:30
+		{time.Date(2025, 1, 3, 20, 30, 0, 0, time.UTC), false}, // Friday 21:30
+	} {
+		got, err := inQuietHours(overnight, tc.at)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got != tc.want {
+			t.Errorf("At %s: expected %v, got %v", tc.at, tc.want, got)
+		}
+	}
+
+	lunch := []wolv1beta1.QuietHours{{Start: "12:00", End: "13:00"}}
+	if quiet, _ := inQuietHours(lunch, time.Date(2025, 1, 5, 12, 59, 0, 0, time.UTC)); !quiet {
+		t.Error("Expected a window without days to apply every day")
+	}
+	if _, err := inQuietHours([]wolv1beta1.QuietHours{{Start: "12:00", End: "13:00", TimeZone: "Mars/Olympus"}}, time.Now()); err == nil {
+		t.Error("Expected an unknown time zone to be rejected")
+	}
+}
+
+func TestAggregator_WolPolicy(t *testing.T) {
+	c := newPolicyClient(t,
+		newTestPolicy("resume", wolv1beta1.WolPolicySpec{
+			VMSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"kubevirt.io/vm": "paused"}},
+			Action:     wolv1beta1.WolPolicyActionResume,
+		}),
+		newTestPolicy("z-ignore", wolv1beta1.WolPolicySpec{Action: wolv1beta1.WolPolicyActionIgnore}),
+		&kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{
+			Name: "paused", Namespace: "tenant", Labels: map[string]string{"kubevirt.io/vm": "paused"}}},
+	)
+	mapper := NewMACMapper(c, logr.Discard())
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:01", VMName: "paused", Namespace: "tenant"},
+				{MACAddress: "52:54:00:00:00:02", VMName: "ignored", Namespace: "tenant"},
+			},
+		},
+	}
+	config.Name = "tenant"
+	mapper.UpdateConfig(config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	starter := &policyStarter{actions: make(map[string]string)}
+	agg := NewAggregator(mapper, starter, logr.Discard())
+	agg.SetPolicyEvaluator(NewPolicyEvaluator(c, logr.Discard()))
+
+	resp, _ := agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01"})
+	if resp.Status != wolv1.ResponseStatus_VM_START_INITIATED || starter.actions["paused"] != "resume" {
+		t.Errorf("Expected the paused VM to be resumed, got %v (%v)", resp.Status, starter.actions)
+	}
+
+	resp, _ = agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:02"})
+	if resp.Status != wolv1.ResponseStatus_POLICY_REJECTED {
+		t.Errorf("Expected the wake to be rejected, got %v", resp.Status)
+	}
+	resp = agg.requestWake(context.Background(), &wolv1.WakeRequest{Namespace: "tenant", Name: "ignored", Source: "test"}, time.Now())
+	if resp.Status != wolv1.ResponseStatus_POLICY_REJECTED {
+		t.Errorf("Expected the wake request to be rejected too, got %v", resp.Status)
+	}
+	if _, started := starter.actions["ignored"]; started {
+		t.Error("Expected the ignored VM not to be started")
+	}
+}
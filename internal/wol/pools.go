@@ -0,0 +1,227 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"kubevirt.io/api/pool"
+	poolv1alpha1 "kubevirt.io/api/pool/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// The members of a VirtualMachinePool are VMs named <pool>-<ordinal>, found
+// by the discovery like the other VMs. A scale-down deletes members with their
+// MACs: the mapper remembers the MACs of the members it has seen, so a magic
+// packet for a removed member scales the pool up again until it has that
+// member, which is then started. Like the auto-assigned MACs (statusmacs.go),
+// the remembered MACs are kept in memory.
+
+// MappingTypePoolMember is the mapping of a MAC of a VirtualMachinePool
+// member removed by a scale-down
+const MappingTypePoolMember MappingType = "pool-member"
+
+// PoolStarter is implemented by the Starters that can recreate the members
+// of a VirtualMachinePool
+type PoolStarter interface {
+	// StartPoolMemberAs scales the pool up until it has the member, if it was
+	// removed, and starts the member
+	StartPoolMemberAs(ctx context.Context, username, namespace, pool, name string) error
+}
+
+var _ PoolStarter = &VMStarter{}
+
+// poolMember is a remembered MAC of a pool member
+type poolMember struct {
+	info VMInfo
+	// labels of the member, to know if its WolConfig still selects it
+	labels map[string]string
+}
+
+// vmPool returns the VirtualMachinePool controlling the VM, empty if none
+func vmPool(vm *kubevirtv1.VirtualMachine) string {
+	owner := metav1.GetControllerOf(vm)
+	if owner == nil || owner.Kind != poolv1alpha1.VirtualMachinePoolKind {
+		return ""
+	}
+	if gv, err := schema.ParseGroupVersion(owner.APIVersion); err != nil || gv.Group != pool.GroupName {
+		return ""
+	}
+	return owner.Name
+}
+
+// poolMemberOrdinal returns the ordinal of a member in the name <pool>-<ordinal>
+func poolMemberOrdinal(pool, name string) (int32, bool) {
+	suffix, found := strings.CutPrefix(name, pool+"-")
+	if !found {
+		return 0, false
+	}
+	ordinal, err := strconv.ParseInt(suffix, 10, 32)
+	if err != nil || ordinal < 0 {
+		return 0, false
+	}
+	return int32(ordinal), true
+}
+
+// rememberPoolMember records the MACs of a pool member discovered by a
+// config. A member with no known MAC (auto-assigned, not booted yet since it
+// was recreated) keeps the ones it had before. m.refreshMu must be held.
+func (m *MACMapper) rememberPoolMember(vm *kubevirtv1.VirtualMachine, info VMInfo, keys []macKey) {
+	if len(keys) == 0 {
+		return
+	}
+	if _, ok := poolMemberOrdinal(info.Pool, vm.Name); !ok {
+		m.log.V(1).Info("Not remembering the MACs of a pool member with an unexpected name",
+			"vm", vm.Name, "namespace", vm.Namespace, "pool", info.Pool)
+		return
+	}
+	info.MappingType = MappingTypePoolMember
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.forgetPoolMemberLocked(vm.Namespace, vm.Name)
+	if m.poolMembers == nil {
+		m.poolMembers = make(map[macKey]poolMember)
+	}
+	for _, key := range keys {
+		m.poolMembers[key] = poolMember{info: info, labels: vm.Labels}
+	}
+}
+
+// forgetPoolMember drops the remembered MACs of a pool member (e.g. opted out)
+func (m *MACMapper) forgetPoolMember(namespace, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.forgetPoolMemberLocked(namespace, name)
+}
+
+// forgetPoolMemberLocked is forgetPoolMember with m.mu held
+func (m *MACMapper) forgetPoolMemberLocked(namespace, name string) {
+	for key, member := range m.poolMembers {
+		if member.info.Namespace == namespace && member.info.Name == name {
+			delete(m.poolMembers, key)
+		}
+	}
+}
+
+// forgetPoolMembers drops the remembered MACs of the members whose WolConfig
+// is gone or no longer selects them, and of the pools that were deleted.
+// m.refreshMu must be held.
+func (m *MACMapper) forgetPoolMembers(ctx context.Context, configs []wolv1beta1.WolConfig) {
+	m.mu.RLock()
+	remembered := len(m.poolMembers)
+	m.mu.RUnlock()
+	if remembered == 0 {
+		return
+	}
+
+	// Senza la lista dei pool (es. CRD assente) restano quelli noti
+	var pools map[string]bool
+	poolList := &poolv1alpha1.VirtualMachinePoolList{}
+	if err := m.client.List(ctx, poolList); err != nil {
+		m.log.V(1).Info("Cannot list the VirtualMachinePools, keeping the MACs of their members", "error", err.Error())
+	} else {
+		pools = make(map[string]bool, len(poolList.Items))
+		for _, p := range poolList.Items {
+			pools[vmIndexKey(p.Namespace, p.Name)] = true
+		}
+	}
+
+	byName := make(map[string]*wolv1beta1.WolConfig, len(configs))
+	for i := range configs {
+		byName[configs[i].Name] = &configs[i]
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, member := range m.poolMembers {
+		info := member.info
+		config := byName[info.ConfigName]
+		vm := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Namespace: info.Namespace, Labels: member.labels}}
+		if config == nil || !m.configSelectsVM(config, vm) || (pools != nil && !pools[vmIndexKey(info.Namespace, info.Pool)]) {
+			delete(m.poolMembers, key)
+		}
+	}
+}
+
+// LookupPoolMember returns the pool member that had the MAC before a scale-down
+func (m *MACMapper) LookupPoolMember(macAddress string) (VMInfo, bool) {
+	key, ok := parseMACKey(macAddress)
+	if !ok {
+		return VMInfo{}, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	member, found := m.poolMembers[key]
+	return member.info, found
+}
+
+// StartPoolMemberAs starts a member of a VirtualMachinePool impersonating the
+// given user. If a scale-down removed it, the pool is scaled up until it has
+// the member again, and the member is started once the pool has recreated it
+// (right away by KubeVirt if the template of the pool runs its VMs).
+func (s *VMStarter) StartPoolMemberAs(ctx context.Context, username, namespace, poolName, name string) error {
+	return s.runAs(username, namespace, name, func(c client.Client) error {
+		key := client.ObjectKey{Namespace: namespace, Name: name}
+		if err := c.Get(ctx, key, &kubevirtv1.VirtualMachine{}); err == nil {
+			return s.startVM(ctx, c, username, namespace, name)
+		} else if !apierrors.IsNotFound(err) {
+			ErrorsTotal.Inc()
+			return fmt.Errorf("failed to get VM %s/%s: %w", namespace, name, err)
+		}
+
+		ordinal, ok := poolMemberOrdinal(poolName, name)
+		if !ok {
+			return fmt.Errorf("VM %s/%s is not a member of VirtualMachinePool %s", namespace, name, poolName)
+		}
+		vmPool := &poolv1alpha1.VirtualMachinePool{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: poolName}, vmPool); err != nil {
+			ErrorsTotal.Inc()
+			return fmt.Errorf("failed to get VirtualMachinePool %s/%s: %w", namespace, poolName, err)
+		}
+		if vmPool.Spec.Paused {
+			return fmt.Errorf("VirtualMachinePool %s/%s is paused and cannot recreate VM %s", namespace, poolName, name)
+		}
+
+		// Il pool crea i membri mancanti a partire dall'ordinale più basso
+		replicas := int32(1)
+		if vmPool.Spec.Replicas != nil {
+			replicas = *vmPool.Spec.Replicas
+		}
+		if replicas <= ordinal {
+			patch := client.MergeFrom(vmPool.DeepCopy())
+			wanted := ordinal + 1
+			vmPool.Spec.Replicas = &wanted
+			if err := c.Patch(ctx, vmPool, patch); err != nil {
+				ErrorsTotal.Inc()
+				return fmt.Errorf("failed to scale VirtualMachinePool %s/%s: %w", namespace, poolName, err)
+			}
+			s.log.Info("Scaled up VirtualMachinePool to recreate a woken member", "pool", poolName,
+				"namespace", namespace, "vm", name, "from", replicas, "to", wanted)
+		}
+		s.queueWake(c, username, namespace, name, "being created by its VirtualMachinePool")
+		return nil
+	})
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	poolv1alpha1 "kubevirt.io/api/pool/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// newPoolVM returns a VM of the tenant namespace owned by the pool
+func newPoolVM(pool, name string, vmLabels map[string]string, mac string) *kubevirtv1.VirtualMachine {
+	vm := newWatchVM(name, vmLabels, mac)
+	controller := true
+	vm.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: poolv1alpha1.SchemeGroupVersion.String(),
+		Kind:       poolv1alpha1.VirtualMachinePoolKind,
+		Name:       pool,
+		Controller: &controller,
+	}}
+	return vm
+}
+
+func newTestPool(name string, replicas int32) *poolv1alpha1.VirtualMachinePool {
+	return &poolv1alpha1.VirtualMachinePool{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "tenant"},
+		Spec:       poolv1alpha1.VirtualMachinePoolSpec{Replicas: &replicas},
+	}
+}
+
+// poolStarter records the pool members started
+type poolStarter struct {
+	policyStarter
+}
+
+func (s *poolStarter) StartPoolMemberAs(_ context.Context, _, _, pool, name string) error {
+	return s.record(name, "pool "+pool)
+}
+
+func TestPoolMemberOrdinal(t *testing.T) {
+	tests := []struct {
+		name    string
+		ordinal int32
+		ok      bool
+	}{
+		{"web-0", 0, true},
+		{"web-12", 12, true},
+		{"web-api-1", 0, false},
+		{"web-", 0, false},
+		{"web--1", 0, false},
+		{"db-1", 0, false},
+	}
+	for _, tt := range tests {
+		if ordinal, ok := poolMemberOrdinal("web", tt.name); ordinal != tt.ordinal || ok != tt.ok {
+			t.Errorf("poolMemberOrdinal(web, %s) = %d, %v, want %d, %v", tt.name, ordinal, ok, tt.ordinal, tt.ok)
+		}
+	}
+}
+
+func TestMACMapper_PoolMembers(t *testing.T) {
+	mapper := newWatchMapper(t, newTestPool("web", 2),
+		newPoolVM("web", "web-0", nil, "52:54:00:00:00:01"),
+		newPoolVM("web", "web-1", nil, "52:54:00:00:00:02"),
+		newWatchVM("standalone", nil, "52:54:00:00:00:03"))
+	ctx := context.Background()
+
+	if info, found := mapper.Lookup("52:54:00:00:00:02"); !found || info.Pool != "web" || info.MappingType != MappingTypeDiscovered {
+		t.Errorf("Expected web-1 to be mapped as a member of pool web, got %+v (found=%v)", info, found)
+	}
+	if info, _ := mapper.Lookup("52:54:00:00:00:03"); info.Pool != "" {
+		t.Errorf("Expected a VM without pool, got pool %q", info.Pool)
+	}
+
+	// Lo scale-down elimina web-1: il suo MAC resta noto al pool
+	if err := mapper.client.Delete(ctx, newPoolVM("web", "web-1", nil, "")); err != nil {
+		t.Fatal(err)
+	}
+	mapper.RemoveVM(ctx, "tenant", "web-1")
+	if err := mapper.RefreshMapping(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, found := mapper.Lookup("52:54:00:00:00:02"); found {
+		t.Error("Expected the MAC of the removed member not to be mapped")
+	}
+	info, found := mapper.LookupPoolMember("52:54:00:00:00:02")
+	if !found || info.Name != "web-1" || info.Pool != "web" || info.MappingType != MappingTypePoolMember ||
+		info.ConfigName != "all" {
+		t.Errorf("Expected the MAC of web-1 to be remembered, got %+v (found=%v)", info, found)
+	}
+
+	// Un membro escluso viene dimenticato
+	optedOut := newPoolVM("web", "web-0", nil, "52:54:00:00:00:01")
+	optedOut.Annotations = map[string]string{AnnotationEnabled: "false"}
+	mapper.ApplyVM(ctx, optedOut)
+	if _, found := mapper.LookupPoolMember("52:54:00:00:00:01"); found {
+		t.Error("Expected the MAC of an opted-out member to be forgotten")
+	}
+
+	// Dimenticato con il pool
+	if err := mapper.client.Delete(ctx, newTestPool("web", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mapper.RefreshMapping(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, found := mapper.LookupPoolMember("52:54:00:00:00:02"); found {
+		t.Error("Expected the MAC of a member of a deleted pool to be forgotten")
+	}
+}
+
+func TestVMStarter_StartPoolMember(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	paused := newTestPool("paused", 1)
+	paused.Spec.Paused = true
+	c := newPolicyClient(t, newTestPool("web", 1), paused)
+	starter := NewVMStarter(c, logr.Discard())
+	starter.EnableImpersonation(&rest.Config{Host: server.URL}, c.Scheme())
+	starter.settleInterval = 10 * time.Millisecond
+	ctx := context.Background()
+
+	if err := starter.StartPoolMemberAs(ctx, "", "tenant", "paused", "paused-1"); err == nil {
+		t.Error("Expected a paused pool not to be scaled up")
+	}
+	if err := starter.StartPoolMemberAs(ctx, "", "tenant", "web", "web-2"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	pool := &poolv1alpha1.VirtualMachinePool{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "tenant", Name: "web"}, pool); err != nil {
+		t.Fatal(err)
+	}
+	if *pool.Spec.Replicas != 3 {
+		t.Errorf("Expected the pool to be scaled up to 3 replicas, got %d", *pool.Spec.Replicas)
+	}
+	if starter.QueuedWakes() != 1 {
+		t.Fatalf("Expected the start of web-2 to be queued, got %d", starter.QueuedWakes())
+	}
+
+	// Il membro ricreato dal pool viene avviato
+	halted := kubevirtv1.RunStrategyHalted
+	vm := newPoolVM("web", "web-2", nil, "")
+	vm.Spec.RunStrategy = &halted
+	if err := c.Create(ctx, vm); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for starter.QueuedWakes() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := "/apis/subresources.kubevirt.io/v1/namespaces/tenant/virtualmachines/web-2/start"; len(calls) != 1 || calls[0] != want {
+		t.Errorf("Expected the recreated member to be started with %s, got %v", want, calls)
+	}
+}
+
+func TestAggregator_WakeRemovedPoolMember(t *testing.T) {
+	mapper := newWatchMapper(t, newTestPool("web", 2), newPoolVM("web", "web-1", nil, "52:54:00:00:00:02"))
+	ctx := context.Background()
+	mapper.RemoveVM(ctx, "tenant", "web-1")
+	starter := &poolStarter{policyStarter{actions: make(map[string]string)}}
+	agg := NewAggregator(mapper, starter, logr.Discard())
+
+	resp, err := agg.ReportWOLEvent(ctx, &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:02", NodeName: "node-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != wolv1.ResponseStatus_VM_START_INITIATED || starter.actions["web-1"] != "pool web" {
+		t.Errorf("Expected the pool to recreate web-1, got %v (actions %v)", resp, starter.actions)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// defaultRateLimitBurst is the bucket size when TokenBucketSpec.Burst is unset
+const defaultRateLimitBurst = 5
+
+// Scope of the bucket that rejected a packet, used as metric label
+const (
+	rateLimitMAC  = "mac"
+	rateLimitNode = "node"
+)
+
+// eventRateLimiter holds the token buckets of the MACs and nodes of each WolConfig
+type eventRateLimiter struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*rate.Limiter // chiave: scope|wolconfig|MAC o nodo
+}
+
+func newEventRateLimiter() *eventRateLimiter {
+	return &eventRateLimiter{
+		now:     time.Now,
+		buckets: make(map[string]*rate.Limiter),
+	}
+}
+
+// allow takes a token from the MAC and node buckets of the config. It returns
+// the scope of the empty bucket, or "" if the packet is allowed; an empty
+// bucket leaves the other one untouched.
+func (l *eventRateLimiter) allow(configName string, spec *wolv1beta1.EventRateLimitSpec, mac, node string) string {
+	return l.check(configName, spec, mac, node, true)
+}
+
+// peek returns the scope allow would return, without taking the tokens
+func (l *eventRateLimiter) peek(configName string, spec *wolv1beta1.EventRateLimitSpec, mac, node string) string {
+	return l.check(configName, spec, mac, node, false)
+}
+
+func (l *eventRateLimiter) check(configName string, spec *wolv1beta1.EventRateLimitSpec, mac, node string, take bool) string {
+	if spec == nil {
+		return ""
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var limiters []*rate.Limiter
+	for _, bucket := range []struct {
+		scope, id string
+		spec      *wolv1beta1.TokenBucketSpec
+	}{
+		{rateLimitMAC, mac, spec.PerMAC},
+		{rateLimitNode, node, spec.PerNode},
+	} {
+		if bucket.spec == nil || bucket.id == "" {
+			continue
+		}
+		limiter := l.bucket(bucket.scope+"|"+configName+"|"+bucket.id, bucket.spec, now)
+		if limiter.TokensAt(now) < 1 {
+			return bucket.scope
+		}
+		limiters = append(limiters, limiter)
+	}
+	for _, limiter := range limiters {
+		if take {
+			limiter.AllowN(now, 1)
+		}
+	}
+	return ""
+}
+
+// bucket returns the limiter of key, following changes of the spec. l.mu must be held.
+func (l *eventRateLimiter) bucket(key string, spec *wolv1beta1.TokenBucketSpec, now time.Time) *rate.Limiter {
+	limit := rate.Limit(float64(spec.RequestsPerMinute) / 60)
+	burst := int(spec.Burst)
+	if burst <= 0 {
+		burst = defaultRateLimitBurst
+	}
+	limiter, ok := l.buckets[key]
+	if !ok {
+		limiter = rate.NewLimiter(limit, burst)
+		l.buckets[key] = limiter
+		return limiter
+	}
+	if limiter.Limit() != limit {
+		limiter.SetLimitAt(now, limit)
+	}
+	if limiter.Burst() != burst {
+		limiter.SetBurstAt(now, burst)
+	}
+	return limiter
+}
+
+// cleanup drops the buckets that refilled: a new bucket starts full anyway
+func (l *eventRateLimiter) cleanup() {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, limiter := range l.buckets {
+		if limiter.TokensAt(now) >= float64(limiter.Burst()) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimit returns the rate limit of a WolConfig, nil if it has none
+func (m *MACMapper) RateLimit(configName string) *wolv1beta1.EventRateLimitSpec {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range m.configs {
+		if m.configs[i].Name == configName {
+			return m.configs[i].Spec.RateLimit
+		}
+	}
+	return nil
+}
+
+// enforceRateLimit returns a RATE_LIMITED response if the packet is over the
+// MAC or node token bucket of the WolConfig of the VM, nil if it can go on
+func (a *Aggregator) enforceRateLimit(event *wolv1.WOLEvent, vmInfo VMInfo, startTime time.Time) *wolv1.WOLEventResponse {
+	scope := a.rateLimiter.allow(vmInfo.ConfigName, a.mapper.RateLimit(vmInfo.ConfigName), event.MacAddress, event.NodeName)
+	if scope == "" {
+		return nil
+	}
+	RateLimitedTotal.WithLabelValues(vmInfo.ConfigName, scope).Inc()
+
+	id := event.MacAddress
+	if scope == rateLimitNode {
+		id = event.NodeName
+	}
+	// In un flood ogni pacchetto finirebbe nei log
+	a.log.V(1).Info("WOL event rate limited", "mac", event.MacAddress, "node", event.NodeName,
+		"vm", vmInfo.Name, "namespace", vmInfo.Namespace, "wolconfig", vmInfo.ConfigName, "scope", scope)
+	return &wolv1.WOLEventResponse{
+		Status:           wolv1.ResponseStatus_RATE_LIMITED,
+		Message:          fmt.Sprintf("Rate limit of WolConfig %s exceeded for %s %s", vmInfo.ConfigName, scope, id),
+		VmInfo:           &wolv1.VMInfo{Name: vmInfo.Name, Namespace: vmInfo.Namespace},
+		ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestEventRateLimiter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newEventRateLimiter()
+	limiter.now = func() time.Time { return now }
+	spec := &wolv1beta1.EventRateLimitSpec{
+		PerMAC:  &wolv1beta1.TokenBucketSpec{RequestsPerMinute: 6, Burst: 2},
+		PerNode: &wolv1beta1.TokenBucketSpec{RequestsPerMinute: 60, Burst: 3},
+	}
+
+	for i, want := range []string{"", "", rateLimitMAC} {
+		if scope := limiter.allow("lab", spec, "mac1", "node1"); scope != want {
+			t.Errorf("Packet %d: expected %q, got %q", i, want, scope)
+		}
+	}
+	// Il bucket del nodo ha ancora un token: il MAC rifiutato non lo consuma
+	if scope := limiter.allow("lab", spec, "mac2", "node1"); scope != "" {
+		t.Errorf("Expected another MAC to be allowed, got %q", scope)
+	}
+	if scope := limiter.allow("lab", spec, "mac3", "node1"); scope != rateLimitNode {
+		t.Errorf("Expected the node bucket to be empty, got %q", scope)
+	}
+	// Bucket separati per ogni WolConfig
+	if scope := limiter.allow("other", spec, "mac1", "node1"); scope != "" {
+		t.Errorf("Expected the buckets to be per WolConfig, got %q", scope)
+	}
+
+	// 6 richieste al minuto: un token ogni 10s
+	now = now.Add(10 * time.Second)
+	if scope := limiter.allow("lab", spec, "mac1", "node1"); scope != "" {
+		t.Errorf("Expected the MAC bucket to refill, got %q", scope)
+	}
+
+	now = now.Add(time.Hour)
+	limiter.cleanup()
+	if len(limiter.buckets) != 0 {
+		t.Errorf("Expected the refilled buckets to be dropped, got %d", len(limiter.buckets))
+	}
+	if scope := limiter.allow("lab", nil, "mac1", "node1"); scope != "" {
+		t.Errorf("Expected no limit without spec, got %q", scope)
+	}
+}
+
+func TestAggregator_RateLimit(t *testing.T) {
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:01", VMName: "vm1", Namespace: "default"},
+			},
+			RateLimit: &wolv1beta1.EventRateLimitSpec{
+				PerMAC: &wolv1beta1.TokenBucketSpec{RequestsPerMinute: 1, Burst: 1},
+			},
+		},
+	}
+	config.Name = "lab"
+	mapper.UpdateConfig(config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	starter := &policyStarter{actions: make(map[string]string)}
+	agg := NewAggregator(mapper, starter, logr.Discard())
+
+	resp, _ := agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01", DestinationPort: 9})
+	if resp.Status != wolv1.ResponseStatus_VM_START_INITIATED {
+		t.Fatalf("Expected the first packet to start the VM, got %v", resp.Status)
+	}
+	// Una porta diversa non è un duplicato, ma consuma lo stesso bucket del MAC
+	resp, _ = agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01", DestinationPort: 7})
+	if resp.Status != wolv1.ResponseStatus_RATE_LIMITED || resp.VmInfo.GetName() != "vm1" {
+		t.Errorf("Expected the second packet to be rate limited, got %v: %s", resp.Status, resp.Message)
+	}
+	// La risposta rifiutata non resta nella cache di deduplica
+	if _, cached := agg.dedupeMap[dedupeKey("52:54:00:00:00:01", 7)]; cached {
+		t.Error("Expected the rate limited packet not to be cached")
+	}
+}
@@ -5,32 +5,109 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/sys/unix"
 )
 
 // -------------------- Opzioni & costruttori --------------------
 
+// Capture modes reported by a RawListener
+const (
+	// CaptureModePromiscuous: the NIC accepts every frame, the BPF filter keeps WoL (and ARP)
+	CaptureModePromiscuous = "promiscuous"
+	// CaptureModeMembership: only frames the NIC accepts anyway (own MAC, broadcast,
+	// joined multicast) reach the BPF filter. Since the filter keeps only EtherType
+	// 0x0842 and ARP, in practice this means broadcast 0x0842 frames: WoL sent over
+	// UDP (including to multicast addresses) is handled by the UDP listener.
+	CaptureModeMembership = "membership"
+	// CaptureModeXDP: an XDP program copies the frames accepted by the filter
+	// to userspace, without promiscuous mode (see xdp.go)
+	CaptureModeXDP = "xdp"
+)
+
+// Capture backends of the raw listeners
+const (
+	// CaptureBackendAFPacket captures with an AF_PACKET socket and a classic BPF filter
+	CaptureBackendAFPacket = "afpacket"
+	// CaptureBackendEBPF captures with an XDP program, falling back to
+	// AF_PACKET where it cannot be attached
+	CaptureBackendEBPF = "ebpf"
+)
+
 type RawListenerOptions struct {
-	Promiscuous    bool // default true
-	AttachBPF      bool // default true
-	RecvTimeoutSec int  // default 1
+	Promiscuous     bool // default true
+	AttachBPF       bool // default true
+	RecvTimeoutSec  int  // default 1
+	ReadBufferBytes int  // SO_RCVBUF, 0 = default del kernel
+	// EtherTypes dei frame con un magic packet, oltre a 0x0842
+	EtherTypes []uint16
+	// UDPPorts: datagrammi IPv4 UDP (anche broadcast) analizzati alla ricerca di
+	// magic packet, passati al directed handler (es. 7 per i router che
+	// riscrivono il WoL in UDP/7)
+	UDPPorts []uint16
+	// ReadErrors conta gli errori di lettura del socket (opzionale)
+	ReadErrors prometheus.Counter
+	// Backend di cattura, CaptureBackendAFPacket se vuoto
+	Backend string
+	// VLANs accettate (0 = frame senza tag); vuoto = tutte
+	VLANs []uint16
+	// VLANFrames conta i frame per VLAN e risultato, con VLANs (opzionale)
+	VLANFrames *prometheus.CounterVec
+	// PacketLog campiona e offusca i log per pacchetto (nil = tutti, in chiaro)
+	PacketLog *PacketLogger
 }
 
 type RawListener struct {
 	interfaceName string
 	fd            int
 	log           logr.Logger
-	packetHandler func(mac string, srcMAC net.HardwareAddr)
-
-	promisc   bool
-	attachBPF bool
-	rcvTOsec  int
+	packetHandler func(mac string, payload []byte, srcMAC net.HardwareAddr)
+	arpHandler    func(req ARPRequest) // opzionale: cattura anche le richieste ARP
+	// opzionale: frame di sleep (Shutdown-on-LAN) con un EtherType dedicato
+	sleepHandler   func(mac string, payload []byte, srcMAC net.HardwareAddr)
+	sleepEtherType uint16
+	// opzionale: magic packet unicast UDP verso le VM (IPv4, porte directedPorts)
+	// e datagrammi verso udpPorts con qualsiasi destinazione
+	directedHandler func(pkt DirectedPacket)
+	directedPorts   []uint16
+	udpPorts        []uint16
+	etherTypes      []uint16 // EtherType dei frame WoL (0x0842 e quelli delle opzioni)
+	readErrorsTotal prometheus.Counter
+	vlans           []uint16 // VLAN accettate (vedi vlan.go)
+	vlanFrames      *prometheus.CounterVec
+	packetLog       *PacketLogger
+
+	// opzionale: risposte ARP/NDP per gli IP delle VM spente (vedi responder.go)
+	neighborLookup  func(ip net.IP) net.HardwareAddr
+	neighborReplied func(protocol string, err error)
+	hwAddr          net.HardwareAddr // MAC dell'interfaccia, impostato da Start
+	ifIndex         int
+
+	// opzionale: ping, SYN e query DNS che svegliano le VM spente (vedi triggers.go)
+	triggerHandler func(pkt TriggerPacket)
+	triggers       WakeTriggers
+
+	backend     string
+	xdp         *xdpCapture // programma XDP, con il backend eBPF attivo
+	promisc     bool
+	attachBPF   bool
+	rcvTOsec    int
+	rcvBuf      int
+	captureMode string // modalità effettiva, impostata da Start
+	promiscErr  error  // errore di PACKET_MR_PROMISC quando richiesto ma non attivato
+
+	heartbeat  atomic.Int64 // ultimo giro del loop (unix nano), per il watchdog
+	readErrors atomic.Int32 // errori di lettura consecutivi
+	exited     atomic.Bool  // loop terminato per errori
 
 	stopOnce sync.Once
 	closed   atomic.Bool
@@ -38,7 +115,7 @@ type RawListener struct {
 }
 
 // Backward-compatible constructor (same signature as prima)
-func NewRawListener(interfaceName string, packetHandler func(mac string, srcMAC net.HardwareAddr), log logr.Logger) *RawListener {
+func NewRawListener(interfaceName string, packetHandler func(mac string, payload []byte, srcMAC net.HardwareAddr), log logr.Logger) *RawListener {
 	return NewRawListenerWithOptions(interfaceName, packetHandler, log, RawListenerOptions{
 		Promiscuous:    true,
 		AttachBPF:      true,
@@ -46,19 +123,76 @@ func NewRawListener(interfaceName string, packetHandler func(mac string, srcMAC
 	})
 }
 
-func NewRawListenerWithOptions(interfaceName string, packetHandler func(mac string, srcMAC net.HardwareAddr), log logr.Logger, opt RawListenerOptions) *RawListener {
+func NewRawListenerWithOptions(interfaceName string, packetHandler func(mac string, payload []byte, srcMAC net.HardwareAddr), log logr.Logger, opt RawListenerOptions) *RawListener {
 	if opt.RecvTimeoutSec <= 0 {
 		opt.RecvTimeoutSec = 1
 	}
+	etherTypes := []uint16{etherTypeWoL}
+	for _, etherType := range opt.EtherTypes {
+		if !slices.Contains(etherTypes, etherType) {
+			etherTypes = append(etherTypes, etherType)
+		}
+	}
 	return &RawListener{
-		interfaceName: interfaceName,
-		fd:            -1,
-		log:           log,
-		packetHandler: packetHandler,
-		promisc:       opt.Promiscuous,
-		attachBPF:     opt.AttachBPF,
-		rcvTOsec:      opt.RecvTimeoutSec,
+		interfaceName:   interfaceName,
+		fd:              -1,
+		log:             log,
+		packetHandler:   packetHandler,
+		promisc:         opt.Promiscuous,
+		attachBPF:       opt.AttachBPF,
+		rcvTOsec:        opt.RecvTimeoutSec,
+		rcvBuf:          opt.ReadBufferBytes,
+		etherTypes:      etherTypes,
+		udpPorts:        opt.UDPPorts,
+		readErrorsTotal: opt.ReadErrors,
+		backend:         opt.Backend,
+		vlans:           opt.VLANs,
+		vlanFrames:      opt.VLANFrames,
+		packetLog:       opt.PacketLog,
+	}
+}
+
+// SetARPHandler enables the capture of ARP who-has requests, passed to handler.
+// Must be called before Start, since it changes the BPF filter.
+func (r *RawListener) SetARPHandler(handler func(req ARPRequest)) {
+	r.arpHandler = handler
+}
+
+// SetSleepHandler enables the capture of broadcast frames with the given
+// EtherType carrying a magic packet, passed to handler as sleep packets.
+// Must be called before Start, since it changes the BPF filter.
+func (r *RawListener) SetSleepHandler(etherType uint16, handler func(mac string, payload []byte, srcMAC net.HardwareAddr)) {
+	r.sleepEtherType = etherType
+	r.sleepHandler = handler
+}
+
+// SetDirectedHandler enables the capture of magic packets sent as unicast IPv4
+// UDP to one of ports, passed to handler. The handler also receives the
+// datagrams to the UDPPorts of the options, broadcast included. Frames sent by
+// the node itself (e.g. forwarded packets) are ignored. Must be called before
+// Start, since it changes the BPF filter.
+func (r *RawListener) SetDirectedHandler(ports []uint16, handler func(pkt DirectedPacket)) {
+	r.directedPorts = ports
+	r.directedHandler = handler
+}
+
+// CaptureMode returns the capture mode active on the interface (empty before Start)
+func (r *RawListener) CaptureMode() string {
+	return r.captureMode
+}
+
+// PromiscuousError returns why promiscuous mode could not be enabled, nil if it
+// was not requested or is active
+func (r *RawListener) PromiscuousError() error {
+	return r.promiscErr
+}
+
+// Healthy returns true if the listen loop is running and went around recently
+func (r *RawListener) Healthy(now time.Time, staleAfter time.Duration) bool {
+	if r.exited.Load() || r.closed.Load() {
+		return false
 	}
+	return now.Sub(time.Unix(0, r.heartbeat.Load())) < staleAfter
 }
 
 // -------------------- Avvio / Arresto --------------------
@@ -73,7 +207,16 @@ func (r *RawListener) Start(ctx context.Context) error {
 	r.log.Info("Starting raw Ethernet WoL listener",
 		"interface", ifi.Name,
 		"mac", ifi.HardwareAddr.String(),
-		"mtu", ifi.MTU)
+		"mtu", ifi.MTU,
+		"backend", r.backend)
+
+	if r.backend == CaptureBackendEBPF {
+		err := r.startXDP(ctx, ifi)
+		if err == nil {
+			return nil
+		}
+		r.log.Error(err, "Failed to attach the XDP program, falling back to AF_PACKET capture", "interface", ifi.Name)
+	}
 
 	// Create raw socket
 	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_ALL)))
@@ -81,6 +224,7 @@ func (r *RawListener) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to create raw socket: %w (requires CAP_NET_RAW)", err)
 	}
 	r.fd = fd
+	r.hwAddr, r.ifIndex = ifi.HardwareAddr, ifi.Index
 
 	// Bind to interface
 	addr := &unix.SockaddrLinklayer{
@@ -93,33 +237,35 @@ func (r *RawListener) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to bind to interface %s: %w", ifi.Name, err)
 	}
 
-	// Optional: promiscuous mode
+	// Optional: promiscuous mode. If it fails the listener keeps running in
+	// membership mode, but the failure is reported (log, metric)
+	r.captureMode = CaptureModeMembership
+	r.promiscErr = nil
 	if r.promisc {
 		mreq := &unix.PacketMreq{
 			Ifindex: int32(ifi.Index),
 			Type:    unix.PACKET_MR_PROMISC,
 		}
 		if err := unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, mreq); err != nil {
-			r.log.V(1).Info("Failed to set promiscuous mode (continuing)", "error", err)
+			r.promiscErr = err
+			r.log.Error(err, "Failed to set promiscuous mode, WoL frames unicast to VM MACs will be missed",
+				"interface", ifi.Name)
+		} else {
+			r.captureMode = CaptureModePromiscuous
 		}
 	}
 
-	// Optional: attach BPF to accept only EtherType 0x0842 (WoL L2)
+	// Optional: attach BPF to accept only the WoL EtherTypes (0x0842 and the
+	// configured ones), plus ARP, the sleep EtherType, the neighbor
+	// solicitations, UDP to the captured ports and the wake triggers if enabled
+	// Il tag VLAN rimosso dal kernel è riportato in un messaggio di controllo
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_AUXDATA, 1); err != nil {
+		r.log.V(1).Info("Failed to enable PACKET_AUXDATA, VLAN tags stripped by the kernel are lost (continuing)",
+			"error", err)
+	}
+
 	if r.attachBPF {
-		// Classic BPF program:
-		//  Load half at [12] (EtherType), accept if == 0x0842, else drop
-		bpf := []unix.SockFilter{
-			// ldh [12] - Load halfword (16-bit) at offset 12 (EtherType position)
-			{Code: 0x28, Jt: 0, Jf: 0, K: 12},
-			// jeq #0x0842 - Jump if equal to 0x0842
-			// Jt: 0 = if match, go to next instruction (accept)
-			// Jf: 1 = if no match, skip 1 instruction (drop)
-			{Code: 0x15, Jt: 0, Jf: 1, K: 0x0842},
-			// ret #0x40000 (accept entire packet - snaplen)
-			{Code: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
-			// ret #0 (drop packet)
-			{Code: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
-		}
+		bpf := r.captureSpec().filter()
 		fprog := unix.SockFprog{
 			Len:    uint16(len(bpf)),
 			Filter: &bpf[0],
@@ -135,20 +281,92 @@ func (r *RawListener) Start(ctx context.Context) error {
 		r.log.V(1).Info("Failed to set SO_RCVTIMEO (continuing)", "error", err)
 	}
 
-	r.log.Info("Raw Ethernet listener started", "interface", r.interfaceName, "fd", fd)
+	if r.rcvBuf > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, r.rcvBuf); err != nil {
+			r.log.V(1).Info("Failed to set SO_RCVBUF (continuing)", "error", err)
+		}
+	}
+
+	r.log.Info("Raw Ethernet listener started", "interface", r.interfaceName, "fd", fd, "captureMode", r.captureMode)
 
 	// Start loop
+	r.heartbeat.Store(time.Now().UnixNano())
 	r.wg.Add(1)
 	go r.listen(ctx)
 	return nil
 }
 
+// captureSpec returns the frames the handlers of the listener need
+func (r *RawListener) captureSpec() captureSpec {
+	etherTypes := slices.Clone(r.etherTypes)
+	if r.arpHandler != nil || r.neighborLookup != nil {
+		// Richieste ARP per il wake su richiesta ARP e il responder
+		etherTypes = append(etherTypes, etherTypeARP)
+	}
+	if r.sleepHandler != nil {
+		etherTypes = append(etherTypes, r.sleepEtherType)
+	}
+	spec := captureSpec{
+		etherTypes:            etherTypes,
+		udpPorts:              r.capturedUDPPorts(),
+		neighborSolicitations: r.neighborLookup != nil,
+		vlanTags:              len(r.vlans) > 0,
+	}
+	if r.triggerHandler != nil {
+		spec.icmpEcho, spec.tcpPorts = r.triggers.ICMPEcho, r.triggers.TCPPorts
+		if r.triggers.DNS {
+			for _, port := range dnsPorts {
+				if !slices.Contains(spec.udpPorts, port) {
+					spec.udpPorts = append(spec.udpPorts, port)
+				}
+			}
+		}
+	}
+	return spec
+}
+
+// startXDP starts the capture with an XDP program on the interface
+func (r *RawListener) startXDP(ctx context.Context, ifi *net.Interface) error {
+	capture, err := openXDPCapture(ifi.Index, r.captureSpec().filter())
+	if err != nil {
+		return err
+	}
+	// Senza protocollo il socket non riceve frame: serve solo alle risposte ARP/NDP
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, 0)
+	if err == nil {
+		if err = unix.Bind(fd, &unix.SockaddrLinklayer{Ifindex: ifi.Index}); err != nil {
+			unix.Close(fd)
+		}
+	}
+	if err != nil {
+		capture.close()
+		return fmt.Errorf("failed to create the reply socket: %w (requires CAP_NET_RAW)", err)
+	}
+	r.fd, r.xdp = fd, capture
+	r.hwAddr, r.ifIndex = ifi.HardwareAddr, ifi.Index
+	r.captureMode = CaptureModeXDP
+	r.promiscErr = nil
+
+	r.log.Info("Raw Ethernet listener started", "interface", r.interfaceName, "captureMode", r.captureMode)
+	r.heartbeat.Store(time.Now().UnixNano())
+	r.wg.Add(1)
+	go r.listenXDP(ctx)
+	return nil
+}
+
 func (r *RawListener) Stop() {
 	r.stopOnce.Do(func() {
 		if r.closed.Load() {
 			return
 		}
 		r.closed.Store(true)
+		if r.xdp != nil {
+			// Il programma è staccato subito, i ring solo a loop terminato
+			r.xdp.detach()
+			r.wg.Wait()
+			r.xdp.close()
+			r.xdp = nil
+		}
 		if r.fd >= 0 {
 			// Unblock any Recvfrom
 			_ = unix.Shutdown(r.fd, unix.SHUT_RD)
@@ -167,34 +385,102 @@ func (r *RawListener) Stop() {
 
 func (r *RawListener) listen(ctx context.Context) {
 	defer r.wg.Done()
-	buffer := make([]byte, 2000) // un po' più di 1500 per eventuali tag
+	// un po' più di 1500 per eventuali tag, più 4 byte per reinserire il tag
+	// VLAN rimosso dal kernel
+	buffer := make([]byte, 4+2000)
+	oob := make([]byte, unix.CmsgSpace(int(unsafe.Sizeof(unix.TpacketAuxdata{}))))
 	r.log.Info("Raw Ethernet listener loop started, waiting for WoL packets...")
 
 	for {
+		r.heartbeat.Store(time.Now().UnixNano())
 		if ctx.Err() != nil || r.closed.Load() {
 			r.log.Info("Context cancelled or listener closed, stopping raw listener loop")
 			return
 		}
 
-		n, _, err := unix.Recvfrom(r.fd, buffer, 0)
+		n, oobn, _, from, err := unix.Recvmsg(r.fd, buffer[4:], oob, 0)
 		if err != nil {
 			// normal timeouts or interruptions
 			if err == unix.EAGAIN || err == unix.EWOULDBLOCK || err == unix.EINTR {
+				r.readErrors.Store(0)
 				continue
 			}
 			if ctx.Err() != nil || r.closed.Load() {
 				return
 			}
 			r.log.Error(err, "Error reading raw packet")
-			// If you have metrics:
-			// ErrorsTotal.Inc()
+			ErrorsTotal.Inc()
+			if r.readErrorsTotal != nil {
+				r.readErrorsTotal.Inc()
+			}
+			// Crash-only: dopo troppi errori il loop esce e il watchdog ricrea il socket
+			if r.readErrors.Add(1) >= maxListenerReadErrors {
+				r.log.Error(err, "Too many consecutive raw read errors, stopping raw listener loop")
+				r.exited.Store(true)
+				return
+			}
 			continue
 		}
+		r.readErrors.Store(0)
 		if n <= 14 {
 			continue
 		}
+		frame := buffer[4 : 4+n]
+		// I pacchetti IP inviati dal nodo (es. inoltri agli host fisici) non sono wake diretti
+		if sll, ok := from.(*unix.SockaddrLinklayer); ok && sll.Pkttype == unix.PACKET_OUTGOING &&
+			binary.BigEndian.Uint16(frame[12:14]) == etherTypeIPv4 {
+			continue
+		}
+		if tpid, tci, ok := strippedVLANTag(oob[:oobn]); ok {
+			frame = insertVLANTag(buffer[:4+n], tpid, tci)
+		}
+
+		r.processEthernetFrame(frame)
+	}
+}
+
+// listenXDP reads the frames captured by the XDP program
+func (r *RawListener) listenXDP(ctx context.Context) {
+	defer r.wg.Done()
+	timeout := time.Duration(r.rcvTOsec) * time.Second
+	r.log.Info("XDP listener loop started, waiting for WoL packets...")
+
+	for {
+		r.heartbeat.Store(time.Now().UnixNano())
+		if ctx.Err() != nil || r.closed.Load() {
+			r.log.Info("Context cancelled or listener closed, stopping XDP listener loop")
+			return
+		}
 
-		r.processEthernetFrame(buffer[:n])
+		lost, err := r.xdp.poll(timeout, func(frame []byte) {
+			if len(frame) > 14 {
+				r.processEthernetFrame(frame)
+			}
+		})
+		if lost > 0 {
+			// Ring pieno: i frame persi sono contati come errori di lettura
+			r.log.V(1).Info("XDP ring full, frames lost", "lost", lost)
+			if r.readErrorsTotal != nil {
+				r.readErrorsTotal.Add(float64(lost))
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil || r.closed.Load() {
+				return
+			}
+			r.log.Error(err, "Error reading the XDP ring")
+			ErrorsTotal.Inc()
+			if r.readErrorsTotal != nil {
+				r.readErrorsTotal.Inc()
+			}
+			if r.readErrors.Add(1) >= maxListenerReadErrors {
+				r.log.Error(err, "Too many consecutive XDP read errors, stopping raw listener loop")
+				r.exited.Store(true)
+				return
+			}
+			continue
+		}
+		r.readErrors.Store(0)
 	}
 }
 
@@ -204,17 +490,17 @@ func (r *RawListener) processEthernetFrame(frame []byte) {
 	// Ethernet header: 14 bytes
 	dstMAC := frame[0:6]
 	srcMAC := frame[6:12]
-	etherType := binary.BigEndian.Uint16(frame[12:14])
-	payload := frame[14:]
 
-	// VLAN 802.1Q tag (0x8100): shift di 4 byte e leggi EtherType interno
-	if etherType == 0x8100 {
-		if len(payload) < 4 {
-			return
-		}
-		// payload[0:2] = TCI, payload[2:4] = inner EtherType
-		etherType = binary.BigEndian.Uint16(payload[2:4])
-		payload = payload[4:]
+	// Tag VLAN 802.1Q/802.1ad (fino a due, QinQ): EtherType interno dopo i tag,
+	// riusati nelle risposte ARP/NDP
+	etherType, vlans, offset, ok := parseVLANTags(frame)
+	if !ok || !r.acceptVLAN(vlans) {
+		return
+	}
+	payload := frame[offset:]
+	var vlanTag []byte
+	if len(vlans) > 0 {
+		vlanTag = frame[12 : offset-2]
 	}
 
 	// // Log ALL broadcast packets for debugging (temporary - change to V(1) in production)
@@ -226,9 +512,63 @@ func (r *RawListener) processEthernetFrame(frame []byte) {
 	// 		"interface", r.interfaceName)
 	// }
 
-	// WoL L2 classico: EtherType 0x0842
-	if etherType != 0x0842 {
-		// Non è WoL L2; se vuoi, potresti anche analizzare IPv4/UDP:9 qui
+	// Richiesta ARP (solo se il wake su ARP o il responder sono abilitati)
+	if etherType == etherTypeARP {
+		if req, ok := parseARPRequest(payload); ok {
+			if r.neighborLookup != nil {
+				r.answerARP(req, vlanTag)
+			}
+			if r.arpHandler != nil {
+				r.arpHandler(req)
+			}
+		}
+		return
+	}
+
+	// Neighbor solicitation IPv6 (solo con il responder)
+	if etherType == etherTypeIPv6 {
+		if r.neighborLookup != nil {
+			if ns, ok := parseNeighborSolicitation(payload, srcMAC); ok {
+				r.answerNDP(ns, vlanTag)
+			}
+		}
+		return
+	}
+
+	// Magic packet in UDP: unicast verso l'IP della VM (etherwake/wakeonlan) o
+	// verso le porte catturate. Broadcast e multicast verso le sole porte
+	// directed arrivano già al listener UDP
+	if etherType == etherTypeIPv4 {
+		// Trigger di wake (ping, SYN, query DNS) verso le VM spente
+		if r.triggerHandler != nil {
+			if pkt, ok := parseTriggerPacket(payload, r.triggers); ok {
+				r.triggerHandler(pkt)
+				return
+			}
+		}
+		ports := r.capturedUDPPorts()
+		if len(ports) == 0 {
+			return
+		}
+		pkt, ok := parseDirectedUDP(payload, ports)
+		if !ok || (dstMAC[0]&0x01 != 0 && !slices.Contains(r.udpPorts, pkt.Port)) {
+			return
+		}
+		r.packetLog.Info(r.log, "Valid WoL magic packet received (directed UDP)",
+			"targetMAC", pkt.TargetMAC,
+			"source", r.packetLog.SourceAddr(pkt.Source),
+			"destination", pkt.Destination.String(),
+			"port", pkt.Port,
+			"interface", r.interfaceName,
+			"vlan", vlanString(vlans))
+		r.directedHandler(pkt)
+		return
+	}
+
+	// WoL L2: EtherType 0x0842 (o configurato), o frame di sleep se abilitati
+	sleep := r.sleepHandler != nil && etherType == r.sleepEtherType
+	if !sleep && !slices.Contains(r.etherTypes, etherType) {
+		// Non è WoL L2
 		return
 	}
 
@@ -244,15 +584,22 @@ func (r *RawListener) processEthernetFrame(frame []byte) {
 	}
 
 	src := net.HardwareAddr(append([]byte{}, srcMAC...)) // copia
-	r.log.Info("Valid WoL magic packet received (raw Ethernet)",
+	r.packetLog.Info(r.log, "Valid WoL magic packet received (raw Ethernet)",
 		"targetMAC", mac,
-		"sourceMAC", src.String(),
+		"sourceMAC", r.packetLog.Source(src.String()),
 		"etherType", fmt.Sprintf("0x%04x", etherType),
 		"interface", r.interfaceName,
-		"payloadSize", len(payload))
+		"vlan", vlanString(vlans),
+		"payloadSize", len(payload),
+		"sleep", sleep)
 
+	if sleep {
+		r.sleepHandler(mac, append([]byte{}, payload...), src)
+		return
+	}
 	if r.packetHandler != nil {
-		r.packetHandler(mac, src)
+		// Il buffer di lettura viene riutilizzato: passa una copia del payload
+		r.packetHandler(mac, append([]byte{}, payload...), src)
 	}
 
 	// If you have metrics:
@@ -261,6 +608,21 @@ func (r *RawListener) processEthernetFrame(frame []byte) {
 
 // -------------------- Helpers --------------------
 
+// capturedUDPPorts returns the UDP ports whose datagrams are parsed, nil
+// without a directed handler
+func (r *RawListener) capturedUDPPorts() []uint16 {
+	if r.directedHandler == nil {
+		return nil
+	}
+	ports := slices.Clone(r.directedPorts)
+	for _, port := range r.udpPorts {
+		if !slices.Contains(ports, port) {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
 func isBroadcastMAC(b []byte) bool {
 	if len(b) != 6 {
 		return false
@@ -273,10 +635,229 @@ func isBroadcastMAC(b []byte) bool {
 	return true
 }
 
+// etherTypeFilter builds a classic BPF program accepting the frames with one
+// of the given EtherTypes
+func etherTypeFilter(etherTypes []uint16) []unix.SockFilter {
+	return captureFilter(etherTypes, nil, false)
+}
+
+// captureFilter builds a classic BPF program accepting the frames with one of
+// the given EtherTypes, plus the IPv4 UDP datagrams (first fragment) to one of
+// udpPorts and, with neighborSolicitations, the untagged IPv6 neighbor
+// solicitations
+func captureFilter(etherTypes, udpPorts []uint16, neighborSolicitations bool) []unix.SockFilter {
+	return captureSpec{etherTypes: etherTypes, udpPorts: udpPorts, neighborSolicitations: neighborSolicitations}.filter()
+}
+
+// captureSpec describes the frames accepted by the BPF filter of a raw listener
+type captureSpec struct {
+	etherTypes []uint16
+	// datagrammi IPv4 UDP (primo frammento) verso queste porte
+	udpPorts []uint16
+	// neighbor solicitation IPv6
+	neighborSolicitations bool
+	// trigger di wake: echo request ICMP e SYN TCP verso tcpPorts (IPv4)
+	icmpEcho bool
+	tcpPorts []uint16
+	// anche i frame con uno o due tag VLAN nel frame (QinQ)
+	vlanTags bool
+}
+
+// filter assembles the BPF program of the spec
+func (s captureSpec) filter() []unix.SockFilter {
+	var p bpfAssembler
+	if s.vlanTags {
+		// ldh [12]: dopo un tag VLAN (802.1Q, 802.1ad) gli stessi controlli
+		// sono ripetuti 4 byte più avanti, fino a due tag (QinQ)
+		p.op(0x28, 12)
+		p.vlanTPIDs("tag1")
+		s.match(&p, 0, "")
+		p.label("tag1")
+		p.op(0x28, 16)
+		p.vlanTPIDs("tag2")
+		s.match(&p, 4, "1")
+		p.label("tag2")
+		s.match(&p, 8, "2")
+	} else {
+		s.match(&p, 0, "")
+	}
+	// ret #0x40000 (accept entire packet - snaplen)
+	p.label("accept")
+	p.op(0x6, 0x00040000)
+	// ret #0 (drop packet)
+	p.label("drop")
+	p.op(0x6, 0)
+	return p.assemble()
+}
+
+// match appends the checks of the frames whose EtherType is at base+12,
+// jumping to accept or drop; suffix keeps the labels of each copy apart
+func (s captureSpec) match(p *bpfAssembler, base uint32, suffix string) {
+	ipv4 := len(s.udpPorts) > 0 || s.icmpEcho || len(s.tcpPorts) > 0
+	next := "drop"
+	if s.neighborSolicitations {
+		next = "ipv6" + suffix
+	} else if ipv4 {
+		next = "ipv4" + suffix
+	}
+
+	// ldh [12]: EtherType; jeq #etherType per ognuno, l'ultimo prosegue con i
+	// controlli IPv6/IPv4 (o il drop)
+	p.op(0x28, base+12)
+	for i, etherType := range s.etherTypes {
+		jf := ""
+		if i == len(s.etherTypes)-1 {
+			jf = next
+		}
+		p.jump(0x15, uint32(etherType), "accept", jf)
+	}
+	if s.neighborSolicitations {
+		after := "drop"
+		if ipv4 {
+			after = "ipv4" + suffix
+		}
+		p.label("ipv6" + suffix)
+		p.jump(0x15, etherTypeIPv6, "", after)
+		// ldb [20]: next header, jeq #58 (ICMPv6)
+		p.op(0x30, base+20)
+		p.jump(0x15, unix.IPPROTO_ICMPV6, "", "drop")
+		// ldb [54]: tipo ICMPv6, jeq #135 (neighbor solicitation)
+		p.op(0x30, base+54)
+		p.jump(0x15, icmpv6NeighborSolicitation, "accept", "drop")
+	}
+	if ipv4 {
+		p.label("ipv4" + suffix)
+		p.jump(0x15, etherTypeIPv4, "", "drop")
+		// ldh [20], jset #0x1fff: i frammenti successivi al primo non hanno l'header L4
+		p.op(0x28, base+20)
+		p.jump(0x45, 0x1fff, "drop", "")
+		// ldxb 4*([14]&0xf): lunghezza dell'header IP
+		p.op(0xb1, base+14)
+		// ldb [23]: protocollo IP
+		p.op(0x30, base+23)
+		type protocol struct {
+			number uint32
+			label  string
+		}
+		var protocols []protocol
+		if len(s.udpPorts) > 0 {
+			protocols = append(protocols, protocol{unix.IPPROTO_UDP, "udp" + suffix})
+		}
+		if s.icmpEcho {
+			protocols = append(protocols, protocol{unix.IPPROTO_ICMP, "icmp" + suffix})
+		}
+		if len(s.tcpPorts) > 0 {
+			protocols = append(protocols, protocol{unix.IPPROTO_TCP, "tcp" + suffix})
+		}
+		for i, proto := range protocols {
+			jf := ""
+			if i == len(protocols)-1 {
+				jf = "drop"
+			}
+			p.jump(0x15, proto.number, proto.label, jf)
+		}
+		if len(s.udpPorts) > 0 {
+			// ldh [x+16]: porta di destinazione
+			p.label("udp" + suffix)
+			p.op(0x48, base+16)
+			p.ports(s.udpPorts)
+		}
+		if s.icmpEcho {
+			// ldb [x+14]: tipo ICMP, jeq #8 (echo request)
+			p.label("icmp" + suffix)
+			p.op(0x50, base+14)
+			p.jump(0x15, icmpEchoRequest, "accept", "drop")
+		}
+		if len(s.tcpPorts) > 0 {
+			// ldb [x+27]: flag TCP, and #0x12, jeq #0x02: SYN senza ACK
+			p.label("tcp" + suffix)
+			p.op(0x50, base+27)
+			p.op(0x54, tcpFlagSYN|tcpFlagACK)
+			p.jump(0x15, tcpFlagSYN, "", "drop")
+			// ldh [x+16]: porta di destinazione
+			p.op(0x48, base+16)
+			p.ports(s.tcpPorts)
+		}
+	}
+}
+
+// bpfAssembler builds a classic BPF program whose conditional jumps target
+// labels, resolved to relative offsets by assemble
+type bpfAssembler struct {
+	program []unix.SockFilter
+	labels  map[string]int
+	jumps   []bpfJump
+}
+
+type bpfJump struct {
+	pc     int
+	jt, jf string // etichette di destinazione ("" = istruzione successiva)
+}
+
+// op appends an instruction without jumps
+func (p *bpfAssembler) op(code uint16, k uint32) {
+	p.program = append(p.program, unix.SockFilter{Code: code, K: k})
+}
+
+// jump appends a conditional jump to the labels jt and jf
+func (p *bpfAssembler) jump(code uint16, k uint32, jt, jf string) {
+	p.jumps = append(p.jumps, bpfJump{pc: len(p.program), jt: jt, jf: jf})
+	p.op(code, k)
+}
+
+// ports appends the comparisons of the loaded port with ports: accept on a
+// match, drop after the last one
+func (p *bpfAssembler) ports(ports []uint16) {
+	for i, port := range ports {
+		jf := ""
+		if i == len(ports)-1 {
+			jf = "drop"
+		}
+		p.jump(0x15, uint32(port), "accept", jf)
+	}
+}
+
+// vlanTPIDs appends the comparisons of the loaded EtherType with the VLAN
+// TPIDs, jumping to label on a match
+func (p *bpfAssembler) vlanTPIDs(label string) {
+	for _, tpid := range vlanTPIDs {
+		p.jump(0x15, uint32(tpid), label, "")
+	}
+}
+
+// label marks the next instruction
+func (p *bpfAssembler) label(name string) {
+	if p.labels == nil {
+		p.labels = make(map[string]int)
+	}
+	p.labels[name] = len(p.program)
+}
+
+// assemble resolves the jumps. The labels always follow the jumps and the
+// programs are short, so the offsets fit in a byte.
+func (p *bpfAssembler) assemble() []unix.SockFilter {
+	offset := func(pc int, label string) uint8 {
+		if label == "" {
+			return 0
+		}
+		return uint8(p.labels[label] - pc - 1)
+	}
+	for _, j := range p.jumps {
+		p.program[j.pc].Jt = offset(j.pc, j.jt)
+		p.program[j.pc].Jf = offset(j.pc, j.jf)
+	}
+	return p.program
+}
+
 // htons converts uint16 from host to network byte order (big-endian)
 func htons(v uint16) uint16 { return (v << 8) | (v >> 8) }
 
-func GetCandidateInterfaces(log logr.Logger) ([]net.Interface, error) {
+// GetCandidateInterfaces returns the up, broadcast-capable interfaces the raw
+// listeners can use. Without include rules they are picked by name (NICs,
+// Wi-Fi, Linux bridges) and deduplicated by MAC; with include rules every
+// matching interface is used as is (e.g. bond0, vlan100, team0). Interfaces
+// matching the exclude rules are dropped.
+func GetCandidateInterfaces(rules InterfaceRules, log logr.Logger) ([]net.Interface, error) {
 	var result []net.Interface
 	interfaces, err := net.Interfaces()
 	if err != nil {
@@ -294,6 +875,15 @@ func GetCandidateInterfaces(log logr.Logger) ([]net.Interface, error) {
 		if (iface.Flags & net.FlagBroadcast) == 0 {
 			continue
 		}
+		if !rules.Allows(name) {
+			log.V(1).Info("Interface skipped by the interface rules", "iface", name)
+			continue
+		}
+		// Interfacce scelte esplicitamente: niente euristiche sul nome
+		if len(rules.Include) > 0 {
+			result = append(result, iface)
+			continue
+		}
 
 		// Skip virtual / OVS internal interfaces
 		if strings.HasPrefix(name, "veth") ||
@@ -317,6 +907,14 @@ func GetCandidateInterfaces(log logr.Logger) ([]net.Interface, error) {
 	if len(result) == 0 {
 		return nil, fmt.Errorf("no suitable interfaces found")
 	}
+	if len(rules.Include) > 0 {
+		// Una VLAN ha il MAC della sua interfaccia: la deduplica le scarterebbe
+		for _, iface := range result {
+			log.Info("Selected WoL interface candidate", "interface", iface.Name,
+				"mac", iface.HardwareAddr.String())
+		}
+		return result, nil
+	}
 
 	// Fase 2: deduplica per MAC (bridge > fisico)
 	deduped := make(map[string]net.Interface)
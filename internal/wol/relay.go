@@ -0,0 +1,262 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+	"github.com/gpillon/kubevirt-wol/pkg/wolclient"
+)
+
+const (
+	// relayDedupeWindow drops the repetitions of a packet that wake tools
+	// usually send in a burst, before they cross the WAN
+	relayDedupeWindow = 2 * time.Second
+	// relayMaxInFlight bounds the events being forwarded at the same time
+	relayMaxInFlight = 32
+)
+
+// RelayOptions configures an edge relay
+type RelayOptions struct {
+	// Ports are the UDP ports to listen on for magic packets
+	Ports []int
+	// BindAddress is the local IP to listen on (empty = all interfaces)
+	BindAddress string
+	// Client forwards the events to the relay endpoint of the manager
+	Client *wolclient.Client
+}
+
+// Relay listens for magic packets on a LAN outside the cluster and forwards
+// them to the manager, authenticated by the token of the relay
+type Relay struct {
+	opts RelayOptions
+	log  logr.Logger
+
+	dedupeMu sync.Mutex
+	seen     map[string]relaySeen
+	inFlight chan struct{}
+
+	bindMu     sync.Mutex
+	bindings   map[int]*wolv1.ListenerBinding
+	forwarding sync.WaitGroup
+}
+
+// relaySeen is the last forwarded packet for a MAC and port
+type relaySeen struct {
+	at       time.Time
+	password string
+}
+
+// NewRelay creates an edge relay
+func NewRelay(opts RelayOptions, log logr.Logger) *Relay {
+	return &Relay{
+		opts:     opts,
+		log:      log,
+		seen:     make(map[string]relaySeen),
+		inFlight: make(chan struct{}, relayMaxInFlight),
+		bindings: make(map[int]*wolv1.ListenerBinding),
+	}
+}
+
+// Start listens on the configured ports until ctx is done. Fails only if no
+// port could be bound; the others are reported to the manager as errors.
+func (r *Relay) Start(ctx context.Context) error {
+	var conns []*net.UDPConn
+	for _, port := range r.opts.Ports {
+		addr := &net.UDPAddr{IP: net.ParseIP(r.opts.BindAddress), Port: port}
+		conn, err := net.ListenUDP("udp4", addr)
+		r.setBinding(port, err)
+		if err != nil {
+			r.log.Error(err, "Failed to listen for WOL packets", "port", port)
+			continue
+		}
+		conns = append(conns, conn)
+	}
+	if len(conns) == 0 {
+		return errors.New("no WOL port could be bound")
+	}
+
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *net.UDPConn) {
+			defer wg.Done()
+			r.listen(ctx, conn)
+		}(conn)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.register(ctx)
+	}()
+
+	r.log.Info("Relay started", "ports", r.opts.Ports, "bindAddress", r.opts.BindAddress)
+	<-ctx.Done()
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	wg.Wait()
+	r.forwarding.Wait()
+	return nil
+}
+
+func (r *Relay) setBinding(port int, err error) {
+	binding := &wolv1.ListenerBinding{Protocol: ListenerProtocolUDP, Port: uint32(port), Bound: err == nil}
+	if err != nil {
+		binding.Error = err.Error()
+	}
+	r.bindMu.Lock()
+	r.bindings[port] = binding
+	r.bindMu.Unlock()
+}
+
+// register reports the listeners to the manager, which registers the relay
+// and lists it in the status of its WolConfig, until ctx is done
+func (r *Relay) register(ctx context.Context) {
+	ticker := time.NewTicker(listenerReportInterval)
+	defer ticker.Stop()
+
+	registered := false
+	for {
+		r.bindMu.Lock()
+		bindings := make([]*wolv1.ListenerBinding, 0, len(r.bindings))
+		for _, binding := range r.bindings {
+			bindings = append(bindings, binding)
+		}
+		r.bindMu.Unlock()
+
+		if err := r.opts.Client.Register(ctx, bindings); err != nil {
+			if ctx.Err() == nil {
+				r.log.Error(err, "Failed to register with the manager")
+			}
+			registered = false
+		} else if !registered {
+			r.log.Info("Registered with the manager")
+			registered = true
+		}
+
+		r.dedupeCleanup(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// listen reads magic packets from conn until it is closed
+func (r *Relay) listen(ctx context.Context, conn *net.UDPConn) {
+	port := uint32(conn.LocalAddr().(*net.UDPAddr).Port)
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				r.log.Error(err, "Failed to read WOL packet", "port", port)
+				r.setBinding(int(port), err)
+			}
+			return
+		}
+		receivedAt := time.Now()
+
+		packet := buf[:n]
+		mac, valid := parseMagicPacket(packet)
+		if !valid {
+			r.log.V(1).Info("Invalid WOL packet (not a magic packet)", "from", addr.String(), "size", n)
+			continue
+		}
+		password := parseSecureOnPassword(packet)
+		if !r.shouldForward(dedupeKey(mac, port), password, receivedAt) {
+			r.log.V(1).Info("Skipping duplicate packet", "mac", mac, "port", port)
+			continue
+		}
+
+		event := &wolv1.WOLEvent{
+			MacAddress:       mac,
+			Timestamp:        timestamppb.New(receivedAt),
+			SourceIp:         addr.IP.String(),
+			SourcePort:       uint32(addr.Port),
+			PacketSize:       uint32(n),
+			DestinationPort:  port,
+			SecureOnPassword: password,
+			AgentVersion:     Version,
+		}
+
+		// Un WAN lento non deve bloccare la lettura: oltre il limite gli eventi sono scartati
+		select {
+		case r.inFlight <- struct{}{}:
+		default:
+			r.log.Info("Too many events being forwarded, dropping packet", "mac", mac, "port", port)
+			continue
+		}
+		r.forwarding.Add(1)
+		go func() {
+			defer r.forwarding.Done()
+			defer func() { <-r.inFlight }()
+			r.forward(ctx, event, receivedAt)
+		}()
+	}
+}
+
+// shouldForward returns false for a repetition of a packet forwarded within
+// relayDedupeWindow (a different SecureOn password is a new packet)
+func (r *Relay) shouldForward(key, password string, now time.Time) bool {
+	r.dedupeMu.Lock()
+	defer r.dedupeMu.Unlock()
+	if last, ok := r.seen[key]; ok && now.Sub(last.at) < relayDedupeWindow && last.password == password {
+		return false
+	}
+	r.seen[key] = relaySeen{at: now, password: password}
+	return true
+}
+
+func (r *Relay) dedupeCleanup(now time.Time) {
+	r.dedupeMu.Lock()
+	defer r.dedupeMu.Unlock()
+	for key, last := range r.seen {
+		if now.Sub(last.at) >= relayDedupeWindow {
+			delete(r.seen, key)
+		}
+	}
+}
+
+func (r *Relay) forward(ctx context.Context, event *wolv1.WOLEvent, receivedAt time.Time) {
+	event.AgentDelayUs = uint64(time.Since(receivedAt).Microseconds())
+	resp, err := r.opts.Client.ReportEvent(ctx, event)
+	if err != nil {
+		r.log.Error(err, "Failed to forward WOL event to the manager", "mac", event.MacAddress)
+		return
+	}
+
+	vm := ""
+	if resp.VmInfo != nil {
+		vm = fmt.Sprintf("%s/%s", resp.VmInfo.Namespace, resp.VmInfo.Name)
+	}
+	r.log.Info("WOL event forwarded", "mac", event.MacAddress, "status", resp.Status.String(),
+		"vm", vm, "message", resp.Message)
+}
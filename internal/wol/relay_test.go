@@ -0,0 +1,138 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+	"github.com/gpillon/kubevirt-wol/pkg/wolclient"
+)
+
+// recordingService records the events and listener reports of a relay
+type recordingService struct {
+	wolv1.UnimplementedWOLServiceServer
+	mu      sync.Mutex
+	events  []*wolv1.WOLEvent
+	reports []*wolv1.ListenerReport
+}
+
+func (s *recordingService) ReportWOLEvent(_ context.Context, event *wolv1.WOLEvent) (*wolv1.WOLEventResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return &wolv1.WOLEventResponse{Status: wolv1.ResponseStatus_VM_START_INITIATED}, nil
+}
+
+func (s *recordingService) ReportListeners(_ context.Context, req *wolv1.ListenerReport) (*wolv1.ListenerReportResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reports = append(s.reports, req)
+	return &wolv1.ListenerReportResponse{Accepted: true}, nil
+}
+
+func (s *recordingService) counts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.events), len(s.reports)
+}
+
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestRelay_ForwardsMagicPackets(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &recordingService{}
+	server := grpc.NewServer()
+	wolv1.RegisterWOLServiceServer(server, service)
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	client, err := wolclient.New(lis.Addr().String(), wolclient.Options{Source: "branch-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	port := freeUDPPort(t)
+	relay := NewRelay(RelayOptions{Ports: []int{port}, BindAddress: "127.0.0.1", Client: client}, logr.Discard())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- relay.Start(ctx) }()
+
+	waitFor := func(what string, cond func(events, reports int) bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond(service.counts()) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	waitFor("the registration", func(_, reports int) bool { return reports > 0 })
+
+	mac := []byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	packet := append(bytes.Repeat([]byte{0xff}, 6), bytes.Repeat(mac, 16)...)
+	sender, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = sender.Close() }()
+	for range 3 {
+		if _, err := sender.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor("the forwarded event", func(events, _ int) bool { return events > 0 })
+	time.Sleep(200 * time.Millisecond) // let duplicates, if any, reach the service
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if len(service.events) != 1 {
+		t.Errorf("Expected the burst to be forwarded once, got %d events", len(service.events))
+	}
+	if event := service.events[0]; event.MacAddress != "52:54:00:12:34:56" || event.DestinationPort != uint32(port) || event.NodeName != "branch-1" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+	if bindings := service.reports[0].Bindings; len(bindings) != 1 || !bindings[0].Bound || bindings[0].Port != uint32(port) {
+		t.Errorf("Expected the bound port to be registered, got %v", bindings)
+	}
+}
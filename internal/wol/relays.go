@@ -0,0 +1,172 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// minRelayTokenLength rejects tokens too short to resist guessing
+const minRelayTokenLength = 16
+
+// relayMethods are the RPCs allowed on the relay endpoint: relays report
+// events and register by reporting their listeners, but cannot start VMs by
+// name nor read the VM IPs and networks served to the agents
+var relayMethods = map[string]bool{
+	wolv1.WOLService_ReportWOLEvent_FullMethodName:       true,
+	wolv1.WOLService_ReportWOLEventStream_FullMethodName: true,
+	wolv1.WOLService_ReportListeners_FullMethodName:      true,
+	wolv1.WOLService_HealthCheck_FullMethodName:          true,
+}
+
+// RelayIdentity is an external relay authenticated by its token
+type RelayIdentity struct {
+	Name      string
+	WolConfig string
+}
+
+// relayTokenHash indexes the tokens, so a lookup does not compare secrets byte by byte
+type relayTokenHash [sha256.Size]byte
+
+type relayContextKey struct{}
+
+// relayFromContext returns the relay that sent a request on the relay endpoint
+func relayFromContext(ctx context.Context) (RelayIdentity, bool) {
+	relay, ok := ctx.Value(relayContextKey{}).(RelayIdentity)
+	return relay, ok
+}
+
+// loadRelayTokens reads the tokens of the relays of a config from their Secrets.
+// A relay whose token cannot be read is left out (it cannot authenticate).
+func (m *MACMapper) loadRelayTokens(ctx context.Context, config *wolv1beta1.WolConfig, tokens map[relayTokenHash]RelayIdentity) error {
+	var errs []string
+	for _, relay := range config.Spec.Relays {
+		ref := relay.TokenSecretRef
+		key := ref.Key
+		if key == "" {
+			key = defaultSecureOnSecretKey // same default as the CRD
+		}
+
+		secret := &corev1.Secret{}
+		if err := m.secretReader.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, secret); err != nil {
+			errs = append(errs, fmt.Sprintf("relay %s: failed to get token secret %s/%s: %v", relay.Name, ref.Namespace, ref.Name, err))
+			continue
+		}
+		token := strings.TrimSpace(string(secret.Data[key]))
+		if len(token) < minRelayTokenLength {
+			errs = append(errs, fmt.Sprintf("relay %s: key %q of secret %s/%s must hold a token of at least %d characters",
+				relay.Name, key, ref.Namespace, ref.Name, minRelayTokenLength))
+			continue
+		}
+
+		hash := relayTokenHash(sha256.Sum256([]byte(token)))
+		if other, found := tokens[hash]; found {
+			errs = append(errs, fmt.Sprintf("relay %s: token already used by relay %s of WolConfig %s", relay.Name, other.Name, other.WolConfig))
+			continue
+		}
+		tokens[hash] = RelayIdentity{Name: relay.Name, WolConfig: config.Name}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// AuthenticateRelay returns the relay provisioned with token
+func (m *MACMapper) AuthenticateRelay(token string) (RelayIdentity, bool) {
+	if token == "" {
+		return RelayIdentity{}, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	relay, ok := m.relayTokens[relayTokenHash(sha256.Sum256([]byte(token)))]
+	return relay, ok
+}
+
+// authenticateRelay checks the bearer token of a request on the relay endpoint
+// and returns a context carrying the identity of the relay
+func (a *Aggregator) authenticateRelay(ctx context.Context, method string) (context.Context, error) {
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			token, _ = strings.CutPrefix(values[0], "Bearer ")
+		}
+	}
+	relay, ok := a.mapper.AuthenticateRelay(token)
+	if !ok {
+		RelayRequestsTotal.WithLabelValues("", "unauthenticated").Inc()
+		a.log.Info("Rejected relay request with a missing or unknown token", "peer", addr, "method", method)
+		return nil, status.Error(codes.Unauthenticated, "missing or unknown relay token")
+	}
+	if !relayMethods[method] {
+		RelayRequestsTotal.WithLabelValues(relay.Name, "denied").Inc()
+		a.log.Info("Rejected relay request for a method not allowed to relays",
+			"relay", relay.Name, "wolconfig", relay.WolConfig, "peer", addr, "method", method)
+		return nil, status.Error(codes.PermissionDenied, "method not allowed to relays")
+	}
+
+	RelayRequestsTotal.WithLabelValues(relay.Name, "ok").Inc()
+	a.log.V(1).Info("Relay request", "relay", relay.Name, "wolconfig", relay.WolConfig, "peer", addr, "method", method)
+	return context.WithValue(ctx, relayContextKey{}, relay), nil
+}
+
+// RelayUnaryInterceptor authenticates the unary RPCs of the relay endpoint
+func (a *Aggregator) RelayUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authenticateRelay(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// RelayStreamInterceptor authenticates the streaming RPCs of the relay endpoint
+func (a *Aggregator) RelayStreamInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticateRelay(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &relayServerStream{ServerStream: stream, ctx: ctx})
+}
+
+// relayServerStream carries the relay identity in the context of a stream
+type relayServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *relayServerStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+const testRelayToken = "0123456789abcdef0123"
+
+// noopStarter accepts every start without touching the cluster
+type noopStarter struct{}
+
+func (noopStarter) StartVMAs(_ context.Context, _, _, _ string) error { return nil }
+func (noopStarter) QueuedWakes() int                                  { return 0 }
+
+func newRelayMapper(t *testing.T) *MACMapper {
+	t.Helper()
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "relay-tokens", Namespace: "kubevirt-wol"},
+		Data: map[string][]byte{
+			"branch-1": []byte(testRelayToken + "\n"),
+			"short":    []byte("secret"),
+		},
+	}
+	mapper := NewMACMapper(fake.NewClientBuilder().WithObjects(secret).Build(), logr.Discard())
+
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:12:34:56", VMName: "vm1", Namespace: "default"},
+			},
+			Relays: []wolv1beta1.RelaySpec{
+				{Name: "branch-1", TokenSecretRef: wolv1beta1.SecretKeyReference{
+					Name: "relay-tokens", Namespace: "kubevirt-wol", Key: "branch-1"}},
+				{Name: "weak", TokenSecretRef: wolv1beta1.SecretKeyReference{
+					Name: "relay-tokens", Namespace: "kubevirt-wol", Key: "short"}},
+			},
+		},
+	}
+	config.Name = "branch"
+	mapper.UpdateConfig(config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return mapper
+}
+
+func relayContext(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func TestMACMapper_AuthenticateRelay(t *testing.T) {
+	mapper := newRelayMapper(t)
+
+	relay, ok := mapper.AuthenticateRelay(testRelayToken)
+	if !ok || relay.Name != "branch-1" || relay.WolConfig != "branch" {
+		t.Errorf("Expected the token to authenticate branch-1 of WolConfig branch, got %+v (ok=%v)", relay, ok)
+	}
+	if _, ok := mapper.AuthenticateRelay("secret"); ok {
+		t.Error("Expected a token shorter than the minimum length to be ignored")
+	}
+	if _, ok := mapper.AuthenticateRelay(""); ok {
+		t.Error("Expected an empty token to be rejected")
+	}
+}
+
+func TestAggregator_RelayUnaryInterceptor(t *testing.T) {
+	agg := NewAggregator(newRelayMapper(t), NewVMStarter(nil, logr.Discard()), logr.Discard())
+
+	var seen RelayIdentity
+	handler := func(ctx context.Context, _ any) (any, error) {
+		seen, _ = relayFromContext(ctx)
+		return nil, nil
+	}
+	call := func(ctx context.Context, method string) error {
+		_, err := agg.RelayUnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	if err := call(context.Background(), wolv1.WOLService_ReportWOLEvent_FullMethodName); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated without a token, got %v", err)
+	}
+	if err := call(relayContext("wrong-token-0123456789"), wolv1.WOLService_ReportWOLEvent_FullMethodName); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated with an unknown token, got %v", err)
+	}
+	if err := call(relayContext(testRelayToken), wolv1.WOLService_RequestWake_FullMethodName); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied for RequestWake, got %v", err)
+	}
+	if err := call(relayContext(testRelayToken), wolv1.WOLService_ReportWOLEvent_FullMethodName); err != nil {
+		t.Fatalf("Expected the relay to be authenticated, got %v", err)
+	}
+	if seen.Name != "branch-1" {
+		t.Errorf("Expected the handler to see relay branch-1, got %+v", seen)
+	}
+}
+
+func TestAggregator_RelayEvents(t *testing.T) {
+	agg := NewAggregator(newRelayMapper(t), noopStarter{}, logr.Discard())
+	ctx := context.WithValue(context.Background(), relayContextKey{}, RelayIdentity{Name: "branch-1", WolConfig: "branch"})
+
+	event := &wolv1.WOLEvent{MacAddress: "52:54:00:12:34:56", NodeName: "spoofed-node"}
+	if _, err := agg.ReportWOLEvent(ctx, event); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if event.NodeName != "branch-1" {
+		t.Errorf("Expected the event to be attributed to the relay, got %q", event.NodeName)
+	}
+
+	other := context.WithValue(context.Background(), relayContextKey{}, RelayIdentity{Name: "branch-2", WolConfig: "other"})
+	resp, err := agg.ReportWOLEvent(other, &wolv1.WOLEvent{MacAddress: "52:54:00:12:34:56", DestinationPort: 7})
+	if err != nil || resp.Status != wolv1.ResponseStatus_VM_NOT_FOUND {
+		t.Errorf("Expected a relay of another WolConfig not to find the VM, got %v, %v", resp, err)
+	}
+
+	if _, err := agg.ReportListeners(ctx, &wolv1.ListenerReport{
+		NodeName: "spoofed-node",
+		Bindings: []*wolv1.ListenerBinding{{Protocol: ListenerProtocolUDP, Port: 9, Bound: true}},
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	listeners := agg.Listeners("branch")
+	if len(listeners) != 1 || !listeners[0].Relay || listeners[0].NodeName != "branch-1" || listeners[0].WolConfig != "branch" {
+		t.Errorf("Expected the relay to be listed under its WolConfig, got %+v", listeners)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// DefaultReportWorkers is the default number of goroutines reporting the
+	// packets to the operator
+	DefaultReportWorkers = 16
+	// DefaultReportQueueSize is the default number of packets waiting for a
+	// report worker; beyond it the packets are dropped
+	DefaultReportQueueSize = 1024
+)
+
+// reportQueue runs the reports to the operator on a fixed pool of workers. A
+// packet storm (e.g. a broadcast loop) fills the queue and is dropped, instead
+// of starting a goroutine per packet.
+type reportQueue struct {
+	workers int
+	tasks   chan func(ctx context.Context)
+	once    sync.Once
+}
+
+func newReportQueue(workers, size int) *reportQueue {
+	if workers <= 0 {
+		workers = DefaultReportWorkers
+	}
+	if size < 0 {
+		size = DefaultReportQueueSize
+	}
+	return &reportQueue{workers: workers, tasks: make(chan func(ctx context.Context), size)}
+}
+
+// SetReportQueue sets the number of report workers and of the packets waiting
+// for them (0 = none, a packet is dropped unless a worker is idle). Must be
+// called before Start.
+func (a *Agent) SetReportQueue(workers, size int) {
+	a.reports = newReportQueue(workers, size)
+}
+
+// enqueueReport queues fn for the report workers, starting them on the first
+// report. Returns false if the queue is full. Called with inflightMu held,
+// before drain closes the queue.
+func (a *Agent) enqueueReport(fn func(ctx context.Context)) bool {
+	a.reports.once.Do(func() {
+		for range a.reports.workers {
+			go a.reportWorker()
+		}
+	})
+	select {
+	case a.reports.tasks <- fn:
+		return true
+	default:
+		return false
+	}
+}
+
+// reportWorker runs the queued reports until drain closes the queue
+func (a *Agent) reportWorker() {
+	for fn := range a.reports.tasks {
+		fn(a.reportCtx)
+		a.inflightCount.Add(-1)
+		a.inflight.Done()
+	}
+}
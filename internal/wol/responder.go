@@ -0,0 +1,208 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"encoding/binary"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Protocols answered by the neighbor responder
+const (
+	NeighborProtocolARP = "arp"
+	NeighborProtocolNDP = "ndp"
+)
+
+const (
+	// etherTypeIPv6 is the EtherType of IPv6 frames
+	etherTypeIPv6 = 0x86DD
+
+	icmpv6NeighborSolicitation  = 135
+	icmpv6NeighborAdvertisement = 136
+
+	// ndpOptionSourceLinkAddress and ndpOptionTargetLinkAddress are the NDP
+	// options carrying a MAC
+	ndpOptionSourceLinkAddress = 1
+	ndpOptionTargetLinkAddress = 2
+
+	// naFlagSolicited marks a neighbor advertisement as the answer to a
+	// solicitation. The override flag is not set, as proxies do: the answer
+	// does not replace a cache entry learned from the VM itself.
+	naFlagSolicited = 0x40
+)
+
+// NeighborSolicitation is an IPv6 neighbor solicitation seen on the wire
+type NeighborSolicitation struct {
+	SenderMAC net.HardwareAddr
+	SenderIP  net.IP
+	TargetIP  net.IP
+}
+
+// ---------------------------------------------------------------------------
+// Raw listener side: answering for the stopped VMs
+// ---------------------------------------------------------------------------
+
+// SetNeighborResponder makes the listener answer the ARP requests and IPv6
+// neighbor solicitations for the IPs lookup resolves to a MAC. replied, if
+// not nil, is called after every answer with the protocol and the send error.
+// Must be called before Start, since it changes the BPF filter.
+func (r *RawListener) SetNeighborResponder(lookup func(ip net.IP) net.HardwareAddr, replied func(protocol string, err error)) {
+	r.neighborLookup = lookup
+	r.neighborReplied = replied
+}
+
+// answerARP answers an ARP request for a resolved IP. The Ethernet source is
+// the MAC of the interface: only the ARP payload carries the MAC of the VM,
+// so the switches do not learn it on the port of the node.
+func (r *RawListener) answerARP(req ARPRequest, vlanTag []byte) {
+	mac := r.neighborLookup(req.TargetIP)
+	if len(mac) != 6 || len(r.hwAddr) != 6 {
+		return
+	}
+	r.packetLog.Info(r.log.V(1), "Answering ARP request for stopped VM", "ip", req.TargetIP.String(), "mac", mac.String(),
+		"from", r.packetLog.Source(req.SenderIP.String()))
+	r.sendNeighborReply(NeighborProtocolARP, buildARPReply(r.hwAddr, mac, req, vlanTag), req.SenderMAC)
+}
+
+// answerNDP answers an IPv6 neighbor solicitation for a resolved IP
+func (r *RawListener) answerNDP(ns NeighborSolicitation, vlanTag []byte) {
+	mac := r.neighborLookup(ns.TargetIP)
+	if len(mac) != 6 || len(r.hwAddr) != 6 {
+		return
+	}
+	r.packetLog.Info(r.log.V(1), "Answering neighbor solicitation for stopped VM", "ip", ns.TargetIP.String(), "mac", mac.String(),
+		"from", r.packetLog.Source(ns.SenderIP.String()))
+	r.sendNeighborReply(NeighborProtocolNDP, buildNeighborAdvertisement(r.hwAddr, mac, ns, vlanTag), ns.SenderMAC)
+}
+
+func (r *RawListener) sendNeighborReply(protocol string, frame []byte, dst net.HardwareAddr) {
+	addr := &unix.SockaddrLinklayer{Ifindex: r.ifIndex, Halen: 6}
+	copy(addr.Addr[:], dst)
+	err := unix.Sendto(r.fd, frame, 0, addr)
+	if err != nil {
+		r.log.Error(err, "Failed to send neighbor reply", "protocol", protocol, "interface", r.interfaceName)
+	}
+	if r.neighborReplied != nil {
+		r.neighborReplied(protocol, err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Frames
+// ---------------------------------------------------------------------------
+
+// ethernetHeader returns the header of a frame from src to dst, with the
+// 802.1Q tag of the request if it had one
+func ethernetHeader(dst, src net.HardwareAddr, vlanTag []byte, etherType uint16) []byte {
+	header := make([]byte, 0, 18)
+	header = append(header, dst[:6]...)
+	header = append(header, src[:6]...)
+	header = append(header, vlanTag...)
+	return binary.BigEndian.AppendUint16(header, etherType)
+}
+
+// buildARPReply builds the ARP reply telling the sender of req that its
+// target IP is at mac
+func buildARPReply(hwAddr, mac net.HardwareAddr, req ARPRequest, vlanTag []byte) []byte {
+	frame := ethernetHeader(req.SenderMAC, hwAddr, vlanTag, etherTypeARP)
+	frame = binary.BigEndian.AppendUint16(frame, 1)      // Ethernet
+	frame = binary.BigEndian.AppendUint16(frame, 0x0800) // IPv4
+	frame = append(frame, 6, 4)
+	frame = binary.BigEndian.AppendUint16(frame, 2) // reply
+	frame = append(frame, mac[:6]...)
+	frame = append(frame, req.TargetIP.To4()...)
+	frame = append(frame, req.SenderMAC[:6]...)
+	return append(frame, req.SenderIP.To4()...)
+}
+
+// parseNeighborSolicitation parses the payload of an IPv6 frame carrying a
+// neighbor solicitation. Returns false for the other packets and for the
+// duplicate address detection of a booting host (source ::), which must not
+// be answered.
+func parseNeighborSolicitation(payload []byte, srcMAC net.HardwareAddr) (NeighborSolicitation, bool) {
+	// Header IPv6 (40) + ICMPv6 (4) + riservato (4) + target (16)
+	if len(payload) < 64 || payload[0]>>4 != 6 || payload[6] != unix.IPPROTO_ICMPV6 || payload[7] != 255 {
+		return NeighborSolicitation{}, false
+	}
+	icmp := payload[40:]
+	if icmp[0] != icmpv6NeighborSolicitation || icmp[1] != 0 {
+		return NeighborSolicitation{}, false
+	}
+	ns := NeighborSolicitation{
+		SenderMAC: net.HardwareAddr(append([]byte{}, srcMAC...)),
+		SenderIP:  net.IP(append([]byte{}, payload[8:24]...)),
+		TargetIP:  net.IP(append([]byte{}, icmp[8:24]...)),
+	}
+	if ns.SenderIP.IsUnspecified() || ns.TargetIP.IsMulticast() {
+		return NeighborSolicitation{}, false
+	}
+	// L'opzione source link-layer address vince sul mittente del frame
+	for options := icmp[24:]; len(options) >= 8 && options[1] > 0; {
+		length := int(options[1]) * 8
+		if length > len(options) {
+			break
+		}
+		if options[0] == ndpOptionSourceLinkAddress {
+			ns.SenderMAC = net.HardwareAddr(append([]byte{}, options[2:8]...))
+		}
+		options = options[length:]
+	}
+	return ns, true
+}
+
+// buildNeighborAdvertisement builds the neighbor advertisement telling the
+// sender of ns that its target IP is at mac
+func buildNeighborAdvertisement(hwAddr, mac net.HardwareAddr, ns NeighborSolicitation, vlanTag []byte) []byte {
+	icmp := []byte{icmpv6NeighborAdvertisement, 0, 0, 0, naFlagSolicited, 0, 0, 0}
+	icmp = append(icmp, ns.TargetIP.To16()...)
+	icmp = append(icmp, ndpOptionTargetLinkAddress, 1)
+	icmp = append(icmp, mac[:6]...)
+	binary.BigEndian.PutUint16(icmp[2:4], icmpv6Checksum(ns.TargetIP, ns.SenderIP, icmp))
+
+	frame := ethernetHeader(ns.SenderMAC, hwAddr, vlanTag, etherTypeIPv6)
+	frame = append(frame, 0x60, 0, 0, 0) // versione 6, traffic class e flow label a 0
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(icmp)))
+	frame = append(frame, unix.IPPROTO_ICMPV6, 255)
+	frame = append(frame, ns.TargetIP.To16()...)
+	frame = append(frame, ns.SenderIP.To16()...)
+	return append(frame, icmp...)
+}
+
+// icmpv6Checksum computes the checksum of an ICMPv6 message, including the
+// IPv6 pseudo-header
+func icmpv6Checksum(src, dst net.IP, message []byte) uint16 {
+	pseudo := make([]byte, 0, 40+len(message))
+	pseudo = append(pseudo, src.To16()...)
+	pseudo = append(pseudo, dst.To16()...)
+	pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(message)))
+	pseudo = append(pseudo, 0, 0, 0, unix.IPPROTO_ICMPV6)
+	pseudo = append(pseudo, message...)
+
+	var sum uint32
+	for i := 0; i+1 < len(pseudo); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(pseudo[i:]))
+	}
+	if len(pseudo)%2 == 1 {
+		sum += uint32(pseudo[len(pseudo)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
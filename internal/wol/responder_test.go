@@ -0,0 +1,188 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/net/bpf"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+var (
+	responderNodeMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x10}
+	responderVMMAC   = net.HardwareAddr{0x52, 0x54, 0, 0, 0, 0x01}
+	responderClient  = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x20}
+)
+
+// neighborSolicitationFrame builds an Ethernet/IPv6 neighbor solicitation from
+// src (with the source link-layer address option) for target
+func neighborSolicitationFrame(src, target string, hopLimit byte) []byte {
+	icmp := []byte{icmpv6NeighborSolicitation, 0, 0, 0, 0, 0, 0, 0}
+	icmp = append(icmp, net.ParseIP(target).To16()...)
+	icmp = append(icmp, ndpOptionSourceLinkAddress, 1)
+	icmp = append(icmp, responderClient...)
+
+	frame := append(net.HardwareAddr{0x33, 0x33, 0xff, 0, 0, 0x20}, responderClient...)
+	frame = binary.BigEndian.AppendUint16(frame, etherTypeIPv6)
+	frame = append(frame, 0x60, 0, 0, 0)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(icmp)))
+	frame = append(frame, 58, hopLimit)
+	frame = append(frame, net.ParseIP(src).To16()...)
+	frame = append(frame, net.ParseIP("ff02::1:ff00:20").To16()...)
+	return append(frame, icmp...)
+}
+
+func TestBuildARPReply(t *testing.T) {
+	req, ok := parseARPRequest(buildARPPayload(1, "10.0.0.5", "10.0.0.20"))
+	if !ok {
+		t.Fatal("Expected a valid ARP request")
+	}
+	req.SenderMAC = responderClient
+
+	frame := buildARPReply(responderNodeMAC, responderVMMAC, req, nil)
+	if !bytes.Equal(frame[0:6], responderClient) || !bytes.Equal(frame[6:12], responderNodeMAC) ||
+		binary.BigEndian.Uint16(frame[12:14]) != etherTypeARP {
+		t.Fatalf("Unexpected Ethernet header % x", frame[:14])
+	}
+	arp := frame[14:]
+	if binary.BigEndian.Uint16(arp[6:8]) != 2 || !bytes.Equal(arp[8:14], responderVMMAC) ||
+		!net.IP(arp[14:18]).Equal(net.ParseIP("10.0.0.20")) || !net.IP(arp[24:28]).Equal(net.ParseIP("10.0.0.5")) {
+		t.Errorf("Unexpected ARP reply % x", arp)
+	}
+
+	// Richiesta con tag VLAN: la risposta lo ripete
+	tagged := buildARPReply(responderNodeMAC, responderVMMAC, req, []byte{0x81, 0x00, 0x00, 0x64})
+	if len(tagged) != len(frame)+4 || binary.BigEndian.Uint16(tagged[16:18]) != etherTypeARP {
+		t.Errorf("Expected the VLAN tag in the reply, got % x", tagged[:18])
+	}
+}
+
+func TestNeighborSolicitation(t *testing.T) {
+	frame := neighborSolicitationFrame("fd00::5", "fd00::20", 255)
+	ns, ok := parseNeighborSolicitation(frame[14:], net.HardwareAddr(frame[6:12]))
+	if !ok {
+		t.Fatal("Expected a valid neighbor solicitation")
+	}
+	if !ns.TargetIP.Equal(net.ParseIP("fd00::20")) || !ns.SenderIP.Equal(net.ParseIP("fd00::5")) ||
+		!bytes.Equal(ns.SenderMAC, responderClient) {
+		t.Errorf("Unexpected solicitation %+v", ns)
+	}
+
+	// Duplicate address detection e hop limit diverso da 255 non hanno risposta
+	if _, ok := parseNeighborSolicitation(neighborSolicitationFrame("::", "fd00::20", 255)[14:], responderClient); ok {
+		t.Error("Expected the duplicate address detection to be ignored")
+	}
+	if _, ok := parseNeighborSolicitation(neighborSolicitationFrame("fd00::5", "fd00::20", 64)[14:], responderClient); ok {
+		t.Error("Expected a routed solicitation to be ignored")
+	}
+
+	na := buildNeighborAdvertisement(responderNodeMAC, responderVMMAC, ns, nil)
+	if !bytes.Equal(na[0:6], responderClient) || binary.BigEndian.Uint16(na[12:14]) != etherTypeIPv6 {
+		t.Fatalf("Unexpected Ethernet header % x", na[:14])
+	}
+	ip := na[14:]
+	if !net.IP(ip[8:24]).Equal(ns.TargetIP) || !net.IP(ip[24:40]).Equal(ns.SenderIP) || ip[7] != 255 {
+		t.Errorf("Unexpected IPv6 header % x", ip[:40])
+	}
+	icmp := ip[40:]
+	if icmp[0] != icmpv6NeighborAdvertisement || icmp[4] != naFlagSolicited ||
+		!net.IP(icmp[8:24]).Equal(ns.TargetIP) || !bytes.Equal(icmp[26:32], responderVMMAC) {
+		t.Errorf("Unexpected neighbor advertisement % x", icmp)
+	}
+	if sum := icmpv6Checksum(ns.TargetIP, ns.SenderIP, icmp); sum != 0 {
+		t.Errorf("Invalid ICMPv6 checksum, residual %#04x", sum)
+	}
+}
+
+func TestCaptureFilter_NeighborSolicitations(t *testing.T) {
+	run := func(t *testing.T, filter []bpf.RawInstruction, frame []byte) bool {
+		t.Helper()
+		vm, err := bpf.NewVM(mustDisassemble(t, filter))
+		if err != nil {
+			t.Fatalf("Invalid filter: %v", err)
+		}
+		n, err := vm.Run(frame)
+		if err != nil {
+			t.Fatalf("Filter failed: %v", err)
+		}
+		return n > 0
+	}
+
+	mac := []byte{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	advertisement := neighborSolicitationFrame("fd00::5", "fd00::20", 255)
+	advertisement[14+40] = icmpv6NeighborAdvertisement
+	udp6 := neighborSolicitationFrame("fd00::5", "fd00::20", 255)
+	udp6[14+6] = 17
+	arp := append(append(bytes.Repeat([]byte{0xff}, 6), responderClient...), 0x08, 0x06)
+
+	tests := []struct {
+		name               string
+		frame              []byte
+		ndp, ndpUDP, plain bool
+	}{
+		{"neighbor solicitation", neighborSolicitationFrame("fd00::5", "fd00::20", 255), true, true, false},
+		{"neighbor advertisement", advertisement, false, false, false},
+		{"IPv6 UDP", udp6, false, false, false},
+		{"ARP", append(arp, buildARPPayload(1, "10.0.0.5", "10.0.0.20")...), true, true, true},
+		{"UDP to port 9", udpFrame(mac, 9, buildMagicPacket(mac, nil)), false, true, false},
+	}
+	ndp := toRaw(captureFilter([]uint16{etherTypeWoL, etherTypeARP}, nil, true))
+	ndpUDP := toRaw(captureFilter([]uint16{etherTypeWoL, etherTypeARP}, []uint16{9}, true))
+	plain := toRaw(captureFilter([]uint16{etherTypeWoL, etherTypeARP}, nil, false))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := run(t, ndp, tt.frame); got != tt.ndp {
+				t.Errorf("Filter with neighbor solicitations: expected %v, got %v", tt.ndp, got)
+			}
+			if got := run(t, ndpUDP, tt.frame); got != tt.ndpUDP {
+				t.Errorf("Filter with neighbor solicitations and UDP ports: expected %v, got %v", tt.ndpUDP, got)
+			}
+			if got := run(t, plain, tt.frame); got != tt.plain {
+				t.Errorf("Filter without neighbor solicitations: expected %v, got %v", tt.plain, got)
+			}
+		})
+	}
+}
+
+func TestAgent_NeighborMAC(t *testing.T) {
+	agent := NewAgent(9, "node-a", "operator:9090", logr.Discard())
+	agent.arpTargets = map[string]*wolv1.ARPTarget{
+		"10.0.0.20": {Ip: "10.0.0.20", Name: "vm1", MacAddress: "52:54:00:00:00:01", Respond: true},
+		"fd00::20":  {Ip: "fd00::20", Name: "vm1", MacAddress: "52:54:00:00:00:01", Respond: true},
+		"10.0.0.30": {Ip: "10.0.0.30", Name: "vm2"},
+	}
+
+	if mac := agent.neighborMAC(net.ParseIP("10.0.0.20")); !bytes.Equal(mac, responderVMMAC) {
+		t.Errorf("Expected the VM MAC, got %v", mac)
+	}
+	if mac := agent.neighborMAC(net.ParseIP("fd00:0::20")); !bytes.Equal(mac, responderVMMAC) {
+		t.Errorf("Expected the VM MAC for the IPv6 address, got %v", mac)
+	}
+	// Target del solo wake su ARP, o IP sconosciuto: nessuna risposta
+	if mac := agent.neighborMAC(net.ParseIP("10.0.0.30")); mac != nil {
+		t.Errorf("Expected no answer for a wake-only target, got %v", mac)
+	}
+	if mac := agent.neighborMAC(net.ParseIP("10.0.0.40")); mac != nil {
+		t.Errorf("Expected no answer for an unknown IP, got %v", mac)
+	}
+}
@@ -0,0 +1,286 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/workqueue"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+const (
+	// DefaultWakeRetryAttempts is the default number of retries of a failed VM start
+	DefaultWakeRetryAttempts = 5
+	// DefaultWakeRetryBaseDelay is the default delay before the first retry,
+	// doubled at each following one
+	DefaultWakeRetryBaseDelay = time.Second
+	// DefaultWakeRetryMaxDelay caps the delay between two retries
+	DefaultWakeRetryMaxDelay = 2 * time.Minute
+
+	// retryDrainTimeout bounds the last attempt of the queued wakes on shutdown
+	retryDrainTimeout = 10 * time.Second
+)
+
+// Results counted by wol_wake_retries_total
+const (
+	retryResultStarted   = "started"
+	retryResultFailed    = "failed"
+	retryResultExhausted = "exhausted"
+	retryResultDropped   = "dropped"
+)
+
+// RetryOptions configures the retries of the VM starts failed with a
+// transient error (API throttling, webhook or apiserver unavailable)
+type RetryOptions struct {
+	// MaxAttempts is the number of retries of a failed start (0 disables them)
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled at each retry up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Validate checks the retry options
+func (o RetryOptions) Validate() error {
+	if o.MaxAttempts < 0 {
+		return fmt.Errorf("the wake retry attempts must not be negative, got %d", o.MaxAttempts)
+	}
+	if o.MaxAttempts > 0 && (o.BaseDelay <= 0 || o.MaxDelay < o.BaseDelay) {
+		return fmt.Errorf("the wake retry delays must be positive, with the max delay (%s) not below the base delay (%s)",
+			o.MaxDelay, o.BaseDelay)
+	}
+	return nil
+}
+
+// wakeRetries is the queue of the VM starts to retry, by <namespace>/<vm>
+type wakeRetries struct {
+	opts  RetryOptions
+	queue workqueue.TypedRateLimitingInterface[string]
+
+	mu      sync.Mutex
+	pending map[string]wakeRetry
+}
+
+// wakeRetry is a VM start to retry
+type wakeRetry struct {
+	vmInfo VMInfo
+	action wolv1beta1.WolPolicyAction
+	origin *wakeOrigin // origine del wake, registrata sulla VM (vedi wake_metadata.go)
+}
+
+// SetRetry enables the retries of the failed VM starts. Must be called before
+// RunRetries, which processes them.
+func (a *Aggregator) SetRetry(opts RetryOptions) {
+	if opts.MaxAttempts == 0 {
+		a.retries = nil
+		return
+	}
+	a.retries = &wakeRetries{
+		opts: opts,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.NewTypedItemExponentialFailureRateLimiter[string](opts.BaseDelay, opts.MaxDelay),
+			workqueue.TypedRateLimitingQueueConfig[string]{}),
+		pending: make(map[string]wakeRetry),
+	}
+}
+
+// retryableStartError returns true if a VM start failed for a reason that may
+// go away by itself: throttling (of the API or of the start limiter),
+// timeouts, server errors (e.g. a webhook down), network errors or injected
+// chaos. RBAC and validation errors are final.
+func retryableStartError(err error) bool {
+	if errors.Is(err, errChaosStartFailure) || errors.Is(err, errStartsThrottled) {
+		return true
+	}
+	if apierrors.IsTooManyRequests(err) || apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) ||
+		apierrors.IsInternalError(err) || apierrors.IsServiceUnavailable(err) || apierrors.IsUnexpectedServerError(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// queueRetry queues a failed VM start for a retry and returns true, or returns
+// false if retries are disabled or the error is final. A VM has at most one
+// queued start: a newer wake replaces its action.
+func (a *Aggregator) queueRetry(ctx context.Context, vmInfo VMInfo, action wolv1beta1.WolPolicyAction, err error) bool {
+	r := a.retries
+	if r == nil || !retryableStartError(err) {
+		return false
+	}
+	key := vmIndexKey(vmInfo.Namespace, vmInfo.Name)
+
+	r.mu.Lock()
+	_, queued := r.pending[key]
+	retry := wakeRetry{vmInfo: vmInfo, action: action}
+	if origin, ok := wakeOriginFromContext(ctx); ok {
+		retry.origin = &origin
+	}
+	r.pending[key] = retry
+	WakeRetryQueueSize.Set(float64(len(r.pending)))
+	r.mu.Unlock()
+
+	if !queued {
+		r.queue.AddRateLimited(key)
+	}
+	a.log.Info("VM start failed, retry queued", "vm", vmInfo.Name, "namespace", vmInfo.Namespace,
+		"wolconfig", vmInfo.ConfigName, "error", err.Error())
+	return true
+}
+
+// startFailure returns the response status and message of a failed VM start,
+// queueing a retry (ACCEPTED) if the error is transient
+func (a *Aggregator) startFailure(ctx context.Context, vmInfo VMInfo, action wolv1beta1.WolPolicyAction, err error) (wolv1.ResponseStatus, string) {
+	// Uno start oltre i limiti globali è rifiutato, non ritentato: in un flood
+	// la coda dei retry si riempirebbe di wake non voluti
+	if errors.Is(err, errStartsThrottled) {
+		return wolv1.ResponseStatus_RATE_LIMITED, fmt.Sprintf("VM start throttled: %v", err)
+	}
+	if a.queueRetry(ctx, vmInfo, action, err) {
+		return wolv1.ResponseStatus_ACCEPTED, fmt.Sprintf("Failed to start VM: %v; retry queued", err)
+	}
+	return wolv1.ResponseStatus_ERROR, fmt.Sprintf("Failed to start VM: %v", err)
+}
+
+// context returns ctx with the origin of the wake of a queued start
+func (r wakeRetry) context(ctx context.Context) context.Context {
+	if r.origin == nil {
+		return ctx
+	}
+	return withWakeOrigin(ctx, *r.origin)
+}
+
+// remove forgets a queued start
+func (r *wakeRetries) remove(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, key)
+	WakeRetryQueueSize.Set(float64(len(r.pending)))
+}
+
+// RunRetries retries the queued VM starts until ctx is done. It then drains
+// the queue: every start still queued is attempted once more before returning.
+func (a *Aggregator) RunRetries(ctx context.Context) {
+	r := a.retries
+	if r == nil {
+		<-ctx.Done()
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for a.processRetry(ctx) {
+		}
+	}()
+
+	<-ctx.Done()
+	r.queue.ShutDown()
+	<-done
+	a.drainRetries()
+}
+
+// processRetry retries the next queued start and returns false once the queue is shut down
+func (a *Aggregator) processRetry(ctx context.Context) bool {
+	r := a.retries
+	key, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.queue.Done(key)
+
+	r.mu.Lock()
+	retry, ok := r.pending[key]
+	r.mu.Unlock()
+	if !ok {
+		r.queue.Forget(key)
+		return true
+	}
+
+	attempt := r.queue.NumRequeues(key)
+	err := a.startVM(retry.context(ctx), retry.vmInfo, retry.action)
+	switch {
+	case err == nil:
+		r.queue.Forget(key)
+		r.remove(key)
+		VMStartedTotal.Inc()
+		WakeRetriesTotal.WithLabelValues(retryResultStarted).Inc()
+		a.log.Info("Started VM on retry", "vm", retry.vmInfo.Name, "namespace", retry.vmInfo.Namespace,
+			"attempt", attempt)
+	case ctx.Err() != nil:
+		// Spegnimento: il drain fa l'ultimo tentativo
+	case retryableStartError(err) && attempt < r.opts.MaxAttempts:
+		WakeRetriesTotal.WithLabelValues(retryResultFailed).Inc()
+		a.log.Info("VM start retry failed", "vm", retry.vmInfo.Name, "namespace", retry.vmInfo.Namespace,
+			"attempt", attempt, "error", err.Error())
+		r.queue.AddRateLimited(key)
+	default:
+		r.queue.Forget(key)
+		r.remove(key)
+		ErrorsTotal.Inc()
+		WakeRetriesTotal.WithLabelValues(retryResultExhausted).Inc()
+		a.log.Error(err, "Giving up starting VM", "vm", retry.vmInfo.Name, "namespace", retry.vmInfo.Namespace,
+			"wolconfig", retry.vmInfo.ConfigName, "attempts", attempt)
+	}
+	return true
+}
+
+// drainRetries attempts once the starts still queued on shutdown
+func (a *Aggregator) drainRetries() {
+	r := a.retries
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = make(map[string]wakeRetry)
+	WakeRetryQueueSize.Set(0)
+	r.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), retryDrainTimeout)
+	defer cancel()
+	a.log.Info("Draining the queued VM starts", "count", len(pending))
+	for _, retry := range pending {
+		if err := a.startVM(retry.context(ctx), retry.vmInfo, retry.action); err != nil {
+			WakeRetriesTotal.WithLabelValues(retryResultDropped).Inc()
+			a.log.Error(err, "Dropping queued VM start on shutdown", "vm", retry.vmInfo.Name,
+				"namespace", retry.vmInfo.Namespace)
+			continue
+		}
+		VMStartedTotal.Inc()
+		WakeRetriesTotal.WithLabelValues(retryResultStarted).Inc()
+	}
+}
+
+// pendingRetries returns the number of queued starts
+func (a *Aggregator) pendingRetries() int {
+	r := a.retries
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.pending)
+}
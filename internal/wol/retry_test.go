@@ -0,0 +1,194 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// flakyStarter fails the first starts with err
+type flakyStarter struct {
+	mu       sync.Mutex
+	failures int
+	err      error
+	attempts int
+	started  int
+}
+
+func (s *flakyStarter) StartVMAs(context.Context, string, string, string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if s.failures != 0 {
+		s.failures--
+		return s.err
+	}
+	s.started++
+	return nil
+}
+
+func (s *flakyStarter) QueuedWakes() int { return 0 }
+
+func (s *flakyStarter) counts() (attempts, started int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts, s.started
+}
+
+// newRetryAggregator returns an aggregator waking vm1 through an explicit mapping
+func newRetryAggregator(t *testing.T, starter Starter, opts RetryOptions) *Aggregator {
+	t.Helper()
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+	config := conflictTestConfig("explicit", 0, 0, "")
+	config.Spec.DiscoveryMode = wolv1beta1.DiscoveryModeExplicit
+	config.Spec.ExplicitMappings = []wolv1beta1.MACVMMapping{
+		{MACAddress: "52:54:00:00:00:01", VMName: "vm1", Namespace: "tenant"},
+	}
+	mapper.UpdateConfig(&config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	agg := NewAggregator(mapper, starter, logr.Discard())
+	agg.SetRetry(opts)
+	return agg
+}
+
+func TestRetryableStartError(t *testing.T) {
+	vm := schema.GroupResource{Group: "kubevirt.io", Resource: "virtualmachines"}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{apierrors.NewTooManyRequests("throttled", 1), true},
+		{apierrors.NewInternalError(errors.New("failed calling webhook")), true},
+		{apierrors.NewServiceUnavailable("down"), true},
+		{fmt.Errorf("failed to start VM: %w", errChaosStartFailure), true},
+		{apierrors.NewForbidden(vm, "vm1", errors.New("denied")), false},
+		{apierrors.NewNotFound(vm, "vm1"), false},
+		{errors.New("refusing to start VM"), false},
+	}
+	for _, tt := range tests {
+		if got := retryableStartError(tt.err); got != tt.want {
+			t.Errorf("retryableStartError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+	if err := (RetryOptions{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Second}).Validate(); err == nil {
+		t.Error("Expected a max delay below the base delay to be rejected")
+	}
+}
+
+func TestAggregator_WakeRetry(t *testing.T) {
+	starter := &flakyStarter{failures: 2, err: apierrors.NewServiceUnavailable("webhook down")}
+	agg := newRetryAggregator(t, starter, RetryOptions{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		agg.RunRetries(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	resp, _ := agg.ReportWOLEvent(ctx, &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01"})
+	if resp.Status != wolv1.ResponseStatus_ACCEPTED {
+		t.Fatalf("Expected the failed start to be queued (ACCEPTED), got %v: %s", resp.Status, resp.Message)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, started := starter.counts(); started == 1 && agg.pendingRetries() == 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if attempts, started := starter.counts(); attempts != 3 || started != 1 {
+		t.Errorf("Expected the VM started at the third attempt, got %d attempts, %d starts", attempts, started)
+	}
+	if got := testutil.ToFloat64(WakeRetryQueueSize); got != 0 {
+		t.Errorf("Expected an empty retry queue, got %v", got)
+	}
+}
+
+func TestAggregator_WakeRetryExhausted(t *testing.T) {
+	starter := &flakyStarter{failures: -1, err: apierrors.NewTooManyRequests("throttled", 1)}
+	agg := newRetryAggregator(t, starter, RetryOptions{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		agg.RunRetries(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	exhausted := testutil.ToFloat64(WakeRetriesTotal.WithLabelValues(retryResultExhausted))
+	agg.ReportWOLEvent(ctx, &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01"})
+	deadline := time.Now().Add(5 * time.Second)
+	for agg.pendingRetries() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if attempts, _ := starter.counts(); attempts != 3 {
+		t.Errorf("Expected the start and 2 retries, got %d attempts", attempts)
+	}
+	if got := testutil.ToFloat64(WakeRetriesTotal.WithLabelValues(retryResultExhausted)); got != exhausted+1 {
+		t.Errorf("Expected the retries to be exhausted, got %v", got-exhausted)
+	}
+}
+
+func TestAggregator_WakeRetryFinalErrorAndDrain(t *testing.T) {
+	vm := schema.GroupResource{Group: "kubevirt.io", Resource: "virtualmachines"}
+	starter := &flakyStarter{failures: 1, err: apierrors.NewForbidden(vm, "vm1", errors.New("denied"))}
+	agg := newRetryAggregator(t, starter, RetryOptions{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour})
+
+	// Un errore RBAC non si risolve da solo: nessun retry
+	resp, _ := agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01"})
+	if resp.Status != wolv1.ResponseStatus_ERROR || agg.pendingRetries() != 0 {
+		t.Fatalf("Expected a final ERROR without retry, got %v (%d queued)", resp.Status, agg.pendingRetries())
+	}
+
+	// Allo spegnimento i retry in attesa fanno un ultimo tentativo
+	starter.mu.Lock()
+	starter.failures, starter.err = 1, apierrors.NewServiceUnavailable("down")
+	starter.mu.Unlock()
+	resp, _ = agg.RequestWake(context.Background(), &wolv1.WakeRequest{Namespace: "tenant", Name: "vm1", Source: APIWakeSource})
+	if resp.Status != wolv1.ResponseStatus_ACCEPTED || agg.pendingRetries() != 1 {
+		t.Fatalf("Expected the wake queued, got %v (%d queued)", resp.Status, agg.pendingRetries())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	agg.RunRetries(ctx)
+	if _, started := starter.counts(); started != 1 || agg.pendingRetries() != 0 {
+		t.Errorf("Expected the queued start drained on shutdown, got %d starts (%d queued)", started, agg.pendingRetries())
+	}
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Internal resources of the aggregator checked for saturation
+const (
+	// SaturationResourceDedupe is the global dedupe cache (one entry per MAC/port/password or VM)
+	SaturationResourceDedupe = "dedupe"
+	// SaturationResourceStarts are the VM starts not completed yet: calls in flight,
+	// wakes queued until a migrating or terminating VM settles and failed starts to retry
+	SaturationResourceStarts = "starts"
+	// SaturationResourceEvents are the agent events being processed by the gRPC
+	// server: the intake queue of the aggregator
+	SaturationResourceEvents = "events"
+)
+
+const (
+	// DefaultDedupeSaturation is the default number of dedupe entries considered saturation
+	DefaultDedupeSaturation = 10000
+	// DefaultStartSaturation is the default number of pending starts considered saturation
+	DefaultStartSaturation = 100
+	// DefaultEventSaturation is the default number of events in flight considered saturation
+	DefaultEventSaturation = 500
+
+	saturationCheckInterval = 5 * time.Second
+)
+
+// SaturationThresholds are the limits above which an aggregator resource is saturated.
+// A threshold <= 0 disables the check of that resource.
+type SaturationThresholds struct {
+	DedupeEntries  int
+	PendingStarts  int
+	InFlightEvents int
+}
+
+// ResourceSaturation is the usage of a single aggregator resource
+type ResourceSaturation struct {
+	Resource  string
+	Usage     int
+	Threshold int
+}
+
+// Saturated returns true if the usage reached the threshold
+func (r ResourceSaturation) Saturated() bool {
+	return r.Threshold > 0 && r.Usage >= r.Threshold
+}
+
+// Ratio returns the usage relative to the threshold (0 when the check is disabled)
+func (r ResourceSaturation) Ratio() float64 {
+	if r.Threshold <= 0 {
+		return 0
+	}
+	return float64(r.Usage) / float64(r.Threshold)
+}
+
+// SaturationReport is a snapshot of the aggregator resources
+type SaturationReport struct {
+	Resources []ResourceSaturation
+}
+
+// Saturated returns the saturated resources
+func (s SaturationReport) Saturated() []ResourceSaturation {
+	var saturated []ResourceSaturation
+	for _, r := range s.Resources {
+		if r.Saturated() {
+			saturated = append(saturated, r)
+		}
+	}
+	return saturated
+}
+
+// Message describes the saturated resources, e.g. "dedupe 12000/10000"
+func (s SaturationReport) Message() string {
+	parts := make([]string, 0, len(s.Resources))
+	for _, r := range s.Saturated() {
+		parts = append(parts, fmt.Sprintf("%s %d/%d", r.Resource, r.Usage, r.Threshold))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// SetSaturationThresholds overrides the default saturation thresholds
+func (a *Aggregator) SetSaturationThresholds(thresholds SaturationThresholds) {
+	a.thresholds = thresholds
+}
+
+// OnSaturationChange registers a callback invoked by MonitorSaturation when the
+// set of saturated resources changes. Must be called before MonitorSaturation.
+func (a *Aggregator) OnSaturationChange(fn func()) {
+	a.saturationNotify = fn
+}
+
+// Saturation returns the current usage of the aggregator resources
+func (a *Aggregator) Saturation() SaturationReport {
+	a.dedupeLock.RLock()
+	dedupeEntries := len(a.dedupeMap)
+	a.dedupeLock.RUnlock()
+
+	return SaturationReport{
+		Resources: []ResourceSaturation{
+			{
+				Resource:  SaturationResourceDedupe,
+				Usage:     dedupeEntries,
+				Threshold: a.thresholds.DedupeEntries,
+			},
+			{
+				Resource:  SaturationResourceStarts,
+				Usage:     int(a.startsInFlight.Load()) + a.vmStarter.QueuedWakes() + a.pendingRetries(),
+				Threshold: a.thresholds.PendingStarts,
+			},
+			{
+				Resource:  SaturationResourceEvents,
+				Usage:     int(a.eventsInFlight.Load()),
+				Threshold: a.thresholds.InFlightEvents,
+			},
+		},
+	}
+}
+
+// MonitorSaturation periodically exports the saturation metric and notifies
+// the OnSaturationChange callback when resources become saturated or recover
+func (a *Aggregator) MonitorSaturation(ctx context.Context) {
+	ticker := time.NewTicker(saturationCheckInterval)
+	defer ticker.Stop()
+
+	var previous string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			previous = a.checkSaturation(previous)
+		}
+	}
+}
+
+// checkSaturation updates the metric and returns the description of the
+// saturated resources, calling the notifier if it differs from previous
+func (a *Aggregator) checkSaturation(previous string) string {
+	report := a.Saturation()
+	for _, r := range report.Resources {
+		AggregatorSaturation.WithLabelValues(r.Resource).Set(r.Ratio())
+	}
+
+	current := saturatedResourceNames(report)
+	if current == previous {
+		return current
+	}
+
+	if current != "" {
+		a.log.Info("Aggregator saturated, WOL events may be delayed or lost", "resources", report.Message())
+	} else {
+		a.log.Info("Aggregator no longer saturated")
+	}
+	if a.saturationNotify != nil {
+		a.saturationNotify()
+	}
+	return current
+}
+
+// saturatedResourceNames returns the names of the saturated resources, comma-separated
+func saturatedResourceNames(report SaturationReport) string {
+	var names []string
+	for _, r := range report.Saturated() {
+		names = append(names, r.Resource)
+	}
+	return strings.Join(names, ",")
+}
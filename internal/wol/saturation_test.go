@@ -0,0 +1,89 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestAggregator_Saturation(t *testing.T) {
+	agg := NewAggregator(NewMACMapper(nil, logr.Discard()), NewVMStarter(nil, logr.Discard()), logr.Discard())
+	agg.SetSaturationThresholds(SaturationThresholds{DedupeEntries: 3, PendingStarts: 2, InFlightEvents: 1})
+
+	if saturated := agg.Saturation().Saturated(); len(saturated) != 0 {
+		t.Fatalf("Expected no saturated resources, got %+v", saturated)
+	}
+
+	for i := 0; i < 3; i++ {
+		agg.recordEvent(fmt.Sprintf("key-%d", i), "", "node", "", &wolv1.WOLEventResponse{})
+	}
+	agg.startsInFlight.Add(1)
+	agg.vmStarter.(*VMStarter).queuedCount.Add(1)
+	agg.eventsInFlight.Add(1)
+
+	report := agg.Saturation()
+	saturated := report.Saturated()
+	if len(saturated) != 3 {
+		t.Fatalf("Expected dedupe, starts and events to be saturated, got %+v", saturated)
+	}
+	if msg := report.Message(); msg != "dedupe 3/3, starts 2/2, events 1/1" {
+		t.Errorf("Unexpected message %q", msg)
+	}
+
+	agg.SetSaturationThresholds(SaturationThresholds{})
+	if saturated := agg.Saturation().Saturated(); len(saturated) != 0 {
+		t.Errorf("Expected disabled thresholds to never saturate, got %+v", saturated)
+	}
+}
+
+func TestAggregator_CheckSaturationNotifiesOnChange(t *testing.T) {
+	agg := NewAggregator(NewMACMapper(nil, logr.Discard()), NewVMStarter(nil, logr.Discard()), logr.Discard())
+	agg.SetSaturationThresholds(SaturationThresholds{DedupeEntries: 1, PendingStarts: 10})
+
+	notified := 0
+	agg.OnSaturationChange(func() { notified++ })
+
+	state := agg.checkSaturation("")
+	if notified != 0 {
+		t.Fatalf("Expected no notification while not saturated, got %d", notified)
+	}
+
+	agg.recordEvent("key", "", "node", "", &wolv1.WOLEventResponse{})
+	state = agg.checkSaturation(state)
+	if state != SaturationResourceDedupe || notified != 1 {
+		t.Fatalf("Expected one notification for dedupe, got state %q and %d notifications", state, notified)
+	}
+	if ratio := testutil.ToFloat64(AggregatorSaturation.WithLabelValues(SaturationResourceDedupe)); ratio != 1 {
+		t.Errorf("Expected dedupe saturation ratio 1, got %v", ratio)
+	}
+
+	state = agg.checkSaturation(state)
+	if notified != 1 {
+		t.Errorf("Expected no notification while the state is unchanged, got %d", notified)
+	}
+
+	agg.dedupeMap = make(map[string]*dedupeEntry)
+	if state = agg.checkSaturation(state); state != "" || notified != 2 {
+		t.Errorf("Expected a notification on recovery, got state %q and %d notifications", state, notified)
+	}
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// defaultSecureOnSecretKey is the Secret key read when PasswordSecretRef.Key is empty
+const defaultSecureOnSecretKey = "password"
+
+// AnnotationSecureOnSecret names the Secret, in the namespace of the annotated
+// VirtualMachine, holding the VM's own SecureOn password: "<secret>" reads the
+// "password" key, "<secret>/<key>" another key
+const AnnotationSecureOnSecret = "wol.pillon.org/secureon-secret"
+
+// secureOnResult is the outcome of a SecureOn password check
+type secureOnResult string
+
+const (
+	secureOnOK      secureOnResult = "ok"
+	secureOnMissing secureOnResult = "missing"
+	secureOnInvalid secureOnResult = "invalid"
+)
+
+// normalizeSecureOnPassword converts a configured password to the form produced by
+// parseSecureOnPassword. Accepts 6 or 4 hex bytes separated by colons or dashes,
+// or a dotted IPv4 address for the 4-byte variant.
+func normalizeSecureOnPassword(password string) (string, bool) {
+	password = strings.ToLower(strings.TrimSpace(password))
+	if strings.Contains(password, ".") {
+		if ip := net.ParseIP(password).To4(); ip != nil {
+			return formatSecureOnPassword(ip), true
+		}
+		return "", false
+	}
+
+	parts := strings.FieldsFunc(password, func(r rune) bool { return r == ':' || r == '-' })
+	if len(parts) != SecureOnPasswordSize && len(parts) != secureOnShortPasswordSize {
+		return "", false
+	}
+	for i, part := range parts {
+		b, err := strconv.ParseUint(part, 16, 8)
+		if err != nil || len(part) > 2 {
+			return "", false
+		}
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, ":"), true
+}
+
+// checkSecureOnPassword compares the password received in a packet with the expected one.
+// An empty expected password (not configured or unreadable) never matches.
+func checkSecureOnPassword(expected, received string) secureOnResult {
+	if received == "" {
+		return secureOnMissing
+	}
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(received)) != 1 {
+		return secureOnInvalid
+	}
+	return secureOnOK
+}
+
+// secureOnPolicyFor returns the SecureOn policy of a VM selected by the given config
+func secureOnPolicyFor(config *wolv1beta1.WolConfig, namespace string) wolv1beta1.SecureOnPolicy {
+	spec := config.Spec.SecureOn
+	if spec == nil || spec.Policy == "" {
+		return wolv1beta1.SecureOnPolicyIgnore
+	}
+	if len(spec.Namespaces) == 0 {
+		return spec.Policy
+	}
+	for _, ns := range spec.Namespaces {
+		if ns == namespace {
+			return spec.Policy
+		}
+	}
+	return wolv1beta1.SecureOnPolicyIgnore
+}
+
+// loadSecureOnPassword reads the expected SecureOn password of a config from its Secret.
+// Returns false if the config has no password configured.
+func (m *MACMapper) loadSecureOnPassword(ctx context.Context, config *wolv1beta1.WolConfig) (string, bool, error) {
+	spec := config.Spec.SecureOn
+	if spec == nil || spec.PasswordSecretRef == nil {
+		return "", false, nil
+	}
+	password, err := m.readSecureOnPassword(ctx, *spec.PasswordSecretRef)
+	if err != nil {
+		return "", false, err
+	}
+	return password, true, nil
+}
+
+// readSecureOnPassword reads and normalizes the SecureOn password of a Secret key
+func (m *MACMapper) readSecureOnPassword(ctx context.Context, ref wolv1beta1.SecretKeyReference) (string, error) {
+	key := ref.Key
+	if key == "" {
+		key = defaultSecureOnSecretKey
+	}
+
+	secret := &corev1.Secret{}
+	if err := m.secretReader.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, secret); err != nil {
+		return "", fmt.Errorf("failed to get SecureOn secret %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	value, ok := secret.Data[key]
+	if !ok {
+		return "", fmt.Errorf("SecureOn secret %s/%s has no key %q", ref.Namespace, ref.Name, key)
+	}
+	password, ok := normalizeSecureOnPassword(string(value))
+	if !ok {
+		return "", fmt.Errorf("SecureOn secret %s/%s key %q is not a valid password", ref.Namespace, ref.Name, key)
+	}
+	return password, nil
+}
+
+// vmPasswordAnnotation returns the Secret of the SecureOn password set on a VM
+// with AnnotationSecureOnSecret
+func vmPasswordAnnotation(vm *kubevirtv1.VirtualMachine) (wolv1beta1.SecretKeyReference, bool) {
+	value := strings.TrimSpace(vm.Annotations[AnnotationSecureOnSecret])
+	if value == "" {
+		return wolv1beta1.SecretKeyReference{}, false
+	}
+	name, key, _ := strings.Cut(value, "/")
+	return wolv1beta1.SecretKeyReference{Namespace: vm.Namespace, Name: name, Key: key}, true
+}
+
+// withPassword gives the VM its own SecureOn password. A VM with a password is
+// protected even if its config ignores SecureOn: the policy becomes Require.
+func (info *VMInfo) withPassword(ref wolv1beta1.SecretKeyReference) {
+	info.SecureOnPasswordRef = ref
+	if info.SecureOnPolicy == "" || info.SecureOnPolicy == wolv1beta1.SecureOnPolicyIgnore {
+		info.SecureOnPolicy = wolv1beta1.SecureOnPolicyRequire
+	}
+}
+
+// loadVMPasswords reads the per-VM SecureOn passwords of the mapping. Unreadable
+// passwords are kept as "" so the VM rejects every packet (fail closed).
+func (m *MACMapper) loadVMPasswords(ctx context.Context, mapping *macStore) map[wolv1beta1.SecretKeyReference]string {
+	passwords := make(map[wolv1beta1.SecretKeyReference]string)
+	mapping.Range(func(_ macKey, info VMInfo) bool {
+		ref := info.SecureOnPasswordRef
+		if ref.Name == "" {
+			return true
+		}
+		if _, done := passwords[ref]; done {
+			return true
+		}
+		password, err := m.readSecureOnPassword(ctx, ref)
+		if err != nil {
+			m.log.Error(err, "Failed to load the SecureOn password of a VM", "vm", info.Name, "namespace", info.Namespace)
+			ErrorsTotal.Inc()
+		}
+		passwords[ref] = password
+		return true
+	})
+	return passwords
+}
+
+// SecureOnPasswordFor returns the expected SecureOn password of a VM: its own
+// password if it has one, otherwise the password of its WolConfig
+func (m *MACMapper) SecureOnPasswordFor(info VMInfo) string {
+	if info.SecureOnPasswordRef.Name == "" {
+		return m.SecureOnPassword(info.ConfigName)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.vmPasswords[info.SecureOnPasswordRef]
+}
+
+// enforceSecureOn evaluates the SecureOn policy of the matched VM.
+// Returns a rejection response if the VM must not be started, nil otherwise.
+func (a *Aggregator) enforceSecureOn(event *wolv1.WOLEvent, vmInfo VMInfo, startTime time.Time) *wolv1.WOLEventResponse {
+	policy := vmInfo.SecureOnPolicy
+	if policy == "" || policy == wolv1beta1.SecureOnPolicyIgnore {
+		return nil
+	}
+
+	result := checkSecureOnPassword(a.mapper.SecureOnPasswordFor(vmInfo), event.SecureOnPassword)
+	SecureOnChecksTotal.WithLabelValues(vmInfo.ConfigName, string(policy), string(result)).Inc()
+	if result == secureOnOK {
+		return nil
+	}
+
+	if policy == wolv1beta1.SecureOnPolicyAudit {
+		a.log.Info("SecureOn password check failed (audit only, starting VM anyway)",
+			"mac", event.MacAddress,
+			"vm", vmInfo.Name,
+			"namespace", vmInfo.Namespace,
+			"wolconfig", vmInfo.ConfigName,
+			"result", result,
+			"node", event.NodeName,
+			"source", event.SourceIp)
+		return nil
+	}
+
+	SecureOnRejectedTotal.WithLabelValues(vmInfo.ConfigName, string(result)).Inc()
+	a.log.Info("Rejecting WOL event: SecureOn password check failed",
+		"mac", event.MacAddress,
+		"vm", vmInfo.Name,
+		"namespace", vmInfo.Namespace,
+		"wolconfig", vmInfo.ConfigName,
+		"result", result,
+		"node", event.NodeName,
+		"source", event.SourceIp)
+
+	status := wolv1.ResponseStatus_SECURE_ON_PASSWORD_INVALID
+	message := fmt.Sprintf("Wrong SecureOn password for VM %s/%s", vmInfo.Namespace, vmInfo.Name)
+	if result == secureOnMissing {
+		status = wolv1.ResponseStatus_SECURE_ON_PASSWORD_MISSING
+		message = fmt.Sprintf("VM %s/%s requires a SecureOn password", vmInfo.Namespace, vmInfo.Name)
+	}
+
+	return &wolv1.WOLEventResponse{
+		Status:  status,
+		Message: message,
+		VmInfo: &wolv1.VMInfo{
+			Name:      vmInfo.Name,
+			Namespace: vmInfo.Namespace,
+		},
+		ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+	}
+}
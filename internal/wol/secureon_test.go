@@ -0,0 +1,265 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestNormalizeSecureOnPassword(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		valid bool
+	}{
+		{"01:23:45:67:89:AB", "01:23:45:67:89:ab", true},
+		{" 01-23-45-67-89-ab\n", "01:23:45:67:89:ab", true},
+		{"c0:a8:01:0a", "c0:a8:01:0a", true},
+		{"192.168.1.10", "c0:a8:01:0a", true},
+		{"01:23:45", "", false},
+		{"01:23:45:67:89:zz", "", false},
+		{"001:23:45:67:89:ab", "", false},
+		{"secret", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := normalizeSecureOnPassword(tt.in)
+		if ok != tt.valid || got != tt.want {
+			t.Errorf("normalizeSecureOnPassword(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.valid)
+		}
+	}
+}
+
+func TestSecureOnPolicyFor(t *testing.T) {
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			SecureOn: &wolv1beta1.SecureOnSpec{
+				Policy:     wolv1beta1.SecureOnPolicyRequire,
+				Namespaces: []string{"secure"},
+			},
+		},
+	}
+
+	if policy := secureOnPolicyFor(config, "secure"); policy != wolv1beta1.SecureOnPolicyRequire {
+		t.Errorf("Expected Require in a listed namespace, got %s", policy)
+	}
+	if policy := secureOnPolicyFor(config, "other"); policy != wolv1beta1.SecureOnPolicyIgnore {
+		t.Errorf("Expected Ignore outside the listed namespaces, got %s", policy)
+	}
+	if policy := secureOnPolicyFor(&wolv1beta1.WolConfig{}, "secure"); policy != wolv1beta1.SecureOnPolicyIgnore {
+		t.Errorf("Expected Ignore without SecureOn spec, got %s", policy)
+	}
+}
+
+func TestMACMapper_SecureOn(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "wol-password", Namespace: "kubevirt-wol"},
+		Data:       map[string][]byte{"password": []byte("01:23:45:67:89:AB")},
+	}
+	mapper := NewMACMapper(fake.NewClientBuilder().WithObjects(secret).Build(), logr.Discard())
+
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:12:34:56", VMName: "vm1", Namespace: "default"},
+				{MACAddress: "52:54:00:ab:cd:ef", VMName: "vm2", Namespace: "default",
+					SecureOnPolicy: wolv1beta1.SecureOnPolicyIgnore},
+			},
+			SecureOn: &wolv1beta1.SecureOnSpec{
+				Policy:            wolv1beta1.SecureOnPolicyRequire,
+				PasswordSecretRef: &wolv1beta1.SecretKeyReference{Name: "wol-password", Namespace: "kubevirt-wol"},
+			},
+		},
+	}
+	config.Name = "secure-config"
+	mapper.UpdateConfig(config)
+
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if password := mapper.SecureOnPassword("secure-config"); password != "01:23:45:67:89:ab" {
+		t.Errorf("Expected normalized password from the secret, got %q", password)
+	}
+	if info, _ := mapper.Lookup("52:54:00:12:34:56"); info.SecureOnPolicy != wolv1beta1.SecureOnPolicyRequire {
+		t.Errorf("Expected vm1 to require a password, got %s", info.SecureOnPolicy)
+	}
+	if info, _ := mapper.Lookup("52:54:00:ab:cd:ef"); info.SecureOnPolicy != wolv1beta1.SecureOnPolicyIgnore {
+		t.Errorf("Expected the mapping override to apply to vm2, got %s", info.SecureOnPolicy)
+	}
+}
+
+func TestAggregator_SecureOnRequire(t *testing.T) {
+	mapper := NewMACMapper(nil, logr.Discard())
+	key, _ := parseMACKey("52:54:00:12:34:56")
+	mapper.mapping.Set(key, VMInfo{
+		Name:           "vm1",
+		Namespace:      "default",
+		ConfigName:     "secure-config",
+		SecureOnPolicy: wolv1beta1.SecureOnPolicyRequire,
+	})
+	mapper.secureOnPasswords = map[string]string{"secure-config": "01:23:45:67:89:ab"}
+	agg := NewAggregator(mapper, NewVMStarter(nil, logr.Discard()), logr.Discard())
+
+	tests := []struct {
+		password string
+		want     wolv1.ResponseStatus
+	}{
+		{"", wolv1.ResponseStatus_SECURE_ON_PASSWORD_MISSING},
+		{"01:23:45:67:89:00", wolv1.ResponseStatus_SECURE_ON_PASSWORD_INVALID},
+	}
+
+	for _, tt := range tests {
+		resp, err := agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{
+			MacAddress:       "52:54:00:12:34:56",
+			NodeName:         "test-node",
+			SecureOnPassword: tt.password,
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if resp.Status != tt.want {
+			t.Errorf("password %q: expected %v, got %v", tt.password, tt.want, resp.Status)
+		}
+	}
+}
+
+func TestAggregator_RequestWakeSecureOnRequire(t *testing.T) {
+	mapper := NewMACMapper(nil, logr.Discard())
+	mapper.vms = map[string]VMInfo{
+		vmIndexKey("default", "vm1"): {
+			Name:           "vm1",
+			Namespace:      "default",
+			ConfigName:     "secure-config",
+			SecureOnPolicy: wolv1beta1.SecureOnPolicyRequire,
+		},
+	}
+	agg := NewAggregator(mapper, NewVMStarter(nil, logr.Discard()), logr.Discard())
+
+	resp, err := agg.RequestWake(context.Background(), &wolv1.WakeRequest{
+		Namespace: "default",
+		Name:      "vm1",
+		Source:    ActivatorWakeSource,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Status != wolv1.ResponseStatus_SECURE_ON_PASSWORD_MISSING {
+		t.Errorf("Expected SECURE_ON_PASSWORD_MISSING for a Require VM, got %v", resp.Status)
+	}
+}
+
+func TestMACMapper_VMSecureOnPasswords(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := kubevirtv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	vmSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "vm-password", Namespace: "tenant"},
+		Data:       map[string][]byte{"wol": []byte("aa-bb-cc-dd-ee-ff")},
+	}
+	annotated := &kubevirtv1.VirtualMachine{}
+	annotated.Name, annotated.Namespace = "annotated", "tenant"
+	annotated.Annotations = map[string]string{AnnotationSecureOnSecret: "vm-password/wol"}
+	annotated.Spec.Template = &kubevirtv1.VirtualMachineInstanceTemplateSpec{}
+	annotated.Spec.Template.Spec.Domain.Devices.Interfaces = []kubevirtv1.Interface{{Name: "default", MacAddress: "52:54:00:00:00:01"}}
+
+	// A missing Secret must not fall back to the config password (fail closed)
+	broken := annotated.DeepCopy()
+	broken.Name = "broken"
+	broken.Annotations = map[string]string{AnnotationSecureOnSecret: "missing"}
+	broken.Spec.Template.Spec.Domain.Devices.Interfaces[0].MacAddress = "52:54:00:00:00:02"
+
+	plain := annotated.DeepCopy()
+	plain.Name = "plain"
+	plain.Annotations = nil
+	plain.Spec.Template.Spec.Domain.Devices.Interfaces[0].MacAddress = "52:54:00:00:00:03"
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(vmSecret, annotated, broken, plain).Build()
+	mapper := NewMACMapper(c, logr.Discard())
+	config := &wolv1beta1.WolConfig{Spec: wolv1beta1.WolConfigSpec{NamespaceSelectors: []string{"tenant"}}}
+	config.Name = "tenant-config"
+	mapper.UpdateConfig(config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	agg := NewAggregator(mapper, noopStarter{}, logr.Discard())
+	for _, tc := range []struct {
+		mac, password string
+		want          wolv1.ResponseStatus
+	}{
+		{"52:54:00:00:00:01", "", wolv1.ResponseStatus_SECURE_ON_PASSWORD_MISSING},
+		{"52:54:00:00:00:01", "00:00:00:00:00:00", wolv1.ResponseStatus_SECURE_ON_PASSWORD_INVALID},
+		{"52:54:00:00:00:01", "aa:bb:cc:dd:ee:ff", wolv1.ResponseStatus_VM_START_INITIATED},
+		{"52:54:00:00:00:02", "aa:bb:cc:dd:ee:ff", wolv1.ResponseStatus_SECURE_ON_PASSWORD_INVALID},
+		{"52:54:00:00:00:03", "", wolv1.ResponseStatus_VM_START_INITIATED},
+	} {
+		resp, err := agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: tc.mac, SecureOnPassword: tc.password})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if resp.Status != tc.want {
+			t.Errorf("%s with password %q: expected %v, got %v (%s)", tc.mac, tc.password, tc.want, resp.Status, resp.Message)
+		}
+	}
+}
+
+func TestExplicitMappingPassword(t *testing.T) {
+	mapper := NewMACMapper(fake.NewClientBuilder().Build(), logr.Discard())
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:12:34:56", VMName: "vm1", Namespace: "default",
+					PasswordSecretRef: &wolv1beta1.SecretKeyReference{Name: "vm1-password", Namespace: "default"}},
+				{MACAddress: "52:54:00:ab:cd:ef", VMName: "vm2", Namespace: "default",
+					SecureOnPolicy:    wolv1beta1.SecureOnPolicyAudit,
+					PasswordSecretRef: &wolv1beta1.SecretKeyReference{Name: "vm2-password", Namespace: "default"}},
+			},
+		},
+	}
+	config.Name = "explicit"
+	mapper.UpdateConfig(config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if info, _ := mapper.Lookup("52:54:00:12:34:56"); info.SecureOnPolicy != wolv1beta1.SecureOnPolicyRequire ||
+		info.SecureOnPasswordRef.Name != "vm1-password" {
+		t.Errorf("Expected a mapping password to require it, got %+v", info)
+	}
+	if info, _ := mapper.Lookup("52:54:00:ab:cd:ef"); info.SecureOnPolicy != wolv1beta1.SecureOnPolicyAudit {
+		t.Errorf("Expected the mapping policy to win, got %s", info.SecureOnPolicy)
+	}
+}
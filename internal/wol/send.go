@@ -0,0 +1,109 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// SendWOL sends a magic packet to a machine outside the cluster, from the
+// manager or, with a node name, from the agent of the WolConfig on that node.
+// The agents re-emit the packet as they do for the Forward mappings.
+func (a *Aggregator) SendWOL(_ context.Context, req *wolv1.SendWOLRequest) (*wolv1.SendWOLResponse, error) {
+	forward, err := a.sendWOLForward(req)
+	if err != nil {
+		return nil, err
+	}
+
+	sender := "manager"
+	if req.NodeName == "" {
+		err = sendForward(forward)
+		if err != nil {
+			err = status.Errorf(codes.Internal, "failed to send magic packet for %s: %v", forward.MacAddress, err)
+		}
+	} else {
+		sender = "agent"
+		err = a.pushForward(req.WolConfig, req.NodeName, forward)
+		if err != nil {
+			err = status.Error(codes.Unavailable, err.Error())
+		}
+	}
+	destination := forwardDestination(forward)
+	if err != nil {
+		a.log.Error(err, "Failed to send WOL packet", "mac", forward.MacAddress, "destination", destination,
+			"node", req.NodeName, "wolconfig", req.WolConfig, "source", req.Source)
+		ErrorsTotal.Inc()
+		SentPacketsTotal.WithLabelValues(sender, "error").Inc()
+		return nil, err
+	}
+
+	SentPacketsTotal.WithLabelValues(sender, "ok").Inc()
+	a.log.Info("Sent WOL packet to external machine", "mac", forward.MacAddress, "destination", destination,
+		"node", req.NodeName, "wolconfig", req.WolConfig, "source", req.Source)
+	message := fmt.Sprintf("Magic packet for %s sent to %s by the manager", forward.MacAddress, destination)
+	if sender == "agent" {
+		// L'agent invia il pacchetto in modo asincrono: l'esito è nei suoi log
+		message = fmt.Sprintf("Magic packet for %s queued on the agent of node %s (WolConfig %s) for %s",
+			forward.MacAddress, req.NodeName, req.WolConfig, destination)
+	}
+	return &wolv1.SendWOLResponse{Sender: sender, Destination: destination, Message: message}, nil
+}
+
+// sendWOLForward validates a SendWOL request and returns the packet to send
+func (a *Aggregator) sendWOLForward(req *wolv1.SendWOLRequest) (*wolv1.ForwardRequest, error) {
+	key, ok := parseMACKey(req.MacAddress)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid MAC address %q", req.MacAddress)
+	}
+	if req.SecureOnPassword != "" {
+		if _, ok := normalizeSecureOnPassword(req.SecureOnPassword); !ok {
+			return nil, status.Error(codes.InvalidArgument, "invalid SecureOn password (4 or 6 bytes, e.g. 01:02:03:04:05:06)")
+		}
+	}
+	switch {
+	case req.Address == "" && req.Interface == "":
+		return nil, status.Error(codes.InvalidArgument, "address or interface is required")
+	case req.Address != "" && net.ParseIP(req.Address) == nil:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid address %q", req.Address)
+	case req.Port > 65535:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid port %d", req.Port)
+	case req.NodeName != "" && req.WolConfig == "":
+		return nil, status.Error(codes.InvalidArgument, "wol_config is required with node_name")
+	}
+	if req.WolConfig != "" && a.mapper.wolConfig(req.WolConfig) == nil {
+		return nil, status.Errorf(codes.NotFound, "WolConfig %s not found", req.WolConfig)
+	}
+
+	port := req.Port
+	if port == 0 {
+		port = DefaultWOLPort
+	}
+	return &wolv1.ForwardRequest{
+		MacAddress:       key.String(),
+		SecureOnPassword: req.SecureOnPassword,
+		Address:          req.Address,
+		Port:             port,
+		Interface:        req.Interface,
+	}, nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestAggregator_SendWOLFromManager(t *testing.T) {
+	conn, port := listenForward(t)
+	agg := newForwardAggregator(t, wolv1beta1.WOLForwardTarget{Address: "192.168.10.255"})
+
+	resp, err := agg.SendWOL(context.Background(), &wolv1.SendWOLRequest{
+		MacAddress:       "AA-BB-CC-DD-EE-FF",
+		SecureOnPassword: "01:02:03:04",
+		Address:          "127.0.0.1",
+		Port:             uint32(port),
+		Source:           "test",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Sender != "manager" || resp.Destination != fmt.Sprintf("127.0.0.1:%d", port) {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+	if mac, password := readForward(t, conn); mac != "aa:bb:cc:dd:ee:ff" || password != "01:02:03:04" {
+		t.Errorf("Unexpected packet for %s (password %q)", mac, password)
+	}
+}
+
+func TestAggregator_SendWOLThroughAgent(t *testing.T) {
+	agg := newForwardAggregator(t, wolv1beta1.WOLForwardTarget{Address: "192.168.10.255"})
+	req := &wolv1.SendWOLRequest{
+		MacAddress: "aa:bb:cc:dd:ee:ff",
+		Address:    "192.168.20.255",
+		Interface:  "eth1",
+		NodeName:   "edge",
+		WolConfig:  "lab",
+	}
+
+	// Senza agent sul nodo l'invio fallisce
+	if _, err := agg.SendWOL(context.Background(), req); status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected Unavailable without agent on the node, got %v", err)
+	}
+
+	queue := make(chan *wolv1.ForwardRequest, 1)
+	agg.forwardStreams = map[string]chan *wolv1.ForwardRequest{"lab/edge": queue}
+	resp, err := agg.SendWOL(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Sender != "agent" || resp.Destination != "192.168.20.255:9 via eth1" {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+	forward := <-queue
+	if forward.MacAddress != "aa:bb:cc:dd:ee:ff" || forward.Port != DefaultWOLPort || forward.Interface != "eth1" {
+		t.Errorf("Unexpected packet queued on the agent: %+v", forward)
+	}
+}
+
+func TestAggregator_SendWOLInvalid(t *testing.T) {
+	agg := newForwardAggregator(t, wolv1beta1.WOLForwardTarget{Address: "192.168.10.255"})
+	tests := []struct {
+		name string
+		req  *wolv1.SendWOLRequest
+		want codes.Code
+	}{
+		{"invalid MAC", &wolv1.SendWOLRequest{MacAddress: "nope", Address: "10.0.0.255"}, codes.InvalidArgument},
+		{"invalid password", &wolv1.SendWOLRequest{MacAddress: "aa:bb:cc:dd:ee:ff", SecureOnPassword: "01",
+			Address: "10.0.0.255"}, codes.InvalidArgument},
+		{"no destination", &wolv1.SendWOLRequest{MacAddress: "aa:bb:cc:dd:ee:ff"}, codes.InvalidArgument},
+		{"invalid address", &wolv1.SendWOLRequest{MacAddress: "aa:bb:cc:dd:ee:ff", Address: "lab.local"}, codes.InvalidArgument},
+		{"invalid port", &wolv1.SendWOLRequest{MacAddress: "aa:bb:cc:dd:ee:ff", Address: "10.0.0.255",
+			Port: 70000}, codes.InvalidArgument},
+		{"node without WolConfig", &wolv1.SendWOLRequest{MacAddress: "aa:bb:cc:dd:ee:ff", Address: "10.0.0.255",
+			NodeName: "edge"}, codes.InvalidArgument},
+		{"unknown WolConfig", &wolv1.SendWOLRequest{MacAddress: "aa:bb:cc:dd:ee:ff", Address: "10.0.0.255",
+			NodeName: "edge", WolConfig: "other"}, codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := agg.SendWOL(context.Background(), tt.req); status.Code(err) != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
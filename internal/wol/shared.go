@@ -0,0 +1,83 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"slices"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// SharedAgentConfigs returns the names, sorted, of the WolConfigs whose agents
+// run in the shared DaemonSet. The first one is the WolConfig the shared
+// agents run with (WOLCONFIG_NAME) and take their settings from.
+func SharedAgentConfigs(configs []wolv1beta1.WolConfig) []string {
+	var names []string
+	for i := range configs {
+		if configs[i].Spec.Agent.Shared && configs[i].DeletionTimestamp == nil {
+			names = append(names, configs[i].Name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// agentConfigs returns the WolConfigs served by the agents running with
+// configName: all those with shared agents if it is one of them
+func (m *MACMapper) agentConfigs(configName string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if shared := SharedAgentConfigs(m.configs); slices.Contains(shared, configName) {
+		return shared
+	}
+	return []string{configName}
+}
+
+// agentConfigName returns the WolConfig the agents serving configName run with
+func (m *MACMapper) agentConfigName(configName string) string {
+	return m.agentConfigs(configName)[0]
+}
+
+// AgentNetworkAttachments returns the NetworkAttachmentDefinitions used by the
+// VMs of the WolConfigs served by the agents running with configName
+func (m *MACMapper) AgentNetworkAttachments(configName string) []NetworkAttachment {
+	names := m.agentConfigs(configName)
+	if len(names) == 1 {
+		return m.NetworkAttachments(names[0])
+	}
+	seen := make(map[string]bool)
+	var result []NetworkAttachment
+	for _, name := range names {
+		for _, attachment := range m.NetworkAttachments(name) {
+			if !seen[attachment.Key()] {
+				seen[attachment.Key()] = true
+				result = append(result, attachment)
+			}
+		}
+	}
+	return result
+}
+
+// agentARPTargets returns the ARP targets of the WolConfigs served by the
+// agents running with configName
+func (m *MACMapper) agentARPTargets(configName string) []ARPTarget {
+	var result []ARPTarget
+	for _, name := range m.agentConfigs(configName) {
+		result = append(result, m.ARPTargets(name)...)
+	}
+	return result
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-logr/logr"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// Dedupe backends of the manager
+const (
+	DedupeBackendMemory = "memory"
+	DedupeBackendLease  = "lease"
+)
+
+// LabelDedupeLease marks the Leases used as shared dedupe claims
+const LabelDedupeLease = "wol.pillon.org/dedupe"
+
+// Results counted by wol_shared_dedupe_claims_total
+const (
+	sharedClaimClaimed   = "claimed"
+	sharedClaimDuplicate = "duplicate"
+	sharedClaimError     = "error"
+)
+
+// SharedDedupe settles the duplicates between manager replicas: each replica
+// keeps its own dedupe cache, and claims an event on the shared backend before
+// acting on the VM
+type SharedDedupe interface {
+	// Claim claims key for window and returns false if another claim of key
+	// (from any replica) is still active
+	Claim(ctx context.Context, key string, window time.Duration) (bool, error)
+	// Cleanup deletes the expired claims
+	Cleanup(ctx context.Context) error
+}
+
+// SetSharedDedupe enables the dedupe shared with the other manager replicas
+func (a *Aggregator) SetSharedDedupe(shared SharedDedupe) {
+	a.shared = shared
+}
+
+// claimShared claims an event on the shared dedupe backend before the VM is
+// started or stopped, and returns the DUPLICATE response (recorded in the local
+// cache) if another replica claimed it first. The claim fails open: if the
+// backend is unreachable the event is handled, at the cost of a possible
+// duplicate start.
+func (a *Aggregator) claimShared(ctx context.Context, key, password, nodeName string, vmInfo VMInfo, startTime time.Time) *wolv1.WOLEventResponse {
+	if a.shared == nil {
+		return nil
+	}
+	// La password fa parte della claim: un pacchetto con la password sbagliata
+	// non deve bloccare quello giusto
+	claimed, err := a.shared.Claim(ctx, key+"|"+password, a.dedupeWindow(vmInfo.ConfigName))
+	if err != nil {
+		SharedDedupeClaimsTotal.WithLabelValues(sharedClaimError).Inc()
+		a.log.Error(err, "Failed to claim the event on the shared dedupe backend, handling it anyway",
+			"key", key, "vm", vmInfo.Name, "namespace", vmInfo.Namespace)
+		return nil
+	}
+	if claimed {
+		SharedDedupeClaimsTotal.WithLabelValues(sharedClaimClaimed).Inc()
+		return nil
+	}
+
+	SharedDedupeClaimsTotal.WithLabelValues(sharedClaimDuplicate).Inc()
+	a.log.V(1).Info("Duplicate WOL event (claimed by another replica)", "key", key, "node", nodeName)
+	resp := &wolv1.WOLEventResponse{
+		Status:       wolv1.ResponseStatus_DUPLICATE,
+		Message:      "Event already processed recently by another manager replica",
+		WasDuplicate: true,
+		VmInfo: &wolv1.VMInfo{
+			Name:      vmInfo.Name,
+			Namespace: vmInfo.Namespace,
+		},
+		ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+	}
+	a.recordEvent(key, password, nodeName, vmInfo.ConfigName, resp)
+	return resp
+}
+
+// LeaseDedupe is a SharedDedupe backed by coordination.k8s.io Leases, one per
+// claimed event: the apiserver lets a single replica create (or take over,
+// once expired) the Lease of an event
+type LeaseDedupe struct {
+	client    client.Client
+	namespace string
+	identity  string
+	log       logr.Logger
+}
+
+// NewLeaseDedupe creates a SharedDedupe keeping its Leases in namespace, held
+// by identity (the replica). The client should not be cached: claims must see
+// the Leases created a moment before by the other replicas.
+func NewLeaseDedupe(c client.Client, namespace, identity string, log logr.Logger) *LeaseDedupe {
+	return &LeaseDedupe{client: c, namespace: namespace, identity: identity, log: log}
+}
+
+// dedupeLeaseName returns the name of the Lease claiming key
+func dedupeLeaseName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "wol-dedupe-" + hex.EncodeToString(sum[:10])
+}
+
+// dedupeLeaseExpired returns true if the claim of a Lease is over
+func dedupeLeaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return !now.Before(expiry)
+}
+
+// Claim implements SharedDedupe
+func (d *LeaseDedupe) Claim(ctx context.Context, key string, window time.Duration) (bool, error) {
+	now := time.Now()
+	renew := metav1.NewMicroTime(now)
+	seconds := int32(max(1, math.Ceil(window.Seconds())))
+	spec := coordinationv1.LeaseSpec{
+		HolderIdentity:       &d.identity,
+		LeaseDurationSeconds: &seconds,
+		AcquireTime:          &renew,
+		RenewTime:            &renew,
+	}
+	name := dedupeLeaseName(key)
+
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: d.namespace,
+			Labels:    map[string]string{LabelDedupeLease: "true"},
+		},
+		Spec: spec,
+	}
+	err := d.client.Create(ctx, lease)
+	if err == nil {
+		return true, nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return false, fmt.Errorf("failed to create dedupe Lease %s: %w", name, err)
+	}
+
+	existing := &coordinationv1.Lease{}
+	if err := d.client.Get(ctx, client.ObjectKey{Namespace: d.namespace, Name: name}, existing); err != nil {
+		if apierrors.IsNotFound(err) {
+			// Cancellata dal cleanup nel frattempo: la claim è scaduta
+			return d.Claim(ctx, key, window)
+		}
+		return false, fmt.Errorf("failed to get dedupe Lease %s: %w", name, err)
+	}
+	if !dedupeLeaseExpired(existing, now) {
+		return false, nil
+	}
+
+	// Claim scaduta: la prende chi aggiorna per primo la Lease
+	existing.Spec = spec
+	if err := d.client.Update(ctx, existing); err != nil {
+		if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to take over dedupe Lease %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// Cleanup implements SharedDedupe. A Lease taken over since it was listed is
+// kept (the delete is conditioned on its resource version).
+func (d *LeaseDedupe) Cleanup(ctx context.Context) error {
+	leases := &coordinationv1.LeaseList{}
+	if err := d.client.List(ctx, leases, client.InNamespace(d.namespace),
+		client.MatchingLabels{LabelDedupeLease: "true"}); err != nil {
+		return fmt.Errorf("failed to list dedupe Leases: %w", err)
+	}
+
+	now := time.Now()
+	deleted := 0
+	for i := range leases.Items {
+		lease := &leases.Items[i]
+		if !dedupeLeaseExpired(lease, now) {
+			continue
+		}
+		err := d.client.Delete(ctx, lease, client.Preconditions{ResourceVersion: &lease.ResourceVersion})
+		if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+			return fmt.Errorf("failed to delete dedupe Lease %s: %w", lease.Name, err)
+		}
+		if err == nil {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		d.log.V(1).Info("Cleaned up dedupe Leases", "deleted", deleted, "remaining", len(leases.Items)-deleted)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func newLeaseClient() client.Client {
+	return fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build()
+}
+
+func TestLeaseDedupe_Claim(t *testing.T) {
+	ctx := context.Background()
+	c := newLeaseClient()
+	replicaA := NewLeaseDedupe(c, "wol-system", "replica-a", logr.Discard())
+	replicaB := NewLeaseDedupe(c, "wol-system", "replica-b", logr.Discard())
+
+	if claimed, err := replicaA.Claim(ctx, "mac|52:54:00:00:00:01", 10*time.Second); err != nil || !claimed {
+		t.Fatalf("Expected the first claim to succeed, got %v, %v", claimed, err)
+	}
+	if claimed, err := replicaB.Claim(ctx, "mac|52:54:00:00:00:01", 10*time.Second); err != nil || claimed {
+		t.Errorf("Expected an active claim to be refused, got %v, %v", claimed, err)
+	}
+	if claimed, _ := replicaB.Claim(ctx, "mac|52:54:00:00:00:02", 10*time.Second); !claimed {
+		t.Error("Expected another key to be claimed")
+	}
+
+	// Una claim scaduta passa alla prima replica che la aggiorna
+	lease := &coordinationv1.Lease{}
+	key := client.ObjectKey{Namespace: "wol-system", Name: dedupeLeaseName("mac|52:54:00:00:00:01")}
+	if err := c.Get(ctx, key, lease); err != nil {
+		t.Fatal(err)
+	}
+	expired := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+	lease.Spec.RenewTime = &expired
+	if err := c.Update(ctx, lease); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, err := replicaB.Claim(ctx, "mac|52:54:00:00:00:01", 10*time.Second); err != nil || !claimed {
+		t.Fatalf("Expected the expired claim to be taken over, got %v, %v", claimed, err)
+	}
+	if err := c.Get(ctx, key, lease); err != nil || *lease.Spec.HolderIdentity != "replica-b" {
+		t.Errorf("Expected replica-b to hold the Lease, got %v (%v)", *lease.Spec.HolderIdentity, err)
+	}
+
+	// Il cleanup cancella solo le claim scadute
+	lease.Spec.RenewTime = &expired
+	if err := c.Update(ctx, lease); err != nil {
+		t.Fatal(err)
+	}
+	if err := replicaA.Cleanup(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	leases := &coordinationv1.LeaseList{}
+	if err := c.List(ctx, leases, client.InNamespace("wol-system")); err != nil {
+		t.Fatal(err)
+	}
+	if len(leases.Items) != 1 || leases.Items[0].Name != dedupeLeaseName("mac|52:54:00:00:00:02") {
+		t.Errorf("Expected only the active claim left, got %d Leases", len(leases.Items))
+	}
+}
+
+func TestAggregator_SharedDedupe(t *testing.T) {
+	ctx := context.Background()
+	c := newLeaseClient()
+	starterA, starterB := &flakyStarter{}, &flakyStarter{}
+	replicaA := newRetryAggregator(t, starterA, RetryOptions{})
+	replicaA.SetSharedDedupe(NewLeaseDedupe(c, "wol-system", "replica-a", logr.Discard()))
+	replicaB := newRetryAggregator(t, starterB, RetryOptions{})
+	replicaB.SetSharedDedupe(NewLeaseDedupe(c, "wol-system", "replica-b", logr.Discard()))
+
+	// Lo stesso broadcast arriva a due agent connessi a repliche diverse
+	event := func(node string) *wolv1.WOLEvent {
+		return &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01", NodeName: node, DestinationPort: 9}
+	}
+	resp, _ := replicaA.ReportWOLEvent(ctx, event("node-a"))
+	if resp.Status != wolv1.ResponseStatus_VM_START_INITIATED {
+		t.Fatalf("Expected the first replica to start the VM, got %v: %s", resp.Status, resp.Message)
+	}
+	resp, _ = replicaB.ReportWOLEvent(ctx, event("node-b"))
+	if resp.Status != wolv1.ResponseStatus_DUPLICATE || !resp.WasDuplicate {
+		t.Errorf("Expected the second replica to report a duplicate, got %v: %s", resp.Status, resp.Message)
+	}
+	// Il duplicato resta nella cache locale della seconda replica
+	if duplicate, _ := replicaB.checkDuplicate(eventDedupeKey(event("node-c")), "", "node-c"); !duplicate {
+		t.Error("Expected the duplicate to be cached by the second replica")
+	}
+
+	// Le richieste di wake per nome usano le stesse claim
+	wake := &wolv1.WakeRequest{Namespace: "tenant", Name: "vm1", Source: APIWakeSource}
+	if resp, _ := replicaB.RequestWake(ctx, wake); resp.Status != wolv1.ResponseStatus_VM_START_INITIATED {
+		t.Errorf("Expected the wake request to start the VM, got %v", resp.Status)
+	}
+	if resp, _ := replicaA.RequestWake(ctx, wake); resp.Status != wolv1.ResponseStatus_DUPLICATE {
+		t.Errorf("Expected the wake request to be a duplicate on the other replica, got %v", resp.Status)
+	}
+
+	_, startedA := starterA.counts()
+	_, startedB := starterB.counts()
+	if startedA != 1 || startedB != 1 {
+		t.Errorf("Expected one start per distinct event, got %d and %d", startedA, startedB)
+	}
+}
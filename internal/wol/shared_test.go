@@ -0,0 +1,66 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestMACMapper_SharedAgentConfigs(t *testing.T) {
+	mapper := NewMACMapper(nil, logr.Discard())
+	var configs []wolv1beta1.WolConfig
+	for _, name := range []string{"prod", "lab", "solo"} {
+		config := wolv1beta1.WolConfig{}
+		config.Name = name
+		config.Spec.Agent.Shared = name != "solo"
+		configs = append(configs, config)
+	}
+	mapper.UpdateConfigs(configs)
+
+	if names := mapper.agentConfigs("prod"); !slices.Equal(names, []string{"lab", "prod"}) {
+		t.Errorf("Expected the shared configs sorted, got %v", names)
+	}
+	if name := mapper.agentConfigName("prod"); name != "lab" {
+		t.Errorf("Expected the shared agents to run with lab, got %s", name)
+	}
+	if name := mapper.agentConfigName("solo"); name != "solo" {
+		t.Errorf("Expected the dedicated agents to run with solo, got %s", name)
+	}
+
+	// Gli heartbeat degli agent condivisi valgono per tutte le WolConfig servite
+	agg := NewAggregator(mapper, NewVMStarter(nil, logr.Discard()), logr.Discard())
+	for _, configName := range []string{"lab", "solo"} {
+		if _, err := agg.AgentHeartbeat(context.Background(), &wolv1.AgentHeartbeatRequest{
+			NodeName: "node-a", WolConfig: configName,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if agents := agg.Agents("prod"); len(agents) != 1 || agents[0].WolConfig != "lab" {
+		t.Errorf("Expected prod to be served by the shared agent, got %v", agents)
+	}
+	if agents := agg.Agents("solo"); len(agents) != 1 || agents[0].WolConfig != "solo" {
+		t.Errorf("Expected solo to be served by its own agent, got %v", agents)
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// reservedEtherTypes cannot carry sleep packets (nor other wake packets): they
+// are WoL itself, ARP, VLAN tags and the IP protocols
+var reservedEtherTypes = map[uint16]bool{
+	etherTypeWoL: true, etherTypeARP: true, 0x8100: true, 0x0800: true, 0x86dd: true,
+}
+
+// ParseEtherType parses the EtherType of the sleep frames (e.g. "0x0843")
+func ParseEtherType(value string) (uint16, error) {
+	return parseEtherType(value, "sleep packets", false)
+}
+
+// ParseWakeEtherType parses an EtherType of the raw frames carrying wake magic
+// packets (e.g. "0x88b7"); 0x0842 is accepted, since it is always captured
+func ParseWakeEtherType(value string) (uint16, error) {
+	return parseEtherType(value, "wake packets", true)
+}
+
+func parseEtherType(value, use string, allowWoL bool) (uint16, error) {
+	hex, ok := strings.CutPrefix(strings.ToLower(strings.TrimSpace(value)), "0x")
+	if !ok {
+		return 0, fmt.Errorf("invalid EtherType %q (0xNNNN)", value)
+	}
+	etherType, err := strconv.ParseUint(hex, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid EtherType %q (0xNNNN)", value)
+	}
+	if allowWoL && etherType == etherTypeWoL {
+		return etherTypeWoL, nil
+	}
+	// Sotto 0x0600 il campo è una lunghezza (802.3), non un EtherType
+	if etherType < 0x0600 || reservedEtherTypes[uint16(etherType)] {
+		return 0, fmt.Errorf("EtherType %q cannot be used for %s", value, use)
+	}
+	return uint16(etherType), nil
+}
+
+// reverseMAC returns the MAC with its bytes in reverse order, as carried by
+// Sleep-on-LAN magic packets
+func reverseMAC(mac string) (string, bool) {
+	key, ok := parseMACKey(mac)
+	if !ok {
+		return "", false
+	}
+	return key.reversed().String(), true
+}
+
+// reversed returns the MAC with its bytes in reverse order
+func (k macKey) reversed() macKey {
+	for i, j := 0, len(k)-1; i < j; i, j = i+1, j-1 {
+		k[i], k[j] = k[j], k[i]
+	}
+	return k
+}
+
+// sleepDedupeKey is the dedupe key of the sleep frames of a MAC, distinct
+// from the wakes of the same MAC
+func sleepDedupeKey(mac string, port uint32) string {
+	return dedupeKey(mac, port) + "|sleep"
+}
+
+// ShutdownOnLAN returns the Shutdown-on-LAN settings of a WolConfig, nil if
+// they are not enabled
+func (m *MACMapper) ShutdownOnLAN(configName string) *wolv1beta1.ShutdownOnLANSpec {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range m.configs {
+		if m.configs[i].Name != configName {
+			continue
+		}
+		if spec := m.configs[i].Spec.ShutdownOnLAN; spec != nil && spec.Enabled {
+			return spec
+		}
+		return nil
+	}
+	return nil
+}
+
+// LookupSleep returns the VM of a Sleep-on-LAN magic packet, which carries
+// the MAC of the VM reversed, if its WolConfig enables Shutdown-on-LAN
+func (m *MACMapper) LookupSleep(macAddress string) (VMInfo, bool) {
+	reversed, ok := reverseMAC(macAddress)
+	if !ok {
+		return VMInfo{}, false
+	}
+	info, found := m.Lookup(reversed)
+	if !found || m.ShutdownOnLAN(info.ConfigName) == nil {
+		return VMInfo{}, false
+	}
+	return info, true
+}
+
+// handleSleep stops or pauses the VM of a sleep packet, as set by the
+// Shutdown-on-LAN settings of its WolConfig
+func (a *Aggregator) handleSleep(ctx context.Context, event *wolv1.WOLEvent, vmInfo VMInfo, startTime time.Time) *wolv1.WOLEventResponse {
+	vm := &wolv1.VMInfo{Name: vmInfo.Name, Namespace: vmInfo.Namespace}
+	spec := a.mapper.ShutdownOnLAN(vmInfo.ConfigName)
+	if spec == nil {
+		a.log.Info("Sleep packet for a VM without Shutdown-on-LAN", "mac", event.MacAddress,
+			"vm", vmInfo.Name, "namespace", vmInfo.Namespace, "wolconfig", vmInfo.ConfigName)
+		return &wolv1.WOLEventResponse{
+			Status:           wolv1.ResponseStatus_POLICY_REJECTED,
+			Message:          fmt.Sprintf("Shutdown-on-LAN is not enabled for WolConfig %s", vmInfo.ConfigName),
+			VmInfo:           vm,
+			ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+		}
+	}
+	action := spec.Action
+	if action == "" {
+		action = wolv1beta1.ShutdownActionStop
+	}
+	if a.mapper.DryRun(vmInfo.ConfigName) {
+		a.skipDryRun(vmInfo.ConfigName, string(action), vmIndexKey(vmInfo.Namespace, vmInfo.Name))
+		return &wolv1.WOLEventResponse{
+			Status: wolv1.ResponseStatus_DRY_RUN,
+			Message: fmt.Sprintf("Dry run: VM would be %s from node %s (Shutdown-on-LAN of WolConfig %s)",
+				simulatedActionVerb(string(action)), event.NodeName, vmInfo.ConfigName),
+			VmInfo:           vm,
+			ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+		}
+	}
+	stopper, ok := a.vmStarter.(VMStopper)
+	if !ok {
+		ErrorsTotal.Inc()
+		return &wolv1.WOLEventResponse{
+			Status:           wolv1.ResponseStatus_ERROR,
+			Message:          "The VM starter does not support Shutdown-on-LAN",
+			VmInfo:           vm,
+			ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+		}
+	}
+
+	a.log.Info("Stopping VM for sleep packet",
+		"mac", event.MacAddress,
+		"vm", vmInfo.Name,
+		"namespace", vmInfo.Namespace,
+		"node", event.NodeName,
+		"source", event.SourceIp,
+		"wolconfig", vmInfo.ConfigName,
+		"action", action)
+
+	var err error
+	if action == wolv1beta1.ShutdownActionPause {
+		vm.CurrentState = "Pausing"
+		err = stopper.PauseVMAs(ctx, vmInfo.StartAs, vmInfo.Namespace, vmInfo.Name)
+	} else {
+		vm.CurrentState = "Stopping"
+		err = stopper.StopVMAs(ctx, vmInfo.StartAs, vmInfo.Namespace, vmInfo.Name)
+	}
+	if err != nil {
+		a.log.Error(err, "Failed to stop VM for sleep packet", "vm", vmInfo.Name, "namespace", vmInfo.Namespace,
+			"action", action)
+		ErrorsTotal.Inc()
+		vm.CurrentState = ""
+		return &wolv1.WOLEventResponse{
+			Status:           wolv1.ResponseStatus_ERROR,
+			Message:          fmt.Sprintf("Failed to %s VM: %v", strings.ToLower(string(action)), err),
+			VmInfo:           vm,
+			ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+		}
+	}
+
+	return &wolv1.WOLEventResponse{
+		Status: wolv1.ResponseStatus_VM_STOP_INITIATED,
+		Message: fmt.Sprintf("VM %s initiated from node %s (Shutdown-on-LAN of WolConfig %s)",
+			strings.ToLower(string(action)), event.NodeName, vmInfo.ConfigName),
+		VmInfo:           vm,
+		ProcessingTimeMs: time.Since(startTime).Milliseconds(),
+	}
+}
+
+// SetSleepEtherType makes the raw listeners report the broadcast frames with
+// this EtherType carrying a magic packet as sleep packets (0 = disabled)
+func (a *Agent) SetSleepEtherType(etherType uint16) {
+	a.sleepEtherType = etherType
+}
+
+// rawSleepHandler reports a sleep frame captured by a raw listener
+func (a *Agent) rawSleepHandler(mac string, payload []byte, srcMAC net.HardwareAddr) {
+	addr := &net.UDPAddr{IP: net.IPv4bcast, Port: 0}
+	a.packetLog.Info(a.log.V(7), "Raw Ethernet sleep packet forwarded to processing",
+		"targetMAC", mac,
+		"sourceMAC", a.packetLog.Source(srcMAC.String()))
+
+	receivedAt := time.Now()
+	a.report(func(reportCtx context.Context) {
+		a.processPacket(reportCtx, payload, addr, 0, true, receivedAt)
+	})
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// stopStarter also records the stops and pauses
+type stopStarter struct {
+	policyStarter
+}
+
+func (s *stopStarter) StopVMAs(_ context.Context, _, _, name string) error {
+	return s.record(name, "stop")
+}
+func (s *stopStarter) PauseVMAs(_ context.Context, _, _, name string) error {
+	return s.record(name, "pause")
+}
+
+func TestParseEtherType(t *testing.T) {
+	if etherType, err := ParseEtherType(" 0x88B5 "); err != nil || etherType != 0x88b5 {
+		t.Errorf("ParseEtherType = %#x, %v", etherType, err)
+	}
+	for _, invalid := range []string{"", "88b5", "0x", "0x10000", "0x0842", "0x0806", "0x05dc"} {
+		if _, err := ParseEtherType(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestParseWakeEtherType(t *testing.T) {
+	for value, want := range map[string]uint16{"0x88b7": 0x88b7, "0x0842": etherTypeWoL} {
+		if etherType, err := ParseWakeEtherType(value); err != nil || etherType != want {
+			t.Errorf("ParseWakeEtherType(%q) = %#x, %v", value, etherType, err)
+		}
+	}
+	for _, invalid := range []string{"0x0800", "0x0806", "0x8100", "0x05dc", "88b7"} {
+		if _, err := ParseWakeEtherType(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestReverseMAC(t *testing.T) {
+	if reversed, ok := reverseMAC("52:54:00:AB:CD:EF"); !ok || reversed != "ef:cd:ab:00:54:52" {
+		t.Errorf("reverseMAC = %q, %v", reversed, ok)
+	}
+	if _, ok := reverseMAC("not-a-mac"); ok {
+		t.Error("Expected an invalid MAC to be rejected")
+	}
+}
+
+func TestAggregator_ShutdownOnLAN(t *testing.T) {
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+	sleepy := wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:01", VMName: "desktop", Namespace: "default"},
+			},
+			ShutdownOnLAN: &wolv1beta1.ShutdownOnLANSpec{Enabled: true, Action: wolv1beta1.ShutdownActionPause},
+		},
+	}
+	sleepy.Name = "sleepy"
+	awake := wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:02", VMName: "server", Namespace: "default"},
+			},
+		},
+	}
+	awake.Name = "awake"
+	mapper.UpdateConfigs([]wolv1beta1.WolConfig{sleepy, awake})
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	starter := &stopStarter{policyStarter{actions: make(map[string]string)}}
+	agg := NewAggregator(mapper, starter, logr.Discard())
+
+	// Sleep-on-LAN: il MAC della VM invertito
+	resp, _ := agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "01:00:00:00:54:52", DestinationPort: 9})
+	if resp.Status != wolv1.ResponseStatus_VM_STOP_INITIATED || starter.actions["desktop"] != "pause" {
+		t.Errorf("Expected the desktop to be paused, got %v (%v)", resp.Status, starter.actions)
+	}
+
+	// Un frame di sleep non è un duplicato del wake dello stesso MAC
+	resp, _ = agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01"})
+	if resp.Status != wolv1.ResponseStatus_VM_START_INITIATED {
+		t.Errorf("Expected the desktop to be woken, got %v", resp.Status)
+	}
+	resp, _ = agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01", Sleep: true})
+	if resp.Status != wolv1.ResponseStatus_VM_STOP_INITIATED {
+		t.Errorf("Expected the sleep frame to pause the desktop, got %v", resp.Status)
+	}
+
+	// Senza shutdownOnLAN il MAC invertito è sconosciuto e i frame di sleep sono rifiutati
+	resp, _ = agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "02:00:00:00:54:52"})
+	if resp.Status != wolv1.ResponseStatus_VM_NOT_FOUND {
+		t.Errorf("Expected the reversed MAC to be unknown, got %v", resp.Status)
+	}
+	resp, _ = agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:02", Sleep: true})
+	if resp.Status != wolv1.ResponseStatus_POLICY_REJECTED {
+		t.Errorf("Expected the sleep frame to be rejected, got %v", resp.Status)
+	}
+	if _, stopped := starter.actions["server"]; stopped {
+		t.Error("Expected the server not to be stopped")
+	}
+}
+
+func TestRawListener_SleepFrame(t *testing.T) {
+	var wakes, sleeps []string
+	listener := NewRawListener("test0", func(mac string, _ []byte, _ net.HardwareAddr) {
+		wakes = append(wakes, mac)
+	}, logr.Discard())
+	listener.SetSleepHandler(0x88b5, func(mac string, _ []byte, _ net.HardwareAddr) {
+		sleeps = append(sleeps, mac)
+	})
+
+	mac := []byte{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	frame := func(etherType uint16) []byte {
+		header := append(bytes.Repeat([]byte{0xff}, 6), 0x02, 0, 0, 0, 0, 1)
+		header = binary.BigEndian.AppendUint16(header, etherType)
+		return append(header, buildMagicPacket(mac, nil)...)
+	}
+	listener.processEthernetFrame(frame(etherTypeWoL))
+	listener.processEthernetFrame(frame(0x88b5))
+	listener.processEthernetFrame(frame(0x88b6))
+
+	if len(wakes) != 1 || len(sleeps) != 1 || sleeps[0] != "52:54:00:00:00:01" {
+		t.Errorf("Expected one wake and one sleep, got %v and %v", wakes, sleeps)
+	}
+}
+
+func TestEtherTypeFilter(t *testing.T) {
+	filter := etherTypeFilter([]uint16{etherTypeWoL, etherTypeARP, 0x88b5})
+	// ldh, 3 jeq, accept, drop
+	if len(filter) != 6 {
+		t.Fatalf("Unexpected filter length %d", len(filter))
+	}
+	for i, want := range [][2]uint8{{2, 0}, {1, 0}, {0, 1}} {
+		jeq := filter[1+i]
+		if jeq.Jt != want[0] || jeq.Jf != want[1] {
+			t.Errorf("jeq %d: expected jt=%d jf=%d, got jt=%d jf=%d", i, want[0], want[1], jeq.Jt, jeq.Jf)
+		}
+	}
+}
+
+func TestVMStarter_StopActions(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	running := &kubevirtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "tenant"}}
+	running.Status.Phase = kubevirtv1.Running
+	paused := &kubevirtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{Name: "paused", Namespace: "tenant"}}
+	paused.Status.Phase = kubevirtv1.Running
+	paused.Status.Conditions = []kubevirtv1.VirtualMachineInstanceCondition{
+		{Type: kubevirtv1.VirtualMachineInstancePaused, Status: "True"},
+	}
+	c := newPolicyClient(t, running, paused)
+
+	starter := NewVMStarter(c, logr.Discard())
+	starter.EnableImpersonation(&rest.Config{Host: server.URL}, c.Scheme())
+	for _, call := range []func() error{
+		func() error { return starter.PauseVMAs(context.Background(), "", "tenant", "running") },
+		func() error { return starter.PauseVMAs(context.Background(), "", "tenant", "paused") },
+		func() error { return starter.StopVMAs(context.Background(), "", "tenant", "running") },
+		func() error { return starter.StopVMAs(context.Background(), "", "tenant", "stopped") },
+	} {
+		if err := call(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	want := []string{
+		"PUT /apis/subresources.kubevirt.io/v1/namespaces/tenant/virtualmachineinstances/running/pause",
+		"PUT /apis/subresources.kubevirt.io/v1/namespaces/tenant/virtualmachines/running/stop",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected %v, got %v", want, calls)
+	}
+}
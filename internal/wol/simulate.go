@@ -0,0 +1,201 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// SimulateWake runs a synthetic magic packet for a MAC through the decisions
+// of ReportWOLEvent (lookup, Forward and sleep mappings, SecureOn, rate limit,
+// WolPolicy, VM state) in dry-run: nothing is started, forwarded or recorded
+// (dedupe, rate limit buckets, policy history, Events, audit, metrics).
+// The node verification and the dedupe of the agents' copies are skipped.
+func (a *Aggregator) SimulateWake(ctx context.Context, req *wolv1.SimulateWakeRequest) (*wolv1.SimulateWakeResponse, error) {
+	key, ok := parseMACKey(req.MacAddress)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid MAC address %q", req.MacAddress)
+	}
+	mac := key.String()
+	a.log.V(1).Info("Simulating WOL event", "mac", mac, "node", req.NodeName, "source", req.Source)
+
+	resp := &wolv1.SimulateWakeResponse{}
+	step := func(format string, args ...any) {
+		resp.Steps = append(resp.Steps, fmt.Sprintf(format, args...))
+	}
+	outcome := func(status wolv1.ResponseStatus, format string, args ...any) (*wolv1.SimulateWakeResponse, error) {
+		resp.Status = status
+		resp.Message = fmt.Sprintf(format, args...)
+		return resp, nil
+	}
+
+	vmInfo, found := a.mapper.Lookup(mac)
+	sleep := false
+	if found {
+		step("MAC %s matched VM %s/%s", mac, vmInfo.Namespace, vmInfo.Name)
+	} else if vmInfo, found = a.mapper.LookupSleep(mac); found {
+		sleep = true
+		step("MAC %s is the reversed MAC of VM %s/%s: a sleep packet", mac, vmInfo.Namespace, vmInfo.Name)
+	} else if vmInfo, found = a.mapper.LookupPoolMember(mac); found {
+		step("MAC %s belongs to VM %s/%s, removed from VirtualMachinePool %s by a scale-down",
+			mac, vmInfo.Namespace, vmInfo.Name, vmInfo.Pool)
+	}
+	if !found {
+		if target, ok := a.mapper.LookupForward(mac); ok {
+			resp.WolConfig = target.ConfigName
+			port := target.Target.Port
+			if port == 0 {
+				port = DefaultWOLPort
+			}
+			destination := forwardDestination(&wolv1.ForwardRequest{
+				Address: target.Target.Address, Port: uint32(port), Interface: target.Target.Interface})
+			sender := "manager"
+			if target.Target.NodeName != "" {
+				sender = "agent of node " + target.Target.NodeName
+			}
+			step("MAC %s has a Forward mapping in WolConfig %s", mac, target.ConfigName)
+			if a.mapper.DryRun(target.ConfigName) {
+				step("WolConfig %s is in dry-run: a real packet would not be forwarded", target.ConfigName)
+			}
+			return outcome(wolv1.ResponseStatus_FORWARDED, "Magic packet for %s would be forwarded to %s by the %s",
+				mac, destination, sender)
+		}
+		step("No VM, sleep, pool member or Forward mapping for MAC %s", mac)
+		return outcome(wolv1.ResponseStatus_VM_NOT_FOUND, "No VM configured for MAC %s", mac)
+	}
+
+	resp.VmInfo = &wolv1.VMInfo{Name: vmInfo.Name, Namespace: vmInfo.Namespace}
+	resp.WolConfig = vmInfo.ConfigName
+	resp.MappingType = string(vmInfo.MappingType)
+	step("Mapping from WolConfig %s (%s)", vmInfo.ConfigName, vmInfo.MappingType)
+	if a.mapper.DryRun(vmInfo.ConfigName) {
+		step("WolConfig %s is in dry-run: a real packet would be answered DRY_RUN, without acting on the VM", vmInfo.ConfigName)
+	}
+	if vmInfo.StartAs != "" {
+		step("The VM would be acted on as %s", vmInfo.StartAs)
+	}
+
+	if policy := vmInfo.SecureOnPolicy; policy != "" && policy != wolv1beta1.SecureOnPolicyIgnore {
+		result := checkSecureOnPassword(a.mapper.SecureOnPasswordFor(vmInfo), req.SecureOnPassword)
+		step("SecureOn policy %s: password %s", policy, result)
+		switch {
+		case result == secureOnOK || policy == wolv1beta1.SecureOnPolicyAudit:
+		case result == secureOnMissing:
+			return outcome(wolv1.ResponseStatus_SECURE_ON_PASSWORD_MISSING,
+				"VM %s/%s requires a SecureOn password", vmInfo.Namespace, vmInfo.Name)
+		default:
+			return outcome(wolv1.ResponseStatus_SECURE_ON_PASSWORD_INVALID,
+				"Wrong SecureOn password for VM %s/%s", vmInfo.Namespace, vmInfo.Name)
+		}
+	}
+
+	if spec := a.mapper.RateLimit(vmInfo.ConfigName); spec != nil {
+		if scope := a.rateLimiter.peek(vmInfo.ConfigName, spec, mac, req.NodeName); scope != "" {
+			return outcome(wolv1.ResponseStatus_RATE_LIMITED, "Rate limit of WolConfig %s exceeded for this %s",
+				vmInfo.ConfigName, scope)
+		}
+		step("Within the rate limit of WolConfig %s", vmInfo.ConfigName)
+	}
+
+	vm := resp.VmInfo
+	if reader, ok := a.vmStarter.(VMStateReader); ok {
+		state, node, err := reader.VMState(ctx, vmInfo.Namespace, vmInfo.Name)
+		if err != nil {
+			step("Cannot read the VM state: %v", err)
+		}
+		vm.CurrentState, vm.NodeName = state, node
+	}
+
+	if sleep {
+		spec := a.mapper.ShutdownOnLAN(vmInfo.ConfigName)
+		if spec == nil {
+			return outcome(wolv1.ResponseStatus_POLICY_REJECTED, "Shutdown-on-LAN is not enabled for WolConfig %s",
+				vmInfo.ConfigName)
+		}
+		action := spec.Action
+		if action == "" {
+			action = wolv1beta1.ShutdownActionStop
+		}
+		resp.Action = string(action)
+		return outcome(wolv1.ResponseStatus_VM_STOP_INITIATED, "VM %s/%s (%s) would be %s (Shutdown-on-LAN of WolConfig %s)",
+			vmInfo.Namespace, vmInfo.Name, stateOrUnknown(vm.CurrentState), simulatedActionVerb(string(action)), vmInfo.ConfigName)
+	}
+
+	action := wolv1beta1.WolPolicyActionStart
+	if a.policies != nil {
+		decision, err := a.policies.preview(ctx, vmInfo.Namespace, vmInfo.Name)
+		if err != nil {
+			return outcome(wolv1.ResponseStatus_ERROR, "Failed to evaluate WolPolicy: %v", err)
+		}
+		if decision.Policy == "" {
+			step("No WolPolicy selects the VM")
+		} else {
+			step("WolPolicy %s: %s", decision.Policy, decision.result)
+		}
+		if !decision.Allowed() {
+			return outcome(wolv1.ResponseStatus_POLICY_REJECTED, "Wake of VM %s/%s would be rejected by WolPolicy %s (%s)",
+				vmInfo.Namespace, vmInfo.Name, decision.Policy, decision.result)
+		}
+		action = decision.Action
+	}
+	if vmInfo.MappingType == MappingTypePoolMember {
+		action = wolv1beta1.WolPolicyActionStart
+	}
+	resp.Action = string(action)
+
+	if companions := a.mapper.LookupCompanions(mac); len(companions) > 0 {
+		names := make([]string, len(companions))
+		for i, companion := range companions {
+			names[i] = companion.Namespace + "/" + companion.Name
+		}
+		step("The other VMs claiming the MAC would be started too (StartAll): %s", strings.Join(names, ", "))
+	}
+	if vmInfo.WakeWith != "" {
+		step("Its dependencies would be started after it (wake-with): %s", vmInfo.WakeWith)
+	}
+
+	if vm.CurrentState == VMStateRunning {
+		return outcome(wolv1.ResponseStatus_VM_ALREADY_RUNNING, "VM %s/%s already running on node %s: nothing to do",
+			vmInfo.Namespace, vmInfo.Name, vm.NodeName)
+	}
+	return outcome(wolv1.ResponseStatus_VM_START_INITIATED, "VM %s/%s (%s) would be woken with action %s",
+		vmInfo.Namespace, vmInfo.Name, stateOrUnknown(vm.CurrentState), action)
+}
+
+// stateOrUnknown returns a VM state for the simulation messages
+func stateOrUnknown(state string) string {
+	if state == "" {
+		return "state unknown"
+	}
+	return state
+}
+
+// simulatedActionVerb returns the past participle of a Shutdown-on-LAN action
+func simulatedActionVerb(action string) string {
+	if action == string(wolv1beta1.ShutdownActionPause) {
+		return "paused"
+	}
+	return "stopped"
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestAggregator_SimulateWake(t *testing.T) {
+	ctx := context.Background()
+	c := newPolicyClient(t, newTestPolicy("limited", wolv1beta1.WolPolicySpec{
+		RateLimit: &wolv1beta1.WakeRateLimit{MaxWakes: 1, PeriodSeconds: 3600},
+	}))
+	mapper := NewMACMapper(c, logr.Discard())
+	vm1, _ := parseMACKey("52:54:00:00:00:01")
+	vm2, _ := parseMACKey("52:54:00:00:00:02")
+	mapper.mapping.Set(vm1, VMInfo{
+		Name: "vm1", Namespace: "tenant", ConfigName: "lab", MappingType: MappingTypeExplicit})
+	mapper.mapping.Set(vm2, VMInfo{
+		Name: "vm2", Namespace: "other", ConfigName: "lab", MappingType: MappingTypeExplicit,
+		SecureOnPolicy: wolv1beta1.SecureOnPolicyRequire})
+	mapper.secureOnPasswords = map[string]string{"lab": "01:23:45:67:89:ab"}
+	starter := &policyStarter{actions: make(map[string]string)}
+	agg := NewAggregator(mapper, starter, logr.Discard())
+	agg.SetPolicyEvaluator(NewPolicyEvaluator(c, logr.Discard()))
+
+	simulate := func(mac, password string) *wolv1.SimulateWakeResponse {
+		t.Helper()
+		resp, err := agg.SimulateWake(ctx, &wolv1.SimulateWakeRequest{MacAddress: mac, SecureOnPassword: password})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return resp
+	}
+
+	if _, err := agg.SimulateWake(ctx, &wolv1.SimulateWakeRequest{MacAddress: "nope"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected an invalid MAC to be rejected, got %v", err)
+	}
+	if resp := simulate("aa:bb:cc:dd:ee:ff", ""); resp.Status != wolv1.ResponseStatus_VM_NOT_FOUND {
+		t.Errorf("Expected VM_NOT_FOUND for an unknown MAC, got %v", resp.Status)
+	}
+	if resp := simulate("52:54:00:00:00:02", ""); resp.Status != wolv1.ResponseStatus_SECURE_ON_PASSWORD_MISSING {
+		t.Errorf("Expected the SecureOn policy to reject the packet, got %v", resp.Status)
+	}
+	if resp := simulate("52:54:00:00:00:02", "01:23:45:67:89:ab"); resp.Status != wolv1.ResponseStatus_VM_START_INITIATED {
+		t.Errorf("Expected the right password to start the VM, got %v: %s", resp.Status, resp.Message)
+	}
+
+	// La simulazione non consuma il rate limit della WolPolicy né avvia la VM
+	for range 2 {
+		resp := simulate("52-54-00-00-00-01", "")
+		if resp.Status != wolv1.ResponseStatus_VM_START_INITIATED || resp.VmInfo.Name != "vm1" ||
+			resp.WolConfig != "lab" || resp.Action != string(wolv1beta1.WolPolicyActionStart) {
+			t.Fatalf("Expected vm1 to be woken, got %+v", resp)
+		}
+		if !strings.Contains(strings.Join(resp.Steps, "\n"), "WolPolicy limited: allowed") {
+			t.Errorf("Expected the WolPolicy decision in the steps, got %q", resp.Steps)
+		}
+	}
+	if len(starter.actions) != 0 {
+		t.Fatalf("Expected no VM to be started, got %v", starter.actions)
+	}
+
+	// Dopo un wake reale la WolPolicy rifiuterebbe il successivo
+	if resp, err := agg.ReportWOLEvent(ctx, &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01", NodeName: "node-a"}); err != nil ||
+		resp.Status != wolv1.ResponseStatus_VM_START_INITIATED {
+		t.Fatalf("Expected the real wake to start vm1, got %v, %v", resp, err)
+	}
+	if resp := simulate("52:54:00:00:00:01", ""); resp.Status != wolv1.ResponseStatus_POLICY_REJECTED {
+		t.Errorf("Expected the WolPolicy rate limit to reject the next wake, got %v", resp.Status)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// The start limiter bounds the VM starts of the manager as a whole, across
+// the WolConfigs: a broadcast of many MACs (malicious or buggy) cannot start
+// enough VMs at once to overload the KubeVirt API or the cluster. A start over
+// the limits waits in line up to the queue timeout, then is answered
+// RATE_LIMITED (and retried later, if queued for a retry).
+
+const (
+	// DefaultMaxConcurrentStarts is the default number of VM starts in progress at the same time
+	DefaultMaxConcurrentStarts = 20
+	// DefaultStartQueueTimeout is the default time a start waits for the limits
+	DefaultStartQueueTimeout = 10 * time.Second
+)
+
+// Limits counted by wol_starts_throttled_total
+const (
+	startLimitConcurrency = "concurrency"
+	startLimitRate        = "rate"
+)
+
+// errStartsThrottled is the error of the starts rejected by the start limiter
+var errStartsThrottled = errors.New("too many VM starts")
+
+// StartLimitOptions configures the global limits of the VM starts
+type StartLimitOptions struct {
+	// MaxConcurrentStarts is the number of VM starts in progress at the same time (0 = unlimited)
+	MaxConcurrentStarts int
+	// StartsPerMinute is the rate of the VM starts (0 = unlimited), with bursts
+	// of up to MaxConcurrentStarts starts
+	StartsPerMinute int
+	// QueueTimeout is how long a start over the limits waits before being
+	// rejected (0 = rejected at once)
+	QueueTimeout time.Duration
+}
+
+// Validate checks the start limit options
+func (o StartLimitOptions) Validate() error {
+	if o.MaxConcurrentStarts < 0 || o.StartsPerMinute < 0 {
+		return fmt.Errorf("the start limits must not be negative, got %d concurrent starts and %d starts per minute",
+			o.MaxConcurrentStarts, o.StartsPerMinute)
+	}
+	if o.QueueTimeout < 0 {
+		return fmt.Errorf("the start queue timeout must not be negative, got %s", o.QueueTimeout)
+	}
+	return nil
+}
+
+// startLimiter enforces the StartLimitOptions
+type startLimiter struct {
+	slots   chan struct{} // nil senza limite di concorrenza
+	rate    *rate.Limiter // nil senza limite di rate
+	timeout time.Duration
+}
+
+// SetStartLimit bounds the VM starts of the aggregator. Must be called before
+// the gRPC server starts.
+func (a *Aggregator) SetStartLimit(opts StartLimitOptions) {
+	if opts.MaxConcurrentStarts == 0 && opts.StartsPerMinute == 0 {
+		a.startLimit = nil
+		return
+	}
+	l := &startLimiter{timeout: opts.QueueTimeout}
+	if opts.MaxConcurrentStarts > 0 {
+		l.slots = make(chan struct{}, opts.MaxConcurrentStarts)
+	}
+	if opts.StartsPerMinute > 0 {
+		burst := max(1, min(opts.MaxConcurrentStarts, opts.StartsPerMinute))
+		l.rate = rate.NewLimiter(rate.Limit(float64(opts.StartsPerMinute)/60), burst)
+	}
+	a.startLimit = l
+}
+
+// acquire waits for a start slot and token, up to the queue timeout. It
+// returns the function releasing the slot, or an error wrapping
+// errStartsThrottled with the limit that was hit.
+func (l *startLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	// Uno slot o un token liberi si prendono anche con timeout zero, col
+	// contesto già scaduto: la select li sceglierebbe a caso
+	release := func() {}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			select {
+			case l.slots <- struct{}{}:
+			case <-ctx.Done():
+				StartsThrottledTotal.WithLabelValues(startLimitConcurrency).Inc()
+				return nil, fmt.Errorf("%w: %d already in progress", errStartsThrottled, cap(l.slots))
+			}
+		}
+		release = func() { <-l.slots }
+	}
+	if l.rate != nil && !l.rate.Allow() {
+		// Wait fallisce subito se il token arriverebbe dopo la scadenza
+		if err := l.rate.Wait(ctx); err != nil {
+			release()
+			StartsThrottledTotal.WithLabelValues(startLimitRate).Inc()
+			return nil, fmt.Errorf("%w: over %g per minute", errStartsThrottled, float64(l.rate.Limit())*60)
+		}
+	}
+	return release, nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// blockingStarter blocks the starts until unblocked
+type blockingStarter struct {
+	policyStarter
+	started chan string
+	unblock chan struct{}
+}
+
+func (s *blockingStarter) StartVMAs(_ context.Context, _, _, name string) error {
+	s.started <- name
+	<-s.unblock
+	return s.record(name, "start")
+}
+
+func TestStartLimitOptions_Validate(t *testing.T) {
+	if err := (StartLimitOptions{MaxConcurrentStarts: 20, QueueTimeout: time.Second}).Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	for _, invalid := range []StartLimitOptions{{MaxConcurrentStarts: -1}, {StartsPerMinute: -1}, {QueueTimeout: -time.Second}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", invalid)
+		}
+	}
+}
+
+func TestAggregator_StartLimitConcurrency(t *testing.T) {
+	ctx := context.Background()
+	starter := &blockingStarter{policyStarter: policyStarter{actions: make(map[string]string)},
+		started: make(chan string, 1), unblock: make(chan struct{})}
+	agg := NewAggregator(NewMACMapper(newPolicyClient(t), logr.Discard()), starter, logr.Discard())
+	agg.SetStartLimit(StartLimitOptions{MaxConcurrentStarts: 1, QueueTimeout: 200 * time.Millisecond})
+	vm := func(name string) VMInfo { return VMInfo{Name: name, Namespace: "default", ConfigName: "lab"} }
+
+	done := make(chan error)
+	go func() { done <- agg.startVM(ctx, vm("first"), wolv1beta1.WolPolicyActionStart) }()
+	<-starter.started
+
+	// Il secondo start aspetta lo slot fino al timeout, poi è rifiutato
+	err := agg.startVM(ctx, vm("second"), wolv1beta1.WolPolicyActionStart)
+	if !errors.Is(err, errStartsThrottled) {
+		t.Fatalf("Expected the second start to be throttled, got %v", err)
+	}
+	if status, _ := agg.startFailure(ctx, vm("second"), wolv1beta1.WolPolicyActionStart, err); status != wolv1.ResponseStatus_RATE_LIMITED {
+		t.Errorf("Expected a throttled start to be RATE_LIMITED, got %v", status)
+	}
+
+	// Uno start in coda parte appena lo slot si libera
+	go func() { done <- agg.startVM(ctx, vm("third"), wolv1beta1.WolPolicyActionStart) }()
+	close(starter.unblock)
+	for range 2 {
+		if err := <-done; err != nil {
+			t.Fatalf("Expected the queued start to go on, got %v", err)
+		}
+	}
+	if len(starter.actions) != 2 || starter.actions["second"] != "" {
+		t.Errorf("Expected the first and third VMs to be started, got %v", starter.actions)
+	}
+}
+
+func TestAggregator_StartLimitRate(t *testing.T) {
+	ctx := context.Background()
+	starter := &policyStarter{actions: make(map[string]string)}
+	agg := NewAggregator(NewMACMapper(newPolicyClient(t), logr.Discard()), starter, logr.Discard())
+	agg.SetStartLimit(StartLimitOptions{StartsPerMinute: 1, QueueTimeout: 100 * time.Millisecond})
+
+	if err := agg.startVM(ctx, VMInfo{Name: "first", Namespace: "default"}, wolv1beta1.WolPolicyActionStart); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Il prossimo token arriva tra un minuto, oltre il timeout: rifiutato subito
+	start := time.Now()
+	err := agg.startVM(ctx, VMInfo{Name: "second", Namespace: "default"}, wolv1beta1.WolPolicyActionStart)
+	if !errors.Is(err, errStartsThrottled) || time.Since(start) > 50*time.Millisecond {
+		t.Errorf("Expected the second start to be throttled at once, got %v after %s", err, time.Since(start))
+	}
+	if !retryableStartError(err) {
+		t.Error("Expected a throttled retry to be retried later")
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	toolscache "k8s.io/client-go/tools/cache"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// Interfaces without a MAC in the VM spec get one assigned when the VM boots,
+// reported only in the VMI status. The mapper remembers these MACs by interface
+// name, so a VM is still wakeable with the MAC of its last boot once stopped.
+// The MACs are kept in memory: after a manager restart they are learned again
+// on the next boot of the VM.
+
+// vmiInterfaceMACs returns the MACs reported in the VMI status for the
+// interfaces of the VM spec (interface name -> MAC)
+func vmiInterfaceMACs(vmi *kubevirtv1.VirtualMachineInstance) map[string]macKey {
+	macs := make(map[string]macKey)
+	for _, iface := range vmi.Status.Interfaces {
+		if iface.Name == "" {
+			continue // interfacce viste solo dal guest agent
+		}
+		if key, ok := parseMACKey(iface.MAC); ok {
+			macs[iface.Name] = key
+		}
+	}
+	return macs
+}
+
+// learnVMIMACs records the MACs of the running VMIs, if a config discovers
+// VMs or maps them by selector. m.refreshMu must be held.
+func (m *MACMapper) learnVMIMACs(ctx context.Context, configs []wolv1beta1.WolConfig) {
+	if !slices.ContainsFunc(configs, func(config wolv1beta1.WolConfig) bool {
+		return config.Spec.DiscoveryMode != wolv1beta1.DiscoveryModeExplicit || hasSelectorMappings(&config)
+	}) {
+		return
+	}
+	vmiList := &kubevirtv1.VirtualMachineInstanceList{}
+	if err := m.client.List(ctx, vmiList); err != nil {
+		m.log.Error(err, "Failed to list VMIs, auto-assigned MACs will use the last known ones")
+		ErrorsTotal.Inc()
+		return
+	}
+	for i := range vmiList.Items {
+		m.learnStatusMACs(&vmiList.Items[i])
+	}
+}
+
+// learnStatusMACs records the MACs reported by a VMI and returns true if they
+// changed. A VMI that reports no MAC (yet) keeps the ones of the last boot.
+// m.refreshMu must be held.
+func (m *MACMapper) learnStatusMACs(vmi *kubevirtv1.VirtualMachineInstance) bool {
+	macs := vmiInterfaceMACs(vmi)
+	if len(macs) == 0 {
+		return false
+	}
+	key := vmIndexKey(vmi.Namespace, vmi.Name)
+	if maps.Equal(m.statusMACs[key], macs) {
+		return false
+	}
+	if m.statusMACs == nil {
+		m.statusMACs = make(map[string]map[string]macKey)
+	}
+	m.statusMACs[key] = macs
+	return true
+}
+
+// statusMAC returns the MAC learned from the VMI status for an interface of a
+// VM without a MAC in its spec. m.refreshMu must be held.
+func (m *MACMapper) statusMAC(vm *kubevirtv1.VirtualMachine, iface string) (macKey, bool) {
+	key, ok := m.statusMACs[vmIndexKey(vm.Namespace, vm.Name)][iface]
+	return key, ok
+}
+
+// forgetStatusMACs drops the learned MACs of the VMs that are not mapped
+// anymore (deleted, or no longer selected by a config). m.refreshMu must be held.
+func (m *MACMapper) forgetStatusMACs(vms map[string]VMInfo) {
+	for key := range m.statusMACs {
+		if _, managed := vms[key]; !managed {
+			delete(m.statusMACs, key)
+		}
+	}
+}
+
+// ApplyVMI maps the MACs a VMI reports for the interfaces of its VM that have
+// no MAC in the spec, so VMs with auto-assigned MACs are wakeable after their
+// first boot without waiting for RefreshMapping
+func (m *MACMapper) ApplyVMI(ctx context.Context, vmi *kubevirtv1.VirtualMachineInstance) {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+
+	if !m.learnStatusMACs(vmi) {
+		return
+	}
+	vm := &kubevirtv1.VirtualMachine{}
+	if err := m.client.Get(ctx, client.ObjectKey{Namespace: vmi.Namespace, Name: vmi.Name}, vm); err != nil {
+		if !apierrors.IsNotFound(err) {
+			m.log.Error(err, "Failed to get the VM of a VMI", "vm", vmi.Name, "namespace", vmi.Namespace)
+			ErrorsTotal.Inc()
+		}
+		return // VMI senza VM: il refresh dimentica i suoi MAC
+	}
+	m.applyVM(ctx, vm)
+}
+
+// vmiEventHandler applies the MACs reported by the VMIs of the informer.
+// Deleted VMIs are ignored: their VM keeps the MACs of the last boot.
+func (m *MACMapper) vmiEventHandler(ctx context.Context) toolscache.ResourceEventHandlerFuncs {
+	return toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			if vmi, ok := obj.(*kubevirtv1.VirtualMachineInstance); ok {
+				m.ApplyVMI(ctx, vmi)
+			}
+		},
+		UpdateFunc: func(_, obj any) {
+			if vmi, ok := obj.(*kubevirtv1.VirtualMachineInstance); ok {
+				m.ApplyVMI(ctx, vmi)
+			}
+		},
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+// newStatusVMI returns a running VMI of the tenant namespace reporting the
+// given MACs (interface name -> MAC)
+func newStatusVMI(name string, macs map[string]string) *kubevirtv1.VirtualMachineInstance {
+	vmi := &kubevirtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "tenant"}}
+	for iface, mac := range macs {
+		vmi.Status.Interfaces = append(vmi.Status.Interfaces, kubevirtv1.VirtualMachineInstanceNetworkInterface{
+			Name: iface, MAC: mac,
+		})
+	}
+	return vmi
+}
+
+func TestMACMapper_StatusMACsOnRefresh(t *testing.T) {
+	auto := newWatchVM("auto", nil, "") // MAC assegnato al boot
+	fixed := newWatchVM("fixed", nil, "52:54:00:00:00:01")
+	vmi := newStatusVMI("auto", map[string]string{"default": "02:00:00:00:00:0a", "": "02:00:00:00:00:0b"})
+	// La VMI della VM con MAC nello spec non cambia nulla
+	fixedVMI := newStatusVMI("fixed", map[string]string{"default": "02:00:00:00:00:0c"})
+	mapper := newWatchMapper(t, auto, fixed, vmi, fixedVMI)
+
+	if info, found := mapper.Lookup("02:00:00:00:00:0a"); !found || info.Name != "auto" {
+		t.Errorf("Expected the status MAC to map to the auto VM, got %+v (found=%v)", info, found)
+	}
+	for _, mac := range []string{"02:00:00:00:00:0b", "02:00:00:00:00:0c"} {
+		if _, found := mapper.Lookup(mac); found {
+			t.Errorf("Expected %s not to be mapped", mac)
+		}
+	}
+	if mapper.GetMappingCount() != 2 {
+		t.Errorf("Expected 2 MACs, got %d", mapper.GetMappingCount())
+	}
+
+	// La VM spenta resta svegliabile con il MAC dell'ultimo boot
+	if err := mapper.client.Delete(context.Background(), vmi); err != nil {
+		t.Fatal(err)
+	}
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, found := mapper.Lookup("02:00:00:00:00:0a"); !found {
+		t.Error("Expected the MAC of the last boot to stay mapped")
+	}
+
+	// Dimenticato con la VM
+	mapper.RemoveVM(context.Background(), "tenant", "auto")
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, found := mapper.Lookup("02:00:00:00:00:0a"); found {
+		t.Error("Expected the MAC of a removed VM to be forgotten")
+	}
+}
+
+func TestMACMapper_ApplyVMI(t *testing.T) {
+	auto := newWatchVM("auto", nil, "")
+	mapper := newWatchMapper(t, auto)
+	ctx := context.Background()
+	if mapper.GetMappingCount() != 0 {
+		t.Fatalf("Expected no MAC before the first boot, got %d", mapper.GetMappingCount())
+	}
+
+	mapper.ApplyVMI(ctx, newStatusVMI("auto", nil)) // non ancora schedulata
+	if mapper.GetMappingCount() != 0 {
+		t.Errorf("Expected no MAC for a VMI without status, got %d", mapper.GetMappingCount())
+	}
+	mapper.ApplyVMI(ctx, newStatusVMI("auto", map[string]string{"default": "02:00:00:00:00:0a"}))
+	if info, found := mapper.Lookup("02:00:00:00:00:0a"); !found || info.Name != "auto" || info.ConfigName != "all" {
+		t.Errorf("Expected the status MAC to be mapped by config all, got %+v (found=%v)", info, found)
+	}
+
+	// Un nuovo boot con un altro MAC sostituisce il precedente
+	mapper.ApplyVMI(ctx, newStatusVMI("auto", map[string]string{"default": "02:00:00:00:00:0b"}))
+	if _, found := mapper.Lookup("02:00:00:00:00:0a"); found {
+		t.Error("Expected the MAC of the previous boot to be removed")
+	}
+	if _, found := mapper.Lookup("02:00:00:00:00:0b"); !found {
+		t.Error("Expected the MAC of the new boot to be mapped")
+	}
+
+	// Una VMI senza VM non è mappata
+	mapper.ApplyVMI(ctx, newStatusVMI("orphan", map[string]string{"default": "02:00:00:00:00:0c"}))
+	if _, found := mapper.Lookup("02:00:00:00:00:0c"); found {
+		t.Error("Expected a VMI without VM not to be mapped")
+	}
+}
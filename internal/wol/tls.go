@@ -0,0 +1,130 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+)
+
+// ClientTLSFiles are the files used by the agents and activators to
+// authenticate to the operator gRPC server, and to verify it
+type ClientTLSFiles struct {
+	// CAFile verifies the server certificate (system CAs if empty)
+	CAFile string
+	// CertFile and KeyFile are the client certificate, reloaded on change
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name verified in the server certificate
+	// (the host of the operator address by default)
+	ServerName string
+}
+
+// Enabled returns true if the connection to the operator uses TLS
+func (f ClientTLSFiles) Enabled() bool {
+	return f.CAFile != "" || f.CertFile != "" || f.KeyFile != ""
+}
+
+// BindClientTLSFlags registers the flags of the TLS connection to the operator on fs
+func BindClientTLSFlags(fs *flag.FlagSet, files *ClientTLSFiles) {
+	fs.StringVar(&files.CAFile, "operator-ca", "",
+		"CA bundle verifying the operator gRPC server; enables TLS (system CAs if only the client certificate is set)")
+	fs.StringVar(&files.CertFile, "client-cert", "",
+		"Client certificate presented to the operator (mutual TLS), reloaded on change")
+	fs.StringVar(&files.KeyFile, "client-key", "", "Key of the client certificate")
+	fs.StringVar(&files.ServerName, "operator-server-name", "",
+		"Name verified in the operator certificate (defaults to the host of the operator address)")
+}
+
+// NewClientTLSConfig returns the TLS config of the connection to the operator.
+// The client certificate is watched until ctx is done, so rotations (e.g. by
+// cert-manager) are picked up by the next handshake.
+func NewClientTLSConfig(ctx context.Context, files ClientTLSFiles) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: files.ServerName}
+
+	if files.CAFile != "" {
+		pool, err := loadCertPool(files.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if (files.CertFile == "") != (files.KeyFile == "") {
+		return nil, errors.New("the client certificate and key must be set together")
+	}
+	if files.CertFile != "" {
+		watcher, err := certwatcher.New(files.CertFile, files.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+		go func() { _ = watcher.Start(ctx) }()
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return watcher.GetCertificate(nil)
+		}
+	}
+	return config, nil
+}
+
+// NewServerMTLSConfig returns the TLS config of the gRPC server for the
+// agents: getCertificate serves the server certificate and clients must
+// present a certificate signed by a CA in caFile. caFile is read again on
+// every handshake, so a rotated CA is trusted without a restart.
+func NewServerMTLSConfig(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error),
+	caFile string) (*tls.Config, error) {
+	// Fallisce subito se la CA non è leggibile, invece che al primo agente
+	if _, err := loadCertPool(caFile); err != nil {
+		return nil, err
+	}
+
+	base := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+	}
+	config := base.Clone()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		handshake := base.Clone()
+		handshake.ClientCAs = pool
+		return handshake, nil
+	}
+	return config, nil
+}
+
+// loadCertPool reads the PEM certificates of file
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", file)
+	}
+	return pool, nil
+}
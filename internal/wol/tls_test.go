@@ -0,0 +1,178 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// testCA signs the certificates of the mutual TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a certificate signed by the CA and its key to dir, returning their paths
+func (ca *testCA) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	writeTestFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeTestFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certFile, keyFile
+}
+
+func writeTestFile(t *testing.T, file string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	writeTestFile(t, caFile, ca.pem)
+	serverCert, serverKey := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "agent", x509.ExtKeyUsageClientAuth)
+
+	cert, err := tls.LoadX509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverTLS, err := NewServerMTLSConfig(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return &cert, nil
+	}, caFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverTLS)))
+	wolv1.RegisterWOLServiceServer(server, &recordingService{})
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	report := func(files ClientTLSFiles) error {
+		config, err := NewClientTLSConfig(ctx, files)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(config)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = conn.Close() }()
+		rpcCtx, rpcCancel := context.WithTimeout(ctx, 5*time.Second)
+		defer rpcCancel()
+		_, err = wolv1.NewWOLServiceClient(conn).ReportListeners(rpcCtx, &wolv1.ListenerReport{})
+		return err
+	}
+
+	if err := report(ClientTLSFiles{CAFile: caFile, CertFile: clientCert, KeyFile: clientKey}); err != nil {
+		t.Errorf("Expected the client certificate to be accepted, got %v", err)
+	}
+	if err := report(ClientTLSFiles{CAFile: caFile}); err == nil {
+		t.Error("Expected a client without certificate to be rejected")
+	}
+
+	otherCert, otherKey := newTestCA(t).issue(t, dir, "spoofer", x509.ExtKeyUsageClientAuth)
+	if err := report(ClientTLSFiles{CAFile: caFile, CertFile: otherCert, KeyFile: otherKey}); err == nil {
+		t.Error("Expected a certificate signed by another CA to be rejected")
+	}
+}
+
+func TestNewClientTLSConfig_Validation(t *testing.T) {
+	if (ClientTLSFiles{ServerName: "operator"}).Enabled() {
+		t.Error("Expected a server name alone not to enable TLS")
+	}
+	if _, err := NewClientTLSConfig(context.Background(), ClientTLSFiles{CertFile: "tls.crt"}); err == nil {
+		t.Error("Expected a certificate without key to be rejected")
+	}
+	if _, err := NewClientTLSConfig(context.Background(), ClientTLSFiles{CAFile: "/nonexistent/ca.crt"}); err == nil {
+		t.Error("Expected a missing CA file to be rejected")
+	}
+}
@@ -0,0 +1,337 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// The agents and activators can authenticate to the gRPC server with a
+// projected ServiceAccount token bound to their pod, sent as a bearer token on
+// every RPC. The manager validates it with a TokenReview for its audience:
+// pods in the namespace of the manager are agents, bound to the node of their
+// pod; the others (activators) may only request the wake of the VMs of their
+// own namespace.
+
+// DefaultTokenAudience is the audience of the ServiceAccount tokens of the agents
+const DefaultTokenAudience = "kubevirt-wol"
+
+const (
+	// tokenCacheTTL is how long a reviewed token is trusted without a new TokenReview
+	tokenCacheTTL = time.Minute
+	// tokenCacheMaxEntries triggers the removal of the expired entries
+	tokenCacheMaxEntries = 1024
+
+	serviceAccountUserPrefix = "system:serviceaccount:"
+	extraPodName             = "authentication.kubernetes.io/pod-name"
+	extraPodUID              = "authentication.kubernetes.io/pod-uid"
+	extraNodeName            = "authentication.kubernetes.io/node-name"
+)
+
+// activatorMethods are the RPCs allowed to the pods outside the namespace of the manager
+var activatorMethods = map[string]bool{
+	wolv1.WOLService_RequestWake_FullMethodName: true,
+	wolv1.WOLService_HealthCheck_FullMethodName: true,
+	wolv1.WOLService_GetVersion_FullMethodName:  true,
+}
+
+var errTokenNotBound = errors.New("the token is not bound to a pod")
+
+// BindTokenFileFlag registers the flag of the ServiceAccount token sent to the operator
+func BindTokenFileFlag(fs *flag.FlagSet, path *string) {
+	fs.StringVar(path, "token-file", "",
+		"Projected ServiceAccount token sent to the operator gRPC server on every RPC, read again at every RPC "+
+			"so the rotations by the kubelet are picked up")
+}
+
+// tokenFileCredentials sends the token of a file as a bearer token
+type tokenFileCredentials struct {
+	path string
+}
+
+// TokenFileCredentials returns the dial option sending the token of path on every RPC
+func TokenFileCredentials(path string) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(tokenFileCredentials{path: path})
+}
+
+func (c tokenFileCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	token, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the ServiceAccount token: %w", err)
+	}
+	return map[string]string{"authorization": "Bearer " + strings.TrimSpace(string(token))}, nil
+}
+
+// RequireTransportSecurity is false: the token is bound to an audience and to
+// a pod, and expires, so it can also be sent to a plaintext server
+func (tokenFileCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+var _ credentials.PerRPCCredentials = tokenFileCredentials{}
+
+// TokenIdentity is the pod authenticated by a ServiceAccount token
+type TokenIdentity struct {
+	Namespace      string
+	ServiceAccount string
+	Pod            string
+	Node           string
+}
+
+// agent returns true if the pod runs in the namespace of the agents
+func (i TokenIdentity) agent(agentNamespace string) bool {
+	return i.Namespace == agentNamespace
+}
+
+type tokenIdentityContextKey struct{}
+
+// tokenIdentityFromContext returns the pod authenticated by the token of a request
+func tokenIdentityFromContext(ctx context.Context) (TokenIdentity, bool) {
+	identity, ok := ctx.Value(tokenIdentityContextKey{}).(TokenIdentity)
+	return identity, ok
+}
+
+type tokenCacheEntry struct {
+	identity TokenIdentity
+	expires  time.Time
+}
+
+// TokenAuthenticator authenticates the RPCs of the gRPC server by the
+// ServiceAccount tokens of the callers
+type TokenAuthenticator struct {
+	client         client.Client // TokenReview
+	pods           client.Reader // nodo del pod, se il token non lo riporta
+	audience       string
+	agentNamespace string
+	log            logr.Logger
+
+	mu    sync.Mutex
+	cache map[relayTokenHash]tokenCacheEntry
+}
+
+// NewTokenAuthenticator creates the authenticator of the tokens with audience.
+// The pods of agentNamespace (the namespace of the manager) are agents.
+func NewTokenAuthenticator(c client.Client, pods client.Reader, audience, agentNamespace string, log logr.Logger) *TokenAuthenticator {
+	return &TokenAuthenticator{
+		client:         c,
+		pods:           pods,
+		audience:       audience,
+		agentNamespace: agentNamespace,
+		log:            log,
+		cache:          make(map[relayTokenHash]tokenCacheEntry),
+	}
+}
+
+// review returns the pod of a token, from the cache or with a TokenReview
+func (t *TokenAuthenticator) review(ctx context.Context, token string) (TokenIdentity, error) {
+	hash := relayTokenHash(sha256.Sum256([]byte(token)))
+	now := time.Now()
+	t.mu.Lock()
+	entry, ok := t.cache[hash]
+	t.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.identity, nil
+	}
+
+	review := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{
+		Token:     token,
+		Audiences: []string{t.audience},
+	}}
+	if err := t.client.Create(ctx, review); err != nil {
+		return TokenIdentity{}, fmt.Errorf("token review failed: %w", err)
+	}
+	if !review.Status.Authenticated {
+		return TokenIdentity{}, errUnauthenticated
+	}
+	if !slices.Contains(review.Status.Audiences, t.audience) {
+		return TokenIdentity{}, fmt.Errorf("the token is not valid for the audience %s", t.audience)
+	}
+	identity, err := t.identity(ctx, review.Status.User)
+	if err != nil {
+		return TokenIdentity{}, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.cache) >= tokenCacheMaxEntries {
+		for key, entry := range t.cache {
+			if now.After(entry.expires) {
+				delete(t.cache, key)
+			}
+		}
+	}
+	t.cache[hash] = tokenCacheEntry{identity: identity, expires: now.Add(tokenCacheTTL)}
+	return identity, nil
+}
+
+// identity returns the pod of the user of a bound ServiceAccount token. The
+// node is the one of the token (Kubernetes 1.30+) or of the pod.
+func (t *TokenAuthenticator) identity(ctx context.Context, user authenticationv1.UserInfo) (TokenIdentity, error) {
+	serviceAccount, ok := strings.CutPrefix(user.Username, serviceAccountUserPrefix)
+	namespace, name, found := strings.Cut(serviceAccount, ":")
+	if !ok || !found {
+		return TokenIdentity{}, fmt.Errorf("%s is not a ServiceAccount", user.Username)
+	}
+	extra := func(key string) string {
+		if values := user.Extra[key]; len(values) == 1 {
+			return values[0]
+		}
+		return ""
+	}
+	identity := TokenIdentity{Namespace: namespace, ServiceAccount: name, Pod: extra(extraPodName), Node: extra(extraNodeName)}
+	if identity.Pod == "" {
+		return TokenIdentity{}, errTokenNotBound
+	}
+	if identity.Node == "" {
+		pod := &corev1.Pod{}
+		if err := t.pods.Get(ctx, client.ObjectKey{Namespace: namespace, Name: identity.Pod}, pod); err != nil {
+			return TokenIdentity{}, fmt.Errorf("failed to get the pod of the token: %w", err)
+		}
+		// Un pod ricreato con lo stesso nome non eredita i token del precedente
+		if uid := extra(extraPodUID); uid != "" && uid != string(pod.UID) {
+			return TokenIdentity{}, errTokenNotBound
+		}
+		identity.Node = pod.Spec.NodeName
+	}
+	return identity, nil
+}
+
+// authenticate checks the token of a request and the method it calls
+func (t *TokenAuthenticator) authenticate(ctx context.Context, method string) (TokenIdentity, error) {
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			token, _ = strings.CutPrefix(values[0], "Bearer ")
+		}
+	}
+	if token == "" {
+		GRPCTokenAuthTotal.WithLabelValues("unauthenticated").Inc()
+		t.log.Info("Rejected gRPC request without a ServiceAccount token", "peer", addr, "method", method)
+		return TokenIdentity{}, status.Error(codes.Unauthenticated, "missing ServiceAccount token")
+	}
+	identity, err := t.review(ctx, token)
+	if err != nil {
+		GRPCTokenAuthTotal.WithLabelValues("unauthenticated").Inc()
+		t.log.Info("Rejected gRPC request with an invalid ServiceAccount token", "peer", addr, "method", method,
+			"error", err.Error())
+		return TokenIdentity{}, status.Error(codes.Unauthenticated, "invalid ServiceAccount token")
+	}
+	if !identity.agent(t.agentNamespace) && !activatorMethods[method] {
+		GRPCTokenAuthTotal.WithLabelValues("denied").Inc()
+		t.log.Info("Rejected gRPC request for a method reserved to the agents", "namespace", identity.Namespace,
+			"serviceAccount", identity.ServiceAccount, "pod", identity.Pod, "method", method)
+		return TokenIdentity{}, status.Error(codes.PermissionDenied, "method reserved to the agents")
+	}
+	GRPCTokenAuthTotal.WithLabelValues("ok").Inc()
+	return identity, nil
+}
+
+// authorize binds a message to the identity of its sender: an agent reports
+// only for its own node, an activator wakes only the VMs of its namespace
+func (t *TokenAuthenticator) authorize(identity TokenIdentity, msg any) error {
+	var err error
+	switch req := msg.(type) {
+	case *wolv1.SendWOLRequest:
+		// NodeName è il nodo che invia il pacchetto, non il chiamante
+	case *wolv1.WakeRequest:
+		if !identity.agent(t.agentNamespace) && req.Namespace != identity.Namespace {
+			err = status.Errorf(codes.PermissionDenied, "pods of namespace %s can only wake the VMs of their namespace",
+				identity.Namespace)
+		}
+	case interface{ GetNodeName() string }:
+		if node := req.GetNodeName(); node != identity.Node {
+			err = status.Errorf(codes.PermissionDenied, "the token is bound to node %s, not %s", identity.Node, node)
+		}
+	}
+	if err != nil {
+		GRPCTokenAuthTotal.WithLabelValues("denied").Inc()
+		t.log.Info("Rejected gRPC message not bound to its sender", "namespace", identity.Namespace,
+			"pod", identity.Pod, "node", identity.Node, "error", err.Error())
+	}
+	return err
+}
+
+// UnaryInterceptor authenticates the unary RPCs
+func (t *TokenAuthenticator) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	identity, err := t.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.authorize(identity, req); err != nil {
+		return nil, err
+	}
+	return handler(context.WithValue(ctx, tokenIdentityContextKey{}, identity), req)
+}
+
+// StreamInterceptor authenticates the streaming RPCs, and every message they receive
+func (t *TokenAuthenticator) StreamInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	identity, err := t.authenticate(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &tokenServerStream{
+		ServerStream: stream,
+		ctx:          context.WithValue(stream.Context(), tokenIdentityContextKey{}, identity),
+		auth:         t,
+		identity:     identity,
+	})
+}
+
+// tokenServerStream checks the messages received on a stream
+type tokenServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	auth     *TokenAuthenticator
+	identity TokenIdentity
+}
+
+func (s *tokenServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *tokenServerStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.auth.authorize(s.identity, m)
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// newTokenReviewClient answers the TokenReviews with the users of tokens,
+// counting the reviews
+func newTokenReviewClient(users map[string]authenticationv1.UserInfo, audiences []string, reviews *int,
+	objs ...client.Object) client.Client {
+	return fake.NewClientBuilder().WithObjects(objs...).WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			review, ok := obj.(*authenticationv1.TokenReview)
+			if !ok {
+				return c.Create(ctx, obj, opts...)
+			}
+			*reviews++
+			if user, ok := users[review.Spec.Token]; ok {
+				review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: user, Audiences: audiences}
+			}
+			return nil
+		},
+	}).Build()
+}
+
+func serviceAccountUser(namespace, name, pod, uid, node string) authenticationv1.UserInfo {
+	extra := map[string]authenticationv1.ExtraValue{}
+	if pod != "" {
+		extra[extraPodName] = authenticationv1.ExtraValue{pod}
+	}
+	if uid != "" {
+		extra[extraPodUID] = authenticationv1.ExtraValue{uid}
+	}
+	if node != "" {
+		extra[extraNodeName] = authenticationv1.ExtraValue{node}
+	}
+	return authenticationv1.UserInfo{Username: serviceAccountUserPrefix + namespace + ":" + name, Extra: extra}
+}
+
+func tokenContext(token string) context.Context {
+	if token == "" {
+		return context.Background()
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent-xyz", Namespace: "kubevirt-wol", UID: "uid-1"},
+		Spec:       corev1.PodSpec{NodeName: "node-2"},
+	}
+	var reviews int
+	c := newTokenReviewClient(map[string]authenticationv1.UserInfo{
+		"agent":     serviceAccountUser("kubevirt-wol", "agent", "agent-abc", "", "node-1"),
+		"old-agent": serviceAccountUser("kubevirt-wol", "agent", "agent-xyz", "uid-1", ""),
+		"recreated": serviceAccountUser("kubevirt-wol", "agent", "agent-xyz", "uid-0", ""),
+		"activator": serviceAccountUser("team-a", "activator", "activator-abc", "", "node-3"),
+		"unbound":   serviceAccountUser("kubevirt-wol", "agent", "", "", ""),
+	}, []string{DefaultTokenAudience}, &reviews, pod)
+	auth := NewTokenAuthenticator(c, c, DefaultTokenAudience, "kubevirt-wol", logr.Discard())
+	handler := func(context.Context, any) (any, error) { return nil, nil }
+	report := &grpc.UnaryServerInfo{FullMethod: wolv1.WOLService_ReportWOLEvent_FullMethodName}
+	wake := &grpc.UnaryServerInfo{FullMethod: wolv1.WOLService_RequestWake_FullMethodName}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		info  *grpc.UnaryServerInfo
+		req   any
+		code  codes.Code
+	}{
+		{"agent of its node", "agent", report, &wolv1.WOLEvent{NodeName: "node-1"}, codes.OK},
+		{"agent of another node", "agent", report, &wolv1.WOLEvent{NodeName: "node-2"}, codes.PermissionDenied},
+		{"node of the pod", "old-agent", report, &wolv1.WOLEvent{NodeName: "node-2"}, codes.OK},
+		{"recreated pod", "recreated", report, &wolv1.WOLEvent{NodeName: "node-2"}, codes.Unauthenticated},
+		{"agent waking any namespace", "agent", wake, &wolv1.WakeRequest{Namespace: "team-b"}, codes.OK},
+		{"activator of its namespace", "activator", wake, &wolv1.WakeRequest{Namespace: "team-a"}, codes.OK},
+		{"activator of another namespace", "activator", wake, &wolv1.WakeRequest{Namespace: "team-b"},
+			codes.PermissionDenied},
+		{"activator reporting events", "activator", report, &wolv1.WOLEvent{NodeName: "node-3"}, codes.PermissionDenied},
+		{"missing token", "", report, &wolv1.WOLEvent{NodeName: "node-1"}, codes.Unauthenticated},
+		{"unknown token", "forged", report, &wolv1.WOLEvent{NodeName: "node-1"}, codes.Unauthenticated},
+		{"token not bound to a pod", "unbound", report, &wolv1.WOLEvent{NodeName: "node-1"}, codes.Unauthenticated},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := auth.UnaryInterceptor(tokenContext(tc.token), tc.req, tc.info, handler)
+			if got := status.Code(err); got != tc.code {
+				t.Errorf("Expected %s, got %s (%v)", tc.code, got, err)
+			}
+		})
+	}
+
+	// I token già verificati non richiedono un'altra TokenReview
+	reviews = 0
+	if _, err := auth.UnaryInterceptor(tokenContext("agent"), &wolv1.WOLEvent{NodeName: "node-1"}, report,
+		handler); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if reviews != 0 {
+		t.Errorf("Expected the cached identity to be used, got %d reviews", reviews)
+	}
+}
+
+func TestTokenAuthenticator_Audience(t *testing.T) {
+	var reviews int
+	c := newTokenReviewClient(map[string]authenticationv1.UserInfo{
+		"agent": serviceAccountUser("kubevirt-wol", "agent", "agent-abc", "", "node-1"),
+	}, []string{"https://kubernetes.default.svc"}, &reviews)
+	auth := NewTokenAuthenticator(c, c, DefaultTokenAudience, "kubevirt-wol", logr.Discard())
+
+	_, err := auth.UnaryInterceptor(tokenContext("agent"), &wolv1.WOLEvent{NodeName: "node-1"},
+		&grpc.UnaryServerInfo{FullMethod: wolv1.WOLService_ReportWOLEvent_FullMethodName},
+		func(context.Context, any) (any, error) { return nil, nil })
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected a token of another audience to be rejected, got %v", err)
+	}
+}
+
+func TestTokenFileCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	creds := tokenFileCredentials{path: path}
+	if _, err := creds.GetRequestMetadata(context.Background()); err == nil {
+		t.Error("Expected an error without the token file")
+	}
+	for _, token := range []string{"first", "rotated"} {
+		if err := os.WriteFile(path, []byte(token+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		md, err := creds.GetRequestMetadata(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if md["authorization"] != "Bearer "+token {
+			t.Errorf("Expected the token of the file, got %q", md["authorization"])
+		}
+	}
+}
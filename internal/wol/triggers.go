@@ -0,0 +1,287 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// Besides magic packets, server NICs can wake on patterns of ordinary traffic
+// addressed to them (wake-on-unicast). The agents do the same for the stopped
+// VMs: the raw listeners capture pings, connection attempts and DNS queries
+// for them and request their wake, with the targets synced for ARP wake.
+
+// Sources reported to the operator by the wakes of the triggers
+const (
+	ICMPWakeSource = "icmp"
+	TCPWakeSource  = "tcp"
+	DNSWakeSource  = "dns"
+)
+
+const (
+	icmpEchoRequest = 8
+
+	tcpFlagSYN = 0x02
+	tcpFlagACK = 0x10
+
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsTypeANY  = 255
+)
+
+// dnsPorts are the UDP ports of the DNS and mDNS queries
+var dnsPorts = []uint16{53, 5353}
+
+// WakeTriggers are the packets, besides magic packets, the raw listeners
+// capture to wake the stopped VMs (WolConfig wakeTriggers)
+type WakeTriggers struct {
+	ICMPEcho bool
+	TCPPorts []uint16
+	DNS      bool
+}
+
+// Enabled returns true if at least one trigger is on
+func (t WakeTriggers) Enabled() bool {
+	return t.ICMPEcho || len(t.TCPPorts) > 0 || t.DNS
+}
+
+// WakeTriggersEnabled returns true if spec turns on at least one trigger
+func WakeTriggersEnabled(spec *wolv1beta1.WakeTriggersSpec) bool {
+	return spec != nil && (spec.ICMPEcho || len(spec.TCPPorts) > 0 || spec.DNS)
+}
+
+// TriggerPacket is a packet, other than a magic packet, that wakes the
+// stopped VM it is addressed to
+type TriggerPacket struct {
+	Kind        string // ICMPWakeSource, TCPWakeSource o DNSWakeSource
+	Source      net.IP
+	Destination net.IP
+	Port        uint16 // porta di destinazione (TCP, DNS)
+	Name        string // nome richiesto, in minuscolo e senza punto finale (DNS)
+}
+
+// ---------------------------------------------------------------------------
+// Raw listener side: parsing the triggers
+// ---------------------------------------------------------------------------
+
+// SetTriggerHandler enables the capture of the IPv4 packets of triggers,
+// passed to handler. Must be called before Start, since it changes the BPF filter.
+func (r *RawListener) SetTriggerHandler(triggers WakeTriggers, handler func(pkt TriggerPacket)) {
+	r.triggers = triggers
+	r.triggerHandler = handler
+}
+
+// parseTriggerPacket parses the payload of an Ethernet/IPv4 frame carrying one
+// of triggers: an ICMP echo request, a TCP SYN (without ACK) to one of the
+// ports, or a DNS address query. Fragments are ignored.
+func parseTriggerPacket(payload []byte, triggers WakeTriggers) (TriggerPacket, bool) {
+	if len(payload) < 20 || payload[0]>>4 != 4 {
+		return TriggerPacket{}, false
+	}
+	headerLen := int(payload[0]&0x0f) * 4
+	totalLen := int(binary.BigEndian.Uint16(payload[2:4]))
+	if headerLen < 20 || totalLen < headerLen || totalLen > len(payload) ||
+		binary.BigEndian.Uint16(payload[6:8])&0x3fff != 0 {
+		return TriggerPacket{}, false
+	}
+	pkt := TriggerPacket{
+		Source:      net.IP(append([]byte{}, payload[12:16]...)),
+		Destination: net.IP(append([]byte{}, payload[16:20]...)),
+	}
+	// Il frame può avere padding Ethernet oltre la lunghezza IP
+	data := payload[headerLen:totalLen]
+
+	switch payload[9] {
+	case unix.IPPROTO_ICMP:
+		if !triggers.ICMPEcho || len(data) < 8 || data[0] != icmpEchoRequest || data[1] != 0 {
+			return TriggerPacket{}, false
+		}
+		pkt.Kind = ICMPWakeSource
+	case unix.IPPROTO_TCP:
+		if len(data) < 20 || data[13]&(tcpFlagSYN|tcpFlagACK) != tcpFlagSYN {
+			return TriggerPacket{}, false
+		}
+		pkt.Port = binary.BigEndian.Uint16(data[2:4])
+		if !slices.Contains(triggers.TCPPorts, pkt.Port) {
+			return TriggerPacket{}, false
+		}
+		pkt.Kind = TCPWakeSource
+	case unix.IPPROTO_UDP:
+		if !triggers.DNS || len(data) < 8 {
+			return TriggerPacket{}, false
+		}
+		pkt.Port = binary.BigEndian.Uint16(data[2:4])
+		if !slices.Contains(dnsPorts, pkt.Port) {
+			return TriggerPacket{}, false
+		}
+		name, ok := parseDNSQuery(data[8:])
+		if !ok {
+			return TriggerPacket{}, false
+		}
+		pkt.Kind, pkt.Name = DNSWakeSource, name
+	default:
+		return TriggerPacket{}, false
+	}
+	return pkt, true
+}
+
+// parseDNSQuery returns the name of the first question of a DNS query for an
+// address (A, AAAA or ANY). Responses, other opcodes and compressed names,
+// which queries do not use, are ignored.
+func parseDNSQuery(msg []byte) (string, bool) {
+	// Header: id, flag (QR e opcode nel primo byte), qdcount
+	if len(msg) < 12 || msg[2]&0xf8 != 0 || binary.BigEndian.Uint16(msg[4:6]) == 0 {
+		return "", false
+	}
+	var labels []string
+	offset := 12
+	for {
+		if offset >= len(msg) {
+			return "", false
+		}
+		length := int(msg[offset])
+		offset++
+		if length == 0 {
+			break
+		}
+		if length > 63 || offset+length > len(msg) {
+			return "", false
+		}
+		labels = append(labels, strings.ToLower(string(msg[offset:offset+length])))
+		offset += length
+	}
+	if len(labels) == 0 || offset+4 > len(msg) {
+		return "", false
+	}
+	switch binary.BigEndian.Uint16(msg[offset : offset+2]) {
+	case dnsTypeA, dnsTypeAAAA, dnsTypeANY:
+		return strings.Join(labels, "."), true
+	}
+	return "", false
+}
+
+// ---------------------------------------------------------------------------
+// Agent side: waking the targets
+// ---------------------------------------------------------------------------
+
+// SetWakeTriggers enables wakes triggered by pings, TCP SYNs to tcpPorts and
+// DNS queries for the stopped VMs (requires the raw listener). The operator
+// tells, per VM, which of them apply.
+func (a *Agent) SetWakeTriggers(icmpEcho bool, tcpPorts []int, dns bool) {
+	a.wakeTriggers = WakeTriggers{ICMPEcho: icmpEcho, TCPPorts: uniquePorts(tcpPorts), DNS: dns}
+}
+
+// triggerTarget returns the stopped VM a trigger packet wakes, nil if none
+func (a *Agent) triggerTarget(pkt TriggerPacket) *wolv1.ARPTarget {
+	a.arpTargetsMu.RLock()
+	defer a.arpTargetsMu.RUnlock()
+
+	switch pkt.Kind {
+	case ICMPWakeSource:
+		if target := a.arpTargets[pkt.Destination.String()]; target != nil && target.IcmpEcho {
+			return target
+		}
+	case TCPWakeSource:
+		if target := a.arpTargets[pkt.Destination.String()]; target != nil &&
+			slices.Contains(target.TcpPorts, uint32(pkt.Port)) {
+			return target
+		}
+	case DNSWakeSource:
+		return dnsTarget(a.arpTargets, pkt.Name)
+	}
+	return nil
+}
+
+// dnsTarget returns the target of the VM a DNS name asks for: <vm>,
+// <vm>.local or <vm>.<namespace>[.<domain>]. A name matching VMs of several
+// namespaces wakes none of them.
+func dnsTarget(targets map[string]*wolv1.ARPTarget, name string) *wolv1.ARPTarget {
+	labels := strings.Split(name, ".")
+	qualified := len(labels) > 1 && labels[1] != "local"
+
+	var match *wolv1.ARPTarget
+	for _, target := range targets {
+		if !target.Dns || target.Name != labels[0] || (qualified && target.Namespace != labels[1]) {
+			continue
+		}
+		if match != nil && match.Namespace != target.Namespace {
+			return nil
+		}
+		match = target
+	}
+	return match
+}
+
+// handleTrigger requests the wake of the stopped VM a trigger packet is for.
+// Clients repeat pings and SYNs: the local dedupe sends one request per window.
+func (a *Agent) handleTrigger(pkt TriggerPacket, iface string) {
+	target := a.triggerTarget(pkt)
+	if target == nil {
+		return
+	}
+	if !a.shouldProcess("trigger/"+target.Namespace+"/"+target.Name, "") {
+		return
+	}
+
+	var what string
+	switch pkt.Kind {
+	case ICMPWakeSource:
+		what = "icmp echo to " + pkt.Destination.String()
+	case TCPWakeSource:
+		what = fmt.Sprintf("tcp syn to %s:%d", pkt.Destination, pkt.Port)
+	case DNSWakeSource:
+		what = fmt.Sprintf("dns query for %s to %s:%d", pkt.Name, pkt.Destination, pkt.Port)
+	}
+	reason := fmt.Sprintf("%s from %s on %s/%s", what, pkt.Source, a.nodeName, iface)
+	a.metrics.triggerWakes.WithLabelValues(iface, pkt.Kind).Inc()
+	a.packetLog.Info(a.log, "Wake trigger for stopped VM, requesting wake",
+		"trigger", pkt.Kind, "vm", target.Name, "namespace", target.Namespace, "from", a.packetLog.Source(pkt.Source.String()))
+
+	a.report(func(reportCtx context.Context) {
+		wakeCtx, cancel := context.WithTimeout(reportCtx, 5*time.Second)
+		defer cancel()
+
+		resp, err := a.grpcClient.RequestWake(wakeCtx, &wolv1.WakeRequest{
+			Namespace: target.Namespace,
+			Name:      target.Name,
+			Source:    pkt.Kind,
+			Reason:    reason,
+		})
+		if err != nil {
+			a.log.Error(err, "Failed to request trigger wake", "vm", target.Name, "namespace", target.Namespace)
+			ErrorsTotal.Inc()
+			return
+		}
+		a.log.Info("Trigger wake reported to operator",
+			"trigger", pkt.Kind,
+			"vm", target.Name,
+			"namespace", target.Namespace,
+			"status", resp.Status.String(),
+			"message", resp.Message)
+	})
+}
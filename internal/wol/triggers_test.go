@@ -0,0 +1,251 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/net/bpf"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// ipv4Frame builds an Ethernet/IPv4 frame from 192.168.1.10 to 10.0.0.42
+// carrying data with the given protocol
+func ipv4Frame(protocol byte, data []byte) []byte {
+	ip := []byte{0x45, 0}
+	ip = binary.BigEndian.AppendUint16(ip, uint16(20+len(data)))
+	ip = append(ip, 0, 0, 0x40, 0, 64, protocol, 0, 0, 192, 168, 1, 10, 10, 0, 0, 42)
+
+	frame := []byte{0x52, 0x54, 0, 0, 0, 0x01, 0x02, 0, 0, 0, 0, 1}
+	frame = binary.BigEndian.AppendUint16(frame, etherTypeIPv4)
+	return append(append(frame, ip...), data...)
+}
+
+// tcpSegment builds a TCP header to port with the given flags
+func tcpSegment(port uint16, flags byte) []byte {
+	tcp := binary.BigEndian.AppendUint16(nil, 40000)
+	tcp = binary.BigEndian.AppendUint16(tcp, port)
+	tcp = append(tcp, 0, 0, 0, 1, 0, 0, 0, 0, 0x50, flags, 0xff, 0xff, 0, 0, 0, 0)
+	return tcp
+}
+
+// dnsQuery builds a DNS message with one question for name
+func dnsQuery(name string, qtype uint16, response bool) []byte {
+	msg := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	if response {
+		msg[2] |= 0x80
+	}
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	return binary.BigEndian.AppendUint16(msg, 1)
+}
+
+func TestParseTriggerPacket(t *testing.T) {
+	mac := []byte{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	all := WakeTriggers{ICMPEcho: true, TCPPorts: []uint16{22, 3389}, DNS: true}
+	fragment := ipv4Frame(1, []byte{icmpEchoRequest, 0, 0, 0, 0, 0, 0, 0})
+	fragment[14+6] = 0x20 // MF
+
+	tests := []struct {
+		name     string
+		frame    []byte
+		triggers WakeTriggers
+		kind     string
+		port     uint16
+		dnsName  string
+	}{
+		{"ping", ipv4Frame(1, []byte{icmpEchoRequest, 0, 0, 0, 0, 0, 0, 0}), all, ICMPWakeSource, 0, ""},
+		{"ping disabled", ipv4Frame(1, []byte{icmpEchoRequest, 0, 0, 0, 0, 0, 0, 0}), WakeTriggers{DNS: true}, "", 0, ""},
+		{"echo reply", ipv4Frame(1, []byte{0, 0, 0, 0, 0, 0, 0, 0}), all, "", 0, ""},
+		{"fragment", fragment, all, "", 0, ""},
+		{"SYN to 22", ipv4Frame(6, tcpSegment(22, tcpFlagSYN)), all, TCPWakeSource, 22, ""},
+		{"SYN-ACK from 22", ipv4Frame(6, tcpSegment(22, tcpFlagSYN|tcpFlagACK)), all, "", 0, ""},
+		{"SYN to 80", ipv4Frame(6, tcpSegment(80, tcpFlagSYN)), all, "", 0, ""},
+		{"DNS query", udpFrame(mac, 53, dnsQuery("VM1.default.svc.cluster.local", dnsTypeA, false)), all,
+			DNSWakeSource, 53, "vm1.default.svc.cluster.local"},
+		{"mDNS query", udpFrame(mac, 5353, dnsQuery("vm1.local", dnsTypeAAAA, false)), all, DNSWakeSource, 5353, "vm1.local"},
+		{"DNS response", udpFrame(mac, 53, dnsQuery("vm1", dnsTypeA, true)), all, "", 0, ""},
+		{"DNS PTR query", udpFrame(mac, 5353, dnsQuery("_ssh._tcp.local", 12, false)), all, "", 0, ""},
+		{"DNS to other port", udpFrame(mac, 9, dnsQuery("vm1", dnsTypeA, false)), all, "", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt, ok := parseTriggerPacket(tt.frame[14:], tt.triggers)
+			if ok != (tt.kind != "") {
+				t.Fatalf("Expected trigger %q, got %v (%+v)", tt.kind, ok, pkt)
+			}
+			if !ok {
+				return
+			}
+			if pkt.Kind != tt.kind || pkt.Port != tt.port || pkt.Name != tt.dnsName ||
+				pkt.Destination.String() != "10.0.0.42" || pkt.Source.String() != "192.168.1.10" {
+				t.Errorf("Unexpected trigger %+v", pkt)
+			}
+		})
+	}
+}
+
+func TestCaptureSpec_Triggers(t *testing.T) {
+	run := func(t *testing.T, filter []bpf.RawInstruction, frame []byte) bool {
+		t.Helper()
+		vm, err := bpf.NewVM(mustDisassemble(t, filter))
+		if err != nil {
+			t.Fatalf("Invalid filter: %v", err)
+		}
+		n, err := vm.Run(frame)
+		if err != nil {
+			t.Fatalf("Filter failed: %v", err)
+		}
+		return n > 0
+	}
+
+	mac := []byte{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	tests := []struct {
+		name     string
+		frame    []byte
+		triggers bool
+	}{
+		{"ping", ipv4Frame(1, []byte{icmpEchoRequest, 0, 0, 0, 0, 0, 0, 0}), true},
+		{"echo reply", ipv4Frame(1, []byte{0, 0, 0, 0, 0, 0, 0, 0}), false},
+		{"SYN to 22", ipv4Frame(6, tcpSegment(22, tcpFlagSYN)), true},
+		{"ACK to 22", ipv4Frame(6, tcpSegment(22, tcpFlagACK)), false},
+		{"SYN to 80", ipv4Frame(6, tcpSegment(80, tcpFlagSYN)), false},
+		{"UDP to port 9", udpFrame(mac, 9, buildMagicPacket(mac, nil)), true},
+		{"UDP to port 53", udpFrame(mac, 53, dnsQuery("vm1", dnsTypeA, false)), true},
+		{"UDP to port 7", udpFrame(mac, 7, buildMagicPacket(mac, nil)), false},
+		{"neighbor solicitation", neighborSolicitationFrame("fd00::5", "fd00::20", 255), true},
+	}
+	triggers := toRaw(captureSpec{
+		etherTypes:            []uint16{etherTypeWoL, etherTypeARP},
+		udpPorts:              []uint16{9, 53},
+		neighborSolicitations: true,
+		icmpEcho:              true,
+		tcpPorts:              []uint16{22, 3389},
+	}.filter())
+	plain := toRaw(captureFilter([]uint16{etherTypeWoL, etherTypeARP}, []uint16{9}, true))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := run(t, triggers, tt.frame); got != tt.triggers {
+				t.Errorf("Filter with wake triggers: expected %v, got %v", tt.triggers, got)
+			}
+			plainWant := tt.name == "UDP to port 9" || tt.name == "neighbor solicitation"
+			if got := run(t, plain, tt.frame); got != plainWant {
+				t.Errorf("Filter without wake triggers: expected %v, got %v", plainWant, got)
+			}
+		})
+	}
+}
+
+func TestAgent_TriggerTarget(t *testing.T) {
+	agent := NewAgent(9, "node-a", "operator:9090", logr.Discard())
+	agent.arpTargets = map[string]*wolv1.ARPTarget{
+		"10.0.0.42": {Ip: "10.0.0.42", Namespace: "default", Name: "vm1", IcmpEcho: true, TcpPorts: []uint32{22}, Dns: true},
+		"10.0.0.43": {Ip: "10.0.0.43", Namespace: "tenant", Name: "vm1", Dns: true},
+		"10.0.0.44": {Ip: "10.0.0.44", Namespace: "tenant", Name: "vm2", TcpPorts: []uint32{3389}},
+	}
+
+	tests := []struct {
+		name string
+		pkt  TriggerPacket
+		want string
+	}{
+		{"ping", TriggerPacket{Kind: ICMPWakeSource, Destination: net.ParseIP("10.0.0.42")}, "default/vm1"},
+		{"ping without ICMP trigger", TriggerPacket{Kind: ICMPWakeSource, Destination: net.ParseIP("10.0.0.44")}, ""},
+		{"SYN", TriggerPacket{Kind: TCPWakeSource, Destination: net.ParseIP("10.0.0.44"), Port: 3389}, "tenant/vm2"},
+		{"SYN to another port", TriggerPacket{Kind: TCPWakeSource, Destination: net.ParseIP("10.0.0.44"), Port: 22}, ""},
+		{"unknown IP", TriggerPacket{Kind: TCPWakeSource, Destination: net.ParseIP("10.0.0.50"), Port: 22}, ""},
+		{"qualified name", TriggerPacket{Kind: DNSWakeSource, Name: "vm1.tenant.svc.cluster.local"}, "tenant/vm1"},
+		// vm1 esiste in due namespace: il nome breve è ambiguo
+		{"ambiguous name", TriggerPacket{Kind: DNSWakeSource, Name: "vm1.local"}, ""},
+		{"name without DNS trigger", TriggerPacket{Kind: DNSWakeSource, Name: "vm2"}, ""},
+	}
+	for _, tt := range tests {
+		var got string
+		if target := agent.triggerTarget(tt.pkt); target != nil {
+			got = target.Namespace + "/" + target.Name
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestRefreshARPTargets_Triggers(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := kubevirtv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	vm := &kubevirtv1.VirtualMachine{}
+	vm.Name, vm.Namespace = "vm1", "default"
+	vm.Annotations = map[string]string{AnnotationIPAddresses: "10.0.0.20,fd00::20"}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(vm).Build()
+	mapper := NewMACMapper(c, logr.Discard())
+
+	triggers := &wolv1beta1.WakeTriggersSpec{ICMPEcho: true, TCPPorts: []int32{22}}
+	configs := []wolv1beta1.WolConfig{{Spec: wolv1beta1.WolConfigSpec{WakeTriggers: triggers}}}
+	configs[0].Name = "triggers"
+	vms := map[string]VMInfo{"default/vm1": {Name: "vm1", Namespace: "default", ConfigName: "triggers"}}
+
+	// Solo i trigger: target IPv4 che non si svegliano su ARP
+	targets := mapper.refreshARPTargets(context.Background(), configs, vms, newMACStore())
+	if len(targets) != 1 || targets[0].IP != "10.0.0.20" || !targets[0].TriggersOnly || targets[0].Triggers != triggers {
+		t.Fatalf("Expected a triggers-only IPv4 target, got %+v", targets)
+	}
+
+	// Con ARPWake lo stesso target sveglia anche su ARP
+	configs[0].Spec.ARPWake = &wolv1beta1.ARPWakeSpec{Enabled: true}
+	targets = mapper.refreshARPTargets(context.Background(), configs, vms, newMACStore())
+	if len(targets) != 1 || targets[0].TriggersOnly || targets[0].Triggers != triggers {
+		t.Errorf("Expected an ARP target with triggers, got %+v", targets)
+	}
+
+	// Trigger vuoti: nessun target
+	configs[0].Spec.ARPWake = nil
+	configs[0].Spec.WakeTriggers = &wolv1beta1.WakeTriggersSpec{}
+	if targets := mapper.refreshARPTargets(context.Background(), configs, vms, newMACStore()); len(targets) != 0 {
+		t.Errorf("Expected no targets without triggers, got %+v", targets)
+	}
+
+	// Gli agent ricevono i trigger e le porte con i target
+	configs[0].Spec.WakeTriggers = triggers
+	mapper.arpTargets = mapper.refreshARPTargets(context.Background(), configs, vms, newMACStore())
+	agg := NewAggregator(mapper, nil, logr.Discard())
+	resp, err := agg.GetARPTargets(context.Background(), &wolv1.ARPTargetsRequest{WolConfig: "triggers"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Targets) != 1 || !resp.Targets[0].TriggersOnly || !resp.Targets[0].IcmpEcho ||
+		resp.Targets[0].Dns || !slices.Equal(resp.Targets[0].TcpPorts, []uint32{22}) {
+		t.Errorf("Unexpected targets %+v", resp.Targets)
+	}
+}
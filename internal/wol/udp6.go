@@ -0,0 +1,154 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// ipv6AllNodes is the link-local all-nodes multicast group, the IPv6
+// replacement of the broadcast address used by WOL senders
+var ipv6AllNodes = net.ParseIP("ff02::1")
+
+// ParseIPFamilies parses a comma-separated list of IP families (IPv4, IPv6)
+func ParseIPFamilies(value string) (ipv4, ipv6 bool, err error) {
+	for _, family := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(family)) {
+		case "ipv4":
+			ipv4 = true
+		case "ipv6":
+			ipv6 = true
+		case "":
+		default:
+			return false, false, fmt.Errorf("invalid IP family %q (IPv4 or IPv6)", family)
+		}
+	}
+	if !ipv4 && !ipv6 {
+		return false, false, fmt.Errorf("no IP family in %q", value)
+	}
+	return ipv4, ipv6, nil
+}
+
+// openUDP6 opens the IPv6 UDP socket for WOL packets and joins ff02::1
+func (a *Agent) openUDP6() (*net.UDPConn, error) {
+	port := a.udpPort()
+	binding := &wolv1.ListenerBinding{Protocol: ListenerProtocolUDP6, Port: uint32(port)}
+	// "udp6" imposta IPV6_V6ONLY: non ruba i pacchetti IPv4 al socket udp4
+	conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6unspecified, Port: port})
+	a.setBindError(binding, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on IPv6 UDP port %d: %w", port, err)
+	}
+
+	if err := conn.SetReadBuffer(a.udpReadBuffer); err != nil {
+		a.log.Error(err, "Failed to set IPv6 read buffer size")
+	}
+	joined := a.joinAllNodes(conn)
+	a.log.Info("IPv6 UDP listener started", "port", port, "group", ipv6AllNodes.String(), "interfaces", joined)
+	return conn, nil
+}
+
+// joinAllNodes joins ff02::1 on every multicast interface allowed by the
+// interface rules. The kernel is usually already a member of the group, so
+// failures are only logged. Interfaces added later are joined when the
+// watchdog recreates the socket.
+func (a *Agent) joinAllNodes(conn *net.UDPConn) []string {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		a.log.Error(err, "Failed to list interfaces for the IPv6 multicast group")
+		return nil
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		a.log.Error(err, "Failed to access the IPv6 socket")
+		return nil
+	}
+
+	var joined []string
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if !a.agentInterfaceRules().Allows(iface.Name) {
+			continue
+		}
+		mreq := &unix.IPv6Mreq{Interface: uint32(iface.Index)}
+		copy(mreq.Multiaddr[:], ipv6AllNodes.To16())
+
+		var joinErr error
+		if err := raw.Control(func(fd uintptr) {
+			joinErr = unix.SetsockoptIPv6Mreq(int(fd), unix.IPPROTO_IPV6, unix.IPV6_JOIN_GROUP, mreq)
+		}); err != nil {
+			joinErr = err
+		}
+		if joinErr != nil {
+			a.log.V(1).Info("Failed to join the IPv6 all-nodes group", "iface", iface.Name, "error", joinErr.Error())
+			continue
+		}
+		joined = append(joined, iface.Name)
+	}
+	return joined
+}
+
+// listen6 è il loop del listener UDP IPv6
+func (a *Agent) listen6(ctx context.Context, conn *net.UDPConn) {
+	defer a.wg.Done()
+	a.log.Info("IPv6 UDP listener loop started, waiting for WOL packets...")
+	a.readUDP(ctx, conn, ListenerProtocolUDP6, &a.udp6Heartbeat, &a.udp6Errors)
+}
+
+// udp6Healthy returns true if the IPv6 UDP loop is alive and not failing on every read
+func (a *Agent) udp6Healthy(now time.Time) bool {
+	a.udpMu.Lock()
+	conn := a.conn6
+	a.udpMu.Unlock()
+	if conn == nil {
+		return false
+	}
+	return a.udp6Errors.Load() < maxListenerReadErrors &&
+		now.Sub(time.Unix(0, a.udp6Heartbeat.Load())) < watchdogStaleAfter
+}
+
+// restartUDP6 closes the IPv6 UDP socket (ending its loop) and starts a new one
+func (a *Agent) restartUDP6(ctx context.Context) error {
+	a.udpMu.Lock()
+	if a.conn6 != nil {
+		_ = a.conn6.Close()
+		a.conn6 = nil
+	}
+	conn, err := a.openUDP6()
+	if err != nil {
+		a.udpMu.Unlock()
+		return err
+	}
+	a.conn6 = conn
+	a.udp6Errors.Store(0)
+	a.udp6Heartbeat.Store(time.Now().UnixNano())
+	a.udpMu.Unlock()
+
+	a.wg.Add(1)
+	go a.listen6(ctx, conn)
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestParseIPFamilies(t *testing.T) {
+	for value, want := range map[string][2]bool{
+		"IPv4":       {true, false},
+		"IPv6":       {false, true},
+		"IPv4, ipv6": {true, true},
+	} {
+		ipv4, ipv6, err := ParseIPFamilies(value)
+		if err != nil || ipv4 != want[0] || ipv6 != want[1] {
+			t.Errorf("ParseIPFamilies(%q) = %v, %v, %v", value, ipv4, ipv6, err)
+		}
+	}
+	for _, invalid := range []string{"", "IPv5", ","} {
+		if _, _, err := ParseIPFamilies(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestAgent_IPv6Listener(t *testing.T) {
+	probe, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("IPv6 not available: %v", err)
+	}
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	_ = probe.Close()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &recordingService{}
+	server := grpc.NewServer()
+	wolv1.RegisterWOLServiceServer(server, service)
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agent := NewAgent(port, "test-node", lis.Addr().String(), logr.Discard())
+	agent.SetIPFamilies(false, true)
+	grpcConn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.grpcConn = newTrackedConn(grpcConn)
+	agent.grpcClient = wolv1.NewWOLServiceClient(agent.grpcConn)
+
+	conn, err := agent.openUDP6()
+	if err != nil {
+		t.Fatalf("Failed to open IPv6 UDP socket: %v", err)
+	}
+	agent.conn6 = conn
+	agent.wg.Add(1)
+	go agent.listen6(ctx, conn)
+	defer func() {
+		cancel()
+		agent.Stop()
+		agent.wg.Wait()
+	}()
+
+	bindings := agent.listenerBindings()
+	if len(bindings) != 1 || bindings[0].Protocol != ListenerProtocolUDP6 || !bindings[0].Bound {
+		t.Fatalf("Expected only the IPv6 listener to be reported, got %v", bindings)
+	}
+
+	sender, err := net.DialUDP("udp6", nil, &net.UDPAddr{IP: net.IPv6loopback, Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = sender.Close() }()
+	mac := []byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	if _, err := sender.Write(append(bytes.Repeat([]byte{0xff}, 6), bytes.Repeat(mac, 16)...)); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if events, _ := service.counts(); events > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the IPv6 magic packet to be reported")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	service.mu.Lock()
+	event := service.events[0]
+	service.mu.Unlock()
+	if event.MacAddress != "52:54:00:12:34:56" || event.SourceIp != "::1" {
+		t.Errorf("Unexpected event %v", event)
+	}
+	if !agent.udp6Healthy(time.Now()) {
+		t.Error("Expected the IPv6 listener to be healthy")
+	}
+}
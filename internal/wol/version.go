@@ -0,0 +1,199 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// Features announced by GetVersion. An agent skips the RPCs of the features
+// a manager does not announce; with a manager older than GetVersion it tries
+// them all.
+const (
+	FeatureEventStream    = "event-stream"
+	FeatureARPTargets     = "arp-targets"
+	FeatureInterfaceHints = "interface-hints"
+	FeatureListeners      = "listener-reports"
+	FeatureForwards       = "forwards"
+	FeatureHeartbeat      = "heartbeat"
+	FeatureAgentConfig    = "agent-config"
+	FeatureMACAllowlist   = "mac-allowlist"
+	FeatureSendWOL        = "send-wol"
+)
+
+// managerFeatures are the features of this manager
+var managerFeatures = []string{
+	FeatureEventStream,
+	FeatureARPTargets,
+	FeatureInterfaceHints,
+	FeatureListeners,
+	FeatureForwards,
+	FeatureHeartbeat,
+	FeatureAgentConfig,
+	FeatureMACAllowlist,
+	FeatureSendWOL,
+}
+
+// GitCommit returns the VCS revision the binary was built from, empty if unknown
+func GitCommit() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
+
+// parseMinorVersion returns the major and minor release of a version like
+// v1.2.3, 1.2 or v1.2.3-rc.1. ok is false for the others (e.g. dev builds).
+func parseMinorVersion(version string) (major, minor int, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	minorPart, _, _ := strings.Cut(parts[1], "-")
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	if minor, err = strconv.Atoi(minorPart); err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// VersionSkewed returns true if the agent version is more than a minor
+// release away from the manager version (or in another major release).
+// Versions that are not releases are never skewed.
+func VersionSkewed(managerVersion, agentVersion string) bool {
+	managerMajor, managerMinor, ok := parseMinorVersion(managerVersion)
+	if !ok {
+		return false
+	}
+	agentMajor, agentMinor, ok := parseMinorVersion(agentVersion)
+	if !ok {
+		return false
+	}
+	if managerMajor != agentMajor {
+		return true
+	}
+	return managerMinor-agentMinor > 1 || agentMinor-managerMinor > 1
+}
+
+// ---------------------------------------------------------------------------
+// Manager side: version and standard services
+// ---------------------------------------------------------------------------
+
+// GetVersion implementa il metodo gRPC con cui agent e operatori leggono la
+// build del manager e le funzionalità che supporta
+func (a *Aggregator) GetVersion(_ context.Context, req *wolv1.VersionRequest) (*wolv1.VersionResponse, error) {
+	if req.NodeName != "" {
+		a.log.V(1).Info("Agent version", "node", req.NodeName, "version", req.AgentVersion)
+	}
+	return &wolv1.VersionResponse{
+		Version:   Version,
+		GitCommit: GitCommit(),
+		GoVersion: runtime.Version(),
+		Features:  managerFeatures,
+	}, nil
+}
+
+// checkEventVersion logs, once per node and version, the events sent by an
+// agent more than a minor release away from the manager
+func (a *Aggregator) checkEventVersion(event *wolv1.WOLEvent) {
+	if event.AgentVersion == "" || !VersionSkewed(Version, event.AgentVersion) {
+		return
+	}
+	if _, logged := a.skewedVersions.LoadOrStore(event.NodeName+"/"+event.AgentVersion, true); !logged {
+		a.log.Info("Agent version is more than a minor release away from the manager, the agents should be updated",
+			"node", event.NodeName, "agentVersion", event.AgentVersion, "version", Version)
+	}
+}
+
+// RegisterStandardServices registers on server the standard gRPC health
+// service, serving the WOLService, and the reflection service used by grpcurl
+func RegisterStandardServices(server *grpc.Server) *health.Server {
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(wolv1.WOLService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	reflection.Register(server)
+	return healthServer
+}
+
+// ---------------------------------------------------------------------------
+// Agent side: feature negotiation
+// ---------------------------------------------------------------------------
+
+// fetchVersion reads the version and the features of the manager. With a
+// manager older than GetVersion the features stay unknown.
+func (a *Agent) fetchVersion(ctx context.Context) {
+	reqCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := a.grpcClient.GetVersion(reqCtx, &wolv1.VersionRequest{NodeName: a.nodeName, AgentVersion: Version})
+	if err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			a.log.Info("Operator does not report its version, assuming it supports every feature")
+		} else {
+			a.log.Error(err, "Failed to get the operator version, assuming it supports every feature")
+		}
+		return
+	}
+	features := make(map[string]bool, len(resp.Features))
+	for _, feature := range resp.Features {
+		features[feature] = true
+	}
+	a.managerFeatures = features
+	a.log.Info("Operator version", "version", resp.Version, "gitCommit", resp.GitCommit, "features", resp.Features)
+	a.logVersionSkew(resp.Version)
+}
+
+// logVersionSkew logs when the manager runs another version than the agent
+func (a *Agent) logVersionSkew(managerVersion string) {
+	switch {
+	case managerVersion == "" || managerVersion == Version:
+	case VersionSkewed(managerVersion, Version):
+		a.log.Info("Agent and operator versions are more than a minor release apart, the agents should be updated",
+			"agent", Version, "operator", managerVersion)
+	default:
+		a.log.Info("Agent and operator versions differ", "agent", Version, "operator", managerVersion)
+	}
+}
+
+// managerSupports returns false if the manager announced its features
+// without this one
+func (a *Agent) managerSupports(feature string) bool {
+	return a.managerFeatures == nil || a.managerFeatures[feature]
+}
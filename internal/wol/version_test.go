@@ -0,0 +1,109 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// serveVersionTest serves service with the standard services and returns a
+// client connection to it
+func serveVersionTest(t *testing.T, service wolv1.WOLServiceServer) *grpc.ClientConn {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	wolv1.RegisterWOLServiceServer(server, service)
+	RegisterStandardServices(server)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestAggregator_VersionAndHealth(t *testing.T) {
+	agg := NewAggregator(NewMACMapper(nil, logr.Discard()), NewVMStarter(nil, logr.Discard()), logr.Discard())
+	conn := serveVersionTest(t, agg)
+	ctx := context.Background()
+
+	health, err := healthpb.NewHealthClient(conn).Check(ctx,
+		&healthpb.HealthCheckRequest{Service: wolv1.WOLService_ServiceDesc.ServiceName})
+	if err != nil || health.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("Expected the WOLService to be SERVING, got %v %v", health, err)
+	}
+
+	resp, err := wolv1.NewWOLServiceClient(conn).HealthCheck(ctx, &wolv1.HealthCheckRequest{Service: "wol"})
+	if err != nil || resp.Version != Version {
+		t.Errorf("Expected the manager version in the WOL health check, got %v %v", resp, err)
+	}
+
+	agent := NewAgent(9, "node-a", "operator:9090", logr.Discard())
+	agent.grpcClient = wolv1.NewWOLServiceClient(conn)
+	agent.fetchVersion(ctx)
+	if !agent.managerSupports(FeatureMACAllowlist) || agent.managerSupports("teleport") {
+		t.Errorf("Expected the announced features only, got %v", agent.managerFeatures)
+	}
+}
+
+func TestAgent_FetchVersionOldManager(t *testing.T) {
+	conn := serveVersionTest(t, wolv1.UnimplementedWOLServiceServer{})
+
+	agent := NewAgent(9, "node-a", "operator:9090", logr.Discard())
+	agent.grpcClient = wolv1.NewWOLServiceClient(conn)
+	agent.fetchVersion(context.Background())
+	if agent.managerFeatures != nil || !agent.managerSupports(FeatureAgentConfig) {
+		t.Errorf("Expected every feature to be tried with an old manager, got %v", agent.managerFeatures)
+	}
+}
+
+func TestVersionSkewed(t *testing.T) {
+	tests := []struct {
+		manager, agent string
+		want           bool
+	}{
+		{"v0.3.0", "v0.3.2", false},
+		{"v0.3.0", "v0.2.9", false},
+		{"v0.3.0", "0.4", false},
+		{"v0.3.0", "v0.1.0", true},
+		{"v0.3.0", "v0.5.0-rc.1", true},
+		{"v1.0.0", "v0.9.0", true},
+		{"v0.3.0", "dev", false},
+		{"v0.3.0", "", false},
+		{"latest", "v0.1.0", false},
+	}
+	for _, tt := range tests {
+		if got := VersionSkewed(tt.manager, tt.agent); got != tt.want {
+			t.Errorf("VersionSkewed(%q, %q) = %v, want %v", tt.manager, tt.agent, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxVLANTags is the number of VLAN tags parsed in a frame (QinQ)
+const maxVLANTags = 2
+
+// Results counted by wol_agent_vlan_frames_total
+const (
+	vlanAccepted = "accepted"
+	vlanDropped  = "dropped"
+	// vlanOther is the VLAN label of the dropped frames, whose VLANs are not bounded
+	vlanOther = "other"
+)
+
+// vlanTPIDs are the EtherTypes of the VLAN tags: 802.1Q, 802.1ad (QinQ) and
+// the pre-standard QinQ TPID
+var vlanTPIDs = []uint16{0x8100, 0x88a8, 0x9100}
+
+// parseVLANTags returns the EtherType of frame after its VLAN tags (up to
+// maxVLANTags), the VLAN IDs of the tags, outer first, and the offset of the
+// payload. ok is false for a frame truncated in its tags.
+func parseVLANTags(frame []byte) (etherType uint16, vlans []uint16, offset int, ok bool) {
+	offset = 12
+	for {
+		if len(frame) < offset+2 {
+			return 0, nil, 0, false
+		}
+		etherType = binary.BigEndian.Uint16(frame[offset:])
+		if len(vlans) == maxVLANTags || !slices.Contains(vlanTPIDs, etherType) {
+			return etherType, vlans, offset + 2, true
+		}
+		if len(frame) < offset+4 {
+			return 0, nil, 0, false
+		}
+		vlans = append(vlans, binary.BigEndian.Uint16(frame[offset+2:])&0x0fff)
+		offset += 4
+	}
+}
+
+// vlanString formats the VLAN IDs of a frame for the logs, outer first
+// ("100", "100.200"); "" for an untagged frame
+func vlanString(vlans []uint16) string {
+	parts := make([]string, len(vlans))
+	for i, vlan := range vlans {
+		parts[i] = strconv.Itoa(int(vlan))
+	}
+	return strings.Join(parts, ".")
+}
+
+// matchVLAN returns the configured VLAN a frame with the given tags is
+// accepted for: one of its VLAN IDs, or 0 for an untagged frame. Without
+// configured VLANs every frame is accepted.
+func (r *RawListener) matchVLAN(vlans []uint16) (uint16, bool) {
+	if len(r.vlans) == 0 {
+		return 0, true
+	}
+	if len(vlans) == 0 {
+		return 0, slices.Contains(r.vlans, 0)
+	}
+	for _, vlan := range vlans {
+		if slices.Contains(r.vlans, vlan) {
+			return vlan, true
+		}
+	}
+	return 0, false
+}
+
+// acceptVLAN filters a frame by its VLAN tags, counting it per VLAN when
+// VLANs are configured
+func (r *RawListener) acceptVLAN(vlans []uint16) bool {
+	if len(r.vlans) == 0 {
+		return true
+	}
+	vlan, ok := r.matchVLAN(vlans)
+	if !ok {
+		r.log.V(1).Info("Frame dropped by the VLAN filter", "vlan", vlanString(vlans))
+	}
+	if r.vlanFrames != nil {
+		if ok {
+			r.vlanFrames.WithLabelValues(strconv.Itoa(int(vlan)), vlanAccepted).Inc()
+		} else {
+			r.vlanFrames.WithLabelValues(vlanOther, vlanDropped).Inc()
+		}
+	}
+	return ok
+}
+
+// strippedVLANTag returns the VLAN tag removed from a frame by the kernel (or
+// the NIC), reported in the PACKET_AUXDATA control message
+func strippedVLANTag(oob []byte) (tpid, tci uint16, ok bool) {
+	messages, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, 0, false
+	}
+	for _, m := range messages {
+		if m.Header.Level != unix.SOL_PACKET || m.Header.Type != unix.PACKET_AUXDATA ||
+			len(m.Data) < int(unsafe.Sizeof(unix.TpacketAuxdata{})) {
+			continue
+		}
+		aux := (*unix.TpacketAuxdata)(unsafe.Pointer(&m.Data[0]))
+		if aux.Status&unix.TP_STATUS_VLAN_VALID == 0 {
+			return 0, 0, false
+		}
+		tpid = vlanTPIDs[0]
+		if aux.Status&unix.TP_STATUS_VLAN_TPID_VALID != 0 {
+			tpid = aux.Vlan_tpid
+		}
+		return tpid, aux.Vlan_tci, true
+	}
+	return 0, 0, false
+}
+
+// insertVLANTag puts back a stripped tag after the MAC addresses of frame,
+// which starts 4 bytes into buffer
+func insertVLANTag(buffer []byte, tpid, tci uint16) []byte {
+	copy(buffer, buffer[4:16])
+	binary.BigEndian.PutUint16(buffer[12:], tpid)
+	binary.BigEndian.PutUint16(buffer[14:], tci)
+	return buffer
+}
+
+// ParseVLANs parses a comma-separated list of VLAN IDs (0 = untagged frames)
+func ParseVLANs(value string) ([]uint16, error) {
+	var vlans []uint16
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		vlan, err := strconv.ParseUint(field, 10, 16)
+		if err != nil || vlan > 4094 {
+			return nil, fmt.Errorf("invalid VLAN ID %q (must be 0-4094)", field)
+		}
+		if !slices.Contains(vlans, uint16(vlan)) {
+			vlans = append(vlans, uint16(vlan))
+		}
+	}
+	return vlans, nil
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"slices"
+	"testing"
+	"unsafe"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// tagged inserts VLAN tags (TPID, VLAN ID) after the MAC addresses of frame
+func tagged(frame []byte, tags ...[2]uint16) []byte {
+	out := slices.Clone(frame[:12])
+	for _, tag := range tags {
+		out = binary.BigEndian.AppendUint16(out, tag[0])
+		out = binary.BigEndian.AppendUint16(out, tag[1])
+	}
+	return append(out, frame[12:]...)
+}
+
+func wolFrame(mac []byte) []byte {
+	frame := append(bytes.Repeat([]byte{0xff}, 6), 0x02, 0, 0, 0, 0, 1)
+	return append(binary.BigEndian.AppendUint16(frame, etherTypeWoL), buildMagicPacket(mac, nil)...)
+}
+
+func TestParseVLANTags(t *testing.T) {
+	frame := wolFrame([]byte{0x52, 0x54, 0x00, 0x00, 0x00, 0x01})
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+		vlans []uint16
+	}{
+		{"untagged", frame, nil},
+		{"802.1Q", tagged(frame, [2]uint16{0x8100, 0x2064}), []uint16{100}},
+		{"QinQ", tagged(frame, [2]uint16{0x88a8, 10}, [2]uint16{0x8100, 200}), []uint16{10, 200}},
+		{"pre-standard QinQ", tagged(frame, [2]uint16{0x9100, 10}, [2]uint16{0x8100, 200}), []uint16{10, 200}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			etherType, vlans, offset, ok := parseVLANTags(tc.frame)
+			if !ok || etherType != etherTypeWoL || !slices.Equal(vlans, tc.vlans) || offset != 14+4*len(tc.vlans) {
+				t.Errorf("Unexpected tags 0x%04x %v %d %v", etherType, vlans, offset, ok)
+			}
+		})
+	}
+
+	// Tre tag: il terzo TPID non è un EtherType accettato
+	if etherType, _, _, _ := parseVLANTags(tagged(frame, [2]uint16{0x88a8, 1}, [2]uint16{0x8100, 2},
+		[2]uint16{0x8100, 3})); etherType != 0x8100 {
+		t.Errorf("Expected two tags at most, got EtherType 0x%04x", etherType)
+	}
+	if _, _, _, ok := parseVLANTags(tagged(frame, [2]uint16{0x8100, 1})[:15]); ok {
+		t.Error("Expected a frame truncated in its tag to be rejected")
+	}
+}
+
+func TestParseVLANs(t *testing.T) {
+	vlans, err := ParseVLANs("0, 100,4094,100")
+	if err != nil || !slices.Equal(vlans, []uint16{0, 100, 4094}) {
+		t.Errorf("Unexpected VLANs %v (%v)", vlans, err)
+	}
+	for _, invalid := range []string{"4095", "-1", "eth0"} {
+		if _, err := ParseVLANs(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestRawListener_VLANs(t *testing.T) {
+	frames := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_vlan_frames_total"}, []string{"vlan", "result"})
+	var wakes []string
+	listener := NewRawListenerWithOptions("test0", func(mac string, _ []byte, _ net.HardwareAddr) {
+		wakes = append(wakes, mac)
+	}, logr.Discard(), RawListenerOptions{VLANs: []uint16{0, 100, 200}, VLANFrames: frames})
+
+	frame := wolFrame([]byte{0x52, 0x54, 0x00, 0x00, 0x00, 0x01})
+	listener.processEthernetFrame(frame)
+	listener.processEthernetFrame(tagged(frame, [2]uint16{0x8100, 100}))
+	listener.processEthernetFrame(tagged(frame, [2]uint16{0x8100, 0x3000 | 100})) // con priorità
+	listener.processEthernetFrame(tagged(frame, [2]uint16{0x88a8, 30}, [2]uint16{0x8100, 200}))
+	listener.processEthernetFrame(tagged(frame, [2]uint16{0x8100, 300}))
+	listener.processEthernetFrame(tagged(frame, [2]uint16{0x88a8, 30}, [2]uint16{0x8100, 40}))
+
+	if len(wakes) != 4 {
+		t.Errorf("Expected the frames of VLANs 0, 100 and 200 only, got %d wakes", len(wakes))
+	}
+	for vlan, want := range map[string]float64{"0": 1, "100": 2, "200": 1} {
+		if got := testutil.ToFloat64(frames.WithLabelValues(vlan, vlanAccepted)); got != want {
+			t.Errorf("Expected %v frames accepted on VLAN %s, got %v", want, vlan, got)
+		}
+	}
+	if got := testutil.ToFloat64(frames.WithLabelValues(vlanOther, vlanDropped)); got != 2 {
+		t.Errorf("Expected 2 dropped frames, got %v", got)
+	}
+
+	// Senza VLAN configurate i frame con tag sono accettati
+	wakes = nil
+	plain := NewRawListener("test0", func(mac string, _ []byte, _ net.HardwareAddr) {
+		wakes = append(wakes, mac)
+	}, logr.Discard())
+	plain.processEthernetFrame(tagged(frame, [2]uint16{0x88a8, 30}, [2]uint16{0x8100, 40}))
+	if len(wakes) != 1 {
+		t.Errorf("Expected the QinQ frame to be accepted, got %d wakes", len(wakes))
+	}
+}
+
+func TestCaptureFilter_VLANTags(t *testing.T) {
+	mac := []byte{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	magic := buildMagicPacket(mac, nil)
+	spec := captureSpec{etherTypes: []uint16{etherTypeWoL}, udpPorts: []uint16{9}, neighborSolicitations: true}
+	spec.vlanTags = true
+	vm, err := bpf.NewVM(mustDisassemble(t, toRaw(spec.filter())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, ad := [2]uint16{0x8100, 100}, [2]uint16{0x88a8, 10}
+
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+		want  bool
+	}{
+		{"untagged WoL frame", wolFrame(mac), true},
+		{"tagged WoL frame", tagged(wolFrame(mac), q), true},
+		{"QinQ WoL frame", tagged(wolFrame(mac), ad, q), true},
+		{"QinQ UDP to port 9", tagged(udpFrame(mac, 9, magic), ad, q), true},
+		{"QinQ UDP to port 53", tagged(udpFrame(mac, 53, magic), ad, q), false},
+		{"tagged neighbor solicitation", tagged(neighborSolicitationFrame("fd00::5", "fd00::20", 255), q), true},
+		{"tagged other EtherType", tagged(udpFrame(mac, 9, magic)[:12], q, [2]uint16{0x88b5, 0}), false},
+		{"three tags", tagged(wolFrame(mac), ad, q, q), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := vm.Run(tc.frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n > 0 != tc.want {
+				t.Errorf("Expected %v, got %v", tc.want, n > 0)
+			}
+		})
+	}
+}
+
+func TestStrippedVLANTag(t *testing.T) {
+	aux := unix.TpacketAuxdata{Status: unix.TP_STATUS_VLAN_VALID | unix.TP_STATUS_VLAN_TPID_VALID,
+		Vlan_tci: 0x2064, Vlan_tpid: 0x88a8}
+	size := int(unsafe.Sizeof(aux))
+	oob := make([]byte, unix.CmsgSpace(size))
+	header := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	header.Level, header.Type = unix.SOL_PACKET, unix.PACKET_AUXDATA
+	header.SetLen(unix.CmsgLen(size))
+	*(*unix.TpacketAuxdata)(unsafe.Pointer(&oob[unix.CmsgLen(0)])) = aux
+
+	tpid, tci, ok := strippedVLANTag(oob)
+	if !ok || tpid != 0x88a8 || tci != 0x2064 {
+		t.Fatalf("Unexpected tag 0x%04x 0x%04x %v", tpid, tci, ok)
+	}
+
+	// Il tag è reinserito dopo i MAC, nei 4 byte liberi in testa al buffer
+	frame := wolFrame([]byte{0x52, 0x54, 0x00, 0x00, 0x00, 0x01})
+	buffer := append(make([]byte, 4), frame...)
+	if got := insertVLANTag(buffer, tpid, tci); !bytes.Equal(got, tagged(frame, [2]uint16{0x88a8, 0x2064})) {
+		t.Errorf("Unexpected frame %x", got)
+	}
+
+	aux.Status = 0
+	*(*unix.TpacketAuxdata)(unsafe.Pointer(&oob[unix.CmsgLen(0)])) = aux
+	if _, _, ok := strippedVLANTag(oob); ok {
+		t.Error("Expected no tag without TP_STATUS_VLAN_VALID")
+	}
+}
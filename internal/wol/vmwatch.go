@@ -0,0 +1,341 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	toolscache "k8s.io/client-go/tools/cache"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// WatchVMs keeps the mapping up to date from the VirtualMachine and
+// VirtualMachineInstance informers until ctx is done: each added, changed or
+// deleted VM, and each VMI reporting new auto-assigned MACs, updates only the
+// MACs of its VM without waiting for the next RefreshMapping. RefreshMapping
+// stays the full resync (and the only source of ARP targets and network
+// attachments).
+func (m *MACMapper) WatchVMs(ctx context.Context, informers cache.Informers) error {
+	vmInformer, err := informers.GetInformer(ctx, &kubevirtv1.VirtualMachine{})
+	if err != nil {
+		return fmt.Errorf("failed to get the VirtualMachine informer: %w", err)
+	}
+	vmiInformer, err := informers.GetInformer(ctx, &kubevirtv1.VirtualMachineInstance{})
+	if err != nil {
+		return fmt.Errorf("failed to get the VirtualMachineInstance informer: %w", err)
+	}
+	vmRegistration, err := vmInformer.AddEventHandler(m.vmEventHandler(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to watch VirtualMachines: %w", err)
+	}
+	defer func() { _ = vmInformer.RemoveEventHandler(vmRegistration) }()
+	vmiRegistration, err := vmiInformer.AddEventHandler(m.vmiEventHandler(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to watch VirtualMachineInstances: %w", err)
+	}
+	defer func() { _ = vmiInformer.RemoveEventHandler(vmiRegistration) }()
+	m.log.Info("Watching VirtualMachines and VirtualMachineInstances for MAC mapping updates")
+
+	<-ctx.Done()
+	return nil
+}
+
+// vmEventHandler applies the VirtualMachine events of the informer to the mapping
+func (m *MACMapper) vmEventHandler(ctx context.Context) toolscache.ResourceEventHandlerFuncs {
+	return toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			if vm, ok := obj.(*kubevirtv1.VirtualMachine); ok {
+				m.ApplyVM(ctx, vm)
+			}
+		},
+		UpdateFunc: func(_, obj any) {
+			if vm, ok := obj.(*kubevirtv1.VirtualMachine); ok {
+				m.ApplyVM(ctx, vm)
+			}
+		},
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if vm, ok := obj.(*kubevirtv1.VirtualMachine); ok {
+				m.RemoveVM(ctx, vm.Namespace, vm.Name)
+			}
+		},
+	}
+}
+
+// ApplyVM updates the MACs discovered from a VM after it was created or changed
+func (m *MACMapper) ApplyVM(ctx context.Context, vm *kubevirtv1.VirtualMachine) {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+	m.applyVM(ctx, vm)
+}
+
+// applyVM is ApplyVM with m.refreshMu held
+func (m *MACMapper) applyVM(ctx context.Context, vm *kubevirtv1.VirtualMachine) {
+	m.mu.RLock()
+	configs := m.configs
+	m.mu.RUnlock()
+
+	builder := newMappingBuilder(configs)
+	for i := range configs {
+		if m.configSelectsVM(&configs[i], vm) {
+			m.extractMACsFromVMs(&configs[i], []kubevirtv1.VirtualMachine{*vm}, builder)
+		}
+	}
+	m.addExplicitVMMappings(configs, vm.Namespace, vm.Name, vm, builder)
+	m.updateVM(ctx, vm.Namespace, vm.Name, builder)
+}
+
+// RemoveVM drops the MACs discovered from a deleted VM
+func (m *MACMapper) RemoveVM(ctx context.Context, namespace, name string) {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+
+	delete(m.statusMACs, vmIndexKey(namespace, name))
+	m.mu.RLock()
+	configs := m.configs
+	m.mu.RUnlock()
+	// Le mappature esplicite restano anche senza VM
+	builder := newMappingBuilder(configs)
+	m.addExplicitVMMappings(configs, namespace, name, nil, builder)
+	m.updateVM(ctx, namespace, name, builder)
+}
+
+// addExplicitVMMappings adds to builder the explicit mappings of a single VM
+// (nil once deleted), unless it opted out
+func (m *MACMapper) addExplicitVMMappings(configs []wolv1beta1.WolConfig, namespace, name string,
+	vm *kubevirtv1.VirtualMachine, builder *mappingBuilder) {
+	if vm != nil && vmOptedOut(vm) {
+		return
+	}
+	for i := range configs {
+		if configs[i].Spec.DiscoveryMode != wolv1beta1.DiscoveryModeExplicit {
+			continue
+		}
+		m.addExplicitMappings(&configs[i], builder, func(ns, n string) (*kubevirtv1.VirtualMachine, bool) {
+			return vm, ns == namespace && n == name
+		})
+		if vm != nil {
+			m.addSelectorVMMappings(&configs[i], vm, builder)
+		}
+	}
+}
+
+// configSelectsVM returns true if the discovery of the config includes the VM,
+// as discoverAllVMs and discoverVMsWithSelector would list it. m.refreshMu must be held.
+func (m *MACMapper) configSelectsVM(config *wolv1beta1.WolConfig, vm *kubevirtv1.VirtualMachine) bool {
+	if !m.configWatchesNamespace(config, vm.Namespace) {
+		return false
+	}
+	if exclusions, err := newDiscoveryExclusions(config); err != nil || exclusions.excludesVM(vm) {
+		return false
+	}
+	switch config.Spec.DiscoveryMode {
+	case wolv1beta1.DiscoveryModeExplicit:
+		return false
+	case wolv1beta1.DiscoveryModeLabelSelector:
+		if config.Spec.VMSelector == nil {
+			return false
+		}
+		selector, err := metav1.LabelSelectorAsSelector(config.Spec.VMSelector)
+		return err == nil && selector.Matches(labels.Set(vm.Labels))
+	default:
+		return true
+	}
+}
+
+// updateVM replaces the claims of a VM with the ones in builder and resolves
+// again the MACs it claimed before or claims now. The builder carries the
+// explicit mappings of the VM too, so an opt-out drops them. m.refreshMu must be held.
+func (m *MACMapper) updateVM(ctx context.Context, namespace, name string, builder *mappingBuilder) {
+	// claims e vmMACs cambiano solo sotto refreshMu
+	if m.claims == nil {
+		return // prima del primo RefreshMapping non c'è nulla da aggiornare
+	}
+
+	vmKey := vmIndexKey(namespace, name)
+	vm := VMInfo{Namespace: namespace, Name: name}
+	affected := maps.Clone(m.vmMACs[vmKey])
+	if affected == nil {
+		affected = make(map[macKey]bool)
+	}
+	for key := range builder.candidates {
+		affected[key] = true
+	}
+
+	claims := make(map[macKey][]VMInfo, len(affected))
+	for key := range affected {
+		kept := slices.DeleteFunc(slices.Clone(m.claims[key]), func(c VMInfo) bool {
+			return c.sameVM(vm)
+		})
+		claims[key] = append(kept, builder.candidates[key]...)
+	}
+
+	// Le VM che si contendono i MAC toccati possono vincere o perdere un MAC:
+	// le loro voci dell'indice per VM vanno ricalcolate
+	touched := map[string]bool{vmKey: true}
+	var added, removed int
+	conflicts := make(map[macKey]*MappingConflict, len(affected))
+	for key := range affected {
+		candidates := claims[key]
+		for _, c := range append(slices.Clone(m.claims[key]), candidates...) {
+			touched[vmIndexKey(c.Namespace, c.Name)] = true
+		}
+		if len(candidates) == 0 {
+			if m.mapping.Delete(key) {
+				removed++
+			}
+			conflicts[key] = nil
+			continue
+		}
+		winner, ok := builder.resolve(candidates)
+		if ok {
+			if _, existed := m.mapping.Get(key); !existed {
+				added++
+			}
+			m.mapping.Set(key, winner)
+		} else if m.mapping.Delete(key) {
+			removed++
+		}
+		conflicts[key] = builder.newConflict(key, candidates, winner, ok)
+	}
+
+	vmPasswords := m.loadNewVMPasswords(ctx, claims)
+
+	m.mu.Lock()
+	for key, candidates := range claims {
+		if len(candidates) == 0 {
+			delete(m.claims, key)
+		} else {
+			m.claims[key] = candidates
+		}
+	}
+	m.vmMACs[vmKey] = make(map[macKey]bool)
+	for key, candidates := range claims {
+		for _, c := range candidates {
+			other := vmIndexKey(c.Namespace, c.Name)
+			if m.vmMACs[other] == nil {
+				m.vmMACs[other] = make(map[macKey]bool)
+			}
+			m.vmMACs[other][key] = true
+		}
+	}
+	if len(m.vmMACs[vmKey]) == 0 {
+		delete(m.vmMACs, vmKey)
+	}
+
+	vms := maps.Clone(m.vms)
+	if vms == nil {
+		vms = make(map[string]VMInfo)
+	}
+	for other := range touched {
+		delete(vms, other)
+		for key := range m.vmMACs[other] {
+			info, found := m.mapping.Get(key)
+			if !found || vmIndexKey(info.Namespace, info.Name) != other {
+				continue
+			}
+			if existing, found := vms[other]; !found || builder.indexBefore(info, existing) {
+				vms[other] = info
+			}
+		}
+	}
+	m.vms = vms
+	m.conflicts = mergeConflicts(m.conflicts, conflicts)
+	if len(vmPasswords) > 0 {
+		passwords := maps.Clone(m.vmPasswords)
+		if passwords == nil {
+			passwords = make(map[wolv1beta1.SecretKeyReference]string)
+		}
+		maps.Copy(passwords, vmPasswords)
+		m.vmPasswords = passwords
+	}
+	m.mu.Unlock()
+
+	ManagedVMs.Set(float64(m.mapping.Len()))
+	if added > 0 || removed > 0 {
+		m.notifyMappingChange()
+	}
+	if added > 0 || removed > 0 || len(builder.candidates) > 0 {
+		m.log.V(1).Info("MAC mapping updated for VM", "vm", name, "namespace", namespace,
+			"macs", len(builder.candidates), "added", added, "removed", removed)
+	}
+}
+
+// loadNewVMPasswords reads the per-VM SecureOn passwords referenced by the
+// claims that are not loaded yet
+func (m *MACMapper) loadNewVMPasswords(ctx context.Context, claims map[macKey][]VMInfo) map[wolv1beta1.SecretKeyReference]string {
+	m.mu.RLock()
+	loaded := m.vmPasswords
+	m.mu.RUnlock()
+
+	passwords := make(map[wolv1beta1.SecretKeyReference]string)
+	for _, candidates := range claims {
+		for _, c := range candidates {
+			ref := c.SecureOnPasswordRef
+			if ref.Name == "" {
+				continue
+			}
+			if _, done := loaded[ref]; done {
+				continue
+			}
+			if _, done := passwords[ref]; done {
+				continue
+			}
+			password, err := m.readSecureOnPassword(ctx, ref)
+			if err != nil {
+				m.log.Error(err, "Failed to load the SecureOn password of a VM", "vm", c.Name, "namespace", c.Namespace)
+				ErrorsTotal.Inc()
+			}
+			passwords[ref] = password
+		}
+	}
+	return passwords
+}
+
+// mergeConflicts replaces the conflicts of the updated MACs (nil = no conflict
+// anymore) and returns the list sorted by MAC
+func mergeConflicts(current []MappingConflict, updated map[macKey]*MappingConflict) []MappingConflict {
+	merged := make([]MappingConflict, 0, len(current))
+	for _, conflict := range current {
+		if key, ok := parseMACKey(conflict.MAC); ok {
+			if _, replaced := updated[key]; replaced {
+				continue
+			}
+		}
+		merged = append(merged, conflict)
+	}
+	for _, conflict := range updated {
+		if conflict != nil {
+			merged = append(merged, *conflict)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].MAC < merged[j].MAC
+	})
+	return merged
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// newWatchVM returns a VM of the tenant namespace with one interface per MAC
+func newWatchVM(name string, vmLabels map[string]string, macs ...string) *kubevirtv1.VirtualMachine {
+	vm := &kubevirtv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "tenant", Labels: vmLabels}}
+	vm.Spec.Template = &kubevirtv1.VirtualMachineInstanceTemplateSpec{}
+	for _, mac := range macs {
+		vm.Spec.Template.Spec.Domain.Devices.Interfaces = append(vm.Spec.Template.Spec.Domain.Devices.Interfaces,
+			kubevirtv1.Interface{Name: "default", MacAddress: mac})
+	}
+	return vm
+}
+
+// newWatchMapper returns a mapper refreshed with an All config for the tenant
+// namespace, a LabelSelector config and the given VMs (and VMIs)
+func newWatchMapper(t *testing.T, objects ...client.Object) *MACMapper {
+	t.Helper()
+	mapper := NewMACMapper(newPolicyClient(t, objects...), logr.Discard())
+
+	all := conflictTestConfig("all", time.Hour, 0, "")
+	all.Spec.NamespaceSelectors = []string{"tenant"}
+	selected := conflictTestConfig("selected", 0, 0, "")
+	selected.Spec.DiscoveryMode = wolv1beta1.DiscoveryModeLabelSelector
+	selected.Spec.VMSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"wol": "on"}}
+	selected.Spec.Precedence = 10
+	mapper.UpdateConfigs([]wolv1beta1.WolConfig{all, selected})
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return mapper
+}
+
+func TestMACMapper_ApplyVM(t *testing.T) {
+	mapper := newWatchMapper(t, newWatchVM("vm1", nil, "52:54:00:00:00:01"))
+	ctx := context.Background()
+
+	// Una VM nuova è svegliabile senza attendere il refresh
+	mapper.ApplyVM(ctx, newWatchVM("vm2", nil, "52:54:00:00:00:02"))
+	if info, found := mapper.Lookup("52:54:00:00:00:02"); !found || info.Name != "vm2" || info.ConfigName != "all" {
+		t.Errorf("Expected vm2 to be mapped by config all, got %+v (found=%v)", info, found)
+	}
+
+	// Il MAC cambiato sostituisce quello vecchio
+	mapper.ApplyVM(ctx, newWatchVM("vm1", nil, "52:54:00:00:00:03"))
+	if _, found := mapper.Lookup("52:54:00:00:00:01"); found {
+		t.Error("Expected the old MAC of vm1 to be removed")
+	}
+	if info, found := mapper.LookupVM("tenant", "vm1"); !found || info.Name != "vm1" {
+		t.Errorf("Expected vm1 in the VM index, got %+v (found=%v)", info, found)
+	}
+
+	// Una label fa entrare la VM nella config con precedenza più alta
+	mapper.ApplyVM(ctx, newWatchVM("vm2", map[string]string{"wol": "on"}, "52:54:00:00:00:02"))
+	if info, _ := mapper.Lookup("52:54:00:00:00:02"); info.ConfigName != "selected" {
+		t.Errorf("Expected vm2 to move to config selected, got %q", info.ConfigName)
+	}
+
+	// Due VM con lo stesso MAC sono un conflitto, che sparisce con la cancellazione
+	mapper.ApplyVM(ctx, newWatchVM("vm3", nil, "52:54:00:00:00:03"))
+	if conflicts := mapper.GetConflicts(""); len(conflicts) != 1 || conflicts[0].MAC != "52:54:00:00:00:03" {
+		t.Errorf("Expected a conflict on 52:54:00:00:00:03, got %+v", conflicts)
+	}
+	mapper.RemoveVM(ctx, "tenant", "vm1")
+	if info, found := mapper.Lookup("52:54:00:00:00:03"); !found || info.Name != "vm3" {
+		t.Errorf("Expected vm3 to keep the MAC, got %+v (found=%v)", info, found)
+	}
+	if conflicts := mapper.GetConflicts(""); len(conflicts) != 0 {
+		t.Errorf("Expected no conflict left, got %+v", conflicts)
+	}
+	if _, found := mapper.LookupVM("tenant", "vm1"); found {
+		t.Error("Expected vm1 to leave the VM index")
+	}
+	if _, found := mapper.LookupVM("tenant", "vm3"); !found {
+		t.Error("Expected vm3 in the VM index")
+	}
+
+	// Le VM fuori dai namespace delle config sono ignorate
+	other := newWatchVM("vm4", nil, "52:54:00:00:00:04")
+	other.Namespace = "other"
+	mapper.ApplyVM(ctx, other)
+	if _, found := mapper.Lookup("52:54:00:00:00:04"); found {
+		t.Error("Expected a VM of another namespace not to be mapped")
+	}
+	if mapper.GetMappingCount() != 2 {
+		t.Errorf("Expected 2 MACs, got %d", mapper.GetMappingCount())
+	}
+}
+
+func TestMACMapper_ApplyVMBeforeRefresh(t *testing.T) {
+	mapper := NewMACMapper(nil, logr.Discard())
+	mapper.ApplyVM(context.Background(), newWatchVM("vm1", nil, "52:54:00:00:00:01"))
+	if mapper.GetMappingCount() != 0 {
+		t.Error("Expected no mapping before the first refresh")
+	}
+}
+
+func TestMACMapper_VMEventHandler(t *testing.T) {
+	mapper := newWatchMapper(t)
+	scheme := runtime.NewScheme()
+	if err := kubevirtv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	informers := &informertest.FakeInformers{Scheme: scheme}
+	informer, err := informers.FakeInformerFor(context.Background(), &kubevirtv1.VirtualMachine{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := informer.AddEventHandler(mapper.vmEventHandler(context.Background())); err != nil {
+		t.Fatal(err)
+	}
+
+	vm := newWatchVM("vm1", nil, "52:54:00:00:00:01")
+	informer.Add(vm)
+	if _, found := mapper.Lookup("52:54:00:00:00:01"); !found {
+		t.Fatal("Expected the added VM to be mapped")
+	}
+	informer.Update(vm, newWatchVM("vm1", nil, "52:54:00:00:00:02"))
+	if _, found := mapper.Lookup("52:54:00:00:00:02"); !found {
+		t.Error("Expected the updated MAC to be mapped")
+	}
+	informer.Delete(vm)
+	if mapper.GetMappingCount() != 0 {
+		t.Errorf("Expected the deleted VM to be unmapped, got %d MACs", mapper.GetMappingCount())
+	}
+}
+
+func TestMACMapper_OptOut(t *testing.T) {
+	optedOut := newWatchVM("vm1", map[string]string{"wol": "on"}, "52:54:00:00:00:01")
+	optedOut.Annotations = map[string]string{AnnotationEnabled: "false"}
+	explicitVM := newWatchVM("vm2", nil)
+	explicitVM.Annotations = map[string]string{AnnotationEnabled: "False"}
+	mapper := NewMACMapper(newPolicyClient(t, optedOut, explicitVM), logr.Discard())
+
+	all := conflictTestConfig("all", time.Hour, 0, "")
+	all.Spec.NamespaceSelectors = []string{"tenant"}
+	selected := conflictTestConfig("selected", 0, 0, "")
+	selected.Spec.DiscoveryMode = wolv1beta1.DiscoveryModeLabelSelector
+	selected.Spec.VMSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"wol": "on"}}
+	selected.Spec.Precedence = 10
+	explicit := conflictTestConfig("explicit", 0, 0, "")
+	explicit.Spec.DiscoveryMode = wolv1beta1.DiscoveryModeExplicit
+	explicit.Spec.ExplicitMappings = []wolv1beta1.MACVMMapping{
+		{MACAddress: "52:54:00:00:00:02", VMName: "vm2", Namespace: "tenant"},
+		{MACAddress: "52:54:00:00:00:03", VMName: "vm3", Namespace: "tenant"},
+	}
+	mapper.UpdateConfigs([]wolv1beta1.WolConfig{all, selected, explicit})
+	ctx := context.Background()
+	if err := mapper.RefreshMapping(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Nessuna modalità di discovery mappa le VM escluse; vm3 non esiste e resta mappata
+	for _, mac := range []string{"52:54:00:00:00:01", "52:54:00:00:00:02"} {
+		if info, found := mapper.Lookup(mac); found {
+			t.Errorf("Expected the opted-out %s not to be mapped, got %+v", mac, info)
+		}
+	}
+	if _, found := mapper.Lookup("52:54:00:00:00:03"); !found {
+		t.Error("Expected the explicit mapping of a missing VM to be kept")
+	}
+
+	// Togliere l'annotazione riporta le VM nel mapping senza attendere il refresh
+	mapper.ApplyVM(ctx, newWatchVM("vm1", map[string]string{"wol": "on"}, "52:54:00:00:00:01"))
+	if info, found := mapper.Lookup("52:54:00:00:00:01"); !found || info.ConfigName != "selected" {
+		t.Errorf("Expected vm1 to be mapped by config selected, got %+v (found=%v)", info, found)
+	}
+	mapper.ApplyVM(ctx, newWatchVM("vm2", nil))
+	if info, found := mapper.Lookup("52:54:00:00:00:02"); !found || info.MappingType != MappingTypeExplicit {
+		t.Errorf("Expected the explicit mapping of vm2 back, got %+v (found=%v)", info, found)
+	}
+
+	// Rimetterla esclude anche la mappatura esplicita
+	mapper.ApplyVM(ctx, explicitVM)
+	if _, found := mapper.Lookup("52:54:00:00:00:02"); found {
+		t.Error("Expected the opted-out vm2 to be unmapped")
+	}
+	if _, found := mapper.LookupVM("tenant", "vm2"); found {
+		t.Error("Expected the opted-out vm2 to leave the VM index")
+	}
+
+	// Cancellata la VM, la mappatura esplicita torna valida
+	mapper.RemoveVM(ctx, "tenant", "vm2")
+	if _, found := mapper.Lookup("52:54:00:00:00:02"); !found {
+		t.Error("Expected the explicit mapping of the deleted vm2 back")
+	}
+}
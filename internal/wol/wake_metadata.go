@@ -0,0 +1,138 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+)
+
+// Annotations of the VMs woken with spec.wakeMetadata
+const (
+	// AnnotationLastWakeTime is the time (RFC 3339) of the last wake
+	AnnotationLastWakeTime = "wol.pillon.org/last-wake-time"
+	// AnnotationLastWakeSource is what woke the VM: "wol/<source IP>" for a
+	// magic packet, the source of the wake request otherwise (arp, api, ...)
+	AnnotationLastWakeSource = "wol.pillon.org/last-wake-source"
+	// AnnotationLastWakeNode is the node of the agent that received the packet
+	AnnotationLastWakeNode = "wol.pillon.org/last-wake-node"
+	// AnnotationLastWakeReason describes the trigger of a wake request
+	AnnotationLastWakeReason = "wol.pillon.org/last-wake-reason"
+	// AnnotationWakeCount counts the wakes of the VM
+	AnnotationWakeCount = "wol.pillon.org/wake-count"
+)
+
+// wakeOrigin is what requested a wake, recorded on the VMs started for it
+type wakeOrigin struct {
+	Source string
+	Node   string
+	Reason string
+	// ReceivedAt is when the packet was captured or the request received
+	ReceivedAt time.Time
+}
+
+type wakeOriginContextKey struct{}
+
+// withWakeOrigin returns a context carrying the origin of the wakes started with it
+func withWakeOrigin(ctx context.Context, origin wakeOrigin) context.Context {
+	return context.WithValue(ctx, wakeOriginContextKey{}, origin)
+}
+
+// wakeOriginFromContext returns the origin of the wakes started with ctx
+func wakeOriginFromContext(ctx context.Context) (wakeOrigin, bool) {
+	origin, ok := ctx.Value(wakeOriginContextKey{}).(wakeOrigin)
+	return origin, ok
+}
+
+// WakeMetadata returns the wake annotations settings of a WolConfig, nil if disabled
+func (m *MACMapper) WakeMetadata(configName string) *wolv1beta1.WakeMetadataSpec {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range m.configs {
+		if m.configs[i].Name != configName {
+			continue
+		}
+		if spec := m.configs[i].Spec.WakeMetadata; spec != nil && spec.Enabled {
+			return spec
+		}
+		return nil
+	}
+	return nil
+}
+
+// setWakeAnnotations records in annotations the count-th wake, at now.
+// The annotations of the previous wake not set by this one are removed.
+func setWakeAnnotations(annotations map[string]string, origin wakeOrigin, now time.Time, count int) map[string]string {
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	for key, value := range map[string]string{
+		AnnotationLastWakeTime:   now.UTC().Format(time.RFC3339),
+		AnnotationLastWakeSource: origin.Source,
+		AnnotationLastWakeNode:   origin.Node,
+		AnnotationLastWakeReason: origin.Reason,
+		AnnotationWakeCount:      strconv.Itoa(count),
+	} {
+		if value == "" {
+			delete(annotations, key)
+		} else {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
+
+// annotateWake records a wake at now on the annotations of the VM or, with
+// template, of its template, unless the VM already has a VMI (a template
+// change would only apply at the next start). Failures are logged: the wake
+// goes on without them.
+func (a *Aggregator) annotateWake(ctx context.Context, vmInfo VMInfo, origin wakeOrigin, now time.Time, template bool) {
+	c := a.mapper.client
+	key := client.ObjectKey{Namespace: vmInfo.Namespace, Name: vmInfo.Name}
+	vm := &kubevirtv1.VirtualMachine{}
+	if err := c.Get(ctx, key, vm); err != nil {
+		a.log.V(1).Info("Cannot record the wake on the VM", "vm", vmInfo.Name, "namespace", vmInfo.Namespace,
+			"error", err.Error())
+		return
+	}
+	patch := client.MergeFrom(vm.DeepCopy())
+	count, _ := strconv.Atoi(vm.Annotations[AnnotationWakeCount])
+	if template {
+		if vm.Spec.Template == nil {
+			return
+		}
+		err := c.Get(ctx, key, &kubevirtv1.VirtualMachineInstance{})
+		if !apierrors.IsNotFound(err) {
+			return
+		}
+		vm.Spec.Template.ObjectMeta.Annotations = setWakeAnnotations(vm.Spec.Template.ObjectMeta.Annotations,
+			origin, now, count+1)
+	} else {
+		vm.Annotations = setWakeAnnotations(vm.Annotations, origin, now, count+1)
+	}
+	if err := c.Patch(ctx, vm, patch); err != nil {
+		a.log.Error(err, "Failed to record the wake on the VM", "vm", vmInfo.Name, "namespace", vmInfo.Namespace,
+			"template", template)
+	}
+}
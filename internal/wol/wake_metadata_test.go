@@ -0,0 +1,123 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestSetWakeAnnotations(t *testing.T) {
+	now := time.Date(2025, 3, 1, 10, 0, 0, 0, time.FixedZone("CET", 3600))
+	annotations := map[string]string{AnnotationLastWakeReason: "old", "other": "kept"}
+	annotations = setWakeAnnotations(annotations, wakeOrigin{Source: "wol/10.0.0.5", Node: "node1"}, now, 3)
+
+	want := map[string]string{
+		AnnotationLastWakeTime:   "2025-03-01T09:00:00Z",
+		AnnotationLastWakeSource: "wol/10.0.0.5",
+		AnnotationLastWakeNode:   "node1",
+		AnnotationWakeCount:      "3",
+		"other":                  "kept",
+	}
+	if len(annotations) != len(want) {
+		t.Errorf("Unexpected annotations %v", annotations)
+	}
+	for key, value := range want {
+		if annotations[key] != value {
+			t.Errorf("Expected %s=%q, got %q", key, value, annotations[key])
+		}
+	}
+}
+
+func TestAggregator_WakeMetadata(t *testing.T) {
+	vm := &kubevirtv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "vm1", Namespace: "default"},
+		Spec:       kubevirtv1.VirtualMachineSpec{Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{}},
+	}
+	c := newPolicyClient(t, vm)
+	mapper := NewMACMapper(c, logr.Discard())
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:01", VMName: "vm1", Namespace: "default"},
+			},
+			WakeMetadata: &wolv1beta1.WakeMetadataSpec{Enabled: true, Guest: true},
+		},
+	}
+	config.Name = "lab"
+	mapper.UpdateConfig(config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	agg := NewAggregator(mapper, &policyStarter{actions: make(map[string]string)}, logr.Discard())
+	ctx := context.Background()
+	get := func() *kubevirtv1.VirtualMachine {
+		got := &kubevirtv1.VirtualMachine{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(vm), got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if _, err := agg.ReportWOLEvent(ctx, &wolv1.WOLEvent{
+		MacAddress: "52:54:00:00:00:01", NodeName: "node1", SourceIp: "10.0.0.5", DestinationPort: 9,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	got := get()
+	if got.Annotations[AnnotationLastWakeSource] != "wol/10.0.0.5" || got.Annotations[AnnotationLastWakeNode] != "node1" ||
+		got.Annotations[AnnotationWakeCount] != "1" || got.Annotations[AnnotationLastWakeTime] == "" {
+		t.Errorf("Unexpected VM annotations %v", got.Annotations)
+	}
+	if template := got.Spec.Template.ObjectMeta.Annotations; template[AnnotationLastWakeSource] != "wol/10.0.0.5" ||
+		template[AnnotationWakeCount] != "1" {
+		t.Errorf("Expected the wake on the VM template for the guest, got %v", template)
+	}
+
+	// Con una VMI il template non cambia: l'annotazione arriverebbe solo al prossimo start
+	vmi := &kubevirtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{Name: "vm1", Namespace: "default"}}
+	if err := c.Create(ctx, vmi); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := agg.RequestWake(ctx, &wolv1.WakeRequest{Namespace: "default", Name: "vm1", Source: ARPWakeSource,
+		Reason: "arp who-has 10.0.0.20"})
+	if resp.Status != wolv1.ResponseStatus_VM_START_INITIATED {
+		t.Fatalf("Unexpected response %v", resp)
+	}
+	got = get()
+	if got.Annotations[AnnotationLastWakeSource] != ARPWakeSource || got.Annotations[AnnotationWakeCount] != "2" ||
+		got.Annotations[AnnotationLastWakeReason] != "arp who-has 10.0.0.20" {
+		t.Errorf("Unexpected VM annotations %v", got.Annotations)
+	}
+	if _, ok := got.Annotations[AnnotationLastWakeNode]; ok {
+		t.Error("Expected the node of the previous wake to be removed")
+	}
+	if got.Spec.Template.ObjectMeta.Annotations[AnnotationWakeCount] != "1" {
+		t.Errorf("Expected the template of a VM with a VMI to be left alone, got %v",
+			got.Spec.Template.ObjectMeta.Annotations)
+	}
+}
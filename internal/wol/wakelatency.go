@@ -0,0 +1,190 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	toolscache "k8s.io/client-go/tools/cache"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// Every VM started for a wake is tracked until its VMI is Running: the time
+// from the packet capture on the agent (or the arrival of the wake request)
+// to Running is observed by wol_wake_to_running_seconds, the wake SLO. The
+// capture time comes from the agent clock, so with unsynchronized nodes the
+// arrival on the manager is used when the agent time is in its future.
+// With spec.wakeVerification, the VMs not Running in time are reported (see
+// wakeverify.go).
+
+// wakeTrackingTTL is how long a started VM is waited for to be Running,
+// without spec.wakeVerification
+const wakeTrackingTTL = 15 * time.Minute
+
+// wakeCheckInterval is how often the pending wakes are checked for their deadline
+const wakeCheckInterval = 10 * time.Second
+
+// pendingWake is a VM started for a wake and not Running yet
+type pendingWake struct {
+	vmInfo    VMInfo
+	origin    wakeOrigin
+	startedAt time.Time
+	// deadline è la scadenza di spec.wakeVerification, zero se disabilitata
+	deadline time.Time
+}
+
+// wakeTracker holds the pending wakes, by vmIndexKey
+type wakeTracker struct {
+	mu      sync.Mutex
+	pending map[string]pendingWake
+}
+
+// wakeReceivedAt returns when the packet of event was captured, receivedAt
+// (its arrival on the manager) if the agent sent no timestamp or one in the future
+func wakeReceivedAt(event *wolv1.WOLEvent, receivedAt time.Time) time.Time {
+	if event.Timestamp == nil {
+		return receivedAt
+	}
+	if captured := event.Timestamp.AsTime(); captured.Before(receivedAt) {
+		return captured
+	}
+	return receivedAt
+}
+
+// trackWake waits for a VM started at startedAt for the wake of origin to be Running
+func (a *Aggregator) trackWake(vmInfo VMInfo, origin wakeOrigin, startedAt time.Time) {
+	deadline := a.verificationDeadline(vmInfo.ConfigName, startedAt)
+	if origin.ReceivedAt.IsZero() && deadline.IsZero() {
+		return
+	}
+	t := &a.wakes
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending == nil {
+		t.pending = make(map[string]pendingWake)
+	}
+	key := vmIndexKey(vmInfo.Namespace, vmInfo.Name)
+	// Un wake successivo non sposta l'origine: conta il primo pacchetto
+	if _, tracked := t.pending[key]; !tracked {
+		t.pending[key] = pendingWake{vmInfo: vmInfo, origin: origin, startedAt: startedAt, deadline: deadline}
+	}
+}
+
+// WatchWakes observes from the VirtualMachineInstance informer when the VMs
+// started for a wake become Running, and reports those past the deadline of
+// spec.wakeVerification, until ctx is done
+func (a *Aggregator) WatchWakes(ctx context.Context, informers cache.Informers) error {
+	informer, err := informers.GetInformer(ctx, &kubevirtv1.VirtualMachineInstance{})
+	if err != nil {
+		return fmt.Errorf("failed to get the VirtualMachineInstance informer: %w", err)
+	}
+	registration, err := informer.AddEventHandler(a.wakeEventHandler(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to watch VirtualMachineInstances: %w", err)
+	}
+	defer func() { _ = informer.RemoveEventHandler(registration) }()
+
+	ticker := time.NewTicker(wakeCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			a.checkWakes(ctx, now)
+		}
+	}
+}
+
+// wakeEventHandler observes the VMIs reaching Running (or failing)
+func (a *Aggregator) wakeEventHandler(ctx context.Context) toolscache.ResourceEventHandlerFuncs {
+	observe := func(obj any) {
+		if vmi, ok := obj.(*kubevirtv1.VirtualMachineInstance); ok {
+			a.observeVMI(ctx, vmi, time.Now())
+		}
+	}
+	return toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    observe,
+		UpdateFunc: func(_, obj any) { observe(obj) },
+	}
+}
+
+// observeVMI records the wake latency of the VM of vmi, if it was started for
+// a wake and is now Running, or reports the wake failed if the VMI failed
+func (a *Aggregator) observeVMI(ctx context.Context, vmi *kubevirtv1.VirtualMachineInstance, now time.Time) {
+	running := vmi.Status.Phase == kubevirtv1.Running && !vmiPaused(vmi) && vmi.DeletionTimestamp == nil
+	failed := vmi.Status.Phase == kubevirtv1.Failed
+	if !running && !failed {
+		return
+	}
+	t := &a.wakes
+	key := vmIndexKey(vmi.Namespace, vmi.Name)
+	t.mu.Lock()
+	wake, tracked := t.pending[key]
+	// Senza verifica una VMI fallita resta in attesa: KubeVirt può riavviarla
+	if tracked && (running || !wake.deadline.IsZero()) {
+		delete(t.pending, key)
+	}
+	t.mu.Unlock()
+	if !tracked {
+		return
+	}
+
+	if failed {
+		if !wake.deadline.IsZero() {
+			a.failWake(ctx, wake, wakeFailureVMIFailed, now)
+		}
+		return
+	}
+	if wake.origin.ReceivedAt.IsZero() {
+		return
+	}
+	latency := now.Sub(wake.origin.ReceivedAt)
+	WakeToRunningSeconds.WithLabelValues(wake.vmInfo.ConfigName).Observe(latency.Seconds())
+	a.log.V(1).Info("VM Running after wake", "vm", vmi.Name, "namespace", vmi.Namespace,
+		"wolconfig", wake.vmInfo.ConfigName, "latency", latency.String())
+}
+
+// checkWakes reports the VMs still not Running at the deadline of
+// spec.wakeVerification, and forgets the others after wakeTrackingTTL
+func (a *Aggregator) checkWakes(ctx context.Context, now time.Time) {
+	var expired []pendingWake
+	t := &a.wakes
+	t.mu.Lock()
+	for key, wake := range t.pending {
+		switch {
+		case !wake.deadline.IsZero() && now.After(wake.deadline):
+			expired = append(expired, wake)
+			delete(t.pending, key)
+		case wake.deadline.IsZero() && now.Sub(wake.startedAt) > wakeTrackingTTL:
+			delete(t.pending, key)
+			a.log.V(1).Info("VM not Running after wake, no longer tracked", "vm", key,
+				"wolconfig", wake.vmInfo.ConfigName)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, wake := range expired {
+		a.failWake(ctx, wake, "", now)
+	}
+}
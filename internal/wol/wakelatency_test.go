@@ -0,0 +1,96 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestWakeReceivedAt(t *testing.T) {
+	now := time.Now()
+	if got := wakeReceivedAt(&wolv1.WOLEvent{}, now); !got.Equal(now) {
+		t.Errorf("Expected the arrival time without an agent timestamp, got %v", got)
+	}
+	captured := now.Add(-time.Second)
+	if got := wakeReceivedAt(&wolv1.WOLEvent{Timestamp: timestamppb.New(captured)}, now); !got.Equal(captured) {
+		t.Errorf("Expected the capture time, got %v", got)
+	}
+	// Orologio dell'agent avanti: vale l'arrivo sul manager
+	if got := wakeReceivedAt(&wolv1.WOLEvent{Timestamp: timestamppb.New(now.Add(time.Minute))}, now); !got.Equal(now) {
+		t.Errorf("Expected the arrival time for an agent clock ahead, got %v", got)
+	}
+}
+
+func TestAggregator_WakeToRunning(t *testing.T) {
+	ctx := context.Background()
+	mapper := NewMACMapper(newPolicyClient(t), logr.Discard())
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:01", VMName: "desktop", Namespace: "default"},
+			},
+		},
+	}
+	config.Name = "latency"
+	mapper.UpdateConfig(config)
+	if err := mapper.RefreshMapping(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	agg := NewAggregator(mapper, &policyStarter{actions: make(map[string]string)}, logr.Discard())
+
+	captured := time.Now().Add(-2 * time.Second)
+	resp, _ := agg.ReportWOLEvent(ctx, &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01", Timestamp: timestamppb.New(captured)})
+	if resp.Status != wolv1.ResponseStatus_VM_START_INITIATED {
+		t.Fatalf("Expected the VM to be started, got %v: %s", resp.Status, resp.Message)
+	}
+
+	vmi := &kubevirtv1.VirtualMachineInstance{}
+	vmi.Namespace, vmi.Name = "default", "desktop"
+	vmi.Status.Phase = kubevirtv1.Scheduling
+	agg.observeVMI(ctx, vmi, time.Now())
+	if got := testutil.CollectAndCount(WakeToRunningSeconds, "wol_wake_to_running_seconds"); got != 0 {
+		t.Fatalf("Expected no observation before Running, got %d series", got)
+	}
+
+	vmi.Status.Phase = kubevirtv1.Running
+	agg.observeVMI(ctx, vmi, captured.Add(30*time.Second))
+	if got := testutil.CollectAndCount(WakeToRunningSeconds, "wol_wake_to_running_seconds"); got != 1 {
+		t.Fatalf("Expected the wake latency to be observed, got %d series", got)
+	}
+	if len(agg.wakes.pending) != 0 {
+		t.Errorf("Expected the wake to be done, got %v", agg.wakes.pending)
+	}
+
+	// Un wake mai arrivato a Running è dimenticato dopo il TTL
+	agg.trackWake(VMInfo{Name: "server", Namespace: "default", ConfigName: "latency"},
+		wakeOrigin{Source: "api", ReceivedAt: captured}, captured)
+	agg.checkWakes(ctx, captured.Add(wakeTrackingTTL+time.Second))
+	if len(agg.wakes.pending) != 0 {
+		t.Errorf("Expected the expired wake to be forgotten, got %v", agg.wakes.pending)
+	}
+}
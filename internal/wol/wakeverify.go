@@ -0,0 +1,147 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// With spec.wakeVerification a successful start is not the end of a wake: the
+// VM has TimeoutSeconds to reach Running. A VM still not Running then, or
+// whose VMI failed, is reported with a WOLWakeNotRunning Event on the VM and
+// the WolConfig, wol_wake_failures_total and a failed audit record, with the
+// status of the VM as the reason (e.g. ErrorUnschedulable).
+
+const (
+	// EventReasonNotRunning: a VM started for a wake did not reach Running in time
+	EventReasonNotRunning = "WOLWakeNotRunning"
+
+	defaultWakeVerificationTimeout = 300 * time.Second
+
+	// wakeFailureVMIFailed is the reason of the wakes whose VMI failed
+	wakeFailureVMIFailed = "VMIFailed"
+	// wakeFailureUnknown is the reason of the wakes of a VM that cannot be read
+	wakeFailureUnknown = "Unknown"
+)
+
+// WakeVerification returns the wake verification settings of a WolConfig, nil if disabled
+func (m *MACMapper) WakeVerification(configName string) *wolv1beta1.WakeVerificationSpec {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range m.configs {
+		if m.configs[i].Name != configName {
+			continue
+		}
+		if spec := m.configs[i].Spec.WakeVerification; spec != nil && spec.Enabled {
+			return spec
+		}
+		return nil
+	}
+	return nil
+}
+
+// verificationDeadline returns when a VM of the WolConfig started at
+// startedAt must be Running, zero without spec.wakeVerification
+func (a *Aggregator) verificationDeadline(configName string, startedAt time.Time) time.Time {
+	spec := a.mapper.WakeVerification(configName)
+	if spec == nil {
+		return time.Time{}
+	}
+	timeout := time.Duration(spec.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultWakeVerificationTimeout
+	}
+	return startedAt.Add(timeout)
+}
+
+// failWake reports a VM started for a wake that did not reach Running.
+// reason is empty at the deadline: the status of the VM is used.
+func (a *Aggregator) failWake(ctx context.Context, wake pendingWake, reason string, now time.Time) {
+	vmInfo := wake.vmInfo
+	vm := &kubevirtv1.VirtualMachine{}
+	err := a.mapper.client.Get(ctx, client.ObjectKey{Namespace: vmInfo.Namespace, Name: vmInfo.Name}, vm)
+	if reason == "" {
+		reason = wakeFailureUnknown
+		if err == nil && vm.Status.PrintableStatus != "" {
+			reason = string(vm.Status.PrintableStatus)
+		}
+	}
+	elapsed := now.Sub(wake.startedAt).Round(time.Second)
+	message := fmt.Sprintf("VM not Running %s after its start for the wake from %s (WolConfig %s): %s",
+		elapsed, wakeOriginDescription(wake.origin), vmInfo.ConfigName, reason)
+
+	WakeFailuresTotal.WithLabelValues(vmInfo.ConfigName, reason).Inc()
+	a.log.Info("VM started for a wake is not Running", "vm", vmInfo.Name, "namespace", vmInfo.Namespace,
+		"wolconfig", vmInfo.ConfigName, "reason", reason, "elapsed", elapsed.String())
+
+	if a.recorder != nil {
+		if err == nil {
+			a.recorder.Event(vm, corev1.EventTypeWarning, EventReasonNotRunning, message)
+		}
+		if config := a.mapper.wolConfig(vmInfo.ConfigName); config != nil {
+			a.recorder.Eventf(config, corev1.EventTypeWarning, EventReasonNotRunning, "VM %s/%s: %s",
+				vmInfo.Namespace, vmInfo.Name, message)
+		}
+	}
+	if a.auditor != nil {
+		since := wake.origin.ReceivedAt
+		if since.IsZero() {
+			since = wake.startedAt
+		}
+		// L'origine dei pacchetti è wol/<IP sorgente>: il record usa i campi di auditEvent
+		source, sourceIP := wake.origin.Source, ""
+		if ip, ok := strings.CutPrefix(source, "wol/"); ok {
+			source, sourceIP = "packet", ip
+		}
+		a.auditor.Write(AuditRecord{
+			Time:      now.UTC(),
+			WolConfig: vmInfo.ConfigName,
+			Action:    "wake",
+			Decision:  AuditDecisionFailed,
+			Status:    wolv1.ResponseStatus_VM_START_INITIATED.String(),
+			Reason:    message,
+			Namespace: vmInfo.Namespace,
+			VM:        vmInfo.Name,
+			Source:    source,
+			Node:      wake.origin.Node,
+			SourceIP:  sourceIP,
+			Trigger:   wake.origin.Reason,
+			LatencyMs: now.Sub(since).Milliseconds(),
+		})
+	}
+}
+
+// wakeOriginDescription describes the origin of a wake for the Events
+func wakeOriginDescription(origin wakeOrigin) string {
+	if origin.Node != "" {
+		return fmt.Sprintf("%s on node %s", origin.Source, origin.Node)
+	}
+	if origin.Source != "" {
+		return origin.Source
+	}
+	return "unknown source"
+}
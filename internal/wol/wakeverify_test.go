@@ -0,0 +1,113 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/tools/record"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestAggregator_WakeVerification(t *testing.T) {
+	ctx := context.Background()
+	unschedulable := newWatchVM("desktop", nil)
+	unschedulable.Namespace = "default"
+	unschedulable.Status.PrintableStatus = kubevirtv1.VirtualMachineStatusUnschedulable
+	mapper := NewMACMapper(newPolicyClient(t, unschedulable), logr.Discard())
+	config := &wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:00:00:01", VMName: "desktop", Namespace: "default"},
+				{MACAddress: "52:54:00:00:00:02", VMName: "server", Namespace: "default"},
+				{MACAddress: "52:54:00:00:00:03", VMName: "broken", Namespace: "default"},
+			},
+			WakeVerification: &wolv1beta1.WakeVerificationSpec{Enabled: true, TimeoutSeconds: 60},
+		},
+	}
+	config.Name = "verified"
+	mapper.UpdateConfig(config)
+	if err := mapper.RefreshMapping(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	agg := NewAggregator(mapper, &policyStarter{actions: make(map[string]string)}, logr.Discard())
+	recorder := record.NewFakeRecorder(10)
+	agg.SetEventRecorder(recorder)
+
+	for _, mac := range []string{"52:54:00:00:00:01", "52:54:00:00:00:02", "52:54:00:00:00:03"} {
+		resp, _ := agg.ReportWOLEvent(ctx, &wolv1.WOLEvent{MacAddress: mac, NodeName: "node-a"})
+		if resp.Status != wolv1.ResponseStatus_VM_START_INITIATED {
+			t.Fatalf("Expected the VM of %s to be started, got %v: %s", mac, resp.Status, resp.Message)
+		}
+	}
+	for len(recorder.Events) > 0 {
+		<-recorder.Events // WokeByWOL
+	}
+
+	// Il server arriva a Running in tempo, la VMI di broken fallisce
+	running := &kubevirtv1.VirtualMachineInstance{}
+	running.Namespace, running.Name = "default", "server"
+	running.Status.Phase = kubevirtv1.Running
+	agg.observeVMI(ctx, running, time.Now())
+	failed := &kubevirtv1.VirtualMachineInstance{}
+	failed.Namespace, failed.Name = "default", "broken"
+	failed.Status.Phase = kubevirtv1.Failed
+	agg.observeVMI(ctx, failed, time.Now())
+	if got := testutil.ToFloat64(WakeFailuresTotal.WithLabelValues("verified", wakeFailureVMIFailed)); got != 1 {
+		t.Errorf("Expected the failed VMI to be counted, got %v", got)
+	}
+
+	// Prima della scadenza nessuna segnalazione
+	agg.checkWakes(ctx, time.Now())
+	if len(agg.wakes.pending) != 1 {
+		t.Fatalf("Expected the desktop still pending, got %v", agg.wakes.pending)
+	}
+	agg.checkWakes(ctx, time.Now().Add(61*time.Second))
+	reason := string(kubevirtv1.VirtualMachineStatusUnschedulable)
+	if got := testutil.ToFloat64(WakeFailuresTotal.WithLabelValues("verified", reason)); got != 1 {
+		t.Errorf("Expected the desktop to be counted with its status, got %v", got)
+	}
+	if len(agg.wakes.pending) != 0 {
+		t.Errorf("Expected no pending wake left, got %v", agg.wakes.pending)
+	}
+
+	// Event sulla WolConfig per broken; su VM e WolConfig per il desktop
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 Events, got %v", events)
+	}
+	for _, event := range events {
+		if !strings.HasPrefix(event, "Warning "+EventReasonNotRunning) {
+			t.Errorf("Expected a %s Warning, got %q", EventReasonNotRunning, event)
+		}
+	}
+	if !strings.Contains(events[2], reason) {
+		t.Errorf("Expected the status of the VM in the Event, got %q", events[2])
+	}
+}
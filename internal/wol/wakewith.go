@@ -0,0 +1,156 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// AnnotationWakeWith lists (comma-separated, <namespace>/<vm> or <vm> for the
+// namespace of the annotated VM) the VMs started, in order, after the VM is woken
+const AnnotationWakeWith = "wol.pillon.org/wake-with"
+
+// vmWakeWith returns the dependencies of a VM as comma-separated <namespace>/<vm>
+// keys, in the order of the annotation. The VM itself and repeated entries are dropped.
+func vmWakeWith(vm *kubevirtv1.VirtualMachine) string {
+	var deps []string
+	for _, entry := range strings.Split(vm.Annotations[AnnotationWakeWith], ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		namespace, name, found := strings.Cut(entry, "/")
+		if !found {
+			namespace, name = vm.Namespace, entry
+		}
+		if namespace == "" || name == "" {
+			continue
+		}
+		key := vmIndexKey(namespace, name)
+		if key == vmIndexKey(vm.Namespace, vm.Name) || slices.Contains(deps, key) {
+			continue
+		}
+		deps = append(deps, key)
+	}
+	return strings.Join(deps, ",")
+}
+
+// startDependencies starts, in order, the dependencies of a woken VM. Each one
+// must be managed by a WolConfig and is checked against its own SecureOn policy
+// and WolPolicy, like the VM itself; a relay only starts the VMs of its own
+// WolConfig. event is nil for wake requests without a magic packet.
+// Dependencies are not followed transitively.
+func (a *Aggregator) startDependencies(ctx context.Context, vmInfo VMInfo, event *wolv1.WOLEvent, source string, startTime time.Time) []*wolv1.WakeDependency {
+	if vmInfo.WakeWith == "" {
+		return nil
+	}
+	relay, fromRelay := relayFromContext(ctx)
+	var results []*wolv1.WakeDependency
+	for _, key := range strings.Split(vmInfo.WakeWith, ",") {
+		namespace, name, _ := strings.Cut(key, "/")
+		result := &wolv1.WakeDependency{Namespace: namespace, Name: name}
+		results = append(results, result)
+
+		dep, found := a.mapper.LookupVM(namespace, name)
+		if found && fromRelay && dep.ConfigName != relay.WolConfig {
+			found = false
+		}
+		if !found {
+			result.Status = wolv1.ResponseStatus_VM_NOT_FOUND
+			result.Message = fmt.Sprintf("VM %s is not managed by any WolConfig", key)
+			WakeDependenciesTotal.WithLabelValues(result.Status.String()).Inc()
+			continue
+		}
+
+		resp := a.checkDependencySecureOn(event, dep, startTime)
+		if resp == nil {
+			var action wolv1beta1.WolPolicyAction
+			action, resp = a.enforcePolicy(ctx, dep, source, startTime)
+			if resp == nil {
+				resp = a.startDependency(ctx, vmInfo, dep, action)
+			}
+		}
+		result.Status, result.Message = resp.Status, resp.Message
+		WakeDependenciesTotal.WithLabelValues(result.Status.String()).Inc()
+		if event != nil {
+			a.recordKubeEvents(ctx, event, dep, resp)
+		}
+	}
+	return results
+}
+
+// checkDependencySecureOn applies the SecureOn policy of a dependency: the
+// magic packet must carry its password, and a wake request without a packet
+// cannot start a dependency that requires one
+func (a *Aggregator) checkDependencySecureOn(event *wolv1.WOLEvent, dep VMInfo, startTime time.Time) *wolv1.WOLEventResponse {
+	if event != nil {
+		return a.enforceSecureOn(event, dep, startTime)
+	}
+	if dep.SecureOnPolicy != wolv1beta1.SecureOnPolicyRequire {
+		return nil
+	}
+	SecureOnRejectedTotal.WithLabelValues(dep.ConfigName, string(secureOnMissing)).Inc()
+	return &wolv1.WOLEventResponse{
+		Status:  wolv1.ResponseStatus_SECURE_ON_PASSWORD_MISSING,
+		Message: fmt.Sprintf("VM %s/%s requires a SecureOn password", dep.Namespace, dep.Name),
+	}
+}
+
+// startDependency starts a dependency with the identity of its own WolConfig
+func (a *Aggregator) startDependency(ctx context.Context, vmInfo, dep VMInfo, action wolv1beta1.WolPolicyAction) *wolv1.WOLEventResponse {
+	if a.mapper.DryRun(dep.ConfigName) {
+		a.skipDryRun(dep.ConfigName, string(action), vmIndexKey(dep.Namespace, dep.Name))
+		return &wolv1.WOLEventResponse{Status: wolv1.ResponseStatus_DRY_RUN,
+			Message: fmt.Sprintf("Dry run: VM would be woken with action %s (matched by WolConfig %s)", action, dep.ConfigName)}
+	}
+	a.recordDemand(dep)
+	if err := a.startVM(ctx, dep, action); err != nil {
+		if !errors.Is(err, errStartsThrottled) {
+			a.log.Error(err, "Failed to start VM dependency", "vm", dep.Name, "namespace", dep.Namespace,
+				"wokenVM", vmInfo.Name, "wokenNamespace", vmInfo.Namespace, "wolconfig", dep.ConfigName)
+			ErrorsTotal.Inc()
+		}
+		status, message := a.startFailure(ctx, dep, action, err)
+		return &wolv1.WOLEventResponse{Status: status, Message: message}
+	}
+	VMStartedTotal.Inc()
+	a.log.Info("Started VM dependency", "vm", dep.Name, "namespace", dep.Namespace,
+		"wokenVM", vmInfo.Name, "wokenNamespace", vmInfo.Namespace, "wolconfig", dep.ConfigName)
+	return &wolv1.WOLEventResponse{Status: wolv1.ResponseStatus_VM_START_INITIATED,
+		Message: fmt.Sprintf("VM start initiated (matched by WolConfig %s)", dep.ConfigName)}
+}
+
+// dependenciesMessage summarizes the started dependencies for the response message
+func dependenciesMessage(deps []*wolv1.WakeDependency) string {
+	started := 0
+	for _, dep := range deps {
+		if dep.Status == wolv1.ResponseStatus_VM_START_INITIATED {
+			started++
+		}
+	}
+	return fmt.Sprintf("; %d of %d dependencies started (wake-with)", started, len(deps))
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// orderedStarter records the order of the started VMs
+type orderedStarter struct {
+	mu      sync.Mutex
+	started []string
+}
+
+func (s *orderedStarter) StartVMAs(_ context.Context, _, namespace, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = append(s.started, vmIndexKey(namespace, name))
+	return nil
+}
+func (s *orderedStarter) QueuedWakes() int { return 0 }
+
+func TestVMWakeWith(t *testing.T) {
+	vm := newWatchVM("app", nil)
+	vm.Annotations = map[string]string{AnnotationWakeWith: " db, other/cache,,tenant/app, db, /x, y/"}
+	if got := vmWakeWith(vm); got != "tenant/db,other/cache" {
+		t.Errorf("Expected tenant/db,other/cache, got %q", got)
+	}
+	if got := vmWakeWith(&kubevirtv1.VirtualMachine{}); got != "" {
+		t.Errorf("Expected no dependency without the annotation, got %q", got)
+	}
+}
+
+func TestAggregator_WakeWith(t *testing.T) {
+	app := newWatchVM("app", nil, "52:54:00:00:00:01")
+	app.Annotations = map[string]string{AnnotationWakeWith: "db,other/cache,missing"}
+	db := newWatchVM("db", nil, "52:54:00:00:00:02")
+	cache := newWatchVM("cache", nil, "52:54:00:00:00:03")
+	cache.Namespace = "other"
+	mapper := NewMACMapper(newPolicyClient(t, app, db, cache), logr.Discard())
+	config := conflictTestConfig("all", time.Hour, 0, "")
+	mapper.UpdateConfig(&config)
+	if err := mapper.RefreshMapping(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	starter := &orderedStarter{}
+	agg := NewAggregator(mapper, starter, logr.Discard())
+
+	resp, _ := agg.ReportWOLEvent(context.Background(), &wolv1.WOLEvent{MacAddress: "52:54:00:00:00:01"})
+	if resp.Status != wolv1.ResponseStatus_VM_START_INITIATED {
+		t.Fatalf("Expected VM_START_INITIATED, got %v: %s", resp.Status, resp.Message)
+	}
+	if want := []string{"tenant/app", "tenant/db", "other/cache"}; !slices.Equal(starter.started, want) {
+		t.Errorf("Expected the VMs started in order %v, got %v", want, starter.started)
+	}
+	var got []string
+	for _, dep := range resp.Dependencies {
+		got = append(got, dep.Namespace+"/"+dep.Name+"="+dep.Status.String())
+	}
+	want := []string{"tenant/db=VM_START_INITIATED", "other/cache=VM_START_INITIATED", "tenant/missing=VM_NOT_FOUND"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected dependencies %v, got %v", want, got)
+	}
+	if !strings.Contains(resp.Message, "2 of 3 dependencies started") {
+		t.Errorf("Expected the dependencies in the message, got %q", resp.Message)
+	}
+
+	// Anche una richiesta di wake senza pacchetto avvia le dipendenze
+	starter.started = nil
+	resp, _ = agg.RequestWake(context.Background(), &wolv1.WakeRequest{Namespace: "tenant", Name: "app", Source: APIWakeSource})
+	if len(resp.Dependencies) != 3 || len(starter.started) != 3 {
+		t.Errorf("Expected the dependencies started by the wake request, got %v (started %v)", resp.Dependencies, starter.started)
+	}
+}
@@ -0,0 +1,320 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// watchdogInterval is how often the agent components are checked
+	watchdogInterval = 5 * time.Second
+	// watchdogStaleAfter is how long a listener loop may go without a heartbeat.
+	// Loops wake up at least every second (read timeout), so this means stuck.
+	watchdogStaleAfter = 15 * time.Second
+	// watchdogFailureThreshold is the number of consecutive failed checks
+	// (for the listeners, each followed by a restart) after which the agent reports not ready
+	watchdogFailureThreshold = 3
+	// maxListenerReadErrors is the number of consecutive read errors after which
+	// a listener loop exits, leaving the socket to be recreated by the watchdog
+	maxListenerReadErrors = 10
+	// grpcMaxRPCFailures is the number of consecutive RPCs that could not reach
+	// the operator after which the gRPC connection is considered unhealthy
+	grpcMaxRPCFailures = 3
+	// grpcNotReadyAfter is how long the connection may stay out of Ready (while
+	// grpc-go backs off and reconnects by itself) before it is considered unhealthy
+	grpcNotReadyAfter = 30 * time.Second
+
+	componentUDP  = "udp"
+	componentUDP6 = "udp6"
+	componentGRPC = "grpc"
+	// componentRawPrefix is followed by the interface name
+	componentRawPrefix = "raw:"
+)
+
+// componentHealth is the watchdog state of a single agent component
+type componentHealth struct {
+	failures int // consecutive failed checks
+	restarts int64
+}
+
+// agentWatchdog keeps the health history of the agent components.
+// Failed listeners are restarted right away; readiness only turns
+// unhealthy once a component keeps failing after its restarts.
+// The gRPC connection is only observed, grpc-go reconnects it by itself.
+type agentWatchdog struct {
+	mu         sync.Mutex
+	components map[string]*componentHealth
+}
+
+func newAgentWatchdog() *agentWatchdog {
+	return &agentWatchdog{components: make(map[string]*componentHealth)}
+}
+
+func (w *agentWatchdog) component(name string) *componentHealth {
+	c := w.components[name]
+	if c == nil {
+		c = &componentHealth{}
+		w.components[name] = c
+	}
+	return c
+}
+
+// report records the outcome of a check and returns the consecutive failures
+func (w *agentWatchdog) report(name string, healthy bool) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	c := w.component(name)
+	if healthy {
+		c.failures = 0
+	} else {
+		c.failures++
+	}
+	return c.failures
+}
+
+// restarted counts a restart of the component
+func (w *agentWatchdog) restarted(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	c := w.component(name)
+	c.restarts++
+}
+
+// forget drops a component that no longer exists (e.g. a removed interface)
+func (w *agentWatchdog) forget(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.components, name)
+}
+
+// unhealthy returns the components that failed at least threshold consecutive checks
+func (w *agentWatchdog) unhealthy(threshold int) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var names []string
+	for name, c := range w.components {
+		if c.failures >= threshold {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// snapshot returns the restarts and consecutive failures of each component, sorted by name
+func (w *agentWatchdog) snapshot() (names []string, restarts []int64, failures []int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for name := range w.components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		restarts = append(restarts, w.components[name].restarts)
+		failures = append(failures, w.components[name].failures)
+	}
+	return names, restarts, failures
+}
+
+// trackedConn is the gRPC connection to the operator. It counts the consecutive
+// RPCs that could not reach the operator and how long the connection has been
+// out of Ready, so the watchdog can judge it without replacing it: grpc-go
+// already reconnects with backoff, and a new connection would reset it.
+type trackedConn struct {
+	*grpc.ClientConn
+	rpcFailures   atomic.Int32
+	notReadySince atomic.Int64 // unix nano, 0 while Ready or Idle
+}
+
+func newTrackedConn(conn *grpc.ClientConn) *trackedConn {
+	return &trackedConn{ClientConn: conn}
+}
+
+func (c *trackedConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	err := c.ClientConn.Invoke(ctx, method, args, reply, opts...)
+	c.record(err)
+	return err
+}
+
+func (c *trackedConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	stream, err := c.ClientConn.NewStream(ctx, desc, method, opts...)
+	c.record(err)
+	return stream, err
+}
+
+// record updates the failure streak: any answer from the operator, even an
+// error, resets it, while Unavailable and DeadlineExceeded extend it
+func (c *trackedConn) record(err error) {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		c.rpcFailures.Add(1)
+	default:
+		c.rpcFailures.Store(0)
+	}
+}
+
+// healthy returns false once too many RPCs failed in a row or the connection
+// has been out of Ready for grpcNotReadyAfter. Idle (no RPC in flight) is healthy.
+func (c *trackedConn) healthy(now time.Time) bool {
+	switch c.GetState() {
+	case connectivity.Ready, connectivity.Idle:
+		c.notReadySince.Store(0)
+	default:
+		c.notReadySince.CompareAndSwap(0, now.UnixNano())
+	}
+	if c.rpcFailures.Load() >= grpcMaxRPCFailures {
+		return false
+	}
+	since := c.notReadySince.Load()
+	return since == 0 || now.Sub(time.Unix(0, since)) < grpcNotReadyAfter
+}
+
+// runWatchdog periodically checks the agent components and recreates the failed ones
+func (a *Agent) runWatchdog(ctx context.Context) {
+	defer a.wg.Done()
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.checkComponents(ctx, time.Now())
+		}
+	}
+}
+
+// checkComponents checks every component once, restarting the unhealthy ones
+func (a *Agent) checkComponents(ctx context.Context, now time.Time) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	// UDP listener
+	if a.enableUDP && a.udp4 {
+		if failures := a.watchdog.report(componentUDP, a.udpHealthy(now)); failures > 0 {
+			a.log.Info("Watchdog: UDP listener unhealthy, recreating it", "failures", failures)
+			a.watchdog.restarted(componentUDP)
+			if err := a.restartUDP(ctx); err != nil {
+				a.log.Error(err, "Watchdog: failed to recreate UDP listener")
+			}
+		}
+	}
+	if a.enableUDP && a.udp6 {
+		if failures := a.watchdog.report(componentUDP6, a.udp6Healthy(now)); failures > 0 {
+			a.log.Info("Watchdog: IPv6 UDP listener unhealthy, recreating it", "failures", failures)
+			a.watchdog.restarted(componentUDP6)
+			if err := a.restartUDP6(ctx); err != nil {
+				a.log.Error(err, "Watchdog: failed to recreate IPv6 UDP listener")
+			}
+		}
+	}
+
+	// Raw listeners
+	a.rawMu.Lock()
+	listeners := append([]*RawListener(nil), a.rawListeners...)
+	a.rawMu.Unlock()
+	for _, l := range listeners {
+		name := componentRawPrefix + l.interfaceName
+		failures := a.watchdog.report(name, l.Healthy(now, watchdogStaleAfter))
+		if failures == 0 {
+			continue
+		}
+		if _, err := net.InterfaceByName(l.interfaceName); err != nil {
+			a.log.Info("Watchdog: interface disappeared, dropping its raw listener", "iface", l.interfaceName)
+			a.removeRawListener(l)
+			a.watchdog.forget(name)
+			continue
+		}
+		a.log.Info("Watchdog: raw listener unhealthy, recreating it", "iface", l.interfaceName, "failures", failures)
+		a.watchdog.restarted(name)
+		a.removeRawListener(l)
+		if err := a.startInterfaceListener(ctx, l.interfaceName); err != nil {
+			a.log.Error(err, "Watchdog: failed to recreate raw listener", "iface", l.interfaceName)
+		}
+	}
+
+	// gRPC client: never recreated, grpc-go reconnects by itself with backoff.
+	// The watchdog only tracks it so that readiness reflects an operator outage.
+	if a.grpcConn != nil {
+		if failures := a.watchdog.report(componentGRPC, a.grpcConn.healthy(now)); failures > 0 {
+			a.log.Info("Watchdog: gRPC connection to the operator unhealthy",
+				"state", a.grpcConn.GetState().String(),
+				"failedRPCs", a.grpcConn.rpcFailures.Load(),
+				"failures", failures)
+		}
+	}
+}
+
+// udpHealthy returns true if the UDP loop is alive and not failing on every read
+func (a *Agent) udpHealthy(now time.Time) bool {
+	a.udpMu.Lock()
+	conn := a.conn
+	a.udpMu.Unlock()
+	if conn == nil {
+		return false
+	}
+	return a.udpErrors.Load() < maxListenerReadErrors &&
+		now.Sub(time.Unix(0, a.udpHeartbeat.Load())) < watchdogStaleAfter
+}
+
+// restartUDP closes the UDP socket (ending its loop) and starts a new one
+func (a *Agent) restartUDP(ctx context.Context) error {
+	a.udpMu.Lock()
+	if a.conn != nil {
+		_ = a.conn.Close()
+		a.conn = nil
+	}
+	conn, err := a.openUDP()
+	if err != nil {
+		a.udpMu.Unlock()
+		return err
+	}
+	a.conn = conn
+	a.udpErrors.Store(0)
+	a.udpHeartbeat.Store(time.Now().UnixNano())
+	a.udpMu.Unlock()
+
+	a.wg.Add(1)
+	go a.listen(ctx, conn)
+	return nil
+}
+
+// removeRawListener stops a raw listener and removes it from the agent
+func (a *Agent) removeRawListener(l *RawListener) {
+	a.rawMu.Lock()
+	for i, existing := range a.rawListeners {
+		if existing == l {
+			a.rawListeners = append(a.rawListeners[:i], a.rawListeners[i+1:]...)
+			break
+		}
+	}
+	a.rawMu.Unlock()
+	l.Stop()
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+func TestAgentWatchdog_Threshold(t *testing.T) {
+	w := newAgentWatchdog()
+
+	for i := 1; i < watchdogFailureThreshold; i++ {
+		if got := w.report(componentUDP, false); got != i {
+			t.Fatalf("Expected %d consecutive failures, got %d", i, got)
+		}
+		w.restarted(componentUDP)
+	}
+	if len(w.unhealthy(watchdogFailureThreshold)) != 0 {
+		t.Fatal("Expected the component to stay ready below the threshold")
+	}
+
+	w.report(componentUDP, false)
+	if failing := w.unhealthy(watchdogFailureThreshold); len(failing) != 1 || failing[0] != componentUDP {
+		t.Fatalf("Expected udp to be failing, got %v", failing)
+	}
+
+	w.report(componentUDP, true)
+	if len(w.unhealthy(watchdogFailureThreshold)) != 0 {
+		t.Error("Expected a healthy check to reset the failures")
+	}
+	names, restarts, _ := w.snapshot()
+	if len(names) != 1 || restarts[0] != int64(watchdogFailureThreshold-1) {
+		t.Errorf("Unexpected restarts %v %v", names, restarts)
+	}
+
+	w.forget(componentUDP)
+	if names, _, _ := w.snapshot(); len(names) != 0 {
+		t.Errorf("Expected forgotten component to be dropped, got %v", names)
+	}
+}
+
+func TestAgent_WatchdogRecreatesUDPListener(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	agent := NewAgent(0, "test-node", "", logr.Discard()) // porta 0: effimera
+	conn, err := agent.openUDP()
+	if err != nil {
+		t.Fatalf("Failed to open UDP socket: %v", err)
+	}
+	agent.conn = conn
+	agent.udpHeartbeat.Store(time.Now().UnixNano())
+	agent.wg.Add(1)
+	go agent.listen(ctx, conn)
+
+	agent.checkComponents(ctx, time.Now())
+	if names, _, _ := agent.watchdog.snapshot(); len(names) != 1 {
+		t.Fatalf("Expected the UDP listener to be tracked, got %v", names)
+	}
+	if _, restarts, _ := agent.watchdog.snapshot(); restarts[0] != 0 {
+		t.Fatal("Expected no restart for a healthy listener")
+	}
+
+	// Il loop esce alla chiusura del socket e smette di aggiornare l'heartbeat
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	agent.checkComponents(ctx, time.Now().Add(2*watchdogStaleAfter))
+
+	agent.udpMu.Lock()
+	recreated := agent.conn
+	agent.udpMu.Unlock()
+	if recreated == nil || recreated == conn {
+		t.Fatal("Expected a new UDP socket")
+	}
+	if _, restarts, _ := agent.watchdog.snapshot(); restarts[0] != 1 {
+		t.Errorf("Expected 1 restart, got %d", restarts[0])
+	}
+
+	agent.checkComponents(ctx, time.Now())
+	if failing := agent.watchdog.unhealthy(1); len(failing) != 0 {
+		t.Errorf("Expected the recreated listener to be healthy, got %v", failing)
+	}
+
+	cancel()
+	agent.Stop()
+	agent.wg.Wait()
+}
+
+func TestAgent_WatchdogTracksGRPCWithoutRecreatingIt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	agent := NewAgent(0, "test-node", "127.0.0.1:1", logr.Discard()) // nessun operator in ascolto
+	conn, err := agent.dialOperator()
+	if err != nil {
+		t.Fatalf("Failed to create gRPC client: %v", err)
+	}
+	agent.grpcConn = newTrackedConn(conn)
+	agent.grpcClient = wolv1.NewWOLServiceClient(agent.grpcConn)
+
+	udp, err := agent.openUDP()
+	if err != nil {
+		t.Fatalf("Failed to open UDP socket: %v", err)
+	}
+	agent.conn = udp
+	agent.udpHeartbeat.Store(time.Now().UnixNano())
+	agent.wg.Add(1)
+	go agent.listen(ctx, udp)
+	defer func() {
+		cancel()
+		agent.Stop()
+		agent.wg.Wait()
+	}()
+
+	// Idle connection without RPCs: healthy
+	agent.checkComponents(ctx, time.Now())
+	if failing := agent.watchdog.unhealthy(1); len(failing) != 0 {
+		t.Fatalf("Expected an idle connection to be healthy, got %v", failing)
+	}
+
+	for i := 0; i < grpcMaxRPCFailures; i++ {
+		rpcCtx, rpcCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		_, err := agent.grpcClient.HealthCheck(rpcCtx, &wolv1.HealthCheckRequest{})
+		rpcCancel()
+		if err == nil {
+			t.Fatal("Expected the RPC to fail without an operator")
+		}
+	}
+
+	for i := 0; i < watchdogFailureThreshold; i++ {
+		agent.checkComponents(ctx, time.Now())
+	}
+	if failing := agent.watchdog.unhealthy(watchdogFailureThreshold); len(failing) != 1 || failing[0] != componentGRPC {
+		t.Fatalf("Expected grpc to be failing, got %v", failing)
+	}
+	if agent.grpcConn.ClientConn != conn {
+		t.Error("Expected the gRPC connection not to be replaced")
+	}
+	names, restarts, _ := agent.watchdog.snapshot()
+	for i, name := range names {
+		if name == componentGRPC && restarts[i] != 0 {
+			t.Errorf("Expected no gRPC restart, got %d", restarts[i])
+		}
+	}
+}
+
+func TestTrackedConn_NotReadyTimeout(t *testing.T) {
+	conn, err := grpc.NewClient("127.0.0.1:1", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracked := newTrackedConn(conn)
+	defer func() { _ = tracked.Close() }()
+
+	// Out of Ready since a while: unhealthy even without failed RPCs
+	now := time.Now()
+	tracked.Connect()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for state := tracked.GetState(); state == connectivity.Idle; state = tracked.GetState() {
+		if !tracked.WaitForStateChange(ctx, state) {
+			t.Fatal("Connection never left Idle")
+		}
+	}
+	if !tracked.healthy(now) {
+		t.Fatal("Expected the connection to be healthy right after leaving Ready")
+	}
+	if tracked.healthy(now.Add(grpcNotReadyAfter)) {
+		t.Error("Expected the connection to be unhealthy after grpcNotReadyAfter out of Ready")
+	}
+}
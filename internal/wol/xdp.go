@@ -0,0 +1,594 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The eBPF capture backend attaches to each interface an XDP program running
+// the same filter as the classic BPF program of the AF_PACKET socket
+// (translated by xdpProgram), which copies the matching frames to a perf
+// event ring per CPU. Every frame goes on to the kernel (XDP_PASS): the
+// program only looks at the frames the NIC accepts, without promiscuous mode
+// and without copying the rest of the traffic to userspace.
+// Requires Linux 5.9+ (BPF links) and CAP_BPF, CAP_PERFMON and CAP_NET_ADMIN.
+
+const (
+	xdpPass = 2 // XDP_PASS
+
+	bpfFuncPerfEventOutput = 25 // helper bpf_perf_event_output
+
+	// xdpCaptureBits: i frame sono copiati fino a 2^11-1 byte (più di un frame Ethernet)
+	xdpCaptureBits = 11
+	// xdpMetaSize: il campione porta la lunghezza copiata prima del frame
+	xdpMetaSize = 8
+	// xdpRingPages: pagine del ring perf di ogni CPU (potenza di 2)
+	xdpRingPages = 8
+
+	xdpProgramName = "kubevirt_wol"
+	// Licenza compatibile GPL, richiesta da bpf_perf_event_output
+	xdpLicense = "Dual BSD/GPL"
+)
+
+// Registri eBPF del programma tradotto: A e X del BPF classico, il contesto,
+// l'inizio e la fine del pacchetto, la lunghezza copiata
+const (
+	bpfR0 uint8 = iota
+	bpfR1
+	bpfR2
+	bpfR3
+	bpfR4
+	bpfR5
+	bpfR6
+	bpfR7
+	bpfR8
+	bpfR9
+	bpfR10
+
+	bpfRegA    = bpfR2
+	bpfRegX    = bpfR3
+	bpfRegCtx  = bpfR6
+	bpfRegData = bpfR7
+	bpfRegEnd  = bpfR8
+	bpfRegLen  = bpfR9
+)
+
+// Opcodes eBPF usati dalla traduzione
+const (
+	ebpfMovK64  = 0xb7
+	ebpfMovX64  = 0xbf
+	ebpfAddK64  = 0x07
+	ebpfAddX64  = 0x0f
+	ebpfLshK64  = 0x67
+	ebpfOrX64   = 0x4f
+	ebpfMovK32  = 0xb4
+	ebpfAndK32  = 0x54
+	ebpfLshK32  = 0x64
+	ebpfToBE    = 0xdc
+	ebpfLdxW    = 0x61
+	ebpfLdxH    = 0x69
+	ebpfLdxB    = 0x71
+	ebpfStxDW   = 0x7b
+	ebpfLdImm64 = 0x18
+	ebpfJa      = 0x05
+	ebpfJgtX    = 0x2d
+	ebpfCall    = 0x85
+	ebpfExit    = 0x95
+)
+
+// bpfInsn is an eBPF instruction. The register nibbles are in the order of a
+// little-endian host (dst in the low bits), like the agents' amd64 and arm64.
+type bpfInsn struct {
+	Code uint8
+	Regs uint8
+	Off  int16
+	Imm  int32
+}
+
+// ebpfAssembler builds an eBPF program whose jumps target labels, resolved
+// to relative offsets by assemble
+type ebpfAssembler struct {
+	program []bpfInsn
+	labels  map[string]int
+	jumps   map[int]string // pc del salto: etichetta di destinazione
+}
+
+// emit appends an instruction
+func (p *ebpfAssembler) emit(code, dst, src uint8, off int16, imm int32) {
+	p.program = append(p.program, bpfInsn{Code: code, Regs: dst | src<<4, Off: off, Imm: imm})
+}
+
+// jump appends a jump to label
+func (p *ebpfAssembler) jump(code, dst, src uint8, imm int32, label string) {
+	if p.jumps == nil {
+		p.jumps = make(map[int]string)
+	}
+	p.jumps[len(p.program)] = label
+	p.emit(code, dst, src, 0, imm)
+}
+
+// label marks the next instruction
+func (p *ebpfAssembler) label(name string) {
+	if p.labels == nil {
+		p.labels = make(map[string]int)
+	}
+	p.labels[name] = len(p.program)
+}
+
+// referenced returns true if a jump targets label
+func (p *ebpfAssembler) referenced(label string) bool {
+	for _, l := range p.jumps {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// assemble resolves the jumps
+func (p *ebpfAssembler) assemble() ([]bpfInsn, error) {
+	for pc, label := range p.jumps {
+		target, ok := p.labels[label]
+		if !ok {
+			return nil, fmt.Errorf("jump to undefined label %s", label)
+		}
+		p.program[pc].Off = int16(target - pc - 1)
+	}
+	return p.program, nil
+}
+
+// load appends a bounds-checked load of size bytes at base+k into dst, in
+// host order. Frames too short for the load are not captured, like the
+// classic BPF program does.
+func (p *ebpfAssembler) load(dst, base uint8, k uint32, size int) error {
+	if k > 0x7fff-4 {
+		return fmt.Errorf("packet offset %d out of range", k)
+	}
+	p.emit(ebpfMovX64, bpfR5, base, 0, 0)
+	p.emit(ebpfAddK64, bpfR5, 0, 0, int32(k)+int32(size))
+	p.jump(ebpfJgtX, bpfR5, bpfRegEnd, 0, "drop")
+	code := map[int]uint8{1: ebpfLdxB, 2: ebpfLdxH, 4: ebpfLdxW}[size]
+	p.emit(code, dst, base, int16(k), 0)
+	if size > 1 {
+		// I campi dei pacchetti sono big-endian, il BPF classico li carica nell'ordine dell'host
+		p.emit(ebpfToBE, dst, 0, 0, int32(size*8))
+	}
+	return nil
+}
+
+// xdpProgram translates the classic BPF capture program of a raw listener
+// into an XDP program sending the accepted frames to the perf event array
+// perfMap. Only the instructions emitted by captureSpec.filter are supported.
+func xdpProgram(filter []unix.SockFilter, perfMap int) ([]bpfInsn, error) {
+	var p ebpfAssembler
+	p.emit(ebpfMovX64, bpfRegCtx, bpfR1, 0, 0)
+	// xdp_md: data e data_end
+	p.emit(ebpfLdxW, bpfRegData, bpfRegCtx, 0, 0)
+	p.emit(ebpfLdxW, bpfRegEnd, bpfRegCtx, 4, 0)
+	p.emit(ebpfMovK64, bpfRegA, 0, 0, 0)
+	p.emit(ebpfMovK64, bpfRegX, 0, 0, 0)
+
+	classic := func(pc int) string { return "c" + strconv.Itoa(pc) }
+	sizes := map[uint16]int{0x00: 4, 0x08: 2, 0x10: 1} // BPF_W, BPF_H, BPF_B
+	for pc, ins := range filter {
+		p.label(classic(pc))
+		var err error
+		switch ins.Code {
+		case 0x20, 0x28, 0x30: // ld{,h,b} [k]
+			err = p.load(bpfRegA, bpfRegData, ins.K, sizes[ins.Code&0x18])
+		case 0x40, 0x48, 0x50: // ld{,h,b} [x+k]
+			p.emit(ebpfMovX64, bpfR4, bpfRegData, 0, 0)
+			p.emit(ebpfAddX64, bpfR4, bpfRegX, 0, 0)
+			err = p.load(bpfRegA, bpfR4, ins.K, sizes[ins.Code&0x18])
+		case 0xb1: // ldxb 4*([k]&0xf)
+			err = p.load(bpfRegX, bpfRegData, ins.K, 1)
+			p.emit(ebpfAndK32, bpfRegX, 0, 0, 0xf)
+			p.emit(ebpfLshK32, bpfRegX, 0, 0, 2)
+		case 0x54: // and #k
+			p.emit(ebpfAndK32, bpfRegA, 0, 0, int32(ins.K))
+		case 0x15, 0x25, 0x35, 0x45: // jeq, jgt, jge, jset #k
+			// Stesso confronto su 32 bit (BPF_JMP32), BPF_K
+			p.jump(unix.BPF_JMP32|uint8(ins.Code&0xf0), bpfRegA, 0, int32(ins.K), classic(pc+1+int(ins.Jt)))
+			if ins.Jf != 0 {
+				p.jump(ebpfJa, 0, 0, 0, classic(pc+1+int(ins.Jf)))
+			}
+		case 0x06: // ret #k
+			if ins.K == 0 {
+				p.jump(ebpfJa, 0, 0, 0, "drop")
+			} else {
+				p.jump(ebpfJa, 0, 0, 0, "accept")
+			}
+		default:
+			return nil, fmt.Errorf("unsupported classic BPF instruction %#x at %d", ins.Code, pc)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.referenced("accept") {
+		p.label("accept")
+		// Lunghezza copiabile, min(data_end-data, 2047), per bisezione: il
+		// verifier non ammette la differenza tra data_end e data
+		p.emit(ebpfMovK64, bpfRegLen, 0, 0, 0)
+		for bit := xdpCaptureBits - 1; bit >= 0; bit-- {
+			skip := "len" + strconv.Itoa(bit)
+			p.emit(ebpfMovX64, bpfR4, bpfRegData, 0, 0)
+			p.emit(ebpfAddX64, bpfR4, bpfRegLen, 0, 0)
+			p.emit(ebpfAddK64, bpfR4, 0, 0, 1<<bit)
+			p.jump(ebpfJgtX, bpfR4, bpfRegEnd, 0, skip)
+			p.emit(ebpfAddK64, bpfRegLen, 0, 0, 1<<bit)
+			p.label(skip)
+		}
+		// bpf_perf_event_output(ctx, map, BPF_F_CURRENT_CPU | len<<32, &len, 8):
+		// il kernel copia len byte del pacchetto dopo i metadati
+		p.emit(ebpfStxDW, bpfR10, bpfRegLen, -xdpMetaSize, 0)
+		p.emit(ebpfMovX64, bpfR1, bpfRegCtx, 0, 0)
+		p.emit(ebpfLdImm64, bpfR2, unix.BPF_PSEUDO_MAP_FD, 0, int32(perfMap))
+		p.emit(0, 0, 0, 0, 0)
+		p.emit(ebpfMovX64, bpfR3, bpfRegLen, 0, 0)
+		p.emit(ebpfLshK64, bpfR3, 0, 0, 32)
+		p.emit(ebpfMovK32, bpfR0, 0, 0, -1) // BPF_F_CURRENT_CPU, senza estensione del segno
+		p.emit(ebpfOrX64, bpfR3, bpfR0, 0, 0)
+		p.emit(ebpfMovX64, bpfR4, bpfR10, 0, 0)
+		p.emit(ebpfAddK64, bpfR4, 0, 0, -xdpMetaSize)
+		p.emit(ebpfMovK64, bpfR5, 0, 0, xdpMetaSize)
+		p.emit(ebpfCall, 0, 0, 0, bpfFuncPerfEventOutput)
+	}
+	// Il programma osserva soltanto: ogni frame prosegue verso il kernel
+	p.label("drop")
+	p.emit(ebpfMovK64, bpfR0, 0, 0, xdpPass)
+	p.emit(ebpfExit, 0, 0, 0, 0)
+	return p.assemble()
+}
+
+// bpfSyscall calls the bpf syscall with the attributes attr
+func bpfSyscall(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// bpfMapCreateAttr is the BPF_MAP_CREATE attribute
+type bpfMapCreateAttr struct {
+	MapType    uint32
+	KeySize    uint32
+	ValueSize  uint32
+	MaxEntries uint32
+	MapFlags   uint32
+}
+
+// bpfMapUpdateAttr is the BPF_MAP_UPDATE_ELEM attribute
+type bpfMapUpdateAttr struct {
+	MapFD uint32
+	_     uint32
+	Key   uint64
+	Value uint64
+	Flags uint64
+}
+
+// bpfProgLoadAttr is the BPF_PROG_LOAD attribute
+type bpfProgLoadAttr struct {
+	ProgType           uint32
+	InsnCnt            uint32
+	Insns              uint64
+	License            uint64
+	LogLevel           uint32
+	LogSize            uint32
+	LogBuf             uint64
+	KernVersion        uint32
+	ProgFlags          uint32
+	ProgName           [16]byte
+	ProgIfindex        uint32
+	ExpectedAttachType uint32
+}
+
+// bpfLinkCreateAttr is the BPF_LINK_CREATE attribute
+type bpfLinkCreateAttr struct {
+	ProgFD        uint32
+	TargetIfindex uint32
+	AttachType    uint32
+	Flags         uint32
+}
+
+// loadXDPProgram loads an XDP program, with the verifier log in the error
+func loadXDPProgram(insns []bpfInsn) (int, error) {
+	license := []byte(xdpLicense + "\x00")
+	attr := bpfProgLoadAttr{
+		ProgType:           unix.BPF_PROG_TYPE_XDP,
+		InsnCnt:            uint32(len(insns)),
+		Insns:              uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		License:            uint64(uintptr(unsafe.Pointer(&license[0]))),
+		ExpectedAttachType: unix.BPF_XDP,
+	}
+	copy(attr.ProgName[:], xdpProgramName)
+	fd, err := bpfSyscall(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil && !errors.Is(err, unix.EPERM) {
+		// Di nuovo con il log del verifier, per capire il rifiuto
+		log := make([]byte, 64*1024)
+		attr.LogLevel, attr.LogSize = 1, uint32(len(log))
+		attr.LogBuf = uint64(uintptr(unsafe.Pointer(&log[0])))
+		if _, retryErr := bpfSyscall(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); retryErr != nil {
+			lines := strings.Split(strings.TrimRight(unix.ByteSliceToString(log), "\n"), "\n")
+			err = fmt.Errorf("%w: %s", err, strings.Join(lines[max(0, len(lines)-5):], "; "))
+		}
+		runtime.KeepAlive(log)
+	}
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if err != nil {
+		return -1, fmt.Errorf("failed to load the XDP program: %w", err)
+	}
+	return fd, nil
+}
+
+// possibleCPUs returns the number of possible CPUs, the size of the perf event array
+func possibleCPUs() (int, error) {
+	data, err := os.ReadFile("/sys/devices/system/cpu/possible")
+	if err != nil {
+		return 0, err
+	}
+	return parseCPUCount(strings.TrimSpace(string(data)))
+}
+
+// parseCPUCount returns the highest CPU of a list like "0-3,8" plus one
+func parseCPUCount(list string) (int, error) {
+	highest := -1
+	for _, part := range strings.Split(list, ",") {
+		_, last, _ := strings.Cut(part, "-")
+		if last == "" {
+			last = part
+		}
+		n, err := strconv.Atoi(last)
+		if err != nil {
+			return 0, fmt.Errorf("invalid CPU list %q", list)
+		}
+		highest = max(highest, n)
+	}
+	return highest + 1, nil
+}
+
+// perfRing is the ring buffer of the perf event of a CPU
+type perfRing struct {
+	fd   int
+	mem  []byte
+	meta *unix.PerfEventMmapPage
+	data []byte
+}
+
+// openPerfRing opens the BPF output perf event of cpu and maps its ring
+func openPerfRing(cpu int) (*perfRing, error) {
+	attr := unix.PerfEventAttr{
+		Type:        unix.PERF_TYPE_SOFTWARE,
+		Config:      unix.PERF_COUNT_SW_BPF_OUTPUT,
+		Sample_type: unix.PERF_SAMPLE_RAW,
+		Sample:      1,
+		Wakeup:      1,
+	}
+	attr.Size = uint32(unsafe.Sizeof(attr))
+	fd, err := unix.PerfEventOpen(&attr, -1, cpu, -1, unix.PERF_FLAG_FD_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the perf event of CPU %d: %w", cpu, err)
+	}
+	pageSize := os.Getpagesize()
+	mem, err := unix.Mmap(fd, 0, (1+xdpRingPages)*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to map the perf ring of CPU %d: %w", cpu, err)
+	}
+	if err := unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_ENABLE, 0); err != nil {
+		_ = unix.Munmap(mem)
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to enable the perf event of CPU %d: %w", cpu, err)
+	}
+	return &perfRing{
+		fd:   fd,
+		mem:  mem,
+		meta: (*unix.PerfEventMmapPage)(unsafe.Pointer(&mem[0])),
+		data: mem[pageSize:],
+	}, nil
+}
+
+// read appends to buf n bytes of the ring at offset off, across the wrap
+func (r *perfRing) read(buf []byte, off uint64, n int) []byte {
+	start := int(off % uint64(len(r.data)))
+	if end := start + n; end <= len(r.data) {
+		return append(buf, r.data[start:end]...)
+	}
+	buf = append(buf, r.data[start:]...)
+	return append(buf, r.data[:n-(len(r.data)-start)]...)
+}
+
+// drain passes the samples in the ring to handle and returns the number of
+// samples the kernel lost because the ring was full
+func (r *perfRing) drain(buf []byte, handle func(sample []byte)) (lost uint64) {
+	head := atomic.LoadUint64(&r.meta.Data_head)
+	tail := atomic.LoadUint64(&r.meta.Data_tail)
+	for tail < head {
+		header := r.read(buf[:0], tail, 8)
+		recordType := binary.LittleEndian.Uint32(header)
+		size := uint64(binary.LittleEndian.Uint16(header[6:]))
+		if size < 8 {
+			break // record corrotto: il ring è ripreso dalla testa
+		}
+		switch recordType {
+		case unix.PERF_RECORD_SAMPLE:
+			raw := r.read(buf[:0], tail+8, 4)
+			buf = r.read(buf[:0], tail+12, int(binary.LittleEndian.Uint32(raw)))
+			handle(buf)
+		case unix.PERF_RECORD_LOST:
+			lost += binary.LittleEndian.Uint64(r.read(buf[:0], tail+16, 8))
+		}
+		tail += size
+	}
+	atomic.StoreUint64(&r.meta.Data_tail, head)
+	return lost
+}
+
+func (r *perfRing) close() {
+	_ = unix.Munmap(r.mem)
+	unix.Close(r.fd)
+}
+
+var raiseMemlock sync.Once
+
+// xdpCapture is the XDP program of an interface and the perf rings its
+// frames are read from
+type xdpCapture struct {
+	prog    int
+	perfMap int
+	link    int
+	epoll   int
+	rings   []*perfRing
+	buf     []byte
+}
+
+// openXDPCapture attaches to the interface ifindex an XDP program capturing
+// the frames accepted by the classic BPF program filter. The program is
+// attached in native mode when the driver supports it, generic otherwise.
+func openXDPCapture(ifindex int, filter []unix.SockFilter) (c *xdpCapture, err error) {
+	// Kernel precedenti alla 5.11 contano le mappe BPF nel limite di memlock
+	raiseMemlock.Do(func() {
+		_ = unix.Setrlimit(unix.RLIMIT_MEMLOCK, &unix.Rlimit{Cur: unix.RLIM_INFINITY, Max: unix.RLIM_INFINITY})
+	})
+	cpus, err := possibleCPUs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the possible CPUs: %w", err)
+	}
+
+	c = &xdpCapture{prog: -1, perfMap: -1, link: -1, epoll: -1, buf: make([]byte, 0, 1<<xdpCaptureBits+xdpMetaSize)}
+	defer func() {
+		if err != nil {
+			c.close()
+		}
+	}()
+	mapAttr := bpfMapCreateAttr{MapType: unix.BPF_MAP_TYPE_PERF_EVENT_ARRAY, KeySize: 4, ValueSize: 4, MaxEntries: uint32(cpus)}
+	if c.perfMap, err = bpfSyscall(unix.BPF_MAP_CREATE, unsafe.Pointer(&mapAttr), unsafe.Sizeof(mapAttr)); err != nil {
+		return nil, fmt.Errorf("failed to create the perf event array: %w (requires CAP_BPF)", err)
+	}
+	if c.epoll, err = unix.EpollCreate1(unix.EPOLL_CLOEXEC); err != nil {
+		return nil, fmt.Errorf("failed to create the epoll instance: %w", err)
+	}
+	for cpu := range cpus {
+		ring, err := openPerfRing(cpu)
+		if err != nil {
+			if errors.Is(err, unix.ENODEV) {
+				continue // CPU offline
+			}
+			return nil, fmt.Errorf("%w (requires CAP_PERFMON)", err)
+		}
+		c.rings = append(c.rings, ring)
+		key, value := uint32(cpu), uint32(ring.fd)
+		update := bpfMapUpdateAttr{
+			MapFD: uint32(c.perfMap),
+			Key:   uint64(uintptr(unsafe.Pointer(&key))),
+			Value: uint64(uintptr(unsafe.Pointer(&value))),
+		}
+		_, err = bpfSyscall(unix.BPF_MAP_UPDATE_ELEM, unsafe.Pointer(&update), unsafe.Sizeof(update))
+		runtime.KeepAlive(&key)
+		runtime.KeepAlive(&value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add the perf event of CPU %d: %w", cpu, err)
+		}
+		event := unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(ring.fd)}
+		if err := unix.EpollCtl(c.epoll, unix.EPOLL_CTL_ADD, ring.fd, &event); err != nil {
+			return nil, fmt.Errorf("failed to poll the perf event of CPU %d: %w", cpu, err)
+		}
+	}
+
+	insns, err := xdpProgram(filter, c.perfMap)
+	if err != nil {
+		return nil, err
+	}
+	if c.prog, err = loadXDPProgram(insns); err != nil {
+		return nil, err
+	}
+	if ifindex > 0 {
+		link := bpfLinkCreateAttr{ProgFD: uint32(c.prog), TargetIfindex: uint32(ifindex), AttachType: unix.BPF_XDP}
+		if c.link, err = bpfSyscall(unix.BPF_LINK_CREATE, unsafe.Pointer(&link), unsafe.Sizeof(link)); err != nil {
+			return nil, fmt.Errorf("failed to attach the XDP program: %w (requires Linux 5.9+, CAP_NET_ADMIN and "+
+				"no other XDP program on the interface)", err)
+		}
+	}
+	return c, nil
+}
+
+// poll waits up to timeout for captured frames and passes them to handle.
+// It returns the number of frames the kernel dropped because a ring was full.
+func (c *xdpCapture) poll(timeout time.Duration, handle func(frame []byte)) (uint64, error) {
+	events := make([]unix.EpollEvent, len(c.rings))
+	n, err := unix.EpollWait(c.epoll, events, int(timeout/time.Millisecond))
+	if err != nil && !errors.Is(err, unix.EINTR) {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, nil
+	}
+	var lost uint64
+	for _, ring := range c.rings {
+		lost += ring.drain(c.buf, func(sample []byte) {
+			if len(sample) < xdpMetaSize {
+				return
+			}
+			// Il campione è allineato a 8 byte: la lunghezza vera è nei metadati
+			length := int(binary.LittleEndian.Uint64(sample))
+			if length > len(sample)-xdpMetaSize {
+				return
+			}
+			handle(sample[xdpMetaSize : xdpMetaSize+length])
+		})
+	}
+	return lost, nil
+}
+
+// detach removes the XDP program from the interface
+func (c *xdpCapture) detach() {
+	if c.link >= 0 {
+		unix.Close(c.link)
+		c.link = -1
+	}
+}
+
+// close detaches the program and releases the rings. The poll loop must have returned.
+func (c *xdpCapture) close() {
+	c.detach()
+	for _, ring := range c.rings {
+		ring.close()
+	}
+	c.rings = nil
+	for _, fd := range []*int{&c.prog, &c.perfMap, &c.epoll} {
+		if *fd >= 0 {
+			unix.Close(*fd)
+			*fd = -1
+		}
+	}
+}
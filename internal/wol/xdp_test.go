@@ -0,0 +1,208 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// bpfTestRunAttr is the BPF_PROG_TEST_RUN attribute, up to duration
+type bpfTestRunAttr struct {
+	ProgFD      uint32
+	Retval      uint32
+	DataSizeIn  uint32
+	DataSizeOut uint32
+	DataIn      uint64
+	DataOut     uint64
+	Repeat      uint32
+	Duration    uint32
+}
+
+func TestParseCPUCount(t *testing.T) {
+	for list, want := range map[string]int{"0": 1, "0-7": 8, "0-3,8-11": 12, "0,2": 3} {
+		if got, err := parseCPUCount(list); err != nil || got != want {
+			t.Errorf("Expected %d CPUs for %q, got %d (%v)", want, list, got, err)
+		}
+	}
+	if _, err := parseCPUCount("x"); err == nil {
+		t.Error("Expected an error for an invalid list")
+	}
+}
+
+func TestXDPProgram_Unsupported(t *testing.T) {
+	// ld M[0]: la memoria temporanea non è usata dai filtri di cattura
+	if _, err := xdpProgram([]unix.SockFilter{{Code: 0x60}, {Code: 0x6, K: 1}}, 0); err == nil {
+		t.Error("Expected an error for an unsupported instruction")
+	}
+}
+
+// TestXDPCapture runs the translated capture filter in the kernel on the
+// frames of the classic BPF tests, and expects the same frames to be
+// captured. Requires CAP_BPF and CAP_PERFMON.
+func TestXDPCapture(t *testing.T) {
+	spec := captureSpec{
+		etherTypes:            []uint16{etherTypeWoL, etherTypeARP},
+		udpPorts:              []uint16{9, 7},
+		neighborSolicitations: true,
+		icmpEcho:              true,
+		tcpPorts:              []uint16{22},
+		vlanTags:              true,
+	}
+	filter := spec.filter()
+	capture, err := openXDPCapture(0, filter)
+	if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) {
+		t.Skipf("Cannot load eBPF programs: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer capture.close()
+
+	mac := []byte{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	magic := buildMagicPacket(mac, nil)
+	l2 := func(etherType uint16) []byte {
+		frame := append(bytes.Repeat([]byte{0xff}, 6), 0x02, 0, 0, 0, 0, 1)
+		return append(binary.BigEndian.AppendUint16(frame, etherType), magic...)
+	}
+	withIP := func(protocol byte, l4 []byte) []byte {
+		frame := udpFrame(mac, 9, nil)[:14+20]
+		frame[14+9] = protocol
+		return append(frame, l4...)
+	}
+	syn := func(port uint16, flags byte) []byte {
+		tcp := binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, 40000), port)
+		tcp = append(tcp, make([]byte, 9)...)
+		return append(tcp, flags, 0, 0, 0, 0, 0, 0)
+	}
+	fragment := udpFrame(mac, 9, magic)
+	fragment[14+7] = 0x10
+	// IPv4 con opzioni: l'header UDP è dopo 24 byte
+	options := udpFrame(mac, 9, magic)
+	options = append(append(options[:14:14], 0x46), append(options[15:34:34], 1, 1, 1, 1)...)
+	options = append(options, udpFrame(mac, 9, magic)[34:]...)
+
+	frames := map[string][]byte{
+		"WoL frame":                l2(etherTypeWoL),
+		"ARP":                      l2(etherTypeARP),
+		"other EtherType":          l2(0x88b5),
+		"UDP to port 9":            udpFrame(mac, 9, magic),
+		"UDP to port 7":            udpFrame(mac, 7, magic),
+		"UDP to port 53":           udpFrame(mac, 53, magic),
+		"UDP with IP options":      options,
+		"later fragment":           fragment,
+		"truncated UDP":            udpFrame(mac, 9, magic)[:14+20+2],
+		"ICMP echo request":        withIP(unix.IPPROTO_ICMP, []byte{8, 0, 0, 0}),
+		"ICMP echo reply":          withIP(unix.IPPROTO_ICMP, []byte{0, 0, 0, 0}),
+		"TCP SYN to port 22":       withIP(unix.IPPROTO_TCP, syn(22, tcpFlagSYN)),
+		"TCP SYN-ACK from port 22": withIP(unix.IPPROTO_TCP, syn(22, tcpFlagSYN|tcpFlagACK)),
+		"TCP SYN to port 80":       withIP(unix.IPPROTO_TCP, syn(80, tcpFlagSYN)),
+		"neighbor solicitation":    neighborSolicitationFrame("fd00::5", "fd00::20", 255),
+		"tagged WoL frame":         tagged(l2(etherTypeWoL), [2]uint16{0x8100, 100}),
+		"QinQ UDP to port 9":       tagged(udpFrame(mac, 9, magic), [2]uint16{0x88a8, 10}, [2]uint16{0x8100, 100}),
+		"QinQ UDP to port 53":      tagged(udpFrame(mac, 53, magic), [2]uint16{0x88a8, 10}, [2]uint16{0x8100, 100}),
+	}
+
+	vm, err := bpf.NewVM(mustDisassemble(t, toRaw(filter)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, frame := range frames {
+		t.Run(name, func(t *testing.T) {
+			n, err := vm.Run(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			attr := bpfTestRunAttr{
+				ProgFD:     uint32(capture.prog),
+				DataSizeIn: uint32(len(frame)),
+				DataIn:     uint64(uintptr(unsafe.Pointer(&frame[0]))),
+				Repeat:     1,
+			}
+			_, err = bpfSyscall(unix.BPF_PROG_TEST_RUN, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+			runtime.KeepAlive(frame)
+			if err != nil {
+				t.Fatalf("Test run failed: %v", err)
+			}
+			if attr.Retval != xdpPass {
+				t.Errorf("Expected XDP_PASS for every frame, got %d", attr.Retval)
+			}
+
+			var captured [][]byte
+			if _, err := capture.poll(100*time.Millisecond, func(f []byte) {
+				captured = append(captured, bytes.Clone(f))
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if accepted := n > 0; accepted != (len(captured) == 1) {
+				t.Fatalf("Expected the classic filter's decision (%v), got %d frames", accepted, len(captured))
+			}
+			if len(captured) == 1 && !bytes.Equal(captured[0], frame) {
+				t.Errorf("Expected the whole frame, got %x", captured[0])
+			}
+		})
+	}
+}
+
+// TestRawListener_XDP attaches the XDP program to the loopback interface and
+// expects the magic packets sent to it. Requires CAP_BPF, CAP_PERFMON and
+// CAP_NET_ADMIN.
+func TestRawListener_XDP(t *testing.T) {
+	macs := make(chan string, 4)
+	listener := NewRawListenerWithOptions("lo", nil, logr.Discard(),
+		RawListenerOptions{AttachBPF: true, RecvTimeoutSec: 1, UDPPorts: []uint16{9}, Backend: CaptureBackendEBPF})
+	listener.SetDirectedHandler(nil, func(pkt DirectedPacket) {
+		macs <- pkt.TargetMAC
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := listener.Start(ctx); err != nil {
+		t.Skipf("Cannot open raw sockets: %v", err)
+	}
+	defer listener.Stop()
+	if listener.CaptureMode() != CaptureModeXDP {
+		t.Skipf("Cannot attach XDP programs, capturing in %s mode", listener.CaptureMode())
+	}
+
+	conn, err := net.Dial("udp4", "127.0.0.1:9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(buildMagicPacket([]byte{0x52, 0x54, 0x00, 0x00, 0x00, 0x07}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case mac := <-macs:
+		if mac != "52:54:00:00:00:07" {
+			t.Errorf("Unexpected MAC %s", mac)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the magic packet to be captured")
+	}
+}
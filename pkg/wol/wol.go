@@ -0,0 +1,217 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package wol lets other operators embed the wake-up logic of kubevirt-wol:
+// map MAC addresses to KubeVirt VMs from WolConfig specs and start the VMs,
+// with the same dedupe, SecureOn and impersonation rules as the operator.
+//
+// A Waker does not need the WolConfig CRD to be installed: the configs are
+// passed in by the embedder. The WOLService gRPC API can be registered on the
+// embedder's server so that kubevirt-wol agents and relays report to it.
+package wol
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+	"github.com/gpillon/kubevirt-wol/internal/wol"
+)
+
+// VMInfo is a VM resolved from a MAC address or a name
+type VMInfo = wol.VMInfo
+
+// Starter starts KubeVirt VMs. The default implementation calls the
+// virtualmachines/start subresource of KubeVirt; embedders can provide their own,
+// e.g. to check quotas or to start VMs through another API.
+type Starter = wol.Starter
+
+// SaturationThresholds are the limits above which the waker reports saturation
+type SaturationThresholds = wol.SaturationThresholds
+
+// Options configures a Waker
+type Options struct {
+	// Client reads the VMs (and the Secrets of SecureOn passwords). Required.
+	Client client.Client
+
+	// RestConfig lets the default Starter call the KubeVirt subresources
+	// (start, unpause, restart) and impersonate the startServiceAccount of
+	// the configs. Required unless Starter is set.
+	RestConfig *rest.Config
+
+	// Log defaults to a discarding logger
+	Log logr.Logger
+
+	// Starter overrides the default KubeVirt starter
+	Starter Starter
+
+	// SaturationThresholds overrides the default saturation thresholds
+	SaturationThresholds *SaturationThresholds
+
+	// WolPolicies applies the WolPolicies of the VM namespaces, read with
+	// Client. Rejected wakes have the POLICY_REJECTED status.
+	WolPolicies bool
+}
+
+// Result is the outcome of a wake
+type Result struct {
+	// Status is the outcome, e.g. VM_START_INITIATED, DUPLICATE or VM_NOT_FOUND
+	Status wolv1.ResponseStatus
+	// Message describes the outcome
+	Message string
+	// Namespace and Name of the VM, empty if no VM was found
+	Namespace string
+	Name      string
+	// State of the VM (e.g. Running, Starting, Stopped, Paused) and node of
+	// its instance, when the Starter can read them
+	State string
+	Node  string
+	// Dependencies are the outcomes of the VMs started after this one
+	// (wol.pillon.org/wake-with annotation), in order
+	Dependencies []Result
+}
+
+// Started returns true if the VM was started or was already running
+func (r Result) Started() bool {
+	return r.Status == wolv1.ResponseStatus_VM_START_INITIATED ||
+		r.Status == wolv1.ResponseStatus_VM_ALREADY_RUNNING
+}
+
+// Waker wakes the KubeVirt VMs selected by a set of WolConfig specs
+type Waker struct {
+	mapper     *wol.MACMapper
+	aggregator *wol.Aggregator
+	vmStarter  *wol.VMStarter // nil with Options.Starter
+}
+
+// New creates a Waker. Call SetConfigs before waking VMs.
+func New(opts Options) (*Waker, error) {
+	if opts.Client == nil {
+		return nil, errors.New("a client is required")
+	}
+	log := opts.Log
+	if log.GetSink() == nil {
+		log = logr.Discard()
+	}
+	starter := opts.Starter
+	var vmStarter *wol.VMStarter
+	if starter == nil {
+		if opts.RestConfig == nil {
+			return nil, errors.New("a REST config is required by the default starter")
+		}
+		vmStarter = wol.NewVMStarter(opts.Client, log.WithName("vm-starter"))
+		vmStarter.EnableImpersonation(opts.RestConfig, opts.Client.Scheme())
+		starter = vmStarter
+	}
+
+	mapper := wol.NewMACMapper(opts.Client, log.WithName("mac-mapper"))
+	aggregator := wol.NewAggregator(mapper, starter, log.WithName("aggregator"))
+	if opts.SaturationThresholds != nil {
+		aggregator.SetSaturationThresholds(*opts.SaturationThresholds)
+	}
+	if opts.WolPolicies {
+		aggregator.SetPolicyEvaluator(wol.NewPolicyEvaluator(opts.Client, log.WithName("policy")))
+	}
+	return &Waker{mapper: mapper, aggregator: aggregator, vmStarter: vmStarter}, nil
+}
+
+// SetConfigs replaces the WolConfig specs (they need not exist in the cluster)
+// and rebuilds the MAC to VM mapping
+func (w *Waker) SetConfigs(ctx context.Context, configs []wolv1beta1.WolConfig) error {
+	w.mapper.UpdateConfigs(configs)
+	return w.mapper.RefreshMapping(ctx)
+}
+
+// Refresh rebuilds the MAC to VM mapping from the VMs in the cluster
+func (w *Waker) Refresh(ctx context.Context) error {
+	return w.mapper.RefreshMapping(ctx)
+}
+
+// Lookup returns the VM mapped to a MAC address
+func (w *Waker) Lookup(mac string) (VMInfo, bool) {
+	return w.mapper.Lookup(mac)
+}
+
+// WakeMAC wakes the VM mapped to mac, as a magic packet received by source
+// would (password is the SecureOn password, if any)
+func (w *Waker) WakeMAC(ctx context.Context, mac, password, source string) (Result, error) {
+	resp, err := w.aggregator.ReportWOLEvent(ctx, &wolv1.WOLEvent{
+		MacAddress:       mac,
+		NodeName:         source,
+		SecureOnPassword: password,
+		Timestamp:        timestamppb.New(time.Now()),
+	})
+	return resultFrom(resp), err
+}
+
+// WakeVM wakes a VM selected by the configs, by name. VMs whose config
+// requires a SecureOn password can only be woken by WakeMAC.
+func (w *Waker) WakeVM(ctx context.Context, namespace, name, source, reason string) (Result, error) {
+	resp, err := w.aggregator.RequestWake(ctx, &wolv1.WakeRequest{
+		Namespace: namespace,
+		Name:      name,
+		Source:    source,
+		Reason:    reason,
+	})
+	return resultFrom(resp), err
+}
+
+// RegisterService registers the WOLService gRPC API on server, so that
+// kubevirt-wol agents, the activator and relays can report to this Waker
+func (w *Waker) RegisterService(server grpc.ServiceRegistrar) {
+	wolv1.RegisterWOLServiceServer(server, w.aggregator)
+}
+
+// Run runs the background maintenance (dedupe cache cleanup, saturation
+// metrics) until ctx is done, then drops the wakes of the default starter
+// queued until their VM settles
+func (w *Waker) Run(ctx context.Context) {
+	go w.aggregator.MonitorSaturation(ctx)
+	w.aggregator.StartCleanup(ctx)
+	if w.vmStarter != nil {
+		w.vmStarter.Run(ctx)
+	}
+}
+
+func resultFrom(resp *wolv1.WOLEventResponse) Result {
+	if resp == nil {
+		return Result{}
+	}
+	result := Result{Status: resp.Status, Message: resp.Message}
+	if resp.VmInfo != nil {
+		result.Namespace = resp.VmInfo.Namespace
+		result.Name = resp.VmInfo.Name
+		result.State = resp.VmInfo.CurrentState
+		result.Node = resp.VmInfo.NodeName
+	}
+	for _, dep := range resp.Dependencies {
+		result.Dependencies = append(result.Dependencies, Result{
+			Status:    dep.Status,
+			Message:   dep.Message,
+			Namespace: dep.Namespace,
+			Name:      dep.Name,
+		})
+	}
+	return result
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wol
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	wolv1beta1 "github.com/gpillon/kubevirt-wol/api/v1beta1"
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// recordingStarter records the started VMs instead of patching them
+type recordingStarter struct {
+	mu      sync.Mutex
+	started []string
+}
+
+func (s *recordingStarter) StartVMAs(_ context.Context, _, namespace, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = append(s.started, namespace+"/"+name)
+	return nil
+}
+
+func (s *recordingStarter) QueuedWakes() int { return 0 }
+
+func TestWaker_CustomStarter(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = kubevirtv1.AddToScheme(scheme)
+	_ = wolv1beta1.AddToScheme(scheme)
+
+	starter := &recordingStarter{}
+	waker, err := New(Options{
+		Client:  fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Starter: starter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := wolv1beta1.WolConfig{
+		Spec: wolv1beta1.WolConfigSpec{
+			DiscoveryMode: wolv1beta1.DiscoveryModeExplicit,
+			ExplicitMappings: []wolv1beta1.MACVMMapping{
+				{MACAddress: "52:54:00:12:34:56", VMName: "vm1", Namespace: "team-a"},
+			},
+		},
+	}
+	config.Name = "embedded"
+	if err := waker.SetConfigs(context.Background(), []wolv1beta1.WolConfig{config}); err != nil {
+		t.Fatalf("Failed to set configs: %v", err)
+	}
+
+	if info, found := waker.Lookup("52:54:00:12:34:56"); !found || info.Name != "vm1" {
+		t.Fatalf("Expected the MAC to map to vm1, got %+v (found=%v)", info, found)
+	}
+
+	result, err := waker.WakeMAC(context.Background(), "52:54:00:12:34:56", "", "embedder")
+	if err != nil || !result.Started() || result.Name != "vm1" || result.Namespace != "team-a" {
+		t.Fatalf("Expected vm1 to be started, got %+v, %v", result, err)
+	}
+	if len(starter.started) != 1 || starter.started[0] != "team-a/vm1" {
+		t.Errorf("Expected the custom starter to start team-a/vm1, got %v", starter.started)
+	}
+
+	result, _ = waker.WakeVM(context.Background(), "team-a", "other", "embedder", "test")
+	if result.Status != wolv1.ResponseStatus_VM_NOT_FOUND || result.Started() {
+		t.Errorf("Expected an unmanaged VM not to be found, got %+v", result)
+	}
+}
+
+func TestNew_RequiresClient(t *testing.T) {
+	if _, err := New(Options{}); err == nil {
+		t.Error("Expected an error without a client")
+	}
+	if _, err := New(Options{Client: fake.NewClientBuilder().Build()}); err == nil {
+		t.Error("Expected an error without a REST config for the default starter")
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package wolclient is a small client of the WOLService gRPC API of the
+// kubevirt-wol manager, for custom senders and relays that report wake events.
+// The API is defined in api/wol/v1/wol.proto (Go package api/wol/v1).
+package wolclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+const (
+	// DefaultMaxAttempts is the default number of attempts of an RPC
+	DefaultMaxAttempts = 3
+	// DefaultTimeout is the default timeout of a single attempt
+	DefaultTimeout = 5 * time.Second
+
+	initialBackoff = 200 * time.Millisecond
+	maxBackoff     = 2 * time.Second
+)
+
+// Options configures a Client
+type Options struct {
+	// TLS enables TLS with the given configuration. Nil means plaintext,
+	// only suitable inside the cluster network.
+	TLS *tls.Config
+
+	// Token is sent as a bearer token on every RPC. Requires TLS.
+	Token string
+
+	// Source identifies the sender in the events (their node name), e.g. "relay-branch-1"
+	Source string
+
+	// MaxAttempts is the number of attempts of an RPC failing with Unavailable
+	// or DeadlineExceeded (default 3). The manager dedupes events, so retries are safe.
+	MaxAttempts int
+
+	// Timeout is the timeout of a single attempt (default 5s)
+	Timeout time.Duration
+}
+
+// Client reports wake events to the kubevirt-wol manager
+type Client struct {
+	conn    *grpc.ClientConn
+	service wolv1.WOLServiceClient
+	opts    Options
+}
+
+// New creates a client of the manager at address (host:port). The connection is lazy.
+func New(address string, opts Options) (*Client, error) {
+	if opts.Token != "" && opts.TLS == nil {
+		return nil, errors.New("a token requires TLS")
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	transport := insecure.NewCredentials()
+	if opts.TLS != nil {
+		transport = credentials.NewTLS(opts.TLS)
+	}
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(transport)}
+	if opts.Token != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(bearerToken(opts.Token)))
+	}
+
+	conn, err := grpc.NewClient(address, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, service: wolv1.NewWOLServiceClient(conn), opts: opts}, nil
+}
+
+// ReportMagicPacket reports a magic packet for mac (password is the SecureOn
+// password, if any), received at receivedAt on destination port port
+func (c *Client) ReportMagicPacket(ctx context.Context, mac, password string, port uint32, receivedAt time.Time) (*wolv1.WOLEventResponse, error) {
+	return c.ReportEvent(ctx, &wolv1.WOLEvent{
+		MacAddress:       mac,
+		Timestamp:        timestamppb.New(receivedAt),
+		DestinationPort:  port,
+		SecureOnPassword: password,
+	})
+}
+
+// ReportEvent reports a WOL event. NodeName defaults to the Source option.
+func (c *Client) ReportEvent(ctx context.Context, event *wolv1.WOLEvent) (*wolv1.WOLEventResponse, error) {
+	if event.NodeName == "" {
+		event.NodeName = c.opts.Source
+	}
+	var resp *wolv1.WOLEventResponse
+	err := c.retry(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = c.service.ReportWOLEvent(ctx, event)
+		return err
+	})
+	return resp, err
+}
+
+// RequestWake asks the manager to start a managed VM by name
+func (c *Client) RequestWake(ctx context.Context, namespace, name, reason string) (*wolv1.WOLEventResponse, error) {
+	req := &wolv1.WakeRequest{Namespace: namespace, Name: name, Source: c.opts.Source, Reason: reason}
+	var resp *wolv1.WOLEventResponse
+	err := c.retry(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = c.service.RequestWake(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// SendWOL asks the manager to send a magic packet to a machine outside the
+// cluster, from the manager or from the agent of a node. Source defaults to
+// the Source option.
+func (c *Client) SendWOL(ctx context.Context, req *wolv1.SendWOLRequest) (*wolv1.SendWOLResponse, error) {
+	if req.Source == "" {
+		req.Source = c.opts.Source
+	}
+	var resp *wolv1.SendWOLResponse
+	err := c.retry(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = c.service.SendWOL(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// Register reports the listeners of a relay, which then appears in the
+// listeners status of its WolConfig. Registrations expire after a few
+// minutes: call it periodically (e.g. every minute).
+func (c *Client) Register(ctx context.Context, bindings []*wolv1.ListenerBinding) error {
+	return c.retry(ctx, func(ctx context.Context) error {
+		_, err := c.service.ReportListeners(ctx, &wolv1.ListenerReport{NodeName: c.opts.Source, Bindings: bindings})
+		return err
+	})
+}
+
+// Service returns the raw WOLService client, for the RPCs without a helper
+func (c *Client) Service() wolv1.WOLServiceClient {
+	return c.service
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// retry runs fn up to MaxAttempts times with exponential backoff, as long as
+// it fails because the manager could not be reached
+func (c *Client) retry(ctx context.Context, fn func(ctx context.Context) error) error {
+	backoff := initialBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+		err = fn(attemptCtx)
+		cancel()
+		if err == nil || !retryable(err) || attempt >= c.opts.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// bearerToken sends the token in the authorization metadata
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(_ context.Context, _ ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t bearerToken) RequireTransportSecurity() bool {
+	return true
+}
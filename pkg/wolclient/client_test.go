@@ -0,0 +1,134 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wolclient
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	wolv1 "github.com/gpillon/kubevirt-wol/api/wol/v1"
+)
+
+// flakyService fails the first failures calls with the given code
+type flakyService struct {
+	wolv1.UnimplementedWOLServiceServer
+	failures int32
+	code     codes.Code
+	calls    atomic.Int32
+	lastNode atomic.Value
+}
+
+func (s *flakyService) ReportWOLEvent(_ context.Context, event *wolv1.WOLEvent) (*wolv1.WOLEventResponse, error) {
+	s.lastNode.Store(event.NodeName)
+	if s.calls.Add(1) <= s.failures {
+		return nil, status.Error(s.code, "not yet")
+	}
+	return &wolv1.WOLEventResponse{Status: wolv1.ResponseStatus_VM_START_INITIATED}, nil
+}
+
+func (s *flakyService) SendWOL(_ context.Context, req *wolv1.SendWOLRequest) (*wolv1.SendWOLResponse, error) {
+	s.lastNode.Store(req.Source)
+	if s.calls.Add(1) <= s.failures {
+		return nil, status.Error(s.code, "not yet")
+	}
+	return &wolv1.SendWOLResponse{Sender: "manager", Destination: req.Address + ":9"}, nil
+}
+
+func startService(t *testing.T, service wolv1.WOLServiceServer) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	wolv1.RegisterWOLServiceServer(server, service)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+func TestClient_RetriesUnavailable(t *testing.T) {
+	service := &flakyService{failures: 2, code: codes.Unavailable}
+	c, err := New(startService(t, service), Options{Source: "relay-1", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+
+	resp, err := c.ReportMagicPacket(context.Background(), "52:54:00:12:34:56", "", 9, time.Now())
+	if err != nil || resp.Status != wolv1.ResponseStatus_VM_START_INITIATED {
+		t.Fatalf("Expected the event to be accepted after retries, got %v, %v", resp, err)
+	}
+	if calls := service.calls.Load(); calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls)
+	}
+	if node := service.lastNode.Load(); node != "relay-1" {
+		t.Errorf("Expected the source as node name, got %v", node)
+	}
+}
+
+func TestClient_DoesNotRetryOtherErrors(t *testing.T) {
+	service := &flakyService{failures: 1, code: codes.PermissionDenied}
+	c, err := New(startService(t, service), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+
+	if _, err := c.ReportMagicPacket(context.Background(), "52:54:00:12:34:56", "", 9, time.Now()); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Expected PermissionDenied, got %v", err)
+	}
+	if calls := service.calls.Load(); calls != 1 {
+		t.Errorf("Expected a single attempt, got %d", calls)
+	}
+}
+
+func TestClient_SendWOL(t *testing.T) {
+	service := &flakyService{failures: 1, code: codes.Unavailable}
+	c, err := New(startService(t, service), Options{Source: "relay-1", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+
+	resp, err := c.SendWOL(context.Background(), &wolv1.SendWOLRequest{MacAddress: "aa:bb:cc:dd:ee:ff", Address: "192.168.1.255"})
+	if err != nil || resp.Destination != "192.168.1.255:9" {
+		t.Fatalf("Expected the packet to be sent after a retry, got %v, %v", resp, err)
+	}
+	if source := service.lastNode.Load(); source != "relay-1" {
+		t.Errorf("Expected the source of the client, got %v", source)
+	}
+}
+
+func TestNew_TokenRequiresTLS(t *testing.T) {
+	if _, err := New("localhost:9090", Options{Token: "secret"}); err == nil {
+		t.Error("Expected a token without TLS to be rejected")
+	}
+	c, err := New("localhost:9090", Options{Token: "secret", TLS: &tls.Config{MinVersion: tls.VersionTLS12}})
+	if err != nil {
+		t.Fatalf("Expected a token with TLS to be accepted, got %v", err)
+	}
+	_ = c.Close()
+}